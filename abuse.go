@@ -0,0 +1,170 @@
+package titan
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+var abuseLog = log.Component("abuse")
+
+// Kinds of the offenses of a remote IP, each counting as one offense.
+const (
+	offenseAuth      = "auth"      // failed login or token refresh
+	offenseMalformed = "malformed" // connection closed for sending a malformed frame or message
+	offenseRateLimit = "rateLimit" // request rejected for exceeding the rate limits
+)
+
+// abuse tracks the offenses of the remote IPs, and bans the IPs with threshold offenses within the window for the cooldown period.
+// Connections from the banned IPs are refused by the listeners, while the existing connections are left open. Threshold less than
+// or equal to zero disables the bans.
+type abuse struct {
+	mutex       sync.Mutex
+	threshold   int
+	window      time.Duration
+	cooldown    time.Duration
+	offenses    map[string][]time.Time // remote IP -> times of the offenses within the window
+	bans        map[string]models.IPBan
+	au          *audit
+	lastCleanup time.Time
+}
+
+func newAbuse(threshold int, window, cooldown time.Duration, au *audit) *abuse {
+	return &abuse{
+		threshold:   threshold,
+		window:      window,
+		cooldown:    cooldown,
+		offenses:    make(map[string][]time.Time),
+		bans:        make(map[string]models.IPBan),
+		au:          au,
+		lastCleanup: time.Now(),
+	}
+}
+
+// setLimits sets the number of offenses within the window to ban an IP after, and the duration of the bans.
+// Offenses recorded so far are forgotten, while the current bans are kept.
+func (a *abuse) setLimits(threshold int, window, cooldown time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.threshold, a.window, a.cooldown = threshold, window, cooldown
+	a.offenses = make(map[string][]time.Time)
+}
+
+// report records an offense of the remote IP of the given connection.
+func (a *abuse) report(c *neptulon.Conn, kind string) {
+	if a == nil || c == nil {
+		return
+	}
+	if ip := connIP(c); ip != "" {
+		a.reportIP(ip, kind, time.Now())
+	}
+}
+
+// reportIP records an offense of the given IP, banning the IP if it reaches the threshold within the window.
+func (a *abuse) reportIP(ip, kind string, now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.threshold <= 0 {
+		return
+	}
+	if _, ok := a.bans[ip]; ok {
+		return
+	}
+
+	// drop the offenses of the IPs which did not offend again within the window
+	if now.Sub(a.lastCleanup) > a.window {
+		for k, ts := range a.offenses {
+			if now.Sub(ts[len(ts)-1]) > a.window {
+				delete(a.offenses, k)
+			}
+		}
+		a.lastCleanup = now
+	}
+
+	ts := a.offenses[ip]
+	for len(ts) != 0 && now.Sub(ts[0]) > a.window {
+		ts = ts[1:]
+	}
+	ts = append(ts, now)
+	if len(ts) < a.threshold {
+		a.offenses[ip] = ts
+		return
+	}
+
+	delete(a.offenses, ip)
+	b := models.IPBan{IP: ip, Reason: kind, Since: now, Until: now.Add(a.cooldown)}
+	a.bans[ip] = b
+	abuseLog.Warnf("%v is banned until %v after %v offenses, last one: %v", ip, b.Until.Format(time.RFC3339), len(ts), kind)
+	a.au.record(nil, models.AuditEntry{Action: models.AuditBan, RemoteAddr: ip, Success: true, Details: map[string]string{"reason": kind}})
+}
+
+// allowed reports whether the connections from the given IP are allowed, which is not the case while the IP is banned.
+func (a *abuse) allowed(ip string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	b, ok := a.bans[ip]
+	if !ok {
+		return true
+	}
+	if time.Now().After(b.Until) {
+		delete(a.bans, ip)
+		return true
+	}
+	return false
+}
+
+// list returns the current bans, the ones ending first being first.
+func (a *abuse) list() []models.IPBan {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+	bans := []models.IPBan{}
+	for ip, b := range a.bans {
+		if now.After(b.Until) {
+			delete(a.bans, ip)
+			continue
+		}
+		bans = append(bans, b)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+	return bans
+}
+
+// clear lifts the ban of the given IP, or all the bans if the IP is empty. Returns the number of bans lifted.
+func (a *abuse) clear(ip string) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if ip == "" {
+		n := len(a.bans)
+		a.bans = make(map[string]models.IPBan)
+		return n
+	}
+	if _, ok := a.bans[ip]; !ok {
+		return 0
+	}
+	delete(a.bans, ip)
+	delete(a.offenses, ip)
+	return 1
+}
+
+// connIP returns the remote IP of the given connection, or an empty string if it is not known.
+func connIP(c *neptulon.Conn) string {
+	addr := c.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
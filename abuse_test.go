@@ -0,0 +1,50 @@
+package titan
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAbuseBan(t *testing.T) {
+	a := newAbuse(3, time.Minute, time.Hour, &audit{})
+	now := time.Now()
+
+	// offenses outside of the window are forgotten
+	a.reportIP("10.0.0.1", offenseAuth, now.Add(-2*time.Minute))
+	a.reportIP("10.0.0.1", offenseAuth, now)
+	a.reportIP("10.0.0.1", offenseRateLimit, now)
+	if !a.allowed("10.0.0.1") {
+		t.Fatal("expected ip with 2 offenses within the window to be allowed")
+	}
+
+	a.reportIP("10.0.0.1", offenseMalformed, now)
+	if a.allowed("10.0.0.1") {
+		t.Fatal("expected ip with 3 offenses within the window to be banned")
+	}
+	if !a.allowed("10.0.0.2") {
+		t.Fatal("expected other ips to be allowed")
+	}
+	if bans := a.list(); len(bans) != 1 || bans[0].IP != "10.0.0.1" || bans[0].Reason != offenseMalformed {
+		t.Fatalf("unexpected bans: %+v", bans)
+	}
+
+	if n := a.clear("10.0.0.1"); n != 1 || !a.allowed("10.0.0.1") {
+		t.Fatalf("expected ban to be lifted, got: %v", n)
+	}
+	if bans := a.list(); len(bans) != 0 {
+		t.Fatalf("expected no bans, got: %+v", bans)
+	}
+}
+
+func TestAbuseBanExpiry(t *testing.T) {
+	a := newAbuse(1, time.Minute, time.Millisecond, &audit{})
+	a.reportIP("10.0.0.1", offenseAuth, time.Now())
+	if a.allowed("10.0.0.1") {
+		t.Fatal("expected ip to be banned")
+	}
+
+	time.Sleep(time.Millisecond * 5)
+	if !a.allowed("10.0.0.1") {
+		t.Fatal("expected ban to expire")
+	}
+}
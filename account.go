@@ -0,0 +1,152 @@
+package titan
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+// accountReapInterval is the interval of checking for the accounts whose deletion grace period is over.
+const accountReapInterval = time.Hour
+
+// ReapAccounts deletes the data of the accounts whose deletion grace period is over, and returns the number of accounts deleted.
+// It is run periodically while the server is listening, but can be called any time (i.e. right after shortening the grace period).
+// Accounts which fail to be deleted are left to be retried with the next run.
+func (s *Server) ReapAccounts() (deleted int, err error) {
+	ids, err := s.db.GetDueDeletions(time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("account: failed to get due deletions: %v", err)
+	}
+
+	for _, id := range ids {
+		// deletion is canceled if the user signed in again in the meantime
+		u, ok := s.db.GetByID(id)
+		if !ok || u.DeleteAt.IsZero() || u.DeleteAt.After(time.Now()) {
+			continue
+		}
+		if err := deleteAccount(s.db, s.blobs, s.queue, id); err != nil {
+			authLog.Errorf("failed to delete account of user %v: %v", id, err)
+			continue
+		}
+		s.events.publish(models.EventAccountDeleted, id, models.AccountDeletion{DeleteAt: u.DeleteAt})
+		s.audit.record(nil, models.AuditEntry{Action: models.AuditAccountDeleted, UserID: id, Success: true})
+		authLog.Infof("account of user %v is deleted", id)
+		deleted++
+	}
+	return deleted, nil
+}
+
+// ReapMessages deletes the messages older than the message retention period from the message history,
+// and returns the number of messages deleted. It is run periodically along with ReapAccounts if a retention period is configured.
+func (s *Server) ReapMessages() (deleted int, err error) {
+	if Conf.App.MsgRetention <= 0 {
+		return 0, nil
+	}
+
+	deleted, err = s.db.DeleteMessagesBefore(time.Now().Add(-Conf.App.MsgRetention))
+	if err != nil {
+		return deleted, fmt.Errorf("account: failed to delete expired messages: %v", err)
+	}
+	if deleted > 0 {
+		authLog.Infof("%v messages older than %v are deleted", deleted, Conf.App.MsgRetention)
+	}
+	return deleted, nil
+}
+
+// runReaper reaps the accounts and the expired messages periodically until done is closed.
+func (s *Server) runReaper(done chan struct{}) {
+	tick := time.NewTicker(accountReapInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+			if _, err := s.ReapAccounts(); err != nil {
+				authLog.Errorf("%v", err)
+			}
+			if _, err := s.ReapMessages(); err != nil {
+				authLog.Errorf("%v", err)
+			}
+		}
+	}
+}
+
+// deleteAccount deletes all the data of a user: the attachments along with their content, the messages sent by the user,
+// the contacts both ways, the blocked users and the muted conversations, the topic subscriptions, the requests waiting
+// in the queue, and finally the user along with the refresh tokens and the encryption keys.
+func deleteAccount(db data.DB, bs data.BlobStore, q data.Queue, userID string) error {
+	as, err := db.GetAttachments(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get attachments: %v", err)
+	}
+	for _, a := range as {
+		attachmentMutex.Lock()
+		err := deleteAttachment(db, bs, &a)
+		attachmentMutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := db.DeleteUserMessages(userID); err != nil {
+		return fmt.Errorf("failed to delete messages: %v", err)
+	}
+
+	cs, err := db.GetContacts(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get contacts: %v", err)
+	}
+	for _, c := range cs {
+		if err := db.RemoveContact(userID, c); err != nil {
+			return fmt.Errorf("failed to remove contact: %v", err)
+		}
+	}
+	of, err := db.GetContactOf(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get contacts of others: %v", err)
+	}
+	for _, o := range of {
+		if err := db.RemoveContact(o, userID); err != nil {
+			return fmt.Errorf("failed to remove contact of others: %v", err)
+		}
+	}
+
+	blocked, err := db.GetBlocked(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get blocked users: %v", err)
+	}
+	for _, b := range blocked {
+		if err := db.Unblock(userID, b); err != nil {
+			return fmt.Errorf("failed to unblock user: %v", err)
+		}
+	}
+	ms, err := db.GetMuted(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get muted conversations: %v", err)
+	}
+	for _, m := range ms {
+		if err := db.Unmute(userID, m); err != nil {
+			return fmt.Errorf("failed to unmute conversation: %v", err)
+		}
+	}
+
+	ts, err := db.GetSubscriptions(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get subscriptions: %v", err)
+	}
+	for _, t := range ts {
+		if err := db.Unsubscribe(t, userID); err != nil {
+			return fmt.Errorf("failed to unsubscribe: %v", err)
+		}
+	}
+
+	q.Purge(userID, models.DeadLetterPurged)
+	if err := db.DeleteUser(userID); err != nil {
+		return fmt.Errorf("failed to delete user: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+// Package acme provides a minimal ACME (RFC 8555) client for obtaining certificates from Let's Encrypt
+// (or any other ACME certificate authority) with HTTP-01 challenges, and a manager for caching and renewing them.
+// https://tools.ietf.org/html/rfc8555
+package acme
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LetsEncryptURL is the directory URL of Let's Encrypt production environment.
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// Client is an ACME client for obtaining certificates with HTTP-01 challenges.
+// Challenge responses must be served by HTTPHandler on port 80 of all the domains that certificates are requested for.
+type Client struct {
+	DirectoryURL string            // ACME directory URL. Defaults to LetsEncryptURL.
+	Key          *ecdsa.PrivateKey // Account key (P-256).
+	Email        string            // Optional contact e-mail for the account.
+	HTTPClient   *http.Client      // HTTP client to make the API calls with. Defaults to http.DefaultClient.
+	PollInterval time.Duration     // Interval to poll the pending authorizations and orders with. Defaults to 1 second.
+
+	mutex      sync.Mutex
+	dir        *directory
+	accountURL string
+	nonces     []string
+	challenges map[string]string // token -> key authorization
+}
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+type authorization struct {
+	Status     string      `json:"status"`
+	Identifier identifier  `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+// Error is an error (problem document) returned by the ACME server.
+type Error struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("acme: %v: %v", e.Type, e.Detail)
+}
+
+// HTTPHandler returns the handler serving HTTP-01 challenge responses at /.well-known/acme-challenge/.
+// All the other requests are passed on to the fallback handler, or responded with 404 if fallback is nil.
+func (c *Client) HTTPHandler(fallback http.Handler) http.Handler {
+	const prefix = "/.well-known/acme-challenge/"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Path) <= len(prefix) || r.URL.Path[:len(prefix)] != prefix {
+			if fallback == nil {
+				http.NotFound(w, r)
+				return
+			}
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		c.mutex.Lock()
+		keyAuth, ok := c.challenges[r.URL.Path[len(prefix):]]
+		c.mutex.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuth))
+	})
+}
+
+// ObtainCertificate registers the account (if not already registered), completes the HTTP-01 challenges for the given domains,
+// and returns the PEM encoded certificate chain issued for the given CSR (in DER format).
+func (c *Client) ObtainCertificate(domains []string, csr []byte) (certChain []byte, err error) {
+	if err := c.register(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]identifier, len(domains))
+	for i, d := range domains {
+		ids[i] = identifier{Type: "dns", Value: d}
+	}
+
+	var o order
+	res, err := c.post(c.dir.NewOrder, map[string]interface{}{"identifiers": ids}, &o)
+	if err != nil {
+		return nil, fmt.Errorf("acme: failed to create order: %v", err)
+	}
+	orderURL := res.Header.Get("Location")
+
+	for _, a := range o.Authorizations {
+		if err := c.authorize(a); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := c.post(o.Finalize, map[string]string{"csr": b64(csr)}, &o); err != nil {
+		return nil, fmt.Errorf("acme: failed to finalize order: %v", err)
+	}
+	for o.Status != "valid" {
+		if o.Status == "invalid" {
+			return nil, errors.New("acme: order is invalid")
+		}
+		time.Sleep(c.pollInterval())
+		if _, err := c.post(orderURL, nil, &o); err != nil {
+			return nil, fmt.Errorf("acme: failed to get order: %v", err)
+		}
+	}
+
+	res, err = c.post(o.Certificate, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("acme: failed to download certificate: %v", err)
+	}
+	defer res.Body.Close()
+	if certChain, err = ioutil.ReadAll(res.Body); err != nil {
+		return nil, fmt.Errorf("acme: failed to download certificate: %v", err)
+	}
+	if b, _ := pem.Decode(certChain); b == nil || b.Type != "CERTIFICATE" {
+		return nil, errors.New("acme: downloaded certificate is not PEM encoded")
+	}
+	return certChain, nil
+}
+
+// authorize completes the HTTP-01 challenge of the given authorization, if it is not already valid.
+func (c *Client) authorize(url string) error {
+	var a authorization
+	if _, err := c.post(url, nil, &a); err != nil {
+		return fmt.Errorf("acme: failed to get authorization: %v", err)
+	}
+	if a.Status == "valid" {
+		return nil
+	}
+
+	var ch *challenge
+	for i := range a.Challenges {
+		if a.Challenges[i].Type == "http-01" {
+			ch = &a.Challenges[i]
+		}
+	}
+	if ch == nil {
+		return fmt.Errorf("acme: no http-01 challenge offered for %v", a.Identifier.Value)
+	}
+
+	c.mutex.Lock()
+	c.challenges[ch.Token] = ch.Token + "." + thumbprint(&c.Key.PublicKey)
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		delete(c.challenges, ch.Token)
+		c.mutex.Unlock()
+	}()
+
+	res, err := c.post(ch.URL, struct{}{}, nil)
+	if err != nil {
+		return fmt.Errorf("acme: failed to accept challenge for %v: %v", a.Identifier.Value, err)
+	}
+	res.Body.Close()
+
+	for a.Status != "valid" {
+		if a.Status == "invalid" {
+			return fmt.Errorf("acme: challenge failed for %v", a.Identifier.Value)
+		}
+		time.Sleep(c.pollInterval())
+		if _, err := c.post(url, nil, &a); err != nil {
+			return fmt.Errorf("acme: failed to get authorization: %v", err)
+		}
+	}
+	return nil
+}
+
+// register retrieves the directory and creates the account, or retrieves the existing one for the account key.
+func (c *Client) register() error {
+	c.mutex.Lock()
+	if c.challenges == nil {
+		c.challenges = make(map[string]string)
+	}
+	registered := c.accountURL != ""
+	c.mutex.Unlock()
+	if registered {
+		return nil
+	}
+
+	durl := c.DirectoryURL
+	if durl == "" {
+		durl = LetsEncryptURL
+	}
+	res, err := c.httpClient().Get(durl)
+	if err != nil {
+		return fmt.Errorf("acme: failed to get directory: %v", err)
+	}
+	defer res.Body.Close()
+	var dir directory
+	if err := json.NewDecoder(res.Body).Decode(&dir); err != nil {
+		return fmt.Errorf("acme: failed to deserialize directory: %v", err)
+	}
+	c.dir = &dir
+
+	acc := map[string]interface{}{"termsOfServiceAgreed": true}
+	if c.Email != "" {
+		acc["contact"] = []string{"mailto:" + c.Email}
+	}
+	if res, err = c.post(dir.NewAccount, acc, nil); err != nil {
+		return fmt.Errorf("acme: failed to register account: %v", err)
+	}
+	res.Body.Close()
+
+	c.mutex.Lock()
+	c.accountURL = res.Header.Get("Location")
+	c.mutex.Unlock()
+	return nil
+}
+
+// post sends a JWS signed POST request with the given payload, or a POST-as-GET request if payload is nil,
+// and deserializes the response into v, if given. Requests rejected due to a bad nonce are retried once.
+func (c *Client) post(url string, payload interface{}, v interface{}) (*http.Response, error) {
+	var res *http.Response
+	for retry := 0; ; retry++ {
+		body, err := c.sign(url, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err = c.httpClient().Post(url, "application/jose+json", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if n := res.Header.Get("Replay-Nonce"); n != "" {
+			c.mutex.Lock()
+			c.nonces = append(c.nonces, n)
+			c.mutex.Unlock()
+		}
+
+		if res.StatusCode < 400 {
+			break
+		}
+
+		var e Error
+		json.NewDecoder(res.Body).Decode(&e)
+		res.Body.Close()
+		if e.Type == "urn:ietf:params:acme:error:badNonce" && retry == 0 {
+			continue
+		}
+		if e.Type == "" {
+			e.Detail = res.Status
+		}
+		return nil, &e
+	}
+
+	if v != nil {
+		defer res.Body.Close()
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			return nil, fmt.Errorf("failed to deserialize response: %v", err)
+		}
+	}
+	return res, nil
+}
+
+// sign creates a JWS in flattened JSON serialization, with the account URL as the key ID if the account is registered,
+// or with the public key otherwise.
+func (c *Client) sign(url string, payload interface{}) ([]byte, error) {
+	nonce, err := c.nonce()
+	if err != nil {
+		return nil, err
+	}
+
+	protected := map[string]interface{}{"alg": "ES256", "nonce": nonce, "url": url}
+	c.mutex.Lock()
+	if c.accountURL != "" {
+		protected["kid"] = c.accountURL
+	} else {
+		protected["jwk"] = jwk(&c.Key.PublicKey)
+	}
+	c.mutex.Unlock()
+
+	ph, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	var pl string
+	if payload != nil {
+		p, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		pl = b64(p)
+	}
+
+	hash := sha256.Sum256([]byte(b64(ph) + "." + pl))
+	r, s, err := ecdsa.Sign(rand.Reader, c.Key, hash[:])
+	if err != nil {
+		return nil, fmt.Errorf("acme: failed to sign request: %v", err)
+	}
+	sig := append(pad32(r), pad32(s)...)
+
+	return json.Marshal(map[string]string{"protected": b64(ph), "payload": pl, "signature": b64(sig)})
+}
+
+// nonce returns a nonce from a previous response, or retrieves a new one.
+func (c *Client) nonce() (string, error) {
+	c.mutex.Lock()
+	if n := len(c.nonces); n != 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mutex.Unlock()
+		return nonce, nil
+	}
+	c.mutex.Unlock()
+
+	res, err := c.httpClient().Head(c.dir.NewNonce)
+	if err != nil {
+		return "", fmt.Errorf("acme: failed to get nonce: %v", err)
+	}
+	res.Body.Close()
+
+	nonce := res.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("acme: server did not return a nonce")
+	}
+	return nonce, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) pollInterval() time.Duration {
+	if c.PollInterval > 0 {
+		return c.PollInterval
+	}
+	return time.Second
+}
+
+// jwk returns the JSON Web Key representation of a P-256 public key, with the members in lexicographic order as required for thumbprints.
+func jwk(k *ecdsa.PublicKey) map[string]string {
+	return map[string]string{"crv": "P-256", "kty": "EC", "x": b64(pad32(k.X)), "y": b64(pad32(k.Y))}
+}
+
+// thumbprint returns the JWK thumbprint of the given public key as described in RFC 7638.
+func thumbprint(k *ecdsa.PublicKey) string {
+	j := jwk(k)
+	hash := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, j["crv"], j["kty"], j["x"], j["y"])))
+	return b64(hash[:])
+}
+
+func pad32(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) >= 32 {
+		return b
+	}
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
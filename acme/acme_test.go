@@ -0,0 +1,259 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCA is a minimal ACME server which verifies the request signatures and validates the HTTP-01 challenges
+// by fetching the key authorizations from the challenge server.
+type fakeCA struct {
+	t         *testing.T
+	srv       *httptest.Server
+	challenge string // URL of the HTTP-01 challenge server, in place of the domains
+	key       *ecdsa.PrivateKey
+
+	mutex    sync.Mutex
+	accounts map[string]*ecdsa.PublicKey // kid -> key
+	token    string
+	valid    bool
+	csr      *x509.CertificateRequest
+	orders   int
+	validity time.Duration
+}
+
+func newFakeCA(t *testing.T, challenge string) *fakeCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := &fakeCA{t: t, challenge: challenge, key: key, accounts: make(map[string]*ecdsa.PublicKey), validity: time.Hour * 24 * 90}
+	ca.srv = httptest.NewServer(ca)
+	return ca
+}
+
+func (ca *fakeCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := ca.srv.URL
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%v", time.Now().UnixNano()))
+
+	if r.URL.Path == "/directory" {
+		json.NewEncoder(w).Encode(directory{NewNonce: u + "/nonce", NewAccount: u + "/account", NewOrder: u + "/order"})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+
+	payload, err := ca.verify(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(Error{Type: "urn:ietf:params:acme:error:malformed", Detail: err.Error()})
+		return
+	}
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	switch r.URL.Path {
+	case "/account":
+		w.Header().Set("Location", u+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	case "/order":
+		ca.orders++
+		ca.token = fmt.Sprintf("token%v", ca.orders)
+		ca.valid = false
+		w.Header().Set("Location", u+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(order{Status: "pending", Authorizations: []string{u + "/authz/1"}, Finalize: u + "/finalize/1"})
+	case "/authz/1":
+		status := "pending"
+		if ca.valid {
+			status = "valid"
+		}
+		json.NewEncoder(w).Encode(authorization{
+			Status:     status,
+			Identifier: identifier{Type: "dns", Value: "titan.test"},
+			Challenges: []challenge{
+				{Type: "dns-01", URL: u + "/chall/dns", Token: ca.token},
+				{Type: "http-01", URL: u + "/chall/1", Token: ca.token},
+			},
+		})
+	case "/chall/1":
+		res, err := http.Get(ca.challenge + "/.well-known/acme-challenge/" + ca.token)
+		if err != nil {
+			ca.t.Error(err)
+			return
+		}
+		keyAuth, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		thumb := thumbprint(ca.accounts[u+"/account/1"])
+		if string(keyAuth) != ca.token+"."+thumb {
+			ca.t.Errorf("invalid key authorization: %s", keyAuth)
+		}
+		ca.valid = true
+		json.NewEncoder(w).Encode(challenge{Type: "http-01", Status: "valid"})
+	case "/finalize/1":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		if ca.csr, err = x509.ParseCertificateRequest(der); err != nil {
+			ca.t.Error(err)
+		}
+		json.NewEncoder(w).Encode(order{Status: "valid", Certificate: u + "/cert/1"})
+	case "/order/1":
+		json.NewEncoder(w).Encode(order{Status: "valid", Certificate: u + "/cert/1"})
+	case "/cert/1":
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(ca.orders)),
+			Subject:      ca.csr.Subject,
+			DNSNames:     ca.csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(ca.validity),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, ca.csr.PublicKey, ca.key)
+		if err != nil {
+			ca.t.Error(err)
+		}
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (ca *fakeCA) orderCount() int {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	return ca.orders
+}
+
+// verify checks the JWS signature of the request with the embedded key or the key of the given account, and returns the payload.
+func (ca *fakeCA) verify(r *http.Request) ([]byte, error) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, err
+	}
+
+	ph, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var protected struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  map[string]string
+	}
+	if err := json.Unmarshal(ph, &protected); err != nil {
+		return nil, err
+	}
+	if protected.Alg != "ES256" || protected.Nonce == "" || protected.URL != ca.srv.URL+r.URL.Path {
+		return nil, fmt.Errorf("invalid protected header: %s", ph)
+	}
+
+	var key *ecdsa.PublicKey
+	ca.mutex.Lock()
+	if protected.Kid != "" {
+		key = ca.accounts[protected.Kid]
+	} else if protected.JWK != nil && r.URL.Path == "/account" {
+		x, _ := base64.RawURLEncoding.DecodeString(protected.JWK["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(protected.JWK["y"])
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		ca.accounts[ca.srv.URL+"/account/1"] = key
+	}
+	ca.mutex.Unlock()
+	if key == nil {
+		return nil, fmt.Errorf("unknown account: %s", ph)
+	}
+
+	sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	hash := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(sig) != 64 || !ecdsa.Verify(key, hash[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	return base64.RawURLEncoding.DecodeString(jws.Payload)
+}
+
+func TestManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "titan-acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var m *Manager
+	chal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { m.HTTPHandler(nil).ServeHTTP(w, r) }))
+	defer chal.Close()
+	ca := newFakeCA(t, chal.URL)
+	defer ca.srv.Close()
+
+	if m, err = NewManager(ca.srv.URL+"/directory", "admin@titan.test", dir, []string{"titan.test", "www.titan.test"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Client.PollInterval = time.Millisecond
+
+	cert, key, err := m.Cert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := pem.Decode(cert)
+	c, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject.CommonName != "titan.test" || strings.Join(c.DNSNames, ",") != "titan.test,www.titan.test" {
+		t.Fatalf("unexpected certificate subject: %v %v", c.Subject.CommonName, c.DNSNames)
+	}
+	if kb, _ := pem.Decode(key); kb == nil || kb.Type != "EC PRIVATE KEY" {
+		t.Fatalf("unexpected certificate key: %s", key)
+	}
+
+	// cached certificate is reused by the same or a new manager, until it is about to expire
+	if _, _, err := m.Cert(); err != nil || ca.orderCount() != 1 {
+		t.Fatalf("expected cached certificate to be reused, got %v orders: %v", ca.orderCount(), err)
+	}
+	if m, err = NewManager(ca.srv.URL+"/directory", "", dir, []string{"titan.test", "www.titan.test"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Client.PollInterval = time.Millisecond
+	if cert2, _, err := m.Cert(); err != nil || ca.orderCount() != 1 || string(cert2) != string(cert) {
+		t.Fatalf("expected certificate to be read from cache, got %v orders: %v", ca.orderCount(), err)
+	}
+
+	m.RenewBefore = ca.validity + time.Hour
+	if cert2, _, err := m.Cert(); err != nil || ca.orderCount() != 2 || string(cert2) == string(cert) {
+		t.Fatalf("expected expiring certificate to be renewed, got %v orders: %v", ca.orderCount(), err)
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	c := &Client{challenges: map[string]string{"token": "token.thumb"}}
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("fallback")) })
+	h := c.HTTPHandler(fallback)
+
+	for path, want := range map[string]string{
+		"/.well-known/acme-challenge/token": "token.thumb",
+		"/.well-known/acme-challenge/wrong": "404 page not found\n",
+		"/":                                 "fallback",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Body.String() != want {
+			t.Fatalf("expected %q for %v, got %q", want, path, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Manager obtains certificates for a set of domains with an ACME client, and caches them in a directory
+// so they are reused across restarts and only renewed when they are about to expire.
+type Manager struct {
+	Client      *Client
+	Domains     []string      // Domains to obtain the certificate for.
+	CacheDir    string        // Directory to cache the account key, certificate, and certificate key in. Caching is disabled if empty.
+	RenewBefore time.Duration // Renew the certificate when it expires within this duration. Defaults to 30 days.
+
+	mutex sync.Mutex
+	cert  []byte
+	key   []byte
+}
+
+// NewManager creates a new certificate manager for the given domains using the ACME server at the given directory URL,
+// loading the account key from the cache directory or generating a new one.
+func NewManager(directoryURL, email, cacheDir string, domains []string) (*Manager, error) {
+	if len(domains) == 0 {
+		return nil, errors.New("acme: at least one domain is required")
+	}
+
+	key, err := loadAccountKey(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manager{
+		Client:   &Client{DirectoryURL: directoryURL, Key: key, Email: email},
+		Domains:  domains,
+		CacheDir: cacheDir,
+	}, nil
+}
+
+// HTTPHandler returns the handler serving HTTP-01 challenge responses. See Client.HTTPHandler.
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	return m.Client.HTTPHandler(fallback)
+}
+
+// Cert returns the PEM encoded certificate chain and private key, obtaining a new certificate only if
+// there is no cached one or the cached one is about to expire.
+func (m *Manager) Cert() (cert, key []byte, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.cert == nil && m.CacheDir != "" {
+		c, cerr := ioutil.ReadFile(filepath.Join(m.CacheDir, "cert.pem"))
+		k, kerr := ioutil.ReadFile(filepath.Join(m.CacheDir, "key.pem"))
+		if cerr == nil && kerr == nil {
+			m.cert, m.key = c, k
+		}
+	}
+
+	if m.cert != nil && !m.expiring(m.cert) {
+		return m.cert, m.key, nil
+	}
+
+	if cert, key, err = m.obtain(); err != nil {
+		return nil, nil, err
+	}
+	m.cert, m.key = cert, key
+
+	if m.CacheDir != "" {
+		if err := ioutil.WriteFile(filepath.Join(m.CacheDir, "cert.pem"), cert, 0600); err != nil {
+			return nil, nil, fmt.Errorf("acme: failed to cache certificate: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(m.CacheDir, "key.pem"), key, 0600); err != nil {
+			return nil, nil, fmt.Errorf("acme: failed to cache certificate key: %v", err)
+		}
+	}
+
+	return cert, key, nil
+}
+
+// obtain generates a new certificate key and obtains a certificate for it.
+func (m *Manager) obtain() (cert, key []byte, err error) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("acme: failed to generate certificate key: %v", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.Domains[0]},
+		DNSNames: m.Domains,
+	}, k)
+	if err != nil {
+		return nil, nil, fmt.Errorf("acme: failed to create certificate request: %v", err)
+	}
+
+	if cert, err = m.Client.ObtainCertificate(m.Domains, csr); err != nil {
+		return nil, nil, err
+	}
+
+	kb, err := x509.MarshalECPrivateKey(k)
+	if err != nil {
+		return nil, nil, fmt.Errorf("acme: failed to serialize certificate key: %v", err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), nil
+}
+
+// expiring checks whether the leaf certificate in the given PEM encoded chain expires within the renewal window, or is unreadable.
+func (m *Manager) expiring(certChain []byte) bool {
+	b, _ := pem.Decode(certChain)
+	if b == nil {
+		return true
+	}
+	c, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		return true
+	}
+
+	renewBefore := m.RenewBefore
+	if renewBefore == 0 {
+		renewBefore = time.Hour * 24 * 30
+	}
+	return time.Now().Add(renewBefore).After(c.NotAfter)
+}
+
+// loadAccountKey reads the account key from the cache directory, or generates and caches a new one.
+func loadAccountKey(cacheDir string) (*ecdsa.PrivateKey, error) {
+	var path string
+	if cacheDir != "" {
+		if err := os.MkdirAll(cacheDir, 0700); err != nil {
+			return nil, fmt.Errorf("acme: failed to create cache directory: %v", err)
+		}
+
+		path = filepath.Join(cacheDir, "account.pem")
+		if kp, err := ioutil.ReadFile(path); err == nil {
+			b, _ := pem.Decode(kp)
+			if b == nil {
+				return nil, fmt.Errorf("acme: invalid account key in %v", path)
+			}
+			k, err := x509.ParseECPrivateKey(b.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("acme: invalid account key in %v: %v", path, err)
+			}
+			return k, nil
+		}
+	}
+
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("acme: failed to generate account key: %v", err)
+	}
+
+	if path != "" {
+		kb, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("acme: failed to serialize account key: %v", err)
+		}
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600); err != nil {
+			return nil, fmt.Errorf("acme: failed to cache account key: %v", err)
+		}
+	}
+
+	return k, nil
+}
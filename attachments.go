@@ -0,0 +1,187 @@
+package titan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
+)
+
+var attachmentLog = log.Component("attachment")
+
+// attachmentHandler is the HTTP endpoint to upload and download the content of the attachments, so large files do not flow
+// through the chat connections. Requests are authenticated with the same JWT tokens as the connections:
+//
+//	PUT /attachments/<id> with "Authorization: Bearer <token>" header uploads the content of an attachment to the slot
+//	requested with attachment.upload. Only the uploader can upload the content, and only once. Content is verified against
+//	the size and the checksum of the slot, and rejected with 400 if they do not match.
+//
+//	GET /attachments/<id> with "Authorization: Bearer <token>" header downloads the content of an attachment, which is allowed
+//	for the uploader and the participants of the conversations the attachment is sent to. If the content is kept in an object
+//	storage service, the request is redirected to a presigned URL at the service instead. Thumbnails of image attachments
+//	are downloaded with "?thumbnail=<size>" query.
+//
+// Unknown attachments, and the ones the user has no access to, are responded with 404.
+type attachmentHandler struct {
+	db      *data.DB
+	blobs   *data.BlobStore
+	jwtKeys *jwtKeys
+}
+
+func (h *attachmentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.auth(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, attachmentPath)
+	switch r.Method {
+	case "PUT":
+		h.upload(w, r, userID, id)
+	case "GET":
+		h.download(w, r, userID, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// auth verifies the JWT token in the Authorization header of a request and returns the ID of the user.
+func (h *attachmentHandler) auth(r *http.Request) (userID string, ok bool) {
+	t := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if t == "" {
+		return "", false
+	}
+
+	_, userID, err := verifyJWT(h.jwtKeys, *h.db, t)
+	return userID, err == nil
+}
+
+func (h *attachmentHandler) upload(w http.ResponseWriter, r *http.Request, userID, id string) {
+	a, ok, err := (*h.db).GetAttachment(id)
+	if err != nil {
+		attachmentLog.Errorf("failed to get attachment %v: %v", id, err)
+		http.Error(w, "failed to get attachment", http.StatusInternalServerError)
+		return
+	}
+	if !ok || a.Owner != userID {
+		http.NotFound(w, r)
+		return
+	}
+	if a.Uploaded {
+		http.Error(w, "attachment is already uploaded", http.StatusConflict)
+		return
+	}
+	if r.ContentLength > a.Size {
+		http.Error(w, "attachment is larger than the slot", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// content is hashed as it is stored, and dropped if it does not match the slot
+	hash := sha256.New()
+	body := &countingReader{r: io.TeeReader(io.LimitReader(r.Body, a.Size+1), hash)}
+	if err := (*h.blobs).PutBlob(id, body); err != nil {
+		attachmentLog.Errorf("failed to store content of attachment %v: %v", id, err)
+		http.Error(w, "failed to store attachment", http.StatusInternalServerError)
+		return
+	}
+	if body.n != a.Size || hex.EncodeToString(hash.Sum(nil)) != strings.ToLower(a.Checksum) {
+		if err := (*h.blobs).DeleteBlob(id); err != nil {
+			attachmentLog.Errorf("failed to delete mismatching content of attachment %v: %v", id, err)
+		}
+		http.Error(w, "attachment size or checksum does not match", http.StatusBadRequest)
+		return
+	}
+
+	a.Uploaded = true
+	if err := (*h.db).SaveAttachment(a); err != nil {
+		attachmentLog.Errorf("failed to save attachment %v: %v", id, err)
+		http.Error(w, "failed to save attachment", http.StatusInternalServerError)
+		return
+	}
+
+	attachmentLog.Debugf("attachment %v of %v bytes is uploaded by user %v", id, a.Size, userID)
+	go generateThumbnails(*h.db, *h.blobs, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *attachmentHandler) download(w http.ResponseWriter, r *http.Request, userID, id string) {
+	a, ok, err := (*h.db).GetAttachment(id)
+	if err != nil {
+		attachmentLog.Errorf("failed to get attachment %v: %v", id, err)
+		http.Error(w, "failed to get attachment", http.StatusInternalServerError)
+		return
+	}
+	if !ok || !a.Uploaded || !canAccessAttachment(*h.db, a, userID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// thumbnails are downloaded from the same URL with the size of the thumbnail as the query parameter
+	blobID, mimeType, size, etag := id, a.MimeType, a.Size, a.Checksum
+	if q := r.URL.Query().Get("thumbnail"); q != "" {
+		n, _ := strconv.Atoi(q)
+		t, ok := a.Thumbnail(n)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		blobID, mimeType, size, etag = thumbnailBlobID(id, t.Size), t.MimeType, -1, ""
+	}
+
+	// content kept in an object storage service is downloaded directly from the service
+	if p, ok := (*h.blobs).(data.BlobPresigner); ok {
+		url, err := p.PresignGet(blobID, attachmentURLTTL)
+		if err != nil {
+			attachmentLog.Errorf("failed to presign download url of attachment %v: %v", id, err)
+			http.Error(w, "failed to read attachment", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+		return
+	}
+
+	rc, err := (*h.blobs).GetBlob(blobID)
+	if err == data.ErrBlobNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		attachmentLog.Errorf("failed to read content of attachment %v: %v", id, err)
+		http.Error(w, "failed to read attachment", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	if mimeType != "" {
+		w.Header().Set("Content-Type", mimeType)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	// content is uploaded by the users, so it is never rendered by the browsers in place
+	w.Header().Set("Content-Disposition", "attachment")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.Header().Set("ETag", `"`+etag+`"`)
+	}
+	io.Copy(w, rc)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
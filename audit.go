@@ -0,0 +1,60 @@
+package titan
+
+import (
+	"time"
+
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+var auditLog = log.Component("audit")
+
+// audit records the security relevant events (logins, token refreshes, admin actions, account changes) to the audit log sink.
+// Failures are only logged as the audited actions must not be interrupted by an unavailable sink.
+type audit struct {
+	sink  data.AuditSink
+	abuse *abuse // failed logins and token refreshes are reported as the offenses of the remote IP
+}
+
+// record appends an entry to the audit log. If the action is performed through a connection, user ID (unless given), device,
+// and remote address of the entry are filled in from the connection.
+func (a *audit) record(c *neptulon.Conn, e models.AuditEntry) {
+	if a.sink == nil {
+		return
+	}
+
+	id, err := shortid.UUID()
+	if err != nil {
+		auditLog.Errorf("failed to generate ID for %v entry of user %v: %v", e.Action, e.UserID, err)
+		return
+	}
+	e.ID, e.Time = id, time.Now()
+	if c != nil {
+		if e.UserID == "" {
+			e.UserID, _ = c.Session.Get("userid").(string)
+		}
+		e.Device, _ = c.Session.Get("device").(string)
+		if addr := c.RemoteAddr(); addr != nil {
+			e.RemoteAddr = addr.String()
+		}
+	}
+
+	if err := a.sink.AppendAudit(&e); err != nil {
+		auditLog.Errorf("failed to append %v entry of user %v: %v", e.Action, e.UserID, err)
+	}
+}
+
+// failure records a failed action along with the reason of the failure.
+func (a *audit) failure(c *neptulon.Conn, action, reason string, details map[string]string) {
+	if details == nil {
+		details = make(map[string]string)
+	}
+	details["reason"] = reason
+	a.record(c, models.AuditEntry{Action: action, Details: details})
+	if action == models.AuditLogin || action == models.AuditRefresh {
+		a.abuse.report(c, offenseAuth)
+	}
+}
@@ -0,0 +1,98 @@
+package titan
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+)
+
+// deviceCertTTL is the lifetime of the client certificates issued to the devices.
+const deviceCertTTL = time.Hour * 24 * 365
+
+// certAuth is client certificate authentication middleware, as an alternative to JWT authentication for the devices
+// which have enrolled a client certificate. Certificates are verified against the client CA during the TLS handshake
+// so the user ID (common name) and the device name (organizational unit) in a verified certificate are stored in the session as is.
+// Connections without a verified client certificate are passed on to JWT authentication.
+func certAuth(au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		if _, ok := ctx.Conn.Session.GetOk("userid"); ok {
+			return ctx.Next()
+		}
+
+		state, ok := ctx.Conn.ConnectionState()
+		if !ok || len(state.VerifiedChains) == 0 {
+			return ctx.Next()
+		}
+
+		cert := state.VerifiedChains[0][0]
+		userID := cert.Subject.CommonName
+		if userID == "" {
+			au.failure(ctx.Conn, models.AuditLogin, "client certificate without user ID", map[string]string{"method": "cert", "serial": cert.SerialNumber.String()})
+			ctx.Conn.Close()
+			return fmt.Errorf("auth: cert: client certificate without user ID: %v: %v", ctx.Conn.RemoteAddr(), cert.SerialNumber)
+		}
+
+		ctx.Conn.Session.Set("userid", userID)
+		if len(cert.Subject.OrganizationalUnit) != 0 {
+			ctx.Conn.Session.Set("device", cert.Subject.OrganizationalUnit[0])
+		}
+		au.record(ctx.Conn, models.AuditEntry{Action: models.AuditLogin, Success: true, Details: map[string]string{"method": "cert", "serial": cert.SerialNumber.String()}})
+		authLog.Infof("cert: client authenticated, user: %v, conn: %v, ip: %v", userID, ctx.Conn.ID, ctx.Conn.RemoteAddr())
+		return ctx.Next()
+	}
+}
+
+// Client certificates can only be enrolled if the client CA private key is configured.
+func initCertRoutes(r *middleware.Router, ca *clientCA, au *audit) {
+	// authentication is already done by the middleware so this only announces the presence, same as auth.jwt
+	r.Request("auth.cert", initJWTAuthHandler())
+	r.Request("cert.enroll", initEnrollCertHandler(ca, au))
+}
+
+// Issues a client certificate signed by the client CA to the calling user's device,
+// so the device can authenticate with the certificate instead of a JWT token in the future connections.
+func initEnrollCertHandler(ca *clientCA, au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		if ca.key == nil {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Certificate enrollment is not enabled."}
+			return ctx.Next()
+		}
+
+		var req struct {
+			Device string `json:"device"`
+		}
+		ctx.Params(&req)
+		if req.Device == "" {
+			req.Device, _ = ctx.Conn.Session.Get("device").(string)
+		}
+		if req.Device == "" {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Device name is required."}
+			return ctx.Next()
+		}
+
+		userID := ctx.Conn.Session.Get("userid").(string)
+		cert, key, err := GenCert(CertOptions{
+			CommonName: userID,
+			OrgUnit:    req.Device,
+			ECDSA:      true,
+			ValidFor:   deviceCertTTL,
+			ClientAuth: true,
+			CACert:     ca.cert,
+			CAKey:      ca.key,
+		})
+		if err != nil {
+			return fmt.Errorf("route: cert.enroll: failed to issue certificate: %v", err)
+		}
+
+		au.record(ctx.Conn, models.AuditEntry{Action: models.AuditCertEnroll, Success: true, Details: map[string]string{"device": req.Device}})
+		authLog.Infof("cert: issued client certificate, user: %v, device: %v", userID, req.Device)
+		res := models.DeviceCert{Cert: string(cert), Key: string(key), Expires: time.Now().Add(deviceCertTTL)}
+		ctx.Res = res
+		res.Key = "***" // don't log the private key
+		ctx.Session.Set(middleware.CustResLogDataKey, res)
+		return ctx.Next()
+	}
+}
@@ -0,0 +1,117 @@
+package titan
+
+import (
+	"fmt"
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+type registerReq struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type verifyEmailReq struct {
+	Email    string `json:"email"`
+	Code     string `json:"code"`
+	Device   string `json:"device,omitempty"`
+	GCMRegID string `json:"gcmRegId,omitempty"`
+}
+
+// emailRegister registers a user with an e-mail address and sends a verification code to the address. Account stays unverified,
+// and no tokens are issued for it, until the code is given back with auth.verifyEmail. Registering again with an unverified
+// address sends a new code, which invalidates the previous one.
+func emailRegister(ctx *neptulon.ReqCtx, db data.DB, vf *verifier, au *audit) error {
+	var r registerReq
+	if err := ctx.Params(&r); err != nil {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Malformed registration request."}
+		return nil
+	}
+	email, ok := normalizeEmail(r.Email)
+	if !ok {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid e-mail address."}
+		return nil
+	}
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" || len(r.Name) > profileNameMaxLen {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: fmt.Sprintf("Name is required and cannot be longer than %v characters.", profileNameMaxLen)}
+		return nil
+	}
+
+	user, ok := db.GetByEmail(email)
+	if ok && !user.Unverified {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "E-mail address is already registered."}
+		au.failure(ctx.Conn, models.AuditRegister, "address is already registered", map[string]string{"email": email})
+		return nil
+	}
+	if !ok {
+		user = &models.User{Email: email, Name: r.Name, Registered: time.Now(), Unverified: true}
+	} else {
+		user.Name = r.Name
+	}
+	if err := db.SaveUser(user); err != nil {
+		return fmt.Errorf("auth: email: failed to persist user information: %v", err)
+	}
+
+	switch err := vf.sendEmail(user.ID, email); err {
+	case nil:
+	case errVerifyDisabled:
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Registration with an e-mail address is not enabled."}
+		return nil
+	case errVerifyThrottled:
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "A verification code was sent recently. Please wait before requesting another one."}
+		return nil
+	default:
+		return fmt.Errorf("auth: email: failed to send verification code to %v: %v", email, err)
+	}
+
+	ctx.Res = "ACK"
+	au.record(ctx.Conn, models.AuditEntry{Action: models.AuditRegister, UserID: user.ID, Success: true, Details: map[string]string{"email": email}})
+	return nil
+}
+
+// emailVerify verifies the e-mail address of a user registered with auth.register with the code sent to the address,
+// and signs in the user. Wrong codes are recorded to the audit log as failed logins.
+func emailVerify(ctx *neptulon.ReqCtx, db data.DB, keys *jwtKeys, vf *verifier, ev *events, au *audit) error {
+	var r verifyEmailReq
+	if err := ctx.Params(&r); err != nil || r.Code == "" {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Malformed or null verification code was provided."}
+		return nil
+	}
+	email, _ := normalizeEmail(r.Email)
+
+	userID, err := vf.check(models.VerifyEmail, email, "", r.Code)
+	if err == errVerifyInvalid {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid or expired verification code."}
+		au.failure(ctx.Conn, models.AuditLogin, "invalid verification code", map[string]string{"method": "email", "email": email})
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("auth: email: failed to check verification code: %v", err)
+	}
+
+	user, ok := db.GetByID(userID)
+	if !ok || user.Email != email {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid or expired verification code."}
+		au.failure(ctx.Conn, models.AuditLogin, "account of the verification is not found", map[string]string{"method": "email", "email": email})
+		return nil
+	}
+
+	return signIn(ctx, db, keys, ev, au, user, r.Device, r.GCMRegID, "email")
+}
+
+// normalizeEmail validates a bare e-mail address and lowercases its domain, which is case insensitive unlike the local part.
+func normalizeEmail(email string) (string, bool) {
+	email = strings.TrimSpace(email)
+	a, err := netmail.ParseAddress(email)
+	if err != nil || a.Address != email || a.Name != "" {
+		return "", false
+	}
+	i := strings.LastIndex(email, "@")
+	return email[:i] + strings.ToLower(email[i:]), true
+}
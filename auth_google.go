@@ -4,17 +4,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"strconv"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
-	"github.com/neptulon/neptulon"
-	"github.com/neptulon/neptulon/middleware"
 	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
 	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
 )
 
+var authLog = log.Component("auth")
+
 type gProfile struct {
 	Name    string
 	Email   string
@@ -22,30 +24,39 @@ type gProfile struct {
 }
 
 type tokenContainer struct {
-	Token string `json:"token"`
+	Token    string `json:"token"`
+	Device   string `json:"device,omitempty"`
+	GCMRegID string `json:"gcmRegId,omitempty"`
 }
 
 type gAuthRes struct {
-	Token   string `json:"token"`
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Email   string `json:"email"`
-	Picture []byte `json:"picture"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Email        string `json:"email"`
+	Picture      []byte `json:"picture"`
 }
 
-// googleAuth authenticates a user with Google+ using provided OAuth 2.0 access token.
-// If authenticated successfully, user profile is retrieved from Google+ and user is given a JWT token in return.
-func googleAuth(ctx *neptulon.ReqCtx, db data.DB, pass string) error {
+// googleAuth authenticates a user with the Google Sign-In ID token provided by the client.
+// If authenticated successfully, user is created or retrieved from the database, device is registered for push notifications
+// if a GCM registration ID is provided, and user is given a JWT token in return. Signing in cancels the deletion of the account, if scheduled.
+// Accounts registered with the same e-mail address but not verified yet are verified too, as Google only signs in verified addresses.
+// Sign-in attempts are recorded to the audit log.
+func googleAuth(ctx *neptulon.ReqCtx, db data.DB, keys *jwtKeys, ev *events, au *audit) error {
 	var r tokenContainer
 	if err := ctx.Params(&r); err != nil || r.Token == "" {
-		ctx.Err = &neptulon.ResError{Code: 666, Message: "Malformed or null Google oauth access token was provided."}
-		return fmt.Errorf("auth: google: malformed or null Google oauth token '%v' was provided: %v", r.Token, err)
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Malformed or null Google ID token was provided."}
+		authLog.Warnf("google: malformed or null Google ID token '%v' was provided: %v", r.Token, err)
+		return nil
 	}
 
 	p, err := getTokenInfo(r.Token)
 	if err != nil {
-		ctx.Err = &neptulon.ResError{Code: 666, Message: "Failed to authenticated with the given Google oauth access token."}
-		return fmt.Errorf("auth: google: error during Google API call using provided token: %v with error: %v", r.Token, err)
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Failed to authenticate with the given Google ID token."}
+		au.failure(ctx.Conn, models.AuditLogin, "invalid Google ID token", map[string]string{"method": "google"})
+		authLog.Warnf("google: error verifying provided ID token: %v with error: %v", r.Token, err)
+		return nil
 	}
 
 	// retrieve user information
@@ -58,38 +69,82 @@ func googleAuth(ctx *neptulon.ReqCtx, db data.DB, pass string) error {
 		if ierr := db.SaveUser(user); ierr != nil {
 			return fmt.Errorf("auth: google: failed to persist user information: %v", ierr)
 		}
+		ev.publish(models.EventUserRegistered, user.ID, user.Profile())
+	}
 
-		// create the JWT token
-		token := jwt.New(jwt.SigningMethodHS256)
-		token.Claims["userid"] = user.ID
-		token.Claims["created"] = user.Registered.Unix()
-		user.JWTToken, err = token.SignedString([]byte(pass))
+	return signIn(ctx, db, keys, ev, au, user, r.Device, r.GCMRegID, "google")
+}
+
+// signIn signs in an authenticated user with the given authentication method. Device is registered for push notifications
+// if a GCM registration ID is provided, and user is given a JWT token and a refresh token in return. Signing in completes
+// the registration of the unverified accounts, and cancels the deletion of the account, if scheduled.
+func signIn(ctx *neptulon.ReqCtx, db data.DB, keys *jwtKeys, ev *events, au *audit, user *models.User, device, gcmRegID, method string) error {
+	// store user ID in session so user can make authenticated call after this
+	ctx.Conn.Session.Set("userid", user.ID)
+	if device != "" {
+		ctx.Conn.Session.Set("device", device)
+	}
+
+	// create the JWT token for new users, if the token was signed with a key that has been rotated since, or if the role of the user has changed
+	save := false
+	if claims, current, _ := keys.Parse(user.JWTToken); !current || claimRole(claims) != userRole(user) {
+		var err error
+		user.JWTToken, err = keys.Sign(userClaims(user))
 		if err != nil {
-			return fmt.Errorf("auth: google: jwt signing error: %v", err)
+			return fmt.Errorf("auth: %v: jwt signing error: %v", method, err)
 		}
+		save = true
+	}
+
+	// account registered with an e-mail address is complete once the address is verified
+	if user.Unverified {
+		user.Unverified = false
+		save = true
+		ev.publish(models.EventUserRegistered, user.ID, user.Profile())
+	}
 
-		// now save the full user info
+	// signing in within the grace period cancels the deletion of the account
+	if !user.DeleteAt.IsZero() {
+		user.DeleteAt = time.Time{}
+		save = true
+		ev.publish(models.EventAccountDeletionCanceled, user.ID, nil)
+		au.record(ctx.Conn, models.AuditEntry{Action: models.AuditAccountRestore, Success: true})
+		authLog.Infof("%v: deletion of the account of user %v is canceled", method, user.ID)
+	}
+
+	// register the device for push notifications
+	if gcmRegID != "" && gcmRegID != user.GCMRegID {
+		user.GCMRegID = gcmRegID
+		save = true
+	}
+
+	// now save the full user info
+	if save {
 		if err := db.SaveUser(user); err != nil {
-			return fmt.Errorf("auth: google: failed to persist user information: %v", err)
+			return fmt.Errorf("auth: %v: failed to persist user information: %v", method, err)
 		}
+	}
 
-		// store user ID in session so user can make authenticated call after this
-		ctx.Conn.Session.Set("userid", user.ID)
+	// issue a refresh token for the device to obtain short-lived access tokens with
+	rt, err := newRefreshToken(db, user.ID, device)
+	if err != nil {
+		return err
 	}
 
-	ctx.Res = gAuthRes{ID: user.ID, Token: user.JWTToken, Name: user.Name, Email: user.Email, Picture: user.Picture}
+	ctx.Res = gAuthRes{ID: user.ID, Token: user.JWTToken, RefreshToken: rt, Name: user.Name, Email: user.Email, Picture: user.Picture}
 	ctx.Session.Set(middleware.CustResLogDataKey, gAuthRes{ID: user.ID, Token: user.JWTToken, Name: user.Name, Email: user.Email})
-	log.Printf("auth: google: logged in: %v, %v", p.Name, p.Email)
+	au.record(ctx.Conn, models.AuditEntry{Action: models.AuditLogin, Success: true, Details: map[string]string{"method": method, "email": user.Email}})
+	authLog.Infof("%v: logged in: %v, %v", method, user.Name, user.Email)
 	return nil
 }
 
 // ################ Google OAuth2 TokenInfo API Call ################
 
-// verifyIDToken verifies and returns ID token info as described in:
+// getTokenInfo verifies the given ID token with Google and returns the user profile as described in:
 // https://developers.google.com/identity/sign-in/android/backend-auth#send-the-id-token-to-your-server
 func getTokenInfo(idToken string) (profile *gProfile, err error) {
 	uri := fmt.Sprintf("https://www.googleapis.com/oauth2/v3/tokeninfo?id_token=%s", idToken)
-	res, err := http.Get(uri)
+	res, err := Conf.App.HTTPClient().Get(uri)
 	if err != nil || res.StatusCode >= http.StatusBadRequest {
 		err = fmt.Errorf("failed to call google oauth2 api with error: %v, and response: %+v", err, res)
 		return
@@ -108,15 +163,13 @@ func getTokenInfo(idToken string) (profile *gProfile, err error) {
 		return
 	}
 
-	// check that 'aud' claim contains our client id
-	if ti.AUD != gServerClient {
-		err = fmt.Errorf("given google oauth2 id token belongs to another app id: %v", ti.AUD)
+	if err = ti.validate(Conf.App.GoogleClientID, time.Now()); err != nil {
 		return
 	}
 
 	// retrieve profile image
 	uri = ti.Picture
-	res, err = http.Get(uri)
+	res, err = Conf.App.HTTPClient().Get(uri)
 	if err != nil {
 		err = fmt.Errorf("failed to call google oauth2 api to get user image with error: %v", err)
 		return
@@ -133,8 +186,12 @@ func getTokenInfo(idToken string) (profile *gProfile, err error) {
 	return
 }
 
+// gServerClient is the default Google OAuth 2.0 client ID for the server, which ID tokens are issued for.
 var gServerClient = "218602439235-6g09g0ap6i8v25v3rel49rtqjcu9ppj0.apps.googleusercontent.com"
 
+// gIssuers are the valid issuers of Google ID tokens.
+var gIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
 type gTokenInfo struct {
 	ISS string
 	SUB string
@@ -152,6 +209,39 @@ type gTokenInfo struct {
 	Locale        string
 }
 
+// validate checks the claims of the ID token as described in:
+// https://developers.google.com/identity/sign-in/android/backend-auth#verify-the-integrity-of-the-id-token
+func (ti *gTokenInfo) validate(clientID string, now time.Time) error {
+	// check that 'aud' claim contains our client id
+	if ti.AUD != clientID {
+		return fmt.Errorf("given google oauth2 id token belongs to another app id: %v", ti.AUD)
+	}
+
+	validIss := false
+	for _, iss := range gIssuers {
+		if ti.ISS == iss {
+			validIss = true
+		}
+	}
+	if !validIss {
+		return fmt.Errorf("given google oauth2 id token has invalid issuer: %v", ti.ISS)
+	}
+
+	exp, err := strconv.ParseInt(ti.EXP, 10, 64)
+	if err != nil {
+		return fmt.Errorf("given google oauth2 id token has malformed expiry: %v", ti.EXP)
+	}
+	if !now.Before(time.Unix(exp, 0)) {
+		return fmt.Errorf("given google oauth2 id token has expired at: %v", time.Unix(exp, 0))
+	}
+
+	if ti.Email == "" || ti.EmailVerified != "true" {
+		return fmt.Errorf("given google oauth2 id token does not have a verified e-mail address: %v", ti.Email)
+	}
+
+	return nil
+}
+
 // ################ Google+ API Call ################
 
 // getGPProfile retrieves user info (display name, e-mail, profile pic) using an oauth2 access token that has 'profile' and 'email' scopes.
@@ -159,7 +249,7 @@ type gTokenInfo struct {
 func getGPProfile(oauthToken string) (profile *gProfile, err error) {
 	// retrieve profile info from Google
 	uri := fmt.Sprintf("https://www.googleapis.com/plus/v1/people/me?access_token=%s", oauthToken)
-	res, err := http.Get(uri)
+	res, err := Conf.App.HTTPClient().Get(uri)
 	if err != nil || res.StatusCode >= http.StatusBadRequest {
 		err = fmt.Errorf("failed to call google+ api with error: %v, and response: %+v", err, res)
 		return
@@ -180,7 +270,7 @@ func getGPProfile(oauthToken string) (profile *gProfile, err error) {
 
 	// retrieve profile image
 	uri = p.Image.URL
-	res, err = http.Get(uri)
+	res, err = Conf.App.HTTPClient().Get(uri)
 	if err != nil {
 		err = fmt.Errorf("failed to call google+ api to get user image with error: %v", err)
 		return
@@ -3,6 +3,7 @@ package titan
 import (
 	"encoding/json"
 	"testing"
+	"time"
 )
 
 // Response from: GET https://www.googleapis.com/plus/v1/people/me?access_token=...
@@ -53,3 +54,51 @@ func TestGoogleAuth(t *testing.T) {
 		t.Fatal("Cannot deserialize Google 'plus/v1/people/me' response.")
 	}
 }
+
+// Response from: GET https://www.googleapis.com/oauth2/v3/tokeninfo?id_token=...
+var googleTokenInfoStr = []byte(`{
+ "iss": "https://accounts.google.com",
+ "sub": "110169484474386276334",
+ "azp": "1008719970978-hb24n2dstb40o45d4feuo2ukqmcc6381.apps.googleusercontent.com",
+ "aud": "1008719970978-hb24n2dstb40o45d4feuo2ukqmcc6381.apps.googleusercontent.com",
+ "iat": "1433978353",
+ "exp": "1433981953",
+ "email": "chuck@titan.com",
+ "email_verified": "true",
+ "name": "Chuck Norris",
+ "picture": "https://lh4.googleusercontent.com/-kYgzyAWpZzJ/ABCDEFGHI/AAAJKLMNOP/tIXL9Ir44LE/s99-c/photo.jpg",
+ "given_name": "Chuck",
+ "family_name": "Norris",
+ "locale": "en"
+}`)
+
+func TestGoogleTokenInfo(t *testing.T) {
+	clientID := "1008719970978-hb24n2dstb40o45d4feuo2ukqmcc6381.apps.googleusercontent.com"
+	now := time.Unix(1433978400, 0)
+
+	var ti gTokenInfo
+	if err := json.Unmarshal(googleTokenInfoStr, &ti); err != nil {
+		t.Fatal(err)
+	}
+	if ti.Email != email || ti.GivenName+" "+ti.FamilyName != displayName {
+		t.Fatal("Cannot deserialize Google 'oauth2/v3/tokeninfo' response.")
+	}
+	if err := ti.validate(clientID, now); err != nil {
+		t.Fatalf("valid ID token was rejected: %v", err)
+	}
+
+	cases := map[string]func(ti *gTokenInfo){
+		"other app":      func(ti *gTokenInfo) { ti.AUD = "other-app" },
+		"invalid issuer": func(ti *gTokenInfo) { ti.ISS = "accounts.evil.com" },
+		"expired":        func(ti *gTokenInfo) { ti.EXP = "1433978000" },
+		"malformed exp":  func(ti *gTokenInfo) { ti.EXP = "tomorrow" },
+		"unverified":     func(ti *gTokenInfo) { ti.EmailVerified = "false" },
+	}
+	for name, modify := range cases {
+		invalid := ti
+		modify(&invalid)
+		if err := invalid.validate(clientID, now); err == nil {
+			t.Fatalf("invalid ID token (%v) was accepted", name)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package titan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+type refreshAuthRes struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// newRefreshToken issues a new long-lived refresh token for the given user and device.
+// Only the hash of the token is persisted in the database.
+func newRefreshToken(db data.DB, userID, device string) (string, error) {
+	token, err := shortid.ID(256)
+	if err != nil {
+		return "", fmt.Errorf("auth: refresh: failed to generate refresh token: %v", err)
+	}
+
+	rt := models.RefreshToken{ID: hashToken(token), UserID: userID, Device: device, Created: time.Now()}
+	if err := db.SaveRefreshToken(&rt); err != nil {
+		return "", fmt.Errorf("auth: refresh: failed to persist refresh token: %v", err)
+	}
+
+	return token, nil
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// refreshAuth exchanges a refresh token for a short-lived JWT access token.
+// Access tokens carry the refresh token ID in "sid" claim so they can be revoked along with the refresh token, and the current role of the user.
+// Refresh attempts are recorded to the audit log.
+func refreshAuth(ctx *neptulon.ReqCtx, db data.DB, keys *jwtKeys, au *audit) error {
+	var r tokenContainer
+	if err := ctx.Params(&r); err != nil || r.Token == "" {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Malformed or null refresh token was provided."}
+		return nil
+	}
+
+	rt, ok := db.GetRefreshToken(hashToken(r.Token))
+	if !ok || rt.Revoked {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid or revoked refresh token."}
+		au.failure(ctx.Conn, models.AuditRefresh, "invalid or revoked refresh token", nil)
+		authLog.Warnf("refresh: invalid or revoked refresh token from: %v", ctx.Conn.RemoteAddr())
+		return nil
+	}
+
+	now := time.Now()
+	exp := now.Add(Conf.App.AccessTokenTTL)
+	claims := map[string]interface{}{"userid": rt.UserID, "created": now.Unix(), "exp": exp.Unix(), "sid": rt.ID}
+	if u, ok := db.GetByID(rt.UserID); ok && userRole(u) != "" {
+		claims["role"] = userRole(u)
+	}
+	token, err := keys.Sign(claims)
+	if err != nil {
+		return fmt.Errorf("auth: refresh: jwt signing error: %v", err)
+	}
+
+	au.record(ctx.Conn, models.AuditEntry{Action: models.AuditRefresh, UserID: rt.UserID, Success: true, Details: map[string]string{"session": rt.ID, "device": rt.Device}})
+	ctx.Res = refreshAuthRes{Token: token, Expires: exp}
+	return nil
+}
+
+// revokeAuth revokes a refresh token of the calling user, along with all the access tokens issued with it.
+func revokeAuth(ctx *neptulon.ReqCtx, db data.DB, au *audit) error {
+	var r tokenContainer
+	if err := ctx.Params(&r); err != nil || r.Token == "" {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Malformed or null refresh token was provided."}
+		return nil
+	}
+
+	rt, ok := db.GetRefreshToken(hashToken(r.Token))
+	if !ok || rt.UserID != ctx.Conn.Session.Get("userid").(string) {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Refresh token not found."}
+		return nil
+	}
+
+	rt.Revoked = true
+	if err := db.SaveRefreshToken(rt); err != nil {
+		return fmt.Errorf("auth: revoke: failed to persist refresh token: %v", err)
+	}
+	au.record(ctx.Conn, models.AuditEntry{Action: models.AuditRevoke, Success: true, Details: map[string]string{"session": rt.ID, "device": rt.Device}})
+
+	ctx.Res = client.ACK
+	return nil
+}
+
+// revokeDevice revokes the refresh tokens of a device of a user, or of all the devices if device is empty, along with all
+// the access tokens issued with them, and closes the connections of the device except the given one (i.e. the caller's own),
+// which is left open to receive the response. Returns the number of the revoked tokens and the closed connections.
+func revokeDevice(db data.DB, p *presence, userID, device string, keep *neptulon.Conn) (revoked, closed int, err error) {
+	ts, err := db.GetRefreshTokens(userID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get refresh tokens: %v", err)
+	}
+	for _, t := range ts {
+		if t.Revoked || (device != "" && t.Device != device) {
+			continue
+		}
+		t.Revoked = true
+		if err := db.SaveRefreshToken(&t); err != nil {
+			return revoked, closed, fmt.Errorf("failed to persist refresh token: %v", err)
+		}
+		revoked++
+	}
+
+	for _, c := range p.UserConns(userID) {
+		if d, _ := c.Session.Get("device").(string); c == keep || (device != "" && d != device) {
+			continue
+		}
+		if err := c.Close(); err != nil {
+			authLog.Warnf("failed to close connection %v: %v", c.ID, err)
+		}
+		closed++
+	}
+	return revoked, closed, nil
+}
+
+// isRevoked checks if the access token with the given claims was issued with a refresh token that is revoked since.
+// Tokens without "sid" claim are not issued with refresh tokens and cannot be revoked.
+func isRevoked(db data.DB, claims map[string]interface{}) bool {
+	sid, ok := claims["sid"].(string)
+	if !ok {
+		return false
+	}
+
+	rt, ok := db.GetRefreshToken(sid)
+	return !ok || rt.Revoked
+}
@@ -0,0 +1,198 @@
+package titan
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+)
+
+type totpReq struct {
+	Code string `json:"code"`
+}
+
+// totpAuth is the second authentication step middleware for the users with two-factor authentication enabled, placed right
+// after the first step (JWT or client certificate). Sessions of such users are not privileged until a valid TOTP or recovery
+// code is given with auth.totp: the authentication requests are responded with client.TOTPRequired, and the others are
+// rejected without reaching the rest of the middleware, so no messages are delivered and the user is not shown online.
+// Wrong codes are recorded to the audit log as failed logins.
+func totpAuth(tf *twoFactor, au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		if _, ok := ctx.Conn.Session.GetOk("totp"); ok {
+			return ctx.Next()
+		}
+
+		userID := ctx.Conn.Session.Get("userid").(string)
+		required, err := tf.required(userID)
+		if err != nil {
+			return fmt.Errorf("auth: totp: failed to get second factor: %v", err)
+		}
+		if !required {
+			ctx.Conn.Session.Set("totp", true)
+			return ctx.Next()
+		}
+
+		switch ctx.Method {
+		case "auth.totp":
+		case "auth.jwt", "auth.cert":
+			ctx.Res = client.TOTPRequired
+			return nil
+		default:
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Two-factor authentication code is required."}
+			return nil
+		}
+
+		var r totpReq
+		if err := ctx.Params(&r); err != nil || r.Code == "" {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Malformed or null two-factor authentication code was provided."}
+			return nil
+		}
+		recovery, err := tf.verify(userID, r.Code)
+		switch err {
+		case nil:
+		case errTOTPInvalid:
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid two-factor authentication code."}
+			au.failure(ctx.Conn, models.AuditLogin, "invalid totp code", map[string]string{"method": "totp"})
+			return nil
+		case errTOTPThrottled:
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Too many invalid two-factor authentication codes. Please try again later."}
+			au.failure(ctx.Conn, models.AuditLogin, "too many invalid totp codes", map[string]string{"method": "totp"})
+			return nil
+		default:
+			return fmt.Errorf("auth: totp: failed to verify code: %v", err)
+		}
+
+		ctx.Conn.Session.Set("totp", true)
+		ctx.Session.Set("totp", true) // lets auth.totp handler know that the code is verified with this very request
+		au.record(ctx.Conn, models.AuditEntry{Action: models.AuditLogin, Success: true, Details: map[string]string{"method": "totp", "recovery": fmt.Sprint(recovery)}})
+		authLog.Infof("totp: client authenticated, user: %v, conn: %v, ip: %v, recovery code: %v", userID, ctx.Conn.ID, ctx.Conn.RemoteAddr(), recovery)
+		return ctx.Next()
+	}
+}
+
+func initTOTPRoutes(r *middleware.Router, db *data.DB, keys *jwtKeys, tf *twoFactor, au *audit) {
+	r.Request("auth.totp", initTOTPAuthHandler(db, keys))
+	r.Request("totp.enroll", initEnrollTOTPHandler(db, tf))
+	r.Request("totp.confirm", initConfirmTOTPHandler(tf, au))
+	r.Request("totp.disable", initDisableTOTPHandler(tf, au))
+}
+
+// Second step is already done by the totpAuth middleware so this announces the presence, same as auth.jwt, and issues
+// a short-lived access token with the "2fa" claim, which is required by the endpoints outside the client connections
+// (i.e. attachments, GCM upstream, and service API) for the users with two-factor authentication enabled.
+// Access token is revoked along with the refresh token the connection is authenticated with, if any.
+func initTOTPAuthHandler(db *data.DB, keys *jwtKeys) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		if _, ok := ctx.Session.GetOk("totp"); !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Two-factor authentication is not enabled or is already done."}
+			return ctx.Next()
+		}
+
+		u, ok := (*db).GetByID(ctx.Conn.Session.Get("userid").(string))
+		if !ok {
+			return fmt.Errorf("route: auth.totp: user not found: %v", ctx.Conn.Session.Get("userid"))
+		}
+		exp := time.Now().Add(Conf.App.AccessTokenTTL)
+		claims := userClaims(u)
+		claims["2fa"] = true
+		claims["exp"] = exp.Unix()
+		if sid, ok := ctx.Conn.Session.GetOk("sid"); ok {
+			claims["sid"] = sid
+		}
+		token, err := keys.Sign(claims)
+		if err != nil {
+			return fmt.Errorf("route: auth.totp: jwt signing error: %v", err)
+		}
+
+		ctx.Res = refreshAuthRes{Token: token, Expires: exp}
+		ctx.Session.Set(middleware.CustResLogDataKey, "***") // don't log the token
+		return ctx.Next()
+	}
+}
+
+// Generates a new TOTP secret for the caller to be added to an authenticator app, along with the one-time recovery codes.
+// Two-factor authentication is enabled once the enrollment is confirmed with totp.confirm. Enrolling again before confirming
+// replaces the secret and the recovery codes.
+func initEnrollTOTPHandler(db *data.DB, tf *twoFactor) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		userID := ctx.Conn.Session.Get("userid").(string)
+		account := userID
+		if u, ok := (*db).GetByID(userID); ok && u.Email != "" {
+			account = u.Email
+		}
+
+		e, err := tf.enroll(userID, account)
+		if err == errTOTPEnabled {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Two-factor authentication is already enabled."}
+			return ctx.Next()
+		}
+		if err != nil {
+			return fmt.Errorf("route: totp.enroll: failed to enroll: %v", err)
+		}
+
+		ctx.Res = e
+		ctx.Session.Set(middleware.CustResLogDataKey, "***") // don't log the secret and the recovery codes
+		return ctx.Next()
+	}
+}
+
+// Confirms the TOTP enrollment of the caller with a code from the authenticator app, enabling two-factor authentication.
+func initConfirmTOTPHandler(tf *twoFactor, au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var r totpReq
+		if err := ctx.Params(&r); err != nil {
+			return err
+		}
+
+		switch err := tf.confirm(ctx.Conn.Session.Get("userid").(string), r.Code); err {
+		case nil:
+		case errTOTPNotFound:
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "No two-factor authentication enrollment to confirm."}
+			return ctx.Next()
+		case errTOTPEnabled:
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Two-factor authentication is already enabled."}
+			return ctx.Next()
+		case errTOTPInvalid, errTOTPThrottled:
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid two-factor authentication code."}
+			au.failure(ctx.Conn, models.AuditTOTPConfirm, "invalid totp code", nil)
+			return ctx.Next()
+		default:
+			return fmt.Errorf("route: totp.confirm: failed to confirm enrollment: %v", err)
+		}
+
+		ctx.Res = client.ACK
+		au.record(ctx.Conn, models.AuditEntry{Action: models.AuditTOTPConfirm, Success: true})
+		return ctx.Next()
+	}
+}
+
+// Disables two-factor authentication of the caller, given a valid TOTP or recovery code.
+func initDisableTOTPHandler(tf *twoFactor, au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var r totpReq
+		if err := ctx.Params(&r); err != nil {
+			return err
+		}
+
+		switch err := tf.disable(ctx.Conn.Session.Get("userid").(string), r.Code); err {
+		case nil:
+		case errTOTPNotFound:
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Two-factor authentication is not enabled."}
+			return ctx.Next()
+		case errTOTPInvalid, errTOTPThrottled:
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid two-factor authentication code."}
+			au.failure(ctx.Conn, models.AuditTOTPDisable, "invalid totp code", nil)
+			return ctx.Next()
+		default:
+			return fmt.Errorf("route: totp.disable: failed to disable: %v", err)
+		}
+
+		ctx.Res = client.ACK
+		au.record(ctx.Conn, models.AuditEntry{Action: models.AuditTOTPDisable, Success: true})
+		return ctx.Next()
+	}
+}
@@ -0,0 +1,90 @@
+package titan
+
+import (
+	"encoding/json"
+
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+// roleRanks ranks the authorization roles so that each role is granted the permissions of the lower ranking ones.
+var roleRanks = map[string]int{models.RoleUser: 0, models.RoleModerator: 1, models.RoleAdmin: 2}
+
+// validRole checks if the given role is one of the known roles. Empty role stands for the regular users.
+func validRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok || role == ""
+}
+
+// hasRole checks if the given role is granted the permissions of the required role.
+// Unknown roles (i.e. of the tokens issued by a newer version) are granted the permissions of the regular users only.
+func hasRole(role, required string) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+// connRole returns the role of the user authenticated on the given connection, as stored in the session by jwtAuth.
+// Connections authenticated with client certificates are always of the regular users.
+func connRole(c *neptulon.Conn) string {
+	role, _ := c.Session.Get("role").(string)
+	return role
+}
+
+// claimRole returns the role in the "role" claim of a JWT token, if any.
+func claimRole(claims map[string]interface{}) string {
+	role, _ := claims["role"].(string)
+	return role
+}
+
+// userRole returns the role to be issued in the JWT tokens of the given user. Regular users are issued no role claim.
+func userRole(u *models.User) string {
+	if u.Role == models.RoleUser {
+		return ""
+	}
+	return u.Role
+}
+
+// userClaims returns the claims of the JWT tokens issued to the given user, including the role of the user, if any.
+func userClaims(u *models.User) map[string]interface{} {
+	claims := map[string]interface{}{"userid": u.ID, "created": u.Registered.Unix()}
+	if role := userRole(u); role != "" {
+		claims["role"] = role
+	}
+	return claims
+}
+
+// auditRedacted lists the privileged requests whose params are left out of the audit log as they carry secrets.
+var auditRedacted = map[string]bool{"admin.jwt.rotate": true}
+
+// authorize wraps the given handler so that only the users with the given role (or a higher ranking one) can call it.
+// All the requests, including the unauthorized ones, are recorded to the audit log along with their params and outcome.
+func authorize(au *audit, role string, handler func(ctx *neptulon.ReqCtx) error) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var params json.RawMessage
+		ctx.Params(&params)
+		var target struct {
+			UserID string `json:"userid"`
+		}
+		json.Unmarshal(params, &target)
+		e := models.AuditEntry{Action: ctx.Method, Target: target.UserID, Details: make(map[string]string)}
+		if len(params) != 0 && !auditRedacted[ctx.Method] {
+			e.Details["params"] = string(params)
+		}
+
+		if !hasRole(connRole(ctx.Conn), role) {
+			e.Details["reason"] = "unauthorized"
+			au.record(ctx.Conn, e)
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Unauthorized."}
+			return ctx.Next()
+		}
+
+		err := handler(ctx)
+		e.Success = err == nil && ctx.Err == nil
+		if err != nil {
+			e.Details["reason"] = err.Error()
+		} else if ctx.Err != nil {
+			e.Details["reason"] = ctx.Err.Message
+		}
+		au.record(ctx.Conn, e)
+		return err
+	}
+}
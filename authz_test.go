@@ -0,0 +1,43 @@
+package titan
+
+import (
+	"testing"
+
+	"github.com/titan-x/titan/models"
+)
+
+func TestHasRole(t *testing.T) {
+	cases := []struct {
+		role, required string
+		ok             bool
+	}{
+		{"", models.RoleUser, true},
+		{"", models.RoleModerator, false},
+		{models.RoleModerator, models.RoleModerator, true},
+		{models.RoleModerator, models.RoleAdmin, false},
+		{models.RoleAdmin, models.RoleModerator, true},
+		{models.RoleAdmin, models.RoleAdmin, true},
+		{"superuser", models.RoleModerator, false},
+	}
+	for _, c := range cases {
+		if ok := hasRole(c.role, c.required); ok != c.ok {
+			t.Fatalf("expected hasRole(%q, %q) to be %v", c.role, c.required, c.ok)
+		}
+	}
+
+	if !validRole("") || !validRole(models.RoleAdmin) || validRole("superuser") {
+		t.Fatal("role validation failed")
+	}
+}
+
+func TestUserClaims(t *testing.T) {
+	u := models.User{ID: "1", Role: models.RoleUser}
+	if _, ok := userClaims(&u)["role"]; ok {
+		t.Fatal("regular users should not be issued a role claim")
+	}
+
+	u.Role = models.RoleModerator
+	if c := userClaims(&u); c["role"] != models.RoleModerator || c["userid"] != "1" {
+		t.Fatalf("expected moderator role claim, got: %v", c)
+	}
+}
@@ -0,0 +1,174 @@
+package titan
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+)
+
+var botLog = log.Component("bots")
+
+// botQueueSize is the maximum number of messages waiting to be handled by a bot, beyond which the senders get a queue full error.
+const botQueueSize = 1000
+
+// Bot is a server side participant of the conversations (i.e. a support assistant or a reminder service), registered with
+// Server.RegisterBot under a reserved user ID. Messages sent to the bot are handed to HandleMessage one at a time, in the order
+// they arrive, and the replies returned are sent from the bot through the message queue and push notifications, the same way
+// as the messages of the users. Replies without a recipient are sent to the sender of the message. Bots can also send messages
+// on their own at any time with Server.SendMessage.
+type Bot interface {
+	HandleMessage(m models.Message) (replies []models.Message, err error)
+}
+
+// BotFunc is an adapter to allow the use of ordinary functions as bots.
+type BotFunc func(m models.Message) ([]models.Message, error)
+
+// HandleMessage calls f(m).
+func (f BotFunc) HandleMessage(m models.Message) ([]models.Message, error) {
+	return f(m)
+}
+
+// bots dispatches the messages sent to the registered bots to their handlers. Each bot has its own queue and worker,
+// so a slow bot does not hold back the others.
+type bots struct {
+	db *data.DB
+	q  *data.Queue
+	ev *events
+	dd *dedupe
+	pu *pusher
+	fl *filters
+
+	mutex sync.RWMutex
+	list  map[string]*bot
+	done  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+}
+
+type bot struct {
+	id      string
+	handler Bot
+	msgs    chan models.Message
+}
+
+func newBots(db *data.DB, q *data.Queue, ev *events, dd *dedupe, pu *pusher, fl *filters) *bots {
+	return &bots{db: db, q: q, ev: ev, dd: dd, pu: pu, fl: fl, list: make(map[string]*bot), done: make(chan struct{})}
+}
+
+// add registers a bot under the given user ID.
+func (bs *bots) add(id string, b Bot) error {
+	if id == "" || id != strings.ToLower(id) {
+		return fmt.Errorf("bot ID should be non-empty and lowercase: %q", id)
+	}
+	if id == "echo" {
+		return fmt.Errorf("bot ID is reserved: %v", id)
+	}
+	if _, ok := (*bs.db).GetByID(id); ok {
+		return fmt.Errorf("bot ID belongs to a user: %v", id)
+	}
+
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	select {
+	case <-bs.done:
+		return fmt.Errorf("bots are closed")
+	default:
+	}
+	if _, ok := bs.list[id]; ok {
+		return fmt.Errorf("bot is already registered: %v", id)
+	}
+	bt := &bot{id: id, handler: b, msgs: make(chan models.Message, botQueueSize)}
+	bs.list[id] = bt
+	bs.wg.Add(1)
+	go bs.run(bt)
+	return nil
+}
+
+// has reports whether the given user ID belongs to a bot.
+func (bs *bots) has(id string) bool {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	_, ok := bs.list[id]
+	return ok
+}
+
+// full reports whether the queue of the bot with the given user ID is full. It is never full for the other users.
+func (bs *bots) full(id string) bool {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	bt, ok := bs.list[id]
+	return ok && len(bt.msgs) == cap(bt.msgs)
+}
+
+// dispatch queues the message to be handled by the bot it is sent to.
+func (bs *bots) dispatch(m models.Message) error {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	bt, ok := bs.list[m.To]
+	if !ok {
+		return fmt.Errorf("no bot registered for user ID: %v", m.To)
+	}
+	select {
+	case bt.msgs <- m:
+		return nil
+	default:
+		return data.ErrQueueFull
+	}
+}
+
+// close stops handling the messages, dropping the ones waiting in the queues, and waits for the ongoing ones to finish.
+func (bs *bots) close() {
+	bs.once.Do(func() {
+		bs.mutex.Lock()
+		close(bs.done)
+		bs.mutex.Unlock()
+	})
+	bs.wg.Wait()
+}
+
+func (bs *bots) run(bt *bot) {
+	defer bs.wg.Done()
+	for {
+		select {
+		case m := <-bt.msgs:
+			bs.handle(bt, m)
+		case <-bs.done:
+			return
+		}
+	}
+}
+
+// handle passes the message to the bot, notifies the sender of the delivery once the bot handles it, and sends the replies of the bot.
+// Messages the bot fails to handle stay in the sent state.
+func (bs *bots) handle(bt *bot, m models.Message) {
+	replies, err := bt.handler.HandleMessage(m)
+	if err != nil {
+		botLog.Warnf("bot %v failed to handle message %v from user %v: %v", bt.id, m.ID, m.From, err)
+		return
+	}
+
+	if r, ok := setDeliveryState(*bs.db, *bs.q, models.Receipt{ID: m.ID, State: models.StateDelivered, Time: time.Now()}); ok {
+		setMsgState(*bs.db, m.ID, r.State)
+		bs.ev.publish(models.EventMsgReceipt, r.From, r)
+		if err := (*bs.q).AddRequest(r.From, "msg.delivered", []models.Receipt{r}, ignoreResHandler); err != nil {
+			botLog.Warnf("msg.delivered receipt of message %v for user %v is discarded: %v", m.ID, r.From, err)
+		}
+	}
+
+	if len(replies) == 0 {
+		return
+	}
+	for i := range replies {
+		if replies[i].To == "" {
+			replies[i].To = m.From
+		}
+	}
+	if _, err := sendAsUser(bs.db, bs.q, bs.ev, bs.dd, bs.pu, bs, bs.fl, bt.id, "", "bot.reply", replies); err != nil {
+		botLog.Warnf("replies of bot %v to message %v from user %v are rejected: %v", bt.id, m.ID, m.From, err)
+	}
+}
@@ -0,0 +1,128 @@
+package titan
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+)
+
+var broadcastLog = log.Component("broadcast")
+
+const (
+	// broadcastBatches is the number of batches per second the recipients of a throttled broadcast are enqueued in,
+	// so the fan-out is spread over the second instead of arriving at the queue all at once.
+	broadcastBatches = 10
+
+	// broadcastPageSize is the number of user IDs retrieved from the database at a time while broadcasting to all the users.
+	broadcastPageSize = 1000
+)
+
+// BroadcastCohort selects the recipients of a broadcast. Zero value selects the users online at the time of the broadcast.
+type BroadcastCohort struct {
+	All     bool     // All the registered users, online or not.
+	UserIDs []string // Only the given users, online or not.
+}
+
+// SetBroadcastRate sets the maximum number of users per second a broadcast notice is enqueued for, so announcing to a large number
+// of users does not flood the queue and hold back the regular messages. Negative value disables the throttling.
+// If not supplied, rate is retrieved from the configuration.
+func (s *Server) SetBroadcastRate(rate int) {
+	atomic.StoreInt32(&s.broadcastRate, int32(rate))
+}
+
+// Broadcast enqueues a system notice with the given message for the users of the given cohort, which is delivered to the clients
+// as sys.notice request. Fan-out is throttled to the broadcast rate. Notices which cannot be delivered within the given TTL are
+// dead-lettered, and zero TTL means they never expire. Users whose queue is full are skipped. Broadcast returns once the notice
+// is enqueued for all the users, or once the server stops listening, with the number of users the notice is enqueued for.
+func (s *Server) Broadcast(message string, cohort BroadcastCohort, ttl time.Duration) (sent int, err error) {
+	n := models.Notice{Message: message, Time: time.Now()}
+	t := newThrottle(int(atomic.LoadInt32(&s.broadcastRate)))
+	defer t.stop()
+
+	var skipped int
+	enqueue := func(userIDs []string) (ok bool, err error) {
+		for _, uid := range userIDs {
+			t.wait()
+			if atomic.LoadInt32(&s.listening) == 0 {
+				return false, nil
+			}
+			if err := s.queue.AddRequestTTL(uid, "sys.notice", n, ttl, ignoreResHandler); err == data.ErrQueueFull {
+				skipped++
+				continue
+			} else if err != nil {
+				return false, fmt.Errorf("broadcast: failed to enqueue notice for user %v: %v", uid, err)
+			}
+			sent++
+		}
+		return true, nil
+	}
+
+	switch {
+	case cohort.All:
+		for after := ""; ; {
+			ids, err := s.db.GetUserIDs(after, broadcastPageSize)
+			if err != nil {
+				return sent, fmt.Errorf("broadcast: failed to retrieve users: %v", err)
+			}
+			if len(ids) == 0 {
+				break
+			}
+			if ok, err := enqueue(ids); !ok {
+				return sent, err
+			}
+			after = ids[len(ids)-1]
+		}
+	case cohort.UserIDs != nil:
+		if _, err := enqueue(cohort.UserIDs); err != nil {
+			return sent, err
+		}
+	default:
+		if _, err := enqueue(s.presence.OnlineUsers()); err != nil {
+			return sent, err
+		}
+	}
+
+	broadcastLog.Infof("system notice broadcast to %v users, skipped %v users with full queues", sent, skipped)
+	return sent, nil
+}
+
+// throttle paces a loop to the given number of iterations per second, letting the iterations through in batches.
+type throttle struct {
+	batch, left int
+	ticker      *time.Ticker
+}
+
+// newThrottle creates a throttle for the given rate. Zero or negative rate means no throttling.
+func newThrottle(rate int) *throttle {
+	if rate <= 0 {
+		return &throttle{}
+	}
+
+	batch := rate / broadcastBatches
+	if batch == 0 {
+		batch = 1
+	}
+	return &throttle{batch: batch, left: batch, ticker: time.NewTicker(time.Second * time.Duration(batch) / time.Duration(rate))}
+}
+
+// wait blocks until the next iteration is allowed.
+func (t *throttle) wait() {
+	if t.ticker == nil {
+		return
+	}
+	if t.left == 0 {
+		<-t.ticker.C
+		t.left = t.batch
+	}
+	t.left--
+}
+
+func (t *throttle) stop() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+	}
+}
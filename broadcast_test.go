@@ -0,0 +1,30 @@
+package titan
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	th := newThrottle(100)
+	defer th.stop()
+
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		th.wait()
+	}
+
+	// first batch of 10 goes right away, and each of the next two batches waits for 100ms
+	if d := time.Since(start); d < 150*time.Millisecond || d > time.Second {
+		t.Fatalf("expected 30 iterations to take ~200ms at 100/s, took: %v", d)
+	}
+
+	th = newThrottle(-1)
+	start = time.Now()
+	for i := 0; i < 1000; i++ {
+		th.wait()
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("expected no throttling, took: %v", d)
+	}
+}
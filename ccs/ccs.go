@@ -0,0 +1,304 @@
+// Package ccs provides GCM CCS (Cloud Connection Server) client implementation using XMPP.
+// https://developers.google.com/cloud-messaging/ccs
+package ccs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-xmpp"
+)
+
+const (
+	gcmMessageStanza = `<message id=""><gcm xmlns="google:mobile:data">%v</gcm></message>`
+	gcmDomain        = "gcm.googleapis.com"
+
+	// maxPending is the maximum number of messages pending an ACK or NACK per connection, as allowed by CCS.
+	maxPending = 100
+)
+
+// ErrClosed is returned by Send and Receive once the connection is closed with Close.
+var ErrClosed = errors.New("ccs: connection closed")
+
+// xmppConn is the underlying XMPP connection, which is replaced in tests.
+type xmppConn interface {
+	Recv() (stanza interface{}, err error)
+	SendOrg(org string) (n int, err error)
+	Close() error
+}
+
+// stanza is a stanza received from one of the XMPP connections.
+type stanza struct {
+	conn xmppConn
+	val  interface{}
+	err  error
+}
+
+// Conn is a GCM CCS connection. When CCS drains the underlying XMPP connection (i.e. for maintenance), a new XMPP connection
+// is opened for the new messages, while the messages still in flight on the old one are received until CCS closes it.
+// Up to 100 messages can be pending an ACK or NACK per connection, after which sends wait for the window to open up.
+type Conn struct {
+	Host, SenderID string
+	debug          bool
+	dial           func() (xmppConn, error)
+	stanzas        chan stanza
+	done           chan struct{}
+	sendMutex      sync.Mutex
+
+	mutex    sync.Mutex
+	window   *sync.Cond                       // signaled when messages can be sent over the current connection, or it fails
+	xmppConn xmppConn                         // connection to send the new messages over
+	conns    map[xmppConn]map[string]struct{} // all the open connections, including the draining ones, with their pending message IDs
+	err      error                            // error of the current connection, after which no messages can be sent
+}
+
+// Connect connects to GCM CCS server denoted by host (production or staging CCS endpoint URI) along with relevant credentials.
+// Debug mode dumps all CSS communications to stdout.
+func Connect(host, senderID, apiKey string, debug bool) (*Conn, error) {
+	if !strings.Contains(senderID, gcmDomain) {
+		senderID += "@" + gcmDomain
+	}
+
+	return newConn(host, senderID, debug, func() (xmppConn, error) {
+		c, err := xmpp.NewClient(host, senderID, apiKey, debug)
+		if err != nil {
+			return nil, err
+		}
+		if debug {
+			log.Printf("New CCS connection established with XMPP parameters: %+v\n", c)
+		}
+		return c, nil
+	})
+}
+
+func newConn(host, senderID string, debug bool, dial func() (xmppConn, error)) (*Conn, error) {
+	c := &Conn{
+		Host:     host,
+		SenderID: senderID,
+		debug:    debug,
+		dial:     dial,
+		stanzas:  make(chan stanza),
+		done:     make(chan struct{}),
+		conns:    make(map[xmppConn]map[string]struct{}),
+	}
+	c.window = sync.NewCond(&c.mutex)
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect opens a new XMPP connection for the new messages, and starts receiving from it.
+func (c *Conn) connect() error {
+	x, err := c.dial()
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.xmppConn = x
+	c.conns[x] = make(map[string]struct{})
+	c.window.Broadcast()
+	c.mutex.Unlock()
+
+	go func() {
+		for {
+			val, err := x.Recv()
+			select {
+			case c.stanzas <- stanza{conn: x, val: val, err: err}:
+			case <-c.done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Receive waits to receive the next incoming message from the CCS connection, which is either an upstream message
+// from a device, or an ACK, NACK, receipt, or control message (see InMsg.MessageType). Upstream messages and receipts
+// are acknowledged automatically as required by CCS. Use InMsg.NackError to get the error reported with a NACK message.
+// CONNECTION_DRAINING control messages are handled by opening a new connection before they are returned.
+// Receive should be called continuously, as ACK and NACK messages open up the send window.
+func (c *Conn) Receive() (*InMsg, error) {
+	for {
+		var s stanza
+		select {
+		case s = <-c.stanzas:
+		case <-c.done:
+			return nil, ErrClosed
+		}
+
+		c.mutex.Lock()
+		current := s.conn == c.xmppConn
+		if s.err != nil && current {
+			c.err = s.err
+			c.window.Broadcast()
+		}
+		c.mutex.Unlock()
+
+		if s.err != nil {
+			if current {
+				return nil, s.err
+			}
+			// draining connections are closed by CCS once all the messages in flight are delivered
+			c.closeConn(s.conn)
+			continue
+		}
+
+		if c.debug {
+			log.Printf("Incoming raw CCS stanza: %+v\n", s.val)
+		}
+
+		chat, ok := s.val.(xmpp.Chat)
+		if !ok {
+			continue
+		}
+
+		if chat.Type == "error" {
+			// todo: once go-xmpp can parse XMPP error messages, return error with XMPP error message (issue: https://github.com/soygul/gcm/issues/14)
+			return nil, errors.New("ccs: CCS returned an XMPP error (can be a stanza or JSON error or anything else)")
+		}
+		if len(chat.Other) == 0 {
+			continue
+		}
+
+		var m InMsg
+		if err := json.Unmarshal([]byte(chat.Other[0]), &m); err != nil {
+			return nil, fmt.Errorf("ccs: unknown message from CCS: %v", err)
+		}
+
+		switch m.MessageType {
+		case Upstream, Receipt:
+			// acknowledge the incoming upstream messages and receipts as per spec over the connection they are received from,
+			// so CCS does not redeliver them
+			ack := &OutMsg{MessageType: "ack", To: m.From, ID: m.ID}
+			if _, err := c.send(s.conn, ack); err != nil {
+				return nil, fmt.Errorf("ccs: failed to send ack message to CCS with error: %v", err)
+			}
+			return &m, nil
+		case Control:
+			if m.ControlType == "CONNECTION_DRAINING" && current {
+				if err := c.connect(); err != nil {
+					return nil, fmt.Errorf("ccs: failed to replace draining connection: %v", err)
+				}
+			}
+			return &m, nil
+		case Ack, Nack:
+			c.release(s.conn, m.ID)
+			return &m, nil
+		default:
+			// unknown message types can be ignored, as per GCM specs
+		}
+	}
+}
+
+// Send sends a message to GCM CCS server and returns the number of bytes written and any error encountered.
+// If empty message ID is given, it's auto-generated and message object is modified with the generated ID.
+// If 100 messages are already pending an ACK or NACK, Send waits until any of them is acknowledged.
+func (c *Conn) Send(m *OutMsg) (n int, err error) {
+	if m.ID == "" {
+		if m.ID, err = getMsgID(); err != nil {
+			return 0, err
+		}
+	}
+
+	c.mutex.Lock()
+	for c.err == nil && len(c.conns[c.xmppConn]) >= maxPending {
+		c.window.Wait()
+	}
+	if c.err != nil {
+		c.mutex.Unlock()
+		return 0, c.err
+	}
+	x := c.xmppConn
+	if m.MessageType == "" {
+		c.conns[x][m.ID] = struct{}{}
+	}
+	c.mutex.Unlock()
+
+	if n, err = c.send(x, m); err != nil {
+		c.release(x, m.ID)
+	}
+	return n, err
+}
+
+// release removes a message from the pending messages of a connection, opening up the send window.
+func (c *Conn) release(x xmppConn, id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if pending, ok := c.conns[x]; ok {
+		if _, ok := pending[id]; ok {
+			delete(pending, id)
+			c.window.Broadcast()
+		}
+	}
+}
+
+func (c *Conn) send(x xmppConn, m *OutMsg) (n int, err error) {
+
+	mb, err := json.Marshal(m)
+	if err != nil {
+		return 0, err
+	}
+
+	// JSON payload is the text content of the XML element, so it should be escaped
+	var ms bytes.Buffer
+	if err := xml.EscapeText(&ms, mb); err != nil {
+		return 0, err
+	}
+
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	return x.SendOrg(fmt.Sprintf(gcmMessageStanza, ms.String()))
+}
+
+func (c *Conn) closeConn(x xmppConn) {
+	c.mutex.Lock()
+	delete(c.conns, x)
+	c.mutex.Unlock()
+	x.Close()
+}
+
+// getID generates a unique message ID using crypto/rand in the form "m-96bitBase16"
+func getMsgID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("m-%x", b), nil
+}
+
+// Close a CSS connection, along with any draining XMPP connections.
+func (c *Conn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	close(c.done)
+	c.err = ErrClosed
+	c.window.Broadcast()
+
+	var err error
+	for x := range c.conns {
+		if e := x.Close(); e != nil && x == c.xmppConn {
+			err = e
+		}
+	}
+	c.conns = nil
+	return err
+}
@@ -0,0 +1,322 @@
+package ccs
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-xmpp"
+)
+
+// fakeXMPP is an in-memory XMPP connection which replays the given stanzas and records the sent ones.
+type fakeXMPP struct {
+	in     chan interface{}
+	mutex  sync.Mutex
+	out    []string
+	closed bool
+}
+
+func newFakeXMPP() *fakeXMPP {
+	return &fakeXMPP{in: make(chan interface{}, 10)}
+}
+
+func (x *fakeXMPP) Recv() (interface{}, error) {
+	s, ok := <-x.in
+	if !ok {
+		return nil, io.EOF
+	}
+	return s, nil
+}
+
+func (x *fakeXMPP) SendOrg(org string) (int, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.out = append(x.out, org)
+	return len(org), nil
+}
+
+func (x *fakeXMPP) Close() error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if !x.closed {
+		x.closed = true
+		close(x.in)
+	}
+	return nil
+}
+
+// sent returns the JSON payloads of the sent messages.
+func (x *fakeXMPP) sent(t *testing.T) []OutMsg {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	var ms []OutMsg
+	for _, s := range x.out {
+		s = strings.TrimSuffix(strings.TrimPrefix(s, `<message id=""><gcm xmlns="google:mobile:data">`), `</gcm></message>`)
+		var m OutMsg
+		if err := json.Unmarshal([]byte(html.UnescapeString(s)), &m); err != nil {
+			t.Fatalf("malformed stanza: %v: %v", s, err)
+		}
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+func (x *fakeXMPP) sentRaw() []string {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return append([]string(nil), x.out...)
+}
+
+func (x *fakeXMPP) push(json string) {
+	x.in <- xmpp.Chat{Other: []string{json}}
+}
+
+// newTestConn creates a connection which dials the given fake XMPP connections in order.
+func newTestConn(t *testing.T, xs ...*fakeXMPP) *Conn {
+	c, err := newConn("", "", false, func() (xmppConn, error) {
+		if len(xs) == 0 {
+			return nil, errors.New("no more connections")
+		}
+		x := xs[0]
+		xs = xs[1:]
+		return x, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestReceive(t *testing.T) {
+	x := newFakeXMPP()
+	c := newTestConn(t, x)
+
+	x.in <- xmpp.Presence{}
+	x.push(`{"from":"reg-1","message_id":"u-1","category":"com.titan","data":{"n.message_type":"message"}}`)
+	x.push(`{"from":"reg-1","message_id":"m-1","message_type":"ack"}`)
+	x.push(`{"from":"reg-1","message_id":"m-4","message_type":"ack","registration_id":"reg-1b"}`)
+	x.push(`{"from":"reg-2","message_id":"m-2","message_type":"nack","error":"DEVICE_UNREGISTERED","error_description":"gone"}`)
+	x.push(`{"from":"reg-3","message_id":"m-3","message_type":"nack","error":"SOMETHING_NEW"}`)
+	x.push(`{"message_type":"control","control_type":"SOMETHING_NEW"}`)
+	x.push(`{"message_type":"unknown"}`)
+	x.Close()
+
+	// upstream messages are acknowledged
+	m, err := c.Receive()
+	if err != nil || m.MessageType != Upstream || m.ID != "u-1" || m.Data["n.message_type"] != "message" || m.NackError() != nil {
+		t.Fatalf("unexpected upstream message: %+v, %v", m, err)
+	}
+	if sent := x.sent(t); len(sent) != 1 || sent[0].MessageType != "ack" || sent[0].To != "reg-1" || sent[0].ID != "u-1" {
+		t.Fatalf("expected upstream message to be acknowledged, got: %+v", sent)
+	}
+
+	if m, err := c.Receive(); err != nil || m.MessageType != Ack || m.ID != "m-1" || m.CanonicalID() != "" {
+		t.Fatalf("unexpected ack message: %+v, %v", m, err)
+	}
+	if m, err := c.Receive(); err != nil || m.CanonicalID() != "reg-1b" {
+		t.Fatalf("expected canonical registration ID with ack message, got: %+v, %v", m, err)
+	}
+
+	// nack error codes are mapped to errors
+	m, err = c.Receive()
+	if err != nil || m.MessageType != Nack {
+		t.Fatalf("unexpected nack message: %+v, %v", m, err)
+	}
+	if ne := m.NackError(); ne.Err != ErrDeviceUnregistered || ne.ID != "m-2" || ne.To != "reg-2" || ne.Code != "DEVICE_UNREGISTERED" || ne.Temporary() {
+		t.Fatalf("unexpected nack error: %+v", ne)
+	}
+	m, err = c.Receive()
+	if err != nil || m.NackError().Err != ErrNack {
+		t.Fatalf("expected unknown error code to be mapped to the generic error, got: %+v, %v", m, err)
+	}
+
+	if m, err := c.Receive(); err != nil || m.MessageType != Control || m.ControlType != "SOMETHING_NEW" {
+		t.Fatalf("unexpected control message: %+v, %v", m, err)
+	}
+
+	// unknown message types are skipped
+	if _, err := c.Receive(); err != io.EOF {
+		t.Fatalf("expected connection to be closed, got: %v", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	x := newFakeXMPP()
+	c := newTestConn(t, x)
+
+	m := OutMsg{To: "reg-1", Data: map[string]string{"text": `<b>"hi" & bye</b>`}}
+	if _, err := c.Send(&m); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(m.ID, "m-") {
+		t.Fatalf("expected message ID to be generated, got: %v", m.ID)
+	}
+
+	// payload is escaped, so it does not break the XML stanza
+	if strings.Contains(x.sentRaw()[0], "<b>") {
+		t.Fatalf("expected payload to be escaped: %v", x.out[0])
+	}
+	if sent := x.sent(t); len(sent) != 1 || sent[0].ID != m.ID || sent[0].Data["text"] != m.Data["text"] {
+		t.Fatalf("unexpected sent message: %+v", sent)
+	}
+}
+
+func TestNackError(t *testing.T) {
+	for code, temp := range map[string]bool{
+		"SERVICE_UNAVAILABLE":          true,
+		"INTERNAL_SERVER_ERROR":        true,
+		"CONNECTION_DRAINING":          true,
+		"DEVICE_MESSAGE_RATE_EXCEEDED": true,
+		"INVALID_JSON":                 false,
+		"BAD_REGISTRATION":             false,
+	} {
+		m := InMsg{MessageType: Nack, ID: "m-1", Err: code}
+		if ne := m.NackError(); ne.Temporary() != temp {
+			t.Fatalf("unexpected temporary state for %v: %v", code, ne.Temporary())
+		}
+	}
+
+	if ne := (&InMsg{MessageType: Nack, Err: "BAD_REGISTRATION"}).NackError(); !ne.Unregistered() {
+		t.Fatal("expected bad registration to be reported as unregistered")
+	}
+	if ne := (&InMsg{MessageType: Nack, Err: "SERVICE_UNAVAILABLE"}).NackError(); ne.Unregistered() {
+		t.Fatal("expected service unavailable not to be reported as unregistered")
+	}
+
+	var err error = (&InMsg{MessageType: Nack, ID: "m-1", Err: "INVALID_JSON", ErrDesc: "bad"}).NackError()
+	if err.Error() != "ccs: invalid json: message m-1: bad" {
+		t.Fatalf("unexpected error message: %v", err)
+	}
+}
+
+func TestConnectionDraining(t *testing.T) {
+	x1, x2 := newFakeXMPP(), newFakeXMPP()
+	c := newTestConn(t, x1, x2)
+	defer c.Close()
+
+	if _, err := c.Send(&OutMsg{To: "reg-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new connection is opened for the new messages once the current one is drained
+	x1.push(`{"message_type":"control","control_type":"CONNECTION_DRAINING"}`)
+	if m, err := c.Receive(); err != nil || m.MessageType != Control {
+		t.Fatalf("unexpected control message: %+v, %v", m, err)
+	}
+	if _, err := c.Send(&OutMsg{To: "reg-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if s1, s2 := x1.sent(t), x2.sent(t); len(s1) != 1 || s1[0].To != "reg-1" || len(s2) != 1 || s2[0].To != "reg-2" {
+		t.Fatalf("expected new messages to be sent over the new connection, got: %+v, %+v", s1, s2)
+	}
+
+	// messages in flight are still received from the draining connection until it is closed, and upstream messages
+	// are acknowledged over the connection they are received from
+	x1.push(`{"from":"reg-1","message_id":"m-1","message_type":"ack"}`)
+	if m, err := c.Receive(); err != nil || m.MessageType != Ack || m.ID != "m-1" {
+		t.Fatalf("unexpected ack message: %+v, %v", m, err)
+	}
+	x1.push(`{"from":"reg-3","message_id":"u-1"}`)
+	if m, err := c.Receive(); err != nil || m.ID != "u-1" {
+		t.Fatalf("unexpected upstream message: %+v, %v", m, err)
+	}
+	if s1 := x1.sent(t); len(s1) != 2 || s1[1].MessageType != "ack" || s1[1].ID != "u-1" {
+		t.Fatalf("expected upstream message to be acknowledged over the draining connection, got: %+v", s1)
+	}
+
+	// draining connection closing is not an error, while the current one closing is
+	x1.Close()
+	x2.push(`{"from":"reg-2","message_id":"m-2","message_type":"ack"}`)
+	if m, err := c.Receive(); err != nil || m.ID != "m-2" {
+		t.Fatalf("unexpected ack message: %+v, %v", m, err)
+	}
+	x2.Close()
+	if _, err := c.Receive(); err != io.EOF {
+		t.Fatalf("expected connection to be closed, got: %v", err)
+	}
+}
+
+func TestSendWindow(t *testing.T) {
+	x1, x2 := newFakeXMPP(), newFakeXMPP()
+	c := newTestConn(t, x1, x2)
+
+	var ids []string
+	for i := 0; i < maxPending; i++ {
+		m := OutMsg{To: "reg-1"}
+		if _, err := c.Send(&m); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, m.ID)
+	}
+
+	// sends wait for the pending messages to be acknowledged
+	sent := make(chan error, 1)
+	go func() {
+		_, err := c.Send(&OutMsg{To: "reg-2"})
+		sent <- err
+	}()
+	select {
+	case <-sent:
+		t.Fatal("expected send to wait for the window to open up")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	// acknowledgements of unknown messages do not open up the window
+	x1.push(`{"from":"reg-1","message_id":"unknown","message_type":"ack"}`)
+	x1.push(`{"from":"reg-1","message_id":"` + ids[0] + `","message_type":"nack","error":"BAD_REGISTRATION"}`)
+	for i := 0; i < 2; i++ {
+		if _, err := c.Receive(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected send to proceed once a pending message is acknowledged")
+	}
+	if s := x1.sent(t); len(s) != maxPending+1 || s[maxPending].To != "reg-2" {
+		t.Fatalf("unexpected sent messages: %v", len(s))
+	}
+
+	// draining connection keeps its pending messages, while the new connection has its own window
+	x1.push(`{"message_type":"control","control_type":"CONNECTION_DRAINING"}`)
+	if _, err := c.Receive(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Send(&OutMsg{To: "reg-3"}); err != nil {
+		t.Fatal(err)
+	}
+	if s := x2.sent(t); len(s) != 1 || s[0].To != "reg-3" {
+		t.Fatalf("unexpected sent messages: %+v", s)
+	}
+
+	// waiting sends fail once the connection is closed
+	for i := 1; i < maxPending; i++ {
+		if _, err := c.Send(&OutMsg{To: "reg-4"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go func() {
+		_, err := c.Send(&OutMsg{To: "reg-5"})
+		sent <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	c.Close()
+	select {
+	case err := <-sent:
+		if err != ErrClosed {
+			t.Fatalf("expected send to fail, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected waiting send to fail once the connection is closed")
+	}
+}
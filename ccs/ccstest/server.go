@@ -0,0 +1,391 @@
+// Package ccstest provides a fake GCM CCS server, so the push notification and upstream messaging flows can be tested
+// end-to-end without real Google credentials. It speaks just enough XMPP for ccs.Connect: TLS, SASL PLAIN login,
+// resource binding, and the GCM JSON messages in both directions.
+//
+// The server uses a self-signed certificate, which the clients should trust with the RootCAs pool of the server:
+//
+//	xmpp.DefaultConfig.RootCAs = s.RootCAs
+//	c, err := ccs.Connect(s.Addr, s.SenderID, s.APIKey, false)
+package ccstest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/ccs"
+)
+
+const (
+	nsStream = "http://etherx.jabber.org/streams"
+	nsSASL   = "urn:ietf:params:xml:ns:xmpp-sasl"
+	nsBind   = "urn:ietf:params:xml:ns:xmpp-bind"
+
+	streamHeader = `<?xml version='1.0'?><stream:stream from='gcm.googleapis.com' id='%v' version='1.0' ` +
+		`xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:client'>`
+	gcmStanza = `<message id=''><gcm xmlns='google:mobile:data'>%v</gcm></message>`
+)
+
+// Server is a fake GCM CCS server listening on the loopback interface.
+// Downstream messages sent by the clients are acknowledged and delivered to the Messages channel, which should be drained
+// by the tests as the connections block until the messages are received.
+type Server struct {
+	Addr     string         // Network address of the server, to be used as the CCS host.
+	SenderID string         // Sender ID accepted by the server.
+	APIKey   string         // API key accepted by the server as the password.
+	RootCAs  *x509.CertPool // Certificate pool with the self-signed certificate of the server.
+	Messages chan ccs.OutMsg
+
+	listener net.Listener
+	mutex    sync.Mutex
+	conns    map[*conn]bool    // open connections -> draining
+	nacks    map[string]string // registration ID -> NACK error code
+	canonics map[string]string // registration ID -> canonical registration ID
+	acked    map[string]bool   // IDs of the upstream messages acknowledged by the clients
+	nextID   int
+}
+
+// conn is a client connection.
+type conn struct {
+	net.Conn
+	mutex sync.Mutex
+}
+
+func (c *conn) write(format string, a ...interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := fmt.Fprintf(c.Conn, format, a...)
+	return err
+}
+
+func (c *conn) send(m interface{}) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	var e bytes.Buffer
+	if err := xml.EscapeText(&e, b); err != nil {
+		return err
+	}
+	return c.write(gcmStanza, e.String())
+}
+
+// NewServer starts a fake CCS server which accepts the given credentials.
+func NewServer(senderID, apiKey string) (*Server, error) {
+	cert, pool, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return nil, fmt.Errorf("ccstest: failed to listen: %v", err)
+	}
+
+	s := &Server{
+		Addr:     l.Addr().String(),
+		SenderID: senderID,
+		APIKey:   apiKey,
+		RootCAs:  pool,
+		Messages: make(chan ccs.OutMsg, 100),
+		listener: l,
+		conns:    make(map[*conn]bool),
+		nacks:    make(map[string]string),
+		canonics: make(map[string]string),
+		acked:    make(map[string]bool),
+	}
+	go s.accept()
+	return s, nil
+}
+
+// Nack makes the server reject the messages sent to the given registration ID with the given error code (i.e. DEVICE_UNREGISTERED).
+func (s *Server) Nack(regID, code string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.nacks[regID] = code
+}
+
+// Canonical makes the server report the given canonical registration ID with the ACKs of the messages sent to the given registration ID.
+func (s *Server) Canonical(regID, canonicalID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.canonics[regID] = canonicalID
+}
+
+// Upstream sends an upstream message from the device with the given registration ID to one of the connected clients,
+// and returns the ID of the message.
+func (s *Server) Upstream(from string, data map[string]string) (id string, err error) {
+	c, id := s.current()
+	if c == nil {
+		return "", errors.New("ccstest: no connected clients")
+	}
+	return id, c.send(ccs.InMsg{From: from, ID: id, Category: "com.titan", Data: data})
+}
+
+// Acked reports whether the upstream message with the given ID is acknowledged by the client.
+func (s *Server) Acked(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.acked[id]
+}
+
+// Drain sends a CONNECTION_DRAINING control message over all the connections, and closes them, as CCS does for maintenance.
+func (s *Server) Drain() error {
+	s.mutex.Lock()
+	var conns []*conn
+	for c, draining := range s.conns {
+		if !draining {
+			s.conns[c] = true
+			conns = append(conns, c)
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, c := range conns {
+		if err := c.send(ccs.InMsg{MessageType: ccs.Control, ControlType: "CONNECTION_DRAINING"}); err != nil {
+			return err
+		}
+		c.Close()
+	}
+	return nil
+}
+
+// Conns returns the number of the open connections which are not draining.
+func (s *Server) Conns() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := 0
+	for _, draining := range s.conns {
+		if !draining {
+			n++
+		}
+	}
+	return n
+}
+
+// Close stops the server and closes all the connections.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mutex.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mutex.Unlock()
+	return err
+}
+
+// current returns one of the connections which are not draining, along with a new message ID.
+func (s *Server) current() (*conn, string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.nextID++
+	id := fmt.Sprintf("ccstest-%v", s.nextID)
+	for c, draining := range s.conns {
+		if !draining {
+			return c, id
+		}
+	}
+	return nil, id
+}
+
+func (s *Server) accept() {
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(&conn{Conn: nc})
+	}
+}
+
+func (s *Server) serve(c *conn) {
+	defer c.Close()
+
+	d, err := s.login(c)
+	if err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	s.conns[c] = false
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, c)
+		s.mutex.Unlock()
+	}()
+
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok || se.Name.Space == nsStream {
+			continue
+		}
+		if se.Name.Local != "message" {
+			d.Skip()
+			continue
+		}
+
+		var msg struct {
+			GCM string `xml:"google:mobile:data gcm"`
+		}
+		if err := d.DecodeElement(&msg, &se); err != nil {
+			return
+		}
+		var m ccs.OutMsg
+		if err := json.Unmarshal([]byte(msg.GCM), &m); err != nil {
+			return
+		}
+		if err := s.handle(c, &m); err != nil {
+			return
+		}
+	}
+}
+
+// handle acknowledges (or rejects) a downstream message, or records the acknowledgement of an upstream message.
+func (s *Server) handle(c *conn, m *ccs.OutMsg) error {
+	switch m.MessageType {
+	case ccs.Ack, ccs.Nack:
+		s.mutex.Lock()
+		s.acked[m.ID] = true
+		s.mutex.Unlock()
+		return nil
+	}
+
+	s.mutex.Lock()
+	code, canonical := s.nacks[m.To], s.canonics[m.To]
+	s.mutex.Unlock()
+
+	res := ccs.InMsg{From: m.To, ID: m.ID, MessageType: ccs.Ack, RegistrationID: canonical}
+	if code != "" {
+		res = ccs.InMsg{From: m.To, ID: m.ID, MessageType: ccs.Nack, Err: code}
+	}
+	if err := c.send(res); err != nil {
+		return err
+	}
+
+	if code == "" && m.DeliveryReceiptRequested {
+		receipt := ccs.InMsg{From: "gcm.googleapis.com", ID: "dr2:" + m.ID, MessageType: ccs.Receipt, Category: "com.titan", Data: map[string]string{
+			"message_status":         "MESSAGE_SENT_TO_DEVICE",
+			"original_message_id":    m.ID,
+			"device_registration_id": m.To,
+		}}
+		if err := c.send(receipt); err != nil {
+			return err
+		}
+	}
+
+	s.Messages <- *m
+	return nil
+}
+
+// login handles the XMPP stream negotiation and SASL PLAIN authentication up to the resource binding,
+// and returns the decoder to read the rest of the stream with.
+func (s *Server) login(c *conn) (*xml.Decoder, error) {
+	d := xml.NewDecoder(c)
+	if err := startStream(d); err != nil {
+		return nil, err
+	}
+	if err := c.write(streamHeader+`<stream:features><mechanisms xmlns='%v'><mechanism>PLAIN</mechanism></mechanisms></stream:features>`, 1, nsSASL); err != nil {
+		return nil, err
+	}
+
+	var auth struct {
+		XMLName   xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-sasl auth"`
+		Mechanism string   `xml:"mechanism,attr"`
+		Value     string   `xml:",chardata"`
+	}
+	if err := d.Decode(&auth); err != nil {
+		return nil, err
+	}
+	cred, _ := base64.StdEncoding.DecodeString(auth.Value)
+	if auth.Mechanism != "PLAIN" || string(cred) != "\x00"+strings.TrimSuffix(s.SenderID, "@gcm.googleapis.com")+"\x00"+s.APIKey {
+		c.write(`<failure xmlns='%v'><not-authorized/></failure>`, nsSASL)
+		return nil, errors.New("ccstest: authentication failed")
+	}
+	if err := c.write(`<success xmlns='%v'/>`, nsSASL); err != nil {
+		return nil, err
+	}
+
+	// client restarts the stream after the authentication, which is decoded from scratch as it starts with an XML declaration
+	d = xml.NewDecoder(c)
+	if err := startStream(d); err != nil {
+		return nil, err
+	}
+	if err := c.write(streamHeader+`<stream:features><bind xmlns='%v'/></stream:features>`, 2, nsBind); err != nil {
+		return nil, err
+	}
+
+	var iq struct {
+		XMLName xml.Name `xml:"jabber:client iq"`
+		ID      string   `xml:"id,attr"`
+	}
+	if err := d.Decode(&iq); err != nil {
+		return nil, err
+	}
+	err := c.write(`<iq type='result' id='%v'><bind xmlns='%v'><jid>%v@gcm.googleapis.com/ccstest</jid></bind></iq>`, iq.ID, nsBind, s.SenderID)
+	return d, err
+}
+
+// startStream reads the stream header sent by the client.
+func startStream(d *xml.Decoder) error {
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if se, ok := t.(xml.StartElement); ok {
+			if se.Name.Space != nsStream || se.Name.Local != "stream" {
+				return fmt.Errorf("ccstest: expected stream header, got: %v", se.Name)
+			}
+			return nil
+		}
+	}
+}
+
+// selfSignedCert generates a certificate for the loopback interface, along with a pool trusting it.
+func selfSignedCert() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour * 24),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(c)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: c}, pool, nil
+}
@@ -0,0 +1,115 @@
+package ccstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattn/go-xmpp"
+	"github.com/titan-x/titan/ccs"
+)
+
+func connect(t *testing.T, s *Server) *ccs.Conn {
+	xmpp.DefaultConfig.RootCAs = s.RootCAs
+	c, err := ccs.Connect(s.Addr, s.SenderID, s.APIKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func receive(t *testing.T, s *Server) ccs.OutMsg {
+	select {
+	case m := <-s.Messages:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for downstream message")
+		return ccs.OutMsg{}
+	}
+}
+
+func TestServer(t *testing.T) {
+	s, err := NewServer("1234", "api-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c := connect(t, s)
+	defer c.Close()
+
+	// downstream messages are acknowledged, with the canonical registration IDs if any
+	s.Canonical("reg-2", "reg-2b")
+	s.Nack("reg-3", "DEVICE_UNREGISTERED")
+	for _, to := range []string{"reg-1", "reg-2", "reg-3"} {
+		if _, err := c.Send(&ccs.OutMsg{To: to, Data: map[string]string{"message": "hi"}}); err != nil {
+			t.Fatal(err)
+		}
+		if m := receive(t, s); m.To != to || m.Data["message"] != "hi" {
+			t.Fatalf("unexpected downstream message: %+v", m)
+		}
+
+		m, err := c.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch to {
+		case "reg-1":
+			if m.MessageType != ccs.Ack || m.CanonicalID() != "" {
+				t.Fatalf("expected ack, got: %+v", m)
+			}
+		case "reg-2":
+			if m.MessageType != ccs.Ack || m.CanonicalID() != "reg-2b" {
+				t.Fatalf("expected ack with canonical ID, got: %+v", m)
+			}
+		case "reg-3":
+			if ne := m.NackError(); ne == nil || !ne.Unregistered() {
+				t.Fatalf("expected nack, got: %+v", m)
+			}
+		}
+	}
+
+	// upstream messages are acknowledged by the client
+	id, err := s.Upstream("reg-1", map[string]string{"n.message_type": "message"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := c.Receive(); err != nil || m.MessageType != ccs.Upstream || m.ID != id || m.From != "reg-1" || m.Data["n.message_type"] != "message" {
+		t.Fatalf("unexpected upstream message: %+v, %v", m, err)
+	}
+	for i := 0; !s.Acked(id); i++ {
+		if i == 100 {
+			t.Fatal("expected upstream message to be acknowledged")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// client reconnects when the connection is drained
+	if err := s.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := c.Receive(); err != nil || m.MessageType != ccs.Control || m.ControlType != "CONNECTION_DRAINING" {
+		t.Fatalf("unexpected control message: %+v, %v", m, err)
+	}
+	if _, err := c.Send(&ccs.OutMsg{To: "reg-4"}); err != nil {
+		t.Fatal(err)
+	}
+	if m := receive(t, s); m.To != "reg-4" {
+		t.Fatalf("unexpected downstream message: %+v", m)
+	}
+	if s.Conns() != 1 {
+		t.Fatalf("expected a new connection, got: %v", s.Conns())
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	s, err := NewServer("1234", "api-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	xmpp.DefaultConfig.RootCAs = s.RootCAs
+	if _, err := ccs.Connect(s.Addr, s.SenderID, "wrong-key", false); err == nil {
+		t.Fatal("expected authentication to fail with wrong API key")
+	}
+}
@@ -0,0 +1,65 @@
+package ccs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors reported by CCS with NACK messages for the downstream messages which cannot be delivered.
+// https://developers.google.com/cloud-messaging/xmpp-server-ref#error-codes
+var (
+	ErrDeviceUnregistered = errors.New("ccs: device unregistered")
+	ErrBadRegistration    = errors.New("ccs: bad registration")
+	ErrInvalidJSON        = errors.New("ccs: invalid json")
+	ErrRateExceeded       = errors.New("ccs: message rate exceeded")
+	ErrServiceUnavailable = errors.New("ccs: service unavailable")
+	ErrConnectionDraining = errors.New("ccs: connection draining")
+	ErrBadAck             = errors.New("ccs: bad ack")
+	ErrNack               = errors.New("ccs: message rejected")
+)
+
+// nackErrs maps the NACK error codes to the errors.
+var nackErrs = map[string]error{
+	"DEVICE_UNREGISTERED":          ErrDeviceUnregistered,
+	"BAD_REGISTRATION":             ErrBadRegistration,
+	"INVALID_JSON":                 ErrInvalidJSON,
+	"DEVICE_MESSAGE_RATE_EXCEEDED": ErrRateExceeded,
+	"TOPICS_MESSAGE_RATE_EXCEEDED": ErrRateExceeded,
+	"SERVICE_UNAVAILABLE":          ErrServiceUnavailable,
+	"INTERNAL_SERVER_ERROR":        ErrServiceUnavailable,
+	"CONNECTION_DRAINING":          ErrConnectionDraining,
+	"BAD_ACK":                      ErrBadAck,
+}
+
+func nackErr(code string) error {
+	if err, ok := nackErrs[code]; ok {
+		return err
+	}
+	return ErrNack
+}
+
+// NackError is the error reported by CCS with a NACK message for a downstream message.
+type NackError struct {
+	ID          string // ID of the rejected message.
+	To          string // Registration ID of the device the message is sent to.
+	Code        string // Error code as reported by CCS, i.e. DEVICE_UNREGISTERED.
+	Description string // Optional error description.
+	Err         error  // One of the Err* errors matching the error code, or ErrNack for the unknown codes.
+}
+
+func (e *NackError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("%v: message %v: %v", e.Err, e.ID, e.Description)
+	}
+	return fmt.Sprintf("%v: message %v", e.Err, e.ID)
+}
+
+// Temporary reports whether the message can be sent again later, possibly over another connection.
+func (e *NackError) Temporary() bool {
+	return e.Err == ErrRateExceeded || e.Err == ErrServiceUnavailable || e.Err == ErrConnectionDraining
+}
+
+// Unregistered reports whether the device the message is sent to is no longer registered, so its registration ID should be removed.
+func (e *NackError) Unregistered() bool {
+	return e.Err == ErrDeviceUnregistered || e.Err == ErrBadRegistration
+}
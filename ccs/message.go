@@ -0,0 +1,60 @@
+package ccs
+
+// OutMsg is a message to be sent to GCM CCS.
+// If ID field is not set, it will be generated automatically using crypto/rand.
+// Google recommends Data field to be strings key/value pairs and keys cannot be
+// reserved words described in GCM server documentation.
+// https://developer.android.com/google/gcm/ccs.html#format
+type OutMsg struct {
+	To                       string            `json:"to"`
+	ID                       string            `json:"message_id"`
+	Data                     map[string]string `json:"data,omitempty"`
+	MessageType              string            `json:"message_type,omitempty"`
+	CollapseKey              string            `json:"collapse_key,omitempty"`
+	TimeToLive               int               `json:"time_to_live,omitempty"` //default:2419200 (in seconds = 4 weeks)
+	ContentAvailable         bool              `json:"content_available,omitempty"`
+	Priority                 string            `json:"priority,omitempty"`
+	DelayWhileIdle           bool              `json:"delay_while_idle,omitempty"`           //default:false
+	DeliveryReceiptRequested bool              `json:"delivery_receipt_requested,omitempty"` //default:false
+}
+
+// InMsg is an incoming GCM CCS message, which is either an upstream message from a device (with empty MessageType),
+// or an ACK, NACK, receipt, or control message.
+type InMsg struct {
+	From           string            `json:"from"`
+	ID             string            `json:"message_id"`
+	Category       string            `json:"category"`
+	Data           map[string]string `json:"data"`
+	MessageType    string            `json:"message_type"`
+	ControlType    string            `json:"control_type"`
+	Err            string            `json:"error"`
+	ErrDesc        string            `json:"error_description"`
+	RegistrationID string            `json:"registration_id"` // canonical registration ID of the device, reported with ACK messages
+}
+
+// possible InMsg.MessageType values
+const (
+	Upstream = ""
+	Ack      = "ack"
+	Nack     = "nack"
+	Receipt  = "receipt"
+	Control  = "control"
+)
+
+// NackError returns the error reported with a NACK message, or nil if the message is not a NACK.
+func (m *InMsg) NackError() *NackError {
+	if m.MessageType != Nack {
+		return nil
+	}
+	return &NackError{ID: m.ID, To: m.From, Code: m.Err, Description: m.ErrDesc, Err: nackErr(m.Err)}
+}
+
+// CanonicalID returns the canonical registration ID reported with an ACK message, if the message is sent to an old
+// registration ID of the device, or an empty string otherwise. The old registration ID should be replaced with the
+// canonical one, as it will stop working eventually.
+func (m *InMsg) CanonicalID() string {
+	if m.MessageType != Ack || m.RegistrationID == m.From {
+		return ""
+	}
+	return m.RegistrationID
+}
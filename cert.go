@@ -0,0 +1,151 @@
+package titan
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// CertOptions describes a certificate to be generated with GenCert.
+type CertOptions struct {
+	CommonName string        // Subject common name. Defaults to the first host, if any.
+	OrgUnit    string        // Subject organizational unit, i.e. the device name of a client certificate.
+	Hosts      []string      // DNS names and IP addresses the certificate is valid for.
+	ECDSA      bool          // Generate an ECDSA P-256 key instead of an RSA 2048-bit key.
+	ValidFor   time.Duration // Validity duration of the certificate starting from now. Defaults to 1 year.
+	IsCA       bool          // Generate a CA certificate which can sign other certificates.
+	ClientAuth bool          // Generate a client certificate (i.e. for device authentication) instead of a server certificate.
+	CACert     []byte        // PEM encoded CA certificate to sign the certificate with. Certificate is self-signed if not given.
+	CAKey      []byte        // PEM encoded private key of the CA certificate.
+}
+
+// GenCert generates a certificate and a private key with the given options, both PEM encoded.
+func GenCert(o CertOptions) (cert, key []byte, err error) {
+	var priv crypto.Signer
+	if o.ECDSA {
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("cert: failed to generate private key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cert: failed to generate serial number: %v", err)
+	}
+
+	if o.ValidFor == 0 {
+		o.ValidFor = time.Hour * 24 * 365
+	}
+	if o.CommonName == "" && len(o.Hosts) != 0 {
+		o.CommonName = o.Hosts[0]
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: o.CommonName},
+		NotBefore:             time.Now().Add(-time.Minute), // tolerate clock skew
+		NotAfter:              time.Now().Add(o.ValidFor),
+		BasicConstraintsValid: true,
+	}
+	if o.OrgUnit != "" {
+		tmpl.Subject.OrganizationalUnit = []string{o.OrgUnit}
+	}
+	for _, h := range o.Hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	switch {
+	case o.IsCA:
+		tmpl.IsCA = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	case o.ClientAuth:
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	default:
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	if !o.ECDSA {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	parent, signer := &tmpl, priv
+	if o.CACert != nil {
+		if parent, signer, err = parseCA(o.CACert, o.CAKey); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, parent, priv.Public(), signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cert: failed to create certificate: %v", err)
+	}
+
+	var keyBlock *pem.Block
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		keyBlock = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		b, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cert: failed to serialize private key: %v", err)
+		}
+		keyBlock = &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(keyBlock), nil
+}
+
+// parseCA parses the given PEM encoded CA certificate and its private key.
+func parseCA(certPEM, keyPEM []byte) (*x509.Certificate, crypto.Signer, error) {
+	b, _ := pem.Decode(certPEM)
+	if b == nil {
+		return nil, nil, errors.New("cert: failed to decode ca certificate")
+	}
+	cert, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cert: failed to parse ca certificate: %v", err)
+	}
+	if !cert.IsCA {
+		return nil, nil, errors.New("cert: given ca certificate is not a ca")
+	}
+
+	if b, _ = pem.Decode(keyPEM); b == nil {
+		return nil, nil, errors.New("cert: failed to decode ca private key")
+	}
+	var key interface{}
+	switch b.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(b.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(b.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(b.Bytes)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("cert: failed to parse ca private key: %v", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("cert: unsupported ca private key type")
+	}
+	return cert, signer, nil
+}
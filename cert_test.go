@@ -0,0 +1,76 @@
+package titan
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func parseTestCert(t *testing.T, certPEM, keyPEM []byte) *x509.Certificate {
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := pem.Decode(certPEM)
+	c, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestGenCert(t *testing.T) {
+	cert, key, err := GenCert(CertOptions{Hosts: []string{"localhost", "127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := parseTestCert(t, cert, key)
+	if c.Subject.CommonName != "localhost" || len(c.DNSNames) != 1 || len(c.IPAddresses) != 1 || !c.IPAddresses[0].IsLoopback() {
+		t.Fatalf("unexpected certificate subject: %v %v %v", c.Subject.CommonName, c.DNSNames, c.IPAddresses)
+	}
+	if k, ok := c.PublicKey.(*rsa.PublicKey); !ok || k.N.BitLen() != 2048 {
+		t.Fatalf("expected a 2048-bit rsa key, got: %T", c.PublicKey)
+	}
+	if d := c.NotAfter.Sub(time.Now()); d < time.Hour*24*364 || d > time.Hour*24*366 {
+		t.Fatalf("expected default validity of 1 year, got: %v", d)
+	}
+	if err := c.VerifyHostname("127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// ca signed ecdsa client certificate
+	caCert, caKey, err := GenCert(CertOptions{CommonName: "titan ca", IsCA: true, ECDSA: true, ValidFor: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := parseTestCert(t, caCert, caKey)
+	if !ca.IsCA {
+		t.Fatal("expected a ca certificate")
+	}
+
+	cert, key, err = GenCert(CertOptions{CommonName: "device1", ECDSA: true, ClientAuth: true, CACert: caCert, CAKey: caKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = parseTestCert(t, cert, key)
+	if _, ok := c.PublicKey.(*ecdsa.PublicKey); !ok {
+		t.Fatalf("expected an ecdsa key, got: %T", c.PublicKey)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	if _, err := c.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}); err == nil {
+		t.Fatal("expected client certificate to be rejected for server authentication")
+	}
+
+	// leaf certificates cannot sign other certificates
+	if _, _, err := GenCert(CertOptions{CommonName: "device2", CACert: cert, CAKey: key}); err == nil {
+		t.Fatal("expected non-ca certificate to be rejected as a ca")
+	}
+}
@@ -1,9 +1,11 @@
 package client
 
 import (
-	"github.com/neptulon/cmap"
-	"github.com/neptulon/neptulon"
-	"github.com/neptulon/neptulon/middleware"
+	"crypto/tls"
+
+	"github.com/titan-x/titan/cmap"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
 )
 
 const (
@@ -12,12 +14,17 @@ const (
 
 	// NACK is the short rejection response for a request.
 	NACK = "NACK"
+
+	// TOTPRequired is the response to the authentication requests of the users with two-factor authentication enabled,
+	// until a valid code is given with auth.totp.
+	TOTPRequired = "TOTP"
 )
 
 // Client is a Titan client.
 type Client struct {
 	ID      string     // Randomly generated unique client connection ID.
 	Session *cmap.CMap // Thread-safe data store for storing arbitrary data for this connection session.
+	Device  string     // Optional device name (i.e. phone, tablet) to identify this connection with upon authentication.
 	conn    *neptulon.Conn
 	router  *middleware.Router
 }
@@ -67,6 +74,19 @@ func (c *Client) Connect(addr string) error {
 	return c.conn.Connect(addr)
 }
 
+// ConnectTLS connectes to the server at given network address with the given TLS configuration and starts receiving messages.
+// This is useful for authenticating with a client certificate (see EnrollCert) or trusting a custom CA.
+func (c *Client) ConnectTLS(addr string, config *tls.Config) error {
+	return c.conn.ConnectTLS(addr, config)
+}
+
+// SendRequest sends a JSON-RPC request with the given method and params, and calls the handler with the raw response.
+// This is useful for the routes that do not have a dedicated method, or for inspecting the error responses.
+func (c *Client) SendRequest(method string, params interface{}, resHandler func(ctx *neptulon.ResCtx) error) error {
+	_, err := c.conn.SendRequest(method, params, resHandler)
+	return err
+}
+
 // Close closes a client connection.
 func (c *Client) Close() error {
 	return c.conn.Close()
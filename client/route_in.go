@@ -3,8 +3,8 @@ package client
 import (
 	"fmt"
 
-	"github.com/neptulon/neptulon"
 	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
 )
 
 // ------ Incoming Requests ---------- //
@@ -25,3 +25,110 @@ func (c *Client) InMsgHandler(handler func(m []models.Message) error) {
 		return ctx.Next()
 	})
 }
+
+// ReceiptHandler registers a handler to accept message delivery state notifications (msg.sent, msg.delivered, msg.read)
+// for the messages sent by this client.
+func (c *Client) ReceiptHandler(handler func(r []models.Receipt) error) {
+	for _, method := range []string{"msg.sent", "msg.delivered", "msg.read"} {
+		method := method
+		c.router.Request(method, func(ctx *neptulon.ReqCtx) error {
+			var r []models.Receipt
+			if err := ctx.Params(&r); err != nil {
+				return fmt.Errorf("client: %v: error reading request params: %v", method, err)
+			}
+
+			if err := handler(r); err != nil {
+				return err
+			}
+
+			ctx.Res = ACK
+			return ctx.Next()
+		})
+	}
+}
+
+// PresenceHandler registers a handler to accept presence updates of the users this client is subscribed to.
+func (c *Client) PresenceHandler(handler func(p []models.Presence) error) {
+	c.router.Request("presence.update", func(ctx *neptulon.ReqCtx) error {
+		var p []models.Presence
+		if err := ctx.Params(&p); err != nil {
+			return fmt.Errorf("client: presence.update: error reading request params: %v", err)
+		}
+
+		if err := handler(p); err != nil {
+			return err
+		}
+
+		ctx.Res = ACK
+		return ctx.Next()
+	})
+}
+
+// TypingHandler registers a handler to accept typing notifications of the other participants of the conversations.
+func (c *Client) TypingHandler(handler func(t *models.Typing) error) {
+	c.router.Request("msg.typing", func(ctx *neptulon.ReqCtx) error {
+		var t models.Typing
+		if err := ctx.Params(&t); err != nil {
+			return fmt.Errorf("client: msg.typing: error reading request params: %v", err)
+		}
+
+		if err := handler(&t); err != nil {
+			return err
+		}
+
+		ctx.Res = ACK
+		return ctx.Next()
+	})
+}
+
+// NoticeHandler registers a handler to accept system notices broadcast by the administrators.
+func (c *Client) NoticeHandler(handler func(n *models.Notice) error) {
+	c.router.Request("sys.notice", func(ctx *neptulon.ReqCtx) error {
+		var n models.Notice
+		if err := ctx.Params(&n); err != nil {
+			return fmt.Errorf("client: sys.notice: error reading request params: %v", err)
+		}
+
+		if err := handler(&n); err != nil {
+			return err
+		}
+
+		ctx.Res = ACK
+		return ctx.Next()
+	})
+}
+
+// ExportHandler registers a handler to accept the notifications of the conversation exports requested by this client
+// getting ready, or failing.
+func (c *Client) ExportHandler(handler func(e *models.Export) error) {
+	c.router.Request("export.ready", func(ctx *neptulon.ReqCtx) error {
+		var e models.Export
+		if err := ctx.Params(&e); err != nil {
+			return fmt.Errorf("client: export.ready: error reading request params: %v", err)
+		}
+
+		if err := handler(&e); err != nil {
+			return err
+		}
+
+		ctx.Res = ACK
+		return ctx.Next()
+	})
+}
+
+// ProfileHandler registers a handler to accept profile updates of the users in the contacts of this client.
+func (c *Client) ProfileHandler(handler func(p *models.Profile) error) {
+	c.router.Request("profile.update", func(ctx *neptulon.ReqCtx) error {
+		var p models.Profile
+		if err := ctx.Params(&p); err != nil {
+			return fmt.Errorf("client: profile.update: error reading request params: %v", err)
+		}
+
+		if err := handler(&p); err != nil {
+			return err
+		}
+
+		ctx.Res = ACK
+		return ctx.Next()
+	})
+}
@@ -2,9 +2,10 @@ package client
 
 import (
 	"fmt"
+	"time"
 
-	"github.com/neptulon/neptulon"
 	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
 )
 
 // ------ Outgoing Requests ---------- //
@@ -12,7 +13,7 @@ import (
 // GoogleAuth authenticates using the given Google OAuth token and retrieves a JWT token.
 // This also announces availability to the server, so server can start sending us pending messages.
 func (c *Client) GoogleAuth(oauthToken string, handler func(jwtToken string) error) error {
-	_, err := c.conn.SendRequest("auth.google", map[string]string{"token": oauthToken}, func(ctx *neptulon.ResCtx) error {
+	_, err := c.conn.SendRequest("auth.google", c.authParams(oauthToken), func(ctx *neptulon.ResCtx) error {
 		var jwtToken map[string]string
 		if err := ctx.Result(&jwtToken); err != nil {
 			return fmt.Errorf("client: auth.google: error reading response: %v", err)
@@ -27,10 +28,90 @@ func (c *Client) GoogleAuth(oauthToken string, handler func(jwtToken string) err
 	return nil
 }
 
+// Register registers a new account with the given e-mail address and name. Server sends a verification code to the address,
+// which is exchanged for a JWT token with VerifyEmail.
+func (c *Client) Register(email, name string, handler func(ack string) error) error {
+	_, err := c.conn.SendRequest("auth.register", map[string]string{"email": email, "name": name}, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: auth.register: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: auth.register: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// VerifyEmail verifies the e-mail address of an account registered with Register using the code sent to the address,
+// and retrieves a JWT token along with a refresh token.
+// This also announces availability to the server, so server can start sending us pending messages.
+func (c *Client) VerifyEmail(email, code string, handler func(jwtToken, refreshToken string) error) error {
+	p := map[string]string{"email": email, "code": code}
+	if c.Device != "" {
+		p["device"] = c.Device
+	}
+	_, err := c.conn.SendRequest("auth.verifyEmail", p, func(ctx *neptulon.ResCtx) error {
+		var res map[string]interface{}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: auth.verifyEmail: error reading response: %v", err)
+		}
+		token, _ := res["token"].(string)
+		refreshToken, _ := res["refreshToken"].(string)
+		return handler(token, refreshToken)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: auth.verifyEmail: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// RefreshAuth exchanges the given long-lived refresh token (retrieved upon Google authentication) for a short-lived JWT token.
+func (c *Client) RefreshAuth(refreshToken string, handler func(jwtToken string, expires time.Time) error) error {
+	_, err := c.conn.SendRequest("auth.refresh", map[string]string{"token": refreshToken}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			Token   string    `json:"token"`
+			Expires time.Time `json:"expires"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: auth.refresh: error reading response: %v", err)
+		}
+		return handler(res.Token, res.Expires)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: auth.refresh: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeRefreshToken revokes the given refresh token along with all the JWT tokens issued with it.
+func (c *Client) RevokeRefreshToken(refreshToken string, handler func(ack string) error) error {
+	_, err := c.conn.SendRequest("auth.revoke", map[string]string{"token": refreshToken}, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: auth.revoke: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: auth.revoke: error sending request: %v", err)
+	}
+
+	return nil
+}
+
 // JWTAuth authenticates using the given JWT token.
 // This also announces availability to the server, so server can start sending us pending messages.
 func (c *Client) JWTAuth(jwtToken string, handler func(ack string) error) error {
-	_, err := c.conn.SendRequest("auth.jwt", map[string]string{"token": jwtToken}, func(ctx *neptulon.ResCtx) error {
+	_, err := c.conn.SendRequest("auth.jwt", c.authParams(jwtToken), func(ctx *neptulon.ResCtx) error {
 		var ack string
 		if err := ctx.Result(&ack); err != nil {
 			return fmt.Errorf("client: auth.jwt: error reading response: %v", err)
@@ -45,6 +126,124 @@ func (c *Client) JWTAuth(jwtToken string, handler func(ack string) error) error
 	return nil
 }
 
+// CertAuth announces availability to the server over a connection authenticated with a client certificate (see ConnectTLS),
+// so server can start sending us pending messages.
+func (c *Client) CertAuth(handler func(ack string) error) error {
+	_, err := c.conn.SendRequest("auth.cert", nil, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: auth.cert: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: auth.cert: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// EnrollCert retrieves a client certificate and private key for this device, issued by the server CA,
+// which can be used for authenticating without a JWT token. If device is empty, device name given upon authentication is used.
+func (c *Client) EnrollCert(device string, handler func(cert *models.DeviceCert) error) error {
+	_, err := c.conn.SendRequest("cert.enroll", map[string]string{"device": device}, func(ctx *neptulon.ResCtx) error {
+		var cert models.DeviceCert
+		if err := ctx.Result(&cert); err != nil {
+			return fmt.Errorf("client: cert.enroll: error reading response: %v", err)
+		}
+		return handler(&cert)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: cert.enroll: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// TOTPAuth completes the authentication with a TOTP or a recovery code, after JWTAuth or CertAuth is responded with TOTPRequired
+// for a user with two-factor authentication enabled. This also announces availability to the server, same as JWTAuth.
+// Response is a short-lived JWT token carrying the second factor, to be used with the attachment endpoints.
+func (c *Client) TOTPAuth(code string, handler func(jwtToken string, expires time.Time) error) error {
+	_, err := c.conn.SendRequest("auth.totp", map[string]string{"code": code}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			Token   string    `json:"token"`
+			Expires time.Time `json:"expires"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: auth.totp: error reading response: %v", err)
+		}
+		return handler(res.Token, res.Expires)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: auth.totp: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// EnrollTOTP retrieves a new TOTP secret to be added to an authenticator app, along with the one-time recovery codes.
+// Two-factor authentication is enabled once the enrollment is confirmed with ConfirmTOTP.
+func (c *Client) EnrollTOTP(handler func(e *models.TOTPEnrollment) error) error {
+	_, err := c.conn.SendRequest("totp.enroll", nil, func(ctx *neptulon.ResCtx) error {
+		var e models.TOTPEnrollment
+		if err := ctx.Result(&e); err != nil {
+			return fmt.Errorf("client: totp.enroll: error reading response: %v", err)
+		}
+		return handler(&e)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: totp.enroll: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// ConfirmTOTP confirms the TOTP enrollment with a code from the authenticator app, enabling two-factor authentication.
+func (c *Client) ConfirmTOTP(code string, handler func(ack string) error) error {
+	return c.sendAckRequest("totp.confirm", map[string]string{"code": code}, handler)
+}
+
+// DisableTOTP disables two-factor authentication, given a valid TOTP or recovery code.
+func (c *Client) DisableTOTP(code string, handler func(ack string) error) error {
+	return c.sendAckRequest("totp.disable", map[string]string{"code": code}, handler)
+}
+
+// ExchangeCaps offers the optional protocol features supported by this client to the server, which should be done right after connecting.
+// If the server accepts a codec (i.e. MessagePack), messages are encoded with it from then on, both ways, in place of JSON.
+// Otherwise, if the server accepts compression, messages larger than the threshold chosen by the server are compressed from then on.
+// Use neptulon.Codecs and neptulon.CompressionAlgorithms to offer everything supported by the client.
+func (c *Client) ExchangeCaps(offer models.Capabilities, handler func(caps *models.Capabilities) error) error {
+	_, err := c.conn.SendRequest("conn.caps", offer, func(ctx *neptulon.ResCtx) error {
+		var caps models.Capabilities
+		if err := ctx.Result(&caps); err != nil {
+			return fmt.Errorf("client: conn.caps: error reading response: %v", err)
+		}
+		if len(caps.Codecs) != 0 {
+			codec := neptulon.LookupCodec(caps.Codecs[0])
+			if codec == nil {
+				return fmt.Errorf("client: conn.caps: unsupported codec: %v", caps.Codecs[0])
+			}
+			c.conn.SetCodec(codec)
+		}
+		if len(caps.Compression) != 0 {
+			if err := c.conn.SetCompression(caps.Compression[0], caps.CompressionThreshold); err != nil {
+				return fmt.Errorf("client: conn.caps: %v", err)
+			}
+		}
+		return handler(&caps)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: conn.caps: error sending request: %v", err)
+	}
+
+	return nil
+}
+
 // SendMessages sends a batch of messages to the server.
 func (c *Client) SendMessages(m []models.Message, handler func(ack string) error) error {
 	_, err := c.conn.SendRequest("msg.send", m, func(ctx *neptulon.ResCtx) error {
@@ -62,20 +261,951 @@ func (c *Client) SendMessages(m []models.Message, handler func(ack string) error
 	return nil
 }
 
-// Echo sends a message to server echo endpoint.
-// This is meant to be used for testing connectivity.
-func (c *Client) Echo(m interface{}, msgHandler func(msg *models.Message) error) error {
-	_, err := c.conn.SendRequest("echo", m, func(ctx *neptulon.ResCtx) error {
-		var msg models.Message
-		if err := ctx.Result(&msg); err != nil {
-			return fmt.Errorf("client: echo: error reading response: %v", err)
+// SendBatch sends the given message to all the given recipients with a single request (i.e. when forwarding to many contacts).
+// Receipts of the sent messages are returned in the order of the recipients, which carry the server generated message IDs.
+func (c *Client) SendBatch(to []string, message string, handler func(receipts []models.Receipt) error) error {
+	_, err := c.conn.SendRequest("msg.sendBatch", map[string]interface{}{"to": to, "message": message}, func(ctx *neptulon.ResCtx) error {
+		var rs []models.Receipt
+		if err := ctx.Result(&rs); err != nil {
+			return fmt.Errorf("client: msg.sendBatch: error reading response: %v", err)
 		}
-		return msgHandler(&msg)
+		return handler(rs)
 	})
 
 	if err != nil {
-		return fmt.Errorf("client: echo: error sending request: %v", err)
+		return fmt.Errorf("client: msg.sendBatch: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// ReadMessages marks the messages with given IDs as read, so the senders are notified.
+func (c *Client) ReadMessages(ids []string, handler func(ack string) error) error {
+	_, err := c.conn.SendRequest("msg.read", ids, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: msg.read: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: msg.read: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// ReadConversation marks the messages received in a direct conversation up to and including the message with given ID as read,
+// so the senders are notified.
+func (c *Client) ReadConversation(conversation, id string, handler func(ack string) error) error {
+	params := map[string]interface{}{"watermarks": []map[string]string{{"conversation": conversation, "id": id}}}
+	_, err := c.conn.SendRequest("msg.read", params, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: msg.read: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: msg.read: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// MessageHistory retrieves the messages of a conversation, newest first, starting with the message before the cursor
+// or with the latest message if the cursor is empty. Handler receives the cursor for the next (older) batch of messages, if any.
+func (c *Client) MessageHistory(conversation, cursor string, limit int, handler func(msgs []models.Message, cursor string) error) error {
+	_, err := c.conn.SendRequest("msg.history", map[string]interface{}{"conversation": conversation, "cursor": cursor, "limit": limit}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			Messages []models.Message `json:"messages"`
+			Cursor   string           `json:"cursor"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: msg.history: error reading response: %v", err)
+		}
+		return handler(res.Messages, res.Cursor)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: msg.history: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// SearchMessages retrieves a page of the user's messages containing all the words of the given query, in the given conversation,
+// or in all conversations if the conversation is empty. Most relevant messages come first. Cursor works as with MessageHistory.
+func (c *Client) SearchMessages(query, conversation, cursor string, limit int, handler func(msgs []models.Message, cursor string) error) error {
+	_, err := c.conn.SendRequest("msg.search", map[string]interface{}{"query": query, "conversation": conversation, "cursor": cursor, "limit": limit}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			Messages []models.Message `json:"messages"`
+			Cursor   string           `json:"cursor"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: msg.search: error reading response: %v", err)
+		}
+		return handler(res.Messages, res.Cursor)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: msg.search: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// ExportConversation requests an export of the message history of a conversation in the given format (models.ExportJSON
+// or models.ExportZip), which is generated in the background. Handler is called with the ID of the export, and the export
+// is delivered to the handler registered with ExportHandler once it is ready.
+func (c *Client) ExportConversation(conversation, format string, handler func(e *models.Export) error) error {
+	_, err := c.conn.SendRequest("msg.export", map[string]string{"conversation": conversation, "format": format}, func(ctx *neptulon.ResCtx) error {
+		var e models.Export
+		if err := ctx.Result(&e); err != nil {
+			return fmt.Errorf("client: msg.export: error reading response: %v", err)
+		}
+		return handler(&e)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: msg.export: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// Typing notifies the other participants of a conversation who are online that the user started or stopped typing.
+// Started notifications should be repeated every few seconds while the user keeps typing.
+func (c *Client) Typing(conversation string, typing bool, handler func(ack string) error) error {
+	_, err := c.conn.SendRequest("msg.typing", models.Typing{Conversation: conversation, Typing: typing}, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: msg.typing: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: msg.typing: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// SubscribePresence subscribes to the presence updates of the given users and retrieves their current presence.
+// Only the contacts of the user who did not block the user can be subscribed to, and the rest are left out of the response.
+// Further updates are delivered to the handler registered with PresenceHandler.
+func (c *Client) SubscribePresence(userIDs []string, handler func(p []models.Presence) error) error {
+	_, err := c.conn.SendRequest("presence.sub", userIDs, func(ctx *neptulon.ResCtx) error {
+		var p []models.Presence
+		if err := ctx.Result(&p); err != nil {
+			return fmt.Errorf("client: presence.sub: error reading response: %v", err)
+		}
+		return handler(p)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: presence.sub: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// UploadKeys uploads the identity key and the signed prekey of the user along with a batch of one-time prekeys,
+// and retrieves the number of the one-time prekeys available.
+func (c *Client) UploadKeys(b *models.KeyBundle, handler func(preKeys int) error) error {
+	_, err := c.conn.SendRequest("keys.upload", b, func(ctx *neptulon.ResCtx) error {
+		var n int
+		if err := ctx.Result(&n); err != nil {
+			return fmt.Errorf("client: keys.upload: error reading response: %v", err)
+		}
+		return handler(n)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: keys.upload: error sending request: %v", err)
 	}
 
 	return nil
 }
+
+// CountPreKeys retrieves the number of the one-time prekeys left for the user.
+func (c *Client) CountPreKeys(handler func(preKeys int) error) error {
+	_, err := c.conn.SendRequest("keys.count", nil, func(ctx *neptulon.ResCtx) error {
+		var n int
+		if err := ctx.Result(&n); err != nil {
+			return fmt.Errorf("client: keys.count: error reading response: %v", err)
+		}
+		return handler(n)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: keys.count: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// GetKeys retrieves the key bundle of a user, with at most one of the user's one-time prekeys, to establish an end-to-end encrypted session.
+func (c *Client) GetKeys(userID string, handler func(b *models.KeyBundle) error) error {
+	_, err := c.conn.SendRequest("keys.get", map[string]string{"userid": userID}, func(ctx *neptulon.ResCtx) error {
+		var b models.KeyBundle
+		if err := ctx.Result(&b); err != nil {
+			return fmt.Errorf("client: keys.get: error reading response: %v", err)
+		}
+		return handler(&b)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: keys.get: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// attachmentRes is the response of the attachment routes, carrying the URL to upload or download the content at.
+type attachmentRes struct {
+	models.AttachmentRef
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// RequestAttachmentUpload requests a slot to upload an attachment with the given size, MIME type, and hex encoded SHA-256 checksum,
+// and retrieves the attachment reference to send with the messages along with the URL to upload the content at with an HTTP PUT request,
+// and the headers to send with the request. Absolute URLs are presigned object storage URLs, and such uploads should be completed
+// with CompleteAttachmentUpload.
+func (c *Client) RequestAttachmentUpload(size int64, mimeType, checksum string, handler func(a *models.AttachmentRef, url string, headers map[string]string) error) error {
+	return c.sendAttachmentRequest("attachment.upload", map[string]interface{}{"size": size, "mimeType": mimeType, "checksum": checksum}, handler)
+}
+
+// CompleteAttachmentUpload completes an upload made with a presigned object storage URL, after which the attachment can be sent.
+func (c *Client) CompleteAttachmentUpload(id string, handler func(ack string) error) error {
+	return c.sendAttachmentAckRequest("attachment.complete", id, handler)
+}
+
+// GetAttachment retrieves an attachment received with a message, along with the URL to download the content at with an HTTP GET request.
+func (c *Client) GetAttachment(id string, handler func(a *models.AttachmentRef, url string) error) error {
+	return c.sendAttachmentRequest("attachment.get", map[string]string{"id": id}, func(a *models.AttachmentRef, url string, headers map[string]string) error {
+		return handler(a, url)
+	})
+}
+
+// DeleteAttachment deletes an attachment uploaded by the user, freeing up the storage quota.
+func (c *Client) DeleteAttachment(id string, handler func(ack string) error) error {
+	return c.sendAttachmentAckRequest("attachment.delete", id, handler)
+}
+
+func (c *Client) sendAttachmentAckRequest(method, id string, handler func(ack string) error) error {
+	_, err := c.conn.SendRequest(method, map[string]string{"id": id}, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: %v: error reading response: %v", method, err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: %v: error sending request: %v", method, err)
+	}
+
+	return nil
+}
+
+func (c *Client) sendAttachmentRequest(method string, params interface{}, handler func(a *models.AttachmentRef, url string, headers map[string]string) error) error {
+	_, err := c.conn.SendRequest(method, params, func(ctx *neptulon.ResCtx) error {
+		var res attachmentRes
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: %v: error reading response: %v", method, err)
+		}
+		return handler(&res.AttachmentRef, res.URL, res.Headers)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: %v: error sending request: %v", method, err)
+	}
+
+	return nil
+}
+
+// ListSessions retrieves the live connections of the user on all the devices, with the connection the request is made through marked as current.
+func (c *Client) ListSessions(handler func(conns []models.Conn) error) error {
+	_, err := c.conn.SendRequest("session.list", nil, func(ctx *neptulon.ResCtx) error {
+		var conns []models.Conn
+		if err := ctx.Result(&conns); err != nil {
+			return fmt.Errorf("client: session.list: error reading response: %v", err)
+		}
+		return handler(conns)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: session.list: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeSession force-logs out a device of the user, given either by the ID of one of its connections or by its name, by revoking its
+// refresh tokens and closing its connections, except the one the request is made through. Handler receives the number of the revoked
+// tokens and the closed connections.
+func (c *Client) RevokeSession(connID, device string, handler func(revoked, closed int) error) error {
+	_, err := c.conn.SendRequest("session.revoke", map[string]string{"id": connID, "device": device}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			Revoked int `json:"revoked"`
+			Closed  int `json:"closed"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: session.revoke: error reading response: %v", err)
+		}
+		return handler(res.Revoked, res.Closed)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: session.revoke: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteAccount schedules the account of the user to be deleted after the grace period, which revokes the tokens of the user
+// and closes the connections of all the devices, including this one right after the response. Handler receives the time
+// the data of the account is deleted at. Signing in again within the grace period cancels the deletion.
+func (c *Client) DeleteAccount(handler func(deleteAt time.Time) error) error {
+	_, err := c.conn.SendRequest("account.delete", nil, func(ctx *neptulon.ResCtx) error {
+		var res models.AccountDeletion
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: account.delete: error reading response: %v", err)
+		}
+		return handler(res.DeleteAt)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: account.delete: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// CreateGroup creates a new group conversation with the given name and members, and retrieves the created group.
+func (c *Client) CreateGroup(name string, members []string, handler func(g *models.Group) error) error {
+	return c.sendGroupRequest("group.create", map[string]interface{}{"name": name, "members": members}, handler)
+}
+
+// AddGroupMembers adds new members to a group and retrieves the updated group.
+func (c *Client) AddGroupMembers(groupID string, members []string, handler func(g *models.Group) error) error {
+	return c.sendGroupRequest("group.add", map[string]interface{}{"id": groupID, "members": members}, handler)
+}
+
+// ListGroupMembers retrieves a page of the members of a group, starting with the page denoted by the cursor,
+// or with the first page if the cursor is empty. Handler receives the cursor for the next page, if any.
+func (c *Client) ListGroupMembers(groupID, cursor string, limit int, handler func(members []string, cursor string) error) error {
+	_, err := c.conn.SendRequest("group.members", map[string]interface{}{"id": groupID, "cursor": cursor, "limit": limit}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			Members []string `json:"members"`
+			Cursor  string   `json:"cursor"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: group.members: error reading response: %v", err)
+		}
+		return handler(res.Members, res.Cursor)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: group.members: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// LeaveGroup removes the current user from the members of a group.
+func (c *Client) LeaveGroup(groupID string, handler func(ack string) error) error {
+	_, err := c.conn.SendRequest("group.leave", map[string]string{"id": groupID}, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: group.leave: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: group.leave: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// SendGroupMessage sends a message to all the other members of a group.
+func (c *Client) SendGroupMessage(groupID string, message string, handler func(ack string) error) error {
+	_, err := c.conn.SendRequest("group.send", map[string]string{"id": groupID, "message": message}, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: group.send: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: group.send: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+func (c *Client) sendGroupRequest(method string, params interface{}, handler func(g *models.Group) error) error {
+	_, err := c.conn.SendRequest(method, params, func(ctx *neptulon.ResCtx) error {
+		var g models.Group
+		if err := ctx.Result(&g); err != nil {
+			return fmt.Errorf("client: %v: error reading response: %v", method, err)
+		}
+		return handler(&g)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: %v: error sending request: %v", method, err)
+	}
+
+	return nil
+}
+
+// AddContact adds a registered user to the contacts of the user.
+func (c *Client) AddContact(userID string, handler func(ack string) error) error {
+	return c.sendAckRequest("contact.add", map[string]string{"userid": userID}, handler)
+}
+
+// RemoveContact removes a user from the contacts of the user.
+func (c *Client) RemoveContact(userID string, handler func(ack string) error) error {
+	return c.sendAckRequest("contact.remove", map[string]string{"userid": userID}, handler)
+}
+
+// ListContacts retrieves a page of the IDs of the contacts of the user, starting with the page denoted by the cursor,
+// or with the first page if the cursor is empty. Handler receives the cursor for the next page, if any.
+func (c *Client) ListContacts(cursor string, limit int, handler func(userIDs []string, cursor string) error) error {
+	_, err := c.conn.SendRequest("contact.list", map[string]interface{}{"cursor": cursor, "limit": limit}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			Contacts []string `json:"contacts"`
+			Cursor   string   `json:"cursor"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: contact.list: error reading response: %v", err)
+		}
+		return handler(res.Contacts, res.Cursor)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: contact.list: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// DiscoverContacts looks up the registered users among the given phone numbers, i.e. from the address book of the user,
+// and retrieves the IDs of the users found, keyed by their phone numbers as given.
+func (c *Client) DiscoverContacts(phones []string, handler func(userIDs map[string]string) error) error {
+	_, err := c.conn.SendRequest("contact.discover", map[string][]string{"phones": phones}, func(ctx *neptulon.ResCtx) error {
+		var res []struct {
+			Phone  string `json:"phone"`
+			UserID string `json:"userid"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: contact.discover: error reading response: %v", err)
+		}
+		ids := make(map[string]string, len(res))
+		for _, r := range res {
+			ids[r.Phone] = r.UserID
+		}
+		return handler(ids)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: contact.discover: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// RegisterPhone requests a verification code to be sent to the given phone number in international format in a text message.
+func (c *Client) RegisterPhone(phone string, handler func(ack string) error) error {
+	return c.sendAckRequest("phone.register", map[string]string{"phone": phone}, handler)
+}
+
+// VerifyPhone verifies a phone number with the code sent by RegisterPhone, binding the number to the account of the user
+// so the user can be discovered by it.
+func (c *Client) VerifyPhone(phone, code string, handler func(ack string) error) error {
+	return c.sendAckRequest("phone.verify", map[string]string{"phone": phone, "code": code}, handler)
+}
+
+// Block blocks a user, so the messages sent by the user to this user are dropped silently.
+func (c *Client) Block(userID string, handler func(ack string) error) error {
+	return c.sendAckRequest("block.add", map[string]string{"userid": userID}, handler)
+}
+
+// Unblock unblocks a user.
+func (c *Client) Unblock(userID string, handler func(ack string) error) error {
+	return c.sendAckRequest("block.remove", map[string]string{"userid": userID}, handler)
+}
+
+// ListBlocked retrieves the IDs of the users blocked by the user.
+func (c *Client) ListBlocked(handler func(userIDs []string) error) error {
+	return c.sendListRequest("block.list", handler)
+}
+
+// Mute mutes a conversation, so no push notifications are sent for its messages.
+func (c *Client) Mute(conversation string, handler func(ack string) error) error {
+	return c.sendAckRequest("mute.add", map[string]string{"conversation": conversation}, handler)
+}
+
+// Unmute unmutes a conversation.
+func (c *Client) Unmute(conversation string, handler func(ack string) error) error {
+	return c.sendAckRequest("mute.remove", map[string]string{"conversation": conversation}, handler)
+}
+
+// ListMuted retrieves the IDs of the conversations muted by the user.
+func (c *Client) ListMuted(handler func(conversations []string) error) error {
+	return c.sendListRequest("mute.list", handler)
+}
+
+// SetProfile replaces the profile of the user with the given one and retrieves the saved profile.
+// Users who have this user in their contacts are notified of the new profile.
+func (c *Client) SetProfile(p *models.Profile, handler func(p *models.Profile) error) error {
+	_, err := c.conn.SendRequest("profile.set", p, func(ctx *neptulon.ResCtx) error {
+		var p models.Profile
+		if err := ctx.Result(&p); err != nil {
+			return fmt.Errorf("client: profile.set: error reading response: %v", err)
+		}
+		return handler(&p)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: profile.set: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// GetProfiles retrieves the profiles of the given users. Only the profiles of the user and the user's contacts are retrieved.
+func (c *Client) GetProfiles(userIDs []string, handler func(ps []models.Profile) error) error {
+	_, err := c.conn.SendRequest("profile.get", userIDs, func(ctx *neptulon.ResCtx) error {
+		var ps []models.Profile
+		if err := ctx.Result(&ps); err != nil {
+			return fmt.Errorf("client: profile.get: error reading response: %v", err)
+		}
+		return handler(ps)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: profile.get: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// SubscribeTopic subscribes to a topic, so the messages published to the topic are received from then on.
+func (c *Client) SubscribeTopic(name string, handler func(ack string) error) error {
+	return c.sendAckRequest("topic.subscribe", map[string]string{"name": name}, handler)
+}
+
+// UnsubscribeTopic unsubscribes from a topic.
+func (c *Client) UnsubscribeTopic(name string, handler func(ack string) error) error {
+	return c.sendAckRequest("topic.unsubscribe", map[string]string{"name": name}, handler)
+}
+
+// PublishTopic publishes a message to all the other subscribers of a topic. Only the subscribers of a topic and the admins can publish to it.
+func (c *Client) PublishTopic(name, message string, handler func(ack string) error) error {
+	return c.sendAckRequest("topic.publish", map[string]string{"name": name, "message": message}, handler)
+}
+
+// ListTopics retrieves the topics the user is subscribed to.
+func (c *Client) ListTopics(handler func(ts []models.Topic) error) error {
+	_, err := c.conn.SendRequest("topic.list", nil, func(ctx *neptulon.ResCtx) error {
+		var ts []models.Topic
+		if err := ctx.Result(&ts); err != nil {
+			return fmt.Errorf("client: topic.list: error reading response: %v", err)
+		}
+		return handler(ts)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: topic.list: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// CreateTopic creates a topic, or updates the description of an existing one, and retrieves the topic.
+// Only the users with admin role can make this call.
+func (c *Client) CreateTopic(name, description string, handler func(t *models.Topic) error) error {
+	_, err := c.conn.SendRequest("admin.topic.create", map[string]string{"name": name, "description": description}, func(ctx *neptulon.ResCtx) error {
+		var t models.Topic
+		if err := ctx.Result(&t); err != nil {
+			return fmt.Errorf("client: admin.topic.create: error reading response: %v", err)
+		}
+		return handler(&t)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.topic.create: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+func (c *Client) sendListRequest(method string, handler func(ids []string) error) error {
+	_, err := c.conn.SendRequest(method, nil, func(ctx *neptulon.ResCtx) error {
+		var ids []string
+		if err := ctx.Result(&ids); err != nil {
+			return fmt.Errorf("client: %v: error reading response: %v", method, err)
+		}
+		return handler(ids)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: %v: error sending request: %v", method, err)
+	}
+
+	return nil
+}
+
+func (c *Client) sendAckRequest(method string, params interface{}, handler func(ack string) error) error {
+	_, err := c.conn.SendRequest(method, params, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: %v: error reading response: %v", method, err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: %v: error sending request: %v", method, err)
+	}
+
+	return nil
+}
+
+// RotateJWTKey rotates the server's JWT signing key with the given key. Key is not persisted by the server, so it should also be
+// set as the signing password of the servers, along with the previous key in the previous passwords.
+// Only the users with admin role can make this call.
+func (c *Client) RotateJWTKey(key string, handler func(ack string) error) error {
+	_, err := c.conn.SendRequest("admin.jwt.rotate", map[string]string{"key": key}, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: admin.jwt.rotate: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.jwt.rotate: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// ListConns retrieves the live connections of all the online users.
+// Only the users with moderator or admin role can make this call.
+func (c *Client) ListConns(handler func(conns []models.Conn) error) error {
+	_, err := c.conn.SendRequest("admin.users", nil, func(ctx *neptulon.ResCtx) error {
+		var conns []models.Conn
+		if err := ctx.Result(&conns); err != nil {
+			return fmt.Errorf("client: admin.users: error reading response: %v", err)
+		}
+		return handler(conns)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.users: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// QueueDepth retrieves the number of requests waiting to be delivered to the given user.
+// Only the users with admin role can make this call.
+func (c *Client) QueueDepth(userID string, handler func(depth int) error) error {
+	_, err := c.conn.SendRequest("admin.queue", map[string]string{"userid": userID}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			Depth int `json:"depth"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: admin.queue: error reading response: %v", err)
+		}
+		return handler(res.Depth)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.queue: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// Disconnect closes the live connection with the given ID.
+// Only the users with moderator or admin role can make this call.
+func (c *Client) Disconnect(connID string, handler func(ack string) error) error {
+	_, err := c.conn.SendRequest("admin.disconnect", map[string]string{"id": connID}, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: admin.disconnect: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.disconnect: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// Kick closes all the live connections of a user. If purge is set, the requests waiting to be delivered to the user are dead-lettered as well.
+// Handler receives the number of the closed connections and the purged requests. Only the users with moderator or admin role can make this call.
+func (c *Client) Kick(userID string, purge bool, handler func(closed, purged int) error) error {
+	_, err := c.conn.SendRequest("admin.kick", map[string]interface{}{"userid": userID, "purge": purge}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			Closed int `json:"closed"`
+			Purged int `json:"purged"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: admin.kick: error reading response: %v", err)
+		}
+		return handler(res.Closed, res.Purged)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.kick: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// Broadcast sends a system notice to all the online users.
+// Only the users with admin role can make this call.
+func (c *Client) Broadcast(message string, handler func(ack string) error) error {
+	_, err := c.conn.SendRequest("admin.broadcast", map[string]string{"message": message}, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: admin.broadcast: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.broadcast: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// BroadcastTo sends a system notice to the given users whether they are online or not, or to all the registered users if userIDs is nil.
+// Notices that cannot be delivered within the given TTL are dead-lettered, and zero TTL means they never expire.
+// Only the users with admin role can make this call.
+func (c *Client) BroadcastTo(userIDs []string, message string, ttl time.Duration, handler func(ack string) error) error {
+	req := map[string]interface{}{"message": message, "userids": userIDs, "all": userIDs == nil}
+	if ttl > 0 {
+		req["ttl"] = ttl.String()
+	}
+
+	_, err := c.conn.SendRequest("admin.broadcast", req, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: admin.broadcast: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.broadcast: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// Stats retrieves the connection, user, and queue size metrics of the server.
+// Only the users with admin role can make this call.
+func (c *Client) Stats(handler func(s *models.Stats) error) error {
+	_, err := c.conn.SendRequest("admin.stats", nil, func(ctx *neptulon.ResCtx) error {
+		var s models.Stats
+		if err := ctx.Result(&s); err != nil {
+			return fmt.Errorf("client: admin.stats: error reading response: %v", err)
+		}
+		return handler(&s)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.stats: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// ListDeadLetters retrieves a page of the requests that could not be delivered to the given user, or to any user if the user ID is empty,
+// starting with the page denoted by the cursor, or with the first page if the cursor is empty. Handler receives the cursor for the next page, if any.
+func (c *Client) ListDeadLetters(userID, cursor string, limit int, handler func(dls []models.DeadLetter, cursor string) error) error {
+	_, err := c.conn.SendRequest("admin.deadletters", map[string]interface{}{"userid": userID, "cursor": cursor, "limit": limit}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			DeadLetters []models.DeadLetter `json:"deadLetters"`
+			Cursor      string              `json:"cursor"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: admin.deadletters: error reading response: %v", err)
+		}
+		return handler(res.DeadLetters, res.Cursor)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.deadletters: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// ListWebhookDeliveries retrieves a page of the recent deliveries of the server events to the given outgoing webhook, or to any webhook
+// if the URL is empty, starting with the page denoted by the cursor, or with the first page if the cursor is empty. Handler receives the cursor
+// for the next page, if any.
+func (c *Client) ListWebhookDeliveries(url, cursor string, limit int, handler func(ds []models.WebhookDelivery, cursor string) error) error {
+	_, err := c.conn.SendRequest("admin.webhooks", map[string]interface{}{"url": url, "cursor": cursor, "limit": limit}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			Deliveries []models.WebhookDelivery `json:"deliveries"`
+			Cursor     string                   `json:"cursor"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: admin.webhooks: error reading response: %v", err)
+		}
+		return handler(res.Deliveries, res.Cursor)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.webhooks: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// Redrive puts the dead-lettered request with the given ID back in the recipient's queue to be delivered again.
+func (c *Client) Redrive(id string, handler func(ack string) error) error {
+	_, err := c.conn.SendRequest("admin.redrive", map[string]string{"id": id}, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: admin.redrive: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.redrive: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeDevice revokes the refresh tokens issued to a device of a user, along with the access tokens issued with them, and closes the
+// live connections of the device. If device is empty, all the devices of the user are revoked. Handler receives the number of the
+// revoked tokens and the closed connections. Only the users with admin role can make this call.
+func (c *Client) RevokeDevice(userID, device string, handler func(revoked, closed int) error) error {
+	_, err := c.conn.SendRequest("admin.revoke", map[string]string{"userid": userID, "device": device}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			Revoked int `json:"revoked"`
+			Closed  int `json:"closed"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: admin.revoke: error reading response: %v", err)
+		}
+		return handler(res.Revoked, res.Closed)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.revoke: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// SetRole sets the role of a user to one of "user", "moderator", or "admin", revoking the refresh tokens and closing the live connections
+// of the user so the role is issued in the new tokens. Handler receives the number of the revoked tokens and the closed connections.
+// Only the users with admin role can make this call.
+func (c *Client) SetRole(userID, role string, handler func(revoked, closed int) error) error {
+	_, err := c.conn.SendRequest("admin.role", map[string]string{"userid": userID, "role": role}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			Revoked int `json:"revoked"`
+			Closed  int `json:"closed"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: admin.role: error reading response: %v", err)
+		}
+		return handler(res.Revoked, res.Closed)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.role: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// Drain gracefully drains the server: new clients are refused and the connected ones are sent the given system notice, if any,
+// and disconnected evenly over the given period. Only the users with admin role can make this call.
+func (c *Client) Drain(period time.Duration, message string, handler func(ack string) error) error {
+	_, err := c.conn.SendRequest("admin.drain", map[string]string{"period": period.String(), "message": message}, func(ctx *neptulon.ResCtx) error {
+		var ack string
+		if err := ctx.Result(&ack); err != nil {
+			return fmt.Errorf("client: admin.drain: error reading response: %v", err)
+		}
+		return handler(ack)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.drain: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// QueryAudit retrieves a page of the audit log entries, newest first, of the actions performed by or on the given user, or of all users
+// if the user ID is empty, with the given action, if any, recorded since the given time, starting with the page denoted by the cursor,
+// or with the first page if the cursor is empty. Handler receives the cursor for the next page, if any. Only the users with admin
+// role can make this call.
+func (c *Client) QueryAudit(userID, action string, since time.Time, cursor string, limit int, handler func(es []models.AuditEntry, cursor string) error) error {
+	_, err := c.conn.SendRequest("admin.audit", map[string]interface{}{"userid": userID, "action": action, "since": since, "cursor": cursor, "limit": limit}, func(ctx *neptulon.ResCtx) error {
+		var res struct {
+			Entries []models.AuditEntry `json:"entries"`
+			Cursor  string              `json:"cursor"`
+		}
+		if err := ctx.Result(&res); err != nil {
+			return fmt.Errorf("client: admin.audit: error reading response: %v", err)
+		}
+		return handler(res.Entries, res.Cursor)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: admin.audit: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// Echo sends a message to server echo endpoint.
+// This is meant to be used for testing connectivity.
+func (c *Client) Echo(m interface{}, msgHandler func(msg *models.Message) error) error {
+	_, err := c.conn.SendRequest("echo", m, func(ctx *neptulon.ResCtx) error {
+		var msg models.Message
+		if err := ctx.Result(&msg); err != nil {
+			return fmt.Errorf("client: echo: error reading response: %v", err)
+		}
+		return msgHandler(&msg)
+	})
+
+	if err != nil {
+		return fmt.Errorf("client: echo: error sending request: %v", err)
+	}
+
+	return nil
+}
+
+// authParams creates the authentication request params with the given token and the device name, if any.
+func (c *Client) authParams(token string) map[string]string {
+	p := map[string]string{"token": token}
+	if c.Device != "" {
+		p["device"] = c.Device
+	}
+	return p
+}
@@ -0,0 +1,107 @@
+package cmap
+
+import "sync"
+
+// DefaultShards is the default number of shards for a sharded map.
+const DefaultShards = 64
+
+// ShardedMap is a thread-safe map with string keys, split into independently locked shards
+// so that concurrent access to different keys rarely contends for the same lock.
+type ShardedMap struct {
+	shards []shard
+}
+
+type shard struct {
+	items map[string]interface{}
+	mutex sync.RWMutex
+}
+
+// NewSharded creates and returns a new thread-safe map with the given number of shards.
+// If shards is not positive, DefaultShards is used.
+func NewSharded(shards int) *ShardedMap {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+
+	m := ShardedMap{shards: make([]shard, shards)}
+	for i := range m.shards {
+		m.shards[i].items = make(map[string]interface{})
+	}
+	return &m
+}
+
+// shard returns the shard for the given key using FNV-1a hash of the key, computed inline to avoid allocations.
+func (m *ShardedMap) shard(key string) *shard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &m.shards[h%uint32(len(m.shards))]
+}
+
+// Get retrieves a value for a given key.
+func (m *ShardedMap) Get(key string) (val interface{}) {
+	val, _ = m.GetOk(key)
+	return
+}
+
+// GetOk retrieves a value for a given key.
+// An 'ok' flag is also returned indicating whether a value exists for the given key.
+func (m *ShardedMap) GetOk(key string) (val interface{}, ok bool) {
+	s := m.shard(key)
+	s.mutex.RLock()
+	val, ok = s.items[key]
+	s.mutex.RUnlock()
+	return
+}
+
+// Set stores a value for a given key.
+func (m *ShardedMap) Set(key string, val interface{}) {
+	s := m.shard(key)
+	s.mutex.Lock()
+	s.items[key] = val
+	s.mutex.Unlock()
+}
+
+// Delete removes a value for a given key.
+func (m *ShardedMap) Delete(key string) {
+	s := m.shard(key)
+	s.mutex.Lock()
+	delete(s.items, key)
+	s.mutex.Unlock()
+}
+
+// Range iterates over the entire collection and executes given function on each item.
+// Shards are locked one at a time so the iteration is not an atomic snapshot of the entire map.
+func (m *ShardedMap) Range(fn func(val interface{})) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mutex.RLock()
+		for _, item := range s.items {
+			fn(item)
+		}
+		s.mutex.RUnlock()
+	}
+}
+
+// Len returns the item count.
+func (m *ShardedMap) Len() int {
+	n := 0
+	for _, l := range m.ShardLens() {
+		n += l
+	}
+	return n
+}
+
+// ShardLens returns the item count of each shard, which can be used to monitor the key distribution.
+func (m *ShardedMap) ShardLens() []int {
+	lens := make([]int, len(m.shards))
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mutex.RLock()
+		lens[i] = len(s.items)
+		s.mutex.RUnlock()
+	}
+	return lens
+}
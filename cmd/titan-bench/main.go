@@ -0,0 +1,421 @@
+// Command titan-bench is a load testing tool for Titan servers. It connects N synthetic clients, authenticates them with
+// JWT tokens signed with the server's JWT secret, sends M messages from each client to the next one at a target rate,
+// and reports the send (msg.send response) and delivery (msg.recv) latency percentiles along with the error rates.
+//
+//	titan-bench -addr wss://titan.example.com:3000 -secret <JWT secret> -clients 100 -messages 50 -rate 500
+//
+// Server should be started with RATE_LIMIT_MESSAGES=-1 (or a large enough limit), otherwise the messages above the per user
+// limit are rejected and reported as errors. If an admin token is given, admin.stats of the server is fetched before and after
+// the run to report the server-side resource usage (connections, queue length, goroutines, memory, and GC).
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+var (
+	addrFlag       = flag.String("addr", "ws://127.0.0.1:3000", "Address of the Titan server: ws://host:port or wss://host:port")
+	secretFlag     = flag.String("secret", os.Getenv("TITAN_JWT_SECRET"), "JWT secret of the server to sign the tokens of the synthetic users with. Defaults to TITAN_JWT_SECRET environment variable.")
+	adminTokenFlag = flag.String("admin-token", "", "Optional JWT token with the admin role to fetch the server stats before and after the run.")
+	clientsFlag    = flag.Int("clients", 10, "Number of concurrent clients.")
+	messagesFlag   = flag.Int("messages", 10, "Number of messages to be sent by each client.")
+	rateFlag       = flag.Float64("rate", 100, "Target rate of sent messages per second, across all clients. Zero sends as fast as possible.")
+	prefixFlag     = flag.String("prefix", "bench-", "Prefix of the synthetic user IDs.")
+	caFlag         = flag.String("ca", "", "PEM encoded CA certificate file to trust for wss:// connections, in addition to the system roots.")
+	insecureFlag   = flag.Bool("insecure", false, "Skip verifying the server certificate for wss:// connections.")
+	timeoutFlag    = flag.Duration("timeout", 10*time.Second, "Time to wait for the responses and the delivery of the messages.")
+)
+
+func main() {
+	flag.Parse()
+	if *secretFlag == "" {
+		fatalf("JWT secret is required to authenticate the synthetic clients, use -secret flag or TITAN_JWT_SECRET environment variable")
+	}
+	if *clientsFlag < 2 || *messagesFlag < 1 {
+		fatalf("at least 2 clients and 1 message per client are required")
+	}
+
+	tlsConf, err := tlsConfig()
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	before := stats(tlsConf)
+
+	b := newBench(*clientsFlag * *messagesFlag)
+	start := time.Now()
+	if err := b.connect(*clientsFlag, tlsConf); err != nil {
+		b.close()
+		fatalf("%v", err)
+	}
+	fmt.Printf("connected and authenticated %v clients in %v\n", *clientsFlag, time.Since(start).Round(time.Millisecond))
+
+	start = time.Now()
+	b.run(*messagesFlag, *rateFlag)
+	sent := time.Since(start)
+	b.wait(*timeoutFlag)
+	elapsed := time.Since(start)
+	b.close()
+
+	b.report(sent, elapsed)
+	if before != nil {
+		if after := stats(tlsConf); after != nil {
+			reportStats(before, after)
+		}
+	}
+}
+
+// tlsConfig creates the TLS configuration for wss:// connections as given by the flags, or nil for the defaults.
+func tlsConfig() (*tls.Config, error) {
+	if *caFlag == "" && !*insecureFlag {
+		return nil, nil
+	}
+
+	conf := &tls.Config{InsecureSkipVerify: *insecureFlag}
+	if *caFlag != "" {
+		pem, err := ioutil.ReadFile(*caFlag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA certificate file: %v", *caFlag)
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}
+
+func signToken(userID string) (string, error) {
+	t := jwt.New(jwt.SigningMethodHS256)
+	t.Claims["userid"] = userID
+	t.Claims["created"] = time.Now().Unix()
+	return t.SignedString([]byte(*secretFlag))
+}
+
+type bench struct {
+	clients []*client.Client
+	ids     []string
+	runID   string // ID of the run, so the messages left in the queue by the previous runs are not mixed up
+
+	mutex    sync.Mutex
+	sentAt   map[string]time.Time // message body -> time sent
+	sendLat  []time.Duration      // msg.send response latencies
+	recvLat  []time.Duration      // msg.recv delivery latencies
+	sendErrs map[string]int       // error message -> count
+	received int64
+	timeouts int64
+	pending  sync.WaitGroup // outstanding msg.send responses
+	done     chan struct{}  // closed once all messages are received
+	total    int64
+}
+
+func newBench(total int) *bench {
+	return &bench{
+		runID:    strconv.FormatInt(time.Now().UnixNano(), 36),
+		sentAt:   make(map[string]time.Time, total),
+		sendErrs: make(map[string]int),
+		done:     make(chan struct{}),
+		total:    int64(total),
+	}
+}
+
+// connect connects and authenticates the clients concurrently.
+func (b *bench) connect(n int, tlsConf *tls.Config) error {
+	b.clients = make([]*client.Client, n)
+	b.ids = make([]string, n)
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		b.ids[i] = *prefixFlag + strconv.Itoa(i+1)
+		c, err := client.NewClient()
+		if err != nil {
+			return fmt.Errorf("failed to create client: %v", err)
+		}
+		c.Device = "bench"
+		c.InMsgHandler(b.onMessages)
+		c.ReceiptHandler(func(r []models.Receipt) error { return nil }) // ack the receipts so they are not redelivered
+		b.clients[i] = c
+
+		wg.Add(1)
+		go func(c *client.Client, userID string) {
+			defer wg.Done()
+			errs <- b.auth(c, userID, tlsConf)
+		}(c, b.ids[i])
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *bench) auth(c *client.Client, userID string, tlsConf *tls.Config) error {
+	token, err := signToken(userID)
+	if err != nil {
+		return fmt.Errorf("failed to sign token for %v: %v", userID, err)
+	}
+	if err := c.ConnectTLS(*addrFlag, tlsConf); err != nil {
+		return fmt.Errorf("failed to connect %v to %v: %v", userID, *addrFlag, err)
+	}
+
+	acked := make(chan struct{})
+	if err := c.JWTAuth(token, func(ack string) error {
+		close(acked)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to authenticate %v: %v", userID, err)
+	}
+	select {
+	case <-acked:
+		return nil
+	case <-time.After(*timeoutFlag):
+		return fmt.Errorf("authentication of %v timed out", userID)
+	}
+}
+
+// run sends the messages in rounds, each client sending to the next one, paced at the given rate.
+func (b *bench) run(messages int, rate float64) {
+	var tick <-chan time.Time
+	if rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer t.Stop()
+		tick = t.C
+	}
+
+	seq := 0
+	for m := 0; m < messages; m++ {
+		for i, c := range b.clients {
+			if tick != nil {
+				<-tick
+			}
+			seq++
+			b.send(c, b.ids[(i+1)%len(b.ids)], seq)
+		}
+	}
+}
+
+func (b *bench) send(c *client.Client, to string, seq int) {
+	body := "bench:" + b.runID + ":" + strconv.Itoa(seq)
+	start := time.Now()
+	b.mutex.Lock()
+	b.sentAt[body] = start
+	b.mutex.Unlock()
+
+	res := make(chan struct{})
+	b.pending.Add(1)
+	err := c.SendRequest("msg.send", []models.Message{{To: to, Message: body, ClientID: body}}, func(ctx *neptulon.ResCtx) error {
+		close(res)
+		if !ctx.Success {
+			b.failed(body, ctx.ErrorMessage)
+			return nil
+		}
+		b.mutex.Lock()
+		b.sendLat = append(b.sendLat, time.Since(start))
+		b.mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		close(res)
+		b.failed(body, err.Error())
+	}
+
+	go func() {
+		defer b.pending.Done()
+		select {
+		case <-res:
+		case <-time.After(*timeoutFlag):
+			atomic.AddInt64(&b.timeouts, 1)
+		}
+	}()
+}
+
+// failed records a failed msg.send. Messages which are never sent are not waited for.
+func (b *bench) failed(body, reason string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.sentAt, body)
+	b.sendErrs[reason]++
+	b.total--
+	if atomic.LoadInt64(&b.received) >= b.total {
+		b.closeDone()
+	}
+}
+
+func (b *bench) onMessages(msgs []models.Message) error {
+	now := time.Now()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, m := range msgs {
+		sent, ok := b.sentAt[m.Message]
+		if !ok {
+			continue // i.e. a message queued by a previous run
+		}
+		delete(b.sentAt, m.Message)
+		b.recvLat = append(b.recvLat, now.Sub(sent))
+		if atomic.AddInt64(&b.received, 1) >= b.total {
+			b.closeDone()
+		}
+	}
+	return nil
+}
+
+func (b *bench) closeDone() {
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+}
+
+// wait waits for the outstanding responses, and for the delivery of the messages until the timeout.
+func (b *bench) wait(timeout time.Duration) {
+	b.pending.Wait()
+	select {
+	case <-b.done:
+	case <-time.After(timeout):
+	}
+}
+
+func (b *bench) close() {
+	for _, c := range b.clients {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
+func (b *bench) report(sent, elapsed time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	attempted := *clientsFlag * *messagesFlag
+	errs := 0
+	for _, n := range b.sendErrs {
+		errs += n
+	}
+	fmt.Printf("\nsent %v messages in %v (%.1f msg/s), finished in %v\n", attempted, sent.Round(time.Millisecond), float64(attempted)/sent.Seconds(), elapsed.Round(time.Millisecond))
+	fmt.Printf("send:     %v ok, %v errors (%.2f%%), %v timeouts\n", len(b.sendLat), errs, percent(errs, attempted), b.timeouts)
+	fmt.Printf("delivery: %v received, %v lost (%.2f%%)\n", b.received, len(b.sentAt), percent(len(b.sentAt), attempted))
+	for reason, n := range b.sendErrs {
+		fmt.Printf("  %v x %v\n", n, reason)
+	}
+
+	fmt.Printf("\n%-10v %10v %10v %10v %10v %10v\n", "latency", "min", "p50", "p90", "p99", "max")
+	printLatency("send", b.sendLat)
+	printLatency("delivery", b.recvLat)
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+func printLatency(name string, lat []time.Duration) {
+	if len(lat) == 0 {
+		fmt.Printf("%-10v %10v\n", name, "-")
+		return
+	}
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	p := func(q float64) time.Duration { return lat[int(q*float64(len(lat)-1))].Round(time.Microsecond) }
+	fmt.Printf("%-10v %10v %10v %10v %10v %10v\n", name, p(0), p(0.5), p(0.9), p(0.99), p(1))
+}
+
+// stats fetches admin.stats of the server with the admin token, if given.
+func stats(tlsConf *tls.Config) *models.Stats {
+	if *adminTokenFlag == "" {
+		return nil
+	}
+
+	c, err := client.NewClient()
+	if err != nil {
+		fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+	if err := c.ConnectTLS(*addrFlag, tlsConf); err != nil {
+		fatalf("failed to connect admin client to %v: %v", *addrFlag, err)
+	}
+
+	acked := make(chan struct{})
+	if err := c.JWTAuth(*adminTokenFlag, func(ack string) error {
+		close(acked)
+		return nil
+	}); err != nil {
+		fatalf("failed to authenticate admin client: %v", err)
+	}
+	select {
+	case <-acked:
+	case <-time.After(*timeoutFlag):
+		fmt.Fprintln(os.Stderr, "admin client authentication timed out, is the admin token valid?")
+		return nil
+	}
+
+	res := make(chan *models.Stats, 1)
+	if err := c.Stats(func(s *models.Stats) error {
+		res <- s
+		return nil
+	}); err != nil {
+		fatalf("failed to fetch server stats: %v", err)
+	}
+
+	select {
+	case s := <-res:
+		return s
+	case <-time.After(*timeoutFlag):
+		fmt.Fprintln(os.Stderr, "server stats request timed out, does the admin token have the admin role?")
+		return nil
+	}
+}
+
+func reportStats(before, after *models.Stats) {
+	mb := func(b uint64) string { return fmt.Sprintf("%.1fMB", float64(b)/(1<<20)) }
+	rows := [][3]string{
+		{"conns", strconv.Itoa(before.Conns), strconv.Itoa(after.Conns)},
+		{"users", strconv.FormatInt(before.Users, 10), strconv.FormatInt(after.Users, 10)},
+		{"queue length", strconv.FormatInt(before.QueueLength, 10), strconv.FormatInt(after.QueueLength, 10)},
+		{"queue expired", strconv.FormatInt(before.QueueExpired, 10), strconv.FormatInt(after.QueueExpired, 10)},
+		{"worker queue", strconv.Itoa(before.WorkerQueue), strconv.Itoa(after.WorkerQueue)},
+		{"requests shed", strconv.FormatInt(before.RequestsShed, 10), strconv.FormatInt(after.RequestsShed, 10)},
+		{"goroutines", strconv.Itoa(before.Goroutines), strconv.Itoa(after.Goroutines)},
+		{"heap alloc", mb(before.HeapAlloc), mb(after.HeapAlloc)},
+		{"sys", mb(before.Sys), mb(after.Sys)},
+		{"GC cycles", strconv.FormatUint(uint64(before.NumGC), 10), strconv.FormatUint(uint64(after.NumGC), 10)},
+		{"GC pause", time.Duration(before.GCPauseTotal).String(), time.Duration(after.GCPauseTotal).String()},
+	}
+
+	fmt.Printf("\n%-14v %12v %12v\n", "server", "before", "after")
+	for _, r := range rows {
+		fmt.Printf("%-14v %12v %12v\n", r[0], r[1], r[2])
+	}
+}
+
+func fatalf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(1)
+}
@@ -0,0 +1,374 @@
+// Command titan-client is an interactive Titan client for manual protocol debugging. It connects to a Titan server,
+// authenticates with a JWT token or a client certificate, and reads commands from stdin while printing everything the server sends.
+//
+//	titan-client -addr wss://titan.example.com:3000 -token <JWT>
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+var (
+	addrFlag     = flag.String("addr", "ws://127.0.0.1:3000", "Address of the Titan server: ws://host:port or wss://host:port")
+	tokenFlag    = flag.String("token", os.Getenv("TITAN_TOKEN"), "JWT token to authenticate with. Defaults to TITAN_TOKEN environment variable.")
+	deviceFlag   = flag.String("device", "cli", "Device name to identify the connection with.")
+	caFlag       = flag.String("ca", "", "PEM encoded CA certificate file to trust for wss:// connections, in addition to the system roots.")
+	certFlag     = flag.String("cert", "", "PEM encoded client certificate file to authenticate with instead of a JWT token.")
+	keyFlag      = flag.String("key", "", "PEM encoded private key file of the client certificate.")
+	insecureFlag = flag.Bool("insecure", false, "Skip verifying the server certificate for wss:// connections.")
+	timeoutFlag  = flag.Duration("timeout", 10*time.Second, "Time to wait for the response to a request.")
+)
+
+const help = `Commands:
+  send <user> <message>           send a message to a user
+  history <conversation> [cursor] list the messages of a conversation, newest first
+  conversations                   list the conversations seen in this session
+  read <conversation> <id>        mark the messages of a conversation as read up to the given message
+  typing <conversation>           notify the other participants that you are typing
+  presence <user> [user...]       subscribe to the presence of the users
+  echo <message>                  send a message to the echo route
+  raw <method> [json params]      send any request, i.e. raw admin.stats {}
+  help                            show this help
+  quit                            close the connection and exit`
+
+func main() {
+	flag.Parse()
+
+	c, err := client.NewClient()
+	if err != nil {
+		fatalf("failed to create client: %v", err)
+	}
+	c.Device = *deviceFlag
+
+	r := newREPL(c, userID(*tokenFlag))
+	c.InMsgHandler(r.onMessages)
+	c.ReceiptHandler(r.onReceipts)
+	c.PresenceHandler(r.onPresence)
+	c.TypingHandler(r.onTyping)
+	c.NoticeHandler(r.onNotice)
+	c.ProfileHandler(r.onProfile)
+	c.ExportHandler(r.onExport)
+	quit := make(chan struct{})
+	c.DisconnHandler(func(c *client.Client) {
+		select {
+		case <-quit:
+		default:
+			r.printf("disconnected from server")
+			os.Exit(1)
+		}
+	})
+
+	tlsConf, err := tlsConfig()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if err := c.ConnectTLS(*addrFlag, tlsConf); err != nil {
+		fatalf("failed to connect to %v: %v", *addrFlag, err)
+	}
+
+	switch {
+	case *certFlag != "":
+		r.call("auth.cert", map[string]string{"device": c.Device})
+	case *tokenFlag != "":
+		r.call("auth.jwt", map[string]string{"token": *tokenFlag, "device": c.Device})
+	default:
+		fmt.Println("no token or client certificate given, only the public routes can be called")
+	}
+
+	r.run(os.Stdin)
+	close(quit)
+	c.Close()
+}
+
+// tlsConfig creates the TLS configuration for wss:// connections as given by the flags, or nil for the defaults.
+func tlsConfig() (*tls.Config, error) {
+	if *caFlag == "" && *certFlag == "" && !*insecureFlag {
+		return nil, nil
+	}
+
+	conf := &tls.Config{InsecureSkipVerify: *insecureFlag}
+	if *caFlag != "" {
+		pem, err := ioutil.ReadFile(*caFlag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA certificate file: %v", *caFlag)
+		}
+		conf.RootCAs = pool
+	}
+	if *certFlag != "" {
+		cert, err := tls.LoadX509KeyPair(*certFlag, *keyFlag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// userID reads the user ID claim of a JWT token without verifying it, so the conversations of the sent messages can be named.
+func userID(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		UserID string `json:"userid"`
+	}
+	json.Unmarshal(b, &claims)
+	return claims.UserID
+}
+
+type repl struct {
+	client *client.Client
+	userID string
+
+	mutex sync.Mutex
+	convs map[string]models.Message // conversation -> latest message seen
+}
+
+func newREPL(c *client.Client, userID string) *repl {
+	return &repl{client: c, userID: userID, convs: make(map[string]models.Message)}
+}
+
+// run reads and executes the commands until the input ends or quit command is given.
+func (r *repl) run(in io.Reader) {
+	fmt.Println(`Type "help" for the list of commands.`)
+	s := bufio.NewScanner(in)
+	for fmt.Print("> "); s.Scan(); fmt.Print("> ") {
+		args := strings.Fields(s.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" || args[0] == "exit" {
+			return
+		}
+		if err := r.exec(args[0], args[1:], s.Text()); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func (r *repl) exec(cmd string, args []string, line string) error {
+	// rest returns the rest of the line after the first n arguments, so the messages keep their spacing
+	rest := func(n int) string {
+		s := strings.TrimSpace(line)
+		for _, w := range append([]string{cmd}, args...)[:n+1] {
+			s = strings.TrimSpace(strings.TrimPrefix(s, w))
+		}
+		return s
+	}
+
+	switch cmd {
+	case "help":
+		fmt.Println(help)
+	case "send":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: send <user> <message>")
+		}
+		m := models.Message{To: args[0], Message: rest(1), ClientID: strconv.FormatInt(time.Now().UnixNano(), 36)}
+		if r.call("msg.send", []models.Message{m}) && r.userID != "" {
+			m.Conversation, m.From, m.Time = models.DirectConversation(r.userID, m.To), r.userID, time.Now()
+			r.seen(m)
+		}
+	case "history":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: history <conversation> [cursor]")
+		}
+		cursor := ""
+		if len(args) > 1 {
+			cursor = args[1]
+		}
+		r.call("msg.history", map[string]interface{}{"conversation": args[0], "cursor": cursor, "limit": 20})
+	case "conversations":
+		r.conversations()
+	case "read":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: read <conversation> <id>")
+		}
+		r.call("msg.read", map[string]interface{}{"watermarks": []map[string]string{{"conversation": args[0], "id": args[1]}}})
+	case "typing":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: typing <conversation>")
+		}
+		r.call("msg.typing", models.Typing{Conversation: args[0], Typing: true})
+	case "presence":
+		if len(args) == 0 {
+			return fmt.Errorf("usage: presence <user> [user...]")
+		}
+		r.call("presence.sub", args)
+	case "echo":
+		r.call("echo", map[string]string{"message": rest(0)})
+	case "raw":
+		if len(args) == 0 {
+			return fmt.Errorf("usage: raw <method> [json params]")
+		}
+		var params interface{}
+		if p := rest(1); p != "" {
+			if err := json.Unmarshal([]byte(p), &params); err != nil {
+				return fmt.Errorf("invalid json params: %v", err)
+			}
+		}
+		r.call(args[0], params)
+	default:
+		return fmt.Errorf("unknown command: %v (type \"help\" for the list of commands)", cmd)
+	}
+	return nil
+}
+
+// call sends a request and waits for the response, printing the result or the error. Returns true if the request succeeded.
+func (r *repl) call(method string, params interface{}) bool {
+	done := make(chan bool, 1)
+	start := time.Now()
+
+	err := r.client.SendRequest(method, params, func(ctx *neptulon.ResCtx) error {
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if !ctx.Success {
+			var data json.RawMessage
+			ctx.ErrorData(&data)
+			fmt.Printf("%v failed (%v): %v %v %s\n", method, elapsed, ctx.ErrorCode, ctx.ErrorMessage, data)
+			done <- false
+			return nil
+		}
+
+		var res json.RawMessage
+		ctx.Result(&res)
+		fmt.Printf("%v (%v): %s\n", method, elapsed, res)
+		if method == "msg.history" {
+			var h struct {
+				Messages []models.Message `json:"messages"`
+			}
+			json.Unmarshal(res, &h)
+			for _, m := range h.Messages {
+				r.seen(m)
+			}
+		}
+		done <- true
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("%v failed: %v\n", method, err)
+		return false
+	}
+
+	select {
+	case ok := <-done:
+		return ok
+	case <-time.After(*timeoutFlag):
+		fmt.Printf("%v: no response in %v\n", method, *timeoutFlag)
+		return false
+	}
+}
+
+func (r *repl) seen(m models.Message) {
+	if m.Conversation == "" {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if last, ok := r.convs[m.Conversation]; !ok || !m.Time.Before(last.Time) {
+		r.convs[m.Conversation] = m
+	}
+}
+
+func (r *repl) conversations() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.convs) == 0 {
+		fmt.Println("no conversations seen yet, use history or wait for incoming messages")
+		return
+	}
+	convs := make([]models.Message, 0, len(r.convs))
+	for _, m := range r.convs {
+		convs = append(convs, m)
+	}
+	sort.Slice(convs, func(i, j int) bool { return convs[i].Time.After(convs[j].Time) })
+	for _, m := range convs {
+		fmt.Printf("%-24v %v  %v: %v\n", m.Conversation, m.Time.Format(time.Stamp), m.From, m.Message)
+	}
+}
+
+func (r *repl) onMessages(msgs []models.Message) error {
+	for _, m := range msgs {
+		r.seen(m)
+		r.printf("<- msg.recv [%v] %v: %v (id: %v)", m.Conversation, m.From, m.Message, m.ID)
+	}
+	return nil
+}
+
+func (r *repl) onReceipts(rs []models.Receipt) error {
+	for _, rc := range rs {
+		r.printf("<- receipt %v: %v -> %v %v", rc.ID, rc.From, rc.To, rc.State)
+	}
+	return nil
+}
+
+func (r *repl) onPresence(ps []models.Presence) error {
+	for _, p := range ps {
+		b, _ := json.Marshal(p)
+		r.printf("<- presence.update %s", b)
+	}
+	return nil
+}
+
+func (r *repl) onTyping(t *models.Typing) error {
+	b, _ := json.Marshal(t)
+	r.printf("<- msg.typing %s", b)
+	return nil
+}
+
+func (r *repl) onNotice(n *models.Notice) error {
+	b, _ := json.Marshal(n)
+	r.printf("<- sys.notice %s", b)
+	return nil
+}
+
+func (r *repl) onProfile(p *models.Profile) error {
+	b, _ := json.Marshal(p)
+	r.printf("<- profile.update %s", b)
+	return nil
+}
+
+func (r *repl) onExport(e *models.Export) error {
+	b, _ := json.Marshal(e)
+	r.printf("<- export.ready %s", b)
+	return nil
+}
+
+// printf prints a line without messing up the prompt, as the server messages arrive while waiting for input.
+func (r *repl) printf(format string, a ...interface{}) {
+	fmt.Printf("\r"+format+"\n> ", a...)
+}
+
+func fatalf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(1)
+}
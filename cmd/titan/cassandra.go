@@ -0,0 +1,24 @@
+//go:build cassandra
+// +build cassandra
+
+package main
+
+import (
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data/cassandra"
+)
+
+// setupMessageDB keeps the message history in the Cassandra cluster given with MSG_DB_DSN, if MSG_DB is cassandra.
+// Returned function closes the connections upon shutdown.
+func setupMessageDB(s *titan.Server) (func(), error) {
+	if titan.Conf.DB.MessageBackend != "cassandra" {
+		return func() {}, nil
+	}
+
+	db, err := cassandra.NewMessageDB(titan.Conf.DB.MessageDSN, titan.Conf.App.MsgRetention)
+	if err != nil {
+		return nil, err
+	}
+	s.SetMessageDB(db)
+	return db.Close, nil
+}
@@ -0,0 +1,19 @@
+//go:build !cassandra
+// +build !cassandra
+
+package main
+
+import (
+	"errors"
+
+	"github.com/titan-x/titan"
+)
+
+// setupMessageDB fails if a Cassandra message database is configured, unless the server is built with the cassandra tag
+// (go build -tags cassandra), which keeps the message history in Cassandra.
+func setupMessageDB(s *titan.Server) (func(), error) {
+	if titan.Conf.DB.MessageBackend != "" {
+		return nil, errors.New("cassandra support is not compiled in, rebuild with -tags cassandra")
+	}
+	return func() {}, nil
+}
@@ -0,0 +1,34 @@
+//go:build grpc
+// +build grpc
+
+package main
+
+import (
+	"net"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/grpcapi"
+	"github.com/titan-x/titan/log"
+)
+
+// setupGRPC serves the gRPC service API for the backend services at the configured address, if any.
+// Returned function stops the gRPC server, waiting for the pending calls to complete.
+func setupGRPC(s *titan.Server) (func(), error) {
+	if titan.Conf.App.GRPCAddr == "" {
+		return func() {}, nil
+	}
+
+	l, err := net.Listen("tcp", titan.Conf.App.GRPCAddr)
+	if err != nil {
+		return nil, err
+	}
+	gs := grpcapi.NewServer(s)
+	go func() {
+		if err := gs.Serve(l); err != nil {
+			log.Errorf("grpc server stopped: %v", err)
+		}
+	}()
+	log.Infof("serving grpc api at %v", l.Addr())
+
+	return gs.GracefulStop, nil
+}
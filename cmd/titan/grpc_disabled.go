@@ -0,0 +1,19 @@
+//go:build !grpc
+// +build !grpc
+
+package main
+
+import (
+	"errors"
+
+	"github.com/titan-x/titan"
+)
+
+// setupGRPC fails if a gRPC address is configured, unless the server is built with the grpc tag (go build -tags grpc),
+// which serves the gRPC service API for the backend services.
+func setupGRPC(s *titan.Server) (func(), error) {
+	if titan.Conf.App.GRPCAddr != "" {
+		return nil, errors.New("grpc support is not compiled in, rebuild with -tags grpc")
+	}
+	return func() {}, nil
+}
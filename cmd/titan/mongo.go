@@ -0,0 +1,27 @@
+//go:build mongo
+// +build mongo
+
+package main
+
+import (
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data/mongo"
+)
+
+// setupMongo keeps all the data in the MongoDB database given with DB_DSN, if DB is mongo.
+// Returned function closes the connections upon shutdown.
+func setupMongo(s *titan.Server) (func(), error) {
+	if titan.Conf.DB.Backend != "mongo" || *awsFlag || *pgFlag != "" {
+		return func() {}, nil
+	}
+
+	db, err := mongo.NewDB(titan.Conf.DB.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SetDB(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return func() { db.Close() }, nil
+}
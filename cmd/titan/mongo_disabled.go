@@ -0,0 +1,19 @@
+//go:build !mongo
+// +build !mongo
+
+package main
+
+import (
+	"errors"
+
+	"github.com/titan-x/titan"
+)
+
+// setupMongo fails if a MongoDB database is configured, unless the server is built with the mongo tag
+// (go build -tags mongo), which keeps all the data in MongoDB.
+func setupMongo(s *titan.Server) (func(), error) {
+	if titan.Conf.DB.Backend == "mongo" && !*awsFlag && *pgFlag == "" {
+		return nil, errors.New("mongo support is not compiled in, rebuild with -tags mongo")
+	}
+	return func() {}, nil
+}
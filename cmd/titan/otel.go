@@ -0,0 +1,37 @@
+//go:build otel
+// +build otel
+
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/trace"
+	"github.com/titan-x/titan/trace/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// setupTracing exports the traces to the OpenTelemetry collector configured with the standard OTEL_EXPORTER_OTLP_* environment
+// variables, if an endpoint is given. Returned function flushes the remaining spans upon shutdown.
+func setupTracing() (func(), error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func() {}, nil
+	}
+
+	exp, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp))
+	trace.SetTracer(otel.NewTracer(tp))
+
+	return func() {
+		trace.SetTracer(nil)
+		if err := tp.Shutdown(context.Background()); err != nil {
+			log.Errorf("error flushing traces: %v", err)
+		}
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !otel
+// +build !otel
+
+package main
+
+// setupTracing is a no-op unless the server is built with the otel tag (go build -tags otel), which exports the traces
+// to an OpenTelemetry collector.
+func setupTracing() (func(), error) {
+	return func() {}, nil
+}
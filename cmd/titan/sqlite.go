@@ -0,0 +1,27 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+import (
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data/sqlite"
+)
+
+// setupSQLite keeps all the data in the SQLite database file given with DB_DSN, if DB is sqlite.
+// Returned function closes the database upon shutdown.
+func setupSQLite(s *titan.Server) (func(), error) {
+	if titan.Conf.DB.Backend != "sqlite" || *awsFlag || *pgFlag != "" {
+		return func() {}, nil
+	}
+
+	db, err := sqlite.NewDB(titan.Conf.DB.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SetDB(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return func() { db.Close() }, nil
+}
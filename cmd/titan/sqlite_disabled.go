@@ -0,0 +1,19 @@
+//go:build !sqlite
+// +build !sqlite
+
+package main
+
+import (
+	"errors"
+
+	"github.com/titan-x/titan"
+)
+
+// setupSQLite fails if a SQLite database is configured, unless the server is built with the sqlite tag
+// (go build -tags sqlite), which keeps all the data in a SQLite database file.
+func setupSQLite(s *titan.Server) (func(), error) {
+	if titan.Conf.DB.Backend == "sqlite" && !*awsFlag && *pgFlag == "" {
+		return nil, errors.New("sqlite support is not compiled in, rebuild with -tags sqlite")
+	}
+	return func() {}, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/titan-x/titan/data/migrate"
+	"github.com/titan-x/titan/data/postgres"
+)
+
+// migrateDB applies the pending schema migrations to the database given with the connection string, without connecting to a server.
+// PostgreSQL connection strings are given as URLs, and anything else is taken as the path of a SQLite database file.
+func migrateDB(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	status := fs.Bool("status", false, "Print the schema version of the database without applying the pending migrations.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: titanctl migrate [-status] <dsn>")
+	}
+
+	m, db, err := openMigrator(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if !*status {
+		n, err := m.Up()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %v migrations\n", n)
+	}
+
+	v, err := m.Version()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("schema version %v, latest %v\n", v, m.Latest())
+	return nil
+}
+
+func openMigrator(dsn string) (*migrate.Migrator, io.Closer, error) {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return openSQLiteMigrator(dsn)
+	}
+
+	db, err := postgres.NewDB(dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := db.Migrator()
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return m, db, nil
+}
@@ -0,0 +1,24 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+import (
+	"io"
+
+	"github.com/titan-x/titan/data/migrate"
+	"github.com/titan-x/titan/data/sqlite"
+)
+
+func openSQLiteMigrator(path string) (*migrate.Migrator, io.Closer, error) {
+	db, err := sqlite.NewDB(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := db.Migrator()
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return m, db, nil
+}
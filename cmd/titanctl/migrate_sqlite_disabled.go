@@ -0,0 +1,16 @@
+//go:build !sqlite
+// +build !sqlite
+
+package main
+
+import (
+	"errors"
+	"io"
+
+	"github.com/titan-x/titan/data/migrate"
+)
+
+// openSQLiteMigrator fails unless titanctl is built with the sqlite tag (go build -tags sqlite).
+func openSQLiteMigrator(path string) (*migrate.Migrator, io.Closer, error) {
+	return nil, nil, errors.New("sqlite support is not compiled in, rebuild with -tags sqlite")
+}
@@ -1,33 +1,229 @@
 package titan
 
 import (
-	"log"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/titan-x/titan/acme"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
 )
 
+var confLog = log.Component("conf")
+
 const (
 	// titan server envrinment variables
-	goEnv    = "GO_ENV"
-	titanEnv = "ENV"
-	debug    = "DEBUG"
-	port     = "PORT"
-	jwtPass  = "PASS"
+	goEnv        = "GO_ENV"
+	titanEnv     = "ENV"
+	configFile   = "CONFIG"
+	debug        = "DEBUG"
+	addr         = "ADDR"
+	port         = "PORT"
+	jwtPass      = "PASS"
+	jwtPrevPass  = "PREV_PASS"
+	tlsCert      = "TLS_CERT"
+	tlsKey       = "TLS_KEY"
+	tlsCACert    = "TLS_CA_CERT"
+	tlsCAKey     = "TLS_CA_KEY"
+	tlsMinVer    = "TLS_MIN_VERSION"
+	tlsCiphers   = "TLS_CIPHER_SUITES"
+	tlsCurves    = "TLS_CURVES"
+	tlsSNICerts  = "TLS_SNI_CERTS"
+	acmeDomains  = "ACME_DOMAINS"
+	acmeEmail    = "ACME_EMAIL"
+	acmeCacheDir = "ACME_CACHE_DIR"
+	acmeHTTPAddr = "ACME_HTTP_ADDR"
+	acmeDirURL   = "ACME_DIRECTORY"
+	httpTimeout  = "HTTP_TIMEOUT"
+	handshakeTO  = "HANDSHAKE_TIMEOUT"
+	readTO       = "READ_TIMEOUT"
+	writeTO      = "WRITE_TIMEOUT"
+	idleTO       = "IDLE_TIMEOUT"
+	writeBuffer  = "WRITE_BUFFER"
+	workers      = "WORKERS"
+	workerQueue  = "WORKER_QUEUE"
+	heartbeat    = "HEARTBEAT_INTERVAL"
+	heartbeatTO  = "HEARTBEAT_TIMEOUT"
+	tokenTTL     = "ACCESS_TOKEN_TTL"
+	rateLimitReq = "RATE_LIMIT_REQUESTS"
+	rateLimitMsg = "RATE_LIMIT_MESSAGES"
+	maxConnsIP   = "MAX_CONNS_PER_IP"
+	abuseLimit   = "ABUSE_THRESHOLD"
+	abuseWindow  = "ABUSE_WINDOW"
+	abuseBan     = "ABUSE_BAN"
+	proxyProto   = "PROXY_PROTOCOL"
+	healthPort   = "HEALTH_PORT"
+	adminPort    = "ADMIN_PORT"
+	longPollHold = "LONG_POLL_HOLD"
+	quicAddr     = "QUIC_ADDR"
+	listeners    = "LISTENERS"
+	grpcAddr     = "GRPC_ADDR"
+	webhookKeys  = "WEBHOOK_KEYS"
+	eventHooks   = "EVENT_WEBHOOKS"
+	eventHookKey = "EVENT_WEBHOOK_SECRET"
+	eventHookTyp = "EVENT_WEBHOOK_TYPES"
+	compressMin  = "COMPRESS_THRESHOLD"
+	msgTTL       = "MSG_TTL"
+	msgRetention = "MSG_RETENTION"
+	queueLimit   = "QUEUE_LIMIT"
+	bcastRate    = "BROADCAST_RATE"
+	attMaxSize   = "ATTACHMENT_MAX_SIZE"
+	attQuota     = "ATTACHMENT_QUOTA"
+	thumbSizes   = "THUMBNAIL_SIZES"
+	deleteGrace  = "DELETION_GRACE"
+	logLevel     = "LOG_LEVEL"
+	logFormat    = "LOG_FORMAT"
 
 	// possible TITAN_ENV values
 	envDev  = "development"
 	envTest = "test"
 	envProd = "production"
 
+	// possible LOG_FORMAT values
+	logText = "text"
+	logJSON = "json"
+
+	// database environment variables
+	dbBackend  = "DB"
+	dbDSN      = "DB_DSN"
+	dbSnapshot = "DB_SNAPSHOT_INTERVAL"
+	msgDB      = "MSG_DB"
+	msgDBDSN   = "MSG_DB_DSN"
+	dbFixtures = "DB_FIXTURES"
+
+	// possible DB values
+	dbInmem    = "inmem"
+	dbAWS      = "aws"
+	dbPostgres = "postgres"
+	dbSQLite   = "sqlite"
+	dbMongo    = "mongo"
+
+	// possible MSG_DB values
+	msgDBCassandra = "cassandra"
+
 	// GCM environment variables
-	gcmSenderID = "GCM_SENDER_ID"
-	gcmCcsHost  = "GCM_CCS_HOST"
+	gcmSenderID    = "GCM_SENDER_ID"
+	gcmCcsHost     = "GCM_CCS_HOST"
+	gcmProvider    = "GCM_PROVIDER"
+	fcmCredentials = "FCM_CREDENTIALS"
+	pushCollapse   = "PUSH_COLLAPSE_WINDOW"
+	pushPriority   = "PUSH_PRIORITY"
+	pushDelayIdle  = "PUSH_DELAY_WHILE_IDLE"
+	pushNotif      = "PUSH_NOTIFICATION"
+	pushTitle      = "PUSH_TITLE"
+	pushBody       = "PUSH_BODY"
+
+	// possible GCM_PROVIDER values
+	providerCCS = "ccs"
+	providerFCM = "fcm"
+
+	// e-mail environment variables
+	mailProvider  = "MAIL_PROVIDER"
+	mailFrom      = "MAIL_FROM"
+	mailVerifyURL = "MAIL_VERIFY_URL"
+	smtpAddr      = "SMTP_ADDR"
+	smtpUser      = "SMTP_USER"
+	smtpPass      = "SMTP_PASSWORD"
+	sesRegion     = "SES_REGION"
+
+	// possible MAIL_PROVIDER values
+	mailSMTP = "smtp"
+	mailSES  = "ses"
+
+	// SMS environment variables
+	smsProvider      = "SMS_PROVIDER"
+	twilioAccountSID = "TWILIO_ACCOUNT_SID"
+	twilioAuthToken  = "TWILIO_AUTH_TOKEN"
+	twilioFrom       = "TWILIO_FROM"
+
+	// possible SMS_PROVIDER values
+	smsTwilio = "twilio"
 
 	// Google environment variables
-	googleAPIKey = "GOOGLE_API_KEY"
+	googleAPIKey   = "GOOGLE_API_KEY"
+	googleClientID = "GOOGLE_CLIENT_ID"
 
 	// Default listener port configuration
 	portDefault = "3000"
 	portTest    = "3001"
+
+	// Default listener address for ACME HTTP-01 challenges, which must be served at port 80
+	acmeHTTPAddrDefault = ":80"
+
+	// Default minimum TLS version the clients can negotiate
+	tlsMinVersionDefault = "1.2"
+
+	// Default timeout for outgoing HTTP calls (i.e. Google APIs)
+	httpTimeoutDefault = 30 * time.Second
+
+	// Default timeouts of the client connections: WebSocket handshake, reading the rest of a message after it starts arriving,
+	// writing a message, and waiting for the next message from the client
+	handshakeTODefault = 10 * time.Second
+	readTODefault      = 30 * time.Second
+	writeTODefault     = 30 * time.Second
+	idleTODefault      = 5 * time.Minute
+
+	// Default number of messages that can wait to be written to a client before it is considered to have stopped reading
+	writeBufferDefault = 256
+
+	// Default number of workers running the request handlers, and the number of requests that can wait for a free worker
+	workersDefault     = 1000
+	workerQueueDefault = 10000
+
+	// Default time of silence from a client after which it is pinged, and the time to wait for its pong
+	heartbeatDefault   = time.Minute
+	heartbeatTODefault = 10 * time.Second
+
+	// Default lifetime of the access tokens issued with refresh tokens
+	tokenTTLDefault = time.Hour
+
+	// Default rate limits, per minute
+	rateLimitReqDefault = 600
+	rateLimitMsgDefault = 120
+
+	// Default number of offenses (failed logins, malformed frames, rate limit violations) of a remote IP within the window
+	// to ban the IP after, and the duration of the bans
+	abuseLimitDefault  = 20
+	abuseWindowDefault = time.Minute
+	abuseBanDefault    = 15 * time.Minute
+
+	// Default number of users per second a broadcast is enqueued for
+	bcastRateDefault = 1000
+
+	// Default minimum size of the messages to compress, in bytes, as smaller messages barely shrink
+	compressMinDefault = 1024
+
+	// Default maximum size of an attachment, and the storage quota of a user for the attachments, in bytes
+	attMaxSizeDefault = 25 << 20
+	attQuotaDefault   = 1 << 30
+
+	// Default sizes of the thumbnails of the image attachments, in pixels, and the largest size allowed
+	thumbSizesDefault = "160,640"
+	thumbSizeMax      = 2048
+
+	// Default time a deleted account is kept for before its data is deleted for good
+	deleteGraceDefault = 30 * 24 * time.Hour
+
+	// Default interval to take the snapshots of the persisted inmem database at
+	dbSnapshotDefault = 5 * time.Minute
+
+	// Default GCM CCS production endpoint
+	ccsHostDefault = "gcm.googleapis.com:5235"
+
+	// Default window to collapse the push notifications of a conversation within
+	pushCollapseDefault = time.Minute
+
+	// Default push notification priority and templates of the displayed notifications
+	pushPriorityDefault = "high"
+	pushTitleDefault    = "{{.From}}"
+	pushBodyDefault     = "{{if gt .Count 1}}{{.Count}} new messages{{else}}New message{{end}}"
 )
 
 // Conf contains all the global configuration for the titan server.
@@ -35,61 +231,884 @@ var Conf Config
 
 // Config describes the global configuration for the titan server.
 type Config struct {
-	App App
-	GCM GCM
+	App  App
+	DB   DB
+	GCM  GCM
+	Mail Mail
+	SMS  SMS
 }
 
 // App contains the global application variables.
 type App struct {
-	Env   string // One of the following: development, test, production.
-	Debug bool   // Enables verbose logging to stdout.
-	Port  string // Listener port.
+	Env               string        // One of the following: development, test, production.
+	Debug             bool          // Enables verbose logging to stdout.
+	LogLevel          string        // Minimum level of log entries to write: debug, info, warn, error. Defaults to debug if Debug is set, info otherwise.
+	LogFormat         string        // One of the following: text, json.
+	Addr              string        // Listener address formatted as host:port. If empty, server listens on all interfaces on the given port.
+	Port              string        // Listener port.
+	JWTSecret         string        // JWT signing password.
+	JWTPrev           string        // Comma separated list of previous JWT signing passwords, which are still accepted for verification.
+	TLSCert           string        // Path to PEM encoded server certificate file.
+	TLSKey            string        // Path to PEM encoded server private key file.
+	TLSCACert         string        // Path to PEM encoded CA certificate file for verifying client certificates.
+	TLSCAKey          string        // Path to PEM encoded private key file of the CA certificate, for issuing client certificates to devices.
+	TLSSNICerts       string        // Comma separated list of additional certificate and private key files formatted as cert.pem:key.pem, each presented to the clients requesting one of its hostnames with SNI.
+	TLSMinVersion     string        // Minimum TLS version the clients can negotiate: 1.2 or 1.3.
+	TLSCipherSuites   string        // Comma separated list of the cipher suites allowed for TLS 1.2, i.e. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. If empty, Go defaults are used.
+	TLSCurves         string        // Comma separated list of the key exchange curves in the order of preference: X25519, P256, P384, P521. If empty, Go defaults are used.
+	ACMEDomains       string        // Comma separated list of domains to obtain the server certificate for from Let's Encrypt (or another ACME CA), in place of TLSCert and TLSKey.
+	ACMEEmail         string        // Contact e-mail for the ACME account.
+	ACMECacheDir      string        // Directory to cache the ACME account key and the obtained certificate in.
+	ACMEHTTPAddr      string        // Listener address for the ACME HTTP-01 challenges.
+	ACMEDirectory     string        // ACME directory URL. Defaults to Let's Encrypt production environment.
+	HTTPTimeout       time.Duration // Timeout for outgoing HTTP calls.
+	HandshakeTimeout  time.Duration // Time allowed for the WebSocket handshake of a client connection. Negative value disables the timeout.
+	ReadTimeout       time.Duration // Time allowed to read the rest of a message from a client after it starts arriving. Negative value disables the timeout.
+	WriteTimeout      time.Duration // Time allowed to write a message to a client, so slow clients cannot block the senders. Negative value disables the timeout.
+	IdleTimeout       time.Duration // Time to wait for the next message from a client before closing the connection. Negative value disables the timeout.
+	WriteBuffer       int           // Number of messages that can wait to be written to a client, beyond which the client is disconnected. Negative value makes the writes synchronous.
+	Workers           int           // Number of workers running the request handlers of all the clients. Negative value runs each request on its own goroutine.
+	WorkerQueue       int           // Number of requests that can wait for a free worker, beyond which the requests are rejected as the server is overloaded. Negative value rejects the requests as soon as all the workers are busy.
+	HeartbeatInterval time.Duration // Time of silence from a client after which it is pinged to detect half-open connections. Negative value disables the heartbeat.
+	HeartbeatTimeout  time.Duration // Time to wait for a client to respond to a ping before closing the connection.
+	AccessTokenTTL    time.Duration // Lifetime of the JWT access tokens issued in exchange for refresh tokens.
+	GoogleClientID    string        // Google OAuth 2.0 client ID of the server, which Google Sign-In ID tokens must be issued for.
+	RateLimitRequests int           // Maximum number of requests per connection per minute. Negative value disables the limit.
+	RateLimitMessages int           // Maximum number of messages sent per user per minute. Negative value disables the limit.
+	MaxConnsPerIP     int           // Maximum number of simultaneous connections from the same remote IP. Zero means no limit.
+	AbuseThreshold    int           // Number of offenses (failed logins, malformed frames, rate limit violations) of a remote IP within AbuseWindow to ban the IP after. Negative value disables the bans.
+	AbuseWindow       time.Duration // Time window to count the offenses of a remote IP within.
+	AbuseBan          time.Duration // Duration of the bans of the remote IPs, during which their connections are refused.
+	ProxyProtocol     string        // Comma separated list of the addresses or networks (CIDR) of the load balancers sending the PROXY protocol header. If empty, the header is not expected.
+	HealthPort        string        // Port to serve the HTTP health check endpoints at. If empty, health checks are not served.
+	AdminPort         string        // Port to serve the admin only HTTP runtime debug endpoints (pprof, goroutines, GC stats) at. If empty, they are not served.
+	LongPollHold      time.Duration // Maximum time to hold the HTTP long-polling requests waiting for messages. Zero disables long-polling.
+	QUICAddr          string        // UDP address to listen for QUIC connections at (experimental, requires building with quic tag). If empty, QUIC is disabled.
+	Listeners         string        // Comma separated list of additional listeners for the WebSocket connections: ws://host:port, wss://host:port (requires TLS), or unix:///path/to/socket.
+	GRPCAddr          string        // TCP address to serve the gRPC service API for the backend services at (requires building with grpc tag). If empty, the gRPC API is disabled.
+	WebhookKeys       string        // Comma separated list of userid:key pairs of the API keys to send messages with the webhook endpoint on behalf of the users. If empty, the endpoint is disabled.
+	EventWebhooks     string        // Comma separated list of the URLs of the outgoing webhooks to deliver the server events to.
+	EventWebhookKey   string        // Secret to sign the events delivered to the outgoing webhooks with. Required if there are any webhooks.
+	EventWebhookTypes string        // Comma separated list of the event types to deliver to the outgoing webhooks. If empty, all events are delivered.
+	CompressThreshold int           // Minimum size of the messages to compress, in bytes, for the connections which negotiated compression. Negative value disables compression.
+	MsgTTL            time.Duration // Maximum time for a message to wait in the queue for delivery, after which it is dead-lettered. Zero means messages never expire.
+	MsgRetention      time.Duration // Time to keep the messages in the message history for, after which they are deleted. Zero means messages are kept forever.
+	QueueLimit        int           // Maximum number of requests waiting in a user's queue, beyond which new messages to the user are rejected. Zero means no limit.
+	BroadcastRate     int           // Maximum number of users per second a broadcast notice is enqueued for. Negative value disables the throttling.
+	AttachmentMaxSize int           // Maximum size of an attachment in bytes.
+	AttachmentQuota   int           // Maximum total size of the attachments of a user in bytes. Negative value disables the quota.
+	ThumbnailSizes    string        // Comma separated list of the sizes of the thumbnails to generate for the image attachments, in pixels. "0" disables thumbnails.
+	DeletionGrace     time.Duration // Time after an account.delete request for the data of the account to be deleted, during which signing in again cancels the deletion.
 }
 
-// JWTPass retrieves the JWT signing password.
+// JWTPass retrieves the JWT signing password. Default password is only used outside the production environment, where the
+// configuration is rejected without a password.
 func (app *App) JWTPass() string {
-	pass := os.Getenv(jwtPass)
-	if pass == "" {
-		return "pass"
+	if pass := os.Getenv(jwtPass); pass != "" {
+		return pass
+	}
+	if app.JWTSecret != "" {
+		return app.JWTSecret
+	}
+	return "pass"
+}
+
+// JWTPrevPasses retrieves the previous JWT signing passwords, which are still accepted for verification.
+func (app *App) JWTPrevPasses() []string {
+	var passes []string
+	for _, p := range strings.Split(app.JWTPrev, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			passes = append(passes, p)
+		}
+	}
+	return passes
+}
+
+// TLSSNICertFiles retrieves the paths of the additional certificate and private key files presented to the clients by SNI hostname.
+func (app *App) TLSSNICertFiles() (certFiles, keyFiles []string) {
+	for _, p := range strings.Split(app.TLSSNICerts, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		i := strings.LastIndex(p, ":")
+		if i < 0 {
+			certFiles, keyFiles = append(certFiles, p), append(keyFiles, "")
+			continue
+		}
+		certFiles, keyFiles = append(certFiles, p[:i]), append(keyFiles, p[i+1:])
+	}
+	return certFiles, keyFiles
+}
+
+// ACMEDomainList retrieves the domains to obtain the server certificate for with ACME.
+func (app *App) ACMEDomainList() []string {
+	var domains []string
+	for _, d := range strings.Split(app.ACMEDomains, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// ThumbnailSizeList retrieves the sizes of the thumbnails to generate for the image attachments.
+func (app *App) ThumbnailSizeList() []int {
+	var sizes []int
+	for _, s := range strings.Split(app.ThumbnailSizes, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil && n > 0 {
+			sizes = append(sizes, n)
+		}
+	}
+	return sizes
+}
+
+// ProxyNets retrieves the networks of the load balancers sending the PROXY protocol header. Addresses are single host networks.
+func (app *App) ProxyNets() ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, a := range strings.Split(app.ProxyProtocol, ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		if ip := net.ParseIP(a); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy protocol address: %v", a)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ListenerURLs retrieves the additional listeners for the WebSocket connections.
+func (app *App) ListenerURLs() ([]*url.URL, error) {
+	var ls []*url.URL
+	for _, l := range strings.Split(app.Listeners, ",") {
+		if l = strings.TrimSpace(l); l == "" {
+			continue
+		}
+		u, err := url.Parse(l)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listener: %v", l)
+		}
+		switch {
+		case (u.Scheme == "ws" || u.Scheme == "wss") && u.Host != "" && u.Path == "":
+		case u.Scheme == "unix" && u.Host == "" && u.Path != "":
+		default:
+			return nil, fmt.Errorf("invalid listener, expected ws://host:port, wss://host:port, or unix:///path/to/socket: %v", l)
+		}
+		ls = append(ls, u)
+	}
+	return ls, nil
+}
+
+// WebhookKeyUsers retrieves the API keys of the webhook endpoint along with the IDs of the users they send the messages on behalf of.
+func (app *App) WebhookKeyUsers() (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, p := range strings.Split(app.WebhookKeys, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		// keys are never included in the errors as they are secrets
+		i := strings.Index(p, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid webhook keys, expected comma separated userid:key pairs")
+		}
+		userID, key := p[:i], p[i+1:]
+		if len(key) < minWebhookKeyLen {
+			return nil, fmt.Errorf("webhook key of user %v should be at least %v characters", userID, minWebhookKeyLen)
+		}
+		if _, ok := keys[key]; ok {
+			return nil, fmt.Errorf("duplicate webhook key of user %v", userID)
+		}
+		keys[key] = userID
 	}
-	return pass
+	return keys, nil
+}
+
+// EventWebhookURLs retrieves the URLs of the outgoing webhooks to deliver the server events to.
+func (app *App) EventWebhookURLs() []string {
+	return splitList(app.EventWebhooks)
+}
+
+// EventWebhookTypeList retrieves the event types to deliver to the outgoing webhooks, or none if all events are delivered.
+func (app *App) EventWebhookTypeList() []string {
+	return splitList(app.EventWebhookTypes)
+}
+
+// ListenAddr retrieves the listener address.
+func (app *App) ListenAddr() string {
+	if app.Addr != "" {
+		return app.Addr
+	}
+	return ":" + app.Port
+}
+
+// HTTPClient creates an HTTP client for outgoing calls, with the configured timeout.
+func (app *App) HTTPClient() *http.Client {
+	return &http.Client{Timeout: app.HTTPTimeout}
+}
+
+// DB describes the database backend to store the user data in.
+type DB struct {
+	Backend          string        // One of the following: inmem, aws, postgres, sqlite, mongo (sqlite and mongo require building with the matching tag).
+	DSN              string        // Connection string for the database, if applicable. Database file for sqlite. Snapshot file to persist the users in, for inmem database.
+	SnapshotInterval time.Duration // Interval to take the snapshots of the inmem database at, if persisted. Changes in between are kept in a write-ahead log.
+	MessageBackend   string        // Separate database to keep the message history in: cassandra (requires building with cassandra tag). If empty, messages are kept in Backend.
+	MessageDSN       string        // Connection string for the message database, i.e. host1,host2/keyspace for cassandra.
+	Fixtures         string        // Path to a fixtures file to load into the database at startup, in development and test environments only.
 }
 
 // GCM describes the Google Cloud Messaging parameters as described here: https://developer.android.com/google/gcm/gs.html
 type GCM struct {
-	CCSHost  string
-	SenderID string
+	CCSHost        string
+	SenderID       string
+	Provider       string        // One of the following: ccs (GCM XMPP CCS connection), fcm (FCM HTTP v1 API).
+	FCMCredentials string        // Path to FCM service account credentials JSON file.
+	CollapseWindow time.Duration // Time to collapse the push notifications of a conversation into a single sync notification. Negative value disables collapsing.
+	Priority       string        // Default delivery priority of the push notifications: high or normal.
+	DelayWhileIdle bool          // Whether to hold the push notifications until the devices are active by default. Only supported by GCM CCS.
+	Notification   bool          // Whether to display the push notifications to the users by default, rather than sending the data only.
+	Title          string        // Template of the title of the displayed notifications. See pushTemplateData for the fields.
+	Body           string        // Template of the body of the displayed notifications.
+	apiKey         string
 }
 
-// APIKey gets the GCM API key from environment variable.
+// APIKey gets the GCM API key from environment variable, or from the configuration file if environment variable is not set.
 func (gcm *GCM) APIKey() string {
-	return os.Getenv(googleAPIKey)
+	if key := os.Getenv(googleAPIKey); key != "" {
+		return key
+	}
+	return gcm.apiKey
+}
+
+// Enabled reports whether push notifications are configured, with the sender ID and the API key for GCM CCS,
+// or with the service account credentials for FCM.
+func (gcm *GCM) Enabled() bool {
+	if gcm.Provider == providerFCM {
+		return gcm.FCMCredentials != ""
+	}
+	return gcm.SenderID != "" && gcm.APIKey() != ""
+}
+
+// Mail describes the e-mail provider to send the verification codes of the accounts registered with an e-mail address with.
+type Mail struct {
+	Provider     string // One of the following: smtp, ses. If empty, registration with an e-mail address is disabled.
+	From         string // Sender address of the e-mails.
+	VerifyURL    string // URL of the verification page to link to in the e-mails, with the address and the code appended as email and code query parameters. If empty, only the code is sent.
+	SMTPAddr     string // SMTP server address formatted as host:port.
+	SMTPUser     string // Optional SMTP username for PLAIN authentication.
+	SMTPPassword string
+	SESRegion    string // AWS region of SES. Defaults to AWS_REGION.
+}
+
+// Enabled reports whether an e-mail provider is configured.
+func (m *Mail) Enabled() bool {
+	return m.Provider != ""
+}
+
+// SMS describes the SMS gateway to send the verification codes of the phone numbers with.
+type SMS struct {
+	Provider         string // One of the following: twilio. If empty, phone number verification is disabled.
+	TwilioAccountSID string // Twilio account SID.
+	TwilioAuthToken  string
+	TwilioFrom       string // Twilio phone number or messaging service SID to send the messages from.
+}
+
+// Enabled reports whether an SMS gateway is configured.
+func (s *SMS) Enabled() bool {
+	return s.Provider != ""
 }
 
 // InitConf initializes application configuration.
 // If given, env parameter overrides environment configuration. This is useful for testing.
+// If CONFIG environment variable is set, configuration is loaded from the given file first.
+// Invalid configuration is fatal.
 func InitConf(env string) {
-	if env == "" {
-		env = os.Getenv(titanEnv)
+	if err := LoadConf(env, os.Getenv(configFile)); err != nil {
+		confLog.Fatalf("%v", err)
 	}
-	if env == "" {
-		if env = os.Getenv(goEnv); env == "" {
-			env = envDev
+}
+
+// LoadConf initializes application configuration from the given configuration file, if any.
+// Environment variables override the values in the configuration file, and if given, env parameter overrides environment configuration.
+// Configuration is validated after loading and any errors are returned without modifying the current configuration.
+func LoadConf(env, file string) error {
+	var c Config
+	if file != "" {
+		if err := readConfFile(file, &c); err != nil {
+			return err
 		}
 	}
-	debug := os.Getenv(debug) != "" || (env != envProd)
-	port := os.Getenv(port)
-	if port == "" {
-		switch env {
+
+	// environment variables override the configuration file
+	setFromEnv(&c.App.Env, goEnv)
+	setFromEnv(&c.App.Env, titanEnv)
+	setFromEnv(&c.App.Addr, addr)
+	setFromEnv(&c.App.Port, port)
+	setFromEnv(&c.App.JWTSecret, jwtPass)
+	setFromEnv(&c.App.JWTPrev, jwtPrevPass)
+	setFromEnv(&c.App.LogLevel, logLevel)
+	setFromEnv(&c.App.LogFormat, logFormat)
+	setFromEnv(&c.App.TLSCert, tlsCert)
+	setFromEnv(&c.App.TLSKey, tlsKey)
+	setFromEnv(&c.App.TLSCACert, tlsCACert)
+	setFromEnv(&c.App.TLSCAKey, tlsCAKey)
+	setFromEnv(&c.App.TLSSNICerts, tlsSNICerts)
+	setFromEnv(&c.App.TLSMinVersion, tlsMinVer)
+	setFromEnv(&c.App.TLSCipherSuites, tlsCiphers)
+	setFromEnv(&c.App.TLSCurves, tlsCurves)
+	setFromEnv(&c.App.ACMEDomains, acmeDomains)
+	setFromEnv(&c.App.ACMEEmail, acmeEmail)
+	setFromEnv(&c.App.ACMECacheDir, acmeCacheDir)
+	setFromEnv(&c.App.ACMEHTTPAddr, acmeHTTPAddr)
+	setFromEnv(&c.App.ACMEDirectory, acmeDirURL)
+	setFromEnv(&c.App.GoogleClientID, googleClientID)
+	setFromEnv(&c.App.ThumbnailSizes, thumbSizes)
+	setFromEnv(&c.App.HealthPort, healthPort)
+	setFromEnv(&c.App.AdminPort, adminPort)
+	setFromEnv(&c.App.QUICAddr, quicAddr)
+	setFromEnv(&c.App.Listeners, listeners)
+	setFromEnv(&c.App.GRPCAddr, grpcAddr)
+	setFromEnv(&c.App.WebhookKeys, webhookKeys)
+	setFromEnv(&c.App.EventWebhooks, eventHooks)
+	setFromEnv(&c.App.EventWebhookKey, eventHookKey)
+	setFromEnv(&c.App.EventWebhookTypes, eventHookTyp)
+	setFromEnv(&c.App.ProxyProtocol, proxyProto)
+	setFromEnv(&c.DB.Backend, dbBackend)
+	setFromEnv(&c.DB.DSN, dbDSN)
+	setFromEnv(&c.DB.MessageBackend, msgDB)
+	setFromEnv(&c.DB.MessageDSN, msgDBDSN)
+	setFromEnv(&c.DB.Fixtures, dbFixtures)
+	setFromEnv(&c.GCM.CCSHost, gcmCcsHost)
+	setFromEnv(&c.GCM.SenderID, gcmSenderID)
+	setFromEnv(&c.GCM.Provider, gcmProvider)
+	setFromEnv(&c.GCM.FCMCredentials, fcmCredentials)
+	setFromEnv(&c.GCM.apiKey, googleAPIKey)
+	setFromEnv(&c.GCM.Priority, pushPriority)
+	setFromEnv(&c.GCM.Title, pushTitle)
+	setFromEnv(&c.GCM.Body, pushBody)
+	setFromEnv(&c.Mail.Provider, mailProvider)
+	setFromEnv(&c.Mail.From, mailFrom)
+	setFromEnv(&c.Mail.VerifyURL, mailVerifyURL)
+	setFromEnv(&c.Mail.SMTPAddr, smtpAddr)
+	setFromEnv(&c.Mail.SMTPUser, smtpUser)
+	setFromEnv(&c.Mail.SMTPPassword, smtpPass)
+	setFromEnv(&c.Mail.SESRegion, sesRegion)
+	setFromEnv(&c.SMS.Provider, smsProvider)
+	setFromEnv(&c.SMS.TwilioAccountSID, twilioAccountSID)
+	setFromEnv(&c.SMS.TwilioAuthToken, twilioAuthToken)
+	setFromEnv(&c.SMS.TwilioFrom, twilioFrom)
+	if err := setBoolFromEnv(&c.GCM.DelayWhileIdle, pushDelayIdle); err != nil {
+		return err
+	}
+	if err := setBoolFromEnv(&c.GCM.Notification, pushNotif); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.HTTPTimeout, httpTimeout); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.AccessTokenTTL, tokenTTL); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.HandshakeTimeout, handshakeTO); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.ReadTimeout, readTO); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.WriteTimeout, writeTO); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.IdleTimeout, idleTO); err != nil {
+		return err
+	}
+	if err := setIntFromEnv(&c.App.WriteBuffer, writeBuffer); err != nil {
+		return err
+	}
+	if err := setIntFromEnv(&c.App.Workers, workers); err != nil {
+		return err
+	}
+	if err := setIntFromEnv(&c.App.WorkerQueue, workerQueue); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.HeartbeatInterval, heartbeat); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.HeartbeatTimeout, heartbeatTO); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.LongPollHold, longPollHold); err != nil {
+		return err
+	}
+	if err := setIntFromEnv(&c.App.CompressThreshold, compressMin); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.MsgTTL, msgTTL); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.MsgRetention, msgRetention); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.DeletionGrace, deleteGrace); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.DB.SnapshotInterval, dbSnapshot); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.GCM.CollapseWindow, pushCollapse); err != nil {
+		return err
+	}
+	if err := setIntFromEnv(&c.App.QueueLimit, queueLimit); err != nil {
+		return err
+	}
+	if err := setIntFromEnv(&c.App.BroadcastRate, bcastRate); err != nil {
+		return err
+	}
+	if err := setIntFromEnv(&c.App.AttachmentMaxSize, attMaxSize); err != nil {
+		return err
+	}
+	if err := setIntFromEnv(&c.App.AttachmentQuota, attQuota); err != nil {
+		return err
+	}
+	if err := setIntFromEnv(&c.App.RateLimitRequests, rateLimitReq); err != nil {
+		return err
+	}
+	if err := setIntFromEnv(&c.App.RateLimitMessages, rateLimitMsg); err != nil {
+		return err
+	}
+	if err := setIntFromEnv(&c.App.MaxConnsPerIP, maxConnsIP); err != nil {
+		return err
+	}
+	if err := setIntFromEnv(&c.App.AbuseThreshold, abuseLimit); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.AbuseWindow, abuseWindow); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&c.App.AbuseBan, abuseBan); err != nil {
+		return err
+	}
+
+	// apply the defaults
+	if env != "" {
+		c.App.Env = env
+	}
+	if c.App.Env == "" {
+		c.App.Env = envDev
+	}
+	c.App.Debug = c.App.Debug || os.Getenv(debug) != "" || (c.App.Env != envProd)
+	if c.App.Port == "" {
+		switch c.App.Env {
 		case envTest:
-			port = portTest
+			c.App.Port = portTest
 		default:
-			port = portDefault
+			c.App.Port = portDefault
 		}
 	}
+	if c.App.LogLevel == "" {
+		if c.App.Debug {
+			c.App.LogLevel = log.DebugLevel.String()
+		} else {
+			c.App.LogLevel = log.InfoLevel.String()
+		}
+	}
+	if c.App.LogFormat == "" {
+		c.App.LogFormat = logText
+	}
+	if c.App.ACMEHTTPAddr == "" {
+		c.App.ACMEHTTPAddr = acmeHTTPAddrDefault
+	}
+	if c.App.TLSMinVersion == "" {
+		c.App.TLSMinVersion = tlsMinVersionDefault
+	}
+	if c.App.ACMEDirectory == "" {
+		c.App.ACMEDirectory = acme.LetsEncryptURL
+	}
+	if c.App.HTTPTimeout == 0 {
+		c.App.HTTPTimeout = httpTimeoutDefault
+	}
+	if c.App.AccessTokenTTL == 0 {
+		c.App.AccessTokenTTL = tokenTTLDefault
+	}
+	if c.App.HandshakeTimeout == 0 {
+		c.App.HandshakeTimeout = handshakeTODefault
+	}
+	if c.App.ReadTimeout == 0 {
+		c.App.ReadTimeout = readTODefault
+	}
+	if c.App.WriteTimeout == 0 {
+		c.App.WriteTimeout = writeTODefault
+	}
+	if c.App.IdleTimeout == 0 {
+		c.App.IdleTimeout = idleTODefault
+	}
+	if c.App.WriteBuffer == 0 {
+		c.App.WriteBuffer = writeBufferDefault
+	}
+	if c.App.Workers == 0 {
+		c.App.Workers = workersDefault
+	}
+	if c.App.WorkerQueue == 0 {
+		c.App.WorkerQueue = workerQueueDefault
+	}
+	if c.App.HeartbeatInterval == 0 {
+		c.App.HeartbeatInterval = heartbeatDefault
+	}
+	if c.App.HeartbeatTimeout == 0 {
+		c.App.HeartbeatTimeout = heartbeatTODefault
+	}
+	if c.App.RateLimitRequests == 0 {
+		c.App.RateLimitRequests = rateLimitReqDefault
+	}
+	if c.App.RateLimitMessages == 0 {
+		c.App.RateLimitMessages = rateLimitMsgDefault
+	}
+	if c.App.AbuseThreshold == 0 {
+		c.App.AbuseThreshold = abuseLimitDefault
+	}
+	if c.App.AbuseWindow == 0 {
+		c.App.AbuseWindow = abuseWindowDefault
+	}
+	if c.App.AbuseBan == 0 {
+		c.App.AbuseBan = abuseBanDefault
+	}
+	if c.App.BroadcastRate == 0 {
+		c.App.BroadcastRate = bcastRateDefault
+	}
+	if c.App.CompressThreshold == 0 {
+		c.App.CompressThreshold = compressMinDefault
+	}
+	if c.App.AttachmentMaxSize == 0 {
+		c.App.AttachmentMaxSize = attMaxSizeDefault
+	}
+	if c.App.AttachmentQuota == 0 {
+		c.App.AttachmentQuota = attQuotaDefault
+	}
+	if c.App.ThumbnailSizes == "" {
+		c.App.ThumbnailSizes = thumbSizesDefault
+	}
+	if c.App.DeletionGrace == 0 {
+		c.App.DeletionGrace = deleteGraceDefault
+	}
+	if c.App.GoogleClientID == "" {
+		c.App.GoogleClientID = gServerClient
+	}
+	if c.DB.Backend == "" {
+		c.DB.Backend = dbInmem
+	}
+	if c.DB.SnapshotInterval == 0 {
+		c.DB.SnapshotInterval = dbSnapshotDefault
+	}
+	if c.GCM.Provider == "" {
+		c.GCM.Provider = providerCCS
+	}
+	if c.GCM.CCSHost == "" {
+		c.GCM.CCSHost = ccsHostDefault
+	}
+	if c.GCM.CollapseWindow == 0 {
+		c.GCM.CollapseWindow = pushCollapseDefault
+	}
+	if c.GCM.Priority == "" {
+		c.GCM.Priority = pushPriorityDefault
+	}
+	if c.GCM.Title == "" {
+		c.GCM.Title = pushTitleDefault
+	}
+	if c.GCM.Body == "" {
+		c.GCM.Body = pushBodyDefault
+	}
+
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	Conf = c
+	level, _ := log.ParseLevel(c.App.LogLevel)
+	log.SetLevel(level)
+	log.SetJSON(c.App.LogFormat == logJSON)
+
+	// don't log the secrets
+	if c.App.JWTSecret != "" {
+		c.App.JWTSecret = "***"
+	}
+	if c.App.JWTPrev != "" {
+		c.App.JWTPrev = "***"
+	}
+	if c.GCM.apiKey != "" {
+		c.GCM.apiKey = "***"
+	}
+	if c.App.WebhookKeys != "" {
+		c.App.WebhookKeys = "***"
+	}
+	if c.App.EventWebhookKey != "" {
+		c.App.EventWebhookKey = "***"
+	}
+	if c.Mail.SMTPPassword != "" {
+		c.Mail.SMTPPassword = "***"
+	}
+	if c.SMS.TwilioAuthToken != "" {
+		c.SMS.TwilioAuthToken = "***"
+	}
+	confLog.Infof("initialized: %+v", c)
+	return nil
+}
+
+// validate checks the configuration for invalid or missing values.
+func (c *Config) validate() error {
+	if _, err := log.ParseLevel(c.App.LogLevel); err != nil {
+		return fmt.Errorf("invalid log level: %v", c.App.LogLevel)
+	}
+
+	switch c.App.LogFormat {
+	case logText, logJSON:
+	default:
+		return fmt.Errorf("invalid log format: %v", c.App.LogFormat)
+	}
+
+	if c.App.HTTPTimeout < 0 {
+		return fmt.Errorf("invalid http timeout: %v", c.App.HTTPTimeout)
+	}
+
+	if c.App.MaxConnsPerIP < 0 {
+		return fmt.Errorf("invalid max connections per ip: %v", c.App.MaxConnsPerIP)
+	}
+
+	if c.App.AbuseWindow < 0 {
+		return fmt.Errorf("invalid abuse window: %v", c.App.AbuseWindow)
+	}
+	if c.App.AbuseBan < 0 {
+		return fmt.Errorf("invalid abuse ban duration: %v", c.App.AbuseBan)
+	}
 
-	app := App{Env: env, Debug: debug, Port: port}
-	gcm := GCM{CCSHost: os.Getenv(gcmCcsHost), SenderID: os.Getenv(gcmSenderID)}
-	Conf = Config{App: app, GCM: gcm}
-	log.Printf("conf: initialized: %+v\n", Conf)
+	if _, err := c.App.ProxyNets(); err != nil {
+		return err
+	}
+
+	if c.App.HeartbeatTimeout < 0 {
+		return fmt.Errorf("invalid heartbeat timeout: %v", c.App.HeartbeatTimeout)
+	}
+
+	if c.App.LongPollHold < 0 {
+		return fmt.Errorf("invalid long-polling hold timeout: %v", c.App.LongPollHold)
+	}
+
+	if c.App.MsgTTL < 0 {
+		return fmt.Errorf("invalid message ttl: %v", c.App.MsgTTL)
+	}
+	if c.App.MsgRetention < 0 {
+		return fmt.Errorf("invalid message retention: %v", c.App.MsgRetention)
+	}
+
+	if c.App.QueueLimit < 0 {
+		return fmt.Errorf("invalid queue limit: %v", c.App.QueueLimit)
+	}
+
+	if c.App.AttachmentMaxSize < 0 {
+		return fmt.Errorf("invalid attachment max size: %v", c.App.AttachmentMaxSize)
+	}
+
+	for _, s := range strings.Split(c.App.ThumbnailSizes, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(s)); err != nil || n < 0 || n > thumbSizeMax {
+			return fmt.Errorf("invalid thumbnail sizes: %v", c.App.ThumbnailSizes)
+		}
+	}
+
+	if c.App.AccessTokenTTL < 0 {
+		return fmt.Errorf("invalid access token ttl: %v", c.App.AccessTokenTTL)
+	}
+
+	if c.App.DeletionGrace < 0 {
+		return fmt.Errorf("invalid account deletion grace period: %v", c.App.DeletionGrace)
+	}
+
+	if (c.App.TLSCert == "") != (c.App.TLSKey == "") {
+		return fmt.Errorf("both tls certificate and private key files must be given")
+	}
+	if c.App.TLSCert != "" && c.App.ACMEDomains != "" {
+		return fmt.Errorf("tls certificate files and acme domains cannot be used together")
+	}
+	if c.App.QUICAddr != "" && c.App.TLSCert == "" && c.App.ACMEDomains == "" {
+		return fmt.Errorf("quic requires tls certificate files or acme domains")
+	}
+	ls, err := c.App.ListenerURLs()
+	if err != nil {
+		return err
+	}
+	for _, u := range ls {
+		if u.Scheme == "wss" && c.App.TLSCert == "" && c.App.ACMEDomains == "" {
+			return fmt.Errorf("wss listener requires tls certificate files or acme domains: %v", u)
+		}
+	}
+	if _, err := c.App.WebhookKeyUsers(); err != nil {
+		return err
+	}
+	for _, u := range c.App.EventWebhookURLs() {
+		if err := validHookURL(u); err != nil {
+			return err
+		}
+		if c.App.EventWebhookKey == "" {
+			return fmt.Errorf("event webhook secret is required along with the event webhooks")
+		}
+	}
+	if c.App.GRPCAddr != "" {
+		if _, _, err := net.SplitHostPort(c.App.GRPCAddr); err != nil {
+			return fmt.Errorf("invalid grpc address: %v", c.App.GRPCAddr)
+		}
+	}
+	if c.App.TLSCAKey != "" && c.App.TLSCACert == "" {
+		return fmt.Errorf("tls ca certificate file must be given along with the ca private key file")
+	}
+	if _, err := tlsPolicy(&c.App); err != nil {
+		return err
+	}
+	sniCerts, sniKeys := c.App.TLSSNICertFiles()
+	if len(sniCerts) != 0 && c.App.TLSCert == "" {
+		return fmt.Errorf("tls sni certificates require the default tls certificate files")
+	}
+	for i := range sniCerts {
+		if sniCerts[i] == "" || sniKeys[i] == "" {
+			return fmt.Errorf("invalid tls sni certificates, expected comma separated cert.pem:key.pem pairs: %v", c.App.TLSSNICerts)
+		}
+	}
+	for _, f := range append([]string{c.App.TLSCert, c.App.TLSKey, c.App.TLSCACert, c.App.TLSCAKey}, append(sniCerts, sniKeys...)...) {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			return fmt.Errorf("cannot access tls file: %v", err)
+		}
+	}
+
+	switch c.DB.Backend {
+	case dbInmem, dbAWS:
+	case dbPostgres:
+		if c.DB.DSN == "" {
+			return fmt.Errorf("database connection string is required for postgres database")
+		}
+	case dbSQLite:
+		if c.DB.DSN == "" {
+			return fmt.Errorf("database file is required for sqlite database")
+		}
+	case dbMongo:
+		if c.DB.DSN == "" {
+			return fmt.Errorf("database connection string is required for mongo database")
+		}
+	default:
+		return fmt.Errorf("invalid database backend: %v", c.DB.Backend)
+	}
+
+	if c.App.JWTSecret == "" && c.App.Env == envProd {
+		return fmt.Errorf("jwt signing password is required in production environment: set %v or jwt_secret", jwtPass)
+	}
+
+	if c.DB.Fixtures != "" && c.App.Env == envProd {
+		return fmt.Errorf("database fixtures cannot be loaded in production environment")
+	}
+
+	switch c.DB.MessageBackend {
+	case "":
+	case msgDBCassandra:
+		if c.DB.MessageDSN == "" {
+			return fmt.Errorf("message database connection string is required for cassandra message database")
+		}
+	default:
+		return fmt.Errorf("invalid message database backend: %v", c.DB.MessageBackend)
+	}
+
+	switch c.GCM.Provider {
+	case providerCCS:
+	case providerFCM:
+		if c.GCM.FCMCredentials == "" {
+			return fmt.Errorf("fcm credentials file is required for fcm push provider")
+		}
+	default:
+		return fmt.Errorf("invalid gcm push provider: %v", c.GCM.Provider)
+	}
+
+	switch c.GCM.Priority {
+	case models.PushPriorityHigh, models.PushPriorityNormal:
+	default:
+		return fmt.Errorf("invalid push priority: %v", c.GCM.Priority)
+	}
+	for _, t := range []string{c.GCM.Title, c.GCM.Body} {
+		if _, err := template.New("push").Parse(t); err != nil {
+			return fmt.Errorf("invalid push notification template: %v", err)
+		}
+	}
+
+	switch c.Mail.Provider {
+	case "":
+	case mailSMTP:
+		if c.Mail.SMTPAddr == "" {
+			return fmt.Errorf("smtp server address is required for smtp e-mail provider")
+		}
+	case mailSES:
+	default:
+		return fmt.Errorf("invalid e-mail provider: %v", c.Mail.Provider)
+	}
+	if c.Mail.Enabled() && c.Mail.From == "" {
+		return fmt.Errorf("sender address is required for sending e-mails")
+	}
+	if c.Mail.VerifyURL != "" {
+		if u, err := url.Parse(c.Mail.VerifyURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid e-mail verification url: %v", c.Mail.VerifyURL)
+		}
+	}
+
+	switch c.SMS.Provider {
+	case "":
+	case smsTwilio:
+		if c.SMS.TwilioAccountSID == "" || c.SMS.TwilioAuthToken == "" || c.SMS.TwilioFrom == "" {
+			return fmt.Errorf("twilio account sid, auth token, and sender number are required for twilio sms provider")
+		}
+	default:
+		return fmt.Errorf("invalid sms provider: %v", c.SMS.Provider)
+	}
+
+	return nil
+}
+
+func setFromEnv(field *string, name string) {
+	if v := os.Getenv(name); v != "" {
+		*field = v
+	}
+}
+
+func setBoolFromEnv(field *bool, name string) error {
+	if v := os.Getenv(name); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %v environment variable: %v", name, err)
+		}
+		*field = b
+	}
+	return nil
+}
+
+func setDurationFromEnv(field *time.Duration, name string) error {
+	if v := os.Getenv(name); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid %v environment variable: %v", name, err)
+		}
+		*field = d
+	}
+	return nil
+}
+
+func setIntFromEnv(field *int, name string) error {
+	if v := os.Getenv(name); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %v environment variable: %v", name, err)
+		}
+		*field = i
+	}
+	return nil
 }
@@ -1,6 +1,11 @@
 package titan
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
 
 func init() {
 	InitConf("test")
@@ -17,7 +22,9 @@ func TestConfig(t *testing.T) {
 		t.Fatal("Config file is not initialized properly for development environment")
 	}
 
+	os.Setenv("PASS", "secret")
 	InitConf("production")
+	os.Unsetenv("PASS")
 	if Conf.App.Env != "production" || Conf.App.Debug || Conf.App.Port != "3000" {
 		t.Fatal("Config file is not initialized properly for production environment")
 	}
@@ -26,6 +33,132 @@ func TestConfig(t *testing.T) {
 	InitConf("test")
 }
 
+func TestConfigFile(t *testing.T) {
+	defer InitConf("test")
+
+	f, err := ioutil.TempFile("", "titan-conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`
+# comments and arrays are supported
+[app]
+env = "production"
+port = 4000
+jwt_secret = "secret"
+http_timeout = "10s"
+thumbnail_sizes = [160, 320]
+
+[db]
+backend = "postgres"
+dsn = "postgres://localhost/titan"
+
+[gcm]
+provider = "fcm"
+fcm_credentials = "/etc/titan/fcm.json"
+`)
+	f.Close()
+
+	os.Setenv(port, "5000")
+	defer os.Unsetenv(port)
+
+	if err := LoadConf("", f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if Conf.App.Env != "production" || Conf.App.Debug || Conf.App.JWTSecret != "secret" || Conf.App.HTTPTimeout != 10*time.Second ||
+		Conf.App.ThumbnailSizes != "160,320" ||
+		Conf.DB.Backend != "postgres" || Conf.DB.DSN != "postgres://localhost/titan" ||
+		Conf.GCM.Provider != "fcm" || Conf.GCM.FCMCredentials != "/etc/titan/fcm.json" {
+		t.Fatalf("configuration file was not loaded properly: %+v", Conf)
+	}
+	if Conf.App.Port != "5000" {
+		t.Fatalf("environment variable did not override configuration file value: %v", Conf.App.Port)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	defer InitConf("test")
+
+	for _, c := range []string{
+		"[app]\nunknown = 1",
+		"[app.tls]\ncert = \"cert.pem\"",
+		"app = 1",
+		"[app]\ndebug = \"yes\"",
+		"[app]\nqueue_limit = \"10\"",
+		"[app]\nacme_domains = [true]",
+		"[app\nport = 443",
+		"[unknown]\nkey = 1",
+		"[app]\ndebug = \"maybe\"",
+		"[app]\nhttp_timeout = \"soon\"",
+		"[app]\ntls_cert = \"cert.pem\"",
+		"[app]\ntls_cert = \"config.go\"\ntls_key = \"config.go\"\nacme_domains = \"titan.test\"",
+		"[app]\nquic_addr = \":443\"",
+		"[app]\nlisteners = \"wss://:8443\"",
+		"[app]\nlisteners = \"tcp://:8443\"",
+		"[app]\ngrpc_addr = \"localhost\"",
+		"[app]\nwebhook_keys = \"billing:short\"",
+		"[app]\nwebhook_keys = \"0123456789abcdef0123456789abcdef\"",
+		"[app]\nevent_webhooks = \"ftp://titan.test/hook\"\nevent_webhook_secret = \"secret\"",
+		"[app]\nevent_webhooks = \"https://titan.test/hook\"",
+		"[app]\ntls_min_version = \"1.1\"",
+		"[app]\ntls_cipher_suites = \"TLS_RSA_WITH_RC4_128_SHA\"",
+		"[app]\ntls_curves = \"X25519,P224\"",
+		"[app]\ntls_sni_certs = \"config.go:config.go\"",
+		"[app]\ntls_cert = \"config.go\"\ntls_key = \"config.go\"\ntls_sni_certs = \"config.go\"",
+		"[app]\nproxy_protocol = \"10.0.0.0/33\"",
+		"[app]\nmsg_ttl = \"-1h\"",
+		"[app]\nqueue_limit = -1",
+		"[app]\nattachment_max_size = -1",
+		"[app]\nthumbnail_sizes = \"160,huge\"",
+		"[db]\nbackend = \"redis\"",
+		"[db]\nbackend = \"mongo\"",
+		"[db]\nbackend = \"postgres\"",
+		"[db]\nbackend = \"sqlite\"",
+		"[db]\nmessage_backend = \"cassandra\"",
+		"[db]\nmessage_backend = \"mongo\"\nmessage_dsn = \"localhost\"",
+		"[gcm]\nprovider = \"fcm\"",
+		"[gcm]\npriority = \"urgent\"",
+		"[gcm]\ntitle = \"{{.From\"",
+		"[mail]\nprovider = \"sendgrid\"\nfrom = \"titan@titan.test\"",
+		"[mail]\nprovider = \"smtp\"\nfrom = \"titan@titan.test\"",
+		"[mail]\nprovider = \"ses\"",
+		"[mail]\nprovider = \"ses\"\nfrom = \"titan@titan.test\"\nverify_url = \"titan.test/verify\"",
+		"[sms]\nprovider = \"nexmo\"",
+		"[sms]\nprovider = \"twilio\"\ntwilio_account_sid = \"AC123\"\ntwilio_from = \"+15550100000\"",
+	} {
+		f, err := ioutil.TempFile("", "titan-conf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(c)
+		f.Close()
+
+		if err := LoadConf("test", f.Name()); err == nil {
+			t.Fatalf("expected validation error for config: %q", c)
+		}
+		os.Remove(f.Name())
+	}
+
+	if err := LoadConf("production", ""); err == nil {
+		t.Fatal("expected jwt signing password to be required in production environment")
+	}
+
+	os.Setenv("PASS", "secret")
+	os.Setenv("DB_FIXTURES", "test/fixtures.json")
+	err := LoadConf("production", "")
+	os.Unsetenv("DB_FIXTURES")
+	os.Unsetenv("PASS")
+	if err == nil {
+		t.Fatal("expected fixtures to be rejected in production environment")
+	}
+
+	if err := LoadConf("test", "non-existent.conf"); err == nil {
+		t.Fatal("expected error for non-existent config file")
+	}
+}
+
 func TestGoEnv(t *testing.T) {
 	// GO_ENV should be used if "TITAN_ENV" is empty
 	// if both are empty, should be env = dev
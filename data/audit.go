@@ -0,0 +1,33 @@
+package data
+
+import (
+	"time"
+
+	"github.com/titan-x/titan/models"
+)
+
+// AuditSink is the append-only destination of the audit log (i.e. a file, the database, or syslog).
+// Entries are never updated or removed through it. Implementations should be safe for concurrent use.
+type AuditSink interface {
+	AppendAudit(e *models.AuditEntry) error
+}
+
+// AuditQuerier is implemented by the audit sinks which can be queried for the recorded entries (i.e. for admin.audit route).
+type AuditQuerier interface {
+	// QueryAudit retrieves the entries matching the filter which were recorded at or before the given time, newest first.
+	QueryAudit(f AuditFilter, before time.Time, offset, limit int) ([]models.AuditEntry, error)
+}
+
+// AuditFilter selects the audit log entries to be retrieved. Empty fields match all entries.
+type AuditFilter struct {
+	UserID string    `json:"userid"` // Matches either the user who performed the action, or the user it is performed on.
+	Action string    `json:"action"`
+	Since  time.Time `json:"since"`
+}
+
+// Match returns true if the given entry matches the filter.
+func (f *AuditFilter) Match(e *models.AuditEntry) bool {
+	return (f.UserID == "" || e.UserID == f.UserID || e.Target == f.UserID) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		!e.Time.Before(f.Since)
+}
@@ -7,18 +7,25 @@
 package aws
 
 import (
+	"bytes"
 	"fmt"
-	"log"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/neptulon/shortid"
 	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
 	"github.com/titan-x/titan/models"
 )
 
+var logger = log.Component("dynamodb")
+
 // DynamoDB implementation for DB interface.
 type DynamoDB struct {
 	DB      *dynamodb.DynamoDB
@@ -32,7 +39,7 @@ type DynamoDB struct {
 // endpoint = Optional endpoint URL setting. Useful for specifying local/development service URL.
 func NewDynamoDB(region string, endpoint string) *DynamoDB {
 	db := DynamoDB{}
-	db.Tables = []string{"users"}
+	db.Tables = []string{"users", "groups", "refresh_tokens", "messages", "keys", "attachments", "topics", "topic_subscribers", "contacts", "blocks", "mutes", "message_index", "verifications", "totp"}
 
 	// carefully crafting config elements not to mess with the defaults
 	if region != "" || endpoint != "" {
@@ -68,6 +75,14 @@ func (db *DynamoDB) listTables() ([]string, error) {
 	return names, nil
 }
 
+// Ping verifies that the DynamoDB service is reachable.
+func (db *DynamoDB) Ping() error {
+	if _, err := db.DB.ListTables(&dynamodb.ListTablesInput{Limit: aws.Int64(1)}); err != nil {
+		return fmt.Errorf("dynamodb: failed to connect: %v", err)
+	}
+	return nil
+}
+
 func (db *DynamoDB) deleteTables() error {
 	tables, err := db.listTables()
 	if err != nil {
@@ -96,7 +111,7 @@ func (db *DynamoDB) Seed(overwrite bool, jwtPass string) error {
 		return fmt.Errorf("dynamodb: failed to initialize: %v", err)
 	}
 
-	log.Printf("dynamodb: initialized with region: %v, access key ID: %v, endpoint: %v", *(db.DB.Config.Region), cred.AccessKeyID, db.DB.Config.Endpoint)
+	logger.Infof("initialized with region: %v, access key ID: %v, endpoint: %v", *(db.DB.Config.Region), cred.AccessKeyID, db.DB.Config.Endpoint)
 
 	if !overwrite {
 		if tbls, err := db.listTables(); err != nil {
@@ -112,7 +127,35 @@ func (db *DynamoDB) Seed(overwrite bool, jwtPass string) error {
 
 	// create the tables
 	for _, tbl := range db.Tables {
-		tableParams := &dynamodb.CreateTableInput{
+		if _, err := db.DB.CreateTable(tableParams(tbl)); err != nil {
+			return err
+		}
+
+		// tables with secondary indexes need to be created sequentially so wait till table is ready
+		if err := db.DB.WaitUntilTableExists(&dynamodb.DescribeTableInput{TableName: aws.String(tbl)}); err != nil {
+			return err
+		}
+	}
+
+	// insert the seed data
+	if err := data.SeedInit(jwtPass); err != nil {
+		return err
+	}
+
+	for _, u := range data.SeedUsers {
+		if err := db.SaveUser(&u); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tableParams returns the table creation parameters for the given table.
+func tableParams(tbl string) *dynamodb.CreateTableInput {
+	// messages table has a secondary conversation index, sorted by message sequence number (time of the message)
+	if tbl == "messages" {
+		return &dynamodb.CreateTableInput{
 			TableName: aws.String(tbl),
 			ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
 				ReadCapacityUnits:  aws.Int64(1),
@@ -124,9 +167,13 @@ func (db *DynamoDB) Seed(overwrite bool, jwtPass string) error {
 					AttributeType: aws.String("S"),
 				},
 				{
-					AttributeName: aws.String("Email"),
+					AttributeName: aws.String("Conversation"),
 					AttributeType: aws.String("S"),
 				},
+				{
+					AttributeName: aws.String("Seq"),
+					AttributeType: aws.String("N"),
+				},
 			},
 			KeySchema: []*dynamodb.KeySchemaElement{
 				{
@@ -136,18 +183,69 @@ func (db *DynamoDB) Seed(overwrite bool, jwtPass string) error {
 			},
 			GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
 				{
-					IndexName: aws.String("Email"),
+					IndexName: aws.String("Conversation"),
 					KeySchema: []*dynamodb.KeySchemaElement{
 						{
-							AttributeName: aws.String("Email"),
+							AttributeName: aws.String("Conversation"),
 							KeyType:       aws.String("HASH"),
 						},
+						{
+							AttributeName: aws.String("Seq"),
+							KeyType:       aws.String("RANGE"),
+						},
 					},
 					Projection: &dynamodb.Projection{
-						NonKeyAttributes: []*string{
-							aws.String("Email"),
+						ProjectionType: aws.String("ALL"),
+					},
+					ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+						ReadCapacityUnits:  aws.Int64(1),
+						WriteCapacityUnits: aws.Int64(1),
+					},
+				},
+			},
+		}
+	}
+
+	// topic_subscribers table is keyed by topic and user ID, so the subscribers of a topic are listed in order,
+	// and has a secondary user index for listing the subscriptions of a user
+	if tbl == "topic_subscribers" {
+		return &dynamodb.CreateTableInput{
+			TableName: aws.String(tbl),
+			ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+				ReadCapacityUnits:  aws.Int64(1),
+				WriteCapacityUnits: aws.Int64(1),
+			},
+			AttributeDefinitions: []*dynamodb.AttributeDefinition{
+				{
+					AttributeName: aws.String("Topic"),
+					AttributeType: aws.String("S"),
+				},
+				{
+					AttributeName: aws.String("UserID"),
+					AttributeType: aws.String("S"),
+				},
+			},
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{
+					AttributeName: aws.String("Topic"),
+					KeyType:       aws.String("HASH"),
+				},
+				{
+					AttributeName: aws.String("UserID"),
+					KeyType:       aws.String("RANGE"),
+				},
+			},
+			GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+				{
+					IndexName: aws.String("UserID"),
+					KeySchema: []*dynamodb.KeySchemaElement{
+						{
+							AttributeName: aws.String("UserID"),
+							KeyType:       aws.String("HASH"),
 						},
-						ProjectionType: aws.String("INCLUDE"),
+					},
+					Projection: &dynamodb.Projection{
+						ProjectionType: aws.String("KEYS_ONLY"),
 					},
 					ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
 						ReadCapacityUnits:  aws.Int64(1),
@@ -155,53 +253,247 @@ func (db *DynamoDB) Seed(overwrite bool, jwtPass string) error {
 					},
 				},
 			},
-			// LocalSecondaryIndexes: []*dynamodb.LocalSecondaryIndex{
-			// 	{
-			// 		IndexName: aws.String("IndexName"),
-			// 		KeySchema: []*dynamodb.KeySchemaElement{
-			// 			{
-			// 				AttributeName: aws.String("KeySchemaAttributeName"),
-			// 				KeyType:       aws.String("KeyType"),
-			// 			},
-			//
-			// 		},
-			// 		Projection: &dynamodb.Projection{
-			// 			NonKeyAttributes: []*string{
-			// 				aws.String("NonKeyAttributeName"),
-			//
-			// 			},
-			// 			ProjectionType: aws.String("ProjectionType"),
-			// 		},
-			// 	},
-			// },
-			// StreamSpecification: &dynamodb.StreamSpecification{
-			// 	StreamEnabled:  aws.Bool(true),
-			// 	StreamViewType: aws.String("StreamViewType"),
-			// },
-		}
-
-		if _, err := db.DB.CreateTable(tableParams); err != nil {
-			return err
 		}
+	}
 
-		// tables with secondary indexes need to be created sequentially so wait till table is ready
-		if err := db.DB.WaitUntilTableExists(&dynamodb.DescribeTableInput{TableName: aws.String(tbl)}); err != nil {
-			return err
+	// contacts table is keyed by user and contact ID, so the contacts of a user are listed in order,
+	// and has a secondary contact index for listing the users who have a contact
+	if tbl == "contacts" {
+		return &dynamodb.CreateTableInput{
+			TableName: aws.String(tbl),
+			ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+				ReadCapacityUnits:  aws.Int64(1),
+				WriteCapacityUnits: aws.Int64(1),
+			},
+			AttributeDefinitions: []*dynamodb.AttributeDefinition{
+				{
+					AttributeName: aws.String("UserID"),
+					AttributeType: aws.String("S"),
+				},
+				{
+					AttributeName: aws.String("ContactID"),
+					AttributeType: aws.String("S"),
+				},
+			},
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{
+					AttributeName: aws.String("UserID"),
+					KeyType:       aws.String("HASH"),
+				},
+				{
+					AttributeName: aws.String("ContactID"),
+					KeyType:       aws.String("RANGE"),
+				},
+			},
+			GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+				{
+					IndexName: aws.String("ContactID"),
+					KeySchema: []*dynamodb.KeySchemaElement{
+						{
+							AttributeName: aws.String("ContactID"),
+							KeyType:       aws.String("HASH"),
+						},
+					},
+					Projection: &dynamodb.Projection{
+						ProjectionType: aws.String("KEYS_ONLY"),
+					},
+					ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+						ReadCapacityUnits:  aws.Int64(1),
+						WriteCapacityUnits: aws.Int64(1),
+					},
+				},
+			},
 		}
 	}
 
-	// insert the seed data
-	if err := data.SeedInit(jwtPass); err != nil {
-		return err
+	// per-user list tables are keyed by user ID and the list item ID, so the list of a user is retrieved in order
+	if attr, ok := listAttrs[tbl]; ok {
+		return &dynamodb.CreateTableInput{
+			TableName: aws.String(tbl),
+			ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+				ReadCapacityUnits:  aws.Int64(1),
+				WriteCapacityUnits: aws.Int64(1),
+			},
+			AttributeDefinitions: []*dynamodb.AttributeDefinition{
+				{
+					AttributeName: aws.String("UserID"),
+					AttributeType: aws.String("S"),
+				},
+				{
+					AttributeName: aws.String(attr),
+					AttributeType: aws.String("S"),
+				},
+			},
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{
+					AttributeName: aws.String("UserID"),
+					KeyType:       aws.String("HASH"),
+				},
+				{
+					AttributeName: aws.String(attr),
+					KeyType:       aws.String("RANGE"),
+				},
+			},
+		}
 	}
 
-	for _, u := range data.SeedUsers {
-		if err := db.SaveUser(&u); err != nil {
-			return err
+	// attachments table has a secondary owner index for storage quota accounting, and refresh_tokens table has a secondary user index
+	// for listing the devices of a user
+	if idx, ok := map[string]string{"attachments": "Owner", "refresh_tokens": "UserID"}[tbl]; ok {
+		return &dynamodb.CreateTableInput{
+			TableName: aws.String(tbl),
+			ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+				ReadCapacityUnits:  aws.Int64(1),
+				WriteCapacityUnits: aws.Int64(1),
+			},
+			AttributeDefinitions: []*dynamodb.AttributeDefinition{
+				{
+					AttributeName: aws.String("ID"),
+					AttributeType: aws.String("S"),
+				},
+				{
+					AttributeName: aws.String(idx),
+					AttributeType: aws.String("S"),
+				},
+			},
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{
+					AttributeName: aws.String("ID"),
+					KeyType:       aws.String("HASH"),
+				},
+			},
+			GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+				{
+					IndexName: aws.String(idx),
+					KeySchema: []*dynamodb.KeySchemaElement{
+						{
+							AttributeName: aws.String(idx),
+							KeyType:       aws.String("HASH"),
+						},
+					},
+					Projection: &dynamodb.Projection{
+						ProjectionType: aws.String("ALL"),
+					},
+					ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+						ReadCapacityUnits:  aws.Int64(1),
+						WriteCapacityUnits: aws.Int64(1),
+					},
+				},
+			},
 		}
 	}
 
-	return nil
+	// users table has a secondary e-mail index while the rest of the tables only have ID as the hash key
+	if tbl != "users" {
+		return &dynamodb.CreateTableInput{
+			TableName: aws.String(tbl),
+			ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+				ReadCapacityUnits:  aws.Int64(1),
+				WriteCapacityUnits: aws.Int64(1),
+			},
+			AttributeDefinitions: []*dynamodb.AttributeDefinition{
+				{
+					AttributeName: aws.String("ID"),
+					AttributeType: aws.String("S"),
+				},
+			},
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{
+					AttributeName: aws.String("ID"),
+					KeyType:       aws.String("HASH"),
+				},
+			},
+		}
+	}
+
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String("users"),
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("ID"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("Email"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("PhoneNumber"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("ID"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("Email"),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("Email"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				Projection: &dynamodb.Projection{
+					NonKeyAttributes: []*string{
+						aws.String("Email"),
+					},
+					ProjectionType: aws.String("INCLUDE"),
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(1),
+					WriteCapacityUnits: aws.Int64(1),
+				},
+			},
+			{
+				// sparse index as users without a verified phone number do not have the attribute
+				IndexName: aws.String("PhoneNumber"),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("PhoneNumber"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				Projection: &dynamodb.Projection{
+					ProjectionType: aws.String("ALL"),
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(1),
+					WriteCapacityUnits: aws.Int64(1),
+				},
+			},
+		},
+		// LocalSecondaryIndexes: []*dynamodb.LocalSecondaryIndex{
+		// 	{
+		// 		IndexName: aws.String("IndexName"),
+		// 		KeySchema: []*dynamodb.KeySchemaElement{
+		// 			{
+		// 				AttributeName: aws.String("KeySchemaAttributeName"),
+		// 				KeyType:       aws.String("KeyType"),
+		// 			},
+		//
+		// 		},
+		// 		Projection: &dynamodb.Projection{
+		// 			NonKeyAttributes: []*string{
+		// 				aws.String("NonKeyAttributeName"),
+		//
+		// 			},
+		// 			ProjectionType: aws.String("ProjectionType"),
+		// 		},
+		// 	},
+		// },
+		// StreamSpecification: &dynamodb.StreamSpecification{
+		// 	StreamEnabled:  aws.Bool(true),
+		// 	StreamViewType: aws.String("StreamViewType"),
+		// },
+	}
 }
 
 // GetByID retrieves a user by ID with OK indicator.
@@ -216,7 +508,7 @@ func (db *DynamoDB) GetByID(id string) (u *models.User, ok bool) {
 		},
 	})
 	if err != nil {
-		log.Printf("dynamodb: error: %v", err)
+		logger.Errorf("error: %v", err)
 		return nil, false
 	}
 	if len(res.Item) == 0 {
@@ -225,7 +517,7 @@ func (db *DynamoDB) GetByID(id string) (u *models.User, ok bool) {
 
 	var user models.User
 	if err := dynamodbattribute.UnmarshalMap(res.Item, &user); err != nil {
-		log.Printf("dynamodb: getbyid error: %v", err)
+		logger.Errorf("getbyid error: %v", err)
 		return nil, false
 	}
 
@@ -247,13 +539,43 @@ func (db *DynamoDB) GetByEmail(email string) (u *models.User, ok bool) {
 		},
 	})
 	if err != nil {
-		log.Printf("dynamodb: getbymail error: %v", err)
+		logger.Errorf("getbymail error: %v", err)
+		return nil, false
+	}
+
+	var user models.User
+	if err := dynamodbattribute.UnmarshalMap(res.Items[0], &user); err != nil {
+		logger.Errorf("getbyid error: %v", err)
+		return nil, false
+	}
+
+	return &user, true
+}
+
+// GetByPhone retrieves a user by phone number with OK indicator.
+func (db *DynamoDB) GetByPhone(phone string) (u *models.User, ok bool) {
+	res, err := db.DB.Query(&dynamodb.QueryInput{
+		TableName:              aws.String("users"),
+		IndexName:              aws.String("PhoneNumber"),
+		Select:                 aws.String("ALL_ATTRIBUTES"),
+		KeyConditionExpression: aws.String("PhoneNumber = :PhoneNumber"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":PhoneNumber": {
+				S: aws.String(phone),
+			},
+		},
+	})
+	if err != nil {
+		logger.Errorf("getbyphone error: %v", err)
+		return nil, false
+	}
+	if len(res.Items) == 0 {
 		return nil, false
 	}
 
 	var user models.User
 	if err := dynamodbattribute.UnmarshalMap(res.Items[0], &user); err != nil {
-		log.Printf("dynamodb: getbyid error: %v", err)
+		logger.Errorf("getbyphone error: %v", err)
 		return nil, false
 	}
 
@@ -275,6 +597,10 @@ func (db *DynamoDB) SaveUser(u *models.User) error {
 	if err != nil {
 		return err
 	}
+	if u.PhoneNumber == "" {
+		// index keys cannot be null, so users without a phone number are left out of the PhoneNumber index
+		delete(item, "PhoneNumber")
+	}
 
 	_, err = db.DB.PutItem(&dynamodb.PutItemInput{
 		TableName: aws.String("users"),
@@ -286,3 +612,1199 @@ func (db *DynamoDB) SaveUser(u *models.User) error {
 
 	return nil
 }
+
+// GetUserIDs retrieves up to limit user IDs that come after the given one in the scan order of the users table.
+func (db *DynamoDB) GetUserIDs(after string, limit int) ([]string, error) {
+	sc := &dynamodb.ScanInput{
+		TableName:            aws.String("users"),
+		ProjectionExpression: aws.String("ID"),
+	}
+	if after != "" {
+		sc.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(after),
+			},
+		}
+	}
+
+	ids := []string{}
+	for len(ids) < limit {
+		sc.Limit = aws.Int64(int64(limit - len(ids)))
+		res, err := db.DB.Scan(sc)
+		if err != nil {
+			return nil, fmt.Errorf("dynamodb: failed to get user ids: %v", err)
+		}
+
+		for _, item := range res.Items {
+			if id := item["ID"]; id != nil && id.S != nil {
+				ids = append(ids, *id.S)
+			}
+		}
+
+		if len(res.LastEvaluatedKey) == 0 {
+			break
+		}
+		sc.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+
+	return ids, nil
+}
+
+// GetDueDeletions retrieves the IDs of the users whose accounts are to be deleted at or before the given time.
+// Users table is scanned, as the deletions are rare and checked only periodically.
+func (db *DynamoDB) GetDueDeletions(t time.Time) ([]string, error) {
+	sc := &dynamodb.ScanInput{
+		TableName:            aws.String("users"),
+		ProjectionExpression: aws.String("ID, DeleteAt"),
+		FilterExpression:     aws.String("DeleteAt <> :Zero"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":Zero": {
+				S: aws.String(time.Time{}.Format(time.RFC3339Nano)),
+			},
+		},
+	}
+
+	ids := []string{}
+	for {
+		res, err := db.DB.Scan(sc)
+		if err != nil {
+			return nil, fmt.Errorf("dynamodb: failed to get due deletions: %v", err)
+		}
+
+		for _, item := range res.Items {
+			var u models.User
+			if err := dynamodbattribute.UnmarshalMap(item, &u); err != nil {
+				return nil, fmt.Errorf("dynamodb: failed to read due deletions: %v", err)
+			}
+			if !u.DeleteAt.IsZero() && !u.DeleteAt.After(t) {
+				ids = append(ids, u.ID)
+			}
+		}
+
+		if len(res.LastEvaluatedKey) == 0 {
+			sort.Strings(ids)
+			return ids, nil
+		}
+		sc.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+}
+
+// DeleteUser deletes a user along with the refresh tokens, the encryption keys, and the second factor of the user.
+func (db *DynamoDB) DeleteUser(id string) error {
+	ts, err := db.GetRefreshTokens(id)
+	if err != nil {
+		return err
+	}
+	for _, t := range ts {
+		if err := db.deleteByID("refresh_tokens", t.ID); err != nil {
+			return fmt.Errorf("dynamodb: failed to delete refresh token: %v", err)
+		}
+	}
+	if err := db.deleteByID("keys", id); err != nil {
+		return fmt.Errorf("dynamodb: failed to delete keys: %v", err)
+	}
+	if err := db.DeleteTOTP(id); err != nil {
+		return fmt.Errorf("dynamodb: failed to delete totp: %v", err)
+	}
+	if err := db.deleteByID("users", id); err != nil {
+		return fmt.Errorf("dynamodb: failed to delete user: %v", err)
+	}
+	return nil
+}
+
+func (db *DynamoDB) deleteByID(tbl, id string) error {
+	_, err := db.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(id),
+			},
+		},
+	})
+	return err
+}
+
+// GetGroup retrieves a group by ID with OK indicator.
+func (db *DynamoDB) GetGroup(id string) (g *models.Group, ok bool) {
+	res, err := db.DB.GetItem(&dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String("groups"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(id),
+			},
+		},
+	})
+	if err != nil {
+		logger.Errorf("getgroup error: %v", err)
+		return nil, false
+	}
+	if len(res.Item) == 0 {
+		return nil, false
+	}
+
+	var group models.Group
+	if err := dynamodbattribute.UnmarshalMap(res.Item, &group); err != nil {
+		logger.Errorf("getgroup error: %v", err)
+		return nil, false
+	}
+
+	return &group, true
+}
+
+// SaveGroup creates or updates a group. Upon creation, groups are assigned a unique ID.
+func (db *DynamoDB) SaveGroup(g *models.Group) error {
+	if g.ID == "" {
+		id, err := shortid.ID(64)
+		if err != nil {
+			return err
+		}
+
+		g.ID = id
+	}
+
+	item, err := dynamodbattribute.MarshalMap(g)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("groups"),
+		Item:      item,
+	})
+	return err
+}
+
+// GetRefreshToken retrieves a refresh token by ID with OK indicator.
+func (db *DynamoDB) GetRefreshToken(id string) (t *models.RefreshToken, ok bool) {
+	res, err := db.DB.GetItem(&dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String("refresh_tokens"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(id),
+			},
+		},
+	})
+	if err != nil {
+		logger.Errorf("getrefreshtoken error: %v", err)
+		return nil, false
+	}
+	if len(res.Item) == 0 {
+		return nil, false
+	}
+
+	var token models.RefreshToken
+	if err := dynamodbattribute.UnmarshalMap(res.Item, &token); err != nil {
+		logger.Errorf("getrefreshtoken error: %v", err)
+		return nil, false
+	}
+
+	return &token, true
+}
+
+// SaveRefreshToken creates or updates a refresh token.
+func (db *DynamoDB) SaveRefreshToken(t *models.RefreshToken) error {
+	item, err := dynamodbattribute.MarshalMap(t)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("refresh_tokens"),
+		Item:      item,
+	})
+	return err
+}
+
+// GetRefreshTokens retrieves all the refresh tokens of a user.
+func (db *DynamoDB) GetRefreshTokens(userID string) ([]models.RefreshToken, error) {
+	q := &dynamodb.QueryInput{
+		TableName:              aws.String("refresh_tokens"),
+		IndexName:              aws.String("UserID"),
+		KeyConditionExpression: aws.String("#UserID = :UserID"),
+		ExpressionAttributeNames: map[string]*string{
+			"#UserID": aws.String("UserID"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":UserID": {
+				S: aws.String(userID),
+			},
+		},
+	}
+
+	ts := []models.RefreshToken{}
+	for {
+		res, err := db.DB.Query(q)
+		if err != nil {
+			return nil, fmt.Errorf("dynamodb: failed to get refresh tokens: %v", err)
+		}
+
+		for _, item := range res.Items {
+			var t models.RefreshToken
+			if err := dynamodbattribute.UnmarshalMap(item, &t); err != nil {
+				return nil, fmt.Errorf("dynamodb: failed to read refresh tokens: %v", err)
+			}
+			ts = append(ts, t)
+		}
+
+		if len(res.LastEvaluatedKey) == 0 {
+			return ts, nil
+		}
+		q.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+}
+
+// GetMessage retrieves a message by ID with OK indicator.
+func (db *DynamoDB) GetMessage(id string) (m *models.Message, ok bool) {
+	res, err := db.DB.GetItem(&dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String("messages"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(id),
+			},
+		},
+	})
+	if err != nil {
+		logger.Errorf("getmessage error: %v", err)
+		return nil, false
+	}
+	if len(res.Item) == 0 {
+		return nil, false
+	}
+
+	var msg models.Message
+	if err := dynamodbattribute.UnmarshalMap(res.Item, &msg); err != nil {
+		logger.Errorf("getmessage error: %v", err)
+		return nil, false
+	}
+
+	return &msg, true
+}
+
+// SaveMessage creates or updates a message.
+func (db *DynamoDB) SaveMessage(m *models.Message) error {
+	item, err := dynamodbattribute.MarshalMap(m)
+	if err != nil {
+		return err
+	}
+	item["Seq"] = msgSeq(m)
+
+	_, err = db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("messages"),
+		Item:      item,
+	})
+	return err
+}
+
+// GetMessages retrieves the messages of a conversation sent at or before the given time, newest first, skipping the first offset messages.
+// DynamoDB does not support offsets so skipped messages are read and discarded.
+func (db *DynamoDB) GetMessages(conversation string, before time.Time, offset, limit int) ([]models.Message, error) {
+	q := &dynamodb.QueryInput{
+		TableName:              aws.String("messages"),
+		IndexName:              aws.String("Conversation"),
+		Select:                 aws.String("ALL_ATTRIBUTES"),
+		ScanIndexForward:       aws.Bool(false),
+		KeyConditionExpression: aws.String("Conversation = :Conversation AND Seq <= :Seq"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":Conversation": {
+				S: aws.String(conversation),
+			},
+			":Seq": msgSeq(&models.Message{Time: before}),
+		},
+	}
+
+	msgs := []models.Message{}
+	for {
+		q.Limit = aws.Int64(int64(offset + limit - len(msgs)))
+		res, err := db.DB.Query(q)
+		if err != nil {
+			return nil, fmt.Errorf("dynamodb: failed to get messages: %v", err)
+		}
+
+		for _, item := range res.Items {
+			if offset > 0 {
+				offset--
+				continue
+			}
+
+			var m models.Message
+			if err := dynamodbattribute.UnmarshalMap(item, &m); err != nil {
+				return nil, fmt.Errorf("dynamodb: failed to read messages: %v", err)
+			}
+			msgs = append(msgs, m)
+		}
+
+		if len(msgs) >= limit || len(res.LastEvaluatedKey) == 0 {
+			return msgs, nil
+		}
+		q.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+}
+
+// DeleteUserMessages deletes the messages sent by a user, along with the search index of the user. Messages table is scanned
+// for the messages of the user. Index items of the other users for the deleted messages are left to be skipped by the searches.
+func (db *DynamoDB) DeleteUserMessages(userID string) error {
+	sc := &dynamodb.ScanInput{
+		TableName:            aws.String("messages"),
+		ProjectionExpression: aws.String("ID"),
+		FilterExpression:     aws.String("#From = :From"),
+		ExpressionAttributeNames: map[string]*string{
+			"#From": aws.String("From"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":From": {
+				S: aws.String(userID),
+			},
+		},
+	}
+
+	for {
+		res, err := db.DB.Scan(sc)
+		if err != nil {
+			return fmt.Errorf("dynamodb: failed to get messages: %v", err)
+		}
+
+		for _, item := range res.Items {
+			if id := item["ID"]; id != nil && id.S != nil {
+				if err := db.deleteByID("messages", *id.S); err != nil {
+					return fmt.Errorf("dynamodb: failed to delete message: %v", err)
+				}
+			}
+		}
+
+		if len(res.LastEvaluatedKey) == 0 {
+			break
+		}
+		sc.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+
+	ids, err := db.queryStrings("message_index", "", "UserID", "MessageID", userID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := db.removeListItem("message_index", userID, id); err != nil {
+			return fmt.Errorf("dynamodb: failed to delete message index: %v", err)
+		}
+	}
+	return nil
+}
+
+// UpdateDeliveryState sets the latest delivery state of a message. Message is not created if it does not exist.
+func (db *DynamoDB) UpdateDeliveryState(id, state string) error {
+	_, err := db.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String("messages"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(id),
+			},
+		},
+		UpdateExpression:    aws.String("SET #State = :State"),
+		ConditionExpression: aws.String("attribute_exists(ID)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#State": aws.String("State"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":State": {
+				S: aws.String(state),
+			},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("dynamodb: failed to update message state: %v", err)
+	}
+	return nil
+}
+
+// DeleteMessagesBefore deletes the messages sent before the given time. Messages table is scanned for the old messages.
+// Index items of the deleted messages are left to be skipped by the searches.
+func (db *DynamoDB) DeleteMessagesBefore(t time.Time) (int, error) {
+	sc := &dynamodb.ScanInput{
+		TableName:            aws.String("messages"),
+		ProjectionExpression: aws.String("ID"),
+		FilterExpression:     aws.String("Seq < :Seq"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":Seq": msgSeq(&models.Message{Time: t}),
+		},
+	}
+
+	n := 0
+	for {
+		res, err := db.DB.Scan(sc)
+		if err != nil {
+			return n, fmt.Errorf("dynamodb: failed to get messages: %v", err)
+		}
+
+		for _, item := range res.Items {
+			if id := item["ID"]; id != nil && id.S != nil {
+				if err := db.deleteByID("messages", *id.S); err != nil {
+					return n, fmt.Errorf("dynamodb: failed to delete message: %v", err)
+				}
+				n++
+			}
+		}
+
+		if len(res.LastEvaluatedKey) == 0 {
+			return n, nil
+		}
+		sc.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+}
+
+// indexItem is the item of a message indexed for a user in the message_index table, keyed by user and message ID.
+type indexItem struct {
+	UserID       string
+	MessageID    string
+	Conversation string
+	Terms        map[string]int // words of the message body -> occurrences
+}
+
+// IndexMessage indexes the body of a message for the given users.
+func (db *DynamoDB) IndexMessage(m *models.Message, userIDs []string) error {
+	for _, uid := range userIDs {
+		item, err := dynamodbattribute.MarshalMap(indexItem{UserID: uid, MessageID: m.ID, Conversation: m.Conversation, Terms: data.SearchTerms(m.Message)})
+		if err != nil {
+			return err
+		}
+		item["Seq"] = msgSeq(m)
+
+		if _, err := db.DB.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String("message_index"),
+			Item:      item,
+		}); err != nil {
+			return fmt.Errorf("dynamodb: failed to index message: %v", err)
+		}
+	}
+	return nil
+}
+
+// SearchMessages retrieves the messages indexed for a user which contain all the words of the query, ordered by relevance, then newest first.
+// DynamoDB does not support full-text search so all the messages indexed for the user are read and matched.
+func (db *DynamoDB) SearchMessages(userID, query, conversation string, before time.Time, offset, limit int) ([]models.Message, error) {
+	q := &dynamodb.QueryInput{
+		TableName:              aws.String("message_index"),
+		KeyConditionExpression: aws.String("UserID = :UserID"),
+		FilterExpression:       aws.String("Seq <= :Seq"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":UserID": {
+				S: aws.String(userID),
+			},
+			":Seq": msgSeq(&models.Message{Time: before}),
+		},
+	}
+	if conversation != "" {
+		q.FilterExpression = aws.String("Seq <= :Seq AND Conversation = :Conversation")
+		q.ExpressionAttributeValues[":Conversation"] = &dynamodb.AttributeValue{S: aws.String(conversation)}
+	}
+
+	type match struct {
+		id         string
+		seq, score int64
+	}
+
+	terms := data.SearchTerms(query)
+	var ms []match
+	for {
+		res, err := db.DB.Query(q)
+		if err != nil {
+			return nil, fmt.Errorf("dynamodb: failed to search messages: %v", err)
+		}
+
+		for _, item := range res.Items {
+			var it indexItem
+			if err := dynamodbattribute.UnmarshalMap(item, &it); err != nil {
+				return nil, fmt.Errorf("dynamodb: failed to read message index: %v", err)
+			}
+			if score := data.SearchScore(it.Terms, terms); score > 0 {
+				var seq int64
+				if n := item["Seq"]; n != nil {
+					seq, _ = strconv.ParseInt(aws.StringValue(n.N), 10, 64)
+				}
+				ms = append(ms, match{id: it.MessageID, seq: seq, score: int64(score)})
+			}
+		}
+
+		if len(res.LastEvaluatedKey) == 0 {
+			break
+		}
+		q.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].score != ms[j].score {
+			return ms[i].score > ms[j].score
+		}
+		return ms[i].seq > ms[j].seq
+	})
+
+	msgs := []models.Message{}
+	for i := offset; i < len(ms) && len(msgs) < limit; i++ {
+		if m, ok := db.GetMessage(ms[i].id); ok {
+			msgs = append(msgs, *m)
+		}
+	}
+	return msgs, nil
+}
+
+// keysItem is the item of a user's key bundle in the keys table, with the one-time prekeys in the order of upload.
+type keysItem struct {
+	ID           string // user ID
+	IdentityKey  []byte
+	SignedPreKey *models.SignedPreKey
+	PreKeys      []models.PreKey
+}
+
+func (it *keysItem) bundle() *models.KeyBundle {
+	return &models.KeyBundle{UserID: it.ID, IdentityKey: it.IdentityKey, SignedPreKey: it.SignedPreKey}
+}
+
+// SaveKeys replaces the identity key and the signed prekey of a user, and adds the one-time prekeys.
+// Existing one-time prekeys are dropped if the identity key changes.
+func (db *DynamoDB) SaveKeys(b *models.KeyBundle) error {
+	it, _, err := db.getKeys(b.UserID)
+	if err != nil {
+		return err
+	}
+
+	pks := []*dynamodb.AttributeValue{}
+	for _, pk := range b.PreKeys {
+		av, err := dynamodbattribute.Marshal(pk)
+		if err != nil {
+			return err
+		}
+		pks = append(pks, av)
+	}
+	spk, err := dynamodbattribute.Marshal(b.SignedPreKey)
+	if err != nil {
+		return err
+	}
+
+	expr := "SET IdentityKey = :IdentityKey, SignedPreKey = :SignedPreKey, PreKeys = list_append(if_not_exists(PreKeys, :Empty), :PreKeys)"
+	if !bytes.Equal(it.IdentityKey, b.IdentityKey) {
+		expr = "SET IdentityKey = :IdentityKey, SignedPreKey = :SignedPreKey, PreKeys = list_append(:Empty, :PreKeys)"
+	}
+
+	_, err = db.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String("keys"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(b.UserID),
+			},
+		},
+		UpdateExpression: aws.String(expr),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":IdentityKey":  {B: b.IdentityKey},
+			":SignedPreKey": spk,
+			":Empty":        {L: []*dynamodb.AttributeValue{}},
+			":PreKeys":      {L: pks},
+		},
+	})
+	return err
+}
+
+// GetKeys retrieves the identity key and the signed prekey of a user.
+func (db *DynamoDB) GetKeys(userID string) (b *models.KeyBundle, ok bool, err error) {
+	it, ok, err := db.getKeys(userID)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	return it.bundle(), true, nil
+}
+
+// ClaimKeys retrieves the key bundle of a user along with the oldest one-time prekey, if any, which is removed.
+// Prekey is removed from the head of the list atomically, so concurrent claims never get the same prekey.
+func (db *DynamoDB) ClaimKeys(userID string) (b *models.KeyBundle, ok bool, err error) {
+	res, err := db.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String("keys"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(userID),
+			},
+		},
+		UpdateExpression:    aws.String("REMOVE PreKeys[0]"),
+		ConditionExpression: aws.String("size(PreKeys) > :Zero"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":Zero": {
+				N: aws.String("0"),
+			},
+		},
+		ReturnValues: aws.String("ALL_OLD"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		// there are no prekeys left, or no keys at all
+		return db.GetKeys(userID)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("dynamodb: failed to claim prekey: %v", err)
+	}
+
+	var it keysItem
+	if err := dynamodbattribute.UnmarshalMap(res.Attributes, &it); err != nil {
+		return nil, false, fmt.Errorf("dynamodb: failed to read keys: %v", err)
+	}
+	kb := it.bundle()
+	kb.PreKeys = it.PreKeys[:1]
+	return kb, true, nil
+}
+
+// CountPreKeys returns the number of one-time prekeys left for a user.
+func (db *DynamoDB) CountPreKeys(userID string) (int, error) {
+	it, _, err := db.getKeys(userID)
+	if err != nil {
+		return 0, err
+	}
+	return len(it.PreKeys), nil
+}
+
+func (db *DynamoDB) getKeys(userID string) (it *keysItem, ok bool, err error) {
+	res, err := db.DB.GetItem(&dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String("keys"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(userID),
+			},
+		},
+	})
+	if err != nil {
+		return &keysItem{}, false, fmt.Errorf("dynamodb: failed to get keys: %v", err)
+	}
+	if len(res.Item) == 0 {
+		return &keysItem{}, false, nil
+	}
+
+	it = &keysItem{}
+	if err := dynamodbattribute.UnmarshalMap(res.Item, it); err != nil {
+		return &keysItem{}, false, fmt.Errorf("dynamodb: failed to read keys: %v", err)
+	}
+	return it, true, nil
+}
+
+// GetAttachment retrieves the metadata of an attachment by ID.
+func (db *DynamoDB) GetAttachment(id string) (a *models.Attachment, ok bool, err error) {
+	res, err := db.DB.GetItem(&dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String("attachments"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(id),
+			},
+		},
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("dynamodb: failed to get attachment: %v", err)
+	}
+	if len(res.Item) == 0 {
+		return nil, false, nil
+	}
+
+	var at models.Attachment
+	if err := dynamodbattribute.UnmarshalMap(res.Item, &at); err != nil {
+		return nil, false, fmt.Errorf("dynamodb: failed to read attachment: %v", err)
+	}
+	return &at, true, nil
+}
+
+// SaveAttachment creates or updates the metadata of an attachment.
+func (db *DynamoDB) SaveAttachment(a *models.Attachment) error {
+	item, err := dynamodbattribute.MarshalMap(a)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("attachments"),
+		Item:      item,
+	})
+	return err
+}
+
+// DeleteAttachment deletes the metadata of an attachment, if any.
+func (db *DynamoDB) DeleteAttachment(id string) error {
+	_, err := db.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String("attachments"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(id),
+			},
+		},
+	})
+	return err
+}
+
+// GetAttachments retrieves all the attachments of a user.
+func (db *DynamoDB) GetAttachments(owner string) ([]models.Attachment, error) {
+	q := &dynamodb.QueryInput{
+		TableName:              aws.String("attachments"),
+		IndexName:              aws.String("Owner"),
+		KeyConditionExpression: aws.String("#Owner = :Owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#Owner": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":Owner": {
+				S: aws.String(owner),
+			},
+		},
+	}
+
+	as := []models.Attachment{}
+	for {
+		res, err := db.DB.Query(q)
+		if err != nil {
+			return nil, fmt.Errorf("dynamodb: failed to get attachments: %v", err)
+		}
+
+		for _, item := range res.Items {
+			var a models.Attachment
+			if err := dynamodbattribute.UnmarshalMap(item, &a); err != nil {
+				return nil, fmt.Errorf("dynamodb: failed to read attachments: %v", err)
+			}
+			as = append(as, a)
+		}
+
+		if len(res.LastEvaluatedKey) == 0 {
+			return as, nil
+		}
+		q.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+}
+
+// topicItem is the item of a topic in the topics table, keyed by the topic name.
+type topicItem struct {
+	ID          string // topic name
+	Description string
+	Created     time.Time
+}
+
+// GetTopic retrieves a topic by name.
+func (db *DynamoDB) GetTopic(name string) (t *models.Topic, ok bool, err error) {
+	res, err := db.DB.GetItem(&dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String("topics"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(name),
+			},
+		},
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("dynamodb: failed to get topic: %v", err)
+	}
+	if len(res.Item) == 0 {
+		return nil, false, nil
+	}
+
+	var it topicItem
+	if err := dynamodbattribute.UnmarshalMap(res.Item, &it); err != nil {
+		return nil, false, fmt.Errorf("dynamodb: failed to read topic: %v", err)
+	}
+
+	return &models.Topic{Name: it.ID, Description: it.Description, Created: it.Created}, true, nil
+}
+
+// SaveTopic creates or updates a topic.
+func (db *DynamoDB) SaveTopic(t *models.Topic) error {
+	item, err := dynamodbattribute.MarshalMap(topicItem{ID: t.Name, Description: t.Description, Created: t.Created})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("topics"),
+		Item:      item,
+	})
+	return err
+}
+
+// Subscribe adds a user to the subscribers of a topic.
+func (db *DynamoDB) Subscribe(topic, userID string) error {
+	_, err := db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("topic_subscribers"),
+		Item:      subscriberKey(topic, userID),
+	})
+	return err
+}
+
+// Unsubscribe removes a user from the subscribers of a topic.
+func (db *DynamoDB) Unsubscribe(topic, userID string) error {
+	_, err := db.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String("topic_subscribers"),
+		Key:       subscriberKey(topic, userID),
+	})
+	return err
+}
+
+// GetSubscribers retrieves up to limit subscriber IDs of a topic that come after the given one, in ascending order.
+func (db *DynamoDB) GetSubscribers(topic, after string, limit int) ([]string, error) {
+	q := &dynamodb.QueryInput{
+		TableName:              aws.String("topic_subscribers"),
+		KeyConditionExpression: aws.String("Topic = :Topic"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":Topic": {
+				S: aws.String(topic),
+			},
+		},
+	}
+	if after != "" {
+		q.ExclusiveStartKey = subscriberKey(topic, after)
+	}
+
+	ids := []string{}
+	for len(ids) < limit {
+		q.Limit = aws.Int64(int64(limit - len(ids)))
+		res, err := db.DB.Query(q)
+		if err != nil {
+			return nil, fmt.Errorf("dynamodb: failed to get subscribers: %v", err)
+		}
+
+		for _, item := range res.Items {
+			if id := item["UserID"]; id != nil && id.S != nil {
+				ids = append(ids, *id.S)
+			}
+		}
+
+		if len(res.LastEvaluatedKey) == 0 {
+			break
+		}
+		q.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+
+	return ids, nil
+}
+
+// GetSubscriptions retrieves the names of the topics a user is subscribed to.
+func (db *DynamoDB) GetSubscriptions(userID string) ([]string, error) {
+	q := &dynamodb.QueryInput{
+		TableName:              aws.String("topic_subscribers"),
+		IndexName:              aws.String("UserID"),
+		KeyConditionExpression: aws.String("UserID = :UserID"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":UserID": {
+				S: aws.String(userID),
+			},
+		},
+	}
+
+	names := []string{}
+	for {
+		res, err := db.DB.Query(q)
+		if err != nil {
+			return nil, fmt.Errorf("dynamodb: failed to get subscriptions: %v", err)
+		}
+
+		for _, item := range res.Items {
+			if t := item["Topic"]; t != nil && t.S != nil {
+				names = append(names, *t.S)
+			}
+		}
+
+		if len(res.LastEvaluatedKey) == 0 {
+			return names, nil
+		}
+		q.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+}
+
+// subscriberKey returns the key of a user's subscription to a topic in the topic_subscribers table.
+func subscriberKey(topic, userID string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Topic": {
+			S: aws.String(topic),
+		},
+		"UserID": {
+			S: aws.String(userID),
+		},
+	}
+}
+
+// AddContact adds a user to the contacts of another.
+func (db *DynamoDB) AddContact(userID, contactID string) error {
+	_, err := db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("contacts"),
+		Item:      contactKey(userID, contactID),
+	})
+	return err
+}
+
+// RemoveContact removes a user from the contacts of another.
+func (db *DynamoDB) RemoveContact(userID, contactID string) error {
+	_, err := db.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String("contacts"),
+		Key:       contactKey(userID, contactID),
+	})
+	return err
+}
+
+// GetContacts retrieves the IDs of the contacts of a user, in ascending order.
+func (db *DynamoDB) GetContacts(userID string) ([]string, error) {
+	return db.queryStrings("contacts", "", "UserID", "ContactID", userID)
+}
+
+// GetContactOf retrieves the IDs of the users who have the given user in their contacts, in ascending order.
+func (db *DynamoDB) GetContactOf(contactID string) ([]string, error) {
+	// secondary index is not sorted by user ID
+	ids, err := db.queryStrings("contacts", "ContactID", "ContactID", "UserID", contactID)
+	sort.Strings(ids)
+	return ids, err
+}
+
+// queryStrings retrieves the given attribute of the items in the given table, or in the given index of it,
+// with the given key attribute value.
+func (db *DynamoDB) queryStrings(tbl, index, key, attr, val string) ([]string, error) {
+	q := &dynamodb.QueryInput{
+		TableName:              aws.String(tbl),
+		KeyConditionExpression: aws.String(key + " = :" + key),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":" + key: {
+				S: aws.String(val),
+			},
+		},
+	}
+	if index != "" {
+		q.IndexName = aws.String(index)
+	}
+
+	ids := []string{}
+	for {
+		res, err := db.DB.Query(q)
+		if err != nil {
+			return nil, fmt.Errorf("dynamodb: failed to query %v: %v", tbl, err)
+		}
+
+		for _, item := range res.Items {
+			if id := item[attr]; id != nil && id.S != nil {
+				ids = append(ids, *id.S)
+			}
+		}
+
+		if len(res.LastEvaluatedKey) == 0 {
+			return ids, nil
+		}
+		q.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+}
+
+// HasContact tells whether a user is in the contacts of another.
+func (db *DynamoDB) HasContact(userID, contactID string) (bool, error) {
+	res, err := db.DB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String("contacts"),
+		Key:       contactKey(userID, contactID),
+	})
+	if err != nil {
+		return false, fmt.Errorf("dynamodb: failed to get contact: %v", err)
+	}
+	return len(res.Item) != 0, nil
+}
+
+// contactKey returns the key of a contact of a user in the contacts table.
+func contactKey(userID, contactID string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"UserID": {
+			S: aws.String(userID),
+		},
+		"ContactID": {
+			S: aws.String(contactID),
+		},
+	}
+}
+
+// listAttrs are the item ID attributes of the per-user list tables, which are keyed by user ID and the item ID.
+var listAttrs = map[string]string{"blocks": "BlockedID", "mutes": "Conversation", "message_index": "MessageID"}
+
+// Block adds a user to the users blocked by another.
+func (db *DynamoDB) Block(userID, blockedID string) error {
+	return db.addListItem("blocks", userID, blockedID)
+}
+
+// Unblock removes a user from the users blocked by another.
+func (db *DynamoDB) Unblock(userID, blockedID string) error {
+	return db.removeListItem("blocks", userID, blockedID)
+}
+
+// GetBlocked retrieves the IDs of the users blocked by a user, in ascending order.
+func (db *DynamoDB) GetBlocked(userID string) ([]string, error) {
+	return db.queryStrings("blocks", "", "UserID", listAttrs["blocks"], userID)
+}
+
+// IsBlocked tells whether a user is blocked by another.
+func (db *DynamoDB) IsBlocked(userID, blockedID string) (bool, error) {
+	return db.hasListItem("blocks", userID, blockedID)
+}
+
+// Mute adds a conversation to the conversations muted by a user.
+func (db *DynamoDB) Mute(userID, conversation string) error {
+	return db.addListItem("mutes", userID, conversation)
+}
+
+// Unmute removes a conversation from the conversations muted by a user.
+func (db *DynamoDB) Unmute(userID, conversation string) error {
+	return db.removeListItem("mutes", userID, conversation)
+}
+
+// GetMuted retrieves the IDs of the conversations muted by a user, in ascending order.
+func (db *DynamoDB) GetMuted(userID string) ([]string, error) {
+	return db.queryStrings("mutes", "", "UserID", listAttrs["mutes"], userID)
+}
+
+// IsMuted tells whether a conversation is muted by a user.
+func (db *DynamoDB) IsMuted(userID, conversation string) (bool, error) {
+	return db.hasListItem("mutes", userID, conversation)
+}
+
+// verificationItem is the item of a pending verification in the verifications table, keyed by the kind and the address.
+type verificationItem struct {
+	ID string // kind:target
+	models.Verification
+}
+
+// GetVerification retrieves the pending verification of the given kind for an address.
+func (db *DynamoDB) GetVerification(kind, target string) (v *models.Verification, ok bool, err error) {
+	res, err := db.DB.GetItem(&dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String("verifications"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(kind + ":" + target),
+			},
+		},
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("dynamodb: failed to get verification: %v", err)
+	}
+	if len(res.Item) == 0 {
+		return nil, false, nil
+	}
+
+	var it verificationItem
+	if err := dynamodbattribute.UnmarshalMap(res.Item, &it); err != nil {
+		return nil, false, fmt.Errorf("dynamodb: failed to read verification: %v", err)
+	}
+	return &it.Verification, true, nil
+}
+
+// SaveVerification creates or replaces the pending verification of an address.
+func (db *DynamoDB) SaveVerification(v *models.Verification) error {
+	item, err := dynamodbattribute.MarshalMap(verificationItem{ID: v.Kind + ":" + v.Target, Verification: *v})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("verifications"),
+		Item:      item,
+	})
+	return err
+}
+
+// DeleteVerification deletes the pending verification of an address, if any.
+func (db *DynamoDB) DeleteVerification(kind, target string) error {
+	_, err := db.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String("verifications"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(kind + ":" + target),
+			},
+		},
+	})
+	return err
+}
+
+// totpItem is the item of a second factor in the totp table, keyed by the user ID.
+type totpItem struct {
+	ID string // user ID
+	models.TOTP
+}
+
+// GetTOTP retrieves the second factor of a user.
+func (db *DynamoDB) GetTOTP(userID string) (t *models.TOTP, ok bool, err error) {
+	res, err := db.DB.GetItem(&dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String("totp"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(userID),
+			},
+		},
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("dynamodb: failed to get totp: %v", err)
+	}
+	if len(res.Item) == 0 {
+		return nil, false, nil
+	}
+
+	var it totpItem
+	if err := dynamodbattribute.UnmarshalMap(res.Item, &it); err != nil {
+		return nil, false, fmt.Errorf("dynamodb: failed to read totp: %v", err)
+	}
+	return &it.TOTP, true, nil
+}
+
+// SaveTOTP creates or replaces the second factor of a user.
+func (db *DynamoDB) SaveTOTP(t *models.TOTP) error {
+	item, err := dynamodbattribute.MarshalMap(totpItem{ID: t.UserID, TOTP: *t})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("totp"),
+		Item:      item,
+	})
+	return err
+}
+
+// DeleteTOTP deletes the second factor of a user, if any.
+func (db *DynamoDB) DeleteTOTP(userID string) error {
+	return db.deleteByID("totp", userID)
+}
+
+func (db *DynamoDB) addListItem(tbl, userID, id string) error {
+	_, err := db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tbl),
+		Item:      listKey(tbl, userID, id),
+	})
+	return err
+}
+
+func (db *DynamoDB) removeListItem(tbl, userID, id string) error {
+	_, err := db.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tbl),
+		Key:       listKey(tbl, userID, id),
+	})
+	return err
+}
+
+func (db *DynamoDB) hasListItem(tbl, userID, id string) (bool, error) {
+	res, err := db.DB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tbl),
+		Key:       listKey(tbl, userID, id),
+	})
+	if err != nil {
+		return false, fmt.Errorf("dynamodb: failed to get %v item: %v", tbl, err)
+	}
+	return len(res.Item) != 0, nil
+}
+
+// listKey returns the key of an item of a user's list in the given per-user list table.
+func listKey(tbl, userID, id string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"UserID": {
+			S: aws.String(userID),
+		},
+		listAttrs[tbl]: {
+			S: aws.String(id),
+		},
+	}
+}
+
+// msgSeq returns the sequence number of a message to sort the messages of a conversation with.
+func msgSeq(m *models.Message) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(m.Time.UnixNano(), 10))}
+}
@@ -110,6 +110,23 @@ func TestGetByMail(t *testing.T) {
 	}
 }
 
+func TestGetByPhone(t *testing.T) {
+	db := newTestDynamoDB(t)
+
+	for _, user := range data.SeedUsers {
+		u, ok := db.GetByPhone(user.PhoneNumber)
+		if !ok {
+			t.Fatal("couldn't get user by phone number")
+		}
+
+		compareUsersForEquality(t, u, &user)
+	}
+
+	if _, ok := db.GetByPhone("+10000000000"); ok {
+		t.Fatal("got a user with an unknown phone number")
+	}
+}
+
 func TestSaveUser(t *testing.T) {
 	db := newTestDynamoDB(t)
 
@@ -0,0 +1,238 @@
+package aws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/titan-x/titan/data"
+)
+
+// defaultS3Region is the region to sign the requests for, unless configured otherwise. S3 compatible services other than
+// Amazon S3 usually accept any region.
+const defaultS3Region = "us-east-1"
+
+// BlobStore is an S3 compatible object storage backed attachment content store (i.e. Amazon S3, MinIO, or Google Cloud Storage
+// with HMAC keys). Objects are addressed path-style (<endpoint>/<bucket>/<key>), so any S3 compatible service works without
+// DNS configuration. Clients can upload and download the content directly with presigned URLs.
+type BlobStore struct {
+	Endpoint string       // Service URL, i.e. https://s3.amazonaws.com or http://localhost:9000
+	Bucket   string       // Bucket to keep the objects in, which should already exist.
+	Prefix   string       // Prefix of the object keys.
+	Region   string       // Region to sign the requests for.
+	Client   *http.Client // HTTP client to make the requests with.
+	signer   *v4.Signer
+}
+
+// NewBlobStore creates a new S3 blob store for the bucket at the given URL (i.e. https://s3.amazonaws.com/bucket).
+// region = Optional region setting. Will overwrite AWS_REGION env var if available.
+// creds = Optional credentials. AWS SDK default credentials (i.e. AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY env vars) are used if nil.
+func NewBlobStore(bucketURL, region string, creds *credentials.Credentials) (*BlobStore, error) {
+	u, err := url.Parse(bucketURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("s3: invalid bucket url: %v", bucketURL)
+	}
+	bucket := strings.Trim(u.Path, "/")
+	if bucket == "" || strings.Contains(bucket, "/") {
+		return nil, fmt.Errorf("s3: invalid bucket url: %v", bucketURL)
+	}
+
+	if creds == nil || region == "" {
+		conf := session.New().Config
+		if creds == nil {
+			creds = conf.Credentials
+		}
+		if region == "" && conf.Region != nil {
+			region = *conf.Region
+		}
+	}
+	if region == "" {
+		region = defaultS3Region
+	}
+
+	return &BlobStore{
+		Endpoint: u.Scheme + "://" + u.Host,
+		Bucket:   bucket,
+		Region:   region,
+		Client:   http.DefaultClient,
+		signer:   v4.NewSigner(creds, func(s *v4.Signer) { s.DisableHeaderHoisting = true }),
+	}, nil
+}
+
+// PutBlob stores the content read from r. Content is buffered in a temporary file first since S3 requires the length
+// and the checksum of the content upfront.
+func (s *BlobStore) PutBlob(id string, r io.Reader) error {
+	f, err := ioutil.TempFile("", "titan-blob")
+	if err != nil {
+		return fmt.Errorf("s3: failed to buffer blob %v: %v", id, err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), r)
+	if err != nil {
+		return fmt.Errorf("s3: failed to buffer blob %v: %v", id, err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return fmt.Errorf("s3: failed to buffer blob %v: %v", id, err)
+	}
+
+	req, err := http.NewRequest("PUT", s.objectURL(id), ioutil.NopCloser(f))
+	if err != nil {
+		return fmt.Errorf("s3: failed to put blob %v: %v", id, err)
+	}
+	req.ContentLength = n
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(hash.Sum(nil)))
+	res, err := s.do(req)
+	if err != nil {
+		return fmt.Errorf("s3: failed to put blob %v: %v", id, err)
+	}
+	res.Body.Close()
+	return nil
+}
+
+// GetBlob opens the content with the given ID for reading.
+func (s *BlobStore) GetBlob(id string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", s.objectURL(id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("s3: failed to get blob %v: %v", id, err)
+	}
+	res, err := s.do(req)
+	if err == data.ErrBlobNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("s3: failed to get blob %v: %v", id, err)
+	}
+	return res.Body, nil
+}
+
+// DeleteBlob removes the content with the given ID.
+func (s *BlobStore) DeleteBlob(id string) error {
+	req, err := http.NewRequest("DELETE", s.objectURL(id), nil)
+	if err != nil {
+		return fmt.Errorf("s3: failed to delete blob %v: %v", id, err)
+	}
+	res, err := s.do(req)
+	if err == data.ErrBlobNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("s3: failed to delete blob %v: %v", id, err)
+	}
+	res.Body.Close()
+	return nil
+}
+
+// StatBlob returns the size of the content with the given ID.
+func (s *BlobStore) StatBlob(id string) (int64, error) {
+	req, err := http.NewRequest("HEAD", s.objectURL(id), nil)
+	if err != nil {
+		return 0, fmt.Errorf("s3: failed to stat blob %v: %v", id, err)
+	}
+	res, err := s.do(req)
+	if err == data.ErrBlobNotFound {
+		return 0, err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("s3: failed to stat blob %v: %v", id, err)
+	}
+	res.Body.Close()
+
+	n, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("s3: failed to stat blob %v: invalid content length: %v", id, res.Header.Get("Content-Length"))
+	}
+	return n, nil
+}
+
+// PresignPut returns the URL to upload the content with the given ID at. Checksum is signed along with the URL
+// as the X-Amz-Content-Sha256 header, which the clients should send as is, so the service rejects any other content.
+func (s *BlobStore) PresignPut(id, checksum string, ttl time.Duration) (string, map[string]string, error) {
+	req, err := http.NewRequest("PUT", s.objectURL(id), nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("s3: failed to presign blob %v: %v", id, err)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", checksum)
+	signed, err := s.signer.Presign(req, nil, "s3", s.Region, ttl, time.Now())
+	if err != nil {
+		return "", nil, fmt.Errorf("s3: failed to presign blob %v: %v", id, err)
+	}
+
+	headers := make(map[string]string)
+	for k, v := range signed {
+		if len(v) > 0 {
+			headers[http.CanonicalHeaderKey(k)] = v[0]
+		}
+	}
+	return req.URL.String(), headers, nil
+}
+
+// PresignGet returns the URL to download the content with the given ID at.
+func (s *BlobStore) PresignGet(id string, ttl time.Duration) (string, error) {
+	req, err := http.NewRequest("GET", s.objectURL(id), nil)
+	if err != nil {
+		return "", fmt.Errorf("s3: failed to presign blob %v: %v", id, err)
+	}
+	if _, err := s.signer.Presign(req, nil, "s3", s.Region, ttl, time.Now()); err != nil {
+		return "", fmt.Errorf("s3: failed to presign blob %v: %v", id, err)
+	}
+	return req.URL.String(), nil
+}
+
+// Ping verifies that the bucket is reachable with the configured credentials.
+func (s *BlobStore) Ping() error {
+	req, err := http.NewRequest("HEAD", s.Endpoint+"/"+s.Bucket, nil)
+	if err != nil {
+		return fmt.Errorf("s3: failed to connect: %v", err)
+	}
+	res, err := s.do(req)
+	if err != nil {
+		return fmt.Errorf("s3: failed to connect: %v", err)
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (s *BlobStore) objectURL(id string) string {
+	return s.Endpoint + path.Join("/", s.Bucket, s.Prefix+id)
+}
+
+// do signs and sends the given request, and returns the response if it is successful. Not found responses are returned
+// as ErrBlobNotFound, and the rest of the failed responses as errors.
+func (s *BlobStore) do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	}
+	if _, err := s.signer.Sign(req, nil, "s3", s.Region, time.Now()); err != nil {
+		return nil, err
+	}
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 == 2 {
+		return res, nil
+	}
+
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, data.ErrBlobNotFound
+	}
+	return nil, fmt.Errorf("unexpected response: %v: %s", res.Status, b)
+}
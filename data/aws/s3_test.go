@@ -0,0 +1,161 @@
+package aws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/titan-x/titan/data"
+)
+
+// newFakeS3 starts a minimal S3 compatible server which keeps the objects in memory. Requests should be signed,
+// and uploaded content should match the X-Amz-Content-Sha256 header unless the payload is unsigned.
+func newFakeS3(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") && r.URL.Query().Get("X-Amz-Signature") == "" {
+			http.Error(w, "AccessDenied", http.StatusForbidden)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Path == "/bucket" {
+			return
+		}
+		b, ok := objects[r.URL.Path]
+		switch r.Method {
+		case "PUT":
+			b, _ := ioutil.ReadAll(r.Body)
+			sum := sha256.Sum256(b)
+			if s := r.Header.Get("X-Amz-Content-Sha256"); s != "UNSIGNED-PAYLOAD" && s != hex.EncodeToString(sum[:]) {
+				http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+				return
+			}
+			objects[r.URL.Path] = b
+		case "GET", "HEAD":
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+			w.Write(b)
+		case "DELETE":
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+func TestBlobStore(t *testing.T) {
+	ts := newFakeS3(t)
+	defer ts.Close()
+
+	if _, err := NewBlobStore("localhost:9000", "", nil); err == nil {
+		t.Fatal("expected bucket url without a scheme to be rejected")
+	}
+	if _, err := NewBlobStore(ts.URL, "", nil); err == nil {
+		t.Fatal("expected url without a bucket to be rejected")
+	}
+	bs, err := NewBlobStore(ts.URL+"/bucket", "", credentials.NewStaticCredentials("id", "secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bs.GetBlob("a"); err != data.ErrBlobNotFound {
+		t.Fatalf("expected blob not found error, got: %v", err)
+	}
+	if _, err := bs.StatBlob("a"); err != data.ErrBlobNotFound {
+		t.Fatalf("expected blob not found error, got: %v", err)
+	}
+	if err := bs.PutBlob("a", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := bs.GetBlob("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(b) != "hello" {
+		t.Fatalf("unexpected blob content: %q", b)
+	}
+	if n, err := bs.StatBlob("a"); err != nil || n != 5 {
+		t.Fatalf("unexpected blob size: %v, %v", n, err)
+	}
+	if err := bs.DeleteBlob("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.DeleteBlob("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bs.GetBlob("a"); err != data.ErrBlobNotFound {
+		t.Fatalf("expected deleted blob not to be found, got: %v", err)
+	}
+}
+
+func TestBlobStorePresign(t *testing.T) {
+	ts := newFakeS3(t)
+	defer ts.Close()
+
+	bs, err := NewBlobStore(ts.URL+"/bucket", "", credentials.NewStaticCredentials("id", "secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("hello"))
+	url, headers, err := bs.PresignPut("b", hex.EncodeToString(sum[:]), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(url, "X-Amz-Signature=") || headers["X-Amz-Content-Sha256"] != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected presigned upload: %v, %v", url, headers)
+	}
+
+	put := func(body string) int {
+		req, _ := http.NewRequest("PUT", url, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := put("hellO"); code != http.StatusBadRequest {
+		t.Fatalf("expected upload with a checksum mismatch to be rejected, got: %v", code)
+	}
+	if code := put("hello"); code != http.StatusOK {
+		t.Fatalf("expected upload to succeed, got: %v", code)
+	}
+
+	url, err = bs.PresignGet("b", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(b) != "hello" {
+		t.Fatalf("unexpected download: %v, %q", res.StatusCode, b)
+	}
+}
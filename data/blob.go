@@ -0,0 +1,38 @@
+package data
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// BlobStore stores the content of the attachments by attachment ID.
+// Implementations should be safe for concurrent use.
+type BlobStore interface {
+	// PutBlob stores the content read from r until EOF, replacing any existing content with the same ID.
+	PutBlob(id string, r io.Reader) error
+
+	// GetBlob opens the content with the given ID for reading, or returns ErrBlobNotFound if there is none.
+	GetBlob(id string) (io.ReadCloser, error)
+
+	// DeleteBlob removes the content with the given ID, if any.
+	DeleteBlob(id string) error
+}
+
+// BlobPresigner is implemented by the blob stores which can issue time limited URLs for the clients to upload and download
+// the content directly (i.e. object storage services), so large content never flows through the server.
+type BlobPresigner interface {
+	// PresignPut returns the URL to upload the content with the given ID at with an HTTP PUT request, along with the headers
+	// to send with the request. Store rejects the content unless it matches the given hex encoded SHA-256 checksum.
+	PresignPut(id, checksum string, ttl time.Duration) (url string, headers map[string]string, err error)
+
+	// PresignGet returns the URL to download the content with the given ID at with an HTTP GET request.
+	PresignGet(id string, ttl time.Duration) (url string, err error)
+
+	// StatBlob returns the size of the content with the given ID, or ErrBlobNotFound if there is none,
+	// i.e. to verify a presigned upload.
+	StatBlob(id string) (size int64, err error)
+}
+
+// ErrBlobNotFound is returned by BlobStore.GetBlob when there is no content with the given ID.
+var ErrBlobNotFound = errors.New("blob: not found")
@@ -0,0 +1,416 @@
+//go:build cassandra
+// +build cassandra
+
+// Package cassandra provides Cassandra (and ScyllaDB) implementation of the message history, for high write volumes.
+// It is not included in the default build and requires building with the cassandra tag (go build -tags cassandra).
+//
+// Cassandra driver: https://github.com/gocql/gocql
+package cassandra
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+)
+
+var logger = log.Component("cassandra")
+
+// bucketSize is the time span of the messages of a conversation kept in the same partition, so the partitions of
+// long running conversations stay small.
+const bucketSize = 24 * time.Hour
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS messages (
+		conversation text,
+		bucket       bigint,
+		time         bigint,
+		id           text,
+		sender       text,
+		recipient    text,
+		group_id     text,
+		body         text,
+		attachment   text,
+		state        text,
+		encrypted    boolean,
+		PRIMARY KEY ((conversation, bucket), time, id)
+	) WITH CLUSTERING ORDER BY (time DESC, id DESC)`,
+	`CREATE TABLE IF NOT EXISTS messages_by_id (
+		id           text PRIMARY KEY,
+		conversation text,
+		bucket       bigint,
+		time         bigint
+	)`,
+	`CREATE TABLE IF NOT EXISTS messages_by_sender (
+		sender text,
+		id     text,
+		PRIMARY KEY (sender, id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS conversation_buckets (
+		conversation text,
+		bucket       bigint,
+		PRIMARY KEY (conversation, bucket)
+	) WITH CLUSTERING ORDER BY (bucket DESC)`,
+	`CREATE TABLE IF NOT EXISTS message_index (
+		user_id     text,
+		word        text,
+		message_id  text,
+		occurrences int,
+		PRIMARY KEY ((user_id, word), message_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS message_index_words (
+		user_id    text,
+		word       text,
+		PRIMARY KEY (user_id, word)
+	)`,
+}
+
+const msgCols = "conversation, bucket, time, id, sender, recipient, group_id, body, attachment, state, encrypted"
+
+// MessageDB is a Cassandra backed message history. Messages of each conversation are partitioned by the day they are sent at,
+// and expire with the retention period, if any, using Cassandra TTLs.
+type MessageDB struct {
+	Session   *gocql.Session
+	retention time.Duration
+}
+
+// NewMessageDB connects to the Cassandra cluster with the given connection string in the form of host1,host2/keyspace,
+// and creates the tables in the keyspace if they do not exist. Keyspace should already exist.
+// Messages expire after the given retention period, unless it is zero.
+func NewMessageDB(dsn string, retention time.Duration) (*MessageDB, error) {
+	i := strings.LastIndex(dsn, "/")
+	if i <= 0 || i == len(dsn)-1 {
+		return nil, fmt.Errorf("cassandra: invalid connection string, expected host1,host2/keyspace: %v", dsn)
+	}
+
+	c := gocql.NewCluster(strings.Split(dsn[:i], ",")...)
+	c.Keyspace = dsn[i+1:]
+	c.Consistency = gocql.LocalQuorum
+	s, err := c.CreateSession()
+	if err != nil {
+		return nil, fmt.Errorf("cassandra: failed to connect: %v", err)
+	}
+
+	for _, stmt := range schema {
+		if err := s.Query(stmt).Exec(); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("cassandra: failed to create schema: %v", err)
+		}
+	}
+
+	return &MessageDB{Session: s, retention: retention}, nil
+}
+
+// Ping verifies the connectivity of the cluster.
+func (db *MessageDB) Ping() error {
+	return db.Session.Query("SELECT now() FROM system.local").Exec()
+}
+
+// Close closes the connections to the cluster.
+func (db *MessageDB) Close() {
+	db.Session.Close()
+}
+
+// GetMessage retrieves a message by ID with OK indicator.
+func (db *MessageDB) GetMessage(id string) (m *models.Message, ok bool) {
+	var conv string
+	var bucket, t int64
+	err := db.Session.Query("SELECT conversation, bucket, time FROM messages_by_id WHERE id = ?", id).Scan(&conv, &bucket, &t)
+	if err == gocql.ErrNotFound {
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("get message error: %v", err)
+		return nil, false
+	}
+
+	iter := db.Session.Query("SELECT "+msgCols+" FROM messages WHERE conversation = ? AND bucket = ? AND time = ? AND id = ?", conv, bucket, t, id).Iter()
+	msgs, _, err := scanMessages(iter, 0, 1)
+	if err != nil {
+		logger.Errorf("get message error: %v", err)
+		return nil, false
+	}
+	if len(msgs) == 0 {
+		return nil, false
+	}
+	return &msgs[0], true
+}
+
+// SaveMessage creates or updates a message. Messages older than the retention period are not saved.
+func (db *MessageDB) SaveMessage(m *models.Message) error {
+	ttl, ok := db.ttl(m.Time)
+	if !ok {
+		return nil
+	}
+
+	att := ""
+	if m.Attachment != nil {
+		b, err := json.Marshal(m.Attachment)
+		if err != nil {
+			return fmt.Errorf("cassandra: failed to serialize attachment: %v", err)
+		}
+		att = string(b)
+	}
+
+	bucket, t := msgBucket(m.Time), m.Time.UnixNano()
+	b := db.Session.NewBatch(gocql.LoggedBatch)
+	b.Query("INSERT INTO messages ("+msgCols+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) USING TTL ?",
+		m.Conversation, bucket, t, m.ID, m.From, m.To, m.Group, m.Message, att, m.State, m.Encrypted, ttl)
+	b.Query("INSERT INTO messages_by_id (id, conversation, bucket, time) VALUES (?, ?, ?, ?) USING TTL ?", m.ID, m.Conversation, bucket, t, ttl)
+	b.Query("INSERT INTO messages_by_sender (sender, id) VALUES (?, ?) USING TTL ?", m.From, m.ID, ttl)
+	b.Query("INSERT INTO conversation_buckets (conversation, bucket) VALUES (?, ?) USING TTL ?", m.Conversation, bucket, ttl)
+	if err := db.Session.ExecuteBatch(b); err != nil {
+		return fmt.Errorf("cassandra: failed to save message: %v", err)
+	}
+	return nil
+}
+
+// GetMessages retrieves the messages of a conversation sent at or before the given time, newest first, skipping the first offset messages.
+// Partitions of the conversation are read one by one, starting with the one the given time falls in.
+func (db *MessageDB) GetMessages(conversation string, before time.Time, offset, limit int) ([]models.Message, error) {
+	buckets := db.Session.Query("SELECT bucket FROM conversation_buckets WHERE conversation = ? AND bucket <= ?", conversation, msgBucket(before)).Iter()
+	var bucket int64
+	var bs []int64
+	for buckets.Scan(&bucket) {
+		bs = append(bs, bucket)
+	}
+	if err := buckets.Close(); err != nil {
+		return nil, fmt.Errorf("cassandra: failed to get messages: %v", err)
+	}
+
+	msgs := []models.Message{}
+	for _, bucket := range bs {
+		iter := db.Session.Query("SELECT "+msgCols+" FROM messages WHERE conversation = ? AND bucket = ? AND time <= ?", conversation, bucket, before.UnixNano()).Iter()
+		ms, left, err := scanMessages(iter, offset, limit-len(msgs))
+		if err != nil {
+			return nil, fmt.Errorf("cassandra: failed to get messages: %v", err)
+		}
+		msgs, offset = append(msgs, ms...), left
+		if len(msgs) >= limit {
+			break
+		}
+	}
+	return msgs, nil
+}
+
+// IndexMessage indexes the body of a message for the given users. Cassandra has no full-text search, so each word is indexed
+// along with the number of times it occurs in the message.
+func (db *MessageDB) IndexMessage(m *models.Message, userIDs []string) error {
+	ttl, ok := db.ttl(m.Time)
+	if !ok {
+		return nil
+	}
+
+	b := db.Session.NewBatch(gocql.UnloggedBatch)
+	for w, n := range data.SearchTerms(m.Message) {
+		for _, uid := range userIDs {
+			b.Query("INSERT INTO message_index (user_id, word, message_id, occurrences) VALUES (?, ?, ?, ?) USING TTL ?", uid, w, m.ID, n, ttl)
+			b.Query("INSERT INTO message_index_words (user_id, word) VALUES (?, ?)", uid, w)
+		}
+	}
+	if err := db.Session.ExecuteBatch(b); err != nil {
+		return fmt.Errorf("cassandra: failed to index message: %v", err)
+	}
+	return nil
+}
+
+// SearchMessages retrieves the messages indexed for a user which contain all the words of the query, ordered by relevance, then newest first.
+// Messages containing any one of the query words are the candidates, which are then matched against the rest of the words.
+func (db *MessageDB) SearchMessages(userID, query, conversation string, before time.Time, offset, limit int) ([]models.Message, error) {
+	type match struct {
+		msg   models.Message
+		score int
+	}
+
+	q := data.SearchTerms(query)
+	var word string
+	for w := range q {
+		word = w
+		break
+	}
+
+	cands := make(map[string]map[string]int)
+	iter := db.Session.Query("SELECT message_id, occurrences FROM message_index WHERE user_id = ? AND word = ?", userID, word).Iter()
+	var id string
+	var n int
+	for iter.Scan(&id, &n) {
+		cands[id] = map[string]int{word: n}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("cassandra: failed to search messages: %v", err)
+	}
+
+	var ms []match
+	for id, terms := range cands {
+		for w := range q {
+			if w == word {
+				continue
+			}
+			var n int
+			err := db.Session.Query("SELECT occurrences FROM message_index WHERE user_id = ? AND word = ? AND message_id = ?", userID, w, id).Scan(&n)
+			if err != nil && err != gocql.ErrNotFound {
+				return nil, fmt.Errorf("cassandra: failed to search messages: %v", err)
+			}
+			terms[w] = n
+		}
+		score := data.SearchScore(terms, q)
+		if score == 0 {
+			continue
+		}
+
+		// index items of the messages deleted along with their senders are skipped
+		m, ok := db.GetMessage(id)
+		if !ok || m.Time.After(before) || (conversation != "" && m.Conversation != conversation) {
+			continue
+		}
+		ms = append(ms, match{msg: *m, score: score})
+	}
+
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].score != ms[j].score {
+			return ms[i].score > ms[j].score
+		}
+		return ms[i].msg.Time.After(ms[j].msg.Time)
+	})
+
+	msgs := []models.Message{}
+	for i := offset; i < len(ms) && len(msgs) < limit; i++ {
+		msgs = append(msgs, ms[i].msg)
+	}
+	return msgs, nil
+}
+
+// DeleteUserMessages deletes the messages sent by a user, along with the search index of the user.
+// Index items of the other users for the deleted messages are left to be skipped by the searches.
+func (db *MessageDB) DeleteUserMessages(userID string) error {
+	iter := db.Session.Query("SELECT id FROM messages_by_sender WHERE sender = ?", userID).Iter()
+	var id string
+	var ids []string
+	for iter.Scan(&id) {
+		ids = append(ids, id)
+	}
+	if err := iter.Close(); err != nil {
+		return fmt.Errorf("cassandra: failed to get messages: %v", err)
+	}
+
+	for _, id := range ids {
+		var conv string
+		var bucket, t int64
+		err := db.Session.Query("SELECT conversation, bucket, time FROM messages_by_id WHERE id = ?", id).Scan(&conv, &bucket, &t)
+		if err == gocql.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cassandra: failed to get message: %v", err)
+		}
+
+		b := db.Session.NewBatch(gocql.LoggedBatch)
+		b.Query("DELETE FROM messages WHERE conversation = ? AND bucket = ? AND time = ? AND id = ?", conv, bucket, t, id)
+		b.Query("DELETE FROM messages_by_id WHERE id = ?", id)
+		if err := db.Session.ExecuteBatch(b); err != nil {
+			return fmt.Errorf("cassandra: failed to delete message: %v", err)
+		}
+	}
+	if err := db.Session.Query("DELETE FROM messages_by_sender WHERE sender = ?", userID).Exec(); err != nil {
+		return fmt.Errorf("cassandra: failed to delete messages: %v", err)
+	}
+
+	iter = db.Session.Query("SELECT word FROM message_index_words WHERE user_id = ?", userID).Iter()
+	var word string
+	var words []string
+	for iter.Scan(&word) {
+		words = append(words, word)
+	}
+	if err := iter.Close(); err != nil {
+		return fmt.Errorf("cassandra: failed to get message index: %v", err)
+	}
+	for _, w := range words {
+		if err := db.Session.Query("DELETE FROM message_index WHERE user_id = ? AND word = ?", userID, w).Exec(); err != nil {
+			return fmt.Errorf("cassandra: failed to delete message index: %v", err)
+		}
+	}
+	if err := db.Session.Query("DELETE FROM message_index_words WHERE user_id = ?", userID).Exec(); err != nil {
+		return fmt.Errorf("cassandra: failed to delete message index: %v", err)
+	}
+	return nil
+}
+
+// UpdateDeliveryState sets the latest delivery state of a message. State expires along with the rest of the message.
+func (db *MessageDB) UpdateDeliveryState(id, state string) error {
+	var conv string
+	var bucket, t int64
+	err := db.Session.Query("SELECT conversation, bucket, time FROM messages_by_id WHERE id = ?", id).Scan(&conv, &bucket, &t)
+	if err == gocql.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cassandra: failed to get message: %v", err)
+	}
+
+	ttl, ok := db.ttl(time.Unix(0, t))
+	if !ok {
+		return nil
+	}
+	if err := db.Session.Query("UPDATE messages USING TTL ? SET state = ? WHERE conversation = ? AND bucket = ? AND time = ? AND id = ?",
+		ttl, state, conv, bucket, t, id).Exec(); err != nil {
+		return fmt.Errorf("cassandra: failed to update message state: %v", err)
+	}
+	return nil
+}
+
+// DeleteMessagesBefore does nothing, as the messages expire with the retention period given to NewMessageDB using Cassandra TTLs,
+// which is more efficient than deleting them. Messages saved with a longer retention period expire with that period.
+func (db *MessageDB) DeleteMessagesBefore(t time.Time) (int, error) {
+	return 0, nil
+}
+
+// ttl returns the TTL in seconds for the data of a message sent at the given time, which is the time left until the end of
+// its retention period, or zero if messages do not expire. Returns ok = false if the message is already expired.
+func (db *MessageDB) ttl(sent time.Time) (ttl int, ok bool) {
+	if db.retention <= 0 {
+		return 0, true
+	}
+
+	left := db.retention - time.Since(sent)
+	if left < time.Second {
+		return 0, false
+	}
+	return int(left / time.Second), true
+}
+
+// scanMessages reads up to limit messages from the given iterator selecting msgCols, skipping the first offset rows, and closes the iterator.
+// Returns the number of rows left to be skipped, if the iterator had fewer rows than the offset.
+func scanMessages(iter *gocql.Iter, offset, limit int) (msgs []models.Message, left int, err error) {
+	msgs = []models.Message{}
+	var m models.Message
+	var bucket, t int64
+	var att string
+	for len(msgs) < limit && iter.Scan(&m.Conversation, &bucket, &t, &m.ID, &m.From, &m.To, &m.Group, &m.Message, &att, &m.State, &m.Encrypted) {
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		m.Time = time.Unix(0, t)
+		m.Attachment = nil
+		if att != "" {
+			if err := json.Unmarshal([]byte(att), &m.Attachment); err != nil {
+				iter.Close()
+				return nil, 0, fmt.Errorf("failed to deserialize attachment: %v", err)
+			}
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, offset, iter.Close()
+}
+
+// msgBucket returns the partition of a conversation that the messages sent at the given time are kept in.
+func msgBucket(t time.Time) int64 {
+	return t.UnixNano() / int64(bucketSize)
+}
@@ -0,0 +1,63 @@
+//go:build cassandra
+// +build cassandra
+
+package cassandra
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/models"
+)
+
+const dsn = "localhost/titan_test"
+
+func newTestDB(t *testing.T) *MessageDB {
+	db, err := NewMessageDB(dsn, time.Hour)
+	if err != nil {
+		t.Skipf("skipping Cassandra test: %v", err)
+	}
+	return db
+}
+
+func TestMessages(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	// messages of a conversation span multiple partitions
+	conv := fmt.Sprintf("c%v:c%v", time.Now().UnixNano(), time.Now().UnixNano())
+	now := time.Now()
+	for i, d := range []time.Duration{bucketSize + time.Minute, time.Minute, 0} {
+		m := &models.Message{ID: fmt.Sprintf("%v-%v", conv, i), From: "1", To: "2", Conversation: conv, Time: now.Add(-d), Message: fmt.Sprintf("lunch %v", i), State: models.StateSent}
+		if err := db.SaveMessage(m); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.IndexMessage(m, []string{"1", "2"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs, err := db.GetMessages(conv, now, 1, 10)
+	if err != nil || len(msgs) != 2 || msgs[0].Message != "lunch 1" || msgs[1].Message != "lunch 0" {
+		t.Fatalf("unexpected messages: %+v, err: %v", msgs, err)
+	}
+
+	if err := db.UpdateDeliveryState(conv+"-2", models.StateRead); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := db.GetMessage(conv + "-2"); !ok || m.State != models.StateRead || !m.Time.Equal(now) {
+		t.Fatalf("expected updated message state, got: %+v", m)
+	}
+
+	if msgs, err = db.SearchMessages("2", "lunch 0", conv, now, 0, 10); err != nil || len(msgs) != 1 || msgs[0].Message != "lunch 0" {
+		t.Fatalf("unexpected search results: %+v, err: %v", msgs, err)
+	}
+
+	if err := db.DeleteUserMessages("1"); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, err = db.GetMessages(conv, now, 0, 10); err != nil || len(msgs) != 0 {
+		t.Fatalf("expected messages of the deleted user to be deleted, got: %+v, err: %v", msgs, err)
+	}
+}
@@ -1,10 +1,29 @@
 package data
 
-import "github.com/titan-x/titan/models"
+import (
+	"time"
+
+	"github.com/titan-x/titan/models"
+)
 
 // DB wraps all database related functions.
 type DB interface {
 	UserDB
+	GroupDB
+	TokenDB
+	MessageDB
+	KeyDB
+	AttachmentDB
+	TopicDB
+	ContactDB
+	BlockDB
+	VerificationDB
+	TOTPDB
+}
+
+// Pinger is implemented by the databases and stores that can verify their connectivity, for health checks.
+type Pinger interface {
+	Ping() error
 }
 
 // UserDB presists user information in database.
@@ -12,5 +31,165 @@ type UserDB interface {
 	Seed(overwrite bool, jwtPass string) error
 	GetByID(id string) (u *models.User, ok bool)
 	GetByEmail(email string) (u *models.User, ok bool)
+
+	// GetByPhone retrieves the user that the given verified phone number, in E.164 format, is bound to.
+	GetByPhone(phone string) (u *models.User, ok bool)
+
 	SaveUser(u *models.User) error
+
+	// GetUserIDs retrieves up to limit IDs of the registered users that come after the given user ID in the iteration order
+	// of the database, for iterating over all the users in pages. Iteration starts with the first user if the ID is empty.
+	GetUserIDs(after string, limit int) ([]string, error)
+
+	// GetDueDeletions retrieves the IDs of the users whose accounts are scheduled to be deleted at or before the given time.
+	GetDueDeletions(t time.Time) ([]string, error)
+
+	// DeleteUser deletes a user along with the refresh tokens, the encryption keys, and the second factor of the user.
+	DeleteUser(id string) error
+}
+
+// GroupDB persists group conversation information in database.
+type GroupDB interface {
+	GetGroup(id string) (g *models.Group, ok bool)
+	SaveGroup(g *models.Group) error
+}
+
+// TokenDB persists refresh tokens. Tokens are revoked by saving them with Revoked flag set.
+type TokenDB interface {
+	GetRefreshToken(id string) (t *models.RefreshToken, ok bool)
+	SaveRefreshToken(t *models.RefreshToken) error
+
+	// GetRefreshTokens retrieves all the refresh tokens issued to a user, revoked or not.
+	GetRefreshTokens(userID string) ([]models.RefreshToken, error)
+}
+
+// MessageDB persists message history along with the delivery state of each message.
+type MessageDB interface {
+	GetMessage(id string) (m *models.Message, ok bool)
+	SaveMessage(m *models.Message) error
+
+	// GetMessages retrieves up to limit messages of a conversation that are sent at or before the given time, newest first,
+	// skipping the first offset messages.
+	GetMessages(conversation string, before time.Time, offset, limit int) ([]models.Message, error)
+
+	// IndexMessage indexes the body of a saved message for full-text search by the given users (i.e. the participants of the conversation).
+	IndexMessage(m *models.Message, userIDs []string) error
+
+	// SearchMessages retrieves up to limit messages indexed for a user which are sent at or before the given time and contain all the words
+	// of the query, in the given conversation or in all conversations if empty, skipping the first offset matches. Messages are ordered
+	// by relevance, then newest first.
+	SearchMessages(userID, query, conversation string, before time.Time, offset, limit int) ([]models.Message, error)
+
+	// DeleteUserMessages deletes the messages sent by a user, along with the search index of the user.
+	DeleteUserMessages(userID string) error
+
+	// UpdateDeliveryState sets the latest delivery state of a saved message, leaving the rest of the message as is.
+	// Unknown messages are ignored.
+	UpdateDeliveryState(id, state string) error
+
+	// DeleteMessagesBefore deletes the messages sent before the given time, along with their search index, for retention,
+	// and returns the number of messages deleted.
+	DeleteMessagesBefore(t time.Time) (int, error)
+}
+
+// KeyDB persists the public end-to-end encryption keys of the users.
+type KeyDB interface {
+	// SaveKeys replaces the identity key and the signed prekey of a user with the given ones, and adds the given one-time prekeys
+	// to the existing ones. Existing one-time prekeys are dropped if the identity key changes (i.e. the app is reinstalled).
+	SaveKeys(b *models.KeyBundle) error
+
+	// GetKeys retrieves the identity key and the signed prekey of a user, without any one-time prekeys.
+	GetKeys(userID string) (b *models.KeyBundle, ok bool, err error)
+
+	// ClaimKeys retrieves the key bundle of a user along with one of the one-time prekeys, if any,
+	// which is removed so it is never given out again.
+	ClaimKeys(userID string) (b *models.KeyBundle, ok bool, err error)
+
+	// CountPreKeys returns the number of one-time prekeys left for a user.
+	CountPreKeys(userID string) (int, error)
+}
+
+// AttachmentDB persists the metadata of the attachments, while their content is kept in a BlobStore.
+type AttachmentDB interface {
+	GetAttachment(id string) (a *models.Attachment, ok bool, err error)
+	SaveAttachment(a *models.Attachment) error
+	DeleteAttachment(id string) error
+
+	// GetAttachments retrieves all the attachments of a user, uploaded or not, for storage quota accounting.
+	GetAttachments(owner string) ([]models.Attachment, error)
+}
+
+// TopicDB persists the publish/subscribe topics and their subscribers.
+type TopicDB interface {
+	GetTopic(name string) (t *models.Topic, ok bool, err error)
+	SaveTopic(t *models.Topic) error
+
+	// Subscribe adds a user to the subscribers of a topic, if not already subscribed.
+	Subscribe(topic, userID string) error
+	Unsubscribe(topic, userID string) error
+
+	// GetSubscribers retrieves up to limit IDs of the subscribers of a topic that come after the given user ID, in ascending order,
+	// for iterating over the subscribers in pages. Iteration starts with the first subscriber if the ID is empty.
+	GetSubscribers(topic, after string, limit int) ([]string, error)
+
+	// GetSubscriptions retrieves the names of the topics a user is subscribed to.
+	GetSubscriptions(userID string) ([]string, error)
+}
+
+// ContactDB persists the contact rosters of the users.
+type ContactDB interface {
+	// AddContact adds a user to the contacts of another, if not already added.
+	AddContact(userID, contactID string) error
+	RemoveContact(userID, contactID string) error
+
+	// GetContacts retrieves the IDs of the contacts of a user, in ascending order.
+	GetContacts(userID string) ([]string, error)
+
+	// GetContactOf retrieves the IDs of the users who have the given user in their contacts, in ascending order.
+	GetContactOf(contactID string) ([]string, error)
+
+	// HasContact tells whether a user is in the contacts of another, i.e. for privacy checks.
+	HasContact(userID, contactID string) (bool, error)
+}
+
+// BlockDB persists the users blocked by each user, and the conversations muted by each user.
+type BlockDB interface {
+	// Block adds a user to the users blocked by another, if not already blocked.
+	Block(userID, blockedID string) error
+	Unblock(userID, blockedID string) error
+
+	// GetBlocked retrieves the IDs of the users blocked by a user, in ascending order.
+	GetBlocked(userID string) ([]string, error)
+
+	// IsBlocked tells whether a user is blocked by another.
+	IsBlocked(userID, blockedID string) (bool, error)
+
+	// Mute adds a conversation to the conversations muted by a user, if not already muted.
+	Mute(userID, conversation string) error
+	Unmute(userID, conversation string) error
+
+	// GetMuted retrieves the IDs of the conversations muted by a user, in ascending order.
+	GetMuted(userID string) ([]string, error)
+
+	// IsMuted tells whether a conversation is muted by a user.
+	IsMuted(userID, conversation string) (bool, error)
+}
+
+// VerificationDB persists the pending verifications of the addresses of the users.
+type VerificationDB interface {
+	// GetVerification retrieves the pending verification of the given kind for an address.
+	GetVerification(kind, target string) (v *models.Verification, ok bool, err error)
+
+	// SaveVerification creates or replaces the pending verification of an address.
+	SaveVerification(v *models.Verification) error
+	DeleteVerification(kind, target string) error
+}
+
+// TOTPDB persists the TOTP second factors of the users.
+type TOTPDB interface {
+	GetTOTP(userID string) (t *models.TOTP, ok bool, err error)
+
+	// SaveTOTP creates or replaces the second factor of a user.
+	SaveTOTP(t *models.TOTP) error
+	DeleteTOTP(userID string) error
 }
@@ -0,0 +1,10 @@
+package data
+
+import "github.com/titan-x/titan/models"
+
+// EventBus distributes server events to the external services and other server instances.
+// Subscribing to an empty event type subscribes to all events. Implementations should be safe for concurrent use.
+type EventBus interface {
+	Publish(e *models.Event) error
+	Subscribe(eventType string, handler func(e *models.Event)) error
+}
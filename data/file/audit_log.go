@@ -0,0 +1,105 @@
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+// AuditLog is a file backed audit log. Entries are appended to the file as JSON objects, one per line, so the file can be
+// shipped and processed with the usual log tools. File is only ever appended to, and is never truncated by the server.
+type AuditLog struct {
+	path  string
+	mutex sync.Mutex
+	f     *os.File
+}
+
+// NewAuditLog opens the audit log at the given file path for appending. The file and its directory are created if they do not exist.
+func NewAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("file: audit log: failed to create directory for %v: %v", path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("file: audit log: failed to open %v: %v", path, err)
+	}
+
+	return &AuditLog{path: path, f: f}, nil
+}
+
+// AppendAudit appends an entry to the audit log file.
+func (l *AuditLog) AppendAudit(e *models.AuditEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("file: audit log: failed to serialize entry %v: %v", e.ID, err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// entry is written with a single write so a crash never leaves a partial line in between the entries
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("file: audit log: failed to write entry %v: %v", e.ID, err)
+	}
+	return nil
+}
+
+// QueryAudit retrieves the entries matching the filter which were recorded at or before the given time, newest first.
+// Whole file is scanned for each query, as the queries are only made by the operators once in a while.
+func (l *AuditLog) QueryAudit(f data.AuditFilter, before time.Time, offset, limit int) ([]models.AuditEntry, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	r, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("file: audit log: failed to open %v: %v", l.path, err)
+	}
+	defer r.Close()
+
+	var es []models.AuditEntry
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		var e models.AuditEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("file: audit log: failed to deserialize entry: %v", err)
+		}
+		if !e.Time.After(before) && f.Match(&e) {
+			es = append(es, e)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("file: audit log: failed to read %v: %v", l.path, err)
+	}
+
+	// entries are appended oldest first
+	if offset >= len(es) {
+		return nil, nil
+	}
+	end := len(es) - offset
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+	page := make([]models.AuditEntry, 0, end-start)
+	for i := end - 1; i >= start; i-- {
+		page = append(page, es[i])
+	}
+	return page, nil
+}
+
+// Close closes the audit log file.
+func (l *AuditLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.f.Close()
+}
@@ -0,0 +1,85 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "titan-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit", "audit.log")
+	l, err := NewAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i, e := range []models.AuditEntry{
+		models.AuditEntry{ID: "e1", Action: models.AuditLogin, UserID: "1", Success: true},
+		models.AuditEntry{ID: "e2", Action: "admin.kick", UserID: "1", Target: "2", Success: true},
+		models.AuditEntry{ID: "e3", Action: models.AuditLogin, UserID: "2", Success: false, Details: map[string]string{"reason": "revoked"}},
+	} {
+		e.Time = now.Add(time.Duration(i) * time.Second)
+		if err := l.AppendAudit(&e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries survive reopening the log, and new entries are appended after them
+	if l, err = NewAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.AppendAudit(&models.AuditEntry{ID: "e4", Time: now.Add(time.Hour), Action: models.AuditRevoke, UserID: "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(es []models.AuditEntry) (ids []string) {
+		for _, e := range es {
+			ids = append(ids, e.ID)
+		}
+		return
+	}
+	for _, c := range []struct {
+		f             data.AuditFilter
+		before        time.Time
+		offset, limit int
+		ids           []string
+	}{
+		{data.AuditFilter{}, now.Add(time.Hour), 0, 10, []string{"e4", "e3", "e2", "e1"}},
+		{data.AuditFilter{}, now.Add(time.Minute), 0, 10, []string{"e3", "e2", "e1"}},
+		{data.AuditFilter{}, now.Add(time.Hour), 1, 2, []string{"e3", "e2"}},
+		{data.AuditFilter{}, now.Add(time.Hour), 4, 2, nil},
+		{data.AuditFilter{UserID: "2"}, now.Add(time.Hour), 0, 10, []string{"e4", "e3", "e2"}},
+		{data.AuditFilter{Action: models.AuditLogin}, now.Add(time.Hour), 0, 10, []string{"e3", "e1"}},
+		{data.AuditFilter{Since: now.Add(time.Second)}, now.Add(time.Hour), 0, 10, []string{"e4", "e3", "e2"}},
+	} {
+		es, err := l.QueryAudit(c.f, c.before, c.offset, c.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(es); strings.Join(got, ",") != strings.Join(c.ids, ",") {
+			t.Fatalf("expected entries %v for filter %+v, got: %v", c.ids, c.f, got)
+		}
+	}
+
+	es, _ := l.QueryAudit(data.AuditFilter{UserID: "2", Action: models.AuditLogin}, now.Add(time.Hour), 0, 10)
+	if len(es) != 1 || es[0].Success || es[0].Details["reason"] != "revoked" {
+		t.Fatalf("unexpected entry: %+v", es)
+	}
+}
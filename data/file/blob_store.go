@@ -0,0 +1,83 @@
+package file
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/titan-x/titan/data"
+)
+
+// BlobStore is a file system backed attachment content store.
+// Each attachment is kept in a separate file in the store directory. Content is written to a temporary file first and
+// renamed into place once complete, so an interrupted upload never leaves a partially written attachment behind.
+type BlobStore struct {
+	dir string
+}
+
+// NewBlobStore creates a new blob store in the given directory. The directory is created if it does not exist.
+func NewBlobStore(dir string) (*BlobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("file: blob store: failed to create directory %v: %v", dir, err)
+	}
+
+	return &BlobStore{dir: dir}, nil
+}
+
+// PutBlob stores the content read from r.
+func (s *BlobStore) PutBlob(id string, r io.Reader) error {
+	f, err := ioutil.TempFile(s.dir, "upload-")
+	if err != nil {
+		return fmt.Errorf("file: blob store: failed to create file for blob %v: %v", id, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("file: blob store: failed to write blob %v: %v", id, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("file: blob store: failed to write blob %v: %v", id, err)
+	}
+
+	return os.Rename(f.Name(), s.path(id))
+}
+
+// GetBlob opens the content with the given ID for reading.
+func (s *BlobStore) GetBlob(id string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, data.ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file: blob store: failed to read blob %v: %v", id, err)
+	}
+	return f, nil
+}
+
+// DeleteBlob removes the content with the given ID.
+func (s *BlobStore) DeleteBlob(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("file: blob store: failed to remove blob %v: %v", id, err)
+	}
+	return nil
+}
+
+// Ping verifies that the store directory is accessible.
+func (s *BlobStore) Ping() error {
+	fi, err := os.Stat(s.dir)
+	if err != nil {
+		return fmt.Errorf("file: blob store: failed to access directory %v: %v", s.dir, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("file: blob store: %v is not a directory", s.dir)
+	}
+	return nil
+}
+
+func (s *BlobStore) path(id string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(id))+".blob")
+}
@@ -0,0 +1,67 @@
+package file
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/titan-x/titan/data"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "titan-blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.PutBlob("a1", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new store on the same directory should see the same blobs, as if the server was restarted
+	s2, err := NewBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := s2.GetBlob("a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(b) != "hello" {
+		t.Fatalf("unexpected blob content: %q, %v", b, err)
+	}
+
+	// interrupted uploads leave neither the blob nor a temporary file behind
+	if err := s2.PutBlob("a2", failingReader{}); err == nil {
+		t.Fatal("expected failed upload to return an error")
+	}
+	if _, err := s2.GetBlob("a2"); err != data.ErrBlobNotFound {
+		t.Fatalf("expected failed upload not to be stored, got: %v", err)
+	}
+
+	if err := s2.DeleteBlob("a1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.DeleteBlob("a1"); err != nil {
+		t.Fatalf("expected deleting a missing blob to succeed, got: %v", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("expected all blob files to be removed, got %v files", len(files))
+	}
+}
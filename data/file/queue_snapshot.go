@@ -0,0 +1,110 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/titan-x/titan/data"
+)
+
+// SnapshotQueueStore is a memory backed queue storage which is saved to a snapshot file upon shutdown and loaded back from it
+// upon startup, so the queued requests survive planned restarts without writing to the disk on each queued request.
+// Unlike QueueStore, requests queued since the last snapshot are lost if the process crashes.
+type SnapshotQueueStore struct {
+	path  string
+	mutex sync.Mutex
+	reqs  map[string][]data.QueuedRequest // user ID -> queued requests, in the order they were added
+}
+
+// NewSnapshotQueueStore creates a new queue store which is saved to the given snapshot file.
+// Requests in the snapshot file, if it exists, are loaded to be delivered to the users as they connect.
+func NewSnapshotQueueStore(path string) (*SnapshotQueueStore, error) {
+	s := SnapshotQueueStore{path: path, reqs: make(map[string][]data.QueuedRequest)}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file: queue snapshot: failed to read snapshot %v: %v", path, err)
+	}
+	if err := json.Unmarshal(b, &s.reqs); err != nil {
+		return nil, fmt.Errorf("file: queue snapshot: failed to deserialize snapshot %v: %v", path, err)
+	}
+
+	return &s, nil
+}
+
+// AddRequest appends a request to the user's queue.
+func (s *SnapshotQueueStore) AddRequest(userID string, req *data.QueuedRequest) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.reqs[userID] = append(s.reqs[userID], *req)
+	return nil
+}
+
+// RemoveRequest removes a request from the user's queue.
+func (s *SnapshotQueueStore) RemoveRequest(userID, reqID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	reqs := s.reqs[userID]
+	for i, r := range reqs {
+		if r.ID != reqID {
+			continue
+		}
+		if len(reqs) == 1 {
+			delete(s.reqs, userID)
+		} else {
+			s.reqs[userID] = append(reqs[:i:i], reqs[i+1:]...)
+		}
+		break
+	}
+	return nil
+}
+
+// GetRequests retrieves all the queued requests for a user, in the order they were added.
+func (s *SnapshotQueueStore) GetRequests(userID string) ([]data.QueuedRequest, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]data.QueuedRequest(nil), s.reqs[userID]...), nil
+}
+
+// Len returns the total number of queued requests of all the users.
+func (s *SnapshotQueueStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	n := 0
+	for _, reqs := range s.reqs {
+		n += len(reqs)
+	}
+	return n
+}
+
+// Save writes the queued requests to the snapshot file. Snapshot file is replaced atomically so a crash while saving
+// leaves the previous snapshot intact.
+func (s *SnapshotQueueStore) Save() error {
+	s.mutex.Lock()
+	b, err := json.Marshal(s.reqs)
+	s.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("file: queue snapshot: failed to serialize snapshot: %v", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("file: queue snapshot: failed to write snapshot %v: %v", s.path, err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Close saves the snapshot. Store should not be used after closing, as the later changes are not saved.
+func (s *SnapshotQueueStore) Close() error {
+	return s.Save()
+}
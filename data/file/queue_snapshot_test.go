@@ -0,0 +1,63 @@
+package file
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/titan-x/titan/data"
+)
+
+func TestSnapshotQueueStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "titan-queue-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queue.json")
+
+	s, err := NewSnapshotQueueStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"r1", "r2", "r3"} {
+		if err := s.AddRequest("1", &data.QueuedRequest{ID: id, Method: "msg.recv", Params: json.RawMessage(`[{"message":"hi"}]`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.AddRequest("2", &data.QueuedRequest{ID: "r4", Method: "msg.recv"})
+	s.RemoveRequest("1", "r2")
+	s.RemoveRequest("2", "r4")
+
+	// nothing is written to the disk until the store is closed
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no snapshot before closing, got: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new store on the same snapshot should see the same queues, as if the server was restarted
+	s2, err := NewSnapshotQueueStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := s2.Len(); n != 2 {
+		t.Fatalf("expected 2 requests in snapshot, got: %v", n)
+	}
+	reqs, err := s2.GetRequests("1")
+	if err != nil || len(reqs) != 2 || reqs[0].ID != "r1" || reqs[1].ID != "r3" || string(reqs[0].Params) != `[{"message":"hi"}]` {
+		t.Fatalf("unexpected queue contents: %+v, %v", reqs, err)
+	}
+	if reqs, err := s2.GetRequests("2"); err != nil || len(reqs) != 0 {
+		t.Fatalf("expected empty queue for user without requests, got: %+v, %v", reqs, err)
+	}
+
+	// malformed snapshots are reported rather than silently discarding the queues
+	ioutil.WriteFile(path, []byte("{"), 0600)
+	if _, err := NewSnapshotQueueStore(path); err == nil {
+		t.Fatal("expected error for malformed snapshot")
+	}
+}
@@ -0,0 +1,126 @@
+// Package file provides file system backed implementation of data interfaces.
+package file
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/titan-x/titan/data"
+)
+
+// QueueStore is a file system backed queue storage.
+// Each user's queued requests are kept in a separate JSON file in the store directory.
+// Files are replaced atomically on each write so a crash never leaves a partially written queue behind.
+type QueueStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewQueueStore creates a new queue store in the given directory. The directory is created if it does not exist.
+func NewQueueStore(dir string) (*QueueStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("file: queue store: failed to create directory %v: %v", dir, err)
+	}
+
+	return &QueueStore{dir: dir}, nil
+}
+
+// AddRequest appends a request to the user's queue.
+func (s *QueueStore) AddRequest(userID string, req *data.QueuedRequest) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	reqs, err := s.read(userID)
+	if err != nil {
+		return err
+	}
+
+	return s.write(userID, append(reqs, *req))
+}
+
+// RemoveRequest removes a request from the user's queue.
+func (s *QueueStore) RemoveRequest(userID, reqID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	reqs, err := s.read(userID)
+	if err != nil {
+		return err
+	}
+
+	for i, r := range reqs {
+		if r.ID == reqID {
+			return s.write(userID, append(reqs[:i], reqs[i+1:]...))
+		}
+	}
+
+	return nil
+}
+
+// GetRequests retrieves all the queued requests for a user, in the order they were added.
+func (s *QueueStore) GetRequests(userID string) ([]data.QueuedRequest, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.read(userID)
+}
+
+// Ping verifies that the store directory is accessible.
+func (s *QueueStore) Ping() error {
+	fi, err := os.Stat(s.dir)
+	if err != nil {
+		return fmt.Errorf("file: queue store: failed to access directory %v: %v", s.dir, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("file: queue store: %v is not a directory", s.dir)
+	}
+	return nil
+}
+
+func (s *QueueStore) path(userID string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(userID))+".json")
+}
+
+func (s *QueueStore) read(userID string) ([]data.QueuedRequest, error) {
+	b, err := ioutil.ReadFile(s.path(userID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file: queue store: failed to read queue for user %v: %v", userID, err)
+	}
+
+	var reqs []data.QueuedRequest
+	if err := json.Unmarshal(b, &reqs); err != nil {
+		return nil, fmt.Errorf("file: queue store: failed to deserialize queue for user %v: %v", userID, err)
+	}
+
+	return reqs, nil
+}
+
+func (s *QueueStore) write(userID string, reqs []data.QueuedRequest) error {
+	p := s.path(userID)
+	if len(reqs) == 0 {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("file: queue store: failed to remove queue for user %v: %v", userID, err)
+		}
+		return nil
+	}
+
+	b, err := json.Marshal(reqs)
+	if err != nil {
+		return fmt.Errorf("file: queue store: failed to serialize queue for user %v: %v", userID, err)
+	}
+
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("file: queue store: failed to write queue for user %v: %v", userID, err)
+	}
+
+	return os.Rename(tmp, p)
+}
@@ -0,0 +1,63 @@
+package file
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/titan-x/titan/data"
+)
+
+func newTestQueueStore(t *testing.T) (*QueueStore, string) {
+	dir, err := ioutil.TempDir("", "titan-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewQueueStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, dir
+}
+
+func TestQueueStore(t *testing.T) {
+	s, dir := newTestQueueStore(t)
+	defer os.RemoveAll(dir)
+
+	for _, id := range []string{"r1", "r2", "r3"} {
+		if err := s.AddRequest("1", &data.QueuedRequest{ID: id, Method: "msg.recv", Params: json.RawMessage(`[{"message":"hi"}]`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.RemoveRequest("1", "r2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new store on the same directory should see the same queue, as if the server was restarted
+	s2, err := NewQueueStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqs, err := s2.GetRequests("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 || reqs[0].ID != "r1" || reqs[1].ID != "r3" || string(reqs[0].Params) != `[{"message":"hi"}]` {
+		t.Fatalf("unexpected queue contents: %+v", reqs)
+	}
+
+	if reqs, err := s2.GetRequests("2"); err != nil || len(reqs) != 0 {
+		t.Fatalf("expected empty queue for user without requests, got: %+v, %v", reqs, err)
+	}
+
+	s2.RemoveRequest("1", "r1")
+	s2.RemoveRequest("1", "r3")
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("expected empty queue files to be removed, got %v files", len(files))
+	}
+}
@@ -0,0 +1,56 @@
+package inmem
+
+import (
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+// maxAuditEntries is the maximum number of audit log entries to keep. Oldest entries are discarded beyond that.
+const maxAuditEntries = 10000
+
+// AuditLog is an in-memory audit log keeping the most recent entries, which is only meant for a single server instance
+// and for testing as the entries are lost upon restart.
+type AuditLog struct {
+	mutex   sync.RWMutex
+	entries []models.AuditEntry // oldest first
+}
+
+// NewAuditLog creates a new in-memory audit log.
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+// AppendAudit appends an entry to the audit log.
+func (l *AuditLog) AppendAudit(e *models.AuditEntry) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.entries = append(l.entries, *e)
+	if n := len(l.entries) - maxAuditEntries; n > 0 {
+		l.entries = append([]models.AuditEntry(nil), l.entries[n:]...)
+	}
+	return nil
+}
+
+// QueryAudit retrieves the entries matching the filter which were recorded at or before the given time, newest first.
+func (l *AuditLog) QueryAudit(f data.AuditFilter, before time.Time, offset, limit int) ([]models.AuditEntry, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	var es []models.AuditEntry
+	for i := len(l.entries) - 1; i >= 0 && len(es) < limit; i-- {
+		e := &l.entries[i]
+		if e.Time.After(before) || !f.Match(e) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		es = append(es, *e)
+	}
+	return es, nil
+}
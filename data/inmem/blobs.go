@@ -0,0 +1,56 @@
+package inmem
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/titan-x/titan/data"
+)
+
+// BlobStore is an in-memory attachment content store, which is only suitable for development and testing
+// as the content of all the attachments is kept in memory.
+type BlobStore struct {
+	mutex sync.RWMutex
+	blobs map[string][]byte
+}
+
+// NewBlobStore creates a new in-memory blob store.
+func NewBlobStore() *BlobStore {
+	return &BlobStore{blobs: make(map[string][]byte)}
+}
+
+// PutBlob stores the content read from r.
+func (s *BlobStore) PutBlob(id string, r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.blobs[id] = b
+	return nil
+}
+
+// GetBlob opens the content with the given ID for reading.
+func (s *BlobStore) GetBlob(id string) (io.ReadCloser, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	b, ok := s.blobs[id]
+	if !ok {
+		return nil, data.ErrBlobNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// DeleteBlob removes the content with the given ID.
+func (s *BlobStore) DeleteBlob(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.blobs, id)
+	return nil
+}
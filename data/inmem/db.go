@@ -1,7 +1,11 @@
 package inmem
 
 import (
+	"bytes"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/titan-x/titan/data"
 	"github.com/titan-x/titan/models"
@@ -10,20 +14,66 @@ import (
 // DB is an in-memory database.
 type DB struct {
 	UserDB
+	GroupDB
+	TokenDB
+	MessageDB
+	KeyDB
+	AttachmentDB
+	TopicDB
+	ContactDB
+	BlockDB
+	VerificationDB
+	TOTPDB
 }
 
 // UserDB is in-memory user database.
 type UserDB struct {
+	users *users
+}
+
+type users struct {
+	mutex  sync.RWMutex
 	ids    map[string]*models.User
 	emails map[string]*models.User
+	phones map[string]*models.User
+	wal    *wal // nil unless the database is persisted
 }
 
 // NewDB creates a new in-memory database.
 func NewDB() *DB {
 	return &DB{
 		UserDB: UserDB{
-			ids:    make(map[string]*models.User),
-			emails: make(map[string]*models.User),
+			users: &users{ids: make(map[string]*models.User), emails: make(map[string]*models.User), phones: make(map[string]*models.User)},
+		},
+		GroupDB: GroupDB{
+			groups: &groups{ids: make(map[string]models.Group)},
+		},
+		TokenDB: TokenDB{
+			tokens: &tokens{ids: make(map[string]models.RefreshToken)},
+		},
+		MessageDB: MessageDB{
+			messages: &messages{ids: make(map[string]models.Message), convs: make(map[string][]string), index: make(map[string]map[string]map[string]int)},
+		},
+		KeyDB: KeyDB{
+			keys: &keys{bundles: make(map[string]models.KeyBundle)},
+		},
+		AttachmentDB: AttachmentDB{
+			attachments: &attachments{ids: make(map[string]models.Attachment)},
+		},
+		TopicDB: TopicDB{
+			topics: &topics{names: make(map[string]models.Topic), subs: make(map[string]map[string]bool), userTs: make(map[string]map[string]bool)},
+		},
+		ContactDB: ContactDB{
+			contacts: &contacts{ids: make(map[string]map[string]bool), of: make(map[string]map[string]bool)},
+		},
+		BlockDB: BlockDB{
+			blocks: &blocks{ids: make(map[string]map[string]bool), convs: make(map[string]map[string]bool)},
+		},
+		VerificationDB: VerificationDB{
+			verifications: &verifications{ids: make(map[string]models.Verification)},
+		},
+		TOTPDB: TOTPDB{
+			totps: &totps{ids: make(map[string]models.TOTP)},
 		},
 	}
 }
@@ -45,23 +95,883 @@ func (db UserDB) Seed(overwrite bool, jwtPass string) error {
 
 // GetByID retrieves a user by ID.
 func (db UserDB) GetByID(id string) (u *models.User, ok bool) {
-	u, ok = db.ids[id]
+	db.users.mutex.RLock()
+	defer db.users.mutex.RUnlock()
+
+	u, ok = db.users.ids[id]
 	return
 }
 
 // GetByEmail retrieves a user by e-mail address.
 func (db UserDB) GetByEmail(email string) (u *models.User, ok bool) {
-	u, ok = db.emails[email]
+	db.users.mutex.RLock()
+	defer db.users.mutex.RUnlock()
+
+	u, ok = db.users.emails[email]
+	return
+}
+
+// GetByPhone retrieves a user by phone number.
+func (db UserDB) GetByPhone(phone string) (u *models.User, ok bool) {
+	db.users.mutex.RLock()
+	defer db.users.mutex.RUnlock()
+
+	// users are updated in place, so the number might have been changed since the user was indexed by it
+	u, ok = db.users.phones[phone]
+	if ok && u.PhoneNumber != phone {
+		return nil, false
+	}
 	return
 }
 
 // SaveUser save or updates a user object in the database.
 func (db UserDB) SaveUser(u *models.User) error {
+	db.users.mutex.Lock()
+	defer db.users.mutex.Unlock()
+
 	if u.ID == "" {
-		u.ID = strconv.Itoa(len(db.ids) + 1)
+		u.ID = strconv.Itoa(len(db.users.ids) + 1)
+	}
+
+	if err := db.users.wal.append(walEntry{Op: "saveUser", User: u}); err != nil {
+		return err
+	}
+	db.putUser(u)
+	return nil
+}
+
+func (db UserDB) putUser(u *models.User) {
+	db.users.ids[u.ID] = u
+	db.users.emails[u.Email] = u
+	if u.PhoneNumber != "" {
+		db.users.phones[u.PhoneNumber] = u
+	}
+}
+
+// GetUserIDs retrieves up to limit user IDs that come after the given one, in ascending order.
+func (db UserDB) GetUserIDs(after string, limit int) ([]string, error) {
+	db.users.mutex.RLock()
+	defer db.users.mutex.RUnlock()
+
+	ids := []string{}
+	for id := range db.users.ids {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+// GetDueDeletions retrieves the IDs of the users whose accounts are to be deleted at or before the given time, in ascending order.
+func (db UserDB) GetDueDeletions(t time.Time) ([]string, error) {
+	db.users.mutex.RLock()
+	defer db.users.mutex.RUnlock()
+
+	ids := []string{}
+	for id, u := range db.users.ids {
+		if !u.DeleteAt.IsZero() && !u.DeleteAt.After(t) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// DeleteUser deletes a user along with the refresh tokens, the encryption keys, and the second factor of the user.
+func (db *DB) DeleteUser(id string) error {
+	db.users.mutex.Lock()
+	db.tokens.mutex.Lock()
+	err := db.users.wal.append(walEntry{Op: "deleteUser", UserID: id})
+	if err == nil {
+		db.deleteUser(id)
+	}
+	db.tokens.mutex.Unlock()
+	db.users.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	db.keys.mutex.Lock()
+	delete(db.keys.bundles, id)
+	db.keys.mutex.Unlock()
+	return db.DeleteTOTP(id)
+}
+
+// deleteUser deletes a user along with the refresh tokens. Caller should hold the locks of both.
+func (db *DB) deleteUser(id string) {
+	if u, ok := db.users.ids[id]; ok {
+		delete(db.users.ids, id)
+		if db.users.emails[u.Email] == u {
+			delete(db.users.emails, u.Email)
+		}
+		if db.users.phones[u.PhoneNumber] == u {
+			delete(db.users.phones, u.PhoneNumber)
+		}
+	}
+	for tid, t := range db.tokens.ids {
+		if t.UserID == id {
+			delete(db.tokens.ids, tid)
+		}
+	}
+}
+
+// GroupDB is in-memory group database.
+type GroupDB struct {
+	groups *groups
+}
+
+type groups struct {
+	mutex sync.RWMutex
+	ids   map[string]models.Group
+}
+
+// GetGroup retrieves a group by ID.
+func (db GroupDB) GetGroup(id string) (g *models.Group, ok bool) {
+	db.groups.mutex.RLock()
+	defer db.groups.mutex.RUnlock()
+
+	gr, ok := db.groups.ids[id]
+	if !ok {
+		return nil, false
+	}
+
+	gr.Members = append([]string(nil), gr.Members...)
+	return &gr, true
+}
+
+// SaveGroup saves or updates a group object in the database.
+func (db GroupDB) SaveGroup(g *models.Group) error {
+	db.groups.mutex.Lock()
+	defer db.groups.mutex.Unlock()
+
+	if g.ID == "" {
+		g.ID = strconv.Itoa(len(db.groups.ids) + 1)
+	}
+
+	gr := *g
+	gr.Members = append([]string(nil), g.Members...)
+	db.groups.ids[g.ID] = gr
+	return nil
+}
+
+// TokenDB is in-memory refresh token database.
+type TokenDB struct {
+	tokens *tokens
+}
+
+type tokens struct {
+	mutex sync.RWMutex
+	ids   map[string]models.RefreshToken
+	wal   *wal // nil unless the database is persisted
+}
+
+// GetRefreshToken retrieves a refresh token by ID.
+func (db TokenDB) GetRefreshToken(id string) (t *models.RefreshToken, ok bool) {
+	db.tokens.mutex.RLock()
+	defer db.tokens.mutex.RUnlock()
+
+	tk, ok := db.tokens.ids[id]
+	if !ok {
+		return nil, false
+	}
+	return &tk, true
+}
+
+// SaveRefreshToken saves or updates a refresh token in the database.
+func (db TokenDB) SaveRefreshToken(t *models.RefreshToken) error {
+	db.tokens.mutex.Lock()
+	defer db.tokens.mutex.Unlock()
+
+	if err := db.tokens.wal.append(walEntry{Op: "saveToken", Token: t}); err != nil {
+		return err
+	}
+	db.tokens.ids[t.ID] = *t
+	return nil
+}
+
+// GetRefreshTokens retrieves all the refresh tokens of a user.
+func (db TokenDB) GetRefreshTokens(userID string) ([]models.RefreshToken, error) {
+	db.tokens.mutex.RLock()
+	defer db.tokens.mutex.RUnlock()
+
+	ts := []models.RefreshToken{}
+	for _, t := range db.tokens.ids {
+		if t.UserID == userID {
+			ts = append(ts, t)
+		}
+	}
+	return ts, nil
+}
+
+// MessageDB is in-memory message history database.
+type MessageDB struct {
+	messages *messages
+}
+
+type messages struct {
+	mutex sync.RWMutex
+	ids   map[string]models.Message
+	convs map[string][]string                  // conversation ID -> message IDs in the order of insertion
+	index map[string]map[string]map[string]int // user ID -> word -> IDs of the messages containing the word -> occurrences
+}
+
+// GetMessage retrieves a message by ID.
+func (db MessageDB) GetMessage(id string) (m *models.Message, ok bool) {
+	db.messages.mutex.RLock()
+	defer db.messages.mutex.RUnlock()
+
+	msg, ok := db.messages.ids[id]
+	if !ok {
+		return nil, false
+	}
+	return &msg, true
+}
+
+// SaveMessage saves or updates a message in the database.
+func (db MessageDB) SaveMessage(m *models.Message) error {
+	db.messages.mutex.Lock()
+	defer db.messages.mutex.Unlock()
+
+	if _, ok := db.messages.ids[m.ID]; !ok {
+		db.messages.convs[m.Conversation] = append(db.messages.convs[m.Conversation], m.ID)
+	}
+	db.messages.ids[m.ID] = *m
+	return nil
+}
+
+// GetMessages retrieves the messages of a conversation sent at or before the given time, newest first, skipping the first offset messages.
+func (db MessageDB) GetMessages(conversation string, before time.Time, offset, limit int) ([]models.Message, error) {
+	db.messages.mutex.RLock()
+	defer db.messages.mutex.RUnlock()
+
+	msgs := []models.Message{}
+	ids := db.messages.convs[conversation]
+	for i := len(ids) - 1; i >= 0 && len(msgs) < limit; i-- {
+		m := db.messages.ids[ids[i]]
+		if m.Time.After(before) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+// IndexMessage indexes the body of a message for the given users.
+func (db MessageDB) IndexMessage(m *models.Message, userIDs []string) error {
+	db.messages.mutex.Lock()
+	defer db.messages.mutex.Unlock()
+
+	for w, n := range data.SearchTerms(m.Message) {
+		for _, uid := range userIDs {
+			if db.messages.index[uid] == nil {
+				db.messages.index[uid] = make(map[string]map[string]int)
+			}
+			if db.messages.index[uid][w] == nil {
+				db.messages.index[uid][w] = make(map[string]int)
+			}
+			db.messages.index[uid][w][m.ID] = n
+		}
+	}
+	return nil
+}
+
+// SearchMessages retrieves the messages indexed for a user which contain all the words of the query, ordered by relevance, then newest first.
+func (db MessageDB) SearchMessages(userID, query, conversation string, before time.Time, offset, limit int) ([]models.Message, error) {
+	db.messages.mutex.RLock()
+	defer db.messages.mutex.RUnlock()
+
+	type match struct {
+		msg   models.Message
+		score int
+	}
+
+	// candidates are the messages containing any one of the query words, as all of them must match
+	q := data.SearchTerms(query)
+	var word string
+	for w := range q {
+		word = w
+		break
+	}
+
+	var ms []match
+	for id := range db.messages.index[userID][word] {
+		m, ok := db.messages.ids[id]
+		if !ok || m.Time.After(before) || (conversation != "" && m.Conversation != conversation) {
+			continue
+		}
+
+		terms := make(map[string]int, len(q))
+		for w := range q {
+			terms[w] = db.messages.index[userID][w][id]
+		}
+		if score := data.SearchScore(terms, q); score > 0 {
+			ms = append(ms, match{msg: m, score: score})
+		}
+	}
+
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].score != ms[j].score {
+			return ms[i].score > ms[j].score
+		}
+		return ms[i].msg.Time.After(ms[j].msg.Time)
+	})
+
+	msgs := []models.Message{}
+	for i := offset; i < len(ms) && len(msgs) < limit; i++ {
+		msgs = append(msgs, ms[i].msg)
+	}
+	return msgs, nil
+}
+
+// DeleteUserMessages deletes the messages sent by a user, along with the search index of the user.
+func (db MessageDB) DeleteUserMessages(userID string) error {
+	db.messages.mutex.Lock()
+	defer db.messages.mutex.Unlock()
+
+	for id, m := range db.messages.ids {
+		if m.From != userID {
+			continue
+		}
+		delete(db.messages.ids, id)
+		ids := db.messages.convs[m.Conversation]
+		for i, cid := range ids {
+			if cid == id {
+				db.messages.convs[m.Conversation] = append(ids[:i:i], ids[i+1:]...)
+				break
+			}
+		}
+	}
+	delete(db.messages.index, userID)
+	return nil
+}
+
+// UpdateDeliveryState sets the latest delivery state of a message.
+func (db MessageDB) UpdateDeliveryState(id, state string) error {
+	db.messages.mutex.Lock()
+	defer db.messages.mutex.Unlock()
+
+	if m, ok := db.messages.ids[id]; ok {
+		m.State = state
+		db.messages.ids[id] = m
+	}
+	return nil
+}
+
+// DeleteMessagesBefore deletes the messages sent before the given time, along with their search index.
+func (db MessageDB) DeleteMessagesBefore(t time.Time) (int, error) {
+	db.messages.mutex.Lock()
+	defer db.messages.mutex.Unlock()
+
+	deleted := make(map[string]bool)
+	for conv, ids := range db.messages.convs {
+		kept := ids[:0]
+		for _, id := range ids {
+			if db.messages.ids[id].Time.Before(t) {
+				delete(db.messages.ids, id)
+				deleted[id] = true
+				continue
+			}
+			kept = append(kept, id)
+		}
+		if len(kept) == 0 {
+			delete(db.messages.convs, conv)
+		} else {
+			db.messages.convs[conv] = kept
+		}
+	}
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+
+	for _, words := range db.messages.index {
+		for w, ids := range words {
+			for id := range ids {
+				if deleted[id] {
+					delete(ids, id)
+				}
+			}
+			if len(ids) == 0 {
+				delete(words, w)
+			}
+		}
+	}
+	return len(deleted), nil
+}
+
+// KeyDB is in-memory end-to-end encryption key database.
+type KeyDB struct {
+	keys *keys
+}
+
+type keys struct {
+	mutex   sync.Mutex
+	bundles map[string]models.KeyBundle // user ID -> keys, with the one-time prekeys in the order of upload
+}
+
+// SaveKeys replaces the identity key and the signed prekey of a user, and adds the one-time prekeys.
+// Existing one-time prekeys are dropped if the identity key changes.
+func (db KeyDB) SaveKeys(b *models.KeyBundle) error {
+	db.keys.mutex.Lock()
+	defer db.keys.mutex.Unlock()
+
+	var pks []models.PreKey
+	if kb, ok := db.keys.bundles[b.UserID]; ok && bytes.Equal(kb.IdentityKey, b.IdentityKey) {
+		pks = kb.PreKeys
+	}
+
+	kb := *b
+	if b.SignedPreKey != nil {
+		spk := *b.SignedPreKey
+		kb.SignedPreKey = &spk
 	}
+	kb.PreKeys = append(append([]models.PreKey(nil), pks...), b.PreKeys...)
+	db.keys.bundles[b.UserID] = kb
+	return nil
+}
+
+// GetKeys retrieves the identity key and the signed prekey of a user.
+func (db KeyDB) GetKeys(userID string) (b *models.KeyBundle, ok bool, err error) {
+	db.keys.mutex.Lock()
+	defer db.keys.mutex.Unlock()
+
+	b, ok = db.getKeys(userID)
+	return b, ok, nil
+}
+
+// ClaimKeys retrieves the key bundle of a user along with the oldest one-time prekey, if any, which is removed.
+func (db KeyDB) ClaimKeys(userID string) (b *models.KeyBundle, ok bool, err error) {
+	db.keys.mutex.Lock()
+	defer db.keys.mutex.Unlock()
+
+	b, ok = db.getKeys(userID)
+	if kb := db.keys.bundles[userID]; ok && len(kb.PreKeys) != 0 {
+		b.PreKeys = []models.PreKey{kb.PreKeys[0]}
+		kb.PreKeys = kb.PreKeys[1:]
+		db.keys.bundles[userID] = kb
+	}
+	return b, ok, nil
+}
+
+func (db KeyDB) getKeys(userID string) (*models.KeyBundle, bool) {
+	kb, ok := db.keys.bundles[userID]
+	if !ok {
+		return nil, false
+	}
+
+	if kb.SignedPreKey != nil {
+		spk := *kb.SignedPreKey
+		kb.SignedPreKey = &spk
+	}
+	kb.PreKeys = nil
+	return &kb, true
+}
+
+// CountPreKeys returns the number of one-time prekeys left for a user.
+func (db KeyDB) CountPreKeys(userID string) (int, error) {
+	db.keys.mutex.Lock()
+	defer db.keys.mutex.Unlock()
+
+	return len(db.keys.bundles[userID].PreKeys), nil
+}
+
+// AttachmentDB is in-memory attachment metadata database.
+type AttachmentDB struct {
+	attachments *attachments
+}
+
+type attachments struct {
+	mutex sync.RWMutex
+	ids   map[string]models.Attachment
+}
+
+// GetAttachment retrieves the metadata of an attachment by ID.
+func (db AttachmentDB) GetAttachment(id string) (a *models.Attachment, ok bool, err error) {
+	db.attachments.mutex.RLock()
+	defer db.attachments.mutex.RUnlock()
+
+	at, ok := db.attachments.ids[id]
+	if !ok {
+		return nil, false, nil
+	}
+
+	at.Conversations = append([]string(nil), at.Conversations...)
+	at.Thumbnails = append([]models.Thumbnail(nil), at.Thumbnails...)
+	return &at, true, nil
+}
+
+// SaveAttachment saves or updates the metadata of an attachment.
+func (db AttachmentDB) SaveAttachment(a *models.Attachment) error {
+	db.attachments.mutex.Lock()
+	defer db.attachments.mutex.Unlock()
+
+	at := *a
+	at.Conversations = append([]string(nil), a.Conversations...)
+	at.Thumbnails = append([]models.Thumbnail(nil), a.Thumbnails...)
+	db.attachments.ids[a.ID] = at
+	return nil
+}
+
+// DeleteAttachment deletes the metadata of an attachment, if any.
+func (db AttachmentDB) DeleteAttachment(id string) error {
+	db.attachments.mutex.Lock()
+	defer db.attachments.mutex.Unlock()
+
+	delete(db.attachments.ids, id)
+	return nil
+}
+
+// GetAttachments retrieves all the attachments of a user.
+func (db AttachmentDB) GetAttachments(owner string) ([]models.Attachment, error) {
+	db.attachments.mutex.RLock()
+	defer db.attachments.mutex.RUnlock()
+
+	as := []models.Attachment{}
+	for _, a := range db.attachments.ids {
+		if a.Owner == owner {
+			a.Conversations = append([]string(nil), a.Conversations...)
+			as = append(as, a)
+		}
+	}
+	return as, nil
+}
+
+// TopicDB is in-memory topic and subscriber database.
+type TopicDB struct {
+	topics *topics
+}
+
+type topics struct {
+	mutex  sync.RWMutex
+	names  map[string]models.Topic
+	subs   map[string]map[string]bool // topic name -> subscriber IDs
+	userTs map[string]map[string]bool // user ID -> subscribed topic names
+}
+
+// GetTopic retrieves a topic by name.
+func (db TopicDB) GetTopic(name string) (t *models.Topic, ok bool, err error) {
+	db.topics.mutex.RLock()
+	defer db.topics.mutex.RUnlock()
+
+	tp, ok := db.topics.names[name]
+	if !ok {
+		return nil, false, nil
+	}
+	return &tp, true, nil
+}
+
+// SaveTopic saves or updates a topic.
+func (db TopicDB) SaveTopic(t *models.Topic) error {
+	db.topics.mutex.Lock()
+	defer db.topics.mutex.Unlock()
+
+	db.topics.names[t.Name] = *t
+	return nil
+}
+
+// Subscribe adds a user to the subscribers of a topic.
+func (db TopicDB) Subscribe(topic, userID string) error {
+	db.topics.mutex.Lock()
+	defer db.topics.mutex.Unlock()
+
+	if db.topics.subs[topic] == nil {
+		db.topics.subs[topic] = make(map[string]bool)
+	}
+	if db.topics.userTs[userID] == nil {
+		db.topics.userTs[userID] = make(map[string]bool)
+	}
+	db.topics.subs[topic][userID] = true
+	db.topics.userTs[userID][topic] = true
+	return nil
+}
+
+// Unsubscribe removes a user from the subscribers of a topic.
+func (db TopicDB) Unsubscribe(topic, userID string) error {
+	db.topics.mutex.Lock()
+	defer db.topics.mutex.Unlock()
+
+	delete(db.topics.subs[topic], userID)
+	delete(db.topics.userTs[userID], topic)
+	return nil
+}
+
+// GetSubscribers retrieves up to limit subscriber IDs of a topic that come after the given one, in ascending order.
+func (db TopicDB) GetSubscribers(topic, after string, limit int) ([]string, error) {
+	db.topics.mutex.RLock()
+	defer db.topics.mutex.RUnlock()
+
+	ids := []string{}
+	for id := range db.topics.subs[topic] {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+// GetSubscriptions retrieves the names of the topics a user is subscribed to, in ascending order.
+func (db TopicDB) GetSubscriptions(userID string) ([]string, error) {
+	db.topics.mutex.RLock()
+	defer db.topics.mutex.RUnlock()
+
+	names := []string{}
+	for name := range db.topics.userTs[userID] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ContactDB is in-memory contact roster database.
+type ContactDB struct {
+	contacts *contacts
+}
+
+type contacts struct {
+	mutex sync.RWMutex
+	ids   map[string]map[string]bool // user ID -> contact IDs
+	of    map[string]map[string]bool // contact ID -> IDs of the users who have the contact
+}
+
+// AddContact adds a user to the contacts of another.
+func (db ContactDB) AddContact(userID, contactID string) error {
+	db.contacts.mutex.Lock()
+	defer db.contacts.mutex.Unlock()
+
+	if db.contacts.ids[userID] == nil {
+		db.contacts.ids[userID] = make(map[string]bool)
+	}
+	if db.contacts.of[contactID] == nil {
+		db.contacts.of[contactID] = make(map[string]bool)
+	}
+	db.contacts.ids[userID][contactID] = true
+	db.contacts.of[contactID][userID] = true
+	return nil
+}
+
+// RemoveContact removes a user from the contacts of another.
+func (db ContactDB) RemoveContact(userID, contactID string) error {
+	db.contacts.mutex.Lock()
+	defer db.contacts.mutex.Unlock()
+
+	delete(db.contacts.ids[userID], contactID)
+	delete(db.contacts.of[contactID], userID)
+	return nil
+}
+
+// GetContacts retrieves the IDs of the contacts of a user, in ascending order.
+func (db ContactDB) GetContacts(userID string) ([]string, error) {
+	db.contacts.mutex.RLock()
+	defer db.contacts.mutex.RUnlock()
+
+	ids := []string{}
+	for id := range db.contacts.ids[userID] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// GetContactOf retrieves the IDs of the users who have the given user in their contacts, in ascending order.
+func (db ContactDB) GetContactOf(contactID string) ([]string, error) {
+	db.contacts.mutex.RLock()
+	defer db.contacts.mutex.RUnlock()
+
+	ids := []string{}
+	for id := range db.contacts.of[contactID] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// HasContact tells whether a user is in the contacts of another.
+func (db ContactDB) HasContact(userID, contactID string) (bool, error) {
+	db.contacts.mutex.RLock()
+	defer db.contacts.mutex.RUnlock()
+
+	return db.contacts.ids[userID][contactID], nil
+}
+
+// BlockDB is in-memory block and mute list database.
+type BlockDB struct {
+	blocks *blocks
+}
+
+type blocks struct {
+	mutex sync.RWMutex
+	ids   map[string]map[string]bool // user ID -> blocked user IDs
+	convs map[string]map[string]bool // user ID -> muted conversation IDs
+}
+
+// Block adds a user to the users blocked by another.
+func (db BlockDB) Block(userID, blockedID string) error {
+	return db.blocks.add(db.blocks.ids, userID, blockedID)
+}
+
+// Unblock removes a user from the users blocked by another.
+func (db BlockDB) Unblock(userID, blockedID string) error {
+	return db.blocks.remove(db.blocks.ids, userID, blockedID)
+}
+
+// GetBlocked retrieves the IDs of the users blocked by a user, in ascending order.
+func (db BlockDB) GetBlocked(userID string) ([]string, error) {
+	return db.blocks.list(db.blocks.ids, userID)
+}
+
+// IsBlocked tells whether a user is blocked by another.
+func (db BlockDB) IsBlocked(userID, blockedID string) (bool, error) {
+	return db.blocks.has(db.blocks.ids, userID, blockedID)
+}
+
+// Mute adds a conversation to the conversations muted by a user.
+func (db BlockDB) Mute(userID, conversation string) error {
+	return db.blocks.add(db.blocks.convs, userID, conversation)
+}
+
+// Unmute removes a conversation from the conversations muted by a user.
+func (db BlockDB) Unmute(userID, conversation string) error {
+	return db.blocks.remove(db.blocks.convs, userID, conversation)
+}
+
+// GetMuted retrieves the IDs of the conversations muted by a user, in ascending order.
+func (db BlockDB) GetMuted(userID string) ([]string, error) {
+	return db.blocks.list(db.blocks.convs, userID)
+}
+
+// IsMuted tells whether a conversation is muted by a user.
+func (db BlockDB) IsMuted(userID, conversation string) (bool, error) {
+	return db.blocks.has(db.blocks.convs, userID, conversation)
+}
+
+func (b *blocks) add(m map[string]map[string]bool, userID, id string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if m[userID] == nil {
+		m[userID] = make(map[string]bool)
+	}
+	m[userID][id] = true
+	return nil
+}
+
+func (b *blocks) remove(m map[string]map[string]bool, userID, id string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(m[userID], id)
+	return nil
+}
+
+func (b *blocks) list(m map[string]map[string]bool, userID string) ([]string, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	ids := []string{}
+	for id := range m[userID] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (b *blocks) has(m map[string]map[string]bool, userID, id string) (bool, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return m[userID][id], nil
+}
+
+// VerificationDB is in-memory pending verification database.
+type VerificationDB struct {
+	verifications *verifications
+}
+
+type verifications struct {
+	mutex sync.RWMutex
+	ids   map[string]models.Verification // kind:target -> verification
+}
+
+// GetVerification retrieves the pending verification of the given kind for an address.
+func (db VerificationDB) GetVerification(kind, target string) (v *models.Verification, ok bool, err error) {
+	db.verifications.mutex.RLock()
+	defer db.verifications.mutex.RUnlock()
+
+	vr, ok := db.verifications.ids[kind+":"+target]
+	if !ok {
+		return nil, false, nil
+	}
+	return &vr, true, nil
+}
+
+// SaveVerification creates or replaces the pending verification of an address.
+func (db VerificationDB) SaveVerification(v *models.Verification) error {
+	db.verifications.mutex.Lock()
+	defer db.verifications.mutex.Unlock()
+
+	db.verifications.ids[v.Kind+":"+v.Target] = *v
+	return nil
+}
+
+// DeleteVerification deletes the pending verification of an address, if any.
+func (db VerificationDB) DeleteVerification(kind, target string) error {
+	db.verifications.mutex.Lock()
+	defer db.verifications.mutex.Unlock()
+
+	delete(db.verifications.ids, kind+":"+target)
+	return nil
+}
+
+// TOTPDB is in-memory second factor database.
+type TOTPDB struct {
+	totps *totps
+}
+
+type totps struct {
+	mutex sync.RWMutex
+	ids   map[string]models.TOTP // user ID -> second factor
+}
+
+// GetTOTP retrieves the second factor of a user.
+func (db TOTPDB) GetTOTP(userID string) (t *models.TOTP, ok bool, err error) {
+	db.totps.mutex.RLock()
+	defer db.totps.mutex.RUnlock()
+
+	tt, ok := db.totps.ids[userID]
+	if !ok {
+		return nil, false, nil
+	}
+	tt.RecoveryCodes = append([]string(nil), tt.RecoveryCodes...)
+	return &tt, true, nil
+}
+
+// SaveTOTP creates or replaces the second factor of a user.
+func (db TOTPDB) SaveTOTP(t *models.TOTP) error {
+	db.totps.mutex.Lock()
+	defer db.totps.mutex.Unlock()
+
+	tt := *t
+	tt.RecoveryCodes = append([]string(nil), t.RecoveryCodes...)
+	db.totps.ids[t.UserID] = tt
+	return nil
+}
+
+// DeleteTOTP deletes the second factor of a user, if any.
+func (db TOTPDB) DeleteTOTP(userID string) error {
+	db.totps.mutex.Lock()
+	defer db.totps.mutex.Unlock()
 
-	db.ids[u.ID] = u
-	db.emails[u.Email] = u
+	delete(db.totps.ids, userID)
 	return nil
 }
@@ -0,0 +1,111 @@
+package inmem
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+// maxDeliveryAttempts is the number of times a request is tried to be sent to a user's connections before it is dead-lettered.
+const maxDeliveryAttempts = 5
+
+// maxDeadLetters is the maximum number of dead letters to keep. Oldest dead letters are discarded beyond that.
+const maxDeadLetters = 10000
+
+// deadLetters keeps the requests that could not be delivered, oldest first.
+type deadLetters struct {
+	mutex   sync.Mutex
+	list    []models.DeadLetter
+	handler func(dl models.DeadLetter) // optional subscriber to the new dead letters
+}
+
+// OnDeadLetter sets the handler to be called with each request as it is dead-lettered.
+func (q *Queue) OnDeadLetter(handler func(dl models.DeadLetter)) {
+	q.deadLetters.mutex.Lock()
+	defer q.deadLetters.mutex.Unlock()
+	q.deadLetters.handler = handler
+}
+
+// DeadLetters retrieves the requests that could not be delivered to the given user, or to any user if the user ID is empty, oldest first.
+func (q *Queue) DeadLetters(userID string) []models.DeadLetter {
+	q.deadLetters.mutex.Lock()
+	defer q.deadLetters.mutex.Unlock()
+
+	var dls []models.DeadLetter
+	for _, dl := range q.deadLetters.list {
+		if userID == "" || dl.UserID == userID {
+			dls = append(dls, dl)
+		}
+	}
+	return dls
+}
+
+// Redrive puts a dead-lettered request back in the recipient's queue, with a fresh delivery attempt count and no TTL.
+// Response handler of the original request is lost, so responses to the re-driven request are discarded.
+// ok is false if there is no dead letter with the given request ID.
+func (q *Queue) Redrive(id string) (ok bool, err error) {
+	q.deadLetters.mutex.Lock()
+	var dl models.DeadLetter
+	for i, d := range q.deadLetters.list {
+		if d.ID == id {
+			dl, ok = d, true
+			q.deadLetters.list = append(q.deadLetters.list[:i], q.deadLetters.list[i+1:]...)
+			break
+		}
+	}
+	q.deadLetters.mutex.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	if q.store != nil {
+		if err := q.store.AddRequest(dl.UserID, &data.QueuedRequest{ID: dl.ID, Method: dl.Method, Params: dl.Params}); err != nil {
+			return true, err
+		}
+	}
+
+	q.addReqChan <- addReqChan{userID: dl.UserID, queuedReq: queuedReq{ID: dl.ID, Method: dl.Method, Params: dl.Params, ResHandler: restoredResHandler}}
+	return true, nil
+}
+
+// deadLetter sets aside a request that could not be delivered, along with the failure reason, and removes it from the store, if any.
+// Rest of the request's conversation is no longer held by it. Removing the request from the in-memory queue is up to the caller.
+func (q *Queue) deadLetter(userID string, req queuedReq, reason string) {
+	logger.Warnf("request %v (%v) for user %v is dead-lettered: %v", req.ID, req.Method, userID, reason)
+	if reason == models.DeadLetterExpired {
+		data.QueueExpired.Add(1)
+	}
+
+	p, ok := req.Params.(json.RawMessage)
+	if !ok {
+		var err error
+		if p, err = json.Marshal(req.Params); err != nil {
+			logger.Errorf("failed to serialize dead-lettered request %v for user %v: %v", req.ID, userID, err)
+		}
+	}
+
+	q.receipts.drop(req.Method, p)
+
+	dl := models.DeadLetter{ID: req.ID, UserID: userID, Method: req.Method, Params: p, Reason: reason, Time: time.Now()}
+	q.deadLetters.mutex.Lock()
+	q.deadLetters.list = append(q.deadLetters.list, dl)
+	if n := len(q.deadLetters.list) - maxDeadLetters; n > 0 {
+		q.deadLetters.list = append([]models.DeadLetter(nil), q.deadLetters.list[n:]...)
+	}
+	handler := q.deadLetters.handler
+	q.deadLetters.mutex.Unlock()
+	if handler != nil {
+		handler(dl)
+	}
+
+	q.release(userID, req)
+	if q.store != nil {
+		if err := q.store.RemoveRequest(userID, req.ID); err != nil {
+			logger.Errorf("failed to remove dead-lettered request %v for user %v from store: %v", req.ID, userID, err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package inmem
+
+import (
+	"sync"
+
+	"github.com/titan-x/titan/models"
+)
+
+// EventBus is an in-memory event bus for subscribing to the events of a single server instance.
+// Handlers are called synchronously by the publisher so they should not block for long.
+type EventBus struct {
+	mutex sync.RWMutex
+	subs  map[string][]func(e *models.Event) // event type -> handlers
+}
+
+// NewEventBus creates a new in-memory event bus.
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[string][]func(e *models.Event))}
+}
+
+// Publish calls the handlers subscribed to the event type, and to all events.
+func (b *EventBus) Publish(e *models.Event) error {
+	b.mutex.RLock()
+	handlers := append(append([]func(e *models.Event){}, b.subs[e.Type]...), b.subs[""]...)
+	b.mutex.RUnlock()
+
+	for _, h := range handlers {
+		h(e)
+	}
+	return nil
+}
+
+// Subscribe registers a handler for the given event type, or for all events if event type is empty.
+func (b *EventBus) Subscribe(eventType string, handler func(e *models.Event)) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.subs[eventType] = append(b.subs[eventType], handler)
+	return nil
+}
@@ -0,0 +1,192 @@
+package inmem
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/trace"
+)
+
+const (
+	ackTimeoutDefault = time.Second * 30 // time to wait for a response to a sent request before sending it again
+	retryBackoffBase  = time.Second      // delay before the first redelivery, which is doubled with each redelivery
+	retryBackoffMax   = time.Minute
+)
+
+// inflight keeps the requests that are sent but not yet responded to (acknowledged).
+type inflight struct {
+	mutex   sync.Mutex
+	timeout time.Duration
+	reqs    map[string]*inflightReq // request ID -> request
+	holders map[string]string       // user ID + conversation -> ID of the request to be acknowledged before the rest of the conversation
+}
+
+type inflightReq struct {
+	userID string
+	req    queuedReq
+	conns  map[string]bool // connections the request is sent through
+	timer  *time.Timer
+	span   trace.Span // delivery attempt, ended upon the response or redelivery
+}
+
+// SetAckTimeout sets the time to wait for a response to a sent request before sending it again.
+// If not set, 30 seconds is used.
+func (q *Queue) SetAckTimeout(timeout time.Duration) {
+	q.inflight.mutex.Lock()
+	defer q.inflight.mutex.Unlock()
+	q.inflight.timeout = timeout
+}
+
+// send sends a request to all of the given connections of a user and tracks it until any of the connections responds.
+// Response handler of the request is called with the responses from each of the connections as usual.
+// Returns false if the request could not be sent through any of the connections, in which case the request still holds its conversation.
+func (q *Queue) send(userID string, req queuedReq, conns []string) bool {
+	span := trace.Start(req.Trace, "queue.send")
+	span.SetAttr("user", userID)
+	span.SetAttr("request", req.ID)
+	span.SetAttr("method", req.Method)
+	span.SetAttr("redeliveries", req.Redeliveries)
+	ir := &inflightReq{userID: userID, req: req, conns: make(map[string]bool), span: span}
+	q.inflight.mutex.Lock()
+	q.inflight.reqs[req.ID] = ir
+	q.hold(userID, req)
+	q.inflight.mutex.Unlock()
+
+	resHandler := func(ctx *neptulon.ResCtx) error {
+		q.ack(req.ID)
+		if req.ResHandler == nil {
+			return nil
+		}
+		return req.ResHandler(ctx)
+	}
+
+	var sent []string
+	for _, connID := range conns {
+		if _, err := q.senderFunc(connID, req.Method, req.Params, resHandler); err == nil {
+			sent = append(sent, connID)
+		}
+	}
+
+	q.inflight.mutex.Lock()
+	defer q.inflight.mutex.Unlock()
+
+	if len(sent) == 0 {
+		delete(q.inflight.reqs, req.ID)
+		span.SetError(errors.New("no connection to send through"))
+		span.End()
+		return false
+	}
+	span.SetAttr("conns", len(sent))
+	if q.inflight.reqs[req.ID] != ir {
+		return true // already acknowledged
+	}
+
+	for _, connID := range sent {
+		ir.conns[connID] = true
+	}
+	timeout := q.inflight.timeout
+	if timeout <= 0 {
+		timeout = ackTimeoutDefault
+	}
+	ir.timer = time.AfterFunc(timeout, func() { q.redeliver(req.ID, true) })
+	return true
+}
+
+// ack marks a sent request as delivered upon the first response to it.
+func (q *Queue) ack(reqID string) {
+	q.inflight.mutex.Lock()
+	ir, ok := q.inflight.reqs[reqID]
+	if ok {
+		delete(q.inflight.reqs, reqID)
+		if ir.timer != nil {
+			ir.timer.Stop()
+		}
+	}
+	q.inflight.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	ir.span.End()
+	q.release(ir.userID, ir.req)
+	if q.store != nil {
+		if err := q.store.RemoveRequest(ir.userID, reqID); err != nil {
+			logger.Errorf("failed to remove delivered request %v for user %v from store: %v", reqID, ir.userID, err)
+		}
+	}
+
+	q.doneReqChan <- doneReqChan{userID: ir.userID, reqID: reqID}
+	data.QueueLength.Add(-1)
+}
+
+// dropConn puts the requests sent through a closed connection back in the queue,
+// unless they were also sent through other connections of the user which might still respond.
+func (q *Queue) dropConn(connID string) {
+	q.inflight.mutex.Lock()
+	var ids []string
+	for id, ir := range q.inflight.reqs {
+		if !ir.conns[connID] {
+			continue
+		}
+		if delete(ir.conns, connID); len(ir.conns) == 0 {
+			ids = append(ids, id)
+		}
+	}
+	q.inflight.mutex.Unlock()
+
+	for _, id := range ids {
+		q.redeliver(id, false)
+	}
+}
+
+// redeliver puts a sent but not responded request back in the queue after an exponential backoff delay.
+// Requests which time out count as failed delivery attempts, and are dead-lettered after the max delivery attempts.
+// Conversation of the request stays held in the meantime, so it is sent again before the later messages of the conversation.
+func (q *Queue) redeliver(reqID string, timedOut bool) {
+	q.inflight.mutex.Lock()
+	ir, ok := q.inflight.reqs[reqID]
+	if ok {
+		delete(q.inflight.reqs, reqID)
+		if ir.timer != nil {
+			ir.timer.Stop()
+		}
+	}
+	q.inflight.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if timedOut {
+		ir.span.SetError(errors.New("ack timeout"))
+	} else {
+		ir.span.SetError(errors.New("connection closed"))
+	}
+	ir.span.End()
+
+	req := ir.req
+	if timedOut {
+		if req.Attempts++; req.Attempts >= maxDeliveryAttempts {
+			q.deadLetter(ir.userID, req, models.DeadLetterMaxAttempts)
+			q.doneReqChan <- doneReqChan{userID: ir.userID, reqID: req.ID}
+			data.QueueLength.Add(-1)
+			return
+		}
+	}
+
+	delay := retryBackoffBase << uint(req.Redeliveries)
+	if delay > retryBackoffMax || delay <= 0 {
+		delay = retryBackoffMax
+	}
+	req.Redeliveries++
+	logger.Debugf("redelivering request %v (%v) for user %v in %v", req.ID, req.Method, ir.userID, delay)
+
+	time.AfterFunc(delay, func() {
+		q.addReqChan <- addReqChan{userID: ir.userID, queuedReq: req, requeue: true}
+	})
+}
@@ -0,0 +1,122 @@
+package inmem
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+// sendRetryDelay is the delay before trying again to send a request which could not be sent through any of the user's connections.
+const sendRetryDelay = time.Millisecond * 100
+
+// orderKey returns the conversation of a msg.recv request, so the messages of a conversation are delivered in the order
+// they are queued. Other requests are not ordered, and have an empty key.
+func orderKey(method string, params interface{}) string {
+	if method != "msg.recv" {
+		return ""
+	}
+
+	switch p := params.(type) {
+	case []models.Message:
+		if len(p) != 0 {
+			return p[0].Conversation
+		}
+	case json.RawMessage:
+		var msgs []struct {
+			Conversation string `json:"conversation"`
+		}
+		if err := json.Unmarshal(p, &msgs); err == nil && len(msgs) != 0 {
+			return msgs[0].Conversation
+		}
+	}
+	return ""
+}
+
+// holderKey is the key of a user's conversation in the list of requests holding the conversations.
+func holderKey(userID, key string) string {
+	return userID + "\x00" + key
+}
+
+// canSend reports whether a request can be sent now, which is the case unless an earlier request of the same conversation
+// is still waiting to be acknowledged.
+func (q *Queue) canSend(userID string, req queuedReq) bool {
+	if req.Key == "" {
+		return true
+	}
+
+	q.inflight.mutex.Lock()
+	defer q.inflight.mutex.Unlock()
+	id, ok := q.inflight.holders[holderKey(userID, req.Key)]
+	return !ok || id == req.ID
+}
+
+// hold blocks the rest of the requests of a request's conversation until the request is acknowledged or dead-lettered.
+// Conversation stays held while the request waits to be sent again, so the later requests cannot overtake it.
+// Caller should hold the inflight mutex.
+func (q *Queue) hold(userID string, req queuedReq) {
+	if req.Key != "" {
+		q.inflight.holders[holderKey(userID, req.Key)] = req.ID
+	}
+}
+
+// release allows the rest of the requests of a request's conversation to be sent, if the conversation is held by the request.
+func (q *Queue) release(userID string, req queuedReq) {
+	if req.Key == "" {
+		return
+	}
+
+	q.inflight.mutex.Lock()
+	defer q.inflight.mutex.Unlock()
+	k := holderKey(userID, req.Key)
+	if q.inflight.holders[k] == req.ID {
+		delete(q.inflight.holders, k)
+	}
+}
+
+// insertBySeq inserts a request into a list of requests sorted in the order of enqueueing.
+func insertBySeq(reqs []queuedReq, req queuedReq) []queuedReq {
+	i := len(reqs)
+	for i > 0 && reqs[i-1].Seq > req.Seq {
+		i--
+	}
+	reqs = append(reqs, queuedReq{})
+	copy(reqs[i+1:], reqs[i:])
+	reqs[i] = req
+	return reqs
+}
+
+// dispatch sends the waiting requests of a user in the order of enqueueing, skipping the ones whose conversation is held
+// by an earlier request, and returns the requests left waiting. It stops at the first request which could not be sent
+// through any of the connections, in which case failed is true.
+func (q *Queue) dispatch(userID string, waiting []queuedReq, conns []string) (rest []queuedReq, failed bool) {
+	now := time.Now()
+	for i, req := range waiting {
+		if !q.canSend(userID, req) {
+			rest = append(rest, req)
+			continue
+		}
+
+		if req.expired(now) {
+			q.deadLetter(userID, req, models.DeadLetterExpired)
+			q.doneReqChan <- doneReqChan{userID: userID, reqID: req.ID}
+			data.QueueLength.Add(-1)
+			continue
+		}
+
+		if q.send(userID, req, conns) {
+			continue
+		}
+
+		if req.Attempts++; req.Attempts >= maxDeliveryAttempts {
+			q.deadLetter(userID, req, models.DeadLetterMaxAttempts)
+			q.doneReqChan <- doneReqChan{userID: userID, reqID: req.ID}
+			data.QueueLength.Add(-1)
+		} else {
+			rest = append(rest, req)
+		}
+		return append(rest, waiting[i+1:]...), true
+	}
+	return rest, false
+}
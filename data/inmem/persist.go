@@ -0,0 +1,209 @@
+package inmem
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+)
+
+var dbLogger = log.Component("inmem")
+
+// snapshot is the persisted state of the database.
+type snapshot struct {
+	Users  []models.User
+	Tokens []models.RefreshToken
+}
+
+// walEntry is a change to the database recorded in the write-ahead log, to be replayed on top of the last snapshot.
+// Entries carry the whole object, so replaying an entry which is already in the snapshot does no harm.
+type walEntry struct {
+	Op     string               `json:"op"` // saveUser, deleteUser, or saveToken
+	User   *models.User         `json:"user,omitempty"`
+	Token  *models.RefreshToken `json:"token,omitempty"`
+	UserID string               `json:"userid,omitempty"`
+}
+
+// wal is the write-ahead log of a persisted database. Locks are always acquired in users, tokens, wal order.
+type wal struct {
+	path  string // snapshot file, log is at path + ".wal"
+	mutex sync.Mutex
+	file  *os.File
+	done  chan struct{}
+}
+
+// Persist loads the users and the refresh tokens (including the device tokens of the users) from the snapshot file
+// at the given path and the write-ahead log next to it (path + ".wal"), and records the changes to them in the log from then on.
+// A new snapshot is taken every interval (if greater than zero), and upon closing, and the log is truncated.
+// Persist must be called before using the database. Other data (i.e. messages and groups) is still only kept in memory.
+func (db *DB) Persist(path string, interval time.Duration) error {
+	if err := db.load(path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path+".wal", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("inmem: failed to open write-ahead log: %v", err)
+	}
+	w := &wal{path: path, file: f, done: make(chan struct{})}
+	db.users.wal = w
+	db.tokens.wal = w
+
+	// compact the replayed log right away so a partial entry from a crash is not followed by the new ones
+	if err := db.Snapshot(); err != nil {
+		return err
+	}
+
+	if interval > 0 {
+		go func() {
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					if err := db.Snapshot(); err != nil {
+						dbLogger.Errorf("%v", err)
+					}
+				case <-w.done:
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// load reads the snapshot and replays the write-ahead log on top of it, if they exist.
+func (db *DB) load(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("inmem: failed to read snapshot %v: %v", path, err)
+	}
+	if err == nil {
+		var s snapshot
+		if err := json.Unmarshal(b, &s); err != nil {
+			return fmt.Errorf("inmem: failed to deserialize snapshot %v: %v", path, err)
+		}
+		for i := range s.Users {
+			db.putUser(&s.Users[i])
+		}
+		for _, t := range s.Tokens {
+			db.tokens.ids[t.ID] = t
+		}
+	}
+
+	f, err := os.Open(path + ".wal")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("inmem: failed to read write-ahead log: %v", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	for {
+		var e walEntry
+		err := dec.Decode(&e)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// a partial entry at the end is a write interrupted by a crash, which was never applied
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("inmem: failed to replay write-ahead log: %v", err)
+		}
+
+		switch {
+		case e.Op == "saveUser" && e.User != nil:
+			db.putUser(e.User)
+		case e.Op == "deleteUser":
+			db.deleteUser(e.UserID)
+		case e.Op == "saveToken" && e.Token != nil:
+			db.tokens.ids[e.Token.ID] = *e.Token
+		default:
+			return fmt.Errorf("inmem: invalid write-ahead log entry: %+v", e)
+		}
+	}
+}
+
+// Snapshot writes the users and the refresh tokens to the snapshot file and truncates the write-ahead log.
+// Snapshot file is replaced atomically so a crash while saving leaves the previous snapshot and the log intact.
+func (db *DB) Snapshot() error {
+	w := db.users.wal
+	if w == nil {
+		return nil
+	}
+
+	db.users.mutex.RLock()
+	defer db.users.mutex.RUnlock()
+	db.tokens.mutex.RLock()
+	defer db.tokens.mutex.RUnlock()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var s snapshot
+	for _, u := range db.users.ids {
+		s.Users = append(s.Users, *u)
+	}
+	for _, t := range db.tokens.ids {
+		s.Tokens = append(s.Tokens, t)
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("inmem: failed to serialize snapshot: %v", err)
+	}
+
+	tmp := w.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("inmem: failed to write snapshot %v: %v", w.path, err)
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		return fmt.Errorf("inmem: failed to write snapshot %v: %v", w.path, err)
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("inmem: failed to truncate write-ahead log: %v", err)
+	}
+	return nil
+}
+
+// Close takes a final snapshot and closes the write-ahead log. Database should not be used after closing, as the later changes are not persisted.
+func (db *DB) Close() error {
+	w := db.users.wal
+	if w == nil {
+		return nil
+	}
+
+	close(w.done)
+	err := db.Snapshot()
+	w.file.Close()
+	return err
+}
+
+// append records the entry in the write-ahead log before the change is applied to the database, if the database is persisted.
+// Caller should hold the lock of the changed data.
+func (w *wal) append(e walEntry) error {
+	if w == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("inmem: failed to serialize write-ahead log entry: %v", err)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, err := w.file.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("inmem: failed to write to write-ahead log: %v", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("inmem: failed to write to write-ahead log: %v", err)
+	}
+	return nil
+}
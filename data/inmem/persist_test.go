@@ -0,0 +1,76 @@
+package inmem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/titan-x/titan/models"
+)
+
+func TestPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "titan-inmem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "db.json")
+
+	db := NewDB()
+	if err := db.Persist(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	db.SaveUser(&models.User{Email: "a@b.c", GCMRegID: "reg1"})
+	db.SaveUser(&models.User{Email: "d@e.f"})
+	db.SaveRefreshToken(&models.RefreshToken{ID: "t1", UserID: "1", Device: "phone"})
+	db.SaveRefreshToken(&models.RefreshToken{ID: "t2", UserID: "2"})
+	u, _ := db.GetByID("1")
+	u.GCMRegID = "reg2"
+	db.SaveUser(u)
+	db.DeleteUser("2")
+
+	// changes are replayed from the write-ahead log if the server crashes without taking a snapshot
+	db2 := NewDB()
+	if err := db2.load(path); err != nil {
+		t.Fatal(err)
+	}
+	assertPersisted(t, db2)
+
+	// and are in the snapshot after closing, along with the truncated log
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path + ".wal"); err != nil || fi.Size() != 0 {
+		t.Fatalf("expected empty write-ahead log after closing, got: %v, %v", fi, err)
+	}
+
+	// partial entry at the end of the log is a write that was interrupted by a crash, and is skipped
+	ioutil.WriteFile(path+".wal", []byte(`{"op":"saveToken","token":{"ID":"t3","UserID":"1"}}`+"\n"+`{"op":"saveUs`), 0600)
+	db3 := NewDB()
+	if err := db3.Persist(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer db3.Close()
+	assertPersisted(t, db3)
+	if _, ok := db3.GetRefreshToken("t3"); !ok {
+		t.Fatal("expected complete entries before the partial one to be replayed")
+	}
+}
+
+func assertPersisted(t *testing.T, db *DB) {
+	t.Helper()
+
+	if u, ok := db.GetByEmail("a@b.c"); !ok || u.ID != "1" || u.GCMRegID != "reg2" {
+		t.Fatalf("expected user with updated device token, got: %+v", u)
+	}
+	if tk, ok := db.GetRefreshToken("t1"); !ok || tk.Device != "phone" {
+		t.Fatalf("expected refresh token, got: %+v", tk)
+	}
+	if _, ok := db.GetByID("2"); ok {
+		t.Fatal("expected deleted user to stay deleted")
+	}
+	if _, ok := db.GetRefreshToken("t2"); ok {
+		t.Fatal("expected refresh tokens of deleted user to stay deleted")
+	}
+}
@@ -0,0 +1,92 @@
+package inmem
+
+import (
+	"github.com/titan-x/titan/data"
+)
+
+// purgeSeq denotes the requests of a user enqueued up to the sequence number, which are purged with the dead-letter reason.
+type purgeSeq struct {
+	seq    uint64
+	reason string
+}
+
+// Purge dead-letters all the requests waiting to be delivered to the given user with the given reason (i.e. models.DeadLetterPurged),
+// including the ones sent but not yet acknowledged, and returns the number of requests purged. Purged requests can still be re-driven.
+// A request that is being sent at the time of the purge might still be delivered if it is acknowledged, and is dead-lettered otherwise.
+func (q *Queue) Purge(userID, reason string) int {
+	res := make(chan int, 1)
+	q.purgeChan <- purgeChan{userID: userID, reason: reason, res: res}
+	return <-res
+}
+
+// purge dead-letters the requests of a user in the queue and in flight, and leaves the ones held by the user's queue processor,
+// or waiting to be sent again, to be dead-lettered as they come back. Should only be called by the worker.
+func (q *Queue) purge(userID, reason string) int {
+	pending := q.pending[userID]
+	n := len(pending)
+	if n == 0 {
+		return 0
+	}
+	for id := range pending {
+		q.purged[id] = reason
+	}
+
+	if rq, ok := q.reqQueues[userID]; ok {
+		// requests taken by the queue processor in the meantime are purged by the processor
+		for req, ok := rq.pop(); ok; req, ok = rq.pop() {
+			q.purgeReq(userID, req)
+		}
+	}
+
+	q.inflight.mutex.Lock()
+	var reqs []queuedReq
+	for id, ir := range q.inflight.reqs {
+		if _, ok := q.purged[id]; ir.userID != userID || !ok {
+			continue
+		}
+		delete(q.inflight.reqs, id)
+		if ir.timer != nil {
+			ir.timer.Stop()
+		}
+		reqs = append(reqs, ir.req)
+	}
+	q.inflight.mutex.Unlock()
+	for _, req := range reqs {
+		q.purgeReq(userID, req)
+	}
+
+	if proc, ok := q.procs[userID]; ok {
+		// worker is the only sender, so the channel has room once the previous purge is dropped, if not yet received
+		select {
+		case <-proc.purge:
+		default:
+		}
+		proc.purge <- purgeSeq{seq: q.seq, reason: reason}
+	}
+
+	return n
+}
+
+// purgeReq dead-letters a purged request with the reason it is purged with, and removes it from the queue. Should only be called by the worker.
+func (q *Queue) purgeReq(userID string, req queuedReq) {
+	q.deadLetter(userID, req, q.purged[req.ID])
+	delete(q.purged, req.ID)
+	delete(q.pending[userID], req.ID)
+	data.QueueLength.Add(-1)
+}
+
+// purgeWaiting dead-letters the requests held by a user's queue processor which are enqueued up to the given sequence number,
+// and returns the rest. Should only be called by the queue processor of the user.
+func (q *Queue) purgeWaiting(userID string, waiting []queuedReq, p purgeSeq) []queuedReq {
+	var rest []queuedReq
+	for _, req := range waiting {
+		if req.Seq > p.seq {
+			rest = append(rest, req)
+			continue
+		}
+		q.deadLetter(userID, req, p.reason)
+		q.doneReqChan <- doneReqChan{userID: userID, reqID: req.ID}
+		data.QueueLength.Add(-1)
+	}
+	return rest
+}
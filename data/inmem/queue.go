@@ -1,34 +1,74 @@
 package inmem
 
 import (
-	"github.com/neptulon/neptulon"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neptulon/shortid"
 	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/trace"
 )
 
+var logger = log.Component("queue")
+
 // Queue is a message queue for queueing and sending messages to users.
+// A user can be connected with multiple devices at once, in which case requests are sent to all the connected devices.
+// Requests queued while a user is offline are delivered to the first device that connects.
 type Queue struct {
-	senderFunc SenderFunc           // sender function to send and receive messages through
-	conns      map[string]string    // user ID -> conn ID
-	reqChans   map[string]queueChan // user ID -> queueProcessor
+	senderFunc  SenderFunc                 // sender function to send and receive messages through
+	store       data.QueueStore            // optional persistent storage for queued requests
+	shared      bool                       // whether the store is shared with other server instances
+	cluster     data.Cluster               // optional transport to reach the users connected to other server instances
+	conns       map[string]map[string]bool // user ID -> conn IDs
+	reqQueues   map[string]*reqQueue       // user ID -> request queue
+	procs       map[string]queueProc       // user ID -> queue processor
+	pending     map[string]map[string]bool // user ID -> IDs of the requests waiting in request queue
+	purged      map[string]string          // IDs of the purged requests which are still held by a queue processor or waiting to be sent again -> dead-letter reason
+	receipts    receipts                   // message delivery states
+	deadLetters deadLetters                // requests that could not be delivered
+	inflight    inflight                   // requests sent but not yet acknowledged
+	maxDepth    int32                      // maximum number of requests per user queue, zero means no limit
+	seq         uint64                     // sequence number of the last queued request
 
 	// worker communication channels
 	middlewareChan chan middlewareChan
-	remUserChan    chan string
+	remConnChan    chan middlewareChan
 	addReqChan     chan addReqChan
+	loadChan       chan string
+	doneReqChan    chan doneReqChan
 	delQueueChan   chan string
+	depthChan      chan depthChan
+	purgeChan      chan purgeChan
+	procExitChan   chan procExit
 }
 
 // NewQueue creates a new queue object.
 func NewQueue(senderFunc SenderFunc) *Queue {
 	q := Queue{
 		senderFunc: senderFunc,
-		conns:      make(map[string]string),
-		reqChans:   make(map[string]queueChan),
+		conns:      make(map[string]map[string]bool),
+		reqQueues:  make(map[string]*reqQueue),
+		procs:      make(map[string]queueProc),
+		pending:    make(map[string]map[string]bool),
+		purged:     make(map[string]string),
+		receipts:   receipts{receipts: make(map[string]models.Receipt)},
+		inflight:   inflight{reqs: make(map[string]*inflightReq), holders: make(map[string]string)},
 
 		middlewareChan: make(chan middlewareChan, 5000),
-		remUserChan:    make(chan string, 5000),
+		remConnChan:    make(chan middlewareChan, 5000),
 		addReqChan:     make(chan addReqChan, 5000),
+		loadChan:       make(chan string, 5000),
+		doneReqChan:    make(chan doneReqChan, 5000),
 		delQueueChan:   make(chan string, 5000),
+		depthChan:      make(chan depthChan),
+		purgeChan:      make(chan purgeChan),
+		procExitChan:   make(chan procExit, 5000),
 	}
 
 	go q.worker()
@@ -40,68 +80,377 @@ func NewQueue(senderFunc SenderFunc) *Queue {
 type SenderFunc func(connID string, method string, params interface{}, resHandler func(ctx *neptulon.ResCtx) error) (reqID string, err error)
 
 type queuedReq struct {
-	Method     string
-	Params     interface{}
-	ResHandler func(ctx *neptulon.ResCtx) error
+	ID           string
+	Seq          uint64 // order of enqueueing
+	Key          string // conversation of the request if it is to be delivered in order, see orderKey
+	Method       string
+	Params       interface{}
+	Expires      time.Time
+	Attempts     int // number of failed delivery attempts
+	Redeliveries int // number of times the request is sent again as it was not acknowledged
+	ResHandler   func(ctx *neptulon.ResCtx) error
+	Trace        trace.SpanContext // span the request is queued in, which the delivery spans are the children of
+}
+
+func (r *queuedReq) expired(now time.Time) bool {
+	return !r.Expires.IsZero() && now.After(r.Expires)
+}
+
+// queueProc is the communication channels of a user's queue processor goroutine.
+type queueProc struct {
+	conns chan []string // updated list of the user's connection IDs
+	wake  chan bool     // a request is done, which might allow the rest of its conversation to be sent
+	purge chan purgeSeq // requests enqueued up to the given sequence number are purged
+	quit  chan bool
+}
+
+// setConns hands the updated list of the user's connection IDs to the queue processor, replacing the previous list if it
+// is not yet received, so the worker never blocks on a queue processor. Should only be called by the worker.
+func (p queueProc) setConns(ids []string) {
+	// worker is the only sender, so the channel has room once the previous list is dropped, if not yet received
+	select {
+	case <-p.conns:
+	default:
+	}
+	p.conns <- ids
+}
+
+// reqQueue is the unbounded FIFO queue of the requests of a user, waiting to be taken by the user's queue processor.
+// Unlike a buffered channel, adding to it never blocks, so the worker is not held up by a user with a long queue.
+type reqQueue struct {
+	mutex sync.Mutex
+	reqs  []queuedReq
+	ready chan struct{} // signaled when a request is added, until the queue is drained
+}
+
+func newReqQueue() *reqQueue {
+	return &reqQueue{ready: make(chan struct{}, 1)}
+}
+
+func (rq *reqQueue) push(req queuedReq) {
+	rq.mutex.Lock()
+	rq.reqs = append(rq.reqs, req)
+	rq.mutex.Unlock()
+
+	select {
+	case rq.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop takes the oldest request in the queue, if any.
+func (rq *reqQueue) pop() (req queuedReq, ok bool) {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+
+	if len(rq.reqs) == 0 {
+		return queuedReq{}, false
+	}
+	req = rq.reqs[0]
+	rq.reqs[0] = queuedReq{}
+	rq.reqs = rq.reqs[1:]
+	return req, true
 }
 
-type queueChan struct {
-	req  chan queuedReq
-	quit chan bool
+func (rq *reqQueue) len() int {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+	return len(rq.reqs)
 }
 
-func (q *Queue) getQueueChan(userID string) queueChan {
-	c, ok := q.reqChans[userID]
+func (q *Queue) getQueue(userID string) *reqQueue {
+	rq, ok := q.reqQueues[userID]
 	if !ok {
-		c = queueChan{req: make(chan queuedReq, 5000), quit: make(chan bool, 1)}
-		q.reqChans[userID] = c
+		rq = newReqQueue()
+		q.reqQueues[userID] = rq
 	}
-	return c
+	return rq
 }
 
 // Middleware registers a queue middleware to register user/connection IDs
 // for connecting users (upon their first incoming-message).
 func (q *Queue) Middleware(ctx *neptulon.ReqCtx) error {
-	q.middlewareChan <- middlewareChan{userID: ctx.Conn.Session.Get("userid").(string), connID: ctx.Conn.ID}
+	if _, ok := ctx.Conn.Session.GetOk("queue"); !ok {
+		ctx.Conn.Session.Set("queue", true)
+		q.middlewareChan <- middlewareChan{userID: ctx.Conn.Session.Get("userid").(string), connID: ctx.Conn.ID}
+	}
 	return ctx.Next()
 }
 
-// RemoveConn removes a user's associated connection ID.
-func (q *Queue) RemoveConn(userID string) {
-	q.remUserChan <- userID
+// RemoveConn removes one of a user's associated connection IDs.
+func (q *Queue) RemoveConn(userID, connID string) {
+	q.remConnChan <- middlewareChan{userID: userID, connID: connID}
+}
+
+// SetStore sets the persistent storage for queued requests.
+// Stored requests are delivered to the users as they connect, including the ones left from previous runs.
+// If the store is shared with other server instances (implements data.QueueNotifier), requests added by other instances
+// are delivered by this instance if the recipient is connected here.
+// This should be called before the queue starts being used.
+func (q *Queue) SetStore(store data.QueueStore) error {
+	q.store = store
+
+	if n, ok := store.(data.QueueNotifier); ok {
+		q.shared = true
+		if err := n.Subscribe(func(userID string) { q.loadChan <- userID }); err != nil {
+			return fmt.Errorf("queue: failed to subscribe to queue store notifications: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// SetCluster sets the transport for relaying requests to the other server instances.
+// Requests for the users without a connection to this instance are published to the instances holding their connections,
+// and only queued here if there is no such instance. Relayed requests are delivered with their responses discarded,
+// so delivery state updates are not available for them.
+// Cluster is not used with a shared store as the store already delivers the requests through the right instance.
+// This should be called before the queue starts being used.
+func (q *Queue) SetCluster(cluster data.Cluster) error {
+	q.cluster = cluster
+
+	if err := cluster.Subscribe(func(userID string, req *data.QueuedRequest) {
+		if q.store != nil {
+			if err := q.store.AddRequest(userID, req); err != nil {
+				logger.Errorf("failed to persist relayed request %v for user %v: %v", req.ID, userID, err)
+			}
+		}
+		q.addReqChan <- addReqChan{userID: userID, queuedReq: queuedReq{ID: req.ID, Method: req.Method, Params: req.Params, Expires: req.Expires, ResHandler: restoredResHandler, Trace: parseTrace(req.Trace)}, local: true}
+	}); err != nil {
+		return fmt.Errorf("queue: failed to subscribe to cluster: %v", err)
+	}
+
+	return nil
 }
 
 // AddRequest queues a request message to be sent to the given user.
 func (q *Queue) AddRequest(userID string, method string, params interface{}, resHandler func(ctx *neptulon.ResCtx) error) error {
-	q.addReqChan <- addReqChan{userID: userID, queuedReq: queuedReq{Method: method, Params: params, ResHandler: resHandler}}
+	return q.AddRequestTTL(userID, method, params, 0, resHandler)
+}
+
+// AddRequestTTL queues a request message to be sent to the given user, which is dead-lettered if it cannot be delivered within the given TTL.
+// Zero TTL means the request never expires.
+func (q *Queue) AddRequestTTL(userID string, method string, params interface{}, ttl time.Duration, resHandler func(ctx *neptulon.ResCtx) error) error {
+	return q.AddTracedRequest(userID, method, params, ttl, trace.SpanContext{}, resHandler)
+}
+
+// AddTracedRequest is AddRequestTTL which traces the request as a part of the trace of the given parent span (i.e. of the request
+// of the sender of a message), from enqueueing to the delivery through each of the user's connections.
+// Trace context is persisted and relayed to the other server instances along with the request.
+func (q *Queue) AddTracedRequest(userID string, method string, params interface{}, ttl time.Duration, parent trace.SpanContext, resHandler func(ctx *neptulon.ResCtx) error) (err error) {
+	s := trace.Start(parent, "queue.enqueue")
+	s.SetAttr("user", userID)
+	s.SetAttr("method", method)
+	defer func() {
+		s.SetError(err)
+		s.End()
+	}()
+
+	if q.Full(userID) {
+		return data.ErrQueueFull
+	}
+
+	id, err := shortid.UUID()
+	if err != nil {
+		return err
+	}
+	s.SetAttr("request", id)
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	if q.store != nil {
+		p, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("queue: failed to serialize request params: %v", err)
+		}
+		if err := q.store.AddRequest(userID, &data.QueuedRequest{ID: id, Method: method, Params: p, Expires: expires, Trace: s.Context().String()}); err != nil {
+			return fmt.Errorf("queue: failed to persist request: %v", err)
+		}
+	}
+
+	q.addReqChan <- addReqChan{userID: userID, queuedReq: queuedReq{ID: id, Method: method, Params: params, Expires: expires, ResHandler: resHandler, Trace: s.Context()}}
 	return nil
 }
 
-func (q *Queue) processQueue(qc queueChan, userID, connID string) {
-	errc := 0 // protect against infinite retry loop
+// SetMaxDepth sets the maximum number of requests waiting in a user's queue, beyond which AddRequest fails with data.ErrQueueFull,
+// so a user who stays offline cannot grow the queue unboundedly. Limit is checked before adding each request,
+// so concurrent additions can exceed it slightly. Zero means no limit.
+func (q *Queue) SetMaxDepth(n int) {
+	atomic.StoreInt32(&q.maxDepth, int32(n))
+}
 
-	for {
-		select {
-		case req := <-qc.req:
-			_, err := q.senderFunc(connID, req.Method, req.Params, req.ResHandler)
-
-			if err != nil {
-				errc++
-				qc.req <- req
-				if errc > 10 {
-					return
-				}
+// Full reports whether the given user's queue has the maximum number of requests allowed.
+func (q *Queue) Full(userID string) bool {
+	max := atomic.LoadInt32(&q.maxDepth)
+	return max > 0 && q.Depth(userID) >= int(max)
+}
+
+// Depth returns the number of requests waiting to be delivered to the given user.
+func (q *Queue) Depth(userID string) int {
+	res := make(chan int, 1)
+	q.depthChan <- depthChan{userID: userID, res: res}
+	return <-res
+}
+
+// restoreQueue loads the persisted requests for a user into the queue, skipping the ones that are already in the queue.
+func (q *Queue) restoreQueue(userID string) {
+	reqs, err := q.store.GetRequests(userID)
+	if err != nil {
+		logger.Errorf("failed to retrieve persisted requests for user %v: %v", userID, err)
+		return
+	}
+
+	rq := q.getQueue(userID)
+	now := time.Now()
+	for _, r := range reqs {
+		if q.pending[userID][r.ID] {
+			continue
+		}
+
+		req := queuedReq{ID: r.ID, Method: r.Method, Params: r.Params, Expires: r.Expires, ResHandler: restoredResHandler, Trace: parseTrace(r.Trace)}
+		if req.expired(now) {
+			q.deadLetter(userID, req, models.DeadLetterExpired)
+			continue
+		}
+
+		q.seq++
+		req.Seq, req.Key = q.seq, orderKey(req.Method, req.Params)
+		q.addPending(userID, r.ID)
+		data.QueueLength.Add(1)
+		rq.push(req)
+	}
+}
+
+// sweep dead-letters the expired requests from the queues of the users without a connection, which would otherwise wait there
+// until the users connect. Queues of the connected users are left to their queue processors.
+func (q *Queue) sweep() {
+	now := time.Now()
+	for userID, rq := range q.reqQueues {
+		if _, ok := q.procs[userID]; ok {
+			continue
+		}
+
+		for n := rq.len(); n > 0; n-- {
+			req, _ := rq.pop()
+			if !req.expired(now) {
+				rq.push(req)
 				continue
 			}
 
+			q.deadLetter(userID, req, models.DeadLetterExpired)
+			delete(q.pending[userID], req.ID)
 			data.QueueLength.Add(-1)
-			errc = 0
+		}
+	}
+}
+
+// relay publishes a request to the other server instances if the user is connected to any of them.
+// Otherwise, the request is put back in the local queue to wait for the user to connect.
+func (q *Queue) relay(userID string, req queuedReq) {
+	p, err := json.Marshal(req.Params)
+	if err != nil {
+		logger.Errorf("failed to serialize request %v for user %v to relay: %v", req.ID, userID, err)
+		q.addReqChan <- addReqChan{userID: userID, queuedReq: req, local: true}
+		return
+	}
 
-		case <-qc.quit:
-			if len(qc.req) == 0 {
+	ok, err := q.cluster.Publish(userID, &data.QueuedRequest{ID: req.ID, Method: req.Method, Params: p, Expires: req.Expires, Trace: req.Trace.String()})
+	if err != nil {
+		logger.Errorf("failed to relay request %v for user %v: %v", req.ID, userID, err)
+	}
+	if !ok {
+		q.addReqChan <- addReqChan{userID: userID, queuedReq: req, local: true}
+		return
+	}
+
+	if q.store != nil {
+		if err := q.store.RemoveRequest(userID, req.ID); err != nil {
+			logger.Errorf("failed to remove relayed request %v for user %v from store: %v", req.ID, userID, err)
+		}
+	}
+}
+
+func (q *Queue) addPending(userID, reqID string) {
+	p, ok := q.pending[userID]
+	if !ok {
+		p = make(map[string]bool)
+		q.pending[userID] = p
+	}
+	p[reqID] = true
+}
+
+// parseTrace parses the trace context of a persisted or relayed request, if any.
+func parseTrace(traceparent string) trace.SpanContext {
+	if traceparent == "" {
+		return trace.SpanContext{}
+	}
+	sc, err := trace.Parse(traceparent)
+	if err != nil {
+		logger.Warnf("discarding trace context of request: %v", err)
+	}
+	return sc
+}
+
+// Response handlers for restored requests are lost with the previous process, and the ones for relayed requests stay with
+// the originating instance, so responses are just discarded.
+func restoredResHandler(ctx *neptulon.ResCtx) error {
+	return nil
+}
+
+// processQueue sends the queued requests of a user to all of the user's connections.
+// A request is considered delivered once any of the connections responds to it. Until then, it is kept in flight,
+// and sent again if there is no response within the ack timeout or all the connections it is sent through are closed.
+// Messages of a conversation are sent one at a time, in the order of enqueueing, each after the previous one is delivered.
+// Other requests are sent as soon as they are queued.
+func (q *Queue) processQueue(rq *reqQueue, proc queueProc, userID string) {
+	conns := <-proc.conns
+	var waiting []queuedReq // requests taken from the queue, in the order of enqueueing
+	var retry <-chan time.Time
+	errc := 0 // protect against infinite retry loop
+
+	for {
+		select {
+		case conns = <-proc.conns:
+		case <-rq.ready:
+			for req, ok := rq.pop(); ok; req, ok = rq.pop() {
+				waiting = insertBySeq(waiting, req)
+			}
+		case <-proc.wake:
+		case <-retry:
+			retry = nil
+		case p := <-proc.purge:
+			waiting = q.purgeWaiting(userID, waiting, p)
+		case <-proc.quit:
+			// waiting requests go back through the worker, so the ones purged in the meantime are dropped
+			for _, req := range waiting {
+				q.addReqChan <- addReqChan{userID: userID, queuedReq: req, requeue: true}
+			}
+			if len(waiting) == 0 && rq.len() == 0 {
 				q.delQueueChan <- userID
 			}
 			return
 		}
+
+		if retry != nil {
+			continue
+		}
+
+		var failed bool
+		if waiting, failed = q.dispatch(userID, waiting, conns); !failed {
+			errc = 0
+			continue
+		}
+		if errc++; errc > 10 {
+			for _, req := range waiting {
+				rq.push(req)
+			}
+			// the next connection of the user starts a new queue processor
+			q.procExitChan <- procExit{userID: userID, proc: proc}
+			return
+		}
+		retry = time.After(sendRetryDelay)
 	}
 }
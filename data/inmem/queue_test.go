@@ -0,0 +1,325 @@
+package inmem
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+func TestDeadLetterMaxAttempts(t *testing.T) {
+	attempts := make(chan bool, 100)
+	q := NewQueue(func(connID string, method string, params interface{}, resHandler func(ctx *neptulon.ResCtx) error) (string, error) {
+		attempts <- true
+		return "", errors.New("connection is closed")
+	})
+	q.middlewareChan <- middlewareChan{userID: "1", connID: "conn1"}
+
+	if err := q.AddRequest("1", "msg.recv", []string{"hello"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(time.Second * 3)
+	for {
+		if dls := q.DeadLetters("1"); len(dls) != 0 {
+			if len(dls) != 1 || dls[0].Reason != models.DeadLetterMaxAttempts || string(dls[0].Params) != `["hello"]` || len(attempts) != maxDeliveryAttempts {
+				t.Fatalf("expected dead letter after %v attempts, got: %+v after %v attempts", maxDeliveryAttempts, dls, len(attempts))
+			}
+			break
+		}
+
+		select {
+		case <-deadline:
+			t.Fatal("request was not dead-lettered in time")
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+
+	if dls := q.DeadLetters("2"); len(dls) != 0 {
+		t.Fatalf("expected no dead letters for another user, got: %+v", dls)
+	}
+}
+
+func TestRedelivery(t *testing.T) {
+	type send struct {
+		connID     string
+		resHandler func(ctx *neptulon.ResCtx) error
+	}
+	sends := make(chan send, 100)
+	q := NewQueue(func(connID string, method string, params interface{}, resHandler func(ctx *neptulon.ResCtx) error) (string, error) {
+		sends <- send{connID, resHandler}
+		return "", nil
+	})
+	q.SetAckTimeout(time.Millisecond * 50)
+	q.middlewareChan <- middlewareChan{userID: "1", connID: "conn1"}
+
+	nextSend := func() send {
+		select {
+		case s := <-sends:
+			return s
+		case <-time.After(time.Second * 3):
+			t.Fatal("request was not sent in time")
+		}
+		return send{}
+	}
+
+	// request is sent again if it is not acknowledged in time
+	acked := 0
+	if err := q.AddRequest("1", "msg.recv", []string{"hello"}, func(ctx *neptulon.ResCtx) error {
+		acked++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	nextSend()
+	s := nextSend()
+	s.resHandler(&neptulon.ResCtx{})
+	if acked != 1 {
+		t.Fatalf("expected response handler to be called once, got: %v", acked)
+	}
+
+	deadline := time.After(time.Second * 3)
+	for q.Depth("1") != 0 {
+		select {
+		case <-deadline:
+			t.Fatal("acknowledged request was not removed from the queue in time")
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+
+	// request is sent again through a new connection if the connection it is sent through is closed
+	if err := q.AddRequest("1", "msg.recv", []string{"hi"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	nextSend()
+	q.RemoveConn("1", "conn1")
+	q.middlewareChan <- middlewareChan{userID: "1", connID: "conn2"}
+	if s := nextSend(); s.connID != "conn2" {
+		t.Fatalf("expected redelivery through conn2, got: %v", s.connID)
+	}
+}
+
+func TestOrderedDelivery(t *testing.T) {
+	type send struct {
+		connID     string
+		msg        models.Message
+		resHandler func(ctx *neptulon.ResCtx) error
+	}
+	sends := make(chan send, 100)
+	q := NewQueue(func(connID string, method string, params interface{}, resHandler func(ctx *neptulon.ResCtx) error) (string, error) {
+		sends <- send{connID, params.([]models.Message)[0], resHandler}
+		return "", nil
+	})
+	q.middlewareChan <- middlewareChan{userID: "1", connID: "conn1"}
+
+	nextSend := func(conv, msg string) send {
+		select {
+		case s := <-sends:
+			if s.msg.Conversation != conv || s.msg.Message != msg {
+				t.Fatalf("expected message %v of conversation %v to be sent, got: %+v", msg, conv, s.msg)
+			}
+			return s
+		case <-time.After(time.Second * 3):
+			t.Fatalf("message %v of conversation %v was not sent in time", msg, conv)
+		}
+		return send{}
+	}
+	noSend := func() {
+		select {
+		case s := <-sends:
+			t.Fatalf("expected no message to be sent before the previous one of the conversation is acknowledged, got: %+v", s.msg)
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+
+	for _, m := range []models.Message{{Conversation: "a", Message: "1"}, {Conversation: "a", Message: "2"}, {Conversation: "a", Message: "3"}, {Conversation: "b", Message: "1"}} {
+		if err := q.AddRequest("1", "msg.recv", []models.Message{m}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// messages of other conversations are not held back by an unacknowledged message
+	a1 := nextSend("a", "1")
+	b1 := nextSend("b", "1")
+	b1.resHandler(&neptulon.ResCtx{})
+	noSend()
+	a1.resHandler(&neptulon.ResCtx{})
+
+	// message sent again after a reconnect is still delivered before the later messages of its conversation
+	nextSend("a", "2")
+	q.RemoveConn("1", "conn1")
+	q.middlewareChan <- middlewareChan{userID: "1", connID: "conn2"}
+	a2 := nextSend("a", "2")
+	if a2.connID != "conn2" {
+		t.Fatalf("expected redelivery through conn2, got: %v", a2.connID)
+	}
+	noSend()
+	a2.resHandler(&neptulon.ResCtx{})
+	nextSend("a", "3")
+}
+
+func TestPurge(t *testing.T) {
+	sends := make(chan models.Message, 100)
+	q := NewQueue(func(connID string, method string, params interface{}, resHandler func(ctx *neptulon.ResCtx) error) (string, error) {
+		sends <- params.([]models.Message)[0]
+		return "", nil
+	})
+	q.middlewareChan <- middlewareChan{userID: "1", connID: "conn1"}
+
+	nextSend := func(conv, msg string) {
+		select {
+		case m := <-sends:
+			if m.Conversation != conv || m.Message != msg {
+				t.Fatalf("expected message %v of conversation %v to be sent, got: %+v", msg, conv, m)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("message %v of conversation %v was not sent in time", msg, conv)
+		}
+	}
+
+	// a1 and b1 are in flight while a2 is held by the queue processor until a1 is acknowledged
+	for _, m := range []models.Message{{Conversation: "a", Message: "1"}, {Conversation: "a", Message: "2"}, {Conversation: "b", Message: "1"}} {
+		if err := q.AddRequest("1", "msg.recv", []models.Message{m}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nextSend("a", "1")
+	nextSend("b", "1")
+
+	if n := q.Purge("1", models.DeadLetterPurged); n != 3 {
+		t.Fatalf("expected 3 purged requests, got: %v", n)
+	}
+	for i := 0; q.Depth("1") != 0 || len(q.DeadLetters("1")) != 3; i++ {
+		if i > 100 {
+			t.Fatalf("expected all requests to be dead-lettered, got depth: %v, dead letters: %+v", q.Depth("1"), q.DeadLetters("1"))
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	for _, dl := range q.DeadLetters("1") {
+		if dl.Reason != models.DeadLetterPurged {
+			t.Fatalf("expected purged dead letter, got: %+v", dl)
+		}
+	}
+
+	// conversations are no longer held by the purged requests
+	if err := q.AddRequest("1", "msg.recv", []models.Message{{Conversation: "a", Message: "3"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	nextSend("a", "3")
+}
+
+func TestUnboundedQueue(t *testing.T) {
+	q := NewQueue(func(connID string, method string, params interface{}, resHandler func(ctx *neptulon.ResCtx) error) (string, error) {
+		return "", nil
+	})
+
+	// a long queue of an offline user does not hold up the queues of the others
+	for i := 0; i < 6000; i++ {
+		if err := q.AddRequest("1", "msg.recv", i, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.AddRequest("2", "msg.recv", "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		if d1, d2 := q.Depth("1"), q.Depth("2"); d1 != 6000 || d2 != 1 {
+			t.Errorf("expected queue depths of 6000 and 1, got: %v and %v", d1, d2)
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 3):
+		t.Fatal("queue worker is blocked")
+	}
+}
+
+func TestQueueProcRestart(t *testing.T) {
+	var failing int32 = 1
+	attempts := make(chan bool, 100)
+	sent := make(chan interface{}, 100)
+	q := NewQueue(func(connID string, method string, params interface{}, resHandler func(ctx *neptulon.ResCtx) error) (string, error) {
+		if atomic.LoadInt32(&failing) == 1 {
+			attempts <- true
+			return "", errors.New("connection is closed")
+		}
+		sent <- params
+		return "", nil
+	})
+	q.middlewareChan <- middlewareChan{userID: "1", connID: "conn1"}
+
+	// first two requests are dead-lettered, and the queue processor gives up before the third one is
+	for i := 0; i < 3; i++ {
+		if err := q.AddRequest("1", "msg.recv", i, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i <= 2*maxDeliveryAttempts; i++ {
+		select {
+		case <-attempts:
+		case <-time.After(time.Second * 3):
+			t.Fatalf("expected %v send attempts, got: %v", 2*maxDeliveryAttempts+1, i)
+		}
+	}
+	time.Sleep(time.Millisecond * 50)
+	atomic.StoreInt32(&failing, 0)
+
+	// connection updates do not block the worker once the queue processor is gone, and the next connection starts a new one
+	for i := 0; i < 200; i++ {
+		q.middlewareChan <- middlewareChan{userID: "1", connID: "conn1"}
+	}
+	q.middlewareChan <- middlewareChan{userID: "1", connID: "conn2"}
+	select {
+	case p := <-sent:
+		if p != 2 {
+			t.Fatalf("expected the last request to be sent, got: %v", p)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("request was not sent after reconnecting")
+	}
+	if dls := q.DeadLetters("1"); len(dls) != 2 {
+		t.Fatalf("expected 2 dead letters, got: %+v", dls)
+	}
+}
+
+func TestReceiptEviction(t *testing.T) {
+	q := NewQueue(nil)
+	now := time.Now()
+	for _, id := range []string{"m1", "m2", "m3"} {
+		if _, ok := q.SetDeliveryState(models.Receipt{ID: id, From: "1", To: "2", State: models.StateSent, Time: now}); !ok {
+			t.Fatalf("expected message %v to be recorded", id)
+		}
+	}
+	q.SetDeliveryState(models.Receipt{ID: "m1", State: models.StateRead, Time: now})
+	q.receipts.drop("msg.recv", json.RawMessage(`[{"id": "m2"}]`))
+
+	q.receipts.evict(now)
+	if _, ok := q.GetDeliveryState("m1"); ok {
+		t.Fatal("expected receipt of the read message to be evicted")
+	}
+	if _, ok := q.GetDeliveryState("m2"); ok {
+		t.Fatal("expected receipt of the dead-lettered message to be dropped")
+	}
+	if _, ok := q.GetDeliveryState("m3"); !ok {
+		t.Fatal("expected receipt of the unread message to be kept")
+	}
+	q.receipts.evict(now.Add(receiptTTL + time.Second))
+	if _, ok := q.GetDeliveryState("m3"); ok {
+		t.Fatal("expected receipt to be evicted after its TTL")
+	}
+
+	// messages no longer tracked are only restored along with all the receipt fields
+	if _, ok := q.SetDeliveryState(models.Receipt{ID: "m3", State: models.StateRead, Time: now}); ok {
+		t.Fatal("expected transition of an unknown message to be rejected")
+	}
+	if r, ok := q.SetDeliveryState(models.Receipt{ID: "m3", From: "1", To: "2", State: models.StateDelivered, Time: now}); !ok || r.State != models.StateDelivered {
+		t.Fatalf("expected message to be restored, got: %+v", r)
+	}
+}
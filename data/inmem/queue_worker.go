@@ -1,6 +1,13 @@
 package inmem
 
-import "github.com/titan-x/titan/data"
+import (
+	"time"
+
+	"github.com/titan-x/titan/data"
+)
+
+// sweepInterval is the interval of dropping the expired requests from the queues of the offline users.
+const sweepInterval = time.Minute
 
 type middlewareChan struct {
 	userID, connID string
@@ -9,32 +16,180 @@ type middlewareChan struct {
 type addReqChan struct {
 	userID    string
 	queuedReq queuedReq
+	local     bool // keep in the local queue without relaying to other server instances
+	requeue   bool // request was sent but not acknowledged, and is put back in the queue
+}
+
+type doneReqChan struct {
+	userID, reqID string
+}
+
+type depthChan struct {
+	userID string
+	res    chan int
+}
+
+type procExit struct {
+	userID string
+	proc   queueProc
+}
+
+type purgeChan struct {
+	userID string
+	reason string
+	res    chan int
 }
 
 func (q *Queue) worker() {
+	sweep := time.NewTicker(sweepInterval)
+	defer sweep.Stop()
+
 	for {
 		select {
 		case mid := <-q.middlewareChan:
-			// start queue gorutine only once per connection
-			if _, ok := q.conns[mid.userID]; !ok {
-				q.conns[mid.userID] = mid.connID
+			// start queue gorutine only once per user (or again if the previous one gave up sending), and let it know of the additional connections
+			conns, ok := q.conns[mid.userID]
+			if !ok {
+				conns = make(map[string]bool)
+				q.conns[mid.userID] = conns
 				data.UserCount.Add(1)
-				go q.processQueue(q.getQueueChan(mid.userID), mid.userID, mid.connID)
+				if q.cluster != nil && !q.shared {
+					if err := q.cluster.Join(mid.userID); err != nil {
+						logger.Errorf("failed to register user %v with cluster: %v", mid.userID, err)
+					}
+				}
+				if q.store != nil {
+					q.restoreQueue(mid.userID)
+				}
 			}
+			proc, ok := q.procs[mid.userID]
+			if !ok {
+				proc = queueProc{conns: make(chan []string, 1), wake: make(chan bool, 1), purge: make(chan purgeSeq, 1), quit: make(chan bool, 1)}
+				q.procs[mid.userID] = proc
+				go q.processQueue(q.getQueue(mid.userID), proc, mid.userID)
+			}
+			conns[mid.connID] = true
+			proc.setConns(connIDs(conns))
 
-		case userID := <-q.remUserChan:
-			if _, ok := q.conns[userID]; ok {
-				q.getQueueChan(userID).quit <- true
-				delete(q.conns, userID)
-				data.UserCount.Add(-1)
+		case rem := <-q.remConnChan:
+			q.dropConn(rem.connID)
+			conns, ok := q.conns[rem.userID]
+			if !ok || !conns[rem.connID] {
+				continue
+			}
+			delete(conns, rem.connID)
+			proc, ok := q.procs[rem.userID]
+			if len(conns) != 0 {
+				if ok {
+					proc.setConns(connIDs(conns))
+				}
+				continue
+			}
+			if ok {
+				proc.quit <- true
+			}
+			delete(q.procs, rem.userID)
+			delete(q.conns, rem.userID)
+			data.UserCount.Add(-1)
+			if q.cluster != nil && !q.shared {
+				if err := q.cluster.Leave(rem.userID); err != nil {
+					logger.Errorf("failed to unregister user %v from cluster: %v", rem.userID, err)
+				}
 			}
 
 		case req := <-q.addReqChan:
-			data.QueueLength.Add(1)
-			q.getQueueChan(req.userID).req <- req.queuedReq
+			q.addReq(req)
+
+		case exit := <-q.procExitChan:
+			// queue processor gave up sending, unless it is already replaced
+			if q.procs[exit.userID] == exit.proc {
+				delete(q.procs, exit.userID)
+			}
+
+		case userID := <-q.loadChan:
+			if _, ok := q.conns[userID]; ok {
+				q.restoreQueue(userID)
+			}
+
+		case done := <-q.doneReqChan:
+			if p, ok := q.pending[done.userID]; ok {
+				delete(p, done.reqID)
+			}
+			delete(q.purged, done.reqID)
+			if proc, ok := q.procs[done.userID]; ok {
+				select {
+				case proc.wake <- true:
+				default:
+				}
+			}
 
 		case userID := <-q.delQueueChan:
-			delete(q.reqChans, userID)
+			// user might have reconnected in the meantime
+			if _, ok := q.conns[userID]; !ok {
+				delete(q.reqQueues, userID)
+				delete(q.pending, userID)
+			}
+
+		case d := <-q.depthChan:
+			// requests added before the depth query should be counted
+			for len(q.addReqChan) > 0 {
+				q.addReq(<-q.addReqChan)
+			}
+			d.res <- len(q.pending[d.userID])
+
+		case p := <-q.purgeChan:
+			// requests added before the purge should be purged as well
+			for len(q.addReqChan) > 0 {
+				q.addReq(<-q.addReqChan)
+			}
+			p.res <- q.purge(p.userID, p.reason)
+
+		case now := <-sweep.C:
+			q.sweep()
+			q.receipts.evict(now)
+		}
+	}
+}
+
+func (q *Queue) addReq(req addReqChan) {
+	// requests put back in the queue are already counted in the queue length, and keep their place in the order of enqueueing
+	if req.requeue {
+		if _, ok := q.purged[req.queuedReq.ID]; ok {
+			q.purgeReq(req.userID, req.queuedReq)
+			return
+		}
+		q.addPending(req.userID, req.queuedReq.ID)
+		q.getQueue(req.userID).push(req.queuedReq)
+		return
+	}
+
+	// with a shared store, only the instance holding the connection keeps the request and the rest discard it
+	if q.shared {
+		if _, ok := q.conns[req.userID]; !ok || q.pending[req.userID][req.queuedReq.ID] {
+			return
 		}
 	}
+
+	// requests for users without a local connection are handed over to the instances holding their connections, if any,
+	// and requests relayed by other instances are kept here even if the user disconnected in the meantime
+	if q.cluster != nil && !q.shared && !req.local {
+		if _, ok := q.conns[req.userID]; !ok {
+			go q.relay(req.userID, req.queuedReq)
+			return
+		}
+	}
+
+	q.seq++
+	req.queuedReq.Seq, req.queuedReq.Key = q.seq, orderKey(req.queuedReq.Method, req.queuedReq.Params)
+	data.QueueLength.Add(1)
+	q.addPending(req.userID, req.queuedReq.ID)
+	q.getQueue(req.userID).push(req.queuedReq)
+}
+
+func connIDs(conns map[string]bool) []string {
+	ids := make([]string, 0, len(conns))
+	for id := range conns {
+		ids = append(ids, id)
+	}
+	return ids
 }
@@ -0,0 +1,109 @@
+package inmem
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/models"
+)
+
+// receiptTTL is the time after the last state transition of a message for its delivery state to be dropped, if not read yet.
+// Delivery states of the messages no longer tracked are left to the message history.
+const receiptTTL = time.Hour * 24
+
+// order of message delivery states, used to allow only forward state transitions
+var stateOrder = map[string]int{
+	models.StateSent:      1,
+	models.StateDelivered: 2,
+	models.StateRead:      3,
+}
+
+// receipts keeps the delivery state of the messages which are not yet read. Receipts of the read messages are dropped
+// on the next sweep, and the ones of the dead-lettered messages right away.
+type receipts struct {
+	mutex    sync.RWMutex
+	receipts map[string]models.Receipt // message ID -> receipt
+}
+
+// SetDeliveryState records a message delivery state transition.
+// New messages should be recorded with all the receipt fields, and so should the messages no longer tracked (i.e. restored
+// from the message history), along with their current state. Successive transitions only need message ID and state,
+// and the recipient device, if the transition is device specific.
+// Returns the updated receipt with ok = false if the message is not known or the transition is not a forward one (i.e. read -> delivered).
+// Forward transitions of individual devices are recorded even if the overall message state does not change.
+func (q *Queue) SetDeliveryState(r models.Receipt) (updated models.Receipt, ok bool) {
+	q.receipts.mutex.Lock()
+	defer q.receipts.mutex.Unlock()
+
+	cur, exists := q.receipts.receipts[r.ID]
+	if !exists {
+		if r.From == "" || stateOrder[r.State] == 0 {
+			return r, false
+		}
+		r.Device, r.Devices = "", nil
+		q.receipts.receipts[r.ID] = r
+		return r, true
+	}
+
+	// device states are copied on write so the returned receipts can be read without locking
+	if r.Device != "" && stateOrder[r.State] > stateOrder[cur.Devices[r.Device]] {
+		devices := make(map[string]string, len(cur.Devices)+1)
+		for d, s := range cur.Devices {
+			devices[d] = s
+		}
+		devices[r.Device] = r.State
+		cur.Devices = devices
+		q.receipts.receipts[r.ID] = cur
+	}
+
+	if stateOrder[r.State] <= stateOrder[cur.State] {
+		return cur, false
+	}
+
+	cur.State = r.State
+	cur.Time = r.Time
+	q.receipts.receipts[r.ID] = cur
+	cur.Device = r.Device
+	return cur, true
+}
+
+// GetDeliveryState retrieves the latest delivery state of a message.
+func (q *Queue) GetDeliveryState(msgID string) (r models.Receipt, ok bool) {
+	q.receipts.mutex.RLock()
+	defer q.receipts.mutex.RUnlock()
+
+	r, ok = q.receipts.receipts[msgID]
+	return
+}
+
+// evict drops the receipts of the read messages, and the ones without a state transition within receiptTTL.
+func (rs *receipts) evict(now time.Time) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	for id, r := range rs.receipts {
+		if r.State == models.StateRead || now.Sub(r.Time) > receiptTTL {
+			delete(rs.receipts, id)
+		}
+	}
+}
+
+// drop drops the receipts of the messages carried by a msg.recv request, i.e. once it is dead-lettered.
+func (rs *receipts) drop(method string, params json.RawMessage) {
+	if method != "msg.recv" {
+		return
+	}
+	var msgs []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(params, &msgs); err != nil {
+		return
+	}
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	for _, m := range msgs {
+		delete(rs.receipts, m.ID)
+	}
+}
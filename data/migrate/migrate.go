@@ -0,0 +1,157 @@
+// Package migrate applies versioned schema migrations to SQL databases.
+//
+// Migrations are SQL files named <version>_<name>.sql (i.e. 0002_message_labels.sql), usually embedded in the binary by
+// the database implementation. Each migration is applied once, in ascending version order, in its own transaction,
+// and recorded in the schema_migrations table. Released migrations should never be edited; schema changes go into a new file.
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.sql$`)
+
+// Migration is a single schema change.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrator applies the migrations to a database.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration // Sorted by version.
+	Lock       string      // Optional statement executed at the beginning of each transaction to serialize concurrent runs, i.e. LOCK TABLE for PostgreSQL.
+}
+
+// Load reads the migrations in the given directory of the file system, i.e. an embed.FS.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: failed to read migrations: %v", err)
+	}
+
+	var ms []Migration
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		match := fileName.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrate: invalid migration file name: %v", e.Name())
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("migrate: failed to read migration %v: %v", e.Name(), err)
+		}
+		v, _ := strconv.Atoi(match[1])
+		ms = append(ms, Migration{Version: v, Name: match[2], SQL: string(b)})
+	}
+
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	for i := range ms {
+		if ms[i].Version <= 0 || (i > 0 && ms[i].Version == ms[i-1].Version) {
+			return nil, fmt.Errorf("migrate: invalid or duplicate migration version: %v", ms[i].Version)
+		}
+	}
+	return ms, nil
+}
+
+// Version returns the version of the latest migration applied to the database, or zero if none.
+func (m *Migrator) Version() (int, error) {
+	if err := m.init(); err != nil {
+		return 0, err
+	}
+	return version(m.DB)
+}
+
+// Latest returns the version of the latest migration, or zero if there are none.
+func (m *Migrator) Latest() int {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// Up applies the pending migrations and returns the number of the applied ones. Migrations already applied by a concurrent
+// run are skipped. If a migration fails, the ones before it stay applied.
+func (m *Migrator) Up() (int, error) {
+	if err := m.init(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, mg := range m.Migrations {
+		ok, err := m.apply(mg)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// apply applies a migration unless it is already applied.
+func (m *Migrator) apply(mg Migration) (bool, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("migrate: failed to apply migration %v: %v", mg.Version, err)
+	}
+	defer tx.Rollback()
+
+	if m.Lock != "" {
+		if _, err := tx.Exec(m.Lock); err != nil {
+			return false, fmt.Errorf("migrate: failed to lock migrations table: %v", err)
+		}
+	}
+	v, err := version(tx)
+	if err != nil {
+		return false, err
+	}
+	if v >= mg.Version {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(mg.SQL); err != nil {
+		return false, fmt.Errorf("migrate: failed to apply migration %v_%v: %v", mg.Version, mg.Name, err)
+	}
+	// version and name are validated by Load, so they are safe to format into the statement, which keeps it independent of the placeholder syntax
+	if _, err := tx.Exec(fmt.Sprintf("INSERT INTO schema_migrations (version, name) VALUES (%d, '%s')", mg.Version, mg.Name)); err != nil {
+		return false, fmt.Errorf("migrate: failed to record migration %v: %v", mg.Version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("migrate: failed to apply migration %v: %v", mg.Version, err)
+	}
+	return true, nil
+}
+
+func (m *Migrator) init() error {
+	_, err := m.DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name    TEXT NOT NULL,
+		applied TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("migrate: failed to create migrations table: %v", err)
+	}
+	return nil
+}
+
+func version(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}) (int, error) {
+	var v sql.NullInt64
+	if err := q.QueryRow("SELECT max(version) FROM schema_migrations").Scan(&v); err != nil {
+		return 0, fmt.Errorf("migrate: failed to get schema version: %v", err)
+	}
+	return int(v.Int64), nil
+}
@@ -0,0 +1,31 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0010_labels.sql":  {Data: []byte("ALTER TABLE messages ADD COLUMN labels TEXT")},
+		"m/0002_users.sql":   {Data: []byte("CREATE TABLE users (id TEXT)")},
+		"m/0001_initial.sql": {Data: []byte("CREATE TABLE messages (id TEXT)")},
+	}
+	ms, err := Load(fsys, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 3 || ms[0].Version != 1 || ms[1].Version != 2 || ms[2].Version != 10 || ms[2].Name != "labels" || ms[1].SQL != "CREATE TABLE users (id TEXT)" {
+		t.Fatalf("expected migrations in version order, got: %+v", ms)
+	}
+	if v := (&Migrator{Migrations: ms}).Latest(); v != 10 {
+		t.Fatalf("expected latest version 10, got: %v", v)
+	}
+
+	for name, f := range map[string]string{"invalid name": "m/labels.sql", "duplicate version": "m/02_duplicate.sql", "zero version": "m/0000_zero.sql"} {
+		fsys := fstest.MapFS{"m/0001_initial.sql": {}, "m/0002_users.sql": {}, f: {}}
+		if _, err := Load(fsys, "m"); err == nil {
+			t.Fatalf("expected error for %v", name)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+//go:build mongo
+// +build mongo
+
+package mongo
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+const dsn = "mongodb://localhost/titan_test?serverSelectionTimeoutMS=2000"
+
+func newTestDB(t *testing.T) *DB {
+	db, err := NewDB(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Skipf("skipping MongoDB test: %v", err)
+	}
+
+	if err := db.Seed(true, "pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func compareUsersForEquality(t *testing.T, u1 *models.User, u2 *models.User) {
+	// mongo stores timestamps with millisecond precision
+	if u1.ID != u2.ID ||
+		u1.Registered.Sub(u2.Registered) > time.Millisecond || u2.Registered.Sub(u1.Registered) > time.Millisecond ||
+		u1.Email != u2.Email ||
+		u1.PhoneNumber != u2.PhoneNumber ||
+		u1.GCMRegID != u2.GCMRegID ||
+		u1.APNSDeviceToken != u2.APNSDeviceToken ||
+		u1.Name != u2.Name ||
+		!bytes.Equal(u1.Picture, u2.Picture) ||
+		u1.JWTToken != u2.JWTToken ||
+		u1.Status != u2.Status ||
+		u1.Avatar != u2.Avatar ||
+		u1.Unverified != u2.Unverified ||
+		u1.Role != u2.Role {
+		t.Fatal("user fields are invalid")
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	for _, user := range data.SeedUsers {
+		u, ok := db.GetByID(user.ID)
+		if !ok {
+			t.Fatal("couldn't get user by id")
+		}
+		compareUsersForEquality(t, u, &user)
+
+		u, ok = db.GetByEmail(user.Email)
+		if !ok {
+			t.Fatal("couldn't get user by email")
+		}
+		compareUsersForEquality(t, u, &user)
+
+		u, ok = db.GetByPhone(user.PhoneNumber)
+		if !ok {
+			t.Fatal("couldn't get user by phone number")
+		}
+		compareUsersForEquality(t, u, &user)
+	}
+
+	if _, ok := db.GetByID("non-existent"); ok {
+		t.Fatal("got non-existent user")
+	}
+}
+
+func TestGetUserIDs(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	var ids []string
+	for after := ""; ; {
+		page, err := db.GetUserIDs(after, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		ids = append(ids, page...)
+		after = page[len(page)-1]
+	}
+
+	if len(ids) != len(data.SeedUsers) {
+		t.Fatalf("expected %v user ids, got: %v", len(data.SeedUsers), ids)
+	}
+}
+
+func TestSaveUser(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	u := models.User{Email: "test@user", Name: "Test User", JWTToken: "345565", Registered: time.Now()}
+	if err := db.SaveUser(&u); err != nil {
+		t.Fatal("cannot create user:", err)
+	} else if u.ID == "" {
+		t.Fatal("user was not assigned a unique ID")
+	}
+
+	u.Email, u.Status, u.Avatar, u.Unverified, u.Role = "test2@user", "busy", "avatar-id", true, models.RoleModerator
+	if err := db.SaveUser(&u); err != nil {
+		t.Fatal("cannot update user:", err)
+	}
+
+	ur, ok := db.GetByID(u.ID)
+	if !ok {
+		t.Fatal("couldn't get user")
+	}
+	compareUsersForEquality(t, ur, &u)
+}
+
+func TestKeys(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	if _, ok, err := db.ClaimKeys("1"); ok || err != nil {
+		t.Fatalf("expected no keys, got: %v, %v", ok, err)
+	}
+
+	b := models.KeyBundle{UserID: "1", IdentityKey: []byte("identity"), SignedPreKey: &models.SignedPreKey{ID: 1, Key: []byte("spk"), Signature: []byte("sig")},
+		PreKeys: []models.PreKey{{ID: 1, Key: []byte("pk1")}, {ID: 2, Key: []byte("pk2")}}}
+	if err := db.SaveKeys(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []int{1, 2, 0} {
+		kb, ok, err := db.ClaimKeys("1")
+		if !ok || err != nil || !bytes.Equal(kb.IdentityKey, b.IdentityKey) || kb.SignedPreKey == nil || !bytes.Equal(kb.SignedPreKey.Signature, []byte("sig")) {
+			t.Fatalf("unexpected keys: %+v, %v, %v", kb, ok, err)
+		}
+		if (id == 0 && len(kb.PreKeys) != 0) || (id != 0 && (len(kb.PreKeys) != 1 || kb.PreKeys[0].ID != id)) {
+			t.Fatalf("expected prekey %v, got: %+v", id, kb.PreKeys)
+		}
+	}
+
+	// prekeys are dropped upon a new identity key
+	if err := db.SaveKeys(&b); err != nil {
+		t.Fatal(err)
+	}
+	b.IdentityKey, b.PreKeys = []byte("identity-2"), nil
+	if err := db.SaveKeys(&b); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.CountPreKeys("1"); n != 0 || err != nil {
+		t.Fatalf("expected prekeys to be dropped, got: %v, %v", n, err)
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	a := models.Attachment{ID: "a1", Owner: "1", Size: 5, MimeType: "text/plain", Checksum: "abc", Created: time.Now()}
+	if err := db.SaveAttachment(&a); err != nil {
+		t.Fatal(err)
+	}
+	a.Uploaded, a.Conversations = true, []string{"1:2"}
+	a.Thumbnails = []models.Thumbnail{models.Thumbnail{Size: 160, Width: 160, Height: 90, MimeType: "image/jpeg"}}
+	if err := db.SaveAttachment(&a); err != nil {
+		t.Fatal(err)
+	}
+
+	at, ok, err := db.GetAttachment("a1")
+	if !ok || err != nil || !at.Uploaded || at.Size != 5 || at.MimeType != "text/plain" || len(at.Conversations) != 1 || at.Conversations[0] != "1:2" || len(at.Thumbnails) != 1 || at.Thumbnails[0].Height != 90 {
+		t.Fatalf("unexpected attachment: %+v, %v, %v", at, ok, err)
+	}
+	if as, err := db.GetAttachments("1"); err != nil || len(as) != 1 || as[0].ID != "a1" {
+		t.Fatalf("unexpected attachments: %+v, %v", as, err)
+	}
+
+	// messages carry the attachment reference
+	m := models.Message{ID: "m1", From: "1", To: "2", Conversation: "1:2", Time: time.Now(), Attachment: at.Ref()}
+	if err := db.SaveMessage(&m); err != nil {
+		t.Fatal(err)
+	}
+	if msg, ok := db.GetMessage("m1"); !ok || msg.Attachment == nil || msg.Attachment.ID != "a1" || msg.Attachment.Checksum != "abc" {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+
+	if err := db.DeleteAttachment("a1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := db.GetAttachment("a1"); ok || err != nil {
+		t.Fatalf("expected attachment to be deleted, got: %v, %v", ok, err)
+	}
+}
+
+func TestTopics(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	tp := models.Topic{Name: "news", Description: "daily news", Created: time.Now()}
+	if err := db.SaveTopic(&tp); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := db.GetTopic("news"); err != nil || !ok || got.Description != "daily news" {
+		t.Fatalf("unexpected topic: %+v, found: %v, err: %v", got, ok, err)
+	}
+
+	for _, uid := range []string{"1", "2"} {
+		if err := db.Subscribe("news", uid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if subs, err := db.GetSubscribers("news", "1", 10); err != nil || len(subs) != 1 || subs[0] != "2" {
+		t.Fatalf("unexpected subscribers: %v, err: %v", subs, err)
+	}
+
+	if err := db.Unsubscribe("news", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if ts, err := db.GetSubscriptions("2"); err != nil || len(ts) != 0 {
+		t.Fatalf("expected no subscriptions, got: %v, err: %v", ts, err)
+	}
+}
+
+func TestVerifications(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	v := models.Verification{Kind: models.VerifyEmail, Target: "test@user", UserID: "1", CodeHash: "hash", Sent: time.Now(), Expires: time.Now().Add(time.Hour)}
+	if err := db.SaveVerification(&v); err != nil {
+		t.Fatal(err)
+	}
+	v.Attempts = 2
+	if err := db.SaveVerification(&v); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := db.GetVerification(models.VerifyEmail, "test@user"); err != nil || !ok || got.UserID != "1" || got.CodeHash != "hash" || got.Attempts != 2 || got.Expires.IsZero() {
+		t.Fatalf("unexpected verification: %+v, found: %v, err: %v", got, ok, err)
+	}
+
+	if err := db.DeleteVerification(models.VerifyEmail, "test@user"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := db.GetVerification(models.VerifyEmail, "test@user"); err != nil || ok {
+		t.Fatalf("expected verification to be deleted, err: %v", err)
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	db := newTestDB(t)
+
+	tt := models.TOTP{UserID: "1", Secret: "SECRET", RecoveryCodes: []string{"hash1", "hash2"}}
+	if err := db.SaveTOTP(&tt); err != nil {
+		t.Fatal(err)
+	}
+	tt.Confirmed, tt.LastStep, tt.RecoveryCodes = true, 42, tt.RecoveryCodes[1:]
+	if err := db.SaveTOTP(&tt); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := db.GetTOTP("1"); err != nil || !ok || got.Secret != "SECRET" || !got.Confirmed || got.LastStep != 42 || len(got.RecoveryCodes) != 1 || got.RecoveryCodes[0] != "hash2" {
+		t.Fatalf("unexpected totp: %+v, found: %v, err: %v", got, ok, err)
+	}
+
+	if err := db.DeleteUser("1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := db.GetTOTP("1"); err != nil || ok {
+		t.Fatalf("expected totp to be deleted along with the user, err: %v", err)
+	}
+}
+
+func TestContacts(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	for _, id := range []string{"3", "2", "2"} {
+		if err := db.AddContact("1", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ids, err := db.GetContacts("1"); err != nil || len(ids) != 2 || ids[0] != "2" || ids[1] != "3" {
+		t.Fatalf("unexpected contacts: %v, err: %v", ids, err)
+	}
+
+	if err := db.RemoveContact("1", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.HasContact("1", "3"); err != nil || ok {
+		t.Fatalf("expected contact to be removed, err: %v", err)
+	}
+	if ok, err := db.HasContact("1", "2"); err != nil || !ok {
+		t.Fatalf("expected contact to be found, err: %v", err)
+	}
+	if ids, err := db.GetContactOf("2"); err != nil || len(ids) != 1 || ids[0] != "1" {
+		t.Fatalf("unexpected users with the contact: %v, err: %v", ids, err)
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	if err := db.Block("1", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.IsBlocked("1", "2"); err != nil || !ok {
+		t.Fatalf("expected user to be blocked, err: %v", err)
+	}
+	if err := db.Unblock("1", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := db.GetBlocked("1"); err != nil || len(ids) != 0 {
+		t.Fatalf("expected no blocked users, got: %v, err: %v", ids, err)
+	}
+
+	if err := db.Mute("1", "1:2"); err != nil {
+		t.Fatal(err)
+	}
+	if convs, err := db.GetMuted("1"); err != nil || len(convs) != 1 || convs[0] != "1:2" {
+		t.Fatalf("unexpected muted conversations: %v, err: %v", convs, err)
+	}
+	if err := db.Unmute("1", "1:2"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.IsMuted("1", "1:2"); err != nil || ok {
+		t.Fatalf("expected conversation to be unmuted, err: %v", err)
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	now := time.Now()
+	for i, body := range []string{"lunch at noon?", "lunch, lunch!", "dinner then"} {
+		m := &models.Message{ID: fmt.Sprintf("search-%v", i), From: "1", To: "2", Conversation: "1:2", Time: now, Message: body}
+		if err := db.SaveMessage(m); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.IndexMessage(m, []string{"1", "2"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs, err := db.SearchMessages("2", "Lunch", "1:2", now.Add(time.Second), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != "search-1" || msgs[1].ID != "search-0" {
+		t.Fatalf("unexpected search results: %+v", msgs)
+	}
+	if msgs, err = db.SearchMessages("3", "lunch", "", now.Add(time.Second), 0, 10); err != nil || len(msgs) != 0 {
+		t.Fatalf("expected no search results for another user, got: %+v, err: %v", msgs, err)
+	}
+}
+
+func TestMessageRetention(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	now := time.Now()
+	for i, d := range []time.Duration{time.Hour, 0} {
+		m := &models.Message{ID: fmt.Sprintf("retention-%v", i), From: "1", To: "2", Conversation: "1:2", Time: now.Add(-d), Message: "lunch", State: models.StateSent}
+		if err := db.SaveMessage(m); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.IndexMessage(m, []string{"1", "2"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.UpdateDeliveryState("retention-1", models.StateRead); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := db.GetMessage("retention-1"); !ok || m.State != models.StateRead || m.Message != "lunch" {
+		t.Fatalf("expected updated message state, got: %+v", m)
+	}
+	if err := db.UpdateDeliveryState("retention-x", models.StateRead); err != nil {
+		t.Fatalf("expected unknown message to be ignored, got: %v", err)
+	}
+
+	if n, err := db.DeleteMessagesBefore(now.Add(-time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expected 1 message to be deleted, got: %v, err: %v", n, err)
+	}
+	if _, ok := db.GetMessage("retention-0"); ok {
+		t.Fatal("expected old message to be deleted")
+	}
+	if msgs, err := db.SearchMessages("2", "lunch", "1:2", now.Add(time.Second), 0, 10); err != nil || len(msgs) != 1 || msgs[0].ID != "retention-1" {
+		t.Fatalf("expected only the new message in search results, got: %+v, err: %v", msgs, err)
+	}
+}
+
+func TestGetDueDeletions(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	u := models.User{Email: "test@user", DeleteAt: time.Now().Add(time.Hour)}
+	if err := db.SaveUser(&u); err != nil {
+		t.Fatal(err)
+	}
+
+	// users who did not request the deletion are not included
+	if ids, err := db.GetDueDeletions(time.Now().Add(2 * time.Hour)); err != nil || len(ids) != 1 || ids[0] != u.ID {
+		t.Fatalf("unexpected due deletions: %v, err: %v", ids, err)
+	}
+	if ids, err := db.GetDueDeletions(time.Now()); err != nil || len(ids) != 0 {
+		t.Fatalf("expected no due deletions, got: %v, err: %v", ids, err)
+	}
+}
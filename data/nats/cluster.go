@@ -0,0 +1,124 @@
+package nats
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/data"
+)
+
+// Cluster is a NATS based transport for relaying requests between server instances (nodes), without any shared storage.
+// Each node subscribes to a subject per connected user and requests are relayed with request/reply,
+// so a request is considered relayed only if a node holding a connection of the user acknowledges it.
+type Cluster struct {
+	Client  *Client
+	Prefix  string        // Subject prefix for all the subjects used by the cluster.
+	Timeout time.Duration // Maximum time to wait for a node to acknowledge a relayed request.
+
+	mutex   sync.Mutex
+	users   map[string]int64 // user ID -> subscription ID
+	handler func(userID string, req *data.QueuedRequest)
+}
+
+// NewCluster creates a new NATS cluster transport for the server at the given network address.
+func NewCluster(addr string) *Cluster {
+	return &Cluster{Client: NewClient(addr), Prefix: "titan.", Timeout: time.Millisecond * 500, users: make(map[string]int64)}
+}
+
+// Join subscribes this node to the requests for the given user.
+func (c *Cluster) Join(userID string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.users[userID]; ok {
+		return nil
+	}
+
+	sid, err := c.Client.Subscribe(c.subject(userID), func(m *Msg) { c.receive(userID, m) })
+	if err != nil {
+		return fmt.Errorf("nats: cluster: failed to join user %v: %v", userID, err)
+	}
+	c.users[userID] = sid
+	return nil
+}
+
+// Leave unsubscribes this node from the requests for the given user.
+func (c *Cluster) Leave(userID string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	sid, ok := c.users[userID]
+	if !ok {
+		return nil
+	}
+
+	delete(c.users, userID)
+	if err := c.Client.Unsubscribe(sid); err != nil {
+		return fmt.Errorf("nats: cluster: failed to leave user %v: %v", userID, err)
+	}
+	return nil
+}
+
+// Publish relays a request to the nodes holding a connection of the given user.
+// Returns false if no node acknowledges the request within the timeout.
+func (c *Cluster) Publish(userID string, req *data.QueuedRequest) (ok bool, err error) {
+	r, err := json.Marshal(req)
+	if err != nil {
+		return false, fmt.Errorf("nats: cluster: failed to serialize request: %v", err)
+	}
+
+	if _, err := c.Client.Request(c.subject(userID), r, c.Timeout); err == ErrTimeout {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("nats: cluster: failed to publish request for user %v: %v", userID, err)
+	}
+
+	return true, nil
+}
+
+// Subscribe registers the handler to be called with each request relayed to this node by the other nodes.
+func (c *Cluster) Subscribe(handler func(userID string, req *data.QueuedRequest)) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.handler = handler
+	return nil
+}
+
+// Ping verifies that the NATS server is reachable.
+func (c *Cluster) Ping() error {
+	return c.Client.Ping()
+}
+
+// Close closes the NATS connection.
+func (c *Cluster) Close() error {
+	return c.Client.Close()
+}
+
+func (c *Cluster) receive(userID string, m *Msg) {
+	var req data.QueuedRequest
+	if err := json.Unmarshal(m.Data, &req); err != nil {
+		logger.Errorf("cluster: failed to deserialize relayed request for user %v: %v", userID, err)
+		return
+	}
+
+	c.mutex.Lock()
+	handler := c.handler
+	c.mutex.Unlock()
+	if handler == nil {
+		return
+	}
+
+	handler(userID, &req)
+	if m.Reply != "" {
+		if err := c.Client.Publish(m.Reply, "", []byte("ok")); err != nil {
+			logger.Errorf("cluster: failed to acknowledge relayed request %v for user %v: %v", req.ID, userID, err)
+		}
+	}
+}
+
+func (c *Cluster) subject(userID string) string {
+	return c.Prefix + "cluster.user." + userID
+}
@@ -0,0 +1,69 @@
+package nats
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/titan-x/titan/models"
+)
+
+// EventBus publishes server events to NATS subjects in the form of <prefix>events.<event type> (i.e. titan.events.msg.queued),
+// so external services can subscribe to them with any NATS client.
+type EventBus struct {
+	Client *Client
+	Prefix string // Subject prefix for all the subjects used by the event bus.
+}
+
+// NewEventBus creates a new NATS event bus for the server at the given network address.
+func NewEventBus(addr string) *EventBus {
+	return &EventBus{Client: NewClient(addr), Prefix: "titan."}
+}
+
+// Publish publishes the event to its subject.
+func (b *EventBus) Publish(e *models.Event) error {
+	d, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("nats: event bus: failed to serialize event: %v", err)
+	}
+
+	if err := b.Client.Publish(b.subject(e.Type), "", d); err != nil {
+		return fmt.Errorf("nats: event bus: failed to publish %v event: %v", e.Type, err)
+	}
+	return nil
+}
+
+// Subscribe registers a handler for the given event type, or for all events if event type is empty.
+// Events published by any server instance are received.
+func (b *EventBus) Subscribe(eventType string, handler func(e *models.Event)) error {
+	subject := b.subject(eventType)
+	if eventType == "" {
+		subject += ">"
+	}
+
+	_, err := b.Client.Subscribe(subject, func(m *Msg) {
+		var e models.Event
+		if err := json.Unmarshal(m.Data, &e); err != nil {
+			logger.Errorf("event bus: failed to deserialize event from %v: %v", m.Subject, err)
+			return
+		}
+		handler(&e)
+	})
+	if err != nil {
+		return fmt.Errorf("nats: event bus: failed to subscribe to %v: %v", subject, err)
+	}
+	return nil
+}
+
+// Ping verifies that the NATS server is reachable.
+func (b *EventBus) Ping() error {
+	return b.Client.Ping()
+}
+
+// Close closes the NATS connection.
+func (b *EventBus) Close() error {
+	return b.Client.Close()
+}
+
+func (b *EventBus) subject(eventType string) string {
+	return b.Prefix + "events." + eventType
+}
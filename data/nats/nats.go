@@ -0,0 +1,336 @@
+// Package nats provides NATS implementation of data interfaces.
+// A minimal NATS protocol client is included so no external NATS client library is needed.
+//
+// NATS Protocol Specs: http://nats.io/documentation/internals/nats-protocol/
+package nats
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/log"
+)
+
+var logger = log.Component("nats")
+
+// ErrTimeout is returned when a request does not receive a reply in time.
+var ErrTimeout = errors.New("nats: timeout")
+
+// Msg is a message received from the server.
+type Msg struct {
+	Subject string
+	Reply   string // Subject to send the reply to, if any.
+	Data    []byte
+}
+
+// Client is a NATS client. Connection is re-established automatically on network errors,
+// along with all the active subscriptions, until the client is closed.
+type Client struct {
+	Addr string
+
+	mutex  sync.Mutex
+	conn   net.Conn
+	w      *bufio.Writer
+	subs   map[int64]*subscription // sid -> subscription
+	sid    int64
+	pongs  []chan error // pending PINGs waiting for a PONG
+	closed bool
+}
+
+type subscription struct {
+	subject string
+	handler func(m *Msg)
+}
+
+// NewClient creates a new NATS client for the server at the given network address (i.e. 127.0.0.1:4222).
+// Connection is established lazily with the first command.
+func NewClient(addr string) *Client {
+	return &Client{Addr: addr, subs: make(map[int64]*subscription)}
+}
+
+// Publish publishes data to the given subject. Reply subject is optional.
+func (c *Client) Publish(subject, reply string, data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if reply != "" {
+		subject += " " + reply
+	}
+	return c.write(fmt.Sprintf("PUB %s %d\r\n%s\r\n", subject, len(data), data))
+}
+
+// Subscribe subscribes to the given subject and calls the handler with each received message.
+// Handlers are called sequentially from a single goroutine so they should not block for long.
+// Returned subscription ID is used for unsubscribing.
+func (c *Client) Subscribe(subject string, handler func(m *Msg)) (sid int64, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.sid++
+	sid = c.sid
+	c.subs[sid] = &subscription{subject: subject, handler: handler}
+	if err := c.write(fmt.Sprintf("SUB %s %d\r\n", subject, sid)); err != nil {
+		delete(c.subs, sid)
+		return 0, err
+	}
+	return sid, nil
+}
+
+// Unsubscribe cancels the subscription with the given ID.
+func (c *Client) Unsubscribe(sid int64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.subs[sid]; !ok {
+		return nil
+	}
+	delete(c.subs, sid)
+	return c.write(fmt.Sprintf("UNSUB %d\r\n", sid))
+}
+
+// Request publishes data to the given subject and waits for the first reply until timeout.
+// Returns ErrTimeout if no reply arrives in time (i.e. there are no subscribers).
+func (c *Client) Request(subject string, data []byte, timeout time.Duration) (*Msg, error) {
+	id, err := shortid.UUID()
+	if err != nil {
+		return nil, err
+	}
+
+	replies := make(chan *Msg, 1)
+	inbox := "_INBOX." + id
+	sid, err := c.Subscribe(inbox, func(m *Msg) {
+		select {
+		case replies <- m:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Unsubscribe(sid)
+
+	if err := c.Publish(subject, inbox, data); err != nil {
+		return nil, err
+	}
+
+	select {
+	case m := <-replies:
+		return m, nil
+	case <-time.After(timeout):
+		return nil, ErrTimeout
+	}
+}
+
+// Ping verifies that the NATS server is reachable with a PING/PONG round trip.
+func (c *Client) Ping() error {
+	pong := make(chan error, 1)
+
+	c.mutex.Lock()
+	if err := c.write("PING\r\n"); err != nil {
+		c.mutex.Unlock()
+		return err
+	}
+	c.pongs = append(c.pongs, pong)
+	c.mutex.Unlock()
+
+	select {
+	case err := <-pong:
+		return err
+	case <-time.After(time.Second * 5):
+		return ErrTimeout
+	}
+}
+
+// Close closes the client connection and stops any subscriptions.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	c.closeConn(c.conn)
+	return nil
+}
+
+type closedErr struct{}
+
+func (closedErr) Error() string {
+	return "nats: use of closed client"
+}
+
+// write sends the given protocol message, connecting to the server first if needed. Caller must hold the mutex.
+func (c *Client) write(msg string) error {
+	if c.closed {
+		return closedErr{}
+	}
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.w.WriteString(msg); err != nil {
+		c.closeConn(c.conn)
+		return fmt.Errorf("nats: failed to write: %v", err)
+	}
+	if err := c.w.Flush(); err != nil {
+		c.closeConn(c.conn)
+		return fmt.Errorf("nats: failed to write: %v", err)
+	}
+	return nil
+}
+
+// connect dials the server, completes the handshake, restores the subscriptions, and starts receiving messages.
+// Caller must hold the mutex.
+func (c *Client) connect() error {
+	conn, err := net.DialTimeout("tcp", c.Addr, time.Second*10)
+	if err != nil {
+		return fmt.Errorf("nats: failed to connect to %v: %v", c.Addr, err)
+	}
+
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	conn.SetDeadline(time.Now().Add(time.Second * 10))
+
+	// server greets with INFO, and replies to PING with PONG once CONNECT is processed
+	if line, err := readLine(r); err != nil || !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return fmt.Errorf("nats: failed to receive server info from %v: %v %v", c.Addr, line, err)
+	}
+	fmt.Fprint(w, "CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"titan\"}\r\nPING\r\n")
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return fmt.Errorf("nats: failed to connect to %v: %v", c.Addr, err)
+	}
+	if line, err := readLine(r); err != nil || line != "PONG" {
+		conn.Close()
+		return fmt.Errorf("nats: failed to connect to %v: %v %v", c.Addr, line, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	for sid, s := range c.subs {
+		fmt.Fprintf(w, "SUB %s %d\r\n", s.subject, sid)
+	}
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return fmt.Errorf("nats: failed to restore subscriptions: %v", err)
+	}
+
+	c.conn, c.w = conn, w
+	go c.receive(conn, r)
+	return nil
+}
+
+// closeConn closes the given connection if it is the current one and fails the pending PINGs. Caller must hold the mutex.
+func (c *Client) closeConn(conn net.Conn) {
+	if conn == nil || conn != c.conn {
+		return
+	}
+
+	conn.Close()
+	c.conn, c.w = nil, nil
+	for _, p := range c.pongs {
+		p <- errors.New("nats: connection closed")
+	}
+	c.pongs = nil
+}
+
+func (c *Client) receive(conn net.Conn, r *bufio.Reader) {
+	err := c.read(conn, r)
+
+	c.mutex.Lock()
+	c.closeConn(conn)
+	if c.closed || len(c.subs) == 0 {
+		c.mutex.Unlock()
+		return
+	}
+	c.mutex.Unlock()
+
+	// reconnect right away only if there are subscriptions to restore, otherwise next command reconnects
+	logger.Warnf("connection interrupted, reconnecting: %v", err)
+	for {
+		time.Sleep(time.Second)
+
+		c.mutex.Lock()
+		if c.closed || c.conn != nil {
+			c.mutex.Unlock()
+			return
+		}
+		err := c.connect()
+		c.mutex.Unlock()
+		if err == nil {
+			return
+		}
+	}
+}
+
+func (c *Client) read(conn net.Conn, r *bufio.Reader) error {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			f := strings.Fields(line)
+			if len(f) != 4 && len(f) != 5 {
+				return fmt.Errorf("nats: malformed message: %q", line)
+			}
+			n, err := strconv.Atoi(f[len(f)-1])
+			if err != nil {
+				return fmt.Errorf("nats: malformed message: %q", line)
+			}
+			b := make([]byte, n+2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return err
+			}
+
+			m := &Msg{Subject: f[1], Data: b[:n]}
+			if len(f) == 5 {
+				m.Reply = f[3]
+			}
+			sid, _ := strconv.ParseInt(f[2], 10, 64)
+			c.mutex.Lock()
+			s, ok := c.subs[sid]
+			c.mutex.Unlock()
+			if ok {
+				s.handler(m)
+			}
+
+		case line == "PING":
+			c.mutex.Lock()
+			if conn == c.conn {
+				c.write("PONG\r\n")
+			}
+			c.mutex.Unlock()
+
+		case line == "PONG":
+			c.mutex.Lock()
+			if len(c.pongs) != 0 {
+				c.pongs[0] <- nil
+				c.pongs = c.pongs[1:]
+			}
+			c.mutex.Unlock()
+
+		case strings.HasPrefix(line, "-ERR"):
+			logger.Errorf("server error: %v", line)
+		}
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,134 @@
+package nats
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+const addr = "127.0.0.1:4222"
+
+func newTestClient(t *testing.T) *Client {
+	c := NewClient(addr)
+	if err := c.Ping(); err != nil {
+		t.Skipf("skipping NATS test: %v", err)
+	}
+	return c
+}
+
+func TestRequest(t *testing.T) {
+	c := newTestClient(t)
+	defer c.Close()
+
+	if _, err := c.Subscribe("titan-test.echo", func(m *Msg) { c.Publish(m.Reply, "", m.Data) }); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := c.Request("titan-test.echo", []byte("hello"), time.Second)
+	if err != nil || string(m.Data) != "hello" {
+		t.Fatalf("expected echo reply, got: %+v, %v", m, err)
+	}
+
+	if _, err := c.Request("titan-test.nobody", []byte("hello"), time.Millisecond*100); err != ErrTimeout {
+		t.Fatalf("expected timeout for subject without subscribers, got: %v", err)
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	c := newTestClient(t)
+	c.Close()
+
+	b1, b2 := NewEventBus(addr), NewEventBus(addr)
+	b1.Prefix, b2.Prefix = "titan-test.", "titan-test."
+	defer b1.Close()
+	defer b2.Close()
+
+	all, queued := make(chan *models.Event, 10), make(chan *models.Event, 10)
+	if err := b2.Subscribe("", func(e *models.Event) { all <- e }); err != nil {
+		t.Fatal(err)
+	}
+	if err := b2.Subscribe(models.EventMsgQueued, func(e *models.Event) { queued <- e }); err != nil {
+		t.Fatal(err)
+	}
+	b2.Ping() // make sure subscriptions are processed before publishing
+
+	b1.Publish(&models.Event{Type: models.EventUserConnected, UserID: "1", Time: time.Now()})
+	b1.Publish(&models.Event{Type: models.EventMsgQueued, UserID: "2", Time: time.Now(), Data: json.RawMessage(`{"message":"hi"}`)})
+
+	for _, typ := range []string{models.EventUserConnected, models.EventMsgQueued} {
+		select {
+		case e := <-all:
+			if e.Type != typ {
+				t.Fatalf("expected %v event, got: %+v", typ, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("did not get %v event in time", typ)
+		}
+	}
+
+	select {
+	case e := <-queued:
+		if e.UserID != "2" || string(e.Data) != `{"message":"hi"}` {
+			t.Fatalf("unexpected event: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not get msg.queued event in time")
+	}
+	if len(queued) != 0 {
+		t.Fatal("expected only the subscribed event type")
+	}
+}
+
+func TestCluster(t *testing.T) {
+	c := newTestClient(t)
+	c.Close()
+
+	c1, c2 := NewCluster(addr), NewCluster(addr)
+	c1.Prefix, c2.Prefix = "titan-test.", "titan-test."
+	c1.Timeout = time.Millisecond * 100
+	defer c1.Close()
+	defer c2.Close()
+
+	relayed := make(chan data.QueuedRequest, 10)
+	if err := c2.Subscribe(func(userID string, req *data.QueuedRequest) {
+		if userID == "1" {
+			relayed <- *req
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &data.QueuedRequest{ID: "r1", Method: "msg.recv", Params: json.RawMessage(`[{"message":"hi"}]`)}
+
+	// user is not connected to any node yet
+	if ok, err := c1.Publish("1", req); err != nil || ok {
+		t.Fatalf("expected publish to fail for user without nodes, got: %v, %v", ok, err)
+	}
+
+	if err := c2.Join("1"); err != nil {
+		t.Fatal(err)
+	}
+	c2.Ping() // make sure subscription is processed before publishing
+	if ok, err := c1.Publish("1", req); err != nil || !ok {
+		t.Fatalf("expected request to be published, got: %v, %v", ok, err)
+	}
+
+	select {
+	case r := <-relayed:
+		if r.ID != "r1" || r.Method != "msg.recv" || string(r.Params) != `[{"message":"hi"}]` {
+			t.Fatalf("unexpected relayed request: %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not get relayed request in time")
+	}
+
+	if err := c2.Leave("1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c1.Publish("1", req); err != nil || ok {
+		t.Fatalf("expected publish to fail after user left, got: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,154 @@
+-- Initial schema. Statements are idempotent, so the databases created before the migrations were introduced are adopted as is.
+
+CREATE TABLE IF NOT EXISTS users (
+    id                TEXT PRIMARY KEY,
+    registered        TIMESTAMPTZ NOT NULL,
+    email             TEXT NOT NULL DEFAULT '',
+    phone_number      TEXT NOT NULL DEFAULT '',
+    gcm_reg_id        TEXT NOT NULL DEFAULT '',
+    apns_device_token TEXT NOT NULL DEFAULT '',
+    name              TEXT NOT NULL DEFAULT '',
+    picture           BYTEA,
+    jwt_token         TEXT NOT NULL DEFAULT '',
+    status            TEXT NOT NULL DEFAULT '',
+    avatar            TEXT NOT NULL DEFAULT '',
+    delete_at         TIMESTAMPTZ
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS delete_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS users_delete_at_idx ON users (delete_at) WHERE delete_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS users_email_idx ON users (email);
+
+CREATE TABLE IF NOT EXISTS groups (
+    id      TEXT PRIMARY KEY,
+    name    TEXT NOT NULL DEFAULT '',
+    owner   TEXT NOT NULL,
+    members TEXT[] NOT NULL,
+    created TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id      TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    device  TEXT NOT NULL DEFAULT '',
+    created TIMESTAMPTZ NOT NULL,
+    revoked BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_idx ON refresh_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS messages (
+    seq          BIGSERIAL PRIMARY KEY,
+    id           TEXT NOT NULL UNIQUE,
+    conversation TEXT NOT NULL,
+    sender       TEXT NOT NULL DEFAULT '',
+    recipient    TEXT NOT NULL DEFAULT '',
+    group_id     TEXT NOT NULL DEFAULT '',
+    body         TEXT NOT NULL DEFAULT '',
+    attachment   JSONB,
+    time         TIMESTAMPTZ NOT NULL,
+    state        TEXT NOT NULL DEFAULT '',
+    encrypted    BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS encrypted BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS messages_conversation_idx ON messages (conversation, seq);
+
+CREATE INDEX IF NOT EXISTS messages_time_idx ON messages (time);
+
+CREATE INDEX IF NOT EXISTS messages_body_idx ON messages USING GIN (to_tsvector('simple', body));
+
+CREATE TABLE IF NOT EXISTS message_index (
+    user_id    TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    PRIMARY KEY (user_id, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS identity_keys (
+    user_id                 TEXT PRIMARY KEY,
+    identity_key            BYTEA,
+    signed_prekey_id        INTEGER NOT NULL DEFAULT 0,
+    signed_prekey           BYTEA,
+    signed_prekey_signature BYTEA
+);
+
+CREATE TABLE IF NOT EXISTS prekeys (
+    seq     BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    id      INTEGER NOT NULL,
+    key     BYTEA NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS prekeys_user_idx ON prekeys (user_id, seq);
+
+CREATE TABLE IF NOT EXISTS attachments (
+    id            TEXT PRIMARY KEY,
+    owner         TEXT NOT NULL,
+    size          BIGINT NOT NULL,
+    mime_type     TEXT NOT NULL DEFAULT '',
+    checksum      TEXT NOT NULL,
+    uploaded      BOOLEAN NOT NULL DEFAULT FALSE,
+    conversations TEXT[] NOT NULL,
+    thumbnails    JSONB,
+    created       TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS attachments_owner_idx ON attachments (owner);
+
+CREATE TABLE IF NOT EXISTS topics (
+    name        TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    created     TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS topic_subscribers (
+    topic   TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    PRIMARY KEY (topic, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS topic_subscribers_user_idx ON topic_subscribers (user_id);
+
+CREATE TABLE IF NOT EXISTS contacts (
+    user_id    TEXT NOT NULL,
+    contact_id TEXT NOT NULL,
+    PRIMARY KEY (user_id, contact_id)
+);
+
+CREATE INDEX IF NOT EXISTS contacts_contact_idx ON contacts (contact_id);
+
+CREATE TABLE IF NOT EXISTS blocks (
+    user_id    TEXT NOT NULL,
+    blocked_id TEXT NOT NULL,
+    PRIMARY KEY (user_id, blocked_id)
+);
+
+CREATE TABLE IF NOT EXISTS mutes (
+    user_id      TEXT NOT NULL,
+    conversation TEXT NOT NULL,
+    PRIMARY KEY (user_id, conversation)
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    seq         BIGSERIAL PRIMARY KEY,
+    id          TEXT NOT NULL,
+    time        TIMESTAMPTZ NOT NULL,
+    action      TEXT NOT NULL,
+    user_id     TEXT NOT NULL DEFAULT '',
+    device      TEXT NOT NULL DEFAULT '',
+    remote_addr TEXT NOT NULL DEFAULT '',
+    target      TEXT NOT NULL DEFAULT '',
+    success     BOOLEAN NOT NULL,
+    details     JSONB
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_user_idx ON audit_log (user_id, seq);
+
+CREATE INDEX IF NOT EXISTS audit_log_target_idx ON audit_log (target, seq) WHERE target <> '';
@@ -0,0 +1,14 @@
+-- E-mail verification of the accounts registered without Google Sign-In.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS unverified BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS verifications (
+    kind      TEXT NOT NULL,
+    target    TEXT NOT NULL,
+    user_id   TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    sent      TIMESTAMPTZ NOT NULL,
+    expires   TIMESTAMPTZ NOT NULL,
+    attempts  INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (kind, target)
+);
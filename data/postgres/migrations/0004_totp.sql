@@ -0,0 +1,9 @@
+-- TOTP second factors of the users, along with the hashes of their unused recovery codes.
+
+CREATE TABLE IF NOT EXISTS totp (
+    user_id        TEXT PRIMARY KEY,
+    secret         TEXT NOT NULL,
+    confirmed      BOOLEAN NOT NULL DEFAULT FALSE,
+    recovery_codes TEXT[],
+    last_step      BIGINT NOT NULL DEFAULT 0
+);
@@ -0,0 +1,3 @@
+-- Authorization roles of the users, which are issued in the "role" claim of their JWT tokens.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT '';
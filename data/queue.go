@@ -1,22 +1,96 @@
 package data
 
 import (
+	"encoding/json"
+	"errors"
 	"expvar"
+	"time"
 
-	"github.com/neptulon/neptulon"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/trace"
 )
 
 // Queue is a message queue for queueing and sending messages to users.
 type Queue interface {
 	Middleware(ctx *neptulon.ReqCtx) error
-	RemoveConn(userID string)
+	RemoveConn(userID, connID string)
 	AddRequest(userID string, method string, params interface{}, resHandler func(ctx *neptulon.ResCtx) error) error
+	AddRequestTTL(userID string, method string, params interface{}, ttl time.Duration, resHandler func(ctx *neptulon.ResCtx) error) error
+	AddTracedRequest(userID string, method string, params interface{}, ttl time.Duration, parent trace.SpanContext, resHandler func(ctx *neptulon.ResCtx) error) error
+	SetStore(store QueueStore) error
+	SetCluster(cluster Cluster) error
+	SetDeliveryState(r models.Receipt) (updated models.Receipt, ok bool)
+	GetDeliveryState(msgID string) (r models.Receipt, ok bool)
+	Depth(userID string) int
+	SetMaxDepth(n int)
+	Full(userID string) bool
+	DeadLetters(userID string) []models.DeadLetter
+	Redrive(id string) (ok bool, err error)
+	Purge(userID, reason string) int
+}
+
+// ErrQueueFull is returned by Queue.AddRequest when the user's queue already has the maximum number of requests allowed.
+var ErrQueueFull = errors.New("queue: user queue is full")
+
+// QueueStore persists queued requests so that undelivered requests can survive process restarts.
+// Implementations should be safe for concurrent use.
+type QueueStore interface {
+	AddRequest(userID string, req *QueuedRequest) error
+	RemoveRequest(userID, reqID string) error
+	GetRequests(userID string) ([]QueuedRequest, error)
+}
+
+// QueueNotifier is implemented by queue stores that are shared by multiple server instances.
+// Subscribers are notified with the user ID whenever a request is added to a user's queue by any of the instances,
+// so the instance holding the user's connection can deliver it.
+type QueueNotifier interface {
+	Subscribe(handler func(userID string)) error
+}
+
+// DedupeStore is implemented by queue stores that are shared by multiple server instances, to deduplicate the client supplied
+// message IDs across the instances, so a message retried through another instance (i.e. after reconnecting) is not sent again.
+// Reserve maps the client supplied message ID of a user to the server generated one for the given TTL, unless it is already
+// mapped, in which case the existing server generated ID is returned with dup = true. Release removes the mapping.
+type DedupeStore interface {
+	Reserve(userID, clientID, id string, ttl time.Duration) (existing string, dup bool, err error)
+	Release(userID, clientID string) error
+}
+
+// DeadLetterNotifier is implemented by queues which notify the subscribers of the requests they dead-letter,
+// i.e. so the server can publish them as events.
+type DeadLetterNotifier interface {
+	OnDeadLetter(handler func(dl models.DeadLetter))
+}
+
+// Cluster relays requests between server instances, so that a request for a user connected to another instance reaches the user.
+// Instances register the users as they connect and disconnect, and requests are published to the instances holding their connections.
+// Publish reports whether the request reached any other instance.
+// Implementations should be safe for concurrent use.
+type Cluster interface {
+	Join(userID string) error
+	Leave(userID string) error
+	Publish(userID string, req *QueuedRequest) (ok bool, err error)
+	Subscribe(handler func(userID string, req *QueuedRequest)) error
+}
+
+// QueuedRequest is a persisted request waiting to be delivered to a user.
+type QueuedRequest struct {
+	ID      string
+	Method  string
+	Params  json.RawMessage
+	Expires time.Time // Time after which the request is dropped instead of being delivered. Zero means the request never expires.
+	Trace   string    `json:",omitempty"` // W3C traceparent of the span the request is queued in, if traced.
 }
 
 // QueueLength is the total request queue for all users combined.
 // This should be handled by the implementing struct.
 var QueueLength = expvar.NewInt("queue-length")
 
+// QueueExpired is the total number of requests dropped from the queue as they expired before being delivered.
+// This should be handled by the implementing struct.
+var QueueExpired = expvar.NewInt("queue-expired")
+
 // UserCount is the total authenticated live user count.
 // This should be handled by the implementing struct.
 var UserCount = expvar.NewInt("users")
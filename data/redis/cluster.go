@@ -0,0 +1,121 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/data"
+)
+
+// Cluster is a Redis pub/sub based transport for relaying requests between server instances (nodes).
+// Each node subscribes to its own channel and the nodes holding a user's connections are kept in a set per user.
+// Nodes that went away without leaving are dropped from the sets as soon as a publish to them reaches no subscriber.
+type Cluster struct {
+	Client *Client
+	Prefix string // Key prefix for all the keys and channels used by the cluster.
+	NodeID string // Unique ID of this node.
+}
+
+// NewCluster creates a new Redis cluster transport for the server at the given network address, with a random node ID.
+func NewCluster(addr string) (*Cluster, error) {
+	id, err := shortid.ID(64)
+	if err != nil {
+		return nil, fmt.Errorf("redis: cluster: failed to generate node ID: %v", err)
+	}
+
+	return &Cluster{Client: NewClient(addr), Prefix: "titan:", NodeID: id}, nil
+}
+
+type relayedReq struct {
+	UserID  string             `json:"userid"`
+	Request data.QueuedRequest `json:"request"`
+}
+
+// Join registers this node as holding a connection of the given user.
+func (c *Cluster) Join(userID string) error {
+	if _, err := c.Client.Do("SADD", c.userKey(userID), c.NodeID); err != nil {
+		return fmt.Errorf("redis: cluster: failed to join user %v: %v", userID, err)
+	}
+	return nil
+}
+
+// Leave unregisters this node for the given user.
+func (c *Cluster) Leave(userID string) error {
+	if _, err := c.Client.Do("SREM", c.userKey(userID), c.NodeID); err != nil {
+		return fmt.Errorf("redis: cluster: failed to leave user %v: %v", userID, err)
+	}
+	return nil
+}
+
+// Publish relays a request to the other nodes holding a connection of the given user.
+// Returns false if there is no such node.
+func (c *Cluster) Publish(userID string, req *data.QueuedRequest) (ok bool, err error) {
+	res, err := c.Client.Do("SMEMBERS", c.userKey(userID))
+	if err != nil {
+		return false, fmt.Errorf("redis: cluster: failed to get nodes of user %v: %v", userID, err)
+	}
+
+	nodes, _ := res.([]interface{})
+	if len(nodes) == 0 {
+		return false, nil
+	}
+
+	r, err := json.Marshal(relayedReq{UserID: userID, Request: *req})
+	if err != nil {
+		return false, fmt.Errorf("redis: cluster: failed to serialize request: %v", err)
+	}
+
+	for _, n := range nodes {
+		b, _ := n.([]byte)
+		node := string(b)
+		if node == c.NodeID {
+			continue
+		}
+
+		res, err := c.Client.Do("PUBLISH", c.channel(node), string(r))
+		if err != nil {
+			return ok, fmt.Errorf("redis: cluster: failed to publish request for user %v: %v", userID, err)
+		}
+
+		if recv, _ := res.(int64); recv == 0 {
+			logger.Warnf("cluster: dropping unreachable node %v of user %v", node, userID)
+			c.Client.Do("SREM", c.userKey(userID), node)
+			continue
+		}
+		ok = true
+	}
+
+	return ok, nil
+}
+
+// Subscribe registers a handler to be called with each request relayed to this node by the other nodes.
+func (c *Cluster) Subscribe(handler func(userID string, req *data.QueuedRequest)) error {
+	return c.Client.Subscribe(c.channel(c.NodeID), func(msg string) {
+		var r relayedReq
+		if err := json.Unmarshal([]byte(msg), &r); err != nil {
+			logger.Errorf("cluster: failed to deserialize relayed request: %v", err)
+			return
+		}
+		handler(r.UserID, &r.Request)
+	})
+}
+
+// Ping verifies that the Redis server is reachable.
+func (c *Cluster) Ping() error {
+	_, err := c.Client.Do("PING")
+	return err
+}
+
+// Close closes the Redis connections.
+func (c *Cluster) Close() error {
+	return c.Client.Close()
+}
+
+func (c *Cluster) userKey(userID string) string {
+	return c.Prefix + "cluster:user:" + userID
+}
+
+func (c *Cluster) channel(nodeID string) string {
+	return c.Prefix + "cluster:node:" + nodeID
+}
@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+)
+
+func newTestCluster(t *testing.T) *Cluster {
+	c, err := NewCluster(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Prefix = "titan-test:"
+
+	if _, err := c.Client.Do("PING"); err != nil {
+		t.Skipf("skipping Redis test: %v", err)
+	}
+
+	return c
+}
+
+func TestCluster(t *testing.T) {
+	c1, c2 := newTestCluster(t), newTestCluster(t)
+	defer c1.Close()
+	defer c2.Close()
+
+	c1.Client.Do("DEL", c1.userKey("1"))
+
+	relayed := make(chan data.QueuedRequest, 10)
+	if err := c2.Subscribe(func(userID string, req *data.QueuedRequest) {
+		if userID == "1" {
+			relayed <- *req
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &data.QueuedRequest{ID: "r1", Method: "msg.recv", Params: json.RawMessage(`[{"message":"hi"}]`)}
+
+	// user is not connected to any node yet
+	if ok, err := c1.Publish("1", req); err != nil || ok {
+		t.Fatalf("expected publish to fail for user without nodes, got: %v, %v", ok, err)
+	}
+
+	if err := c2.Join("1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c1.Publish("1", req); err != nil || !ok {
+		t.Fatalf("expected request to be published, got: %v, %v", ok, err)
+	}
+
+	select {
+	case r := <-relayed:
+		if r.ID != "r1" || r.Method != "msg.recv" || string(r.Params) != `[{"message":"hi"}]` {
+			t.Fatalf("unexpected relayed request: %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not get relayed request in time")
+	}
+
+	// nodes should not relay to themselves
+	if ok, err := c2.Publish("1", req); err != nil || ok {
+		t.Fatalf("expected publish to skip own node, got: %v, %v", ok, err)
+	}
+
+	// nodes without subscribers are dropped
+	c3 := newTestCluster(t)
+	c3.Join("1")
+	c3.Close()
+	if err := c2.Leave("1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c1.Publish("1", req); err != nil || ok {
+		t.Fatalf("expected publish to fail for user on unreachable node, got: %v, %v", ok, err)
+	}
+	if res, _ := c1.Client.Do("SCARD", c1.userKey("1")); res.(int64) != 0 {
+		t.Fatalf("expected unreachable node to be dropped, got %v nodes", res)
+	}
+}
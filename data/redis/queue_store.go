@@ -0,0 +1,154 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/titan-x/titan/data"
+)
+
+// QueueStore is a Redis backed queue storage to be shared by multiple server instances.
+// Each user's queue is kept as a list of request IDs (to preserve order) along with a hash of request ID -> request.
+// Whenever a request is added, user ID is published so the server instance holding the user's connection can deliver it.
+type QueueStore struct {
+	Client *Client
+	Prefix string // Key prefix for all the keys used by the store.
+}
+
+// NewQueueStore creates a new Redis queue store for the server at the given network address.
+func NewQueueStore(addr string) *QueueStore {
+	return &QueueStore{Client: NewClient(addr), Prefix: "titan:"}
+}
+
+// AddRequest appends a request to the user's queue and notifies all subscribers.
+func (s *QueueStore) AddRequest(userID string, req *data.QueuedRequest) error {
+	r, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("redis: queue store: failed to serialize request: %v", err)
+	}
+
+	if _, err := s.Client.Transaction(
+		[]string{"HSET", s.reqsKey(userID), req.ID, string(r)},
+		[]string{"RPUSH", s.idsKey(userID), req.ID}); err != nil {
+		return fmt.Errorf("redis: queue store: failed to add request for user %v: %v", userID, err)
+	}
+
+	if _, err := s.Client.Do("PUBLISH", s.channel(), userID); err != nil {
+		return fmt.Errorf("redis: queue store: failed to publish request for user %v: %v", userID, err)
+	}
+
+	return nil
+}
+
+// RemoveRequest removes a request from the user's queue.
+func (s *QueueStore) RemoveRequest(userID, reqID string) error {
+	if _, err := s.Client.Transaction(
+		[]string{"HDEL", s.reqsKey(userID), reqID},
+		[]string{"LREM", s.idsKey(userID), "1", reqID}); err != nil {
+		return fmt.Errorf("redis: queue store: failed to remove request %v for user %v: %v", reqID, userID, err)
+	}
+
+	return nil
+}
+
+// GetRequests retrieves all the queued requests for a user, in the order they were added.
+func (s *QueueStore) GetRequests(userID string) ([]data.QueuedRequest, error) {
+	res, err := s.Client.Transaction(
+		[]string{"LRANGE", s.idsKey(userID), "0", "-1"},
+		[]string{"HGETALL", s.reqsKey(userID)})
+	if err != nil {
+		return nil, fmt.Errorf("redis: queue store: failed to get requests for user %v: %v", userID, err)
+	}
+
+	ids, _ := res[0].([]interface{})
+	kvs, _ := res[1].([]interface{})
+	reqs := make(map[string][]byte, len(kvs)/2)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		k, _ := kvs[i].([]byte)
+		v, _ := kvs[i+1].([]byte)
+		reqs[string(k)] = v
+	}
+
+	var qreqs []data.QueuedRequest
+	for _, id := range ids {
+		b, _ := id.([]byte)
+		r, ok := reqs[string(b)]
+		if !ok {
+			continue
+		}
+
+		var qr data.QueuedRequest
+		if err := json.Unmarshal(r, &qr); err != nil {
+			return nil, fmt.Errorf("redis: queue store: failed to deserialize request %s for user %v: %v", b, userID, err)
+		}
+		qreqs = append(qreqs, qr)
+	}
+
+	return qreqs, nil
+}
+
+// Reserve maps the client supplied message ID of a user to the server generated message ID for the given TTL, unless it is
+// already mapped by any server instance, in which case the existing server generated ID is returned with dup = true.
+func (s *QueueStore) Reserve(userID, clientID, id string, ttl time.Duration) (existing string, dup bool, err error) {
+	k := s.dedupeKey(userID, clientID)
+	for {
+		res, err := s.Client.Do("SET", k, id, "NX", "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+		if err != nil {
+			return "", false, fmt.Errorf("redis: queue store: failed to reserve client message ID of user %v: %v", userID, err)
+		}
+		if res != nil {
+			return id, false, nil
+		}
+
+		res, err = s.Client.Do("GET", k)
+		if err != nil {
+			return "", false, fmt.Errorf("redis: queue store: failed to get client message ID of user %v: %v", userID, err)
+		}
+		// mapping might have expired or been released in between, in which case it is reserved again
+		if b, _ := res.([]byte); b != nil {
+			return string(b), true, nil
+		}
+	}
+}
+
+// Release removes the mapping of a client supplied message ID of a user.
+func (s *QueueStore) Release(userID, clientID string) error {
+	if _, err := s.Client.Do("DEL", s.dedupeKey(userID, clientID)); err != nil {
+		return fmt.Errorf("redis: queue store: failed to release client message ID of user %v: %v", userID, err)
+	}
+	return nil
+}
+
+// Subscribe registers a handler to be called with the user ID whenever a request is added to a user's queue, by any server instance.
+func (s *QueueStore) Subscribe(handler func(userID string)) error {
+	return s.Client.Subscribe(s.channel(), handler)
+}
+
+// Ping verifies that the Redis server is reachable.
+func (s *QueueStore) Ping() error {
+	_, err := s.Client.Do("PING")
+	return err
+}
+
+// Close closes the Redis connections.
+func (s *QueueStore) Close() error {
+	return s.Client.Close()
+}
+
+func (s *QueueStore) idsKey(userID string) string {
+	return s.Prefix + "queue:" + userID
+}
+
+func (s *QueueStore) reqsKey(userID string) string {
+	return s.Prefix + "queue:" + userID + ":reqs"
+}
+
+func (s *QueueStore) dedupeKey(userID, clientID string) string {
+	return s.Prefix + "dedupe:" + userID + ":" + clientID
+}
+
+func (s *QueueStore) channel() string {
+	return s.Prefix + "queue"
+}
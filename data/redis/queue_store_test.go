@@ -0,0 +1,88 @@
+package redis
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+)
+
+const addr = "127.0.0.1:6379"
+
+func newTestQueueStore(t *testing.T) *QueueStore {
+	s := NewQueueStore(addr)
+	s.Prefix = "titan-test:"
+
+	if _, err := s.Client.Do("PING"); err != nil {
+		t.Skipf("skipping Redis test: %v", err)
+	}
+
+	return s
+}
+
+func TestQueueStore(t *testing.T) {
+	s := newTestQueueStore(t)
+	defer s.Close()
+
+	s.Client.Do("DEL", s.idsKey("1"), s.reqsKey("1"))
+
+	notified := make(chan string, 10)
+	if err := s.Subscribe(func(userID string) { notified <- userID }); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"r1", "r2", "r3"} {
+		if err := s.AddRequest("1", &data.QueuedRequest{ID: id, Method: "msg.recv", Params: json.RawMessage(`[{"message":"hi"}]`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case userID := <-notified:
+			if userID != "1" {
+				t.Fatalf("expected notification for user 1, got: %v", userID)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("did not get queue notification in time")
+		}
+	}
+
+	if err := s.RemoveRequest("1", "r2"); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs, err := s.GetRequests("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 || reqs[0].ID != "r1" || reqs[1].ID != "r3" || string(reqs[0].Params) != `[{"message":"hi"}]` {
+		t.Fatalf("unexpected queue contents: %+v", reqs)
+	}
+
+	if reqs, err := s.GetRequests("2"); err != nil || len(reqs) != 0 {
+		t.Fatalf("expected empty queue for user without requests, got: %+v, %v", reqs, err)
+	}
+}
+
+func TestQueueStoreDedupe(t *testing.T) {
+	s := newTestQueueStore(t)
+	defer s.Close()
+
+	s.Client.Do("DEL", s.dedupeKey("1", "c1"))
+
+	if id, dup, err := s.Reserve("1", "c1", "m1", time.Minute); err != nil || dup || id != "m1" {
+		t.Fatalf("expected client ID to be reserved, got: %v, %v, %v", id, dup, err)
+	}
+	if id, dup, err := s.Reserve("1", "c1", "m2", time.Minute); err != nil || !dup || id != "m1" {
+		t.Fatalf("expected existing message ID, got: %v, %v, %v", id, dup, err)
+	}
+
+	if err := s.Release("1", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if id, dup, err := s.Reserve("1", "c1", "m3", time.Minute); err != nil || dup || id != "m3" {
+		t.Fatalf("expected released client ID to be reserved again, got: %v, %v, %v", id, dup, err)
+	}
+}
@@ -0,0 +1,316 @@
+// Package redis provides Redis implementation of data interfaces.
+// A minimal RESP (REdis Serialization Protocol) client is included so no external Redis client library is needed.
+//
+// RESP Specs: http://redis.io/topics/protocol
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/log"
+)
+
+var logger = log.Component("redis")
+
+// Error is an error reply returned by the Redis server.
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// Client is a Redis client. Commands are sent over a single shared connection which is re-established on network errors.
+type Client struct {
+	Addr string
+
+	mutex  sync.Mutex
+	conn   *conn
+	subs   map[*conn]bool // dedicated subscription connections
+	closed bool
+}
+
+// NewClient creates a new Redis client for the server at the given network address (i.e. 127.0.0.1:6379).
+// Connection is established lazily with the first command.
+func NewClient(addr string) *Client {
+	return &Client{Addr: addr, subs: make(map[*conn]bool)}
+}
+
+// Do sends a command to the server and returns the reply.
+// Replies are one of: string (status reply), int64, []byte (bulk reply, nil if missing), []interface{} (multi-bulk reply).
+func (c *Client) Do(args ...string) (interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cn, err := c.getConn()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cn.write(args...); err != nil {
+		c.closeConn()
+		return nil, err
+	}
+
+	res, err := cn.read()
+	if _, ok := err.(Error); err != nil && !ok {
+		c.closeConn()
+	}
+	return res, err
+}
+
+// Transaction executes given commands atomically within a MULTI/EXEC block and returns the replies to each command.
+func (c *Client) Transaction(cmds ...[]string) ([]interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cn, err := c.getConn()
+	if err != nil {
+		return nil, err
+	}
+
+	// pipeline all the commands and read all the replies in one go
+	cmds = append(append([][]string{{"MULTI"}}, cmds...), []string{"EXEC"})
+	for _, cmd := range cmds {
+		if err := cn.write(cmd...); err != nil {
+			c.closeConn()
+			return nil, err
+		}
+	}
+
+	var res interface{}
+	var rerr error
+	for range cmds {
+		r, err := cn.read()
+		if _, ok := err.(Error); err != nil && !ok {
+			c.closeConn()
+			return nil, err
+		}
+		if err != nil && rerr == nil {
+			rerr = err
+		}
+		res = r
+	}
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	replies, ok := res.([]interface{})
+	if !ok {
+		return nil, errors.New("redis: transaction was aborted")
+	}
+	return replies, nil
+}
+
+// Subscribe subscribes to the given channel on a dedicated connection and calls the handler with each published message.
+// Subscription is re-established automatically upon connection errors until the client is closed.
+func (c *Client) Subscribe(channel string, handler func(msg string)) error {
+	cn, err := c.subscribe(channel)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			err := c.receive(cn, handler)
+			if !c.unsubscribe(cn) {
+				return
+			}
+
+			logger.Warnf("subscription to channel %v interrupted, reconnecting: %v", channel, err)
+			for {
+				time.Sleep(time.Second)
+				if cn, err = c.subscribe(channel); err == nil {
+					break
+				}
+				if _, closed := err.(closedErr); closed {
+					return
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close closes the client connection and stops any subscriptions.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	c.closeConn()
+	for cn := range c.subs {
+		cn.Close()
+	}
+	return nil
+}
+
+type closedErr struct{}
+
+func (closedErr) Error() string {
+	return "redis: use of closed client"
+}
+
+func (c *Client) subscribe(channel string) (*conn, error) {
+	c.mutex.Lock()
+	closed := c.closed
+	c.mutex.Unlock()
+	if closed {
+		return nil, closedErr{}
+	}
+
+	cn, err := dial(c.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cn.write("SUBSCRIBE", channel); err != nil {
+		cn.Close()
+		return nil, err
+	}
+	if _, err := cn.read(); err != nil {
+		cn.Close()
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		cn.Close()
+		return nil, closedErr{}
+	}
+	c.subs[cn] = true
+	return cn, nil
+}
+
+// unsubscribe closes and forgets a subscription connection. Returns false if the client is already closed.
+func (c *Client) unsubscribe(cn *conn) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cn.Close()
+	delete(c.subs, cn)
+	return !c.closed
+}
+
+func (c *Client) receive(cn *conn, handler func(msg string)) error {
+	for {
+		res, err := cn.read()
+		if err != nil {
+			return err
+		}
+
+		// published messages are in the form: ["message", channel, payload]
+		if m, ok := res.([]interface{}); ok && len(m) == 3 {
+			if kind, ok := m[0].([]byte); ok && string(kind) == "message" {
+				if payload, ok := m[2].([]byte); ok {
+					handler(string(payload))
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) getConn() (*conn, error) {
+	if c.closed {
+		return nil, closedErr{}
+	}
+
+	if c.conn == nil {
+		cn, err := dial(c.Addr)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = cn
+	}
+
+	return c.conn, nil
+}
+
+func (c *Client) closeConn() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// conn is a RESP protocol connection.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func dial(addr string) (*conn, error) {
+	c, err := net.DialTimeout("tcp", addr, time.Second*10)
+	if err != nil {
+		return nil, fmt.Errorf("redis: failed to connect to %v: %v", addr, err)
+	}
+
+	return &conn{Conn: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}, nil
+}
+
+// write sends a command as an array of bulk strings.
+func (c *conn) write(args ...string) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	return c.w.Flush()
+}
+
+// read reads a single reply.
+func (c *conn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply: %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			// errors within arrays (i.e. failed commands in EXEC reply) are returned in place
+			if arr[i], err = c.read(); err != nil {
+				if _, ok := err.(Error); !ok {
+					return nil, err
+				}
+				arr[i] = err
+			}
+		}
+		return arr, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type: %q", line)
+	}
+}
@@ -0,0 +1,30 @@
+package data
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SearchTerms splits a text into the lowercase words it contains, along with the number of times each word occurs,
+// for indexing the message bodies and matching the search queries.
+func SearchTerms(text string) map[string]int {
+	terms := make(map[string]int)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) }) {
+		terms[w]++
+	}
+	return terms
+}
+
+// SearchScore returns the relevance of a text with the given terms to a search query with the given terms, which is the number of
+// the occurrences of the query words in the text, or zero if any of the query words is missing.
+func SearchScore(text, query map[string]int) int {
+	score := 0
+	for w := range query {
+		n := text[w]
+		if n == 0 {
+			return 0
+		}
+		score += n
+	}
+	return score
+}
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"time"
+
+	"github.com/titan-x/titan/models"
+)
+
+// splitDB is a database which keeps the message history in a separate message database.
+type splitDB struct {
+	DB
+	messages MessageDB
+}
+
+// WithMessageDB returns a database which keeps the message history in the given message database (i.e. one suited for
+// high write volumes), and the rest of the data in the given database.
+func WithMessageDB(db DB, messages MessageDB) DB {
+	return &splitDB{DB: db, messages: messages}
+}
+
+// Ping verifies the connectivity of both of the databases, if supported.
+func (db *splitDB) Ping() error {
+	for _, d := range []interface{}{db.DB, db.messages} {
+		if p, ok := d.(Pinger); ok {
+			if err := p.Ping(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (db *splitDB) GetMessage(id string) (m *models.Message, ok bool) {
+	return db.messages.GetMessage(id)
+}
+
+func (db *splitDB) SaveMessage(m *models.Message) error {
+	return db.messages.SaveMessage(m)
+}
+
+func (db *splitDB) GetMessages(conversation string, before time.Time, offset, limit int) ([]models.Message, error) {
+	return db.messages.GetMessages(conversation, before, offset, limit)
+}
+
+func (db *splitDB) IndexMessage(m *models.Message, userIDs []string) error {
+	return db.messages.IndexMessage(m, userIDs)
+}
+
+func (db *splitDB) SearchMessages(userID, query, conversation string, before time.Time, offset, limit int) ([]models.Message, error) {
+	return db.messages.SearchMessages(userID, query, conversation, before, offset, limit)
+}
+
+func (db *splitDB) DeleteUserMessages(userID string) error {
+	return db.messages.DeleteUserMessages(userID)
+}
+
+func (db *splitDB) UpdateDeliveryState(id, state string) error {
+	return db.messages.UpdateDeliveryState(id, state)
+}
+
+func (db *splitDB) DeleteMessagesBefore(t time.Time) (int, error) {
+	return db.messages.DeleteMessagesBefore(t)
+}
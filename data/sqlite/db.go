@@ -0,0 +1,932 @@
+//go:build sqlite
+// +build sqlite
+
+// Package sqlite provides SQLite implementation of data interfaces, for single node installations with durable storage
+// and no external database server. It is not included in the default build and requires building with the sqlite tag
+// (go build -tags sqlite) and cgo.
+//
+// SQLite driver: https://github.com/mattn/go-sqlite3
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // registers sqlite3 driver
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/data/migrate"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+)
+
+var logger = log.Component("sqlite")
+
+// migrations are the schema changes applied by Migrator in version order. New changes go into a new file, i.e. 0002_<name>.sql.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+const (
+	userCols = "id, registered, email, phone_number, gcm_reg_id, apns_device_token, name, picture, jwt_token, status, avatar, delete_at, unverified, role"
+	msgCols  = "id, conversation, sender, recipient, group_id, body, attachment, time, state, encrypted"
+	attCols  = "id, owner, size, mime_type, checksum, uploaded, conversations, thumbnails, created"
+)
+
+// DB is a SQLite implementation of DB interface.
+type DB struct {
+	DB *sql.DB // Connection pool, limited to a single connection as SQLite allows a single writer at a time.
+}
+
+// NewDB opens the SQLite database file at the given path, which is created if it does not exist.
+// Driver parameters can be appended to the path as described at: https://github.com/mattn/go-sqlite3#connection-string
+// By default, the database is opened in write-ahead logging mode with a busy timeout of 5 seconds.
+func NewDB(path string) (*DB, error) {
+	dsn := path
+	if !strings.Contains(dsn, "?") {
+		dsn = "file:" + path + "?_journal_mode=WAL&_busy_timeout=5000"
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: failed to open database: %v", err)
+	}
+
+	// a single connection serializes the writes instead of failing them with "database is locked" errors
+	db.SetMaxOpenConns(1)
+	return &DB{DB: db}, nil
+}
+
+// Migrator returns the migrator of the database schema, which applies the migrations embedded from the migrations directory.
+func (db *DB) Migrator() (*migrate.Migrator, error) {
+	ms, err := migrate.Load(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return &migrate.Migrator{DB: db.DB, Migrations: ms}, nil
+}
+
+// Seed applies the pending schema migrations and populates the database with the seed data, overwriting existing data if specified.
+func (db *DB) Seed(overwrite bool, jwtPass string) error {
+	if err := db.DB.Ping(); err != nil {
+		return fmt.Errorf("sqlite: failed to open database: %v", err)
+	}
+
+	if overwrite {
+		for _, t := range []string{"users", "groups", "refresh_tokens", "messages", "message_terms", "identity_keys", "prekeys", "attachments", "topics", "topic_subscribers", "contacts", "blocks", "mutes", "verifications", "totp", "schema_migrations"} {
+			if _, err := db.DB.Exec("DROP TABLE IF EXISTS " + t); err != nil {
+				return fmt.Errorf("sqlite: failed to drop tables: %v", err)
+			}
+		}
+	}
+
+	m, err := db.Migrator()
+	if err != nil {
+		return err
+	}
+	if n, err := m.Up(); err != nil {
+		return err
+	} else if n > 0 {
+		logger.Infof("applied %v schema migrations", n)
+	}
+
+	if !overwrite {
+		var count int
+		if err := db.DB.QueryRow("SELECT count(*) FROM users").Scan(&count); err != nil {
+			return err
+		} else if count != 0 {
+			return nil
+		}
+	}
+
+	// insert the seed data
+	if err := data.SeedInit(jwtPass); err != nil {
+		return err
+	}
+
+	for _, u := range data.SeedUsers {
+		if err := db.SaveUser(&u); err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("initialized with seed data")
+	return nil
+}
+
+// GetByID retrieves a user by ID with OK indicator.
+func (db *DB) GetByID(id string) (u *models.User, ok bool) {
+	return db.getUser("SELECT "+userCols+" FROM users WHERE id = ?", id)
+}
+
+// GetByEmail retrieves a user by e-mail with OK indicator.
+func (db *DB) GetByEmail(email string) (u *models.User, ok bool) {
+	return db.getUser("SELECT "+userCols+" FROM users WHERE email = ? LIMIT 1", email)
+}
+
+// GetByPhone retrieves a user by phone number with OK indicator.
+func (db *DB) GetByPhone(phone string) (u *models.User, ok bool) {
+	return db.getUser("SELECT "+userCols+" FROM users WHERE phone_number = ? LIMIT 1", phone)
+}
+
+// SaveUser creates or updates a user. Upon creation, users are assigned a unique ID.
+func (db *DB) SaveUser(u *models.User) error {
+	if u.ID == "" {
+		id, err := shortid.ID(64)
+		if err != nil {
+			return err
+		}
+
+		u.ID = id
+	}
+
+	_, err := db.DB.Exec(`INSERT INTO users (`+userCols+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			registered = excluded.registered, email = excluded.email, phone_number = excluded.phone_number,
+			gcm_reg_id = excluded.gcm_reg_id, apns_device_token = excluded.apns_device_token, name = excluded.name,
+			picture = excluded.picture, jwt_token = excluded.jwt_token, status = excluded.status, avatar = excluded.avatar,
+			delete_at = excluded.delete_at, unverified = excluded.unverified, role = excluded.role`,
+		u.ID, unixTime{&u.Registered}, u.Email, u.PhoneNumber, u.GCMRegID, u.APNSDeviceToken, u.Name, nullBytes(u.Picture), u.JWTToken, u.Status, u.Avatar, unixTime{&u.DeleteAt}, u.Unverified, u.Role)
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to save user: %v", err)
+	}
+
+	return nil
+}
+
+// GetUserIDs retrieves up to limit user IDs that come after the given one, in ascending order.
+func (db *DB) GetUserIDs(after string, limit int) ([]string, error) {
+	return db.getStrings("user ids", "SELECT id FROM users WHERE id > ? ORDER BY id LIMIT ?", after, limit)
+}
+
+// GetDueDeletions retrieves the IDs of the users whose accounts are to be deleted at or before the given time, in ascending order.
+func (db *DB) GetDueDeletions(t time.Time) ([]string, error) {
+	return db.getStrings("due deletions", "SELECT id FROM users WHERE delete_at <= ? ORDER BY id", nanos(t))
+}
+
+// DeleteUser deletes a user along with the refresh tokens, the encryption keys, and the second factor of the user.
+func (db *DB) DeleteUser(id string) error {
+	return db.execTx("delete user", []string{
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+		"DELETE FROM prekeys WHERE user_id = ?",
+		"DELETE FROM identity_keys WHERE user_id = ?",
+		"DELETE FROM totp WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	}, id)
+}
+
+// GetGroup retrieves a group by ID with OK indicator.
+func (db *DB) GetGroup(id string) (g *models.Group, ok bool) {
+	var gr models.Group
+	err := db.DB.QueryRow("SELECT id, name, owner, members, created FROM groups WHERE id = ?", id).Scan(&gr.ID, &gr.Name, &gr.Owner, jsonValue{&gr.Members}, unixTime{&gr.Created})
+	if err == sql.ErrNoRows {
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("get group error: %v", err)
+		return nil, false
+	}
+
+	return &gr, true
+}
+
+// SaveGroup creates or updates a group. Upon creation, groups are assigned a unique ID.
+func (db *DB) SaveGroup(g *models.Group) error {
+	if g.ID == "" {
+		id, err := shortid.ID(64)
+		if err != nil {
+			return err
+		}
+
+		g.ID = id
+	}
+
+	_, err := db.DB.Exec(`INSERT INTO groups (id, name, owner, members, created) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, owner = excluded.owner, members = excluded.members`,
+		g.ID, g.Name, g.Owner, jsonValue{&g.Members}, unixTime{&g.Created})
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to save group: %v", err)
+	}
+
+	return nil
+}
+
+// GetRefreshToken retrieves a refresh token by ID with OK indicator.
+func (db *DB) GetRefreshToken(id string) (t *models.RefreshToken, ok bool) {
+	var tk models.RefreshToken
+	err := db.DB.QueryRow("SELECT id, user_id, device, created, revoked FROM refresh_tokens WHERE id = ?", id).Scan(&tk.ID, &tk.UserID, &tk.Device, unixTime{&tk.Created}, &tk.Revoked)
+	if err == sql.ErrNoRows {
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("get refresh token error: %v", err)
+		return nil, false
+	}
+
+	return &tk, true
+}
+
+// SaveRefreshToken creates or updates a refresh token.
+func (db *DB) SaveRefreshToken(t *models.RefreshToken) error {
+	_, err := db.DB.Exec(`INSERT INTO refresh_tokens (id, user_id, device, created, revoked) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET revoked = excluded.revoked`,
+		t.ID, t.UserID, t.Device, unixTime{&t.Created}, t.Revoked)
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to save refresh token: %v", err)
+	}
+
+	return nil
+}
+
+// GetRefreshTokens retrieves all the refresh tokens of a user.
+func (db *DB) GetRefreshTokens(userID string) ([]models.RefreshToken, error) {
+	rows, err := db.DB.Query("SELECT id, user_id, device, created, revoked FROM refresh_tokens WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: failed to get refresh tokens: %v", err)
+	}
+	defer rows.Close()
+
+	ts := []models.RefreshToken{}
+	for rows.Next() {
+		var t models.RefreshToken
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Device, unixTime{&t.Created}, &t.Revoked); err != nil {
+			return nil, fmt.Errorf("sqlite: failed to read refresh tokens: %v", err)
+		}
+		ts = append(ts, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: failed to read refresh tokens: %v", err)
+	}
+
+	return ts, nil
+}
+
+// GetMessage retrieves a message by ID with OK indicator.
+func (db *DB) GetMessage(id string) (m *models.Message, ok bool) {
+	var msg models.Message
+	err := db.DB.QueryRow("SELECT "+msgCols+" FROM messages WHERE id = ?", id).Scan(msgFields(&msg)...)
+	if err == sql.ErrNoRows {
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("get message error: %v", err)
+		return nil, false
+	}
+
+	return &msg, true
+}
+
+// SaveMessage creates or updates a message. Only the delivery state of existing messages is updated.
+func (db *DB) SaveMessage(m *models.Message) error {
+	_, err := db.DB.Exec(`INSERT INTO messages (`+msgCols+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET state = excluded.state`,
+		m.ID, m.Conversation, m.From, m.To, m.Group, m.Message, jsonValue{&m.Attachment}, unixTime{&m.Time}, m.State, m.Encrypted)
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to save message: %v", err)
+	}
+
+	return nil
+}
+
+// GetMessages retrieves the messages of a conversation sent at or before the given time, newest first, skipping the first offset messages.
+func (db *DB) GetMessages(conversation string, before time.Time, offset, limit int) ([]models.Message, error) {
+	return db.getMessages("SELECT "+msgCols+" FROM messages WHERE conversation = ? AND time <= ? ORDER BY seq DESC LIMIT ? OFFSET ?", conversation, nanos(before), limit, offset)
+}
+
+// IndexMessage indexes the words of a message for the given users, along with the number of times each word occurs in the message.
+func (db *DB) IndexMessage(m *models.Message, userIDs []string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to index message: %v", err)
+	}
+	defer tx.Rollback()
+
+	for w, n := range data.SearchTerms(m.Message) {
+		for _, uid := range userIDs {
+			if _, err := tx.Exec("INSERT INTO message_terms (user_id, word, message_id, occurrences) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING", uid, w, m.ID, n); err != nil {
+				return fmt.Errorf("sqlite: failed to index message: %v", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite: failed to index message: %v", err)
+	}
+	return nil
+}
+
+// SearchMessages retrieves the messages indexed for a user which contain all the words of the query, ordered by relevance, then newest first.
+// Relevance is the number of the occurrences of the query words in the message, as with data.SearchScore.
+func (db *DB) SearchMessages(userID, query, conversation string, before time.Time, offset, limit int) ([]models.Message, error) {
+	q := data.SearchTerms(query)
+	if len(q) == 0 {
+		return []models.Message{}, nil
+	}
+
+	args := []interface{}{userID}
+	for w := range q {
+		args = append(args, w)
+	}
+	args = append(args, conversation, conversation, nanos(before), len(q), limit, offset)
+
+	return db.getMessages(`SELECT `+msgCols+` FROM messages JOIN message_terms ON message_id = id
+		WHERE user_id = ? AND word IN (?`+strings.Repeat(", ?", len(q)-1)+`) AND (? = '' OR conversation = ?) AND time <= ?
+		GROUP BY id HAVING count(*) = ?
+		ORDER BY sum(occurrences) DESC, seq DESC LIMIT ? OFFSET ?`, args...)
+}
+
+// DeleteUserMessages deletes the messages sent by a user, along with the search index of the user.
+func (db *DB) DeleteUserMessages(userID string) error {
+	return db.execTx("delete messages", []string{
+		"DELETE FROM message_terms WHERE user_id = ?1 OR message_id IN (SELECT id FROM messages WHERE sender = ?1)",
+		"DELETE FROM messages WHERE sender = ?1",
+	}, userID)
+}
+
+// UpdateDeliveryState sets the latest delivery state of a message.
+func (db *DB) UpdateDeliveryState(id, state string) error {
+	if _, err := db.DB.Exec("UPDATE messages SET state = ? WHERE id = ?", state, id); err != nil {
+		return fmt.Errorf("sqlite: failed to update message state: %v", err)
+	}
+	return nil
+}
+
+// DeleteMessagesBefore deletes the messages sent before the given time, along with their search index.
+func (db *DB) DeleteMessagesBefore(t time.Time) (int, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("sqlite: failed to delete messages: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM message_terms WHERE message_id IN (SELECT id FROM messages WHERE time < ?)", nanos(t)); err != nil {
+		return 0, fmt.Errorf("sqlite: failed to delete messages: %v", err)
+	}
+	res, err := tx.Exec("DELETE FROM messages WHERE time < ?", nanos(t))
+	if err != nil {
+		return 0, fmt.Errorf("sqlite: failed to delete messages: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("sqlite: failed to delete messages: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("sqlite: failed to delete messages: %v", err)
+	}
+	return int(n), nil
+}
+
+// getMessages retrieves the messages returned by the given query, which selects msgCols.
+func (db *DB) getMessages(query string, args ...interface{}) ([]models.Message, error) {
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: failed to get messages: %v", err)
+	}
+	defer rows.Close()
+
+	msgs := []models.Message{}
+	for rows.Next() {
+		var m models.Message
+		if err := rows.Scan(msgFields(&m)...); err != nil {
+			return nil, fmt.Errorf("sqlite: failed to read messages: %v", err)
+		}
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: failed to read messages: %v", err)
+	}
+
+	return msgs, nil
+}
+
+// SaveKeys replaces the identity key and the signed prekey of a user, and adds the one-time prekeys.
+// Existing one-time prekeys are dropped if the identity key changes.
+func (db *DB) SaveKeys(b *models.KeyBundle) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to save keys: %v", err)
+	}
+	defer tx.Rollback()
+
+	var ik []byte
+	err = tx.QueryRow("SELECT identity_key FROM identity_keys WHERE user_id = ?", b.UserID).Scan(&ik)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("sqlite: failed to get keys: %v", err)
+	}
+	if err == nil && !bytes.Equal(ik, b.IdentityKey) {
+		if _, err := tx.Exec("DELETE FROM prekeys WHERE user_id = ?", b.UserID); err != nil {
+			return fmt.Errorf("sqlite: failed to drop prekeys: %v", err)
+		}
+	}
+
+	var spk models.SignedPreKey
+	if b.SignedPreKey != nil {
+		spk = *b.SignedPreKey
+	}
+	_, err = tx.Exec(`INSERT INTO identity_keys (user_id, identity_key, signed_prekey_id, signed_prekey, signed_prekey_signature) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			identity_key = excluded.identity_key, signed_prekey_id = excluded.signed_prekey_id,
+			signed_prekey = excluded.signed_prekey, signed_prekey_signature = excluded.signed_prekey_signature`,
+		b.UserID, nullBytes(b.IdentityKey), spk.ID, nullBytes(spk.Key), nullBytes(spk.Signature))
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to save keys: %v", err)
+	}
+
+	for _, pk := range b.PreKeys {
+		if _, err := tx.Exec("INSERT INTO prekeys (user_id, id, key) VALUES (?, ?, ?)", b.UserID, pk.ID, pk.Key); err != nil {
+			return fmt.Errorf("sqlite: failed to save prekeys: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite: failed to save keys: %v", err)
+	}
+	return nil
+}
+
+// GetKeys retrieves the identity key and the signed prekey of a user.
+func (db *DB) GetKeys(userID string) (b *models.KeyBundle, ok bool, err error) {
+	kb := models.KeyBundle{UserID: userID}
+	var spk models.SignedPreKey
+	err = db.DB.QueryRow("SELECT identity_key, signed_prekey_id, signed_prekey, signed_prekey_signature FROM identity_keys WHERE user_id = ?", userID).
+		Scan(&kb.IdentityKey, &spk.ID, &spk.Key, &spk.Signature)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("sqlite: failed to get keys: %v", err)
+	}
+	if spk.Key != nil {
+		kb.SignedPreKey = &spk
+	}
+
+	return &kb, true, nil
+}
+
+// ClaimKeys retrieves the key bundle of a user along with the oldest one-time prekey, if any, which is removed.
+// Prekey is selected and removed in the same transaction, and SQLite allows a single writer at a time,
+// so concurrent claims never get the same prekey.
+func (db *DB) ClaimKeys(userID string) (b *models.KeyBundle, ok bool, err error) {
+	if b, ok, err = db.GetKeys(userID); !ok || err != nil {
+		return nil, ok, err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("sqlite: failed to claim prekey: %v", err)
+	}
+	defer tx.Rollback()
+
+	var seq int64
+	var pk models.PreKey
+	err = tx.QueryRow("SELECT seq, id, key FROM prekeys WHERE user_id = ? ORDER BY seq LIMIT 1", userID).Scan(&seq, &pk.ID, &pk.Key)
+	if err == sql.ErrNoRows {
+		return b, true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("sqlite: failed to claim prekey: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM prekeys WHERE seq = ?", seq); err != nil {
+		return nil, false, fmt.Errorf("sqlite: failed to claim prekey: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("sqlite: failed to claim prekey: %v", err)
+	}
+
+	b.PreKeys = []models.PreKey{pk}
+	return b, true, nil
+}
+
+// CountPreKeys returns the number of one-time prekeys left for a user.
+func (db *DB) CountPreKeys(userID string) (int, error) {
+	var count int
+	if err := db.DB.QueryRow("SELECT count(*) FROM prekeys WHERE user_id = ?", userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("sqlite: failed to count prekeys: %v", err)
+	}
+	return count, nil
+}
+
+// GetAttachment retrieves the metadata of an attachment by ID.
+func (db *DB) GetAttachment(id string) (a *models.Attachment, ok bool, err error) {
+	var at models.Attachment
+	err = db.DB.QueryRow("SELECT "+attCols+" FROM attachments WHERE id = ?", id).Scan(attFields(&at)...)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("sqlite: failed to get attachment: %v", err)
+	}
+
+	return &at, true, nil
+}
+
+// SaveAttachment creates or updates the metadata of an attachment.
+func (db *DB) SaveAttachment(a *models.Attachment) error {
+	convs := append([]string{}, a.Conversations...)
+	_, err := db.DB.Exec(`INSERT INTO attachments (`+attCols+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET uploaded = excluded.uploaded, conversations = excluded.conversations, thumbnails = excluded.thumbnails`,
+		a.ID, a.Owner, a.Size, a.MimeType, a.Checksum, a.Uploaded, jsonValue{&convs}, jsonValue{&a.Thumbnails}, unixTime{&a.Created})
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to save attachment: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteAttachment deletes the metadata of an attachment, if any.
+func (db *DB) DeleteAttachment(id string) error {
+	if _, err := db.DB.Exec("DELETE FROM attachments WHERE id = ?", id); err != nil {
+		return fmt.Errorf("sqlite: failed to delete attachment: %v", err)
+	}
+	return nil
+}
+
+// GetAttachments retrieves all the attachments of a user.
+func (db *DB) GetAttachments(owner string) ([]models.Attachment, error) {
+	rows, err := db.DB.Query("SELECT "+attCols+" FROM attachments WHERE owner = ?", owner)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: failed to get attachments: %v", err)
+	}
+	defer rows.Close()
+
+	as := []models.Attachment{}
+	for rows.Next() {
+		var a models.Attachment
+		if err := rows.Scan(attFields(&a)...); err != nil {
+			return nil, fmt.Errorf("sqlite: failed to read attachments: %v", err)
+		}
+		as = append(as, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: failed to read attachments: %v", err)
+	}
+
+	return as, nil
+}
+
+// GetTopic retrieves a topic by name.
+func (db *DB) GetTopic(name string) (t *models.Topic, ok bool, err error) {
+	var tp models.Topic
+	err = db.DB.QueryRow("SELECT name, description, created FROM topics WHERE name = ?", name).Scan(&tp.Name, &tp.Description, unixTime{&tp.Created})
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("sqlite: failed to get topic: %v", err)
+	}
+
+	return &tp, true, nil
+}
+
+// SaveTopic creates or updates a topic.
+func (db *DB) SaveTopic(t *models.Topic) error {
+	_, err := db.DB.Exec(`INSERT INTO topics (name, description, created) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET description = excluded.description`,
+		t.Name, t.Description, unixTime{&t.Created})
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to save topic: %v", err)
+	}
+
+	return nil
+}
+
+// Subscribe adds a user to the subscribers of a topic.
+func (db *DB) Subscribe(topic, userID string) error {
+	if _, err := db.DB.Exec("INSERT INTO topic_subscribers (topic, user_id) VALUES (?, ?) ON CONFLICT DO NOTHING", topic, userID); err != nil {
+		return fmt.Errorf("sqlite: failed to subscribe to topic: %v", err)
+	}
+	return nil
+}
+
+// Unsubscribe removes a user from the subscribers of a topic.
+func (db *DB) Unsubscribe(topic, userID string) error {
+	if _, err := db.DB.Exec("DELETE FROM topic_subscribers WHERE topic = ? AND user_id = ?", topic, userID); err != nil {
+		return fmt.Errorf("sqlite: failed to unsubscribe from topic: %v", err)
+	}
+	return nil
+}
+
+// GetSubscribers retrieves up to limit subscriber IDs of a topic that come after the given one, in ascending order.
+func (db *DB) GetSubscribers(topic, after string, limit int) ([]string, error) {
+	return db.getStrings("subscribers", "SELECT user_id FROM topic_subscribers WHERE topic = ? AND user_id > ? ORDER BY user_id LIMIT ?", topic, after, limit)
+}
+
+// GetSubscriptions retrieves the names of the topics a user is subscribed to, in ascending order.
+func (db *DB) GetSubscriptions(userID string) ([]string, error) {
+	return db.getStrings("subscriptions", "SELECT topic FROM topic_subscribers WHERE user_id = ? ORDER BY topic", userID)
+}
+
+// AddContact adds a user to the contacts of another.
+func (db *DB) AddContact(userID, contactID string) error {
+	if _, err := db.DB.Exec("INSERT INTO contacts (user_id, contact_id) VALUES (?, ?) ON CONFLICT DO NOTHING", userID, contactID); err != nil {
+		return fmt.Errorf("sqlite: failed to add contact: %v", err)
+	}
+	return nil
+}
+
+// RemoveContact removes a user from the contacts of another.
+func (db *DB) RemoveContact(userID, contactID string) error {
+	if _, err := db.DB.Exec("DELETE FROM contacts WHERE user_id = ? AND contact_id = ?", userID, contactID); err != nil {
+		return fmt.Errorf("sqlite: failed to remove contact: %v", err)
+	}
+	return nil
+}
+
+// GetContacts retrieves the IDs of the contacts of a user, in ascending order.
+func (db *DB) GetContacts(userID string) ([]string, error) {
+	return db.getStrings("contacts", "SELECT contact_id FROM contacts WHERE user_id = ? ORDER BY contact_id", userID)
+}
+
+// GetContactOf retrieves the IDs of the users who have the given user in their contacts, in ascending order.
+func (db *DB) GetContactOf(contactID string) ([]string, error) {
+	return db.getStrings("contacts", "SELECT user_id FROM contacts WHERE contact_id = ? ORDER BY user_id", contactID)
+}
+
+// HasContact tells whether a user is in the contacts of another.
+func (db *DB) HasContact(userID, contactID string) (bool, error) {
+	return db.exists("contact", "SELECT EXISTS (SELECT 1 FROM contacts WHERE user_id = ? AND contact_id = ?)", userID, contactID)
+}
+
+// Block adds a user to the users blocked by another.
+func (db *DB) Block(userID, blockedID string) error {
+	if _, err := db.DB.Exec("INSERT INTO blocks (user_id, blocked_id) VALUES (?, ?) ON CONFLICT DO NOTHING", userID, blockedID); err != nil {
+		return fmt.Errorf("sqlite: failed to block user: %v", err)
+	}
+	return nil
+}
+
+// Unblock removes a user from the users blocked by another.
+func (db *DB) Unblock(userID, blockedID string) error {
+	if _, err := db.DB.Exec("DELETE FROM blocks WHERE user_id = ? AND blocked_id = ?", userID, blockedID); err != nil {
+		return fmt.Errorf("sqlite: failed to unblock user: %v", err)
+	}
+	return nil
+}
+
+// GetBlocked retrieves the IDs of the users blocked by a user, in ascending order.
+func (db *DB) GetBlocked(userID string) ([]string, error) {
+	return db.getStrings("blocked users", "SELECT blocked_id FROM blocks WHERE user_id = ? ORDER BY blocked_id", userID)
+}
+
+// IsBlocked tells whether a user is blocked by another.
+func (db *DB) IsBlocked(userID, blockedID string) (bool, error) {
+	return db.exists("blocked user", "SELECT EXISTS (SELECT 1 FROM blocks WHERE user_id = ? AND blocked_id = ?)", userID, blockedID)
+}
+
+// Mute adds a conversation to the conversations muted by a user.
+func (db *DB) Mute(userID, conversation string) error {
+	if _, err := db.DB.Exec("INSERT INTO mutes (user_id, conversation) VALUES (?, ?) ON CONFLICT DO NOTHING", userID, conversation); err != nil {
+		return fmt.Errorf("sqlite: failed to mute conversation: %v", err)
+	}
+	return nil
+}
+
+// Unmute removes a conversation from the conversations muted by a user.
+func (db *DB) Unmute(userID, conversation string) error {
+	if _, err := db.DB.Exec("DELETE FROM mutes WHERE user_id = ? AND conversation = ?", userID, conversation); err != nil {
+		return fmt.Errorf("sqlite: failed to unmute conversation: %v", err)
+	}
+	return nil
+}
+
+// GetMuted retrieves the IDs of the conversations muted by a user, in ascending order.
+func (db *DB) GetMuted(userID string) ([]string, error) {
+	return db.getStrings("muted conversations", "SELECT conversation FROM mutes WHERE user_id = ? ORDER BY conversation", userID)
+}
+
+// IsMuted tells whether a conversation is muted by a user.
+func (db *DB) IsMuted(userID, conversation string) (bool, error) {
+	return db.exists("muted conversation", "SELECT EXISTS (SELECT 1 FROM mutes WHERE user_id = ? AND conversation = ?)", userID, conversation)
+}
+
+// GetVerification retrieves the pending verification of the given kind for an address.
+func (db *DB) GetVerification(kind, target string) (v *models.Verification, ok bool, err error) {
+	var vr models.Verification
+	err = db.DB.QueryRow("SELECT kind, target, user_id, code_hash, sent, expires, attempts FROM verifications WHERE kind = ? AND target = ?", kind, target).
+		Scan(&vr.Kind, &vr.Target, &vr.UserID, &vr.CodeHash, unixTime{&vr.Sent}, unixTime{&vr.Expires}, &vr.Attempts)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("sqlite: failed to get verification: %v", err)
+	}
+
+	return &vr, true, nil
+}
+
+// SaveVerification creates or replaces the pending verification of an address.
+func (db *DB) SaveVerification(v *models.Verification) error {
+	_, err := db.DB.Exec(`INSERT INTO verifications (kind, target, user_id, code_hash, sent, expires, attempts) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (kind, target) DO UPDATE SET
+			user_id = excluded.user_id, code_hash = excluded.code_hash, sent = excluded.sent, expires = excluded.expires, attempts = excluded.attempts`,
+		v.Kind, v.Target, v.UserID, v.CodeHash, unixTime{&v.Sent}, unixTime{&v.Expires}, v.Attempts)
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to save verification: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteVerification deletes the pending verification of an address, if any.
+func (db *DB) DeleteVerification(kind, target string) error {
+	if _, err := db.DB.Exec("DELETE FROM verifications WHERE kind = ? AND target = ?", kind, target); err != nil {
+		return fmt.Errorf("sqlite: failed to delete verification: %v", err)
+	}
+	return nil
+}
+
+// GetTOTP retrieves the second factor of a user.
+func (db *DB) GetTOTP(userID string) (t *models.TOTP, ok bool, err error) {
+	var tt models.TOTP
+	err = db.DB.QueryRow("SELECT user_id, secret, confirmed, recovery_codes, last_step FROM totp WHERE user_id = ?", userID).
+		Scan(&tt.UserID, &tt.Secret, &tt.Confirmed, jsonValue{&tt.RecoveryCodes}, &tt.LastStep)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("sqlite: failed to get totp: %v", err)
+	}
+
+	return &tt, true, nil
+}
+
+// SaveTOTP creates or replaces the second factor of a user.
+func (db *DB) SaveTOTP(t *models.TOTP) error {
+	_, err := db.DB.Exec(`INSERT INTO totp (user_id, secret, confirmed, recovery_codes, last_step) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret = excluded.secret, confirmed = excluded.confirmed, recovery_codes = excluded.recovery_codes, last_step = excluded.last_step`,
+		t.UserID, t.Secret, t.Confirmed, jsonValue{&t.RecoveryCodes}, t.LastStep)
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to save totp: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteTOTP deletes the second factor of a user, if any.
+func (db *DB) DeleteTOTP(userID string) error {
+	if _, err := db.DB.Exec("DELETE FROM totp WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("sqlite: failed to delete totp: %v", err)
+	}
+	return nil
+}
+
+// exists retrieves the single boolean column of the row returned by the given query.
+func (db *DB) exists(what, query string, args ...interface{}) (bool, error) {
+	var ok bool
+	if err := db.DB.QueryRow(query, args...).Scan(&ok); err != nil {
+		return false, fmt.Errorf("sqlite: failed to get %v: %v", what, err)
+	}
+	return ok, nil
+}
+
+// getStrings retrieves the single text column of the rows returned by the given query.
+func (db *DB) getStrings(what, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: failed to get %v: %v", what, err)
+	}
+	defer rows.Close()
+
+	ss := []string{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, fmt.Errorf("sqlite: failed to read %v: %v", what, err)
+		}
+		ss = append(ss, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: failed to read %v: %v", what, err)
+	}
+
+	return ss, nil
+}
+
+// Ping verifies that the database file is accessible.
+func (db *DB) Ping() error {
+	if err := db.DB.Ping(); err != nil {
+		return fmt.Errorf("sqlite: failed to open database: %v", err)
+	}
+	return nil
+}
+
+// Close closes the database.
+func (db *DB) Close() error {
+	return db.DB.Close()
+}
+
+func (db *DB) getUser(query string, arg string) (*models.User, bool) {
+	var u models.User
+	err := db.DB.QueryRow(query, arg).Scan(&u.ID, unixTime{&u.Registered}, &u.Email, &u.PhoneNumber, &u.GCMRegID, &u.APNSDeviceToken, &u.Name, &u.Picture, &u.JWTToken, &u.Status, &u.Avatar, unixTime{&u.DeleteAt}, &u.Unverified, &u.Role)
+	if err == sql.ErrNoRows {
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("get user error: %v", err)
+		return nil, false
+	}
+
+	return &u, true
+}
+
+// execTx executes the given statements with the same arguments in a single transaction.
+func (db *DB) execTx(what string, stmts []string, args ...interface{}) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to %v: %v", what, err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			return fmt.Errorf("sqlite: failed to %v: %v", what, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite: failed to %v: %v", what, err)
+	}
+	return nil
+}
+
+// nanos returns the given time as Unix nanoseconds, for comparing with the stored times in the queries.
+func nanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// unixTime stores a time as Unix nanoseconds, or NULL if it is the zero time.
+type unixTime struct {
+	t *time.Time
+}
+
+func (u unixTime) Value() (driver.Value, error) {
+	if u.t.IsZero() {
+		return nil, nil
+	}
+	return u.t.UnixNano(), nil
+}
+
+func (u unixTime) Scan(src interface{}) error {
+	*u.t = time.Time{}
+	if n, ok := src.(int64); ok {
+		*u.t = time.Unix(0, n)
+	}
+	return nil
+}
+
+func msgFields(m *models.Message) []interface{} {
+	return []interface{}{&m.ID, &m.Conversation, &m.From, &m.To, &m.Group, &m.Message, jsonValue{&m.Attachment}, unixTime{&m.Time}, &m.State, &m.Encrypted}
+}
+
+func attFields(a *models.Attachment) []interface{} {
+	return []interface{}{&a.ID, &a.Owner, &a.Size, &a.MimeType, &a.Checksum, &a.Uploaded, jsonValue{&a.Conversations}, jsonValue{&a.Thumbnails}, unixTime{&a.Created}}
+}
+
+// jsonValue stores the value pointed to as JSON, i.e. the lists and the nested objects which SQLite has no column types for.
+// Nil pointers and slices are stored as JSON null, which is read back as nil.
+type jsonValue struct {
+	v interface{}
+}
+
+func (j jsonValue) Value() (driver.Value, error) {
+	b, err := json.Marshal(j.v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (j jsonValue) Scan(src interface{}) error {
+	var b []byte
+	switch s := src.(type) {
+	case []byte:
+		b = s
+	case string:
+		b = []byte(s)
+	default:
+		return nil
+	}
+
+	if err := json.Unmarshal(b, j.v); err != nil {
+		return fmt.Errorf("sqlite: failed to read json column: %v", err)
+	}
+	return nil
+}
+
+// nullBytes converts nil byte slices to SQL NULL, as they are otherwise stored as empty values.
+func nullBytes(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+	return b
+}
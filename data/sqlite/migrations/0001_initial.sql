@@ -0,0 +1,133 @@
+-- Times are stored as Unix nanoseconds (see unixTime) so they compare correctly in the queries regardless of the time zone.
+
+CREATE TABLE IF NOT EXISTS users (
+    id                TEXT PRIMARY KEY,
+    registered        INTEGER,
+    email             TEXT NOT NULL DEFAULT '',
+    phone_number      TEXT NOT NULL DEFAULT '',
+    gcm_reg_id        TEXT NOT NULL DEFAULT '',
+    apns_device_token TEXT NOT NULL DEFAULT '',
+    name              TEXT NOT NULL DEFAULT '',
+    picture           BLOB,
+    jwt_token         TEXT NOT NULL DEFAULT '',
+    status            TEXT NOT NULL DEFAULT '',
+    avatar            TEXT NOT NULL DEFAULT '',
+    delete_at         INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS users_email_idx ON users (email);
+
+CREATE INDEX IF NOT EXISTS users_delete_at_idx ON users (delete_at);
+
+CREATE TABLE IF NOT EXISTS groups (
+    id      TEXT PRIMARY KEY,
+    name    TEXT NOT NULL DEFAULT '',
+    owner   TEXT NOT NULL DEFAULT '',
+    members TEXT NOT NULL,
+    created INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id      TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    device  TEXT NOT NULL DEFAULT '',
+    created INTEGER,
+    revoked BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_idx ON refresh_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS messages (
+    seq          INTEGER PRIMARY KEY AUTOINCREMENT,
+    id           TEXT NOT NULL UNIQUE,
+    conversation TEXT NOT NULL,
+    sender       TEXT NOT NULL DEFAULT '',
+    recipient    TEXT NOT NULL DEFAULT '',
+    group_id     TEXT NOT NULL DEFAULT '',
+    body         TEXT NOT NULL DEFAULT '',
+    attachment   TEXT,
+    time         INTEGER NOT NULL,
+    state        TEXT NOT NULL DEFAULT '',
+    encrypted    BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS messages_conversation_idx ON messages (conversation, seq);
+
+CREATE INDEX IF NOT EXISTS messages_sender_idx ON messages (sender);
+
+CREATE INDEX IF NOT EXISTS messages_time_idx ON messages (time);
+
+CREATE TABLE IF NOT EXISTS message_terms (
+    user_id     TEXT NOT NULL,
+    word        TEXT NOT NULL,
+    message_id  TEXT NOT NULL,
+    occurrences INTEGER NOT NULL,
+    PRIMARY KEY (user_id, word, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_terms_message_idx ON message_terms (message_id);
+
+CREATE TABLE IF NOT EXISTS identity_keys (
+    user_id                 TEXT PRIMARY KEY,
+    identity_key            BLOB,
+    signed_prekey_id        INTEGER NOT NULL DEFAULT 0,
+    signed_prekey           BLOB,
+    signed_prekey_signature BLOB
+);
+
+CREATE TABLE IF NOT EXISTS prekeys (
+    seq     INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT NOT NULL,
+    id      INTEGER NOT NULL,
+    key     BLOB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS prekeys_user_idx ON prekeys (user_id, seq);
+
+CREATE TABLE IF NOT EXISTS attachments (
+    id            TEXT PRIMARY KEY,
+    owner         TEXT NOT NULL,
+    size          INTEGER NOT NULL,
+    mime_type     TEXT NOT NULL DEFAULT '',
+    checksum      TEXT NOT NULL,
+    uploaded      BOOLEAN NOT NULL DEFAULT FALSE,
+    conversations TEXT NOT NULL,
+    thumbnails    TEXT,
+    created       INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS attachments_owner_idx ON attachments (owner);
+
+CREATE TABLE IF NOT EXISTS topics (
+    name        TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    created     INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS topic_subscribers (
+    topic   TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    PRIMARY KEY (topic, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS topic_subscribers_user_idx ON topic_subscribers (user_id);
+
+CREATE TABLE IF NOT EXISTS contacts (
+    user_id    TEXT NOT NULL,
+    contact_id TEXT NOT NULL,
+    PRIMARY KEY (user_id, contact_id)
+);
+
+CREATE INDEX IF NOT EXISTS contacts_contact_idx ON contacts (contact_id);
+
+CREATE TABLE IF NOT EXISTS blocks (
+    user_id    TEXT NOT NULL,
+    blocked_id TEXT NOT NULL,
+    PRIMARY KEY (user_id, blocked_id)
+);
+
+CREATE TABLE IF NOT EXISTS mutes (
+    user_id      TEXT NOT NULL,
+    conversation TEXT NOT NULL,
+    PRIMARY KEY (user_id, conversation)
+);
@@ -0,0 +1,14 @@
+-- E-mail verification of the accounts registered without Google Sign-In.
+
+ALTER TABLE users ADD COLUMN unverified BOOLEAN NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS verifications (
+    kind      TEXT NOT NULL,
+    target    TEXT NOT NULL,
+    user_id   TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    sent      INTEGER,
+    expires   INTEGER,
+    attempts  INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (kind, target)
+);
@@ -0,0 +1,3 @@
+-- Lookup of the users by their verified phone numbers, for contact discovery.
+
+CREATE INDEX IF NOT EXISTS users_phone_number ON users (phone_number);
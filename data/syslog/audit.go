@@ -0,0 +1,54 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+// Package syslog provides syslog backed implementation of data interfaces.
+package syslog
+
+import (
+	"encoding/json"
+	"fmt"
+	stdsyslog "log/syslog"
+
+	"github.com/titan-x/titan/models"
+)
+
+// AuditLog is a syslog backed audit log, for shipping the audit log to a central log server along with the other system logs.
+// Entries are written as JSON objects with auth facility, and failed actions with warning severity.
+// Entries cannot be queried back through the server.
+type AuditLog struct {
+	w *stdsyslog.Writer
+}
+
+// NewAuditLog connects to the syslog server at the given network address (i.e. udp, localhost:514),
+// or to the local syslog server if the network is empty.
+func NewAuditLog(network, addr string) (*AuditLog, error) {
+	w, err := stdsyslog.Dial(network, addr, stdsyslog.LOG_AUTH|stdsyslog.LOG_INFO, "titan")
+	if err != nil {
+		return nil, fmt.Errorf("syslog: audit log: failed to connect: %v", err)
+	}
+
+	return &AuditLog{w: w}, nil
+}
+
+// AppendAudit writes an entry to syslog.
+func (l *AuditLog) AppendAudit(e *models.AuditEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("syslog: audit log: failed to serialize entry %v: %v", e.ID, err)
+	}
+
+	if e.Success {
+		err = l.w.Info(string(b))
+	} else {
+		err = l.w.Warning(string(b))
+	}
+	if err != nil {
+		return fmt.Errorf("syslog: audit log: failed to write entry %v: %v", e.ID, err)
+	}
+	return nil
+}
+
+// Close closes the connection to the syslog server.
+func (l *AuditLog) Close() error {
+	return l.w.Close()
+}
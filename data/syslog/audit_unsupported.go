@@ -0,0 +1,29 @@
+//go:build windows || plan9
+// +build windows plan9
+
+// Package syslog provides syslog backed implementation of data interfaces.
+package syslog
+
+import (
+	"errors"
+
+	"github.com/titan-x/titan/models"
+)
+
+// AuditLog is a syslog backed audit log, which is not supported on this platform.
+type AuditLog struct{}
+
+// NewAuditLog returns an error as syslog is not supported on this platform.
+func NewAuditLog(network, addr string) (*AuditLog, error) {
+	return nil, errors.New("syslog: audit log: syslog is not supported on this platform")
+}
+
+// AppendAudit is not supported on this platform.
+func (l *AuditLog) AppendAudit(e *models.AuditEntry) error {
+	return errors.New("syslog: audit log: syslog is not supported on this platform")
+}
+
+// Close is a no-op on this platform.
+func (l *AuditLog) Close() error {
+	return nil
+}
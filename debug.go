@@ -0,0 +1,131 @@
+package titan
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rdebug "runtime/debug"
+	rpprof "runtime/pprof"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+)
+
+var debugLog = log.Component("debug")
+
+// gcRes is the response of the GC stats endpoint.
+type gcRes struct {
+	NumGC          int64           `json:"numGC"`
+	LastGC         time.Time       `json:"lastGC"`
+	PauseTotal     time.Duration   `json:"pauseTotal"`
+	RecentPauses   []time.Duration `json:"recentPauses"` // most recent first
+	HeapAlloc      uint64          `json:"heapAlloc"`
+	HeapObjects    uint64          `json:"heapObjects"`
+	HeapSys        uint64          `json:"heapSys"`
+	NextGC         uint64          `json:"nextGC"`
+	GCCPUFraction  float64         `json:"gcCPUFraction"`
+	NumGoroutine   int             `json:"numGoroutine"`
+	GOMAXPROCS     int             `json:"gomaxprocs"`
+	PauseQuantiles []time.Duration `json:"pauseQuantiles"` // min, 25%, 50%, 75%, max
+}
+
+// DebugHandler returns the HTTP handler serving the runtime debug endpoints, for diagnosing the server in production:
+//
+//	/debug/pprof/:     CPU, heap, goroutine, block, and mutex profiles and execution traces of net/http/pprof,
+//	                   i.e. /debug/pprof/profile?seconds=30 for a CPU profile to be viewed with go tool pprof
+//	/debug/goroutines: Stack traces of all the goroutines, in plain text.
+//	/debug/gc:         GC stats and heap usage, in JSON.
+//	/debug/vars:       Metrics exposed with expvar, in JSON.
+//
+// Requests are authenticated with the JWT tokens of the admin users in "Authorization: Bearer <token>" header,
+// and are recorded to the audit log, including the unauthorized ones.
+func (s *Server) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rpprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+	mux.HandleFunc("/debug/gc", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(readGCStats())
+	})
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := models.AuditEntry{Action: models.AuditDebug, RemoteAddr: r.RemoteAddr, Details: map[string]string{"path": r.URL.Path}}
+		if r.URL.RawQuery != "" {
+			e.Details["query"] = r.URL.RawQuery
+		}
+
+		t := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		claims, userID, err := verifyJWT(s.jwtKeys, s.db, t)
+		e.UserID = userID
+		if err != nil || !hasRole(claimRole(claims), models.RoleAdmin) {
+			e.Details["reason"] = "unauthorized"
+			s.audit.record(nil, e)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		e.Success = true
+		s.audit.record(nil, e)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// listenDebug starts serving the runtime debug endpoints at the given network address, in a separate goroutine.
+func (s *Server) listenDebug(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("server: failed to create debug listener on network address %v: %v", addr, err)
+	}
+	s.debugListener = l
+
+	go func() {
+		// no write timeout as CPU profiles and execution traces are streamed for as long as requested
+		srv := &http.Server{Handler: s.DebugHandler(), ReadHeaderTimeout: time.Second * 10}
+		if err := srv.Serve(l); err != nil && atomic.LoadInt32(&s.listening) == 1 {
+			debugLog.Errorf("listener stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+func readGCStats() gcRes {
+	gc := rdebug.GCStats{PauseQuantiles: make([]time.Duration, 5)}
+	rdebug.ReadGCStats(&gc)
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	pauses := gc.Pause
+	if len(pauses) > 20 {
+		pauses = pauses[:20]
+	}
+	return gcRes{
+		NumGC:          gc.NumGC,
+		LastGC:         gc.LastGC,
+		PauseTotal:     gc.PauseTotal,
+		RecentPauses:   pauses,
+		HeapAlloc:      mem.HeapAlloc,
+		HeapObjects:    mem.HeapObjects,
+		HeapSys:        mem.HeapSys,
+		NextGC:         mem.NextGC,
+		GCCPUFraction:  mem.GCCPUFraction,
+		NumGoroutine:   runtime.NumGoroutine(),
+		GOMAXPROCS:     runtime.GOMAXPROCS(0),
+		PauseQuantiles: gc.PauseQuantiles,
+	}
+}
@@ -0,0 +1,100 @@
+package titan
+
+import (
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
+)
+
+var dedupeLog = log.Component("dedupe")
+
+// dedupeTTL is how long the client supplied message IDs are remembered for deduplicating the retries of msg.send.
+const dedupeTTL = time.Hour * 24
+
+// maxClientIDLen is the maximum length of a client supplied message ID.
+const maxClientIDLen = 128
+
+// dedupe maps the client supplied message IDs to the server generated ones, so the messages retried by the clients
+// (i.e. after reconnecting without receiving the msg.send response) are not sent again.
+// IDs are remembered for dedupeTTL. With a shared store (i.e. the Redis queue store), they are remembered by all the server
+// instances, so the retries are deduplicated even if the client reconnects to another instance. Otherwise, they are only
+// remembered by the server instance the message is sent through, and a retry through another instance is sent again.
+// If the shared store fails, the local mapping is used instead.
+type dedupe struct {
+	mutex       sync.Mutex
+	store       data.DedupeStore
+	ids         map[dedupeKey]dedupeID
+	lastCleanup time.Time
+}
+
+type dedupeKey struct {
+	userID, clientID string
+}
+
+type dedupeID struct {
+	id      string
+	expires time.Time
+}
+
+func newDedupe() *dedupe {
+	return &dedupe{ids: make(map[dedupeKey]dedupeID), lastCleanup: time.Now()}
+}
+
+// setStore sets the store shared by the server instances to keep the client supplied message IDs in.
+func (d *dedupe) setStore(store data.DedupeStore) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.store = store
+}
+
+// reserve maps the given client supplied message ID of a user to the given server generated message ID,
+// unless it is already mapped, in which case the existing server generated ID is returned with dup = true.
+func (d *dedupe) reserve(userID, clientID, id string) (existing string, dup bool) {
+	d.mutex.Lock()
+	store := d.store
+	d.mutex.Unlock()
+	if store != nil {
+		existing, dup, err := store.Reserve(userID, clientID, id, dedupeTTL)
+		if err == nil {
+			return existing, dup
+		}
+		dedupeLog.Errorf("falling back to local deduplication: %v", err)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(d.lastCleanup) > time.Minute {
+		for k, v := range d.ids {
+			if now.After(v.expires) {
+				delete(d.ids, k)
+			}
+		}
+		d.lastCleanup = now
+	}
+
+	k := dedupeKey{userID: userID, clientID: clientID}
+	if v, ok := d.ids[k]; ok && now.Before(v.expires) {
+		return v.id, true
+	}
+
+	d.ids[k] = dedupeID{id: id, expires: now.Add(dedupeTTL)}
+	return id, false
+}
+
+// release removes the mapping of a client supplied message ID, i.e. when the message failed to be sent so it can be retried.
+func (d *dedupe) release(userID, clientID string) {
+	d.mutex.Lock()
+	delete(d.ids, dedupeKey{userID: userID, clientID: clientID})
+	store := d.store
+	d.mutex.Unlock()
+
+	if store != nil {
+		if err := store.Release(userID, clientID); err != nil {
+			dedupeLog.Errorf("%v", err)
+		}
+	}
+}
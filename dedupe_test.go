@@ -0,0 +1,57 @@
+package titan
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// sharedDedupeStore is a data.DedupeStore shared by the dedupe instances of multiple servers, which fails if down is set.
+type sharedDedupeStore struct {
+	ids  map[string]string
+	down bool
+}
+
+func (s *sharedDedupeStore) Reserve(userID, clientID, id string, ttl time.Duration) (string, bool, error) {
+	if s.down {
+		return "", false, errors.New("store is down")
+	}
+	if existing, ok := s.ids[userID+":"+clientID]; ok {
+		return existing, true, nil
+	}
+	s.ids[userID+":"+clientID] = id
+	return id, false, nil
+}
+
+func (s *sharedDedupeStore) Release(userID, clientID string) error {
+	delete(s.ids, userID+":"+clientID)
+	return nil
+}
+
+func TestDedupeSharedStore(t *testing.T) {
+	store := &sharedDedupeStore{ids: make(map[string]string)}
+	d1, d2 := newDedupe(), newDedupe()
+	d1.setStore(store)
+	d2.setStore(store)
+
+	if id, dup := d1.reserve("1", "c1", "m1"); dup || id != "m1" {
+		t.Fatalf("expected client ID to be reserved, got: %v, %v", id, dup)
+	}
+	if id, dup := d2.reserve("1", "c1", "m2"); !dup || id != "m1" {
+		t.Fatalf("expected retry through another instance to be deduplicated, got: %v, %v", id, dup)
+	}
+
+	d2.release("1", "c1")
+	if id, dup := d1.reserve("1", "c1", "m3"); dup || id != "m3" {
+		t.Fatalf("expected released client ID to be reserved again, got: %v, %v", id, dup)
+	}
+
+	// local mapping is used while the store is down
+	store.down = true
+	if _, dup := d1.reserve("1", "c2", "m4"); dup {
+		t.Fatal("expected client ID to be reserved locally")
+	}
+	if id, dup := d1.reserve("1", "c2", "m5"); !dup || id != "m4" {
+		t.Fatalf("expected local mapping, got: %v, %v", id, dup)
+	}
+}
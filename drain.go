@@ -0,0 +1,73 @@
+package titan
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+var drainLog = log.Component("drain")
+
+// Drain gracefully takes the server out of rotation, i.e. before a deploy or a scale down. Readiness check starts failing so
+// the load balancers stop routing new connections to the server, and new authentication attempts are refused. Connected clients
+// are sent a system notice with the given message, if any, and disconnected evenly over the given period, so they reconnect to
+// the other servers without all arriving at once. Requests that are not yet delivered stay in the queues. Connections of the admin
+// users are left open, so the drain can be followed with admin.stats. Drain returns once all the other connections are closed,
+// or right away if the server is already draining.
+func (s *Server) Drain(period time.Duration, message string) {
+	if !atomic.CompareAndSwapInt32(&s.draining, 0, 1) {
+		return
+	}
+
+	var conns []*neptulon.Conn
+	for _, c := range s.presence.Conns() {
+		if !hasRole(connRole(c), models.RoleAdmin) {
+			conns = append(conns, c)
+		}
+	}
+	drainLog.Infof("draining %v connections over %v", len(conns), period)
+	if message != "" {
+		n := models.Notice{Message: message, Time: time.Now()}
+		for _, uid := range s.presence.OnlineUsers() {
+			if err := s.queue.AddRequest(uid, "sys.notice", n, ignoreResHandler); err != nil {
+				drainLog.Warnf("drain notice to user %v is discarded: %v", uid, err)
+			}
+		}
+	}
+
+	var interval time.Duration
+	if len(conns) != 0 {
+		interval = period / time.Duration(len(conns))
+	}
+	for _, c := range conns {
+		time.Sleep(interval)
+		if atomic.LoadInt32(&s.listening) == 0 {
+			return
+		}
+		if err := c.Close(); err != nil {
+			drainLog.Warnf("failed to close connection %v: %v", c.ID, err)
+		}
+	}
+	drainLog.Infof("drained all connections")
+}
+
+// Draining returns true if the server is draining, or is drained.
+func (s *Server) Draining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// refuseDraining is a middleware refusing the requests of the connections which are not authenticated yet, while the server is
+// draining, so the clients connect to the other servers instead.
+func (s *Server) refuseDraining(ctx *neptulon.ReqCtx) error {
+	if s.Draining() {
+		if _, ok := ctx.Conn.Session.GetOk("userid"); !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Server is draining, please reconnect."}
+			return nil
+		}
+	}
+
+	return ctx.Next()
+}
@@ -0,0 +1,41 @@
+package titan
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+)
+
+var eventLog = log.Component("events")
+
+// events publishes the server events through the event bus and to the outgoing webhooks, if any.
+// Publish failures are only logged as events are informational and must not interrupt message delivery.
+type events struct {
+	bus   data.EventBus
+	hooks *hooks
+}
+
+func (ev *events) publish(eventType, userID string, v interface{}) {
+	if ev.bus == nil && (ev.hooks == nil || ev.hooks.empty()) {
+		return
+	}
+
+	d, err := json.Marshal(v)
+	if err != nil {
+		eventLog.Errorf("failed to serialize %v event data for user %v: %v", eventType, userID, err)
+		return
+	}
+
+	e := &models.Event{Type: eventType, UserID: userID, Time: time.Now(), Data: d}
+	if ev.hooks != nil && !ev.hooks.empty() {
+		ev.hooks.send(e)
+	}
+	if ev.bus != nil {
+		if err := ev.bus.Publish(e); err != nil {
+			eventLog.Errorf("failed to publish %v event for user %v: %v", eventType, userID, err)
+		}
+	}
+}
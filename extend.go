@@ -0,0 +1,79 @@
+package titan
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/titan-x/titan/neptulon"
+)
+
+// errChained is returned when the server is extended after the middleware chain is built, which happens once the server starts listening.
+var errChained = errors.New("server: middleware and routes should be registered before listening")
+
+// MiddlewareBeforeAuth registers custom middleware (i.e. request filtering or metrics) to handle the incoming requests before the
+// authentication, after the built-in logging and tracing middleware. Middleware run in the order they are registered, for both the public
+// and the authenticated requests, and should call ctx.Next() to pass the request on. Middleware should be registered before listening.
+func (s *Server) MiddlewareBeforeAuth(middleware ...func(ctx *neptulon.ReqCtx) error) error {
+	if atomic.LoadInt32(&s.chained) == 1 {
+		return errChained
+	}
+	s.beforeAuth = append(s.beforeAuth, middleware...)
+	return nil
+}
+
+// MiddlewareAfterAuth registers custom middleware to handle the authenticated requests, right before the private routes.
+// The ID of the authenticated user is available as the "userid" value of the connection session.
+// Middleware should be registered before listening.
+func (s *Server) MiddlewareAfterAuth(middleware ...func(ctx *neptulon.ReqCtx) error) error {
+	if atomic.LoadInt32(&s.chained) == 1 {
+		return errChained
+	}
+	s.afterAuth = append(s.afterAuth, middleware...)
+	return nil
+}
+
+// PublicRoute registers a route which does not require authentication, replacing the built-in route of the same method, if any.
+// Public route handlers should not call ctx.Next(), so the requests do not reach the authentication middleware.
+// Routes should be registered before listening.
+func (s *Server) PublicRoute(method string, handler func(ctx *neptulon.ReqCtx) error) error {
+	if atomic.LoadInt32(&s.chained) == 1 {
+		return errChained
+	}
+	s.pubRouter.Request(method, handler)
+	return nil
+}
+
+// PrivateRoute registers a route for the authenticated connections, replacing the built-in route of the same method, if any.
+// Routes should be registered before listening.
+func (s *Server) PrivateRoute(method string, handler func(ctx *neptulon.ReqCtx) error) error {
+	if atomic.LoadInt32(&s.chained) == 1 {
+		return errChained
+	}
+	s.privRouter.Request(method, handler)
+	return nil
+}
+
+// initMiddleware builds the middleware chain of the server along with the custom middleware, once.
+func (s *Server) initMiddleware() {
+	if !atomic.CompareAndSwapInt32(&s.chained, 0, 1) {
+		return
+	}
+
+	s.neptulon.MiddlewareFunc(logRequest)
+	s.neptulon.MiddlewareFunc(traceRequest)
+	s.neptulon.MiddlewareFunc(s.recordRoute)
+	s.neptulon.MiddlewareFunc(s.refuseDraining)
+	s.neptulon.MiddlewareFunc(s.beforeAuth...)
+	s.neptulon.Middleware(s.pubRouter)
+
+	//all communication below this point is authenticated
+	s.neptulon.MiddlewareFunc(certAuth(s.audit))
+	s.neptulon.MiddlewareFunc(jwtAuth(s.jwtKeys, &s.db, s.audit))
+	s.neptulon.MiddlewareFunc(totpAuth(s.twoFactor, s.audit))
+	s.neptulon.Middleware(s.limiter)
+	s.neptulon.Middleware(s.presence)
+	s.neptulon.Middleware(s.queue)
+	s.neptulon.MiddlewareFunc(s.afterAuth...)
+	s.neptulon.Middleware(s.privRouter)
+	// todo: r.Middleware(NotFoundHandler()) - 404-like handler, if any request reaches this point without being handled
+}
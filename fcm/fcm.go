@@ -0,0 +1,188 @@
+// Package fcm provides Firebase Cloud Messaging (FCM) HTTP v1 API client implementation.
+// https://firebase.google.com/docs/reference/fcm/rest/v1/projects.messages
+package fcm
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	fcmScope    = "https://www.googleapis.com/auth/firebase.messaging"
+	fcmSendURL  = "https://fcm.googleapis.com/v1/projects/%v/messages:send"
+	googleToken = "https://oauth2.googleapis.com/token"
+	jwtGrant    = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+)
+
+// Client is an FCM HTTP v1 API client authenticated with a Google service account.
+type Client struct {
+	ProjectID  string
+	TokenURL   string       // OAuth2 token endpoint. Defaults to the one given in the service account credentials.
+	SendURL    string       // Message send endpoint. Defaults to FCM HTTP v1 messages:send endpoint for the project.
+	HTTPClient *http.Client // HTTP client to make the API calls with. Defaults to http.DefaultClient.
+
+	clientEmail string
+	privateKey  *rsa.PrivateKey
+
+	mutex       sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+// Service account credentials file as downloaded from Firebase/Google Cloud console.
+type credentials struct {
+	Type        string `json:"type"`
+	ProjectID   string `json:"project_id"`
+	PrivateKey  string `json:"private_key"`
+	ClientEmail string `json:"client_email"`
+	TokenURI    string `json:"token_uri"`
+}
+
+type tokenRes struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type sendReq struct {
+	Message *Message `json:"message"`
+}
+
+type sendRes struct {
+	Name string `json:"name"`
+}
+
+type errorRes struct {
+	Error *Error `json:"error"`
+}
+
+// NewClient creates a new FCM client using the given service account credentials JSON.
+func NewClient(credentialsJSON []byte) (*Client, error) {
+	var cred credentials
+	if err := json.Unmarshal(credentialsJSON, &cred); err != nil {
+		return nil, fmt.Errorf("fcm: failed to deserialize service account credentials: %v", err)
+	}
+	if cred.Type != "service_account" || cred.ProjectID == "" || cred.ClientEmail == "" {
+		return nil, fmt.Errorf("fcm: given credentials are not a valid service account credentials: type: %v, project: %v", cred.Type, cred.ProjectID)
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(cred.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("fcm: failed to parse service account private key: %v", err)
+	}
+
+	tokenURL := cred.TokenURI
+	if tokenURL == "" {
+		tokenURL = googleToken
+	}
+
+	return &Client{
+		ProjectID:   cred.ProjectID,
+		TokenURL:    tokenURL,
+		SendURL:     fmt.Sprintf(fcmSendURL, cred.ProjectID),
+		HTTPClient:  http.DefaultClient,
+		clientEmail: cred.ClientEmail,
+		privateKey:  key,
+	}, nil
+}
+
+// Send sends a message to FCM and returns the message name (ID) assigned by FCM.
+func (c *Client) Send(m *Message) (name string, err error) {
+	token, err := c.token()
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(sendReq{Message: m})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", c.SendURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fcm: failed to call send api: %v", err)
+	}
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("fcm: failed to read send api response: %v", err)
+	}
+
+	if res.StatusCode >= http.StatusBadRequest {
+		var er errorRes
+		if err := json.Unmarshal(resBody, &er); err != nil || er.Error == nil {
+			return "", &Error{Code: res.StatusCode, Message: string(resBody)}
+		}
+		return "", er.Error
+	}
+
+	var sr sendRes
+	if err := json.Unmarshal(resBody, &sr); err != nil {
+		return "", fmt.Errorf("fcm: failed to deserialize send api response: %v", err)
+	}
+
+	return sr.Name, nil
+}
+
+// token returns a cached OAuth2 access token, or retrieves a new one if it is missing or about to expire.
+func (c *Client) token() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if c.accessToken != "" && now.Add(time.Minute).Before(c.expiry) {
+		return c.accessToken, nil
+	}
+
+	t := jwt.New(jwt.SigningMethodRS256)
+	t.Claims["iss"] = c.clientEmail
+	t.Claims["scope"] = fcmScope
+	t.Claims["aud"] = c.TokenURL
+	t.Claims["iat"] = now.Unix()
+	t.Claims["exp"] = now.Add(time.Hour).Unix()
+	assertion, err := t.SignedString(c.privateKey)
+	if err != nil {
+		return "", fmt.Errorf("fcm: failed to sign oauth2 assertion: %v", err)
+	}
+
+	form := url.Values{"grant_type": {jwtGrant}, "assertion": {assertion}}
+	res, err := c.HTTPClient.Post(c.TokenURL, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("fcm: failed to call oauth2 token api: %v", err)
+	}
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("fcm: failed to read oauth2 token api response: %v", err)
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		return "", fmt.Errorf("fcm: oauth2 token api returned status: %v, response: %s", res.StatusCode, resBody)
+	}
+
+	var tr tokenRes
+	if err := json.Unmarshal(resBody, &tr); err != nil || tr.AccessToken == "" {
+		return "", fmt.Errorf("fcm: failed to deserialize oauth2 token api response: %v", err)
+	}
+
+	c.accessToken = tr.AccessToken
+	c.expiry = now.Add(time.Second * time.Duration(tr.ExpiresIn))
+	return c.accessToken, nil
+}
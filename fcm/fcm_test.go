@@ -0,0 +1,115 @@
+package fcm
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestClient(t *testing.T, h http.Handler) (*Client, *httptest.Server) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	s := httptest.NewServer(h)
+	cred, _ := json.Marshal(credentials{
+		Type:        "service_account",
+		ProjectID:   "titan-test",
+		PrivateKey:  string(keyPEM),
+		ClientEmail: "titan@titan-test.iam.gserviceaccount.com",
+		TokenURI:    s.URL + "/token",
+	})
+
+	c, err := NewClient(cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SendURL = s.URL + "/send"
+	return c, s
+}
+
+func TestSend(t *testing.T) {
+	tokenCalls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenCalls++
+		if r.FormValue("grant_type") != jwtGrant || r.FormValue("assertion") == "" {
+			t.Fatalf("unexpected token request: %v", r.Form)
+		}
+		w.Write([]byte(`{"access_token":"abc","expires_in":3600}`))
+	})
+	mux.HandleFunc("/send", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer abc" {
+			t.Fatalf("expected bearer token, got: %v", r.Header.Get("Authorization"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var req sendReq
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Message.Token != "device-1" || req.Message.Data["message"] != "hi" || req.Message.Notification.Title != "Chuck" {
+			t.Fatalf("unexpected message: %s", body)
+		}
+		w.Write([]byte(`{"name":"projects/titan-test/messages/1"}`))
+	})
+
+	c, s := newTestClient(t, mux)
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		name, err := c.Send(&Message{Token: "device-1", Data: map[string]string{"message": "hi"}, Notification: &Notification{Title: "Chuck"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "projects/titan-test/messages/1" {
+			t.Fatalf("unexpected message name: %v", name)
+		}
+	}
+
+	if tokenCalls != 1 {
+		t.Fatalf("expected access token to be cached, got %v token calls", tokenCalls)
+	}
+}
+
+func TestSendError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token":"abc","expires_in":3600}`))
+	})
+	mux.HandleFunc("/send", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"code":404,"message":"Requested entity was not found.","status":"UNREGISTERED"}}`))
+	})
+
+	c, s := newTestClient(t, mux)
+	defer s.Close()
+
+	_, err := c.Send(&Message{Token: "device-1"})
+	if ferr, ok := err.(*Error); !ok || ferr.Status != "UNREGISTERED" || !ferr.Unregistered() {
+		t.Fatalf("expected UNREGISTERED error, got: %v", err)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	var e errorRes
+	body := `{"error":{"code":404,"message":"Requested entity was not found.","status":"NOT_FOUND","details":[
+		{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Error.ErrorCode() != "UNREGISTERED" || !e.Error.Unregistered() {
+		t.Fatalf("expected error code to be read from the error details, got: %+v", e.Error)
+	}
+
+	if e := (&Error{Code: 429, Status: "RESOURCE_EXHAUSTED"}); e.ErrorCode() != "RESOURCE_EXHAUSTED" || e.Unregistered() {
+		t.Fatalf("expected status to be used as the error code, got: %v", e.ErrorCode())
+	}
+}
@@ -0,0 +1,62 @@
+package fcm
+
+import "fmt"
+
+// Message is a message to be sent to FCM.
+// Exactly one of Token or Topic fields should be set as the target.
+// https://firebase.google.com/docs/reference/fcm/rest/v1/projects.messages#Message
+type Message struct {
+	Token        string            `json:"token,omitempty"`
+	Topic        string            `json:"topic,omitempty"`
+	Data         map[string]string `json:"data,omitempty"`
+	Notification *Notification     `json:"notification,omitempty"`
+	Android      *AndroidConfig    `json:"android,omitempty"`
+}
+
+// Notification is the basic notification template to be displayed on the device.
+type Notification struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+	Image string `json:"image,omitempty"`
+}
+
+// AndroidConfig contains Android specific delivery options.
+type AndroidConfig struct {
+	CollapseKey string `json:"collapse_key,omitempty"`
+	Priority    string `json:"priority,omitempty"` // "normal" or "high"
+	TTL         string `json:"ttl,omitempty"`      // duration in seconds with "s" suffix, i.e. "3.5s"
+}
+
+// Error is an error returned by the FCM API.
+type Error struct {
+	Code    int           `json:"code"`
+	Message string        `json:"message"`
+	Status  string        `json:"status"` // i.e. INVALID_ARGUMENT, NOT_FOUND, RESOURCE_EXHAUSTED, UNAVAILABLE
+	Details []ErrorDetail `json:"details,omitempty"`
+}
+
+// ErrorDetail carries the FCM specific error code of an API error.
+type ErrorDetail struct {
+	Type      string `json:"@type"`
+	ErrorCode string `json:"errorCode"` // i.e. UNREGISTERED, INVALID_ARGUMENT, QUOTA_EXCEEDED, UNAVAILABLE
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("fcm: api error: code: %v, status: %v, message: %v", e.Code, e.Status, e.Message)
+}
+
+// ErrorCode returns the FCM specific error code of the error, or the status if no error code is given.
+func (e *Error) ErrorCode() string {
+	for _, d := range e.Details {
+		if d.ErrorCode != "" {
+			return d.ErrorCode
+		}
+	}
+	return e.Status
+}
+
+// Unregistered reports whether the registration token the message is sent to is no longer valid (i.e. the app is
+// uninstalled), so the token should be removed.
+func (e *Error) Unregistered() bool {
+	return e.ErrorCode() == "UNREGISTERED"
+}
@@ -0,0 +1,68 @@
+package titan
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+// MessageFilter inspects the messages before they are queued for the recipients, i.e. a profanity filter, a spam scorer, or a compliance
+// scanner, registered with Server.AddMessageFilter. Filter is called with the message along with its sender and recipient (or group, or topic),
+// and returns the message to be sent, of which only the body is used so the filter can mask or redact it, or a non-empty reason to reject the
+// message, which is returned to the sender as an error. Errors fail the request of the sender. Encrypted message bodies can only be allowed or
+// rejected as a whole. Filter is called concurrently for the messages of different senders.
+type MessageFilter interface {
+	Filter(m models.Message) (filtered models.Message, reject string, err error)
+}
+
+// MessageFilterFunc is an adapter to allow the use of ordinary functions as message filters.
+type MessageFilterFunc func(m models.Message) (models.Message, string, error)
+
+// Filter calls f(m).
+func (f MessageFilterFunc) Filter(m models.Message) (models.Message, string, error) {
+	return f(m)
+}
+
+// filters runs the registered message filters in the order they are registered.
+type filters struct {
+	mutex sync.RWMutex
+	list  []MessageFilter
+}
+
+func (fs *filters) add(f MessageFilter) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.list = append(fs.list, f)
+}
+
+// apply passes the message through all the filters, each filter getting the body returned by the previous one.
+// Returns the filtered body, or the reason of the first filter rejecting the message.
+func (fs *filters) apply(m models.Message) (body string, reject string, err error) {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+	for _, f := range fs.list {
+		fm, reject, err := f.Filter(m)
+		if err != nil || reject != "" {
+			return "", reject, err
+		}
+		m.Message = fm.Message
+	}
+	return m.Message, "", nil
+}
+
+// filterMsg filters the given message sent with a request, replacing its body with the filtered one. If the message is rejected,
+// the error response is set on the request context and false is returned.
+func filterMsg(ctx *neptulon.ReqCtx, fs *filters, m *models.Message) (ok bool, err error) {
+	body, reject, err := fs.apply(*m)
+	if err != nil {
+		return false, fmt.Errorf("route: %v: message filter failed: %v", ctx.Method, err)
+	}
+	if reject != "" {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Message is rejected: " + reject}
+		return false, nil
+	}
+	m.Message = body
+	return true, nil
+}
@@ -0,0 +1,125 @@
+package titan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+)
+
+var fixtureLog = log.Component("fixtures")
+
+// Fixtures is a known state of the database for local development and integration tests, loaded from a JSON file.
+type Fixtures struct {
+	Users    []FixtureUser    `json:"users"`
+	Groups   []models.Group   `json:"groups"`
+	Messages []models.Message `json:"messages"` // Sample conversations. Messages are added to the history (and indexed for search) but not delivered.
+}
+
+// FixtureUser is a user along with the devices and the contacts of the user.
+type FixtureUser struct {
+	models.User
+	Devices  []FixtureDevice `json:"devices"`
+	Contacts []string        `json:"contacts"` // IDs of the contacts of the user.
+}
+
+// FixtureDevice is a device of a user, which is issued the given refresh token.
+type FixtureDevice struct {
+	Name         string `json:"name"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// LoadFixtures loads the users (along with their devices and contacts), groups, and messages in the given fixtures file
+// into the database, overwriting the existing ones with the same IDs. Users without a JWT token are issued one, which is logged
+// so the clients can sign in with it. Messages without an ID or time are assigned one, each a millisecond after the previous.
+// Fixtures are for development and test environments only, as they carry the credentials of the users.
+func (s *Server) LoadFixtures(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("fixtures: failed to read fixtures file: %v", err)
+	}
+	var f Fixtures
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("fixtures: failed to parse fixtures file %v: %v", path, err)
+	}
+
+	now := time.Now()
+	for _, fu := range f.Users {
+		u := fu.User
+		if u.ID == "" {
+			return fmt.Errorf("fixtures: user without id: %v", u.Email)
+		}
+		if u.Registered.IsZero() {
+			u.Registered = now
+		}
+		if u.JWTToken == "" {
+			if u.JWTToken, err = s.jwtKeys.Sign(userClaims(&u)); err != nil {
+				return fmt.Errorf("fixtures: failed to sign jwt token: %v", err)
+			}
+		}
+		if err := s.db.SaveUser(&u); err != nil {
+			return fmt.Errorf("fixtures: failed to save user %v: %v", u.ID, err)
+		}
+		fixtureLog.Infof("user %v (%v) token: %v", u.ID, u.Email, u.JWTToken)
+
+		for _, d := range fu.Devices {
+			if d.RefreshToken == "" {
+				continue
+			}
+			rt := models.RefreshToken{ID: hashToken(d.RefreshToken), UserID: u.ID, Device: d.Name, Created: now}
+			if err := s.db.SaveRefreshToken(&rt); err != nil {
+				return fmt.Errorf("fixtures: failed to save refresh token of user %v: %v", u.ID, err)
+			}
+		}
+		for _, c := range fu.Contacts {
+			if err := s.db.AddContact(u.ID, c); err != nil {
+				return fmt.Errorf("fixtures: failed to add contact of user %v: %v", u.ID, err)
+			}
+		}
+	}
+
+	members := make(map[string][]string)
+	for _, g := range f.Groups {
+		if g.Created.IsZero() {
+			g.Created = now
+		}
+		if err := s.db.SaveGroup(&g); err != nil {
+			return fmt.Errorf("fixtures: failed to save group %v: %v", g.ID, err)
+		}
+		members[g.ID] = g.Members
+	}
+
+	t := now.Add(-time.Duration(len(f.Messages)) * time.Millisecond)
+	for _, m := range f.Messages {
+		t = t.Add(time.Millisecond)
+		if m.ID == "" {
+			if m.ID, err = shortid.UUID(); err != nil {
+				return fmt.Errorf("fixtures: failed to generate message ID: %v", err)
+			}
+		}
+		if m.Time.IsZero() {
+			m.Time = t
+		}
+		if m.State == "" {
+			m.State = models.StateDelivered
+		}
+
+		userIDs := []string{m.From, m.To}
+		if m.Group != "" {
+			m.Conversation, userIDs = models.GroupConversation(m.Group), members[m.Group]
+		} else {
+			m.Conversation = models.DirectConversation(m.From, m.To)
+		}
+		if err := s.db.SaveMessage(&m); err != nil {
+			return fmt.Errorf("fixtures: failed to save message %v: %v", m.ID, err)
+		}
+		indexMsg(s.db, &m, userIDs)
+	}
+
+	fixtureLog.Infof("loaded %v users, %v groups, and %v messages from %v", len(f.Users), len(f.Groups), len(f.Messages), path)
+	return nil
+}
@@ -1,57 +1,212 @@
 package titan
 
 import (
-	"log"
-	"strconv"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
 
-	"github.com/soygul/gcm/ccs"
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/ccs"
+	"github.com/titan-x/titan/fcm"
+	"github.com/titan-x/titan/log"
 )
 
-func listenGCM() {
-	c, err := ccs.Connect(Conf.GCM.CCSHost, Conf.GCM.SenderID, Conf.GCM.APIKey(), Conf.App.Debug)
-	if err != nil {
-		log.Fatalln("gcm: failed to connect to GCM CCS with error:", err)
-	}
+var gcmLog = log.Component("gcm")
 
-	log.Println("gcm: started")
+// gcmAcks is the number of push notifications acknowledged by GCM CCS.
+var gcmAcks = expvar.NewInt("gcm-acks")
 
-	for {
-		m, err := c.Receive()
+// gcmNacks is the number of push notifications rejected by GCM CCS, per error code (i.e. DEVICE_UNREGISTERED).
+var gcmNacks = expvar.NewMap("gcm-nacks")
+
+// possible GCM CCS connection states, as reported by the health checks
+const (
+	gcmDisabled     = "disabled"
+	gcmConnected    = "connected"
+	gcmDisconnected = "disconnected"
+)
+
+// gcmState is the current GCM CCS connection state.
+var gcmState atomic.Value
+
+func init() {
+	gcmState.Store(gcmDisabled)
+}
+
+// pushMsg is a push notification to be delivered to a device.
+type pushMsg struct {
+	UserID string            // ID of the user the device belongs to, so the registration ID can be updated as reported by GCM.
+	To     string            // GCM registration ID or FCM registration token of the device.
+	Data   map[string]string // Data payload to be handled by the client application.
+	Title  string            // Optional notification title. If set, a notification payload is sent along with the data.
+	Body   string            // Optional notification body.
+
+	// Optional key of the group of notifications which replace each other, so only the latest one of the group is delivered
+	// to a device which is not reachable when they are sent.
+	CollapseKey string
+
+	Priority       string // Delivery priority: high or normal.
+	DelayWhileIdle bool   // Whether to hold the notification until the device is active. Only supported by GCM CCS.
+}
+
+// pushSender sends push notifications to devices.
+type pushSender interface {
+	Send(m *pushMsg) error
+	Close() error
+}
+
+// newPushSender creates a push sender as configured by Conf.GCM.Provider.
+// Canonical and unregistered registration IDs reported with the push notification results are queued to the given tokens,
+// and upstream messages from the devices (only supported by GCM CCS) are handed over to the given upstream handler.
+func newPushSender(tokens *gcmTokens, upstream *gcmUpstream) (pushSender, error) {
+	switch Conf.GCM.Provider {
+	case providerFCM:
+		cred, err := ioutil.ReadFile(Conf.GCM.FCMCredentials)
 		if err != nil {
-			log.Println("gcm: error receiving message:", err)
+			return nil, fmt.Errorf("gcm: failed to read fcm credentials file: %v", err)
 		}
-
-		go readHandler(m)
+		c, err := fcm.NewClient(cred)
+		if err != nil {
+			return nil, err
+		}
+		c.HTTPClient = Conf.App.HTTPClient()
+		return &fcmSender{client: c, tokens: tokens}, nil
+	case providerCCS, "":
+		c, err := ccs.Connect(Conf.GCM.CCSHost, Conf.GCM.SenderID, Conf.GCM.APIKey(), Conf.App.Debug)
+		if err != nil {
+			gcmState.Store(gcmDisconnected)
+			return nil, fmt.Errorf("gcm: failed to connect to GCM CCS with error: %v", err)
+		}
+		gcmState.Store(gcmConnected)
+		s := &ccsSender{conn: c, tokens: tokens, upstream: upstream, users: make(map[string]string)}
+		go s.listen()
+		return s, nil
+	default:
+		return nil, fmt.Errorf("gcm: unknown push provider: %v", Conf.GCM.Provider)
 	}
 }
 
-func readHandler(m *ccs.InMsg) {
-	t := m.Data["n.message_type"]
-	if t == "" {
-		log.Printf("gcm: malformed message from device: %+v\n", m)
-		return
+// ccsSender sends push notifications through GCM XMPP CCS connection.
+type ccsSender struct {
+	conn     *ccs.Conn
+	tokens   *gcmTokens
+	upstream *gcmUpstream
+	mutex    sync.Mutex
+	users    map[string]string // message ID -> user ID, for the messages pending an ACK or NACK
+}
+
+func (s *ccsSender) Send(m *pushMsg) error {
+	// CCS does not support notification payloads through ccs.OutMsg so notification text is sent in data payload
+	data := m.Data
+	if m.Title != "" || m.Body != "" {
+		data = make(map[string]string, len(m.Data)+2)
+		for k, v := range m.Data {
+			data[k] = v
+		}
+		data["n.title"] = m.Title
+		data["n.body"] = m.Body
+	}
+
+	// message ID is generated beforehand so the ACK or NACK can be matched to the user even if it arrives before Send returns
+	id, err := shortid.UUID()
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.users[id] = m.UserID
+	s.mutex.Unlock()
+
+	om := ccs.OutMsg{To: m.To, ID: id, Data: data, CollapseKey: m.CollapseKey, Priority: m.Priority, DelayWhileIdle: m.DelayWhileIdle}
+	if _, err := s.conn.Send(&om); err != nil {
+		s.user(id)
+		return err
 	}
+	return nil
+}
+
+func (s *ccsSender) Close() error {
+	err := s.conn.Close()
+	gcmState.Store(gcmDisabled)
+	return err
+}
+
+// user returns the ID of the user a message is sent to, and forgets the message.
+func (s *ccsSender) user(msgID string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	userID := s.users[msgID]
+	delete(s.users, msgID)
+	return userID
+}
 
-	switch t {
-	case "message":
-		ids := m.Data["n.to"]
-		if ids == "" {
-			log.Printf("gcm: malformed message from device: %+v\n", m)
+// listen receives the messages from the CCS connection until it fails. Results of the sent push notifications are
+// recorded in the metrics, and upstream messages from the devices are handed over to the upstream handler.
+func (s *ccsSender) listen() {
+	gcmLog.Infof("started")
+
+	for {
+		m, err := s.conn.Receive()
+		if err == ccs.ErrClosed {
+			gcmState.Store(gcmDisabled)
+			gcmLog.Infof("stopped")
 			return
 		}
-
-		id64, err := strconv.ParseUint(ids, 10, 32)
-		if err != nil || id64 == 0 {
-			log.Printf("gcm: invalid user ID specific in 'n.to' data field in message from device: %+v\n", m)
+		if err != nil {
+			gcmState.Store(gcmDisconnected)
+			gcmLog.Errorf("error receiving message: %v", err)
 			return
 		}
 
-		// id := uint32(id64)
-		// user, ok := users[id]
-		// if !ok {
-		// 	log.Printf("User not found in user list: %+v\n", m)
-		// }
-		//
-		// user.Send(m.Data)
+		switch m.MessageType {
+		case ccs.Upstream:
+			go s.upstream.handle(m)
+		case ccs.Ack:
+			gcmAcks.Add(1)
+			userID := s.user(m.ID)
+			if id := m.CanonicalID(); id != "" {
+				s.tokens.replace(userID, m.From, id)
+			}
+		case ccs.Nack:
+			err := m.NackError()
+			gcmNacks.Add(err.Code, 1)
+			gcmLog.Warnf("push notification rejected: %v", err)
+			userID := s.user(m.ID)
+			if err.Unregistered() {
+				s.tokens.remove(userID, m.From)
+			}
+		case ccs.Receipt:
+			gcmLog.Debugf("push notification %v delivered: %+v", m.ID, m.Data)
+		case ccs.Control:
+			// draining connections are replaced by the ccs connection, so the new push notifications are not stalled
+			gcmLog.Infof("received control message: %v", m.ControlType)
+		}
+	}
+}
+
+// fcmSender sends push notifications through FCM HTTP v1 API.
+type fcmSender struct {
+	client *fcm.Client
+	tokens *gcmTokens
+}
+
+func (s *fcmSender) Send(m *pushMsg) error {
+	fm := fcm.Message{Token: m.To, Data: m.Data}
+	if m.Title != "" || m.Body != "" {
+		fm.Notification = &fcm.Notification{Title: m.Title, Body: m.Body}
+	}
+	if m.CollapseKey != "" || m.Priority != "" {
+		fm.Android = &fcm.AndroidConfig{CollapseKey: m.CollapseKey, Priority: m.Priority}
+	}
+
+	_, err := s.client.Send(&fm)
+	if ferr, ok := err.(*fcm.Error); ok && ferr.Unregistered() {
+		s.tokens.remove(m.UserID, m.To)
 	}
+	return err
+}
+
+func (s *fcmSender) Close() error {
+	return nil
 }
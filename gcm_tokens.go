@@ -0,0 +1,119 @@
+package titan
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+// gcmTokensInterval is the interval of applying the reported registration ID changes to the database.
+const gcmTokensInterval = time.Minute
+
+// gcmTokensReplaced is the number of registration IDs replaced with the canonical IDs reported by GCM.
+var gcmTokensReplaced = expvar.NewInt("gcm-tokens-replaced")
+
+// gcmTokensRemoved is the number of registration IDs removed as GCM reported the devices to be unregistered.
+var gcmTokensRemoved = expvar.NewInt("gcm-tokens-removed")
+
+// gcmTokens collects the registration ID changes reported with the push notification results (canonical IDs and
+// unregistered devices), and periodically applies them to the user records so we stop pushing to dead registration IDs.
+// Updates are applied only if the user still has the reported registration ID, so a newer one is never overwritten.
+// Requests waiting for an offline user whose registration ID is removed are dead-lettered, as the user can no longer be
+// notified of them. They can still be re-driven, i.e. once the user signs in again.
+type gcmTokens struct {
+	db      data.UserDB
+	pr      *presence
+	ev      *events
+	mutex   sync.Mutex
+	updates map[string]gcmTokenUpdate // user ID -> pending update
+}
+
+type gcmTokenUpdate struct {
+	token     string // registration ID the push notification was sent to
+	canonical string // registration ID to replace the token with, or empty if the token is to be removed
+}
+
+func newGCMTokens(db data.UserDB, pr *presence, ev *events) *gcmTokens {
+	return &gcmTokens{db: db, pr: pr, ev: ev, updates: make(map[string]gcmTokenUpdate)}
+}
+
+// replace queues the replacement of a user's registration ID with the canonical one.
+func (t *gcmTokens) replace(userID, token, canonical string) {
+	if userID == "" || canonical == "" || token == canonical {
+		return
+	}
+	t.queue(userID, gcmTokenUpdate{token: token, canonical: canonical})
+}
+
+// remove queues the removal of a user's registration ID.
+func (t *gcmTokens) remove(userID, token string) {
+	if userID == "" {
+		return
+	}
+	t.queue(userID, gcmTokenUpdate{token: token})
+}
+
+func (t *gcmTokens) queue(userID string, u gcmTokenUpdate) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.updates[userID] = u
+}
+
+// flush applies the pending updates to the database. Updates which fail to be saved are kept to be retried with the next flush.
+func (t *gcmTokens) flush() {
+	t.mutex.Lock()
+	updates := t.updates
+	t.updates = make(map[string]gcmTokenUpdate)
+	t.mutex.Unlock()
+
+	for userID, u := range updates {
+		user, ok := t.db.GetByID(userID)
+		if !ok || user.GCMRegID != u.token {
+			continue
+		}
+
+		user.GCMRegID = u.canonical
+		if err := t.db.SaveUser(user); err != nil {
+			gcmLog.Errorf("failed to update registration ID of user %v: %v", userID, err)
+			t.mutex.Lock()
+			if _, ok := t.updates[userID]; !ok {
+				t.updates[userID] = u
+			}
+			t.mutex.Unlock()
+			continue
+		}
+
+		if u.canonical != "" {
+			gcmTokensReplaced.Add(1)
+			gcmLog.Debugf("replaced registration ID of user %v with the canonical ID", userID)
+		} else {
+			gcmTokensRemoved.Add(1)
+			t.ev.publish(models.EventDeviceUnregistered, userID, nil)
+			gcmLog.Debugf("removed unregistered registration ID of user %v", userID)
+			if !t.pr.IsOnline(userID) {
+				if n := (*t.pr.queue).Purge(userID, models.DeadLetterInvalidToken); n > 0 {
+					gcmLog.Infof("dead-lettered %v requests of user %v with unregistered registration ID", n, userID)
+				}
+			}
+		}
+	}
+}
+
+// run flushes the pending updates periodically until done is closed, and one last time before returning.
+func (t *gcmTokens) run(done chan struct{}) {
+	tick := time.NewTicker(gcmTokensInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-done:
+			t.flush()
+			return
+		case <-tick.C:
+			t.flush()
+		}
+	}
+}
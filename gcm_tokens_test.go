@@ -0,0 +1,51 @@
+package titan
+
+import (
+	"testing"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/data/inmem"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+func TestGCMTokens(t *testing.T) {
+	db := inmem.NewDB()
+	for _, u := range []models.User{{ID: "1", GCMRegID: "old"}, {ID: "2", GCMRegID: "gone"}, {ID: "3", GCMRegID: "newer"}} {
+		u := u
+		if err := db.SaveUser(&u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// requests waiting for the user with the unregistered device are dead-lettered
+	var q data.Queue = inmem.NewQueue(func(connID string, method string, params interface{}, resHandler func(ctx *neptulon.ResCtx) error) (string, error) {
+		return "", nil
+	})
+	if err := q.AddRequest("2", "msg.recv", []models.Message{{From: "1", Message: "hi"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	replaced, removed := gcmTokensReplaced.Value(), gcmTokensRemoved.Value()
+	tokens := newGCMTokens(db, newPresence(&q, &events{}), &events{})
+	tokens.replace("1", "old", "canonical")
+	tokens.remove("2", "gone")
+	tokens.remove("3", "older") // user already has a newer registration ID
+	tokens.remove("4", "unknown")
+	tokens.flush()
+
+	for id, regID := range map[string]string{"1": "canonical", "2": "", "3": "newer"} {
+		if u, _ := db.GetByID(id); u.GCMRegID != regID {
+			t.Fatalf("expected registration ID of user %v to be %q, got: %q", id, regID, u.GCMRegID)
+		}
+	}
+	if r, d := gcmTokensReplaced.Value()-replaced, gcmTokensRemoved.Value()-removed; r != 1 || d != 1 {
+		t.Fatalf("unexpected metrics: replaced: %v, removed: %v", r, d)
+	}
+	if dls := q.DeadLetters("2"); len(dls) != 1 || dls[0].Reason != models.DeadLetterInvalidToken || q.Depth("2") != 0 {
+		t.Fatalf("expected queued request to be dead-lettered with invalid token reason, got: %+v", dls)
+	}
+	if len(tokens.updates) != 0 {
+		t.Fatalf("expected updates to be flushed, got: %+v", tokens.updates)
+	}
+}
@@ -0,0 +1,73 @@
+package titan
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/titan-x/titan/ccs"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+// gcmUpstream handles the upstream messages sent by the devices through GCM CCS, so the Android clients which cannot keep
+// a connection open (i.e. in doze mode) can still send messages. Messages are authenticated with the JWT access token of the user,
+// rate limited, and queued the same way as the messages sent with msg.send over a client connection. Upstream message data fields:
+//
+//	n.message_type: "message"
+//	n.token:        JWT access token of the sender
+//	n.to:           ID of the recipient
+//	n.message:      message body
+//	n.client_id:    optional client generated message ID, so the retries of the same message are not sent again
+//
+// As there is no response to an upstream message, the sender is notified of the sent message with a msg.sent request
+// once connected, and rejected messages are only logged.
+type gcmUpstream struct {
+	db      *data.DB
+	q       *data.Queue
+	ev      *events
+	dd      *dedupe
+	pu      *pusher
+	bt      *bots
+	fl      *filters
+	keys    *jwtKeys
+	limiter *rateLimiter
+}
+
+// handle handles an upstream message, logging any error.
+func (u *gcmUpstream) handle(m *ccs.InMsg) {
+	if err := u.send(m); err != nil {
+		gcmLog.Warnf("upstream message %v from device %v is rejected: %v", m.ID, m.From, err)
+	}
+}
+
+func (u *gcmUpstream) send(m *ccs.InMsg) error {
+	switch t := m.Data["n.message_type"]; t {
+	case "message":
+	case "":
+		return fmt.Errorf("malformed message: no message type: %+v", m.Data)
+	default:
+		return fmt.Errorf("unknown message type: %v", t)
+	}
+
+	_, userID, err := verifyJWT(u.keys, *u.db, m.Data["n.token"])
+	if err != nil {
+		return err
+	}
+
+	msg := models.Message{To: m.Data["n.to"], Message: m.Data["n.message"], ClientID: m.Data["n.client_id"]}
+	if msg.To == "" {
+		return fmt.Errorf("malformed message: no recipient in 'n.to' field")
+	}
+
+	if retry, ok := u.limiter.take(true, userID, 1, time.Now()); !ok {
+		return fmt.Errorf("rate limit exceeded for user %v, retry after %v", userID, retry)
+	}
+
+	// upstream messages are handled as msg.send requests of a connection authenticated as the sender
+	if _, err := sendAsUser(u.db, u.q, u.ev, u.dd, u.pu, u.bt, u.fl, userID, m.ID, "gcm.upstream", []models.Message{msg}); err != nil {
+		return err
+	}
+
+	gcmLog.Debugf("upstream message %v from user %v is queued for %v", m.ID, userID, msg.To)
+	return nil
+}
@@ -0,0 +1,57 @@
+package titan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/ccs"
+	"github.com/titan-x/titan/models"
+)
+
+func TestGCMUpstream(t *testing.T) {
+	s, err := NewServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.jwtKeys.Sign(map[string]interface{}{"userid": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &gcmUpstream{db: &s.db, q: &s.queue, ev: s.events, dd: s.dedupe, pu: s.pusher, bt: s.bots, fl: s.filters, keys: s.jwtKeys, limiter: newRateLimiter(0, 1)}
+	upstream := func(data map[string]string) *ccs.InMsg {
+		return &ccs.InMsg{From: "reg-1", ID: "u-1", Data: data}
+	}
+
+	m := upstream(map[string]string{"n.message_type": "message", "n.token": token, "n.to": "2", "n.message": "hi", "n.client_id": "c-1"})
+	if err := u.send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// message is queued for the recipient and saved in the history, as if it is sent over a client connection
+	if d := s.queue.Depth("2"); d != 1 {
+		t.Fatalf("expected message to be queued for the recipient, got queue depth: %v", d)
+	}
+	msgs, err := s.db.GetMessages(models.DirectConversation("1", "2"), time.Now(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].From != "1" || msgs[0].To != "2" || msgs[0].Message != "hi" {
+		t.Fatalf("unexpected message history: %+v", msgs)
+	}
+
+	// messages are rate limited per user
+	if err := u.send(m); err == nil {
+		t.Fatal("expected message to be rate limited")
+	}
+
+	for _, data := range []map[string]string{
+		{"n.token": token, "n.to": "2"},
+		{"n.message_type": "presence", "n.token": token},
+		{"n.message_type": "message", "n.token": "invalid", "n.to": "2"},
+		{"n.message_type": "message", "n.token": token},
+	} {
+		if err := u.send(upstream(data)); err == nil {
+			t.Fatalf("expected malformed or unauthenticated message to be rejected: %v", data)
+		}
+	}
+}
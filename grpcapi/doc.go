@@ -0,0 +1,8 @@
+// Package grpcapi serves the Titan gRPC API for the server-to-server integrations, so the backend services (i.e. billing,
+// CRM bots) can send messages through the same pipeline as the clients, and query the delivery states and presence of the users,
+// without speaking the device protocol. The titan command only serves it when built with the grpc build tag (go build -tags grpc).
+//
+// Go code in titan.pb.go and titan_grpc.pb.go is generated from titan.proto with:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative titan.proto
+package grpcapi
@@ -0,0 +1,110 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// NewServer creates a gRPC server serving the Titan service over the given server. Connections are secured with the TLS
+// configuration of the server, if TLS is enabled.
+func NewServer(s *titan.Server, opts ...grpc.ServerOption) *grpc.Server {
+	if c := s.TLSConfig(); c != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(c)))
+	}
+	gs := grpc.NewServer(opts...)
+	RegisterTitanServer(gs, &service{s: s})
+	return gs
+}
+
+// service implements the Titan service. Each call is authenticated with the JWT token of an admin user given in the
+// authorization metadata as "Bearer <token>", and recorded to the audit log.
+type service struct {
+	UnimplementedTitanServer
+	s *titan.Server
+}
+
+func (svc *service) SendMessage(ctx context.Context, req *SendMessageRequest) (*Receipt, error) {
+	userID, err := svc.auth(ctx, "service.send", req.To)
+	if err != nil {
+		return nil, err
+	}
+	if req.To == "" || req.Message == "" {
+		return nil, status.Error(codes.InvalidArgument, "recipient and message are required")
+	}
+
+	r, err := svc.s.SendMessage(userID, models.Message{To: req.To, Message: req.Message, ClientID: req.ClientId})
+	if errors.Is(err, titan.ErrMessageRejected) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to send message: %v", err)
+	}
+	return receipt(r), nil
+}
+
+func (svc *service) QueryDeliveryState(ctx context.Context, req *QueryDeliveryStateRequest) (*Receipt, error) {
+	if _, err := svc.auth(ctx, "service.deliveryState", ""); err != nil {
+		return nil, err
+	}
+
+	r, ok := svc.s.DeliveryState(req.MessageId)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "message not found: %v", req.MessageId)
+	}
+	return receipt(r), nil
+}
+
+func (svc *service) GetPresence(ctx context.Context, req *GetPresenceRequest) (*GetPresenceResponse, error) {
+	if _, err := svc.auth(ctx, "service.presence", ""); err != nil {
+		return nil, err
+	}
+
+	res := &GetPresenceResponse{}
+	for _, p := range svc.s.Presence(req.UserIds...) {
+		pp := &Presence{UserId: p.UserID, Online: p.Online}
+		if !p.LastSeen.IsZero() {
+			pp.LastSeen = timestamppb.New(p.LastSeen)
+		}
+		res.Presences = append(res.Presences, pp)
+	}
+	return res, nil
+}
+
+// auth authenticates the call with the bearer token in the authorization metadata and returns the ID of the calling user.
+func (svc *service) auth(ctx context.Context, action, target string) (string, error) {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if a := md.Get("authorization"); len(a) > 0 {
+			token = strings.TrimPrefix(a[0], "Bearer ")
+		}
+	}
+
+	e := models.AuditEntry{Action: action, Target: target}
+	if p, ok := peer.FromContext(ctx); ok {
+		e.RemoteAddr = p.Addr.String()
+	}
+	userID, err := svc.s.AuthService(token, e)
+	if err != nil {
+		return "", status.Error(codes.Unauthenticated, "authorization with the jwt token of an admin user is required")
+	}
+	return userID, nil
+}
+
+func receipt(r models.Receipt) *Receipt {
+	res := &Receipt{Id: r.ID, ClientId: r.ClientID, From: r.From, To: r.To, State: r.State, Devices: r.Devices}
+	if !r.Time.IsZero() {
+		res.Time = timestamppb.New(r.Time)
+	}
+	return res
+}
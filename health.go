@@ -0,0 +1,112 @@
+package titan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
+)
+
+var healthLog = log.Component("health")
+
+const (
+	healthOK   = "ok"
+	healthFail = "fail"
+)
+
+// healthRes is the response of the health check endpoints.
+type healthRes struct {
+	Status string            `json:"status"`           // ok or fail
+	Checks map[string]string `json:"checks,omitempty"` // component -> ok, status, or error message
+}
+
+// HealthHandler returns the HTTP handler serving the health check endpoints, for load balancers and orchestrators:
+//
+//	/healthz: Liveness check, which fails if the server is not listening for connections.
+//	/readyz:  Readiness check, which also fails if the database, queue store, attachment store, cluster, event bus, or GCM CCS connection is unavailable,
+//	          or if the server is draining.
+//
+// Both endpoints respond with 200 OK if all the checks pass, or 503 Service Unavailable otherwise, along with the check results in JSON.
+func (s *Server) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, map[string]string{"listener": s.listenerHealth()})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]string{
+			"listener": s.listenerHealth(),
+			"db":       pingHealth(s.db),
+			"gcm":      gcmState.Load().(string),
+			"drain":    healthOK,
+		}
+		if s.Draining() {
+			checks["drain"] = "draining"
+		}
+		if s.blobs != nil {
+			checks["attachments"] = pingHealth(s.blobs)
+		}
+		if s.queueStore != nil {
+			checks["queue"] = pingHealth(s.queueStore)
+		}
+		if s.cluster != nil {
+			checks["cluster"] = pingHealth(s.cluster)
+		}
+		if s.events.bus != nil {
+			checks["events"] = pingHealth(s.events.bus)
+		}
+		writeHealth(w, checks)
+	})
+	return mux
+}
+
+// listenHealth starts serving the health check endpoints at the given network address, in a separate goroutine.
+func (s *Server) listenHealth(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("server: failed to create health check listener on network address %v: %v", addr, err)
+	}
+	s.healthListener = l
+
+	go func() {
+		if err := http.Serve(l, s.HealthHandler()); err != nil && atomic.LoadInt32(&s.listening) == 1 {
+			healthLog.Errorf("listener stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (s *Server) listenerHealth() string {
+	if atomic.LoadInt32(&s.listening) == 1 {
+		return healthOK
+	}
+	return "not listening"
+}
+
+// pingHealth checks the connectivity of the given database or store, if it supports it.
+func pingHealth(v interface{}) string {
+	if p, ok := v.(data.Pinger); ok {
+		if err := p.Ping(); err != nil {
+			return err.Error()
+		}
+	}
+	return healthOK
+}
+
+func writeHealth(w http.ResponseWriter, checks map[string]string) {
+	res := healthRes{Status: healthOK, Checks: checks}
+	for _, c := range checks {
+		if c != healthOK && c != gcmConnected && c != gcmDisabled {
+			res.Status = healthFail
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if res.Status != healthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,270 @@
+package titan
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+)
+
+var hookLog = log.Component("hooks")
+
+const (
+	hookQueueSize     = 1000            // maximum number of events waiting to be delivered to a webhook, beyond which new events are dropped
+	hookMaxAttempts   = 5               // number of times an event is tried to be delivered before giving up
+	hookBackoff       = time.Second     // wait before the first retry, doubled with each retry
+	hookMaxBackoff    = time.Minute     // maximum wait between the retries
+	maxHookDeliveries = 1000            // maximum number of deliveries to keep in the delivery log, oldest are discarded beyond that
+	hookSigTolerance  = 5 * time.Minute // maximum age of a signature accepted by VerifyWebhookSignature
+)
+
+// hooks delivers the server events to the outgoing webhooks registered by the operators, as signed JSON POST requests.
+// Each webhook has its own queue and worker, so a slow or unavailable webhook does not hold back the others, and the events
+// are delivered to a webhook in the order they happen. Failed deliveries (network errors, 429 and 5xx responses) are retried
+// with exponential backoff, and the outcome of each delivery is recorded to the delivery log.
+type hooks struct {
+	secret  []byte
+	client  *http.Client
+	backoff time.Duration
+
+	mutex sync.RWMutex
+	list  []*hook
+	n     int32 // number of the webhooks, accessed atomically
+	done  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+
+	logMutex   sync.Mutex
+	deliveries []models.WebhookDelivery // oldest first
+}
+
+type hook struct {
+	url    string
+	types  map[string]bool // event types to deliver, or all if empty
+	events chan *models.Event
+}
+
+func newHooks(secret string, client *http.Client) *hooks {
+	return &hooks{secret: []byte(secret), client: client, backoff: hookBackoff, done: make(chan struct{})}
+}
+
+// add registers a webhook for the given event types, or for all events if none given.
+func (h *hooks) add(rawurl string, eventTypes ...string) error {
+	if err := validHookURL(rawurl); err != nil {
+		return err
+	}
+	if len(h.secret) == 0 {
+		return fmt.Errorf("webhook secret is required to sign the events for webhook %v", rawurl)
+	}
+
+	hk := &hook{url: rawurl, types: make(map[string]bool), events: make(chan *models.Event, hookQueueSize)}
+	for _, t := range eventTypes {
+		hk.types[t] = true
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	select {
+	case <-h.done:
+		return fmt.Errorf("webhooks are closed")
+	default:
+	}
+	h.list = append(h.list, hk)
+	atomic.AddInt32(&h.n, 1)
+	h.wg.Add(1)
+	go h.run(hk)
+	return nil
+}
+
+// empty reports whether there are no webhooks registered, so the events need not be serialized for them.
+func (h *hooks) empty() bool {
+	return atomic.LoadInt32(&h.n) == 0
+}
+
+// send queues the event for delivery to the webhooks registered for its type. Events are dropped if the queue of a webhook is full.
+func (h *hooks) send(e *models.Event) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for _, hk := range h.list {
+		if len(hk.types) != 0 && !hk.types[e.Type] {
+			continue
+		}
+		select {
+		case hk.events <- e:
+		default:
+			hookLog.Warnf("queue of webhook %v is full, dropping %v event for user %v", hk.url, e.Type, e.UserID)
+			h.record(models.WebhookDelivery{URL: hk.url, Event: e.Type, UserID: e.UserID, Time: time.Now(), Error: "queue is full"})
+		}
+	}
+}
+
+// close stops delivering the events, dropping the ones waiting in the queues, and waits for the ongoing deliveries to finish.
+func (h *hooks) close() {
+	h.once.Do(func() {
+		h.mutex.Lock()
+		close(h.done)
+		h.mutex.Unlock()
+	})
+	h.wg.Wait()
+}
+
+func (h *hooks) run(hk *hook) {
+	defer h.wg.Done()
+	for {
+		select {
+		case e := <-hk.events:
+			h.deliver(hk, e)
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// deliver posts the event to the webhook, retrying with exponential backoff, and records the outcome to the delivery log.
+func (h *hooks) deliver(hk *hook, e *models.Event) {
+	d := models.WebhookDelivery{URL: hk.url, Event: e.Type, UserID: e.UserID}
+	body, err := json.Marshal(e)
+	if err != nil {
+		hookLog.Errorf("failed to serialize %v event for user %v: %v", e.Type, e.UserID, err)
+		return
+	}
+	if d.ID, err = shortid.UUID(); err != nil {
+		hookLog.Errorf("failed to generate delivery ID: %v", err)
+		return
+	}
+
+	backoff := h.backoff
+attempts:
+	for {
+		d.Attempts++
+		retry := h.post(&d, body)
+		if d.Success || !retry || d.Attempts >= hookMaxAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-h.done:
+			break attempts
+		}
+		if backoff *= 2; backoff > hookMaxBackoff {
+			backoff = hookMaxBackoff
+		}
+	}
+
+	d.Time = time.Now()
+	if !d.Success {
+		hookLog.Warnf("failed to deliver %v event for user %v to webhook %v after %v attempts: %v", e.Type, e.UserID, hk.url, d.Attempts, d.Error)
+	}
+	h.record(d)
+}
+
+// post makes a delivery attempt and reports whether the failure, if any, is temporary so the delivery should be retried.
+func (h *hooks) post(d *models.WebhookDelivery, body []byte) (retry bool) {
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(body))
+	if err != nil {
+		d.Error = err.Error()
+		return false
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Titan-Event", d.Event)
+	req.Header.Set("X-Titan-Delivery", d.ID)
+	req.Header.Set("X-Titan-Signature", "t="+ts+",v1="+signHook(h.secret, ts, body))
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		d.Status, d.Error = 0, err.Error()
+		return true
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+	res.Body.Close()
+
+	d.Status = res.StatusCode
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		d.Success, d.Error = true, ""
+		return false
+	}
+	d.Error = res.Status
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+}
+
+// record appends a delivery to the delivery log.
+func (h *hooks) record(d models.WebhookDelivery) {
+	h.logMutex.Lock()
+	defer h.logMutex.Unlock()
+
+	h.deliveries = append(h.deliveries, d)
+	if n := len(h.deliveries) - maxHookDeliveries; n > 0 {
+		h.deliveries = append([]models.WebhookDelivery(nil), h.deliveries[n:]...)
+	}
+}
+
+// deliveryLog retrieves the deliveries to the given webhook, or to any webhook if the URL is empty, oldest first.
+func (h *hooks) deliveryLog(hookURL string) []models.WebhookDelivery {
+	h.logMutex.Lock()
+	defer h.logMutex.Unlock()
+
+	var ds []models.WebhookDelivery
+	for _, d := range h.deliveries {
+		if hookURL == "" || d.URL == hookURL {
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+func signHook(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validHookURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url, expected http(s)://host/path: %v", rawurl)
+	}
+	return nil
+}
+
+// VerifyWebhookSignature verifies the X-Titan-Signature header of an event delivered to an outgoing webhook against the request body,
+// for the receivers of the events. Header has the form "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>" with the webhook secret>".
+// Signatures older than 5 minutes are rejected so the captured requests cannot be replayed.
+func VerifyWebhookSignature(secret, header string, body []byte) bool {
+	var ts, sig string
+	for _, p := range strings.Split(header, ",") {
+		switch {
+		case strings.HasPrefix(p, "t="):
+			ts = p[2:]
+		case strings.HasPrefix(p, "v1="):
+			sig = p[3:]
+		}
+	}
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return false
+	}
+	if age := time.Since(time.Unix(t, 0)); age > hookSigTolerance || age < -hookSigTolerance {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(signHook([]byte(secret), ts, body)))
+}
@@ -0,0 +1,109 @@
+package titan
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/models"
+)
+
+func TestHooks(t *testing.T) {
+	var calls int32
+	received := make(chan *models.Event, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !VerifyWebhookSignature("secret", r.Header.Get("X-Titan-Signature"), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// first two attempts fail temporarily
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e models.Event
+		json.Unmarshal(body, &e)
+		received <- &e
+	}))
+	defer srv.Close()
+
+	h := newHooks("secret", http.DefaultClient)
+	h.backoff = time.Millisecond
+	defer h.close()
+	if err := h.add(srv.URL, models.EventUserRegistered); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.add("ftp://titan.test"); err == nil {
+		t.Fatal("expected invalid webhook url to be rejected")
+	}
+
+	ev := &events{hooks: h}
+	ev.publish(models.EventUserConnected, "1", nil) // not subscribed
+	ev.publish(models.EventUserRegistered, "2", models.Profile{UserID: "2", Name: "Alice"})
+
+	select {
+	case e := <-received:
+		if e.Type != models.EventUserRegistered || e.UserID != "2" {
+			t.Fatalf("unexpected event: %+v", e)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get the event in time")
+	}
+
+	// wait for the delivery to be recorded
+	var ds []models.WebhookDelivery
+	for i := 0; i < 100 && len(ds) == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+		ds = h.deliveryLog(srv.URL)
+	}
+	if len(ds) != 1 || !ds[0].Success || ds[0].Attempts != 3 || ds[0].Status != http.StatusOK || ds[0].Event != models.EventUserRegistered {
+		t.Fatalf("unexpected delivery log: %+v", ds)
+	}
+
+	// signatures with the wrong secret or too old are rejected
+	body := []byte(`{}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if !VerifyWebhookSignature("secret", "t="+now+",v1="+signHook([]byte("secret"), now, body), body) {
+		t.Fatal("expected valid signature to be accepted")
+	}
+	if VerifyWebhookSignature("other", "t="+now+",v1="+signHook([]byte("secret"), now, body), body) {
+		t.Fatal("expected signature with the wrong secret to be rejected")
+	}
+	if VerifyWebhookSignature("secret", "t=1,v1="+signHook([]byte("secret"), "1", body), body) {
+		t.Fatal("expected old signature to be rejected")
+	}
+}
+
+func TestHooksPermanentFailure(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	h := newHooks("secret", http.DefaultClient)
+	h.backoff = time.Millisecond
+	if err := h.add(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	(&events{hooks: h}).publish(models.EventUserConnected, "1", nil)
+
+	var ds []models.WebhookDelivery
+	for i := 0; i < 100 && len(ds) == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+		ds = h.deliveryLog("")
+	}
+	h.close()
+
+	// client errors are not retried
+	if len(ds) != 1 || ds[0].Success || ds[0].Attempts != 1 || ds[0].Status != http.StatusBadRequest || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("unexpected delivery log: %+v", ds)
+	}
+}
@@ -0,0 +1,176 @@
+package titan
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+// maxJWTKeys is the maximum number of keys (current + previous) to be accepted when verifying JWT tokens.
+const maxJWTKeys = 5
+
+// jwtKeys holds the HMAC keys for signing and verifying JWT tokens.
+// First key is used for signing new tokens while all the keys are accepted when verifying tokens,
+// so keys can be rotated without invalidating the tokens that are already issued.
+type jwtKeys struct {
+	mutex sync.RWMutex
+	keys  [][]byte
+}
+
+func newJWTKeys(current string, previous ...string) *jwtKeys {
+	k := jwtKeys{keys: [][]byte{[]byte(current)}}
+	for _, p := range previous {
+		if p != "" && len(k.keys) < maxJWTKeys {
+			k.keys = append(k.keys, []byte(p))
+		}
+	}
+	return &k
+}
+
+// Rotate makes the given key the current signing key. Previous keys are still accepted for verification,
+// up to a maximum of maxJWTKeys keys in total, oldest keys being dropped first.
+func (k *jwtKeys) Rotate(key string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	keys := [][]byte{[]byte(key)}
+	for _, old := range k.keys {
+		if len(keys) < maxJWTKeys && string(old) != key {
+			keys = append(keys, old)
+		}
+	}
+	k.keys = keys
+}
+
+// Sign creates a signed JWT token with the given claims, using the current key.
+func (k *jwtKeys) Sign(claims map[string]interface{}) (string, error) {
+	k.mutex.RLock()
+	key := k.keys[0]
+	k.mutex.RUnlock()
+
+	t := jwt.New(jwt.SigningMethodHS256)
+	for c, v := range claims {
+		t.Claims[c] = v
+	}
+	return t.SignedString(key)
+}
+
+// Parse verifies the given JWT token with any of the keys and returns the claims.
+// current is true if the token is signed with the current key.
+func (k *jwtKeys) Parse(token string) (claims map[string]interface{}, current bool, err error) {
+	k.mutex.RLock()
+	keys := k.keys
+	k.mutex.RUnlock()
+
+	for i, key := range keys {
+		jt, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+			}
+			return key, nil
+		})
+
+		if err == nil && jt.Valid {
+			return jt.Claims, i == 0, nil
+		}
+		if verr, ok := err.(*jwt.ValidationError); !ok || verr.Errors&jwt.ValidationErrorSignatureInvalid == 0 {
+			// token is malformed or expired so no need to try the other keys
+			return nil, false, err
+		}
+	}
+
+	return nil, false, errors.New("signature is invalid")
+}
+
+// jwtAuth is JSON Web Token authentication middleware using HMAC.
+// If successful, "userid", "role", and "sid" (if any) claims, and the device name (if given) are stored in the session.
+// If unsuccessful, or if the token is revoked, connection is closed right away. Both outcomes are recorded to the audit log.
+func jwtAuth(keys *jwtKeys, db *data.DB, au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		// if user is already authenticated
+		if _, ok := ctx.Conn.Session.GetOk("userid"); ok {
+			return ctx.Next()
+		}
+
+		// if user is not authenticated.. check the JWT token
+		var t tokenContainer
+		if err := ctx.Params(&t); err != nil {
+			au.failure(ctx.Conn, models.AuditLogin, "malformed token", map[string]string{"method": "jwt"})
+			ctx.Conn.Close()
+			return err
+		}
+
+		claims, userID, err := verifyFirstFactor(keys, *db, t.Token)
+		if err != nil {
+			au.failure(ctx.Conn, models.AuditLogin, err.Error(), map[string]string{"method": "jwt"})
+			ctx.Conn.Close()
+			return fmt.Errorf("auth: jwt: %v: %v: %v", err, ctx.Conn.RemoteAddr(), t.Token)
+		}
+
+		ctx.Conn.Session.Set("userid", userID)
+		if role, ok := claims["role"].(string); ok {
+			ctx.Conn.Session.Set("role", role)
+		}
+		if sid, ok := claims["sid"].(string); ok {
+			ctx.Conn.Session.Set("sid", sid)
+		}
+		if t.Device != "" {
+			ctx.Conn.Session.Set("device", t.Device)
+		}
+		au.record(ctx.Conn, models.AuditEntry{Action: models.AuditLogin, Success: true, Details: map[string]string{"method": "jwt"}})
+		authLog.Infof("jwt: client authenticated, user: %v, conn: %v, ip: %v", userID, ctx.Conn.ID, ctx.Conn.RemoteAddr())
+		return ctx.Next()
+	}
+}
+
+// verifyJWT verifies a JWT access token and returns its claims along with the ID of the user it is issued for.
+// Tokens of the users with two-factor authentication enabled are only accepted if they carry the "2fa" claim, which is only
+// issued upon auth.totp, so a stolen token cannot be used without the second factor outside the client connections.
+func verifyJWT(keys *jwtKeys, db data.DB, token string) (claims map[string]interface{}, userID string, err error) {
+	claims, userID, err = verifyFirstFactor(keys, db, token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if done, _ := claims["2fa"].(bool); !done {
+		t, ok, err := db.GetTOTP(userID)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get second factor: %v", err)
+		}
+		if ok && t.Confirmed {
+			return nil, "", errors.New("JWT authentication attempt without the second factor")
+		}
+	}
+
+	return claims, userID, nil
+}
+
+// verifyFirstFactor verifies a JWT access token regardless of the second factor of the user, which is left to the caller
+// (i.e. totpAuth middleware of the client connections), and returns its claims along with the ID of the user it is issued for.
+// Revoked tokens, the tokens without a user ID, and the tokens of the accounts scheduled for deletion are rejected.
+func verifyFirstFactor(keys *jwtKeys, db data.DB, token string) (claims map[string]interface{}, userID string, err error) {
+	claims, _, err = keys.Parse(token)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid JWT authentication attempt: %v", err)
+	}
+
+	if isRevoked(db, claims) {
+		return nil, "", errors.New("revoked JWT authentication attempt")
+	}
+
+	userID, ok := claims["userid"].(string)
+	if !ok || userID == "" {
+		return nil, "", errors.New("JWT token without user ID")
+	}
+
+	if u, ok := db.GetByID(userID); ok && !u.DeleteAt.IsZero() {
+		return nil, "", errors.New("JWT authentication attempt of an account scheduled for deletion")
+	}
+
+	return claims, userID, nil
+}
@@ -0,0 +1,35 @@
+package titan
+
+import "testing"
+
+func TestJWTKeyRotation(t *testing.T) {
+	k := newJWTKeys("key1")
+	t1, err := k.Sign(map[string]interface{}{"userid": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k.Rotate("key2")
+	t2, err := k.Sign(map[string]interface{}{"userid": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c, current, err := k.Parse(t1); err != nil || current || c["userid"] != "1" {
+		t.Fatalf("token signed with previous key should be accepted as non-current: %v, %v, %v", c, current, err)
+	}
+	if c, current, err := k.Parse(t2); err != nil || !current || c["userid"] != "2" {
+		t.Fatalf("token signed with current key should be accepted as current: %v, %v, %v", c, current, err)
+	}
+
+	for i := 0; i < maxJWTKeys; i++ {
+		k.Rotate(string(rune('a' + i)))
+	}
+	if _, _, err := k.Parse(t1); err == nil {
+		t.Fatal("token signed with a retired key was accepted")
+	}
+
+	if _, _, err := newJWTKeys("other").Parse(t2); err == nil {
+		t.Fatal("token signed with an unknown key was accepted")
+	}
+}
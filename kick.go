@@ -0,0 +1,26 @@
+package titan
+
+import "github.com/titan-x/titan/models"
+
+// Kick closes all the live connections of a user right away, i.e. for abuse handling or a forced credential reset, and returns
+// the number of connections closed. Requests sent through the connections but not yet acknowledged are put back in the user's queue
+// to be delivered once the user connects again, unless purge is set, in which case all the requests waiting to be delivered
+// to the user are dead-lettered instead, which can still be re-driven. Kicked user can connect again right away unless the user's
+// refresh tokens are revoked as well.
+func (s *Server) Kick(userID string, purge bool) (closed, purged int) {
+	for _, c := range s.presence.UserConns(userID) {
+		if err := c.Close(); err != nil {
+			connLog.Warnf("failed to close connection %v: %v", c.ID, err)
+		}
+		// detached from the queue right away instead of with the disconnect handler, so nothing else is sent through the connection
+		s.queue.RemoveConn(userID, c.ID)
+		closed++
+	}
+
+	if purge {
+		purged = s.queue.Purge(userID, models.DeadLetterPurged)
+	}
+
+	connLog.Infof("user %v is kicked, connections: %v, purged requests: %v", userID, closed, purged)
+	return closed, purged
+}
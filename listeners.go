@@ -0,0 +1,67 @@
+package titan
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync/atomic"
+
+	"github.com/titan-x/titan/log"
+)
+
+var listenerLog = log.Component("listener")
+
+// listenExtra starts serving the WebSocket connections at the given additional listeners, each in a separate goroutine.
+// Connections accepted from all the listeners share the routers, the sessions, and the queue with the main listener.
+func (s *Server) listenExtra(urls []*url.URL) error {
+	for _, u := range urls {
+		l, err := newListener(u, s.neptulon.TLSConfig())
+		if err != nil {
+			return err
+		}
+		s.extraListeners = append(s.extraListeners, l)
+
+		go func(l net.Listener, u *url.URL) {
+			if err := s.neptulon.Serve(l); err != nil && atomic.LoadInt32(&s.listening) == 1 {
+				listenerLog.Errorf("listener %v stopped: %v", u, err)
+			}
+		}(l, u)
+		listenerLog.Infof("listening at %v", u)
+	}
+	return nil
+}
+
+// newListener creates a listener for the given listener URL, wrapping it with TLS for wss scheme.
+// Stale socket files (i.e. left over by a crashed instance) are removed before listening at a unix socket.
+func newListener(u *url.URL, tlsConf *tls.Config) (net.Listener, error) {
+	switch u.Scheme {
+	case "ws":
+		l, err := net.Listen("tcp", u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("server: failed to create listener on network address %v: %v", u.Host, err)
+		}
+		return l, nil
+	case "wss":
+		if tlsConf == nil {
+			return nil, errors.New("server: wss listener requires tls to be enabled")
+		}
+		l, err := net.Listen("tcp", u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("server: failed to create listener on network address %v: %v", u.Host, err)
+		}
+		return tls.NewListener(l, tlsConf), nil
+	case "unix":
+		if fi, err := os.Stat(u.Path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(u.Path)
+		}
+		l, err := net.Listen("unix", u.Path)
+		if err != nil {
+			return nil, fmt.Errorf("server: failed to create listener on unix socket %v: %v", u.Path, err)
+		}
+		return l, nil
+	}
+	return nil, fmt.Errorf("server: unsupported listener: %v", u)
+}
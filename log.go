@@ -0,0 +1,43 @@
+package titan
+
+import (
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+)
+
+var reqLog = log.Component("request")
+
+// logRequest is an incoming request/outgoing response logger middleware.
+// Entries are tagged with the connection ID, request ID, route, and user ID (if authenticated).
+// Requests that fail with an error are logged at error level, and the rest at debug level.
+func logRequest(ctx *neptulon.ReqCtx) error {
+	err := ctx.Next()
+
+	f := log.Fields{"conn": ctx.Conn.ID, "req": ctx.ID, "route": ctx.Method}
+	if uid, ok := ctx.Conn.Session.GetOk("userid"); ok {
+		f["user"] = uid
+	}
+	l := reqLog.With(f)
+
+	if err != nil {
+		l.Errorf("request failed: %v", err)
+		return err
+	}
+
+	if l.Enabled(log.DebugLevel) {
+		var v interface{}
+		ctx.Params(&v)
+
+		var res interface{}
+		if res = ctx.Session.Get(middleware.CustResLogDataKey); res == nil {
+			res = ctx.Res
+			if res == nil {
+				res = ctx.Err
+			}
+		}
+		l.Debugf("in: \"%v\", out: \"%#v\"", v, res)
+	}
+
+	return nil
+}
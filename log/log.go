@@ -0,0 +1,203 @@
+// Package log provides leveled logging with optional JSON output and per-component fields.
+//
+// Text output is compatible with the standard library logger output, with the addition of log level and fields:
+//
+//	2016/05/20 15:04:05 INFO queue: message delivered conn=2a8f user=1
+//
+// JSON output has one JSON object per line:
+//
+//	{"component":"queue","conn":"2a8f","level":"info","msg":"message delivered","time":"2016-05-20T15:04:05.123Z","user":"1"}
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity level of a log entry.
+type Level int
+
+// Log levels in increasing order of severity.
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a log level name: debug, info, warn, or error.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("log: unknown log level: %v", name)
+}
+
+// Fields are key-value pairs to be attached to log entries.
+type Fields map[string]interface{}
+
+const componentKey = "component"
+
+// output is the log destination shared by a logger and all the loggers derived from it.
+type output struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+	json  bool
+}
+
+// Logger writes leveled log entries with a fixed set of fields.
+// Logger is safe for concurrent use.
+type Logger struct {
+	out    *output
+	fields Fields
+}
+
+// New creates a new logger writing to the given writer with info log level and text output.
+func New(w io.Writer) *Logger {
+	return &Logger{out: &output{w: w, level: InfoLevel}}
+}
+
+// SetOutput sets the destination for the logger and all the loggers derived from it.
+func (l *Logger) SetOutput(w io.Writer) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w = w
+}
+
+// SetLevel sets the minimum level of entries to be written, for the logger and all the loggers derived from it.
+func (l *Logger) SetLevel(level Level) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.level = level
+}
+
+// SetJSON enables or disables JSON output, for the logger and all the loggers derived from it.
+func (l *Logger) SetJSON(enabled bool) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.json = enabled
+}
+
+// Enabled returns true if entries with the given level are written.
+func (l *Logger) Enabled(level Level) bool {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return level >= l.out.level
+}
+
+// With creates a new logger which attaches the given fields to all entries, along with the fields of the parent logger.
+// Derived logger shares the output and settings of the parent logger.
+func (l *Logger) With(fields Fields) *Logger {
+	f := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		f[k] = v
+	}
+	for k, v := range fields {
+		f[k] = v
+	}
+	return &Logger{out: l.out, fields: f}
+}
+
+// Component creates a new logger for the given component name.
+// In text output, component name is used as the message prefix.
+func (l *Logger) Component(name string) *Logger {
+	return l.With(Fields{componentKey: name})
+}
+
+// Debugf writes a debug entry.
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.write(DebugLevel, format, v...)
+}
+
+// Infof writes an info entry.
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.write(InfoLevel, format, v...)
+}
+
+// Warnf writes a warning entry.
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.write(WarnLevel, format, v...)
+}
+
+// Errorf writes an error entry.
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.write(ErrorLevel, format, v...)
+}
+
+// Fatalf writes an error entry and exits the process with exit code 1.
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	l.write(ErrorLevel, format, v...)
+	os.Exit(1)
+}
+
+func (l *Logger) write(level Level, format string, v ...interface{}) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	if level < l.out.level {
+		return
+	}
+
+	now := time.Now()
+	msg := strings.TrimSuffix(fmt.Sprintf(format, v...), "\n")
+	var buf bytes.Buffer
+
+	if l.out.json {
+		e := make(map[string]interface{}, len(l.fields)+3)
+		for k, v := range l.fields {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			e[k] = v
+		}
+		e["time"] = now.Format(time.RFC3339Nano)
+		e["level"] = level.String()
+		e["msg"] = msg
+		if b, err := json.Marshal(e); err == nil {
+			buf.Write(b)
+		} else {
+			fmt.Fprintf(&buf, `{"time":%q,"level":%q,"msg":%q}`, now.Format(time.RFC3339Nano), level.String(), msg)
+		}
+	} else {
+		buf.WriteString(now.Format("2006/01/02 15:04:05 "))
+		buf.WriteString(strings.ToUpper(level.String()))
+		buf.WriteByte(' ')
+		if c, ok := l.fields[componentKey]; ok {
+			fmt.Fprintf(&buf, "%v: ", c)
+		}
+		buf.WriteString(msg)
+
+		keys := make([]string, 0, len(l.fields))
+		for k := range l.fields {
+			if k != componentKey {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&buf, " %v=%v", k, l.fields[k])
+		}
+	}
+
+	buf.WriteByte('\n')
+	l.out.w.Write(buf.Bytes())
+}
@@ -0,0 +1,56 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf)
+	cl := l.Component("queue").With(Fields{"user": "1", "conn": "abc"})
+
+	cl.Debugf("not written")
+	cl.Infof("message delivered: %v", 5)
+
+	out := buf.String()
+	if strings.Contains(out, "not written") {
+		t.Fatalf("debug entry was written with info log level: %v", out)
+	}
+	if !strings.HasSuffix(out, "INFO queue: message delivered: 5 conn=abc user=1\n") {
+		t.Fatalf("unexpected log output: %v", out)
+	}
+
+	buf.Reset()
+	l.SetLevel(DebugLevel)
+	cl.Debugf("written")
+	if !strings.Contains(buf.String(), "DEBUG queue: written") {
+		t.Fatalf("level change was not applied to derived logger: %v", buf.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf)
+	l.SetJSON(true)
+	l.Component("redis").With(Fields{"user": "1"}).Warnf("reconnecting")
+
+	var e map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("invalid json output: %v: %v", buf.String(), err)
+	}
+	if e["level"] != "warn" || e["msg"] != "reconnecting" || e["component"] != "redis" || e["user"] != "1" || e["time"] == nil {
+		t.Fatalf("unexpected json entry: %+v", e)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("WARN"); err != nil || l != WarnLevel {
+		t.Fatalf("expected warn level, got: %v, %v", l, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Fatal("expected error for unknown log level")
+	}
+}
@@ -0,0 +1,58 @@
+package log
+
+import (
+	"io"
+	"os"
+)
+
+var std = New(os.Stderr)
+
+// SetOutput sets the destination for the standard logger.
+func SetOutput(w io.Writer) {
+	std.SetOutput(w)
+}
+
+// SetLevel sets the minimum level of entries to be written by the standard logger.
+func SetLevel(level Level) {
+	std.SetLevel(level)
+}
+
+// SetJSON enables or disables JSON output for the standard logger.
+func SetJSON(enabled bool) {
+	std.SetJSON(enabled)
+}
+
+// With creates a new logger derived from the standard logger with the given fields.
+func With(fields Fields) *Logger {
+	return std.With(fields)
+}
+
+// Component creates a new logger derived from the standard logger for the given component name.
+func Component(name string) *Logger {
+	return std.Component(name)
+}
+
+// Debugf writes a debug entry with the standard logger.
+func Debugf(format string, v ...interface{}) {
+	std.Debugf(format, v...)
+}
+
+// Infof writes an info entry with the standard logger.
+func Infof(format string, v ...interface{}) {
+	std.Infof(format, v...)
+}
+
+// Warnf writes a warning entry with the standard logger.
+func Warnf(format string, v ...interface{}) {
+	std.Warnf(format, v...)
+}
+
+// Errorf writes an error entry with the standard logger.
+func Errorf(format string, v ...interface{}) {
+	std.Errorf(format, v...)
+}
+
+// Fatalf writes an error entry with the standard logger and exits the process with exit code 1.
+func Fatalf(format string, v ...interface{}) {
+	std.Fatalf(format, v...)
+}
@@ -0,0 +1,329 @@
+package titan
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/neptulon"
+	"golang.org/x/net/websocket"
+)
+
+var pollLog = log.Component("poll")
+
+// maxPollBacklog is the maximum number of messages to buffer for a long-polling session between the poll requests.
+// Sessions falling behind further are closed, same as a WebSocket connection with a full send buffer.
+const maxPollBacklog = 1000
+
+// longPoll is an HTTP long-polling transport for the clients behind networks that block WebSocket connections.
+// Each session is bridged to an in-process WebSocket connection to the server, so it shares the same routes, middleware,
+// session, and queue semantics with the WebSocket clients:
+//
+//	POST /poll?session=<token> with a JSON-RPC message or an array of them in the body, sends the messages.
+//	Without a session token, a new session is created. Responds with {"session": "<token>"}.
+//
+//	GET /poll?session=<token> waits up to the hold timeout for the messages from the server (responses and requests),
+//	and responds with an array of them, which is empty if none arrived in time.
+//
+// Session token is the only way to resume a session across poll requests. Sessions which are not polled for a while
+// are closed, just like a dropped WebSocket connection. Unknown or closed sessions are responded with 404.
+type longPoll struct {
+	server *neptulon.Server
+	hold   time.Duration
+
+	mutex    sync.Mutex
+	sessions map[string]*pollSession
+	done     chan struct{}
+}
+
+type pollSession struct {
+	id       string
+	ws       *websocket.Conn // client end of the bridged connection
+	msgs     chan json.RawMessage
+	closed   chan struct{}
+	once     sync.Once
+	mutex    sync.Mutex
+	lastPoll time.Time
+}
+
+// newLongPoll creates a long-polling transport for the given server, with the given hold timeout for GET requests.
+func newLongPoll(server *neptulon.Server, hold time.Duration) *longPoll {
+	lp := &longPoll{server: server, hold: hold, sessions: make(map[string]*pollSession), done: make(chan struct{})}
+	go lp.expire()
+	return lp
+}
+
+func (lp *longPoll) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-lp.done:
+		http.Error(w, "server is closed", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	switch r.Method {
+	case "POST":
+		lp.send(w, r)
+	case "GET":
+		lp.receive(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (lp *longPoll) send(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	var msgs []json.RawMessage
+	if body = bytes.TrimSpace(body); len(body) != 0 && body[0] == '[' {
+		err = json.Unmarshal(body, &msgs)
+	} else if len(body) != 0 {
+		var m json.RawMessage
+		err = json.Unmarshal(body, &m)
+		msgs = []json.RawMessage{m}
+	}
+	if err != nil {
+		http.Error(w, "malformed json-rpc message", http.StatusBadRequest)
+		return
+	}
+
+	var s *pollSession
+	if id := r.URL.Query().Get("session"); id != "" {
+		if s = lp.session(id); s == nil {
+			http.NotFound(w, r)
+			return
+		}
+	} else if s, err = lp.open(r.RemoteAddr); err != nil {
+		pollLog.Errorf("failed to open session for %v: %v", r.RemoteAddr, err)
+		http.Error(w, "failed to open session", http.StatusInternalServerError)
+		return
+	}
+
+	s.touch()
+	for _, m := range msgs {
+		if err := websocket.Message.Send(s.ws, string(withoutCodecs(m))); err != nil {
+			lp.close(s)
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"session": s.id})
+}
+
+func (lp *longPoll) receive(w http.ResponseWriter, r *http.Request) {
+	s := lp.session(r.URL.Query().Get("session"))
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.touch()
+	defer s.touch()
+
+	msgs := []json.RawMessage{}
+	select {
+	case m := <-s.msgs:
+		msgs = append(msgs, m)
+	case <-s.closed:
+		if len(s.msgs) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+	case <-time.After(lp.hold):
+	}
+
+	// return everything else that is already in the backlog along with the first message
+	for drained := false; !drained && len(msgs) < maxPollBacklog; {
+		select {
+		case m := <-s.msgs:
+			msgs = append(msgs, m)
+		default:
+			drained = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msgs)
+}
+
+// open creates a new session bridged to the server with an in-process connection.
+func (lp *longPoll) open(remoteAddr string) (*pollSession, error) {
+	id, err := shortid.ID(256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session token: %v", err)
+	}
+
+	cconn, sconn := net.Pipe()
+	go lp.server.ServeConn(&pollConn{Conn: sconn, remoteAddr: pollAddr(remoteAddr)})
+
+	config, err := websocket.NewConfig("ws://titan/poll", "http://localhost")
+	if err != nil {
+		return nil, err
+	}
+	ws, err := websocket.NewClient(config, cconn)
+	if err != nil {
+		cconn.Close()
+		return nil, fmt.Errorf("failed to connect session to server: %v", err)
+	}
+
+	s := &pollSession{id: id, ws: ws, msgs: make(chan json.RawMessage, maxPollBacklog), closed: make(chan struct{}), lastPoll: time.Now()}
+	lp.mutex.Lock()
+	lp.sessions[id] = s
+	lp.mutex.Unlock()
+
+	go func() {
+		defer lp.close(s)
+		for {
+			var m []byte
+			if err := pollCodec.Receive(ws, &m); err != nil {
+				return
+			}
+			select {
+			case s.msgs <- json.RawMessage(m):
+			default:
+				pollLog.Warnf("session backlog is full, closing session of %v", remoteAddr)
+				return
+			}
+		}
+	}()
+
+	pollLog.Debugf("session opened for %v", remoteAddr)
+	return s, nil
+}
+
+func (lp *longPoll) session(id string) *pollSession {
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
+	return lp.sessions[id]
+}
+
+func (lp *longPoll) close(s *pollSession) {
+	s.once.Do(func() {
+		lp.mutex.Lock()
+		delete(lp.sessions, s.id)
+		lp.mutex.Unlock()
+
+		close(s.closed)
+		s.ws.Close()
+	})
+}
+
+// expire closes the sessions which are not polled within twice the hold timeout, until the transport is closed.
+func (lp *longPoll) expire() {
+	t := time.NewTicker(lp.hold)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-lp.done:
+			return
+		case <-t.C:
+		}
+
+		lp.mutex.Lock()
+		var expired []*pollSession
+		for _, s := range lp.sessions {
+			if s.idle() > lp.hold*2 {
+				expired = append(expired, s)
+			}
+		}
+		lp.mutex.Unlock()
+
+		for _, s := range expired {
+			lp.close(s)
+		}
+	}
+}
+
+// Close closes all the sessions and stops expiring them.
+func (lp *longPoll) Close() {
+	close(lp.done)
+
+	lp.mutex.Lock()
+	var sessions []*pollSession
+	for _, s := range lp.sessions {
+		sessions = append(sessions, s)
+	}
+	lp.mutex.Unlock()
+
+	for _, s := range sessions {
+		lp.close(s)
+	}
+}
+
+func (s *pollSession) touch() {
+	s.mutex.Lock()
+	s.lastPoll = time.Now()
+	s.mutex.Unlock()
+}
+
+func (s *pollSession) idle() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return time.Since(s.lastPoll)
+}
+
+// pollCodec receives the messages from the server end of a bridged connection, decompressing the compressed (binary) ones
+// in case the client negotiated compression, since the poll responses are always plain JSON.
+var pollCodec = websocket.Codec{Unmarshal: func(msg []byte, payloadType byte, v interface{}) error {
+	if payloadType == websocket.BinaryFrame {
+		b, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(msg)))
+		if err != nil {
+			return fmt.Errorf("failed to decompress message: %v", err)
+		}
+		msg = b
+	}
+	*v.(*[]byte) = msg
+	return nil
+}}
+
+// withoutCodecs removes the codec offer from a conn.caps request, since the poll requests and responses are always plain JSON.
+// Other messages, including the malformed ones, are returned as is.
+func withoutCodecs(m json.RawMessage) json.RawMessage {
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(m, &msg); err != nil || string(msg["method"]) != `"conn.caps"` {
+		return m
+	}
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(msg["params"], &params); err != nil || params["codecs"] == nil {
+		return m
+	}
+
+	delete(params, "codecs")
+	msg["params"], _ = json.Marshal(params)
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return m
+	}
+	return b
+}
+
+// pollConn is the server end of a bridged connection, which reports the remote address of the HTTP client
+// so the connection is logged and limited per IP as the WebSocket connections.
+type pollConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (c *pollConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+type pollAddr string
+
+func (a pollAddr) Network() string { return "tcp" }
+func (a pollAddr) String() string  { return string(a) }
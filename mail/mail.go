@@ -0,0 +1,58 @@
+// Package mail sends e-mails through pluggable providers: an SMTP server, or Amazon Simple Email Service (SES).
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"strings"
+	"time"
+)
+
+// Sender sends e-mails, i.e. the verification codes of the new accounts.
+type Sender interface {
+	Send(m Message) error
+}
+
+// Message is a plain text e-mail to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// validate checks the recipient address and rejects the line breaks in the headers, which could inject additional headers.
+func (m *Message) validate() error {
+	if _, err := netmail.ParseAddress(m.To); err != nil {
+		return fmt.Errorf("mail: invalid recipient address %q: %v", m.To, err)
+	}
+	if strings.ContainsAny(m.To+m.Subject, "\r\n") {
+		return fmt.Errorf("mail: line breaks are not allowed in the headers")
+	}
+	return nil
+}
+
+// format formats the message as a MIME e-mail with the given sender, with the subject encoded as UTF-8
+// and the body as quoted-printable UTF-8 text.
+func format(from string, m Message, date time.Time) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %v\r\n", from)
+	fmt.Fprintf(&b, "To: %v\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %v\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	w := quotedprintable.NewWriter(&b)
+	body := strings.Replace(strings.Replace(m.Body, "\r\n", "\n", -1), "\n", "\r\n", -1)
+	if _, err := w.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,132 @@
+package mail
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func TestFormat(t *testing.T) {
+	msg, err := format("titan@example.com", Message{To: "user@example.com", Subject: "Doğrulama", Body: "line 1\nline 2"}, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(msg)
+	for _, h := range []string{"From: titan@example.com\r\n", "To: user@example.com\r\n", "Subject: =?utf-8?q?", "Content-Transfer-Encoding: quoted-printable\r\n"} {
+		if !strings.Contains(s, h) {
+			t.Fatalf("expected e-mail to contain %q, got: %q", h, s)
+		}
+	}
+	if !strings.HasSuffix(s, "\r\n\r\nline 1\r\nline 2") {
+		t.Fatalf("expected body with CRLF line breaks, got: %q", s)
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	for _, m := range []Message{
+		{To: "user@example.com\r\nBcc: other@example.com", Subject: "hi"},
+		{To: "user@example.com", Subject: "hi\r\nBcc: other@example.com"},
+		{To: "not an address", Subject: "hi"},
+	} {
+		if err := m.validate(); err == nil {
+			t.Fatalf("expected invalid message: %+v", m)
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// minimal SMTP server accepting a single e-mail
+	got := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		c.Write([]byte("220 localhost\r\n"))
+		var data []string
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case inData && line == ".\r\n":
+				inData = false
+				got <- strings.Join(data, "")
+				c.Write([]byte("250 ok\r\n"))
+			case inData:
+				data = append(data, line)
+			case strings.HasPrefix(line, "DATA"):
+				inData = true
+				c.Write([]byte("354 go ahead\r\n"))
+			case strings.HasPrefix(line, "QUIT"):
+				c.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				c.Write([]byte("250 ok\r\n"))
+			}
+		}
+	}()
+
+	s := SMTP{Addr: l.Addr().String(), From: "titan@example.com"}
+	if err := s.Send(Message{To: "user@example.com", Subject: "Code", Body: "123456"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-got:
+		if !strings.Contains(m, "To: user@example.com\r\n") || !strings.Contains(m, "123456") {
+			t.Fatalf("unexpected e-mail: %q", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("e-mail was not received in time")
+	}
+}
+
+func TestSES(t *testing.T) {
+	var req sesSendReq
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/email/outbound-emails" || !strings.Contains(r.Header.Get("Authorization"), "/ses/aws4_request") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &req)
+		w.Write([]byte(`{"MessageId":"1"}`))
+	}))
+	defer srv.Close()
+
+	s, err := NewSES("eu-west-1", "titan@example.com", credentials.NewStaticCredentials("id", "secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Endpoint = srv.URL
+	if err := s.Send(Message{To: "user@example.com", Subject: "Code", Body: "123456"}); err != nil {
+		t.Fatal(err)
+	}
+	if req.FromEmailAddress != "titan@example.com" || len(req.Destination.ToAddresses) != 1 || req.Destination.ToAddresses[0] != "user@example.com" ||
+		req.Content.Simple.Subject.Data != "Code" || req.Content.Simple.Body.Text.Data != "123456" {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusForbidden) })
+	if err := s.Send(Message{To: "user@example.com", Subject: "Code", Body: "123456"}); err == nil {
+		t.Fatal("expected failed response to be returned as an error")
+	}
+}
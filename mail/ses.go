@@ -0,0 +1,108 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// SES sends e-mails through the Amazon Simple Email Service v2 API.
+// https://docs.aws.amazon.com/ses/latest/APIReference-V2/API_SendEmail.html
+type SES struct {
+	Endpoint string       // Service URL. Defaults to https://email.<region>.amazonaws.com
+	Region   string       // Region to sign the requests for.
+	From     string       // Sender address of the e-mails, which should be verified with SES.
+	Client   *http.Client // HTTP client to make the requests with.
+	signer   *v4.Signer
+}
+
+// NewSES creates a new SES sender sending the e-mails from the given address.
+// region = Optional region setting. Will overwrite AWS_REGION env var if available.
+// creds = Optional credentials. AWS SDK default credentials (i.e. AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY env vars) are used if nil.
+func NewSES(region, from string, creds *credentials.Credentials) (*SES, error) {
+	if creds == nil || region == "" {
+		conf := session.New().Config
+		if creds == nil {
+			creds = conf.Credentials
+		}
+		if region == "" && conf.Region != nil {
+			region = *conf.Region
+		}
+	}
+	if region == "" {
+		return nil, fmt.Errorf("mail: ses: region is required")
+	}
+
+	return &SES{
+		Endpoint: "https://email." + region + ".amazonaws.com",
+		Region:   region,
+		From:     from,
+		Client:   http.DefaultClient,
+		signer:   v4.NewSigner(creds),
+	}, nil
+}
+
+type sesContent struct {
+	Data    string
+	Charset string
+}
+
+type sesSendReq struct {
+	FromEmailAddress string
+	Destination      struct {
+		ToAddresses []string
+	}
+	Content struct {
+		Simple struct {
+			Subject sesContent
+			Body    struct {
+				Text sesContent
+			}
+		}
+	}
+}
+
+// Send sends the given e-mail.
+func (s *SES) Send(m Message) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	var r sesSendReq
+	r.FromEmailAddress = s.From
+	r.Destination.ToAddresses = []string{m.To}
+	r.Content.Simple.Subject = sesContent{Data: m.Subject, Charset: "UTF-8"}
+	r.Content.Simple.Body.Text = sesContent{Data: m.Body, Charset: "UTF-8"}
+	body, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("mail: ses: failed to serialize request: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", s.Endpoint+"/v2/email/outbound-emails", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("mail: ses: failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if _, err := s.signer.Sign(req, bytes.NewReader(body), "ses", s.Region, time.Now()); err != nil {
+		return fmt.Errorf("mail: ses: failed to sign request: %v", err)
+	}
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("mail: ses: failed to send e-mail: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("mail: ses: failed to send e-mail: %v: %s", res.Status, b)
+	}
+	return nil
+}
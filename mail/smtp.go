@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTP sends e-mails through an SMTP server, i.e. a local relay or the SMTP interface of a mail provider.
+// Connections are upgraded to TLS with STARTTLS if the server supports it.
+type SMTP struct {
+	Addr     string // Server address formatted as host:port.
+	From     string // Sender address of the e-mails.
+	Username string // Optional username for PLAIN authentication, which is only done over TLS or to localhost.
+	Password string
+}
+
+// Send sends the given e-mail.
+func (s *SMTP) Send(m Message) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	msg, err := format(s.From, m, time.Now())
+	if err != nil {
+		return fmt.Errorf("mail: smtp: failed to format e-mail: %v", err)
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("mail: smtp: invalid server address %v: %v", s.Addr, err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	if err := smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, msg); err != nil {
+		return fmt.Errorf("mail: smtp: failed to send e-mail: %v", err)
+	}
+	return nil
+}
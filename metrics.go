@@ -0,0 +1,99 @@
+package titan
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/titan-x/titan/neptulon"
+)
+
+// routeMetrics is the number of requests, the number of failed requests, and the latency histogram of each route,
+// by method name (i.e. msg.send).
+var routeMetrics = expvar.NewMap("routes")
+
+// routeMetricsMutex serializes the creation of the metrics of the routes.
+var routeMetricsMutex sync.Mutex
+
+// latencyBuckets are the upper bounds of the buckets of the route latency histograms.
+var latencyBuckets = []time.Duration{
+	time.Millisecond * 5, time.Millisecond * 10, time.Millisecond * 25, time.Millisecond * 50, time.Millisecond * 100,
+	time.Millisecond * 250, time.Millisecond * 500, time.Second, time.Millisecond * 2500, time.Second * 5, time.Second * 10,
+}
+
+// routeStats are the metrics of a single route. It is exported as JSON, i.e.:
+//
+//	{"count": 12, "errors": 1, "latencySum": 0.128, "latency": {"0.005": 9, "0.01": 11, ..., "+Inf": 12}}
+//
+// with the latencies in seconds, and the histogram buckets being cumulative, as in the Prometheus histograms.
+type routeStats struct {
+	count   int64   // number of requests, accessed atomically
+	errors  int64   // number of requests failed with an error, accessed atomically
+	sum     int64   // total latency in nanoseconds, accessed atomically
+	buckets []int64 // number of requests in each latency bucket (non-cumulative), along with the +Inf bucket, accessed atomically
+}
+
+func newRouteStats() *routeStats {
+	return &routeStats{buckets: make([]int64, len(latencyBuckets)+1)}
+}
+
+// observe records a request which took the given time, and which failed if failed is set.
+func (r *routeStats) observe(d time.Duration, failed bool) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&r.buckets[i], 1)
+	atomic.AddInt64(&r.sum, int64(d))
+	if failed {
+		atomic.AddInt64(&r.errors, 1)
+	}
+	atomic.AddInt64(&r.count, 1)
+}
+
+// String implements expvar.Var.
+func (r *routeStats) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `{"count": %v, "errors": %v, "latencySum": %v, "latency": {`,
+		atomic.LoadInt64(&r.count), atomic.LoadInt64(&r.errors), time.Duration(atomic.LoadInt64(&r.sum)).Seconds())
+	var n int64
+	for i, le := range latencyBuckets {
+		n += atomic.LoadInt64(&r.buckets[i])
+		fmt.Fprintf(&b, `"%v": %v, `, le.Seconds(), n)
+	}
+	n += atomic.LoadInt64(&r.buckets[len(latencyBuckets)])
+	fmt.Fprintf(&b, `"+Inf": %v}}`, n)
+	return b.String()
+}
+
+// routeStatsFor returns the metrics of the given route, creating them on the first request to the route.
+func routeStatsFor(method string) *routeStats {
+	if v := routeMetrics.Get(method); v != nil {
+		return v.(*routeStats)
+	}
+	routeMetricsMutex.Lock()
+	defer routeMetricsMutex.Unlock()
+	if v := routeMetrics.Get(method); v != nil {
+		return v.(*routeStats)
+	}
+	r := newRouteStats()
+	routeMetrics.Set(method, r)
+	return r
+}
+
+// recordRoute is a middleware recording the count, latency, and errors of the requests to each route, including the time
+// spent in the authentication and the other middleware. Requests to the methods with no route are not recorded, so
+// the clients cannot grow the metrics with arbitrary method names.
+func (s *Server) recordRoute(ctx *neptulon.ReqCtx) error {
+	if !s.pubRouter.Handles(ctx.Method) && !s.privRouter.Handles(ctx.Method) {
+		return ctx.Next()
+	}
+
+	start := time.Now()
+	err := ctx.Next()
+	routeStatsFor(ctx.Method).observe(time.Since(start), err != nil || ctx.Err != nil)
+	return err
+}
@@ -0,0 +1,31 @@
+package titan
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRouteStats(t *testing.T) {
+	r := newRouteStats()
+	r.observe(time.Millisecond, false)
+	r.observe(time.Millisecond*5, false)
+	r.observe(time.Millisecond*20, true)
+	r.observe(time.Minute, true)
+
+	var s struct {
+		Count      int64            `json:"count"`
+		Errors     int64            `json:"errors"`
+		LatencySum float64          `json:"latencySum"`
+		Latency    map[string]int64 `json:"latency"`
+	}
+	if err := json.Unmarshal([]byte(r.String()), &s); err != nil {
+		t.Fatalf("invalid route stats JSON: %v: %v", err, r.String())
+	}
+	if s.Count != 4 || s.Errors != 2 || s.LatencySum != 60.026 {
+		t.Fatalf("unexpected route stats: %+v", s)
+	}
+	if len(s.Latency) != len(latencyBuckets)+1 || s.Latency["0.005"] != 2 || s.Latency["0.01"] != 2 || s.Latency["0.025"] != 3 || s.Latency["10"] != 3 || s.Latency["+Inf"] != 4 {
+		t.Fatalf("unexpected latency histogram: %+v", s.Latency)
+	}
+}
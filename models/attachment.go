@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// AttachmentRef is the reference to an uploaded attachment carried by the messages, so the recipients can download
+// and verify the content.
+type AttachmentRef struct {
+	ID         string      `json:"id"`
+	Size       int64       `json:"size"`                 // Size of the content in bytes.
+	MimeType   string      `json:"mimeType,omitempty"`   // MIME type of the content, as given by the uploader.
+	Checksum   string      `json:"checksum"`             // Hex encoded SHA-256 checksum of the content.
+	Thumbnails []Thumbnail `json:"thumbnails,omitempty"` // Thumbnails of image attachments, to render previews before downloading the content.
+}
+
+// Thumbnail is a downscaled preview of an image attachment, which fits in a square of the given size.
+type Thumbnail struct {
+	Size     int    `json:"size"`   // Size of the square the thumbnail fits in, in pixels, which is also used to download the thumbnail.
+	Width    int    `json:"width"`  // Width of the thumbnail in pixels.
+	Height   int    `json:"height"` // Height of the thumbnail in pixels.
+	MimeType string `json:"mimeType"`
+}
+
+// Attachment is the metadata of a file uploaded by a user, i.e. an image or a video to be sent with a message.
+// Content is kept in a blob store apart from the metadata.
+type Attachment struct {
+	ID            string      `json:"id"`
+	Owner         string      `json:"owner"` // ID of the uploader.
+	Size          int64       `json:"size"`
+	MimeType      string      `json:"mimeType,omitempty"`
+	Checksum      string      `json:"checksum"`
+	Uploaded      bool        `json:"uploaded"`                // Whether the content is uploaded and verified against the size and the checksum.
+	Conversations []string    `json:"conversations,omitempty"` // Conversations the attachment is sent to, whose participants can download it.
+	Thumbnails    []Thumbnail `json:"thumbnails,omitempty"`    // Thumbnails generated for image attachments after the content is uploaded.
+	Created       time.Time   `json:"created"`
+}
+
+// Ref returns the reference to the attachment to be carried by the messages.
+func (a *Attachment) Ref() *AttachmentRef {
+	return &AttachmentRef{ID: a.ID, Size: a.Size, MimeType: a.MimeType, Checksum: a.Checksum, Thumbnails: a.Thumbnails}
+}
+
+// Thumbnail returns the thumbnail of the attachment with the given size, if any.
+func (a *Attachment) Thumbnail(size int) (t Thumbnail, ok bool) {
+	for _, t := range a.Thumbnails {
+		if t.Size == size {
+			return t, true
+		}
+	}
+	return Thumbnail{}, false
+}
+
+// SharedIn reports whether the attachment is sent to the given conversation.
+func (a *Attachment) SharedIn(conversation string) bool {
+	for _, c := range a.Conversations {
+		if c == conversation {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// AuditEntry is a security relevant event recorded to the audit log, i.e. a login or an admin action.
+type AuditEntry struct {
+	ID         string            `json:"id"`
+	Time       time.Time         `json:"time"`
+	Action     string            `json:"action"`           // One of the Audit... constants, or the method of an admin request (i.e. admin.kick) or a service call (i.e. service.send).
+	UserID     string            `json:"userid,omitempty"` // User who performed the action, if known.
+	Device     string            `json:"device,omitempty"`
+	RemoteAddr string            `json:"remoteAddr,omitempty"`
+	Target     string            `json:"target,omitempty"` // User the action is performed on, if other than the user who performed it.
+	Success    bool              `json:"success"`
+	Details    map[string]string `json:"details,omitempty"` // Action specific details, i.e. the failure reason.
+}
+
+// Audit actions, along with the method of each admin request.
+const (
+	AuditLogin          = "auth.login"       // User authenticated. Details has the authentication method: jwt, cert, google, email, or totp.
+	AuditRegister       = "auth.register"    // Account is registered with an e-mail address, or a new verification code is sent to the address.
+	AuditPhone          = "phone.verify"     // Phone number is verified and bound to the account. Target has the user the number is moved from, if any.
+	AuditRefresh        = "auth.refresh"     // Access token is issued in exchange for a refresh token.
+	AuditRevoke         = "auth.revoke"      // Refresh token, or a device or session along with its refresh tokens is revoked.
+	AuditTOTPConfirm    = "totp.confirm"     // Two-factor authentication is enabled by confirming a TOTP enrollment.
+	AuditTOTPDisable    = "totp.disable"     // Two-factor authentication is disabled.
+	AuditCertEnroll     = "auth.cert.enroll" // Client certificate is issued for a device.
+	AuditAccountDelete  = "account.delete"   // User requested the deletion of the account.
+	AuditAccountRestore = "account.restore"  // User signed in again within the grace period, canceling the deletion.
+	AuditAccountDeleted = "account.deleted"  // Data of the account is deleted after the grace period.
+	AuditDebug          = "admin.debug"      // Admin user accessed the runtime debug endpoints. Details has the path.
+	AuditWebhook        = "webhook.send"     // Message is sent with the API key of a user through the webhook endpoint. Details has the message ID.
+	AuditBan            = "abuse.ban"        // Remote address is banned temporarily for too many offenses. Details has the reason of the last offense.
+)
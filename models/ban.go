@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// IPBan is a temporary ban of a remote IP address, during which the connections from the address are refused.
+type IPBan struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"` // Kind of the offense which triggered the ban: auth, malformed, or rateLimit.
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+}
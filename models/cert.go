@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// DeviceCert is a client certificate issued by the server CA for authenticating a user's device without a JWT token.
+type DeviceCert struct {
+	Cert    string    `json:"cert"` // PEM encoded certificate.
+	Key     string    `json:"key"`  // PEM encoded private key.
+	Expires time.Time `json:"expires"`
+}
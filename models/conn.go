@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// Conn is a live client connection of an authenticated user.
+type Conn struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"userid"`
+	Device       string    `json:"device,omitempty"` // Device name given by the client during authentication, if any.
+	RemoteAddr   string    `json:"remoteAddr"`
+	ConnectedAt  time.Time `json:"connectedAt"`
+	LastActivity time.Time `json:"lastActivity"`      // Time of the last message received from the client.
+	Current      bool      `json:"current,omitempty"` // Set for the connection the session.list request is made through.
+}
+
+// Stats is the connection, user, and queue size metrics of a server instance.
+type Stats struct {
+	Conns        int    `json:"conns"`
+	ConnShards   []int  `json:"connShards"`   // Connection counts of each shard of the connection map.
+	Users        int64  `json:"users"`        // Number of users with at least one connection.
+	QueueLength  int64  `json:"queueLength"`  // Total number of requests waiting to be delivered.
+	QueueExpired int64  `json:"queueExpired"` // Total number of requests dropped from the queue as they expired before being delivered.
+	Workers      int    `json:"workers"`      // Number of workers running the request handlers, zero if each request runs on its own goroutine.
+	WorkersBusy  int    `json:"workersBusy"`  // Number of workers running a request handler.
+	WorkerQueue  int    `json:"workerQueue"`  // Number of requests waiting for a free worker.
+	RequestsShed int64  `json:"requestsShed"` // Total number of requests rejected as the worker queue was full.
+	Goroutines   int    `json:"goroutines"`   // Number of goroutines of the server process.
+	HeapAlloc    uint64 `json:"heapAlloc"`    // Bytes of allocated heap objects.
+	Sys          uint64 `json:"sys"`          // Total bytes of memory obtained from the OS.
+	NumGC        uint32 `json:"numGC"`        // Number of completed GC cycles.
+	GCPauseTotal uint64 `json:"gcPauseTotal"` // Cumulative GC pause time in nanoseconds.
+}
+
+// Capabilities are the optional protocol features offered by a client with a conn.caps request right after connecting,
+// and the ones accepted by the server in the response.
+type Capabilities struct {
+	Compression          []string `json:"compression,omitempty"`          // Compression algorithms in the order of preference (i.e. "deflate"). Server responds with the chosen one, if any.
+	CompressionThreshold int      `json:"compressionThreshold,omitempty"` // Minimum size of the compressed messages, in bytes, chosen by the server.
+	Codecs               []string `json:"codecs,omitempty"`               // Wire formats in place of JSON in the order of preference (i.e. "msgpack"). Server responds with the chosen one, if any.
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DeadLetter is a queued request that could not be delivered, which is kept aside to be inspected and re-driven.
+type DeadLetter struct {
+	ID     string          `json:"id"`     // ID of the undelivered request.
+	UserID string          `json:"userid"` // Recipient of the request.
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Reason string          `json:"reason"` // Failure reason, as one of the DeadLetter... constants.
+	Time   time.Time       `json:"time"`   // Time the request was dead-lettered.
+}
+
+// Dead letter failure reasons.
+const (
+	DeadLetterExpired      = "expired"       // Request was not delivered within its TTL.
+	DeadLetterMaxAttempts  = "max attempts"  // Request could not be sent to or acknowledged by any of the recipient's connections after the max delivery attempts.
+	DeadLetterPurged       = "purged"        // Request was purged from the recipient's queue by an operator, i.e. when the recipient is kicked.
+	DeadLetterInvalidToken = "invalid token" // Request was waiting for an offline recipient whose push notification token was reported to be invalid, i.e. the app is uninstalled.
+)
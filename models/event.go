@@ -0,0 +1,35 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is a server event published for the external services and other server instances to subscribe to.
+type Event struct {
+	Type   string          `json:"type"`
+	UserID string          `json:"userid"` // ID of the user the event is about.
+	Time   time.Time       `json:"time"`
+	Data   json.RawMessage `json:"data,omitempty"` // Event type specific data, as listed along with the event types.
+}
+
+// AccountDeletion is the data of the account deletion events.
+type AccountDeletion struct {
+	DeleteAt time.Time `json:"deleteAt"` // Time the data of the account is (to be) deleted at.
+}
+
+// Event types.
+const (
+	EventUserConnected    = "user.connected"    // User came online. Data is Presence.
+	EventUserDisconnected = "user.disconnected" // User went offline. Data is Presence.
+	EventMsgQueued        = "msg.queued"        // Message is queued for delivery to the user. Data is Message.
+	EventMsgReceipt       = "msg.receipt"       // Delivery state of a message sent by the user has changed (i.e. delivered or read). Data is Receipt.
+
+	EventUserRegistered     = "user.registered"     // User signed in for the first time. Data is Profile.
+	EventDeviceUnregistered = "device.unregistered" // Registration ID of the user's device is removed as GCM reported the device to be unregistered. No data.
+	EventDeadLettered       = "queue.deadLettered"  // Request to the user could not be delivered and is dead-lettered. Data is DeadLetter.
+
+	EventAccountDeletionRequested = "account.deletionRequested" // User requested the deletion of the account. Data is AccountDeletion.
+	EventAccountDeletionCanceled  = "account.deletionCanceled"  // User signed in again within the grace period, canceling the deletion. No data.
+	EventAccountDeleted           = "account.deleted"           // Data of the account is deleted after the grace period. Data is AccountDeletion.
+)
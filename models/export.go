@@ -0,0 +1,18 @@
+package models
+
+// Export formats.
+const (
+	ExportJSON = "json" // Messages of the conversation as a JSON document.
+	ExportZip  = "zip"  // A zip archive of the JSON document along with the content of the attachments.
+)
+
+// Export is a downloadable export of the message history of a conversation, generated in the background.
+// Once generated, it is kept as an attachment of the user who requested it, and downloaded at the given URL.
+type Export struct {
+	ID           string `json:"id"`
+	Conversation string `json:"conversation"`
+	Format       string `json:"format"`
+	Size         int64  `json:"size,omitempty"`
+	URL          string `json:"url,omitempty"`   // URL to download the export at, relative to the server address unless absolute.
+	Error        string `json:"error,omitempty"` // Reason the export failed, if it did.
+}
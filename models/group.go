@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Group is a group conversation.
+type Group struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Owner   string    `json:"owner"`   // ID of the user who created the group.
+	Members []string  `json:"members"` // IDs of the member users, including the owner.
+	Created time.Time `json:"created"`
+}
+
+// IsMember returns true if the given user is a member of the group.
+func (g *Group) IsMember(userID string) bool {
+	for _, m := range g.Members {
+		if m == userID {
+			return true
+		}
+	}
+	return false
+}
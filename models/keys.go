@@ -0,0 +1,23 @@
+package models
+
+// PreKey is a public one-time prekey of a user, for establishing an end-to-end encrypted session (i.e. X3DH of Signal protocol).
+// Keys are opaque to the server, and are base64 encoded in JSON.
+type PreKey struct {
+	ID  int    `json:"id"` // Client assigned ID of the key.
+	Key []byte `json:"key"`
+}
+
+// SignedPreKey is a public medium-term prekey of a user, signed with the user's identity key.
+type SignedPreKey struct {
+	ID        int    `json:"id"` // Client assigned ID of the key.
+	Key       []byte `json:"key"`
+	Signature []byte `json:"signature"`
+}
+
+// KeyBundle is the public keys of a user for the senders to establish end-to-end encrypted sessions with.
+type KeyBundle struct {
+	UserID       string        `json:"userid"`
+	IdentityKey  []byte        `json:"identityKey"`
+	SignedPreKey *SignedPreKey `json:"signedPreKey"`
+	PreKeys      []PreKey      `json:"preKeys,omitempty"` // One-time prekeys. Bundles retrieved by the senders carry at most one.
+}
@@ -1,11 +1,90 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // Message is a chat message.
 type Message struct {
-	From    string    `json:"from,omitempty"`
-	To      string    `json:"to"`
-	Time    time.Time `json:"time"`
-	Message string    `json:"message"`
+	ID           string         `json:"id,omitempty"`
+	ClientID     string         `json:"clientId,omitempty"` // Client generated ID of a message to be sent, which deduplicates the retries. Not delivered to the recipient.
+	From         string         `json:"from,omitempty"`
+	To           string         `json:"to"`
+	Group        string         `json:"group,omitempty"`        // Group ID if this is a group message.
+	Topic        string         `json:"topic,omitempty"`        // Topic name if this is a message published to a topic.
+	Conversation string         `json:"conversation,omitempty"` // Conversation ID, as given by DirectConversation, GroupConversation, or TopicConversation.
+	Time         time.Time      `json:"time"`
+	Message      string         `json:"message"`
+	Attachment   *AttachmentRef `json:"attachment,omitempty"` // Attachment uploaded beforehand by the sender, if any.
+	State        string         `json:"state,omitempty"`      // Latest delivery state of the message, if known.
+	Encrypted    bool           `json:"encrypted,omitempty"`  // Message body is end-to-end encrypted, so it is relayed as is and not indexed for search.
+	Push         *PushOptions   `json:"push,omitempty"`       // Options of the push notifications for the offline recipients, given by the sender. Not delivered to the recipient.
+}
+
+// groupConvPrefix and topicConvPrefix are the prefixes of group and topic conversation IDs, which distinguish them from direct conversation IDs.
+const (
+	groupConvPrefix = "group:"
+	topicConvPrefix = "topic:"
+)
+
+// DirectConversation returns the conversation ID for the messages between the given two users.
+// Order of the users does not matter.
+func DirectConversation(user1, user2 string) string {
+	if user1 > user2 {
+		user1, user2 = user2, user1
+	}
+	return user1 + ":" + user2
+}
+
+// GroupConversation returns the conversation ID for the messages of the given group.
+func GroupConversation(groupID string) string {
+	return groupConvPrefix + groupID
+}
+
+// TopicConversation returns the conversation ID for the messages published to the given topic.
+func TopicConversation(topic string) string {
+	return topicConvPrefix + topic
+}
+
+// ParseConversation parses the given conversation ID into either a group ID or the IDs of the two users of a direct conversation.
+// ok is false if the conversation ID is malformed, or is of a topic, which has no fixed members.
+func ParseConversation(id string) (groupID string, users []string, ok bool) {
+	if strings.HasPrefix(id, topicConvPrefix) {
+		return "", nil, false
+	}
+	if strings.HasPrefix(id, groupConvPrefix) {
+		groupID = strings.TrimPrefix(id, groupConvPrefix)
+		return groupID, nil, groupID != ""
+	}
+
+	users = strings.Split(id, ":")
+	if len(users) != 2 || users[0] == "" || users[1] == "" {
+		return "", nil, false
+	}
+	return "", users, true
+}
+
+// Message delivery states, in the order of transition.
+const (
+	StateSent      = "sent"      // Message is accepted by the server and queued for delivery.
+	StateDelivered = "delivered" // Message is delivered to and acknowledged by the recipient.
+	StateRead      = "read"      // Message is read by the recipient.
+)
+
+// StateFailed is the state of a message which could not be saved or queued for delivery, along with the reason in Receipt.Error.
+const StateFailed = "failed"
+
+// Receipt denotes the latest delivery state of a message.
+// State is the furthest state reached by any of the recipient's devices while the per-device states are kept in Devices.
+type Receipt struct {
+	ID       string            `json:"id"`                 // Message ID.
+	ClientID string            `json:"clientId,omitempty"` // Client generated ID of the message given by the sender, if any.
+	From     string            `json:"from,omitempty"`
+	To       string            `json:"to"`
+	State    string            `json:"state"`
+	Time     time.Time         `json:"time"`
+	Device   string            `json:"device,omitempty"`  // Recipient device that caused the state transition, if any.
+	Devices  map[string]string `json:"devices,omitempty"` // Recipient device -> delivery state.
+	Error    string            `json:"error,omitempty"`   // Reason the message could not be sent, if in the failed state.
 }
@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+// Notice is a system notice broadcast by the administrators to all the online users.
+type Notice struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
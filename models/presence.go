@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Presence is the online status of a user.
+type Presence struct {
+	UserID   string    `json:"userid"`
+	Online   bool      `json:"online"`
+	LastSeen time.Time `json:"lastSeen,omitempty"` // Time the user was last online. Zero if the user was never seen online.
+}
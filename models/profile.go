@@ -0,0 +1,9 @@
+package models
+
+// Profile is the public profile of a user, visible to the users who have the user in their contacts.
+type Profile struct {
+	UserID string `json:"userid"`
+	Name   string `json:"name"`
+	Status string `json:"status,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+}
@@ -0,0 +1,16 @@
+package models
+
+// PushOptions are the options of the push notifications sent for a message to the recipients who are offline.
+// Unset options default to the server settings.
+type PushOptions struct {
+	Priority       string            `json:"priority,omitempty"`       // Delivery priority: high (wakes the device right away) or normal (may be delayed to save battery).
+	DelayWhileIdle *bool             `json:"delayWhileIdle,omitempty"` // Whether to hold the notification until the device is active. Only supported by GCM CCS.
+	Notification   *bool             `json:"notification,omitempty"`   // Whether to display a notification to the user along with the data, or to send the data only.
+	Data           map[string]string `json:"data,omitempty"`           // Custom keys to add to the data of the notification, i.e. to deep link into the app.
+}
+
+// possible PushOptions.Priority values
+const (
+	PushPriorityHigh   = "high"
+	PushPriorityNormal = "normal"
+)
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// RefreshToken is a long-lived token issued per device, to be exchanged for short-lived JWT access tokens.
+// Only the hash of the token is stored, which also serves as the ID.
+type RefreshToken struct {
+	ID      string    // Hex encoded SHA-256 hash of the token.
+	UserID  string    // ID of the user who the token was issued to.
+	Device  string    // Optional identifier of the device that the token was issued to.
+	Created time.Time // Time that the token was issued at.
+	Revoked bool      // Revoked tokens cannot be used to obtain new access tokens, and the access tokens issued with them are not accepted.
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Topic is a named publish/subscribe channel, created by the admins. Messages published to a topic are delivered to all of its subscribers.
+type Topic struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Created     time.Time `json:"created"`
+}
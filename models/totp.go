@@ -0,0 +1,19 @@
+package models
+
+// TOTP is the time-based one-time password (RFC 6238) second factor of a user, which is required upon authentication
+// once the enrollment is confirmed with a valid code.
+type TOTP struct {
+	UserID        string
+	Secret        string   // Base32 encoded shared secret, without padding.
+	Confirmed     bool     // Enrollment is confirmed with a valid code. Unconfirmed enrollments are not required upon authentication.
+	RecoveryCodes []string // Hex encoded SHA-256 hashes of the unused recovery codes, so the codes cannot be read from the database.
+	LastStep      int64    // Time step of the last accepted code, so the same code cannot be used twice.
+}
+
+// TOTPEnrollment is the shared secret of a new TOTP second factor to be added to an authenticator app, along with the
+// one-time recovery codes to authenticate with if the app is lost. Recovery codes are only returned upon the enrollment.
+type TOTPEnrollment struct {
+	Secret        string   `json:"secret"`        // Base32 encoded shared secret.
+	URI           string   `json:"uri"`           // otpauth:// URI of the secret, i.e. to be shown as a QR code.
+	RecoveryCodes []string `json:"recoveryCodes"` // Recovery codes, each of which can be used once in place of a TOTP code.
+}
@@ -0,0 +1,8 @@
+package models
+
+// Typing is a notification of a user starting or stopping typing in a conversation.
+type Typing struct {
+	Conversation string `json:"conversation"`
+	UserID       string `json:"userid,omitempty"` // ID of the typing user, which is set by the server.
+	Typing       bool   `json:"typing"`           // Whether the user started or stopped typing.
+}
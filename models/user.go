@@ -7,10 +7,27 @@ type User struct {
 	ID              string
 	Registered      time.Time
 	Email           string
-	PhoneNumber     string
+	PhoneNumber     string // Verified phone number in E.164 format, which the user can be discovered by from the address books of others.
 	GCMRegID        string
 	APNSDeviceToken string
 	Name            string
 	Picture         []byte
 	JWTToken        string
+	Status          string    // Status text shown to the contacts of the user.
+	Avatar          string    // Reference to the profile picture, i.e. an attachment ID or a URL.
+	DeleteAt        time.Time // Time the account is to be deleted at, if the user requested the deletion. Zero otherwise.
+	Unverified      bool      // Account is registered with an e-mail address which is not verified yet, so no tokens are issued for it.
+	Role            string    // Authorization role of the user, issued in the "role" claim of the JWT tokens. Empty for the regular users.
+}
+
+// Authorization roles of the users. Each role is granted the permissions of the roles before it.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Profile returns the public profile of the user.
+func (u *User) Profile() Profile {
+	return Profile{UserID: u.ID, Name: u.Name, Status: u.Status, Avatar: u.Avatar}
 }
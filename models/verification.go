@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Kinds of the verifications.
+const (
+	VerifyEmail = "email"
+	VerifyPhone = "phone"
+)
+
+// Verification is a pending verification of an e-mail address or a phone number of a user, with a one-time code sent to it.
+// Verifications are deleted once completed, as the verified state is kept on the user profile.
+type Verification struct {
+	Kind     string // Kind of the verification, i.e. VerifyEmail.
+	Target   string // Address or phone number being verified.
+	UserID   string
+	CodeHash string    // Hex encoded SHA-256 hash of the code, so the codes cannot be read from the database.
+	Sent     time.Time // Time the code was last sent at, for throttling the resends.
+	Expires  time.Time
+	Attempts int // Number of wrong codes tried.
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// WebhookDelivery is the outcome of delivering a server event to an outgoing webhook, as recorded to the webhook delivery log.
+type WebhookDelivery struct {
+	ID       string    `json:"id"` // Delivery ID, also sent to the webhook in the X-Titan-Delivery header.
+	URL      string    `json:"url"`
+	Event    string    `json:"event"`            // Type of the delivered event.
+	UserID   string    `json:"userid,omitempty"` // ID of the user the event is about.
+	Time     time.Time `json:"time"`             // Time of the last attempt.
+	Attempts int       `json:"attempts"`
+	Status   int       `json:"status,omitempty"` // HTTP status code of the response to the last attempt, if any.
+	Success  bool      `json:"success"`
+	Error    string    `json:"error,omitempty"` // Failure reason of the last attempt, if failed.
+}
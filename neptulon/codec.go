@@ -0,0 +1,326 @@
+package neptulon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Codec is an alternative wire format for the messages, in place of JSON. Messages are always handled as JSON by the connection
+// (i.e. request params and response results are read with encoding/json), so the handlers are not affected by the wire format
+// and a codec only needs to convert the messages from and to JSON. Messages encoded with a codec are sent as binary frames.
+type Codec interface {
+	Name() string
+	FromJSON(data []byte) ([]byte, error)
+	ToJSON(data []byte) ([]byte, error)
+}
+
+// Codecs lists the supported codecs in the order of preference.
+var Codecs = []Codec{MsgPack}
+
+// LookupCodec returns the supported codec with the given name, or nil if there is none.
+func LookupCodec(name string) Codec {
+	for _, c := range Codecs {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// MsgPack is the MessagePack (https://msgpack.org) codec. Integers are encoded in the smallest representation, floats as 64-bit,
+// and map keys in sorted order. Only the types which have a JSON equivalent are decoded (binary values are decoded as base64 strings).
+var MsgPack Codec = msgPack{}
+
+// SetCodec sets the codec to encode the outgoing messages with, i.e. after the peer accepts it. Nil switches back to JSON.
+// Compression is not applied to the messages encoded with a codec. Received text frames are always decoded as JSON.
+func (c *Conn) SetCodec(codec Codec) {
+	c.codec.Store(codecValue{codec})
+}
+
+func (c *Conn) getCodec() Codec {
+	v, _ := c.codec.Load().(codecValue)
+	return v.Codec
+}
+
+// codecValue wraps the codec as atomic.Value requires the values to be of the same concrete type.
+type codecValue struct {
+	Codec
+}
+
+type msgPack struct{}
+
+func (msgPack) Name() string {
+	return "msgpack"
+}
+
+func (msgPack) FromJSON(data []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := msgPackEncode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgPack) ToJSON(data []byte) ([]byte, error) {
+	d := msgPackDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("msgpack: %v", err)
+	}
+	if d.pos != len(d.data) {
+		return nil, errors.New("msgpack: trailing data after value")
+	}
+	return json.Marshal(v)
+}
+
+func msgPackEncode(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			msgPackInt(buf, i)
+		} else if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			buf.WriteByte(0xcf)
+			binary.Write(buf, binary.BigEndian, u)
+		} else if f, err := v.Float64(); err == nil {
+			buf.WriteByte(0xcb)
+			binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+		} else {
+			return fmt.Errorf("msgpack: invalid number: %v", v)
+		}
+	case string:
+		msgPackHeader(buf, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		buf.WriteString(v)
+	case []interface{}:
+		msgPackHeader(buf, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			if err := msgPackEncode(buf, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		msgPackHeader(buf, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			msgPackEncode(buf, k)
+			if err := msgPackEncode(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type: %T", v)
+	}
+	return nil
+}
+
+func msgPackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 127:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// msgPackHeader writes the type and length header of a string, array, or map with the given fixed and 8/16/32-bit length type codes.
+// Zero 8-bit type code means the type has no 8-bit length form.
+func msgPackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, t8, t16, t32 byte) {
+	switch {
+	case n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case t8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(t8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(t16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(t32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// maxMsgPackDepth limits the nesting of the decoded values to protect against stack exhaustion.
+const maxMsgPackDepth = 100
+
+type msgPackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *msgPackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errors.New("unexpected end of data")
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgPackDecoder) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (d *msgPackDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxMsgPackDepth {
+		return nil, errors.New("value is nested too deep")
+	}
+
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	t := b[0]
+	switch {
+	case t <= 0x7f:
+		return int64(t), nil
+	case t >= 0xe0:
+		return int64(int8(t)), nil
+	case t >= 0x80 && t <= 0x8f:
+		return d.decodeMap(int(t&0x0f), depth)
+	case t >= 0x90 && t <= 0x9f:
+		return d.decodeArray(int(t&0x0f), depth)
+	case t >= 0xa0 && t <= 0xbf:
+		return d.decodeString(int(t & 0x1f))
+	}
+
+	switch t {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6: // bin 8/16/32, decoded as []byte which is marshalled as base64 string
+		n, err := d.uint(1 << (t - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.next(int(n))
+	case 0xca:
+		u, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.uint(8)
+		return math.Float64frombits(u), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uint(1 << (t - 0xcc))
+	case 0xd0:
+		u, err := d.uint(1)
+		return int64(int8(u)), err
+	case 0xd1:
+		u, err := d.uint(2)
+		return int64(int16(u)), err
+	case 0xd2:
+		u, err := d.uint(4)
+		return int64(int32(u)), err
+	case 0xd3:
+		u, err := d.uint(8)
+		return int64(u), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (t - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (t - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (t - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(int(n), depth)
+	}
+	return nil, fmt.Errorf("unsupported type: 0x%x", t)
+}
+
+func (d *msgPackDecoder) decodeString(n int) (string, error) {
+	b, err := d.next(n)
+	return string(b), err
+}
+
+func (d *msgPackDecoder) decodeArray(n int, depth int) ([]interface{}, error) {
+	if n > len(d.data)-d.pos { // each element takes at least a byte
+		return nil, errors.New("unexpected end of data")
+	}
+	a := make([]interface{}, n)
+	for i := range a {
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+func (d *msgPackDecoder) decodeMap(n int, depth int) (map[string]interface{}, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errors.New("unexpected end of data")
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		ks, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("unsupported map key type: %T", k)
+		}
+		if m[ks], err = d.decode(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
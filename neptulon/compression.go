@@ -0,0 +1,109 @@
+package neptulon
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+
+	"golang.org/x/net/websocket"
+)
+
+// Deflate is the DEFLATE (RFC 1951) message compression algorithm.
+const Deflate = "deflate"
+
+// CompressionAlgorithms lists the supported message compression algorithms in the order of preference.
+var CompressionAlgorithms = []string{Deflate}
+
+// maxDecompressedSize limits the size of a decompressed message to protect against compression bombs.
+const maxDecompressedSize = 32 << 20
+
+// compression is the message compression setting of a connection.
+type compression struct {
+	algorithm string
+	threshold int
+}
+
+// SetCompression enables compression of the outgoing messages larger than or equal to the given threshold (in bytes)
+// with the given algorithm, i.e. after the peer accepts compression. Compressed messages are sent as binary frames
+// while the rest are sent as text frames as usual. Empty algorithm disables compression.
+// Received binary frames are always decompressed (unless a codec is set), with DEFLATE until an algorithm is set,
+// as the peer might start compressing as soon as it accepts an offer from this side.
+func (c *Conn) SetCompression(algorithm string, threshold int) error {
+	switch algorithm {
+	case "", Deflate:
+	default:
+		return fmt.Errorf("unsupported compression algorithm: %v", algorithm)
+	}
+
+	c.compression.Store(compression{algorithm: algorithm, threshold: threshold})
+	return nil
+}
+
+func (c *Conn) getCompression() compression {
+	comp, _ := c.compression.Load().(compression)
+	return comp
+}
+
+// compress writes the DEFLATE compressed data into dst.
+func compress(dst *bytes.Buffer, data []byte) error {
+	w := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(w)
+
+	w.Reset(dst)
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// decompress writes the DEFLATE decompressed data into dst.
+func decompress(dst *bytes.Buffer, data []byte) error {
+	r := flateReaderPool.Get().(io.ReadCloser)
+	defer flateReaderPool.Put(r)
+
+	if err := r.(flate.Resetter).Reset(bytes.NewReader(data), nil); err != nil {
+		return fmt.Errorf("failed to decompress message: %v", err)
+	}
+	n, err := dst.ReadFrom(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to decompress message: %v", err)
+	}
+	if n > maxDecompressedSize {
+		return fmt.Errorf("decompressed message exceeds %v bytes", maxDecompressedSize)
+	}
+	return nil
+}
+
+// frame is a raw WebSocket data frame.
+type frame struct {
+	data   []byte
+	binary bool
+}
+
+// frameCodec receives raw WebSocket frames along with their types, and sends frames as is, []byte as binary frames
+// and string as text frames.
+var frameCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		switch data := v.(type) {
+		case frame:
+			if data.binary {
+				return data.data, websocket.BinaryFrame, nil
+			}
+			return data.data, websocket.TextFrame, nil
+		case []byte:
+			return data, websocket.BinaryFrame, nil
+		case string:
+			return []byte(data), websocket.TextFrame, nil
+		}
+		return nil, 0, websocket.ErrNotSupported
+	},
+	Unmarshal: func(msg []byte, payloadType byte, v interface{}) error {
+		f, ok := v.(*frame)
+		if !ok {
+			return websocket.ErrNotSupported
+		}
+		f.data, f.binary = msg, payloadType == websocket.BinaryFrame
+		return nil
+	},
+}
@@ -0,0 +1,535 @@
+package neptulon
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/cmap"
+
+	"golang.org/x/net/websocket"
+)
+
+var recvCounter = expvar.NewInt("receivers")
+var reqCounter = expvar.NewInt("requests")
+var resCounter = expvar.NewInt("responses")
+
+// Conn is a client connection.
+type Conn struct {
+	lastRead       int64 // time of the last frame received from the peer in Unix nanoseconds, accessed atomically (first for 64-bit alignment)
+	lastMessage    int64 // time of the last message (data frame) received from the peer in Unix nanoseconds, accessed atomically
+	connectedAt    time.Time
+	ID             string     // Randomly generated unique client connection ID.
+	Session        *cmap.CMap // Thread-safe data store for storing arbitrary data for this connection session.
+	middleware     []func(ctx *ReqCtx) error
+	resRoutes      *cmap.CMap     // message ID (string) -> handler func(ctx *ResCtx) error : expected responses for requests that we've sent
+	ws             atomic.Value   // -> *websocket.Conn
+	compression    atomic.Value   // -> compression
+	codec          atomic.Value   // -> codecValue
+	wg             sync.WaitGroup // incremented by one per goroutine created by conn
+	writeMutex     sync.Mutex     // serializes the writes so each gets its own write deadline
+	outbox         chan frame     // frames waiting to be written by the writer goroutine, nil if the writes are synchronous
+	closing        chan struct{}  // closed upon Close, so the writer goroutine flushes the outbox and closes the connection
+	closeOnce      sync.Once
+	writeBuffer    int
+	pool           *WorkerPool // runs the request handlers, or nil to run each on its own goroutine
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	idleTimeout    time.Duration
+	pingInterval   time.Duration
+	pongTimeout    time.Duration
+	isClientConn   bool
+	connected      atomic.Value // -> bool
+	errOnce        sync.Once    // records only the first error the connection is closed with
+	disconnHandler func(c *Conn)
+}
+
+// DisconnErrKey is the Session key the error a connection is closed with is recorded under, as a *ConnError.
+const DisconnErrKey = "neptulon.disconnErr"
+
+var (
+	// ErrUnknownMessage is the error a connection is closed with when the peer sends a message which is neither
+	// a JSON-RPC request nor a response.
+	ErrUnknownMessage = errors.New("received a message which is not a JSON-RPC request or response")
+	// ErrPongTimeout is the error a connection is closed with when the peer does not respond to a ping in time.
+	ErrPongTimeout = errors.New("no response to ping in time")
+	// ErrWriteBufferFull is the error a connection is closed with when a message is sent while the write buffer is full,
+	// i.e. when the peer stops reading, so the senders are not blocked and the buffered messages do not pile up in memory.
+	ErrWriteBufferFull = errors.New("write buffer is full")
+	// ErrFragmentation is the error a connection is closed with when the peer sends a continuation frame without a fragmented
+	// message to continue, or starts a new message before finishing the fragmented one.
+	ErrFragmentation = errors.New("received an out of order fragment of a message")
+)
+
+// ConnError is an error that caused a connection to be closed, along with the operation that failed: "receive" (reading a
+// frame), "decode" (decompressing or unmarshalling a message), "message" (handling a message), "request" (a request middleware
+// returned an error), "response" (a response handler returned an error), "send" (sending a message), "heartbeat" (the peer
+// did not respond to a ping), or "panic".
+type ConnError struct {
+	Op  string
+	Err error
+}
+
+func (e *ConnError) Error() string { return e.Op + ": " + e.Err.Error() }
+
+// Unwrap returns the underlying error.
+func (e *ConnError) Unwrap() error { return e.Err }
+
+// NewConn creates a new Conn object.
+func NewConn() (*Conn, error) {
+	id, err := shortid.UUID()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{
+		ID:             id,
+		Session:        cmap.New(),
+		resRoutes:      cmap.New(),
+		disconnHandler: func(c *Conn) {},
+	}
+	c.setTimeouts(DefaultListenerConfig)
+	c.connected.Store(false)
+	return c, nil
+}
+
+// SetDeadline sets the idle timeout (time to wait for the next message) and the write timeout of the connection, in seconds.
+// Default values are as in DefaultListenerConfig. Server connections use the timeouts of the listener they are accepted by.
+func (c *Conn) SetDeadline(seconds int) {
+	c.idleTimeout = time.Second * time.Duration(seconds)
+	c.writeTimeout = c.idleTimeout
+}
+
+// Middleware registers middleware to handle incoming request messages.
+func (c *Conn) Middleware(middleware ...Middleware) {
+	for _, m := range middleware {
+		c.MiddlewareFunc(m.Middleware)
+	}
+}
+
+// MiddlewareFunc registers middleware function to handle incoming request messages.
+func (c *Conn) MiddlewareFunc(middleware ...func(ctx *ReqCtx) error) {
+	c.middleware = append(c.middleware, middleware...)
+}
+
+// DisconnHandler registers a function to handle disconnection event.
+func (c *Conn) DisconnHandler(handler func(c *Conn)) {
+	c.disconnHandler = handler
+}
+
+// Connect connects to the given WebSocket server.
+// addr should be formatted as ws://host:port -or- wss://host:port (i.e. ws://127.0.0.1:3000 -or- wss://localhost:3000)
+func (c *Conn) Connect(addr string) error {
+	return c.ConnectTLS(addr, nil)
+}
+
+// ConnectTLS connects to the given WebSocket server using the given TLS configuration for wss:// addresses,
+// i.e. to present a client certificate or to trust a custom CA.
+func (c *Conn) ConnectTLS(addr string, tlsConfig *tls.Config) error {
+	config, err := websocket.NewConfig(addr, "http://localhost")
+	if err != nil {
+		return err
+	}
+	config.TlsConfig = tlsConfig
+
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return err
+	}
+	if err := c.setConn(ws); err != nil {
+		return err
+	}
+
+	c.isClientConn = true
+	c.wg.Add(1)
+	go func() {
+		defer recoverAndLog(c, &c.wg)
+		c.startReceive()
+	}()
+	time.Sleep(time.Millisecond) // give receive goroutine a few cycles to start
+	return nil
+}
+
+// ConnectedAt returns the time the connection is established.
+func (c *Conn) ConnectedAt() time.Time {
+	return c.connectedAt
+}
+
+// LastActivity returns the time of the last message received from the peer, or the time the connection is established
+// if no messages are received yet. Ping and pong frames do not count as activity.
+func (c *Conn) LastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastMessage))
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	ws := c.ws.Load().(*websocket.Conn)
+	if ws == nil {
+		return nil
+	}
+
+	// websocket package reports the Origin header as the remote address of the server side connections, which is often missing,
+	// so the address of the peer is taken from the upgrade request instead
+	if r := ws.Request(); r != nil {
+		if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+			return addr
+		}
+	}
+	return ws.RemoteAddr()
+}
+
+// ConnectionState returns the TLS connection state of an incoming connection, including the verified client certificates, if any.
+// ok is false if the connection is not over TLS.
+func (c *Conn) ConnectionState() (state tls.ConnectionState, ok bool) {
+	ws, _ := c.ws.Load().(*websocket.Conn)
+	if ws == nil || ws.Request() == nil || ws.Request().TLS == nil {
+		return tls.ConnectionState{}, false
+	}
+
+	return *ws.Request().TLS, true
+}
+
+// SendRequest sends a JSON-RPC request through the connection with an auto generated request ID.
+// resHandler is called when a response is returned.
+func (c *Conn) SendRequest(method string, params interface{}, resHandler func(res *ResCtx) error) (reqID string, err error) {
+	id, err := shortid.UUID()
+	if err != nil {
+		return "", err
+	}
+
+	// register the handler before sending, as the response can arrive before send returns
+	req := request{ID: id, Method: method, Params: params}
+	c.resRoutes.Set(req.ID, resHandler)
+	if err = c.send(req); err != nil {
+		c.resRoutes.Delete(req.ID)
+		return "", err
+	}
+
+	return id, nil
+}
+
+// SendRequestArr sends a JSON-RPC request through the connection, with array params and auto generated request ID.
+// resHandler is called when a response is returned.
+func (c *Conn) SendRequestArr(method string, resHandler func(res *ResCtx) error, params ...interface{}) (reqID string, err error) {
+	return c.SendRequest(method, params, resHandler)
+}
+
+// Close closes the connection. Messages waiting in the write buffer, if any, are written within the write timeout before
+// the connection is closed, so a response sent right before closing the connection is not lost.
+func (c *Conn) Close() error {
+	c.connected.Store(false)
+	if c.outbox != nil {
+		c.closeOnce.Do(func() { close(c.closing) })
+		return nil
+	}
+	ws := c.ws.Load().(*websocket.Conn)
+	if ws != nil {
+		ws.Close()
+	}
+	return nil
+}
+
+// abort closes the connection right away, discarding the messages waiting in the write buffer and interrupting the write
+// in progress, if any.
+func (c *Conn) abort() {
+	c.connected.Store(false)
+	if c.outbox != nil {
+		c.closeOnce.Do(func() { close(c.closing) })
+	}
+	ws := c.ws.Load().(*websocket.Conn)
+	if ws != nil {
+		ws.SetWriteDeadline(time.Now())
+		ws.Close()
+	}
+}
+
+// DisconnErr returns the error the connection is closed with, if any. It is nil while the connection is open, and if the
+// connection is closed by either side without an error, i.e. with Close or by the peer disconnecting.
+func (c *Conn) DisconnErr() *ConnError {
+	err, _ := c.Session.Get(DisconnErrKey).(*ConnError)
+	return err
+}
+
+// closeWithErr records the error in the Session and closes the connection right away, discarding the messages waiting in the
+// write buffer. Only the error that caused the connection to be closed is recorded, and not the ones that followed, i.e. failing
+// to send a response after the connection is closed.
+func (c *Conn) closeWithErr(op string, err error) {
+	if !c.connected.Load().(bool) {
+		return
+	}
+	c.errOnce.Do(func() {
+		c.Session.Set(DisconnErrKey, &ConnError{Op: op, Err: err})
+	})
+	log.Printf("conn: closing %v: %v: %v: %v", c.ID, c.RemoteAddr(), op, err)
+	c.abort()
+}
+
+// Wait waits for all message/connection handler goroutines to exit.
+// Returns error if wait timeouts (in seconds).
+func (c *Conn) Wait(timeout int) error {
+	done := make(chan bool)
+
+	go func() { c.wg.Wait(); done <- true }()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(time.Second * time.Duration(timeout)):
+		return errors.New("wait timed out")
+	}
+}
+
+// SendResponse sends a JSON-RPC response message through the connection.
+func (c *Conn) sendResponse(id string, result interface{}, err *ResError) error {
+	return c.send(response{ID: id, Result: result, Error: err})
+}
+
+// Send sends the given message through the connection.
+func (c *Conn) send(msg interface{}) error {
+	if !c.connected.Load().(bool) {
+		return errors.New("use of closed connection")
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := json.NewEncoder(buf).Encode(msg); err != nil {
+		return err
+	}
+	data := bytes.TrimSuffix(buf.Bytes(), []byte{'\n'})
+
+	ws := c.ws.Load().(*websocket.Conn)
+	if codec := c.getCodec(); codec != nil {
+		data, err := codec.FromJSON(data)
+		if err != nil {
+			return fmt.Errorf("failed to encode message with %v: %v", codec.Name(), err)
+		}
+		return c.sendFrame(ws, frame{data: data, binary: true})
+	}
+	if comp := c.getCompression(); comp.algorithm != "" && len(data) >= comp.threshold {
+		cbuf := getBuffer()
+		defer putBuffer(cbuf)
+		if err := compress(cbuf, data); err != nil {
+			return fmt.Errorf("failed to compress message: %v", err)
+		}
+		return c.sendFrame(ws, frame{data: cbuf.Bytes(), binary: true})
+	}
+	return c.sendFrame(ws, frame{data: data})
+}
+
+// sendFrame writes the frame to the connection, closing the connection if the write fails or times out,
+// as a partially written frame leaves the connection unusable. If the write buffer is enabled, the frame is put in the buffer
+// to be written by the writer goroutine instead, and the connection is closed if the buffer is full.
+func (c *Conn) sendFrame(ws *websocket.Conn, f frame) error {
+	if c.outbox == nil {
+		if err := c.writeFrame(ws, f, deadline(c.writeTimeout)); err != nil {
+			c.closeWithErr("send", err)
+			return err
+		}
+		return nil
+	}
+
+	// the data is pooled and goes back to the pool once send returns
+	f.data = append([]byte(nil), f.data...)
+	select {
+	case c.outbox <- f:
+		return nil
+	default:
+		c.closeWithErr("send", ErrWriteBufferFull)
+		return ErrWriteBufferFull
+	}
+}
+
+// startWriter writes the frames in the write buffer to the connection in order, each within the write timeout, until the
+// connection is closed. Frames left in the buffer upon Close are flushed within the write timeout before closing the connection.
+func (c *Conn) startWriter(ws *websocket.Conn) {
+	for {
+		select {
+		case f := <-c.outbox:
+			if err := c.writeFrame(ws, f, deadline(c.writeTimeout)); err != nil {
+				c.closeWithErr("send", err)
+				ws.Close()
+				return
+			}
+		case <-c.closing:
+			flush := deadline(c.writeTimeout)
+			for len(c.outbox) > 0 {
+				if err := c.writeFrame(ws, <-c.outbox, flush); err != nil {
+					break
+				}
+			}
+			ws.Close()
+			return
+		}
+	}
+}
+
+// Receive receives message from the connection.
+func (c *Conn) receive(msg *message) error {
+	if !c.connected.Load().(bool) {
+		return errors.New("use of closed connection")
+	}
+
+	// the buffers can go back to the pool once the message is unmarshalled, as json.RawMessage fields copy the data
+	buf := getBuffer()
+	defer putBuffer(buf)
+	payloadType, err := c.readFrame(c.ws.Load().(*websocket.Conn), buf)
+	if err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	if payloadType == websocket.BinaryFrame {
+		if codec := c.getCodec(); codec != nil {
+			if data, err = codec.ToJSON(data); err != nil {
+				return &ConnError{Op: "decode", Err: err}
+			}
+		} else {
+			dbuf := getBuffer()
+			defer putBuffer(dbuf)
+			if err := decompress(dbuf, data); err != nil {
+				return &ConnError{Op: "decode", Err: err}
+			}
+			data = dbuf.Bytes()
+		}
+	}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return &ConnError{Op: "decode", Err: err}
+	}
+	return nil
+}
+
+// Reuse an established websocket.Conn.
+func (c *Conn) setConn(ws *websocket.Conn) error {
+	c.connectedAt = time.Now()
+	atomic.StoreInt64(&c.lastMessage, c.connectedAt.UnixNano())
+	c.ws.Store(ws)
+	if c.writeBuffer > 0 {
+		c.outbox = make(chan frame, c.writeBuffer)
+		c.closing = make(chan struct{})
+		go c.startWriter(ws)
+	}
+	c.connected.Store(true)
+	// clear the handshake deadline, as the reads and writes set their own deadlines from now on
+	if err := ws.SetDeadline(time.Time{}); err != nil {
+		return fmt.Errorf("conn: error while setting websocket connection deadline: %v", err)
+	}
+	c.startHeartbeat()
+	return nil
+}
+
+// startReceive starts receiving messages. This method blocks and does not return until the connection is closed.
+func (c *Conn) startReceive() {
+	recvCounter.Add(1)
+	defer func() {
+		c.Close()
+		c.disconnHandler(c)
+		recvCounter.Add(-1)
+	}()
+
+	for {
+		var m message
+		err := c.receive(&m)
+		if err != nil {
+			// if we closed the connection
+			if !c.connected.Load().(bool) {
+				log.Printf("conn: closed %v: %v", c.ID, c.RemoteAddr())
+				break
+			}
+
+			// if peer closed the connection
+			if err == io.EOF {
+				log.Printf("conn: peer disconnected %v: %v", c.ID, c.RemoteAddr())
+				break
+			}
+
+			if ce, ok := err.(*ConnError); ok {
+				c.closeWithErr(ce.Op, ce.Err)
+			} else {
+				c.closeWithErr("receive", err)
+			}
+			break
+		}
+
+		// if the message is a request
+		if m.Method != "" {
+			reqCounter.Add(1)
+			c.wg.Add(1)
+			handle := func() {
+				defer reqCounter.Add(-1)
+				defer recoverAndLog(c, &c.wg)
+				ctx := newReqCtx(c, m.ID, m.Method, m.Params, c.middleware)
+				if err := ctx.Next(); err != nil {
+					c.closeWithErr("request", err)
+				}
+				if ctx.Res != nil || ctx.Err != nil {
+					if err := ctx.Conn.sendResponse(ctx.ID, ctx.Res, ctx.Err); err != nil {
+						c.closeWithErr("send", err)
+					}
+				}
+				if ctx.codec != nil {
+					c.codec.Store(*ctx.codec)
+				}
+			}
+
+			if c.pool == nil {
+				go handle()
+			} else if !c.pool.tryRun(handle) {
+				// shed the request right away, as the pool is overloaded
+				reqCounter.Add(-1)
+				c.wg.Done()
+				oerr := OverloadedError
+				if err := c.sendResponse(m.ID, nil, &oerr); err != nil {
+					c.closeWithErr("send", err)
+				}
+			}
+
+			continue
+		}
+
+		// if the message is not a JSON-RPC message
+		if m.ID == "" || (m.Result == nil && m.Error == nil) {
+			c.closeWithErr("message", ErrUnknownMessage)
+			break
+		}
+
+		// if the message is a response
+		if resHandler, ok := c.resRoutes.GetOk(m.ID); ok {
+			// removed before handling so a duplicate response is not handled twice
+			c.resRoutes.Delete(m.ID)
+			resCounter.Add(1)
+			c.wg.Add(1)
+			go func() {
+				defer resCounter.Add(-1)
+				defer recoverAndLog(c, &c.wg)
+				if err := resHandler.(func(ctx *ResCtx) error)(newResCtx(c, m.ID, m.Result, m.Error)); err != nil {
+					c.closeWithErr("response", err)
+				}
+			}()
+		} else {
+			// late or duplicate responses (i.e. to requests that are already redelivered and acknowledged) are expected
+			log.Printf("conn: dropping response to unknown request %v: %v: %v", c.ID, c.RemoteAddr(), m.ID)
+		}
+	}
+}
+
+func recoverAndLog(c *Conn, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if err := recover(); err != nil {
+		const size = 64 << 10
+		buf := make([]byte, size)
+		buf = buf[:runtime.Stack(buf, false)]
+		log.Printf("conn: panic handling response %v: %v\nstack trace: %s", c.RemoteAddr(), err, buf)
+		c.closeWithErr("panic", fmt.Errorf("%v", err))
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/neptulon/cmap"
+	"github.com/titan-x/titan/cmap"
 )
 
 // ReqCtx is the request context.
@@ -21,6 +21,7 @@ type ReqCtx struct {
 	params  json.RawMessage // request parameters
 	mw      []func(ctx *ReqCtx) error
 	mwIndex int
+	codec   *codecValue // codec to switch the connection to after the response is sent
 }
 
 func newReqCtx(conn *Conn, id, method string, params json.RawMessage, mw []func(ctx *ReqCtx) error) *ReqCtx {
@@ -48,6 +49,12 @@ func (ctx *ReqCtx) Params(v interface{}) error {
 	return nil
 }
 
+// SetCodec switches the connection to the given codec right after the response to this request is sent,
+// so the response to a codec negotiation request is still encoded in the format the peer expects. Nil switches back to JSON.
+func (ctx *ReqCtx) SetCodec(codec Codec) {
+	ctx.codec = &codecValue{codec}
+}
+
 // Next executes the next middleware in the middleware stack.
 func (ctx *ReqCtx) Next() error {
 	ctx.mwIndex++
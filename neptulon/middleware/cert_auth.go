@@ -1,6 +1,6 @@
 package middleware
 
-import "github.com/neptulon/neptulon"
+import "github.com/titan-x/titan/neptulon"
 
 // CertAtuh is TLS client-certificate authentication.
 // If successful, certificate common name will stored with the key "userid" in session.
@@ -1,6 +1,6 @@
 package middleware
 
-import "github.com/neptulon/neptulon"
+import "github.com/titan-x/titan/neptulon"
 
 // Echo sends incoming messages back as is.
 func Echo(ctx *neptulon.ReqCtx) error {
@@ -4,7 +4,7 @@ import (
 	"log"
 	"runtime"
 
-	"github.com/neptulon/neptulon"
+	"github.com/titan-x/titan/neptulon"
 )
 
 // Error is an error/panic handler middleware.
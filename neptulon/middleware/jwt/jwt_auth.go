@@ -5,7 +5,7 @@ import (
 	"log"
 
 	"github.com/dgrijalva/jwt-go"
-	"github.com/neptulon/neptulon"
+	"github.com/titan-x/titan/neptulon"
 )
 
 type token struct {
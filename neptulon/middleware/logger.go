@@ -3,7 +3,7 @@ package middleware
 import (
 	"log"
 
-	"github.com/neptulon/neptulon"
+	"github.com/titan-x/titan/neptulon"
 )
 
 // CustResLogDataKey is the key to be used in session data store to put any custom response log data.
@@ -1,6 +1,6 @@
 package middleware
 
-import "github.com/neptulon/neptulon"
+import "github.com/titan-x/titan/neptulon"
 
 // Router is a request routing middleware.
 type Router struct {
@@ -17,6 +17,12 @@ func (r *Router) Request(route string, handler func(ctx *neptulon.ReqCtx) error)
 	r.routes[route] = handler
 }
 
+// Handles checks if there is a route registered for the given method.
+func (r *Router) Handles(route string) bool {
+	_, ok := r.routes[route]
+	return ok
+}
+
 // Middleware is the Neptulon middleware method.
 func (r *Router) Middleware(ctx *neptulon.ReqCtx) error {
 	if handler, ok := r.routes[ctx.Method]; ok {
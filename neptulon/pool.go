@@ -0,0 +1,61 @@
+package neptulon
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+)
+
+// maxPooledBufferSize is the capacity limit of the buffers returned to the pool. Buffers grown by the occasional large message
+// are left to the garbage collector instead, so a few large messages do not pin large buffers for the lifetime of the process.
+const maxPooledBufferSize = 64 << 10
+
+// bufferPool holds the buffers used for reading, encoding, and (de)compressing messages, which would otherwise be allocated
+// per message.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// flateWriterPool and flateReaderPool hold the DEFLATE writers and readers, which are costly to allocate (a writer is ~600KB).
+var flateWriterPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression) // only fails with an invalid level
+		return w
+	},
+}
+
+var flateReaderPool = sync.Pool{
+	New: func() interface{} { return flate.NewReader(nil) },
+}
+
+// readerPool holds the read buffers of the connections served with Server.ServeConn. Write buffers are not pooled as
+// a connection might still be written to by a late sender after it is closed.
+var readerPool = sync.Pool{
+	New: func() interface{} { return bufio.NewReader(nil) },
+}
+
+func getReader(r io.Reader) *bufio.Reader {
+	br := readerPool.Get().(*bufio.Reader)
+	br.Reset(r)
+	return br
+}
+
+func putReader(br *bufio.Reader) {
+	br.Reset(nil)
+	readerPool.Put(br)
+}
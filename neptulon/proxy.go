@@ -0,0 +1,183 @@
+package neptulon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UseProxyProtocol makes the server read the PROXY protocol (v1 or v2) header sent by the load balancers at the given networks
+// ahead of the TLS handshake, so the connections are attributed to the address of the client instead of the load balancer
+// (i.e. for the connection limits and the logs). Connections from the load balancers without the header are closed, and the ones
+// from other addresses are served as is. Header is awaited for up to the handshake timeout. It should be called before listening.
+func (s *Server) UseProxyProtocol(trusted []*net.IPNet) {
+	s.proxyTrusted = trusted
+}
+
+// proxyListener reads the PROXY protocol header of the connections accepted from the trusted addresses.
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		for _, n := range l.trusted {
+			if n.Contains(addr.IP) {
+				return &proxyConn{Conn: c, timeout: l.timeout}, nil
+			}
+		}
+	}
+	return c, nil
+}
+
+// proxyConn is a connection which starts with a PROXY protocol header. Header is read upon the first read or the first
+// remote address lookup, both of which happen in the goroutine serving the connection, so a slow peer cannot block the listener.
+type proxyConn struct {
+	net.Conn
+	timeout  time.Duration
+	once     sync.Once
+	r        *bufio.Reader
+	addr     net.Addr // Address of the client, or nil if the header carries none (i.e. health checks of the load balancer).
+	err      error
+	mutex    sync.Mutex
+	deadline time.Time // Read deadline set by the user of the connection, to be restored after reading the header.
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(deadline(c.timeout))
+		c.r = bufio.NewReader(c.Conn)
+		if c.addr, c.err = readProxyHeader(c.r); c.err != nil {
+			c.err = fmt.Errorf("invalid proxy protocol header from %v: %v", c.Conn.RemoteAddr(), c.err)
+		}
+		c.mutex.Lock()
+		c.Conn.SetReadDeadline(c.deadline)
+		c.mutex.Unlock()
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.addr != nil {
+		return c.addr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+var proxySigV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header and returns the source address in it, if any.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	b, err := r.Peek(len(proxySigV2))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(b, proxySigV2) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(b, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+	return nil, errors.New("missing header")
+}
+
+// readProxyHeaderV1 reads a human readable header, i.e. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 { // maximum header length, including the CRLF
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("v1 header is too long")
+	}
+
+	f := strings.Fields(string(line))
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed v1 header: %q", line)
+	}
+	ip := net.ParseIP(f[2])
+	port, err := strconv.Atoi(f[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("malformed v1 header: %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyHeaderV2 reads a binary header.
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	var h [16]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, err
+	}
+	if h[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 header version: %v", h[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(h[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	// LOCAL command (i.e. health checks of the load balancer) and protocols other than TCP carry no client address
+	if h[12]&0xf == 0 {
+		return nil, nil
+	}
+	switch h[13] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("short v2 ipv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short v2 ipv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+	return nil, nil
+}
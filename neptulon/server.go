@@ -0,0 +1,525 @@
+// Package neptulon is a RPC framework with middleware support.
+package neptulon
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/titan-x/titan/cmap"
+
+	"golang.org/x/net/websocket"
+)
+
+var connsCounter = expvar.NewInt("conns")
+
+// Server is a Neptulon server.
+type Server struct {
+	addr           string
+	conns          *cmap.ShardedMap // conn ID -> *Conn
+	middleware     []func(ctx *ReqCtx) error
+	listener       net.Listener
+	httpServer     *http.Server
+	wsConfig       websocket.Config
+	cert           atomic.Value // *tls.Certificate presented to the clients
+	sniCerts       atomic.Value // map[string]*tls.Certificate presented to the clients requesting the hostname (or wildcard name) with SNI
+	wg             sync.WaitGroup
+	running        atomic.Value
+	disconnHandler func(c *Conn)
+	ipLimit        int            // max simultaneous connections per remote IP (0 = unlimited)
+	ipConns        map[string]int // remote IP -> live connection count
+	ipMutex        sync.Mutex
+	httpHandlers   map[string]http.Handler // pattern -> handler served along with the WebSocket endpoint
+	listenerConfig ListenerConfig
+	tlsPolicy      TLSPolicy
+	proxyTrusted   []*net.IPNet // networks of the load balancers sending the PROXY protocol header
+	acceptFilter   func(ip string) bool
+	pool           *WorkerPool // runs the request handlers, if set
+}
+
+// TLSPolicy restricts the TLS versions, cipher suites, and key exchange curves the clients can negotiate.
+// Zero values leave the defaults of crypto/tls in place.
+type TLSPolicy struct {
+	MinVersion       uint16        // Minimum TLS version, i.e. tls.VersionTLS12.
+	CipherSuites     []uint16      // Cipher suites allowed for TLS 1.2 and earlier. TLS 1.3 cipher suites are not configurable.
+	CurvePreferences []tls.CurveID // Key exchange curves in the order of preference.
+}
+
+// NewServer creates a new Neptulon server.
+// addr should be formatted as host:port (i.e. 127.0.0.1:3000)
+func NewServer(addr string) *Server {
+	s := &Server{
+		addr:           addr,
+		conns:          cmap.NewSharded(cmap.DefaultShards),
+		disconnHandler: func(c *Conn) {},
+		ipConns:        make(map[string]int),
+		httpHandlers:   make(map[string]http.Handler),
+		listenerConfig: DefaultListenerConfig,
+	}
+	s.running.Store(false)
+	return s
+}
+
+// UseTLS enables Transport Layer Security for the connections.
+// cert, key = Server certificate/private key pair.
+// clientCACert = Optional certificate for verifying client certificates.
+// All certificates/private keys are in PEM encoded X.509 format.
+func (s *Server) UseTLS(cert, privKey, clientCACert []byte) error {
+	if err := s.storeCert(cert, privKey); err != nil {
+		return err
+	}
+
+	s.wsConfig.TlsConfig = &tls.Config{GetCertificate: s.getCertificate}
+	s.applyTLSPolicy()
+
+	if clientCACert != nil {
+		pool := x509.NewCertPool()
+		ok := pool.AppendCertsFromPEM(clientCACert)
+		if !ok {
+			return errors.New("failed to parse the CA certificate")
+		}
+
+		s.wsConfig.TlsConfig.ClientCAs = pool
+		s.wsConfig.TlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return nil
+}
+
+// SetTLSPolicy sets the TLS versions, cipher suites, and key exchange curves the clients can negotiate,
+// for the current TLS configuration, if any, and the ones enabled later with UseTLS. It should be called before listening.
+func (s *Server) SetTLSPolicy(policy TLSPolicy) {
+	s.tlsPolicy = policy
+	if s.wsConfig.TlsConfig != nil {
+		s.applyTLSPolicy()
+	}
+}
+
+func (s *Server) applyTLSPolicy() {
+	c := s.wsConfig.TlsConfig
+	c.MinVersion, c.CipherSuites, c.CurvePreferences = s.tlsPolicy.MinVersion, s.tlsPolicy.CipherSuites, s.tlsPolicy.CurvePreferences
+}
+
+// TLSConfig returns the TLS configuration of the server, or nil if TLS is not enabled.
+// Configuration presents the current server certificate, so it can be used for the listeners of other transports.
+func (s *Server) TLSConfig() *tls.Config {
+	return s.wsConfig.TlsConfig
+}
+
+// SetCertificate replaces the server certificate/private key pair, i.e. upon certificate renewal.
+// New certificate is presented to the new connections while the existing connections are not affected.
+// TLS must already be enabled with UseTLS.
+func (s *Server) SetCertificate(cert, privKey []byte) error {
+	if s.wsConfig.TlsConfig == nil {
+		return errors.New("tls is not enabled")
+	}
+	return s.storeCert(cert, privKey)
+}
+
+// CertPair is a PEM encoded X.509 certificate/private key pair.
+type CertPair struct {
+	Cert, Key []byte
+}
+
+// SetSNICertificates sets the additional certificate/private key pairs, each presented to the clients which request one of
+// the hostnames of the certificate with SNI (Server Name Indication), so that the server can terminate TLS for multiple domains.
+// Hostnames are the DNS names of the certificate (or the common name, if there are none), which may be wildcards (i.e. *.nbusy.com).
+// Server certificate given with UseTLS is presented to the clients requesting other hostnames, or none.
+// Previous pairs are replaced, and the existing connections are not affected.
+func (s *Server) SetSNICertificates(pairs ...CertPair) error {
+	certs := make(map[string]*tls.Certificate)
+	for _, p := range pairs {
+		c, err := parseCert(p.Cert, p.Key)
+		if err != nil {
+			return err
+		}
+		names := c.Leaf.DNSNames
+		if len(names) == 0 {
+			names = []string{c.Leaf.Subject.CommonName}
+		}
+		for _, n := range names {
+			certs[strings.ToLower(n)] = c
+		}
+	}
+
+	s.sniCerts.Store(certs)
+	return nil
+}
+
+// getCertificate returns the certificate for the hostname requested by the client with SNI, or the server certificate.
+func (s *Server) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if certs, _ := s.sniCerts.Load().(map[string]*tls.Certificate); len(certs) != 0 && hello.ServerName != "" {
+		name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+		if c, ok := certs[name]; ok {
+			return c, nil
+		}
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			if c, ok := certs["*"+name[i:]]; ok {
+				return c, nil
+			}
+		}
+	}
+	return s.cert.Load().(*tls.Certificate), nil
+}
+
+func (s *Server) storeCert(cert, privKey []byte) error {
+	c, err := parseCert(cert, privKey)
+	if err != nil {
+		return err
+	}
+	s.cert.Store(c)
+	return nil
+}
+
+func parseCert(cert, privKey []byte) (*tls.Certificate, error) {
+	tlsCert, err := tls.X509KeyPair(cert, privKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the server certificate or the private key: %v", err)
+	}
+
+	c, _ := pem.Decode(cert)
+	if tlsCert.Leaf, err = x509.ParseCertificate(c.Bytes); err != nil {
+		return nil, fmt.Errorf("failed to parse the server certificate: %v", err)
+	}
+	return &tlsCert, nil
+}
+
+// ConnLimitPerIP limits the number of simultaneous connections from the same remote IP address.
+// Connections exceeding the limit are closed with a close frame right after the WebSocket handshake. Zero means no limit.
+func (s *Server) ConnLimitPerIP(limit int) {
+	s.ipMutex.Lock()
+	defer s.ipMutex.Unlock()
+	s.ipLimit = limit
+}
+
+// AcceptFilter registers a function which decides whether to serve the connections from the given remote IP, i.e. to refuse
+// the banned addresses. Refused connections are closed right after they are accepted, before the TLS and WebSocket handshakes,
+// or for the connections from the load balancers sending the PROXY protocol header, right after the WebSocket handshake.
+// It should be called before listening.
+func (s *Server) AcceptFilter(filter func(ip string) bool) {
+	s.acceptFilter = filter
+}
+
+// Middleware registers middleware to handle incoming request messages.
+func (s *Server) Middleware(middleware ...Middleware) {
+	for _, m := range middleware {
+		s.MiddlewareFunc(m.Middleware)
+	}
+}
+
+// MiddlewareFunc registers middleware function to handle incoming request messages.
+func (s *Server) MiddlewareFunc(middleware ...func(ctx *ReqCtx) error) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// DisconnHandler registers a function to handle client disconnection events.
+func (s *Server) DisconnHandler(handler func(c *Conn)) {
+	s.disconnHandler = handler
+}
+
+// HandleHTTP registers an HTTP handler for the given pattern, to be served on the same listener along with the WebSocket endpoint at "/".
+// This must be called before ListenAndServe.
+func (s *Server) HandleHTTP(pattern string, handler http.Handler) {
+	s.httpHandlers[pattern] = handler
+}
+
+// ServeConn serves a WebSocket connection over the given network connection, i.e. one end of a net.Pipe bridging another transport.
+// Connection is handled exactly as the ones accepted by the listener. This function blocks until the connection is closed.
+// If the connection is secured (i.e. *tls.Conn), its TLS connection state is available to the handlers with Conn.ConnectionState.
+func (s *Server) ServeConn(conn net.Conn) error {
+	return s.serveConn(conn, s.listenerConfig)
+}
+
+func (s *Server) serveConn(conn net.Conn, config ListenerConfig) error {
+	if err := conn.SetDeadline(deadline(config.HandshakeTimeout)); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to set websocket handshake deadline: %v", err)
+	}
+	br := getReader(conn)
+	defer putReader(br) // handler returns only after the connection is closed and the receive loop is done with the reader
+	buf := bufio.NewReadWriter(br, bufio.NewWriter(conn))
+	req, err := http.ReadRequest(buf.Reader)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to read websocket handshake request: %v", err)
+	}
+	req.RemoteAddr = conn.RemoteAddr().String()
+	if tc, ok := conn.(interface {
+		ConnectionState() tls.ConnectionState
+	}); ok {
+		state := tc.ConnectionState()
+		req.TLS = &state
+	}
+
+	s.wsServer(config).ServeHTTP(&hijackedConn{conn: conn, buf: buf}, req)
+	return nil
+}
+
+// Serve serves WebSocket connections accepted from the given listener, i.e. a listener of another transport carrying the same protocol.
+// Connections are handled exactly as the ones accepted by ListenAndServe, which must also be called to start the server.
+// This function blocks until the listener is closed.
+func (s *Server) Serve(l net.Listener) error {
+	return s.ServeConfig(l, s.listenerConfig)
+}
+
+// ServeConfig is like Serve, but with the given timeouts for the connections accepted from the listener, in place of the ones
+// set with SetListenerConfig.
+func (s *Server) ServeConfig(l net.Listener, config ListenerConfig) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || !s.running.Load().(bool) {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %v", err)
+		}
+		if !s.accepts(conn) {
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			if err := s.serveConn(conn, config); err != nil {
+				log.Printf("server: %v: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// ListenAndServe starts the Neptulon server. This function blocks until server is closed.
+func (s *Server) ListenAndServe() error {
+	mux := http.NewServeMux()
+	mux.Handle("/", s.wsServer(s.listenerConfig))
+	for p, h := range s.httpHandlers {
+		mux.Handle(p, h)
+	}
+
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to create TLS listener on network address %v with error: %v", s.addr, err)
+	}
+	if len(s.proxyTrusted) != 0 {
+		l = &proxyListener{Listener: l, trusted: s.proxyTrusted, timeout: s.listenerConfig.HandshakeTimeout}
+	}
+	if s.acceptFilter != nil {
+		l = &filterListener{Listener: l, server: s}
+	}
+	if s.wsConfig.TlsConfig != nil {
+		l = tls.NewListener(l, s.wsConfig.TlsConfig)
+	}
+	s.listener = l
+	s.httpServer = &http.Server{Handler: mux, ReadHeaderTimeout: s.listenerConfig.HandshakeTimeout}
+
+	log.Printf("server: started %v", s.addr)
+	s.running.Store(true)
+	err = s.httpServer.Serve(l)
+	if !s.running.Load().(bool) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) wsServer(config ListenerConfig) websocket.Server {
+	return websocket.Server{
+		Config:  s.wsConfig,
+		Handler: func(ws *websocket.Conn) { s.wsConnHandler(ws, config) },
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			s.wg.Add(1)                                  // todo: this needs to happen inside the gorotune executing the Start method and not the request goroutine or we'll miss some edge connections
+			config.Origin, _ = url.Parse(req.RemoteAddr) // we're interested in remote address and not origin header text
+			return nil
+		},
+	}
+}
+
+// hijackedConn is an http.ResponseWriter which is only good for being hijacked, for serving WebSocket handshakes over a given connection.
+type hijackedConn struct {
+	conn net.Conn
+	buf  *bufio.ReadWriter
+}
+
+func (h *hijackedConn) Header() http.Header         { return http.Header{} }
+func (h *hijackedConn) Write(b []byte) (int, error) { return 0, http.ErrHijacked }
+func (h *hijackedConn) WriteHeader(int)             {}
+func (h *hijackedConn) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, h.buf, nil
+}
+
+// SendRequest sends a JSON-RPC request through the connection denoted by the connection ID with an auto generated request ID.
+// resHandler is called when a response is returned.
+func (s *Server) SendRequest(connID string, method string, params interface{}, resHandler func(ctx *ResCtx) error) (reqID string, err error) {
+	if !s.running.Load().(bool) {
+		return "", errors.New("use of closed server")
+	}
+
+	if conn, ok := s.conns.GetOk(connID); ok {
+		reqID, err = conn.(*Conn).SendRequest(method, params, resHandler)
+		// todo: only log in debug mode?
+		log.Printf("server: send-request: connID: %v, reqID: %v, method: %v, params: %#v, err (if any): %v", connID, reqID, method, params, err)
+		return
+	}
+
+	return "", fmt.Errorf("connection with requested ID: %v does not exist", connID)
+}
+
+// ConnCount returns the number of active connections.
+func (s *Server) ConnCount() int {
+	return s.conns.Len()
+}
+
+// ConnShardLens returns the number of active connections in each shard of the connection map,
+// which can be used to monitor the distribution of the connections across shards.
+func (s *Server) ConnShardLens() []int {
+	return s.conns.ShardLens()
+}
+
+// SendRequestArr sends a JSON-RPC request through the connection denoted by the connection ID, with array params and auto generated request ID.
+// resHandler is called when a response is returned.
+func (s *Server) SendRequestArr(connID string, method string, resHandler func(ctx *ResCtx) error, params ...interface{}) (reqID string, err error) {
+	return s.SendRequest(connID, method, params, resHandler)
+}
+
+// Close closes the network listener and the active connections.
+func (s *Server) Close() error {
+	if !s.running.Load().(bool) {
+		return nil
+	}
+	s.running.Store(false)
+	// also closes the idle keep-alive connections of the plain HTTP handlers so they are not served after the server is stopped
+	err := s.httpServer.Close()
+
+	// close all active connections discarding any read/writes that is going on currently
+	s.conns.Range(func(c interface{}) {
+		c.(*Conn).abort()
+	})
+
+	if err != nil {
+		return fmt.Errorf("an error occured before or while stopping the server: %v", err)
+	}
+
+	s.wg.Wait()
+	if s.pool != nil {
+		s.pool.stop()
+		s.pool = nil
+	}
+	log.Printf("server: stopped %v", s.addr)
+	return nil
+}
+
+// Wait waits for all message/connection handler goroutines in all connections to exit.
+func (s *Server) Wait() {
+	s.conns.Range(func(c interface{}) {
+		c.(*Conn).Wait(180)
+	})
+}
+
+// wsHandler handles incoming websocket connections.
+func (s *Server) wsConnHandler(ws *websocket.Conn, config ListenerConfig) {
+	c, err := NewConn()
+	if err != nil {
+		log.Printf("server: error while accepting connection: %v", err)
+		return
+	}
+	defer recoverAndLog(c, &s.wg)
+
+	ip := remoteIP(ws)
+	if s.acceptFilter != nil && !s.acceptFilter(ip) {
+		log.Printf("server: connection from %v is refused", ip)
+		ws.Close()
+		return
+	}
+	if !s.addIPConn(ip) {
+		log.Printf("server: too many connections from %v, closing connection", ip)
+		ws.Close()
+		return
+	}
+	defer s.removeIPConn(ip)
+
+	c.MiddlewareFunc(s.middleware...)
+	c.setTimeouts(config)
+	c.pool = s.pool
+
+	s.conns.Set(c.ID, c)
+	connsCounter.Add(1)
+	c.setConn(ws)
+	log.Printf("server: client connected %v: %v", c.ID, c.RemoteAddr())
+	c.startReceive()
+	s.conns.Delete(c.ID)
+	connsCounter.Add(-1)
+	s.disconnHandler(c)
+}
+
+// filterListener closes the accepted connections refused by the accept filter of the server.
+type filterListener struct {
+	net.Listener
+	server *Server
+}
+
+func (l *filterListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil || l.server.accepts(c) {
+			return c, err
+		}
+		c.Close()
+	}
+}
+
+// accepts reports whether the given connection is accepted by the accept filter, if any. Connections with the PROXY protocol header
+// are accepted here, as reading the header would block the listener, and filtered once the WebSocket handshake is done.
+func (s *Server) accepts(c net.Conn) bool {
+	if s.acceptFilter == nil {
+		return true
+	}
+	if _, ok := c.(*proxyConn); ok {
+		return true
+	}
+	ra := c.RemoteAddr()
+	if ra == nil {
+		return true
+	}
+	addr := ra.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return s.acceptFilter(addr)
+}
+
+// addIPConn registers a new connection from the given IP, if the IP is not over the connection limit.
+func (s *Server) addIPConn(ip string) bool {
+	s.ipMutex.Lock()
+	defer s.ipMutex.Unlock()
+
+	if s.ipLimit > 0 && s.ipConns[ip] >= s.ipLimit {
+		return false
+	}
+	s.ipConns[ip]++
+	return true
+}
+
+func (s *Server) removeIPConn(ip string) {
+	s.ipMutex.Lock()
+	defer s.ipMutex.Unlock()
+
+	if s.ipConns[ip]--; s.ipConns[ip] <= 0 {
+		delete(s.ipConns, ip)
+	}
+}
+
+func remoteIP(ws *websocket.Conn) string {
+	addr := ws.Request().RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,208 @@
+package neptulon
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// ListenerConfig holds the timeouts and the write buffer size of the connections accepted by a listener. Zero value of a timeout
+// disables it, and zero write buffer makes the writes synchronous, so the senders wait for the writes to complete.
+type ListenerConfig struct {
+	HandshakeTimeout time.Duration // Time allowed for the WebSocket handshake after a connection is accepted.
+	ReadTimeout      time.Duration // Time allowed to read the rest of a message after it starts arriving.
+	WriteTimeout     time.Duration // Time allowed to write a message, so a slow client cannot block the senders.
+	IdleTimeout      time.Duration // Time to wait for the next message (or ping/pong frame) from the peer before closing the connection.
+	PingInterval     time.Duration // Time of silence from the peer after which a WebSocket ping frame is sent to check that it is still there.
+	PongTimeout      time.Duration // Time to wait for a pong (or any other frame) after a ping before closing the connection.
+	WriteBuffer      int           // Number of messages that can wait to be written, beyond which the peer is assumed to have stopped reading and the connection is closed.
+}
+
+// DefaultListenerConfig is the listener configuration used unless another one is set.
+var DefaultListenerConfig = ListenerConfig{
+	HandshakeTimeout: 10 * time.Second,
+	ReadTimeout:      30 * time.Second,
+	WriteTimeout:     30 * time.Second,
+	IdleTimeout:      300 * time.Second,
+	WriteBuffer:      256,
+}
+
+// SetListenerConfig sets the timeouts of the connections accepted by ListenAndServe, and by Serve and ServeConn.
+// Use ServeConfig to serve another listener with different timeouts.
+func (s *Server) SetListenerConfig(config ListenerConfig) {
+	s.listenerConfig = config
+}
+
+// setTimeouts sets the read, write, and idle timeouts, the heartbeat, and the write buffer of the connection.
+func (c *Conn) setTimeouts(config ListenerConfig) {
+	c.readTimeout, c.writeTimeout, c.idleTimeout = config.ReadTimeout, config.WriteTimeout, config.IdleTimeout
+	c.pingInterval, c.pongTimeout = config.PingInterval, config.PongTimeout
+	c.writeBuffer = config.WriteBuffer
+}
+
+// deadline returns the deadline for the given timeout starting now, or zero time for no deadline.
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// readFrame reads the next message from the connection into w, waiting up to the idle timeout for the message to start arriving,
+// and up to the read timeout for the rest of it, including the rest of its fragments if any. Ping and pong frames received
+// before the message restart the idle timeout.
+func (c *Conn) readFrame(ws *websocket.Conn, w io.Writer) (payloadType byte, err error) {
+	if err := ws.SetReadDeadline(deadline(c.idleTimeout)); err != nil {
+		return 0, err
+	}
+	var sw *startWriter
+	if c.readTimeout > 0 {
+		sw = &startWriter{w: w, start: func() { ws.SetReadDeadline(deadline(c.readTimeout)) }}
+		w = sw
+	}
+	payloadType, err = readFrame(ws, w, func() {
+		c.touch()
+		// control frames between the fragments of a message do not extend the read timeout
+		if sw == nil || !sw.started {
+			ws.SetReadDeadline(deadline(c.idleTimeout))
+		}
+	})
+	if err == nil {
+		c.touch()
+		atomic.StoreInt64(&c.lastMessage, atomic.LoadInt64(&c.lastRead))
+	}
+	return payloadType, err
+}
+
+// touch records that a frame is received from the peer.
+func (c *Conn) touch() {
+	atomic.StoreInt64(&c.lastRead, time.Now().UnixNano())
+}
+
+// startHeartbeat starts checking that the peer is still there, if ping interval is set.
+func (c *Conn) startHeartbeat() {
+	c.touch()
+	if c.pingInterval > 0 {
+		time.AfterFunc(c.pingInterval, c.heartbeat)
+	}
+}
+
+// heartbeat sends a ping frame to the peer if nothing is received from it for the ping interval, and closes the connection if
+// still nothing is received after the pong timeout. Half-open connections (i.e. of a mobile device that lost its network)
+// are detected this way, which would otherwise be left open until the idle timeout, or the TCP keep-alives, if any.
+func (c *Conn) heartbeat() {
+	if !c.connected.Load().(bool) {
+		return
+	}
+
+	silence := time.Since(time.Unix(0, atomic.LoadInt64(&c.lastRead)))
+	switch {
+	case silence < c.pingInterval:
+		time.AfterFunc(c.pingInterval-silence, c.heartbeat)
+	case silence < c.pingInterval+c.pongTimeout:
+		if err := c.ping(); err != nil {
+			c.closeWithErr("heartbeat", err)
+			return
+		}
+		time.AfterFunc(c.pingInterval+c.pongTimeout-silence, c.heartbeat)
+	default:
+		c.closeWithErr("heartbeat", ErrPongTimeout)
+	}
+}
+
+// ping writes a ping frame to the connection within the write timeout.
+func (c *Conn) ping() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	ws := c.ws.Load().(*websocket.Conn)
+	if err := ws.SetWriteDeadline(deadline(c.writeTimeout)); err != nil {
+		return err
+	}
+	return pingCodec.Send(ws, nil)
+}
+
+// pingCodec sends WebSocket ping frames with no payload. Frames are written by the websocket package along with the pong frames
+// it sends back, so the two do not interleave.
+var pingCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		return nil, websocket.PingFrame, nil
+	},
+}
+
+// readFrame reads the payload of the next data message from ws into w and returns its payload type. Messages fragmented into
+// several frames are read up to the final frame, so w gets the whole payload rather than the first fragment of it, same as
+// the single frame messages. Unlike websocket.Codec.Receive, it does not allocate a new byte slice per frame, so the caller can
+// reuse its buffers. control is called for each ping and pong frame handled by the websocket package while waiting for the data
+// frames. As the frames are read with the exported frame API of the websocket package, the connection should only be read
+// by a single goroutine, through readFrame.
+func readFrame(ws *websocket.Conn, w io.Writer, control func()) (payloadType byte, err error) {
+	payloadType = websocket.UnknownFrame
+	for {
+		frame, err := ws.NewFrameReader()
+		if err != nil {
+			return websocket.UnknownFrame, err
+		}
+
+		// HandleFrame discards the frame header and replaces the opcode of the continuation frames with the one of the message,
+		// so the FIN bit and the opcode are read from the header beforehand
+		var header [1]byte
+		hr := frame.HeaderReader()
+		if hr == nil {
+			return websocket.UnknownFrame, io.ErrUnexpectedEOF
+		}
+		if _, err := io.ReadFull(hr, header[:]); err != nil {
+			return websocket.UnknownFrame, err
+		}
+		fin, opcode := header[0]&0x80 != 0, header[0]&0x0f
+
+		if frame, err = ws.HandleFrame(frame); err != nil {
+			return websocket.UnknownFrame, err
+		}
+		if frame == nil {
+			control()
+			continue
+		}
+		if (opcode == websocket.ContinuationFrame) == (payloadType == websocket.UnknownFrame) {
+			return websocket.UnknownFrame, ErrFragmentation
+		}
+		if payloadType == websocket.UnknownFrame {
+			payloadType = frame.PayloadType()
+		}
+		if _, err := io.Copy(w, frame); err != nil {
+			return websocket.UnknownFrame, err
+		}
+		if fin {
+			return payloadType, nil
+		}
+	}
+}
+
+// writeFrame writes the frame to the connection before the given deadline. Writes are serialized, so each write gets
+// its full timeout regardless of the writes queued before it.
+func (c *Conn) writeFrame(ws *websocket.Conn, f frame, until time.Time) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if err := ws.SetWriteDeadline(until); err != nil {
+		return err
+	}
+	return frameCodec.Send(ws, f)
+}
+
+// startWriter calls start before the first write.
+type startWriter struct {
+	w       io.Writer
+	start   func()
+	started bool
+}
+
+func (sw *startWriter) Write(p []byte) (int, error) {
+	if !sw.started {
+		sw.started = true
+		sw.start()
+	}
+	return sw.w.Write(p)
+}
@@ -0,0 +1,167 @@
+package neptulon
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestReadFrame(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		frames      [][]byte
+		payload     string
+		payloadType byte
+		controls    int
+		err         error
+	}{
+		{"single", [][]byte{wsFrame(true, websocket.TextFrame, "hello world")}, "hello world", websocket.TextFrame, 0, nil},
+		{"fragmented", [][]byte{
+			wsFrame(false, websocket.TextFrame, "hel"),
+			wsFrame(false, websocket.ContinuationFrame, "lo "),
+			wsFrame(true, websocket.ContinuationFrame, "world"),
+		}, "hello world", websocket.TextFrame, 0, nil},
+		{"interleaved ping", [][]byte{
+			wsFrame(true, websocket.PingFrame, ""),
+			wsFrame(false, websocket.BinaryFrame, "hello "),
+			wsFrame(true, websocket.PingFrame, ""),
+			wsFrame(true, websocket.ContinuationFrame, "world"),
+		}, "hello world", websocket.BinaryFrame, 2, nil},
+		{"continuation without message", [][]byte{wsFrame(true, websocket.ContinuationFrame, "world")}, "", 0, 0, ErrFragmentation},
+		{"message before final fragment", [][]byte{
+			wsFrame(false, websocket.TextFrame, "hello "),
+			wsFrame(true, websocket.TextFrame, "world"),
+		}, "", 0, 0, ErrFragmentation},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ws, peer := newFrameConn(t)
+			defer ws.Close()
+			defer peer.Close()
+			go func() {
+				for _, f := range tc.frames {
+					if _, err := peer.Write(f); err != nil {
+						return
+					}
+				}
+			}()
+
+			var buf bytes.Buffer
+			controls := 0
+			payloadType, err := readFrame(ws, &buf, func() { controls++ })
+			if err != tc.err {
+				t.Fatalf("expected error: %v, got: %v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if buf.String() != tc.payload || payloadType != tc.payloadType || controls != tc.controls {
+				t.Fatalf("expected payload: %q of type %v with %v control frames, got: %q of type %v with %v control frames",
+					tc.payload, tc.payloadType, tc.controls, buf.String(), payloadType, controls)
+			}
+		})
+	}
+}
+
+func BenchmarkReadFrame(b *testing.B) {
+	payload := string(bytes.Repeat([]byte("a"), 4096))
+	for _, bm := range []struct {
+		name      string
+		fragments int
+	}{
+		{"single", 1},
+		{"fragmented", 8},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			// fragments of a message are written at once so the benchmark measures the reads rather than the pipe writes
+			var msg []byte
+			size := len(payload) / bm.fragments
+			for i := 0; i < bm.fragments; i++ {
+				opcode := byte(websocket.ContinuationFrame)
+				if i == 0 {
+					opcode = websocket.TextFrame
+				}
+				msg = append(msg, wsFrame(i == bm.fragments-1, opcode, payload[i*size:(i+1)*size])...)
+			}
+
+			ws, peer := newFrameConn(b)
+			defer ws.Close()
+			defer peer.Close()
+			n := b.N
+			go func() {
+				for i := 0; i < n; i++ {
+					if _, err := peer.Write(msg); err != nil {
+						return
+					}
+				}
+			}()
+
+			var buf bytes.Buffer
+			b.SetBytes(int64(len(payload)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if _, err := readFrame(ws, &buf, func() {}); err != nil {
+					b.Fatal(err)
+				}
+				if buf.Len() != len(payload) {
+					b.Fatalf("expected payload of %v bytes, got: %v", len(payload), buf.Len())
+				}
+			}
+		})
+	}
+}
+
+// newFrameConn returns a client websocket connection over an in-memory pipe, along with the server end of the pipe to write
+// raw frames to. Anything the client writes (i.e. pong frames) is discarded.
+func newFrameConn(tb testing.TB) (*websocket.Conn, net.Conn) {
+	client, peer := net.Pipe()
+	go func() {
+		r := bufio.NewReader(peer)
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+		h := sha1.Sum([]byte(req.Header.Get("Sec-Websocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		fmt.Fprintf(peer, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %v\r\n\r\n",
+			base64.StdEncoding.EncodeToString(h[:]))
+		io.Copy(ioutil.Discard, r)
+	}()
+
+	config, err := websocket.NewConfig("ws://localhost/", "http://localhost/")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ws, err := websocket.NewClient(config, client)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return ws, peer
+}
+
+// wsFrame returns an unmasked frame, as sent by a server, with the given payload.
+func wsFrame(fin bool, opcode byte, payload string) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	f := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		f = append(f, byte(n))
+	case n <= 0xffff:
+		f = append(f, 126, byte(n>>8), byte(n))
+	default:
+		f = append(f, 127, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(f, payload...)
+}
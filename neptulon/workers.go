@@ -0,0 +1,115 @@
+package neptulon
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+var shedCounter = expvar.NewInt("requests-shed")
+
+// OverloadedError is the error response to the requests shed as the worker pool queue is full.
+var OverloadedError = ResError{Code: 503, Message: "Server is overloaded, try again later."}
+
+// WorkerPool runs the request handlers of all the connections of a server on a fixed number of goroutines, instead of a
+// goroutine per request, so a flood of requests degrades gracefully rather than exploding the goroutine count. Requests wait
+// in a bounded queue for a free worker, and the requests arriving while the queue is full are shed with OverloadedError.
+//
+// Response handlers are not run on the pool as the responses are bounded by the requests sent by the server, and a request
+// handler waiting for a response would otherwise deadlock the pool when all the workers are busy.
+type WorkerPool struct {
+	jobs    chan func()
+	workers int
+	busy    int64 // number of workers running a handler, accessed atomically
+	shed    int64 // number of requests shed since the pool is started, accessed atomically
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// WorkerPoolStats are the metrics of a worker pool.
+type WorkerPoolStats struct {
+	Workers int   // Number of workers.
+	Busy    int   // Number of workers running a handler.
+	Queue   int   // Number of requests waiting for a free worker.
+	Shed    int64 // Number of requests shed as the queue was full.
+}
+
+// newWorkerPool starts a worker pool with the given number of workers and the given queue size.
+func newWorkerPool(workers, queue int) *WorkerPool {
+	if queue < 0 {
+		queue = 0
+	}
+	p := &WorkerPool{jobs: make(chan func(), queue), workers: workers, quit: make(chan struct{})}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case job := <-p.jobs:
+			atomic.AddInt64(&p.busy, 1)
+			job()
+			atomic.AddInt64(&p.busy, -1)
+		case <-p.quit:
+			// jobs left in the queue are still run so the connections waiting for them are not left hanging
+			for {
+				select {
+				case job := <-p.jobs:
+					job()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// tryRun queues the job to be run by a worker, and returns false if the queue is full.
+func (p *WorkerPool) tryRun(job func()) bool {
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+		atomic.AddInt64(&p.shed, 1)
+		shedCounter.Add(1)
+		return false
+	}
+}
+
+// stop stops the workers once they are done with the jobs they are running and the jobs waiting in the queue.
+func (p *WorkerPool) stop() {
+	close(p.quit)
+	p.wg.Wait()
+}
+
+// Stats returns the current metrics of the pool.
+func (p *WorkerPool) Stats() WorkerPoolStats {
+	return WorkerPoolStats{Workers: p.workers, Busy: int(atomic.LoadInt64(&p.busy)), Queue: len(p.jobs), Shed: atomic.LoadInt64(&p.shed)}
+}
+
+// SetWorkerPool runs the request handlers of the connections on the given number of workers, with up to the given number of
+// requests waiting for a free worker, beyond which the requests are shed with OverloadedError. Zero (or negative) queue sheds the
+// requests as soon as all the workers are busy. Zero workers runs each request handler on its own goroutine, which is the default.
+// It should be called before the server starts listening.
+func (s *Server) SetWorkerPool(workers, queue int) {
+	if s.pool != nil {
+		s.pool.stop()
+		s.pool = nil
+	}
+	if workers > 0 {
+		s.pool = newWorkerPool(workers, queue)
+	}
+}
+
+// WorkerPoolStats returns the metrics of the worker pool of the server, and false if the worker pool is not used.
+func (s *Server) WorkerPoolStats() (stats WorkerPoolStats, ok bool) {
+	if s.pool == nil {
+		return WorkerPoolStats{}, false
+	}
+	return s.pool.Stats(), true
+}
@@ -0,0 +1,83 @@
+package titan
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/titan-x/titan/neptulon"
+)
+
+const (
+	pageLimitDefault = 50
+	pageLimitMax     = 100
+)
+
+// pageReq is embedded in the params of list routes to page through large result sets.
+type pageReq struct {
+	Cursor string `json:"cursor"`
+	Limit  int    `json:"limit"`
+}
+
+// pageCursor is an opaque cursor handed to clients for retrieving the next page of a list.
+// It encodes the offset of the next page and the time of the first page request (snapshot time),
+// so items added to the list after the first page is retrieved do not shift the following pages.
+type pageCursor struct {
+	Offset   int   `json:"o"`
+	Snapshot int64 `json:"t"` // Unix time in nanoseconds.
+}
+
+// page parses the requested page into a cursor and a page size. Empty cursor denotes the first page, with the current time as the snapshot time.
+// If the cursor is malformed, error response is set on the request context and ok is false.
+func (r *pageReq) page(ctx *neptulon.ReqCtx) (c pageCursor, limit int, ok bool) {
+	limit = r.Limit
+	if limit <= 0 {
+		limit = pageLimitDefault
+	} else if limit > pageLimitMax {
+		limit = pageLimitMax
+	}
+
+	if r.Cursor == "" {
+		return pageCursor{Snapshot: time.Now().UnixNano()}, limit, true
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(r.Cursor)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil || c.Offset < 0 {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid cursor."}
+		return c, 0, false
+	}
+
+	return c, limit, true
+}
+
+// snapshot returns the snapshot time of the list. Items created after this time should be excluded.
+func (c pageCursor) snapshot() time.Time {
+	return time.Unix(0, c.Snapshot)
+}
+
+// next returns the cursor for the page following the current page with n items, or empty string if there are no more items.
+func (c pageCursor) next(n int, more bool) string {
+	if !more {
+		return ""
+	}
+
+	c.Offset += n
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// pageSlice returns the bounds of the current page in a slice of given length, and whether there are more items after the page.
+func pageSlice(length int, c pageCursor, limit int) (start, end int, more bool) {
+	start = c.Offset
+	if start > length {
+		start = length
+	}
+	end = start + limit
+	if end > length {
+		end = length
+	}
+	return start, end, end < length
+}
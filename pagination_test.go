@@ -0,0 +1,52 @@
+package titan
+
+import (
+	"testing"
+
+	"github.com/titan-x/titan/neptulon"
+)
+
+func TestPageCursor(t *testing.T) {
+	r := pageReq{Limit: 1000}
+	c, limit, ok := r.page(nil)
+	if !ok || c.Offset != 0 || c.Snapshot == 0 || limit != pageLimitMax {
+		t.Fatalf("unexpected first page: %+v, limit: %v", c, limit)
+	}
+
+	if next := c.next(10, false); next != "" {
+		t.Fatalf("expected no cursor for the last page, got: %v", next)
+	}
+
+	r = pageReq{Cursor: c.next(10, true)}
+	c2, limit, ok := r.page(nil)
+	if !ok || c2.Offset != 10 || c2.Snapshot != c.Snapshot || limit != pageLimitDefault {
+		t.Fatalf("unexpected second page: %+v, limit: %v", c2, limit)
+	}
+
+	ctx := &neptulon.ReqCtx{}
+	r = pageReq{Cursor: "not-a-cursor"}
+	if _, _, ok := r.page(ctx); ok || ctx.Err == nil {
+		t.Fatal("malformed cursor was accepted")
+	}
+}
+
+func TestPageSlice(t *testing.T) {
+	cases := []struct {
+		length, offset, limit int
+		start, end            int
+		more                  bool
+	}{
+		{5, 0, 2, 0, 2, true},
+		{5, 4, 2, 4, 5, false},
+		{5, 3, 2, 3, 5, false},
+		{5, 10, 2, 5, 5, false},
+		{0, 0, 2, 0, 0, false},
+	}
+
+	for _, c := range cases {
+		start, end, more := pageSlice(c.length, pageCursor{Offset: c.offset}, c.limit)
+		if start != c.start || end != c.end || more != c.more {
+			t.Fatalf("unexpected page bounds for %+v: %v, %v, %v", c, start, end, more)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package titan
+
+import (
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+var presenceLog = log.Component("presence")
+
+// presence tracks the online status of users through their connections and notifies subscribed users of status changes.
+// Subscriptions are valid for as long as the subscriber is online.
+type presence struct {
+	queue  *data.Queue
+	events *events
+
+	mutex    sync.Mutex
+	conns    map[string]map[string]*neptulon.Conn // user ID -> conn ID -> live connections
+	lastSeen map[string]time.Time                 // user ID -> last time the user went offline
+	subs     map[string]map[string]bool           // user ID -> subscriber user IDs
+	subbed   map[string]map[string]bool           // subscriber user ID -> user IDs it is subscribed to
+}
+
+func newPresence(q *data.Queue, ev *events) *presence {
+	return &presence{
+		queue:    q,
+		events:   ev,
+		conns:    make(map[string]map[string]*neptulon.Conn),
+		lastSeen: make(map[string]time.Time),
+		subs:     make(map[string]map[string]bool),
+		subbed:   make(map[string]map[string]bool),
+	}
+}
+
+// Middleware marks authenticated users online upon the first request over each connection.
+func (p *presence) Middleware(ctx *neptulon.ReqCtx) error {
+	if _, ok := ctx.Conn.Session.GetOk("presence"); !ok {
+		ctx.Conn.Session.Set("presence", true)
+		p.connected(ctx.Conn)
+	}
+
+	return ctx.Next()
+}
+
+// Disconnected marks a user offline if the given connection was the user's last live connection.
+func (p *presence) Disconnected(c *neptulon.Conn) {
+	if _, ok := c.Session.GetOk("presence"); ok {
+		p.disconnected(c)
+	}
+}
+
+// Subscribe subscribes a user to the presence updates of given users and returns their current presence.
+func (p *presence) Subscribe(subscriber string, userIDs []string) []models.Presence {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.subbed[subscriber]; !ok {
+		p.subbed[subscriber] = make(map[string]bool)
+	}
+
+	ps := make([]models.Presence, 0, len(userIDs))
+	for _, id := range userIDs {
+		if _, ok := p.subs[id]; !ok {
+			p.subs[id] = make(map[string]bool)
+		}
+		p.subs[id][subscriber] = true
+		p.subbed[subscriber][id] = true
+		ps = append(ps, p.get(id))
+	}
+
+	return ps
+}
+
+// Get retrieves the current presence of a user.
+func (p *presence) Get(userID string) models.Presence {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.get(userID)
+}
+
+// IsOnline returns true if the user has at least one live connection.
+func (p *presence) IsOnline(userID string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.conns[userID]) > 0
+}
+
+func (p *presence) get(userID string) models.Presence {
+	return models.Presence{UserID: userID, Online: len(p.conns[userID]) > 0, LastSeen: p.lastSeen[userID]}
+}
+
+// Conns returns all the live connections of the online users.
+func (p *presence) Conns() []*neptulon.Conn {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var conns []*neptulon.Conn
+	for _, uc := range p.conns {
+		for _, c := range uc {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// UserConns returns the live connections of a user.
+func (p *presence) UserConns(userID string) []*neptulon.Conn {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	conns := make([]*neptulon.Conn, 0, len(p.conns[userID]))
+	for _, c := range p.conns[userID] {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Conn retrieves a live connection by ID with OK indicator.
+func (p *presence) Conn(connID string) (c *neptulon.Conn, ok bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, uc := range p.conns {
+		if c, ok := uc[connID]; ok {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// OnlineUsers returns the IDs of the users with at least one live connection.
+func (p *presence) OnlineUsers() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ids := make([]string, 0, len(p.conns))
+	for id := range p.conns {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (p *presence) connected(c *neptulon.Conn) {
+	userID := c.Session.Get("userid").(string)
+
+	p.mutex.Lock()
+	uc, ok := p.conns[userID]
+	if !ok {
+		uc = make(map[string]*neptulon.Conn)
+		p.conns[userID] = uc
+	}
+	uc[c.ID] = c
+	if len(uc) > 1 {
+		p.mutex.Unlock()
+		return
+	}
+	pr, subs := p.get(userID), p.onlineSubs(userID)
+	p.mutex.Unlock()
+
+	p.events.publish(models.EventUserConnected, userID, pr)
+	p.notify(pr, subs)
+}
+
+func (p *presence) disconnected(c *neptulon.Conn) {
+	userID := c.Session.Get("userid").(string)
+
+	p.mutex.Lock()
+	delete(p.conns[userID], c.ID)
+	if len(p.conns[userID]) > 0 {
+		p.mutex.Unlock()
+		return
+	}
+
+	delete(p.conns, userID)
+	p.lastSeen[userID] = time.Now()
+
+	// subscriptions are dropped as the subscriber goes offline
+	for id := range p.subbed[userID] {
+		delete(p.subs[id], userID)
+		if len(p.subs[id]) == 0 {
+			delete(p.subs, id)
+		}
+	}
+	delete(p.subbed, userID)
+
+	pr, subs := p.get(userID), p.onlineSubs(userID)
+	p.mutex.Unlock()
+
+	p.events.publish(models.EventUserDisconnected, userID, pr)
+	p.notify(pr, subs)
+}
+
+func (p *presence) onlineSubs(userID string) []string {
+	var subs []string
+	for s := range p.subs[userID] {
+		if len(p.conns[s]) > 0 {
+			subs = append(subs, s)
+		}
+	}
+	return subs
+}
+
+func (p *presence) notify(pr models.Presence, subs []string) {
+	for _, s := range subs {
+		if err := (*p.queue).AddRequest(s, "presence.update", []models.Presence{pr}, ignoreResHandler); err != nil {
+			presenceLog.Errorf("failed to notify user %v of presence update of user %v: %v", s, pr.UserID, err)
+		}
+	}
+}
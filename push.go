@@ -0,0 +1,277 @@
+package titan
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/trace"
+)
+
+// pusher sends push notifications for the messages queued for the users who are offline, so their devices wake up and connect
+// to receive the messages. Notifications only carry the conversation and the sender of a message, not the content, and are not
+// sent for the conversations muted by the recipient. Failures are only logged as the messages are delivered once the recipient
+// connects anyway.
+//
+// Notifications are collapsed per conversation: only the first message queued for an offline user within the collapse window
+// is notified right away, and the rest of the messages of the conversation queued within the window are notified together
+// with a single sync notification at the end of the window. Notifications of a conversation also share the same collapse key,
+// so a device which is not reachable by GCM/FCM only receives the latest one. Push notification data fields:
+//
+//	n.message_type: "message", or "sync" for the messages collapsed within the window
+//	n.conversation: ID of the conversation the message belongs to (empty for sync of a dropped connection)
+//	n.from:         ID of the sender (of the last message for sync, empty for sync of a dropped connection)
+//	n.count:        Number of the messages collapsed within the window (only for sync)
+//
+// Priority of the notifications, whether they are displayed to the user, and any custom data keys are given by the sender
+// of a message with models.PushOptions, and default to the server settings. Title and body of the displayed notifications
+// are rendered from the templates in the server settings, with pushTemplateData.
+type pusher struct {
+	db        *data.DB
+	presence  *presence
+	window    time.Duration // collapse window, which is disabled if not positive
+	defaults  pushDefaults
+	mutex     sync.RWMutex
+	sender    pushSender
+	collapsed map[string]*collapsedPush // user ID + conversation -> messages collapsed within the current window
+}
+
+// pushDefaults are the server settings of the push notifications, for the options not given by the sender of a message.
+type pushDefaults struct {
+	priority       string
+	delayWhileIdle bool
+	notification   bool
+	title, body    *template.Template
+}
+
+// pushTemplateData is the data the title and body templates of the displayed notifications are executed with,
+// i.e. "{{.From}}" and "{{if gt .Count 1}}{{.Count}} new messages{{else}}New message{{end}}".
+type pushTemplateData struct {
+	From         string // ID of the sender (of the last message for sync)
+	Conversation string
+	Group        string // Group ID if this is a group message
+	Count        int    // Number of the messages notified, which is more than 1 for sync
+}
+
+// collapsedPush is the latest message of a conversation and the number of messages collapsed into it within a collapse window.
+type collapsedPush struct {
+	msg    models.Message
+	opts   *models.PushOptions
+	parent trace.SpanContext
+	count  int
+}
+
+func newPusher(db *data.DB, p *presence, conf GCM) (*pusher, error) {
+	title, err := template.New("title").Parse(conf.Title)
+	if err != nil {
+		return nil, fmt.Errorf("push: invalid title template: %v", err)
+	}
+	body, err := template.New("body").Parse(conf.Body)
+	if err != nil {
+		return nil, fmt.Errorf("push: invalid body template: %v", err)
+	}
+
+	d := pushDefaults{priority: conf.Priority, delayWhileIdle: conf.DelayWhileIdle, notification: conf.Notification, title: title, body: body}
+	return &pusher{db: db, presence: p, window: conf.CollapseWindow, defaults: d, collapsed: make(map[string]*collapsedPush)}, nil
+}
+
+// setSender sets the sender to send the push notifications with, or disables the push notifications if nil,
+// and returns the previous sender.
+func (p *pusher) setSender(s pushSender) pushSender {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	prev := p.sender
+	p.sender = s
+	return prev
+}
+
+// msgQueued sends a push notification for a message queued for a user in the background, if the user is offline,
+// unless the message is collapsed with the earlier messages of the conversation.
+// Push notification is sent with the given options, if any, and is traced as a part of the given trace (i.e. of the sender's request).
+func (p *pusher) msgQueued(userID string, msg models.Message, opts *models.PushOptions, parent trace.SpanContext) {
+	p.mutex.Lock()
+	s := p.sender
+	if s == nil || p.presence.IsOnline(userID) {
+		p.mutex.Unlock()
+		return
+	}
+	if p.window > 0 {
+		key := userID + ":" + msg.Conversation
+		if c, ok := p.collapsed[key]; ok {
+			c.msg, c.opts, c.parent = msg, opts, parent
+			c.count++
+			p.mutex.Unlock()
+			return
+		}
+		p.collapsed[key] = &collapsedPush{}
+		time.AfterFunc(p.window, func() { p.flush(userID, key) })
+	}
+	p.mutex.Unlock()
+
+	go p.push(s, userID, msg, opts, 0, parent)
+}
+
+// connDropped sends a sync notification for the given number of requests waiting in the queue of a user whose connection is
+// dropped for not reading in time (i.e. of a device that lost its network), so the device connects again to receive them, as the
+// messages queued while the user was online are not notified otherwise. Nothing is sent if the user has other connections.
+func (p *pusher) connDropped(userID string, pending int) {
+	p.mutex.RLock()
+	s := p.sender
+	p.mutex.RUnlock()
+	if s == nil || pending == 0 || p.presence.IsOnline(userID) {
+		return
+	}
+
+	go p.push(s, userID, models.Message{}, nil, pending, trace.SpanContext{})
+}
+
+// flush ends the collapse window of a conversation, sending a sync notification for the messages collapsed within the window,
+// if any, unless the user came online in the meantime. Another window is started along with the sync notification.
+func (p *pusher) flush(userID, key string) {
+	p.mutex.Lock()
+	c := p.collapsed[key]
+	delete(p.collapsed, key)
+	s := p.sender
+	if c.count == 0 || s == nil || p.presence.IsOnline(userID) {
+		p.mutex.Unlock()
+		return
+	}
+	p.collapsed[key] = &collapsedPush{}
+	time.AfterFunc(p.window, func() { p.flush(userID, key) })
+	p.mutex.Unlock()
+
+	p.push(s, userID, c.msg, c.opts, c.count, c.parent)
+}
+
+// push sends a push notification for a message, or a sync notification for the given number of collapsed messages
+// of the conversation of the message, if the count is not zero.
+func (p *pusher) push(s pushSender, userID string, msg models.Message, opts *models.PushOptions, count int, parent trace.SpanContext) {
+	u, ok := (*p.db).GetByID(userID)
+	if !ok || u.GCMRegID == "" {
+		return
+	}
+
+	muted, err := (*p.db).IsMuted(userID, msg.Conversation)
+	if err != nil {
+		gcmLog.Errorf("failed to get muted state of conversation %v for user %v: %v", msg.Conversation, userID, err)
+		return
+	}
+	if muted {
+		return
+	}
+
+	span := trace.Start(parent, "push.send")
+	span.SetAttr("user", userID)
+	span.SetAttr("msg", msg.ID)
+	defer span.End()
+
+	m, err := p.pushMsg(userID, u.GCMRegID, msg, opts, count)
+	if err == nil {
+		err = s.Send(m)
+	}
+	if err != nil {
+		span.SetError(err)
+		gcmLog.Warnf("failed to send push notification for message %v to user %v: %v", msg.ID, userID, err)
+	}
+}
+
+// pushMsg creates the push notification for a message, or a sync notification for the given number of collapsed messages,
+// with the given options applied over the server defaults.
+func (p *pusher) pushMsg(userID, regID string, msg models.Message, opts *models.PushOptions, count int) (*pushMsg, error) {
+	if opts == nil {
+		opts = &models.PushOptions{}
+	}
+
+	data := make(map[string]string, len(opts.Data)+4)
+	for k, v := range opts.Data {
+		data[k] = v
+	}
+	data["n.message_type"] = "message"
+	data["n.conversation"] = msg.Conversation
+	data["n.from"] = msg.From
+	if count != 0 {
+		data["n.message_type"] = "sync"
+		data["n.count"] = strconv.Itoa(count)
+	}
+
+	m := &pushMsg{UserID: userID, To: regID, Data: data, CollapseKey: msg.Conversation, Priority: p.defaults.priority, DelayWhileIdle: p.defaults.delayWhileIdle}
+	if opts.Priority != "" {
+		m.Priority = opts.Priority
+	}
+	if opts.DelayWhileIdle != nil {
+		m.DelayWhileIdle = *opts.DelayWhileIdle
+	}
+
+	notification := p.defaults.notification
+	if opts.Notification != nil {
+		notification = *opts.Notification
+	}
+	if notification {
+		if count == 0 {
+			count = 1
+		}
+		td := pushTemplateData{From: msg.From, Conversation: msg.Conversation, Group: msg.Group, Count: count}
+		var title, body bytes.Buffer
+		if err := p.defaults.title.Execute(&title, td); err != nil {
+			return nil, fmt.Errorf("push: failed to execute title template: %v", err)
+		}
+		if err := p.defaults.body.Execute(&body, td); err != nil {
+			return nil, fmt.Errorf("push: failed to execute body template: %v", err)
+		}
+		m.Title, m.Body = title.String(), body.String()
+	}
+	return m, nil
+}
+
+const (
+	pushDataMaxKeys = 10   // maximum number of custom data keys of a push notification
+	pushDataMaxSize = 1024 // maximum total size of the custom data keys and values of a push notification, in bytes
+)
+
+// Data keys, and prefixes of the data keys, which cannot be used as custom keys as they are used by the server, GCM, or FCM.
+var (
+	pushReservedKeys     = map[string]bool{"from": true, "notification": true, "message_type": true, "collapse_key": true}
+	pushReservedPrefixes = []string{"n.", "google", "gcm"}
+)
+
+// validPushOptions validates the push options given by the sender of a message, and returns the reason if they are invalid.
+func validPushOptions(o *models.PushOptions) (reason string, ok bool) {
+	if o == nil {
+		return "", true
+	}
+
+	switch o.Priority {
+	case "", models.PushPriorityHigh, models.PushPriorityNormal:
+	default:
+		return "Push priority should be either high or normal.", false
+	}
+
+	if len(o.Data) > pushDataMaxKeys {
+		return fmt.Sprintf("Push notification can have at most %v custom data keys.", pushDataMaxKeys), false
+	}
+	size := 0
+	for k, v := range o.Data {
+		if k == "" {
+			return "Push notification data keys cannot be empty.", false
+		}
+		lk := strings.ToLower(k)
+		reserved := pushReservedKeys[lk]
+		for _, r := range pushReservedPrefixes {
+			reserved = reserved || strings.HasPrefix(lk, r)
+		}
+		if reserved {
+			return fmt.Sprintf("Push notification data key is reserved: %v", k), false
+		}
+		size += len(k) + len(v)
+	}
+	if size > pushDataMaxSize {
+		return fmt.Sprintf("Push notification data should be at most %v bytes.", pushDataMaxSize), false
+	}
+	return "", true
+}
@@ -0,0 +1,105 @@
+//go:build quic
+// +build quic
+
+package titan
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// quicStreamTimeout is the time allowed for a new QUIC connection to open its stream.
+const quicStreamTimeout = time.Second * 10
+
+// newQUICListener listens for QUIC connections at the given UDP address. Each connection carries the WebSocket protocol
+// over its first bidirectional stream, so the accepted connections can be served with neptulon.Server.Serve.
+// QUIC connections survive network changes (i.e. Wi-Fi to cellular) and resume faster than TCP+TLS over flaky mobile networks.
+func newQUICListener(addr string, tlsConf *tls.Config) (net.Listener, error) {
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{QUICALPN}
+
+	l, err := quic.ListenAddr(addr, tlsConf, &quic.Config{MaxIdleTimeout: time.Minute, KeepAlivePeriod: time.Second * 20})
+	if err != nil {
+		return nil, err
+	}
+
+	ql := &quicListener{l: l, conns: make(chan net.Conn), done: make(chan struct{})}
+	go ql.accept()
+	return ql, nil
+}
+
+// quicListener adapts a QUIC listener to net.Listener, accepting the first stream of each connection as a net.Conn.
+type quicListener struct {
+	l     *quic.Listener
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (ql *quicListener) accept() {
+	for {
+		c, err := ql.l.Accept(context.Background())
+		if err != nil {
+			return
+		}
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), quicStreamTimeout)
+			defer cancel()
+
+			s, err := c.AcceptStream(ctx)
+			if err != nil {
+				c.CloseWithError(0, "no stream opened")
+				return
+			}
+
+			select {
+			case ql.conns <- &quicConn{Stream: s, conn: c}:
+			case <-ql.done:
+				c.CloseWithError(0, "server closed")
+			}
+		}()
+	}
+}
+
+func (ql *quicListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ql.conns:
+		return c, nil
+	case <-ql.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (ql *quicListener) Close() error {
+	ql.once.Do(func() { close(ql.done) })
+	return ql.l.Close()
+}
+
+func (ql *quicListener) Addr() net.Addr {
+	return ql.l.Addr()
+}
+
+// quicConn is a QUIC stream along with its connection. Closing it closes the whole connection as there is one stream per connection.
+type quicConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+func (c *quicConn) Close() error {
+	c.Stream.Close()
+	return c.conn.CloseWithError(0, "")
+}
+
+func (c *quicConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *quicConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// ConnectionState exposes the TLS connection state so the client certificates can be used for authentication.
+func (c *quicConn) ConnectionState() tls.ConnectionState {
+	return c.conn.ConnectionState().TLS
+}
@@ -0,0 +1,15 @@
+//go:build !quic
+// +build !quic
+
+package titan
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+)
+
+// newQUICListener is not available unless the server is built with the quic tag (go build -tags quic), since QUIC support is experimental.
+func newQUICListener(addr string, tlsConf *tls.Config) (net.Listener, error) {
+	return nil, errors.New("quic support is not compiled in, rebuild with -tags quic")
+}
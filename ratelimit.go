@@ -0,0 +1,160 @@
+package titan
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+// rateLimiter is a token bucket rate limiter middleware, which limits the requests per connection and the messages per user, per minute.
+// Requests exceeding the limits are rejected with an error response with the number of seconds to retry after.
+// Limits less than or equal to zero disable the respective rate limiting.
+type rateLimiter struct {
+	mutex       sync.Mutex
+	reqRate     int                // requests per connection per minute
+	msgRate     int                // messages per user per minute
+	conns       map[string]*bucket // conn ID -> request bucket
+	users       map[string]*bucket // user ID -> message bucket
+	lastCleanup time.Time
+	abuse       *abuse // rejected requests are reported as the offenses of the remote IP, if set
+}
+
+type rateLimitErrData struct {
+	RetryAfter int `json:"retryAfter"` // Seconds to wait before retrying the request.
+}
+
+func newRateLimiter(reqRate, msgRate int) *rateLimiter {
+	return &rateLimiter{
+		reqRate:     reqRate,
+		msgRate:     msgRate,
+		conns:       make(map[string]*bucket),
+		users:       make(map[string]*bucket),
+		lastCleanup: time.Now(),
+	}
+}
+
+// SetRates sets the requests per connection, and messages per user per minute limits.
+func (rl *rateLimiter) SetRates(reqRate, msgRate int) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	rl.reqRate, rl.msgRate = reqRate, msgRate
+	rl.conns = make(map[string]*bucket)
+	rl.users = make(map[string]*bucket)
+}
+
+// Middleware rate limits the incoming requests of authenticated users.
+func (rl *rateLimiter) Middleware(ctx *neptulon.ReqCtx) error {
+	now := time.Now()
+	retry, ok := rl.take(false, ctx.Conn.ID, 1, now)
+
+	if ok {
+		if n := msgCount(ctx); n != 0 {
+			retry, ok = rl.take(true, ctx.Conn.Session.Get("userid").(string), n, now)
+		}
+	}
+
+	if !ok {
+		rl.abuse.report(ctx.Conn, offenseRateLimit)
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Rate limit exceeded.", Data: rateLimitErrData{RetryAfter: int(math.Ceil(retry.Seconds()))}}
+		return nil
+	}
+
+	return ctx.Next()
+}
+
+// Disconnected releases the resources associated with a connection.
+func (rl *rateLimiter) Disconnected(c *neptulon.Conn) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	delete(rl.conns, c.ID)
+}
+
+// take takes n tokens from the user or connection bucket with the given key, creating a full bucket if it does not exist.
+// If there are not enough tokens, ok is false and the time to wait for enough tokens is returned.
+func (rl *rateLimiter) take(user bool, key string, n int, now time.Time) (retry time.Duration, ok bool) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	buckets, rate := rl.conns, rl.reqRate
+	if user {
+		buckets, rate = rl.users, rl.msgRate
+	}
+	if rate <= 0 {
+		return 0, true
+	}
+
+	// drop the user buckets that are full since they are no different than new ones
+	if now.Sub(rl.lastCleanup) > time.Minute {
+		for k, b := range rl.users {
+			if b.refill(rl.msgRate, now) {
+				delete(rl.users, k)
+			}
+		}
+		rl.lastCleanup = now
+	}
+
+	b, exists := buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(rate), last: now}
+		buckets[key] = b
+	}
+
+	return b.take(rate, n, now)
+}
+
+// msgCount returns the number of messages sent with a request, which is zero for requests other than message sending requests.
+func msgCount(ctx *neptulon.ReqCtx) int {
+	switch ctx.Method {
+	case "msg.send":
+		var msgs []models.Message
+		if err := ctx.Params(&msgs); err != nil || len(msgs) == 0 {
+			return 1
+		}
+		return len(msgs)
+	case "msg.sendBatch":
+		var req sendBatchReq
+		if err := ctx.Params(&req); err != nil || len(req.messages()) == 0 {
+			return 1
+		}
+		return len(req.messages())
+	case "group.send", "topic.publish":
+		return 1
+	}
+	return 0
+}
+
+// bucket is a token bucket with the capacity of the number of tokens added per minute.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accumulated since the last refill and reports whether the bucket is full.
+func (b *bucket) refill(rate int, now time.Time) (full bool) {
+	b.tokens += now.Sub(b.last).Minutes() * float64(rate)
+	b.last = now
+	if b.tokens >= float64(rate) {
+		b.tokens = float64(rate)
+		return true
+	}
+	return false
+}
+
+// take takes n tokens from the bucket if available, or else returns the time to wait for enough tokens.
+// Takes of more than the capacity are treated as taking the full capacity.
+func (b *bucket) take(rate, n int, now time.Time) (retry time.Duration, ok bool) {
+	b.refill(rate, now)
+
+	cost := math.Min(float64(n), float64(rate))
+	if b.tokens < cost {
+		return time.Duration((cost - b.tokens) / float64(rate) * float64(time.Minute)), false
+	}
+
+	b.tokens -= cost
+	return 0, true
+}
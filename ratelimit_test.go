@@ -0,0 +1,30 @@
+package titan
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := bucket{tokens: 60, last: now}
+
+	if _, ok := b.take(60, 60, now); !ok {
+		t.Fatal("expected full bucket to allow taking all tokens")
+	}
+	retry, ok := b.take(60, 1, now)
+	if ok || retry != time.Second {
+		t.Fatalf("expected empty bucket to reject with 1s retry, got: %v, %v", ok, retry)
+	}
+
+	// a token is added every second with the rate of 60 per minute
+	if _, ok := b.take(60, 1, now.Add(time.Second)); !ok {
+		t.Fatal("expected refilled bucket to allow taking a token")
+	}
+
+	// bucket capacity is limited to the rate
+	b.refill(60, now.Add(time.Hour))
+	if b.tokens != 60 {
+		t.Fatalf("expected bucket to be capped at 60 tokens, got: %v", b.tokens)
+	}
+}
@@ -0,0 +1,57 @@
+package titan
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+)
+
+// accountCloseDelay is the time the connection an account.delete request is made through is left open for, to deliver the response.
+const accountCloseDelay = time.Second
+
+func initAccountRoutes(r *middleware.Router, db *data.DB, p *presence, ev *events, au *audit) {
+	r.Request("account.delete", initDeleteAccountHandler(db, p, ev, au))
+}
+
+// Schedules the account of the caller to be deleted after the grace period, and returns the time of the deletion. All the refresh
+// tokens of the user are revoked, the access tokens of the user are refused from then on, and the connections of all the devices
+// are closed, including the caller's own right after the response. Once the grace period is over, the messages sent by the user,
+// the attachments, the contacts, and the rest of the user's data are deleted for good. Signing in again within the grace period
+// cancels the deletion.
+func initDeleteAccountHandler(db *data.DB, p *presence, ev *events, au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		uid := ctx.Conn.Session.Get("userid").(string)
+		u, ok := (*db).GetByID(uid)
+		if !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "User not found."}
+			return ctx.Next()
+		}
+
+		if u.DeleteAt.IsZero() {
+			u.DeleteAt = time.Now().Add(Conf.App.DeletionGrace)
+			if err := (*db).SaveUser(u); err != nil {
+				return fmt.Errorf("route: account.delete: failed to save user: %v", err)
+			}
+			ev.publish(models.EventAccountDeletionRequested, uid, models.AccountDeletion{DeleteAt: u.DeleteAt})
+			au.record(ctx.Conn, models.AuditEntry{Action: models.AuditAccountDelete, Success: true, Details: map[string]string{"deleteAt": u.DeleteAt.Format(time.RFC3339)}})
+		}
+
+		revoked, closed, err := revokeDevice(*db, p, uid, "", ctx.Conn)
+		if err != nil {
+			return fmt.Errorf("route: account.delete: %v", err)
+		}
+		time.AfterFunc(accountCloseDelay, func() {
+			if err := ctx.Conn.Close(); err != nil {
+				authLog.Warnf("failed to close connection %v: %v", ctx.Conn.ID, err)
+			}
+		})
+		authLog.Infof("account of user %v is scheduled to be deleted at %v, revoked tokens: %v, closed connections: %v", uid, u.DeleteAt, revoked, closed+1)
+
+		ctx.Res = models.AccountDeletion{DeleteAt: u.DeleteAt}
+		return ctx.Next()
+	}
+}
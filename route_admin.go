@@ -0,0 +1,488 @@
+package titan
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+)
+
+var adminLog = log.Component("admin")
+
+// Admin routes declare the role required to call them: moderation routes are accessible to the moderators and the admins,
+// and the rest only to the admins, as given in the "role" claim of their JWT tokens. See authorize.
+func initAdminRoutes(r *middleware.Router, keys *jwtKeys, db *data.DB, q *data.Queue, p *presence, n *neptulon.Server, au *audit, h *hooks, ab *abuse, drain func(period time.Duration, message string),
+	kick func(userID string, purge bool) (closed, purged int), broadcast func(message string, cohort BroadcastCohort, ttl time.Duration) (int, error)) {
+	// moderation
+	r.Request("admin.users", authorize(au, models.RoleModerator, initListConnsHandler(p)))
+	r.Request("admin.disconnect", authorize(au, models.RoleModerator, initDisconnectHandler(p)))
+	r.Request("admin.kick", authorize(au, models.RoleModerator, initKickHandler(kick)))
+	r.Request("admin.bans", authorize(au, models.RoleModerator, initListBansHandler(ab)))
+	r.Request("admin.unban", authorize(au, models.RoleModerator, initUnbanHandler(ab)))
+
+	// administration
+	r.Request("admin.jwt.rotate", authorize(au, models.RoleAdmin, initRotateJWTKeyHandler(keys)))
+	r.Request("admin.role", authorize(au, models.RoleAdmin, initSetRoleHandler(db, p)))
+	r.Request("admin.queue", authorize(au, models.RoleAdmin, initQueueDepthHandler(q)))
+	r.Request("admin.broadcast", authorize(au, models.RoleAdmin, initBroadcastHandler(broadcast)))
+	r.Request("admin.stats", authorize(au, models.RoleAdmin, initStatsHandler(n)))
+	r.Request("admin.deadletters", authorize(au, models.RoleAdmin, initDeadLettersHandler(q)))
+	r.Request("admin.redrive", authorize(au, models.RoleAdmin, initRedriveHandler(q)))
+	r.Request("admin.webhooks", authorize(au, models.RoleAdmin, initWebhookDeliveriesHandler(h)))
+	r.Request("admin.revoke", authorize(au, models.RoleAdmin, initRevokeDeviceHandler(db, p)))
+	r.Request("admin.drain", authorize(au, models.RoleAdmin, initDrainHandler(drain)))
+	r.Request("admin.topic.create", authorize(au, models.RoleAdmin, initCreateTopicHandler(db)))
+	r.Request("admin.audit", authorize(au, models.RoleAdmin, initAuditHandler(au)))
+}
+
+// Rotates the JWT signing key with the given key. Key is not persisted by the server, so the caller should also set it as
+// the signing password of all the servers (along with the previous one in the previous passwords) for the tokens signed with
+// it to remain valid upon restart and on the other servers of a cluster.
+// Tokens signed with the previous keys are still accepted so active sessions and issued tokens remain valid.
+func initRotateJWTKeyHandler(keys *jwtKeys) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req struct {
+			Key string `json:"key"`
+		}
+		if err := ctx.Params(&req); err != nil || req.Key == "" {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Signing key is required."}
+			return ctx.Next()
+		}
+
+		keys.Rotate(req.Key)
+		adminLog.Infof("jwt signing key rotated by user: %v", ctx.Conn.Session.Get("userid"))
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Lists the live connections of all the online users.
+func initListConnsHandler(p *presence) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		conns := []models.Conn{}
+		for _, c := range p.Conns() {
+			conns = append(conns, connModel(c))
+		}
+
+		ctx.Res = conns
+		return ctx.Next()
+	}
+}
+
+type queueDepthRes struct {
+	UserID string `json:"userid"`
+	Depth  int    `json:"depth"`
+}
+
+// Returns the number of requests waiting in a user's queue to be delivered.
+func initQueueDepthHandler(q *data.Queue) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req struct {
+			UserID string `json:"userid"`
+		}
+		if err := ctx.Params(&req); err != nil || req.UserID == "" {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "User ID is required."}
+			return ctx.Next()
+		}
+
+		ctx.Res = queueDepthRes{UserID: req.UserID, Depth: (*q).Depth(req.UserID)}
+		return ctx.Next()
+	}
+}
+
+// Closes a live connection. Requests that are not yet delivered over the connection stay in the user's queue.
+func initDisconnectHandler(p *presence) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req struct {
+			ID string `json:"id"`
+		}
+		ctx.Params(&req)
+
+		c, ok := p.Conn(req.ID)
+		if !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Connection not found."}
+			return ctx.Next()
+		}
+
+		if err := c.Close(); err != nil {
+			adminLog.Warnf("failed to close connection %v: %v", c.ID, err)
+		}
+		adminLog.Infof("connection %v of user %v closed by user: %v", c.ID, c.Session.Get("userid"), ctx.Conn.Session.Get("userid"))
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+type kickReq struct {
+	UserID string `json:"userid"`
+	Purge  bool   `json:"purge"` // whether to dead-letter the requests waiting to be delivered to the user as well
+}
+
+type kickRes struct {
+	Closed int `json:"closed"` // number of live connections closed
+	Purged int `json:"purged"` // number of requests dead-lettered
+}
+
+// Closes all the live connections of a user, optionally purging the user's queue. Admins cannot kick themselves,
+// as the response would be lost with their connection.
+func initKickHandler(kick func(userID string, purge bool) (closed, purged int)) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req kickReq
+		if err := ctx.Params(&req); err != nil || req.UserID == "" {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "User ID is required."}
+			return ctx.Next()
+		}
+		if req.UserID == ctx.Conn.Session.Get("userid") {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Cannot kick yourself."}
+			return ctx.Next()
+		}
+
+		closed, purged := kick(req.UserID, req.Purge)
+		adminLog.Infof("user %v kicked by user: %v", req.UserID, ctx.Conn.Session.Get("userid"))
+
+		ctx.Res = kickRes{Closed: closed, Purged: purged}
+		return ctx.Next()
+	}
+}
+
+type broadcastReq struct {
+	Message string   `json:"message"`
+	All     bool     `json:"all"`     // broadcast to all the registered users instead of the online ones
+	UserIDs []string `json:"userids"` // broadcast to the given users only instead of the online ones
+	TTL     string   `json:"ttl"`     // i.e. "24h", after which the undelivered notices are dead-lettered, defaults to no expiry
+}
+
+// Sends a system notice to the online users, or to the given cohort of users. Responds right away while the notice is enqueued
+// for the users in the background, at the broadcast rate.
+func initBroadcastHandler(broadcast func(message string, cohort BroadcastCohort, ttl time.Duration) (int, error)) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req broadcastReq
+		if err := ctx.Params(&req); err != nil || req.Message == "" {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Notice message is required."}
+			return ctx.Next()
+		}
+
+		var ttl time.Duration
+		if req.TTL != "" {
+			d, err := time.ParseDuration(req.TTL)
+			if err != nil || d < 0 {
+				ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid notice TTL."}
+				return ctx.Next()
+			}
+			ttl = d
+		}
+
+		adminLog.Infof("system notice broadcast started by user: %v", ctx.Conn.Session.Get("userid"))
+		go func() {
+			if _, err := broadcast(req.Message, BroadcastCohort{All: req.All, UserIDs: req.UserIDs}, ttl); err != nil {
+				adminLog.Errorf("system notice broadcast failed: %v", err)
+			}
+		}()
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Returns the connection, user, and queue size metrics of the server, along with the memory and GC stats of the server process.
+func initStatsHandler(n *neptulon.Server) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		s := models.Stats{
+			Conns: n.ConnCount(), ConnShards: n.ConnShardLens(), Users: data.UserCount.Value(), QueueLength: data.QueueLength.Value(), QueueExpired: data.QueueExpired.Value(),
+			Goroutines: runtime.NumGoroutine(), HeapAlloc: m.HeapAlloc, Sys: m.Sys, NumGC: m.NumGC, GCPauseTotal: m.PauseTotalNs,
+		}
+		if ws, ok := n.WorkerPoolStats(); ok {
+			s.Workers, s.WorkersBusy, s.WorkerQueue, s.RequestsShed = ws.Workers, ws.Busy, ws.Queue, ws.Shed
+		}
+		ctx.Res = s
+		return ctx.Next()
+	}
+}
+
+type deadLettersReq struct {
+	UserID string `json:"userid"`
+	pageReq
+}
+
+type deadLettersRes struct {
+	DeadLetters []models.DeadLetter `json:"deadLetters"`
+	Cursor      string              `json:"cursor,omitempty"`
+}
+
+// Lists the requests that could not be delivered (i.e. expired or exceeded the max delivery attempts) along with the failure reasons,
+// oldest first. Dead letters can be filtered by the recipient user ID.
+func initDeadLettersHandler(q *data.Queue) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req deadLettersReq
+		ctx.Params(&req)
+
+		c, limit, ok := req.page(ctx)
+		if !ok {
+			return ctx.Next()
+		}
+
+		dls := (*q).DeadLetters(req.UserID)
+		for i, dl := range dls {
+			if dl.Time.After(c.snapshot()) {
+				dls = dls[:i]
+				break
+			}
+		}
+
+		start, end, more := pageSlice(len(dls), c, limit)
+		ctx.Res = deadLettersRes{DeadLetters: dls[start:end], Cursor: c.next(end-start, more)}
+		return ctx.Next()
+	}
+}
+
+type webhookDeliveriesReq struct {
+	URL string `json:"url"`
+	pageReq
+}
+
+type webhookDeliveriesRes struct {
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+	Cursor     string                   `json:"cursor,omitempty"`
+}
+
+// Lists the recent deliveries of the server events to the outgoing webhooks along with their outcome, oldest first.
+// Deliveries can be filtered by the webhook URL.
+func initWebhookDeliveriesHandler(h *hooks) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req webhookDeliveriesReq
+		ctx.Params(&req)
+
+		c, limit, ok := req.page(ctx)
+		if !ok {
+			return ctx.Next()
+		}
+
+		ds := h.deliveryLog(req.URL)
+		for i, d := range ds {
+			if d.Time.After(c.snapshot()) {
+				ds = ds[:i]
+				break
+			}
+		}
+
+		start, end, more := pageSlice(len(ds), c, limit)
+		ctx.Res = webhookDeliveriesRes{Deliveries: ds[start:end], Cursor: c.next(end-start, more)}
+		return ctx.Next()
+	}
+}
+
+// Lists the remote IPs which are temporarily banned for abuse, the ones ending first being first.
+func initListBansHandler(ab *abuse) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		ctx.Res = ab.list()
+		return ctx.Next()
+	}
+}
+
+type unbanRes struct {
+	Unbanned int `json:"unbanned"`
+}
+
+// Lifts the ban of a remote IP, or all the bans if no IP is given, and returns the number of bans lifted.
+func initUnbanHandler(ab *abuse) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req struct {
+			IP string `json:"ip"`
+		}
+		ctx.Params(&req)
+
+		n := ab.clear(req.IP)
+		adminLog.Infof("%v bans of ip %q lifted by user: %v", n, req.IP, ctx.Conn.Session.Get("userid"))
+		ctx.Res = unbanRes{Unbanned: n}
+		return ctx.Next()
+	}
+}
+
+// Puts a dead-lettered request back in the recipient's queue to be delivered again, given the request ID.
+func initRedriveHandler(q *data.Queue) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req struct {
+			ID string `json:"id"`
+		}
+		ctx.Params(&req)
+
+		ok, err := (*q).Redrive(req.ID)
+		if err != nil {
+			return fmt.Errorf("route: admin.redrive: failed to re-drive request %v: %v", req.ID, err)
+		}
+		if !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Dead letter not found."}
+			return ctx.Next()
+		}
+		adminLog.Infof("dead-lettered request %v re-driven by user: %v", req.ID, ctx.Conn.Session.Get("userid"))
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+type revokeDeviceReq struct {
+	UserID string `json:"userid"`
+	Device string `json:"device"`
+}
+
+type revokeDeviceRes struct {
+	Revoked int `json:"revoked"` // number of refresh tokens revoked
+	Closed  int `json:"closed"`  // number of live connections closed
+}
+
+// Revokes the refresh tokens issued to a device of a user (i.e. when a device is lost or compromised), along with all the access tokens
+// issued with them, and closes the live connections of the device. If the device name is empty, all the devices of the user are revoked.
+func initRevokeDeviceHandler(db *data.DB, p *presence) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req revokeDeviceReq
+		if err := ctx.Params(&req); err != nil || req.UserID == "" {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "User ID is required."}
+			return ctx.Next()
+		}
+
+		revoked, closed, err := revokeDevice(*db, p, req.UserID, req.Device, ctx.Conn)
+		if err != nil {
+			return fmt.Errorf("route: admin.revoke: %v", err)
+		}
+		res := revokeDeviceRes{Revoked: revoked, Closed: closed}
+		adminLog.Infof("device %q of user %v revoked by user: %v, tokens: %v, connections: %v", req.Device, req.UserID, ctx.Conn.Session.Get("userid"), res.Revoked, res.Closed)
+
+		ctx.Res = res
+		return ctx.Next()
+	}
+}
+
+type setRoleReq struct {
+	UserID string `json:"userid"`
+	Role   string `json:"role"` // one of "user", "moderator", or "admin"
+}
+
+type setRoleRes struct {
+	Revoked int `json:"revoked"`
+	Closed  int `json:"closed"`
+}
+
+// Sets the role of a user. Role is issued in the JWT tokens of the user upon the next sign-in or token refresh, so the refresh tokens of
+// the user are revoked and the live connections are closed for the change to take effect. Tokens issued without a refresh token keep
+// the previous role until the JWT signing key is rotated. Admins cannot change their own role, as they could lock out all the admins.
+func initSetRoleHandler(db *data.DB, p *presence) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req setRoleReq
+		if err := ctx.Params(&req); err != nil || req.UserID == "" || !validRole(req.Role) {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "User ID and a valid role are required."}
+			return ctx.Next()
+		}
+		if req.UserID == ctx.Conn.Session.Get("userid") {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Cannot change your own role."}
+			return ctx.Next()
+		}
+
+		u, ok := (*db).GetByID(req.UserID)
+		if !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "User not found."}
+			return ctx.Next()
+		}
+		if req.Role == models.RoleUser {
+			req.Role = ""
+		}
+		u.Role = req.Role
+		if err := (*db).SaveUser(u); err != nil {
+			return fmt.Errorf("route: admin.role: failed to persist user: %v", err)
+		}
+
+		revoked, closed, err := revokeDevice(*db, p, req.UserID, "", nil)
+		if err != nil {
+			return fmt.Errorf("route: admin.role: %v", err)
+		}
+		adminLog.Infof("role of user %v set to %q by user: %v", req.UserID, req.Role, ctx.Conn.Session.Get("userid"))
+
+		ctx.Res = setRoleRes{Revoked: revoked, Closed: closed}
+		return ctx.Next()
+	}
+}
+
+type drainReq struct {
+	Period  string `json:"period"`  // i.e. "30s", defaults to 0 which closes all the connections right away
+	Message string `json:"message"` // optional system notice to send to the connected clients before disconnecting them
+}
+
+// Gracefully drains the server: stops accepting new clients and disconnects the connected ones evenly over the given period,
+// so they reconnect to the other servers. Responds right away while the connections are closed in the background.
+// Connections of the admin users, including the caller's, are left open.
+func initDrainHandler(drain func(period time.Duration, message string)) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req drainReq
+		ctx.Params(&req)
+
+		var period time.Duration
+		if req.Period != "" {
+			d, err := time.ParseDuration(req.Period)
+			if err != nil || d < 0 {
+				ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid drain period."}
+				return ctx.Next()
+			}
+			period = d
+		}
+
+		adminLog.Infof("drain over %v started by user: %v", period, ctx.Conn.Session.Get("userid"))
+		go drain(period, req.Message)
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+type auditReq struct {
+	data.AuditFilter
+	pageReq
+}
+
+type auditRes struct {
+	Entries []models.AuditEntry `json:"entries"`
+	Cursor  string              `json:"cursor,omitempty"`
+}
+
+// Lists the audit log entries, newest first, filtered by the user who performed the action or the user it is performed on (userid),
+// by the action (action), and by the time they are recorded since (since). Only available if the audit log sink can be queried.
+func initAuditHandler(au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req auditReq
+		ctx.Params(&req)
+
+		aq, ok := au.sink.(data.AuditQuerier)
+		if !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Audit log cannot be queried."}
+			return ctx.Next()
+		}
+
+		c, limit, ok := req.page(ctx)
+		if !ok {
+			return ctx.Next()
+		}
+
+		// one extra entry is retrieved to see if there are any more entries left
+		es, err := aq.QueryAudit(req.AuditFilter, c.snapshot(), c.Offset, limit+1)
+		if err != nil {
+			return fmt.Errorf("route: admin.audit: failed to query audit log: %v", err)
+		}
+
+		more := len(es) > limit
+		if more {
+			es = es[:limit]
+		}
+
+		ctx.Res = auditRes{Entries: es, Cursor: c.next(len(es), more)}
+		return ctx.Next()
+	}
+}
@@ -0,0 +1,317 @@
+package titan
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+)
+
+// attachmentSlotTTL is the time for the content of an attachment to be uploaded after requesting the upload slot,
+// after which the slot expires and stops counting against the storage quota.
+const attachmentSlotTTL = time.Hour
+
+// attachmentPath is the path of the HTTP endpoint to upload and download the content of the attachments at.
+const attachmentPath = "/attachments/"
+
+// attachmentURLTTL is the lifetime of the presigned URLs to download the content of the attachments at,
+// when the content is kept in an object storage service.
+const attachmentURLTTL = 15 * time.Minute
+
+// initAttachmentRoutes registers the routes to manage the attachments, while their content is transferred over the HTTP endpoint.
+func initAttachmentRoutes(r *middleware.Router, db *data.DB, bs *data.BlobStore) {
+	r.Request("attachment.upload", initUploadAttachmentHandler(db, bs))
+	r.Request("attachment.complete", initCompleteAttachmentHandler(db, bs))
+	r.Request("attachment.get", initGetAttachmentHandler(db, bs))
+	r.Request("attachment.delete", initDeleteAttachmentHandler(db, bs))
+}
+
+type attachmentReq struct {
+	ID       string `json:"id"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Checksum string `json:"checksum"`
+}
+
+type attachmentRes struct {
+	models.AttachmentRef
+	URL     string            `json:"url"`               // URL to upload or download the content at, relative to the server address unless absolute.
+	Headers map[string]string `json:"headers,omitempty"` // Headers to send along with the upload request, if any.
+}
+
+// Allows clients to request a slot to upload an attachment with the given size, MIME type, and SHA-256 checksum, within the storage quota.
+// Content is then uploaded to the returned URL with an HTTP PUT request, and verified against the size and the checksum.
+// If the content is kept in an object storage service, the URL is a presigned one to upload the content directly to the service
+// along with the returned headers, after which the upload is completed with attachment.complete.
+// Upload slots expire if the content is not uploaded within an hour.
+func initUploadAttachmentHandler(db *data.DB, bs *data.BlobStore) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req attachmentReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		if req.Size <= 0 || req.Size > int64(Conf.App.AttachmentMaxSize) {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid attachment size.", Data: map[string]int{"max": Conf.App.AttachmentMaxSize}}
+			return ctx.Next()
+		}
+		if b, err := hex.DecodeString(req.Checksum); err != nil || len(b) != 32 {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Attachment checksum should be a hex encoded SHA-256 hash."}
+			return ctx.Next()
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		if quota := int64(Conf.App.AttachmentQuota); quota >= 0 {
+			used, err := attachmentUsage(*db, uid)
+			if err != nil {
+				return fmt.Errorf("route: attachment.upload: %v", err)
+			}
+			if used+req.Size > quota {
+				ctx.Err = &neptulon.ResError{Code: 666, Message: "Storage quota exceeded.", Data: map[string]int64{"quota": quota, "used": used}}
+				return ctx.Next()
+			}
+		}
+
+		id, err := shortid.UUID()
+		if err != nil {
+			return fmt.Errorf("route: attachment.upload: failed to generate attachment ID: %v", err)
+		}
+		a := models.Attachment{ID: id, Owner: uid, Size: req.Size, MimeType: req.MimeType, Checksum: req.Checksum, Created: time.Now()}
+		if err := (*db).SaveAttachment(&a); err != nil {
+			return fmt.Errorf("route: attachment.upload: failed to save attachment: %v", err)
+		}
+
+		res := attachmentRes{AttachmentRef: *a.Ref(), URL: attachmentPath + id}
+		if p, ok := (*bs).(data.BlobPresigner); ok {
+			if res.URL, res.Headers, err = p.PresignPut(id, strings.ToLower(req.Checksum), attachmentSlotTTL); err != nil {
+				return fmt.Errorf("route: attachment.upload: failed to presign upload url: %v", err)
+			}
+		}
+
+		ctx.Res = res
+		return ctx.Next()
+	}
+}
+
+// Allows the uploader of an attachment to complete an upload made directly to the object storage service with a presigned URL.
+// Content is verified against the size of the slot, while the service verifies the checksum. Completing an upload made
+// to the HTTP endpoint is a no-op.
+func initCompleteAttachmentHandler(db *data.DB, bs *data.BlobStore) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req attachmentReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		a, ok, err := (*db).GetAttachment(req.ID)
+		if err != nil {
+			return fmt.Errorf("route: attachment.complete: failed to get attachment: %v", err)
+		}
+		if !ok || a.Owner != ctx.Conn.Session.Get("userid").(string) {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Attachment not found."}
+			return ctx.Next()
+		}
+
+		if a.Uploaded {
+			ctx.Res = client.ACK
+			return ctx.Next()
+		}
+		p, ok := (*bs).(data.BlobPresigner)
+		if !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Attachment is not uploaded."}
+			return ctx.Next()
+		}
+
+		size, err := p.StatBlob(a.ID)
+		if err == data.ErrBlobNotFound {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Attachment is not uploaded."}
+			return ctx.Next()
+		}
+		if err != nil {
+			return fmt.Errorf("route: attachment.complete: failed to stat content: %v", err)
+		}
+		if size != a.Size {
+			if err := (*bs).DeleteBlob(a.ID); err != nil {
+				return fmt.Errorf("route: attachment.complete: failed to delete mismatching content: %v", err)
+			}
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Attachment size does not match.", Data: map[string]int64{"size": a.Size}}
+			return ctx.Next()
+		}
+
+		a.Uploaded = true
+		if err := (*db).SaveAttachment(a); err != nil {
+			return fmt.Errorf("route: attachment.complete: failed to save attachment: %v", err)
+		}
+		go generateThumbnails(*db, *bs, a.ID)
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Allows the uploader of an attachment and the participants of the conversations it is sent to, to retrieve the attachment
+// along with the URL to download its content at. If the content is kept in an object storage service, the URL is a presigned
+// one which expires in 15 minutes.
+func initGetAttachmentHandler(db *data.DB, bs *data.BlobStore) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req attachmentReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		a, ok, err := (*db).GetAttachment(req.ID)
+		if err != nil {
+			return fmt.Errorf("route: attachment.get: failed to get attachment: %v", err)
+		}
+		if !ok || !a.Uploaded || !canAccessAttachment(*db, a, ctx.Conn.Session.Get("userid").(string)) {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Attachment not found."}
+			return ctx.Next()
+		}
+
+		res := attachmentRes{AttachmentRef: *a.Ref(), URL: attachmentPath + a.ID}
+		if p, ok := (*bs).(data.BlobPresigner); ok {
+			if res.URL, err = p.PresignGet(a.ID, attachmentURLTTL); err != nil {
+				return fmt.Errorf("route: attachment.get: failed to presign download url: %v", err)
+			}
+		}
+
+		ctx.Res = res
+		return ctx.Next()
+	}
+}
+
+// Allows the uploader of an attachment to delete it, freeing up the storage quota.
+// Messages already sent with the attachment are kept, but its content cannot be downloaded anymore.
+func initDeleteAttachmentHandler(db *data.DB, bs *data.BlobStore) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req attachmentReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		// serialized with the updates, so the attachment is not saved back after deletion
+		attachmentMutex.Lock()
+		defer attachmentMutex.Unlock()
+
+		a, ok, err := (*db).GetAttachment(req.ID)
+		if err != nil {
+			return fmt.Errorf("route: attachment.delete: failed to get attachment: %v", err)
+		}
+		if !ok || a.Owner != ctx.Conn.Session.Get("userid").(string) {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Attachment not found."}
+			return ctx.Next()
+		}
+
+		if err := deleteAttachment(*db, *bs, a); err != nil {
+			return fmt.Errorf("route: attachment.delete: %v", err)
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// deleteAttachment deletes an attachment along with its content and thumbnails. Should be called with attachmentMutex held.
+func deleteAttachment(db data.DB, bs data.BlobStore, a *models.Attachment) error {
+	if err := bs.DeleteBlob(a.ID); err != nil {
+		return fmt.Errorf("failed to delete content: %v", err)
+	}
+	if err := deleteThumbnails(bs, a.ID, a.Thumbnails); err != nil {
+		return fmt.Errorf("failed to delete thumbnails: %v", err)
+	}
+	if err := db.DeleteAttachment(a.ID); err != nil {
+		return fmt.Errorf("failed to delete attachment: %v", err)
+	}
+	return nil
+}
+
+// attachmentUsage returns the total size of a user's attachments, including the upload slots which are not expired yet.
+// Expired upload slots are deleted along the way.
+func attachmentUsage(db data.DB, userID string) (int64, error) {
+	as, err := db.GetAttachments(userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get attachments: %v", err)
+	}
+
+	var used int64
+	for _, a := range as {
+		if !a.Uploaded && time.Since(a.Created) > attachmentSlotTTL {
+			if err := db.DeleteAttachment(a.ID); err != nil {
+				return 0, fmt.Errorf("failed to delete expired attachment: %v", err)
+			}
+			continue
+		}
+		used += a.Size
+	}
+	return used, nil
+}
+
+// canAccessAttachment reports whether the given user is the uploader of an attachment, or a participant of any of the conversations
+// the attachment is sent to.
+func canAccessAttachment(db data.DB, a *models.Attachment, userID string) bool {
+	if a.Owner == userID {
+		return true
+	}
+
+	for _, c := range a.Conversations {
+		if canAccessConversation(db, c, userID) {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessConversation reports whether the given user is a participant of a conversation, which is one of the two users
+// of a direct conversation or a member of the group of a group conversation.
+func canAccessConversation(db data.DB, conversation, userID string) bool {
+	groupID, users, ok := models.ParseConversation(conversation)
+	if !ok {
+		return false
+	}
+	if groupID != "" {
+		g, ok := db.GetGroup(groupID)
+		return ok && g.IsMember(userID)
+	}
+	return users[0] == userID || users[1] == userID
+}
+
+// attachmentMutex serializes the updates to the conversations and the thumbnails of the attachments, and their deletion,
+// so concurrent updates are not lost. Updates are not serialized across server instances.
+var attachmentMutex sync.Mutex
+
+// shareAttachment resolves the attachment reference of a message to be sent to the given conversation, and grants the participants
+// of the conversation access to the attachment. Attachment should be uploaded by the sender. Otherwise, the error response is set
+// on the request context and ok is false. Messages without an attachment are ok.
+func shareAttachment(ctx *neptulon.ReqCtx, db data.DB, ref *models.AttachmentRef, conversation string) (r *models.AttachmentRef, ok bool, err error) {
+	if ref == nil {
+		return nil, true, nil
+	}
+
+	attachmentMutex.Lock()
+	defer attachmentMutex.Unlock()
+
+	a, ok, err := db.GetAttachment(ref.ID)
+	if err != nil {
+		return nil, false, fmt.Errorf("route: %v: failed to get attachment: %v", ctx.Method, err)
+	}
+	if !ok || !a.Uploaded || a.Owner != ctx.Conn.Session.Get("userid").(string) {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Attachment not found.", Data: map[string]string{"id": ref.ID}}
+		return nil, false, nil
+	}
+
+	if !a.SharedIn(conversation) {
+		a.Conversations = append(a.Conversations, conversation)
+		if err := db.SaveAttachment(a); err != nil {
+			return nil, false, fmt.Errorf("route: %v: failed to save attachment: %v", ctx.Method, err)
+		}
+	}
+	return a.Ref(), true, nil
+}
@@ -0,0 +1,134 @@
+package titan
+
+import (
+	"fmt"
+
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+)
+
+func initBlockRoutes(r *middleware.Router, db *data.DB) {
+	r.Request("block.add", initBlockHandler(db))
+	r.Request("block.remove", initUnblockHandler(db))
+	r.Request("block.list", initListBlockedHandler(db))
+	r.Request("mute.add", initMuteHandler(db))
+	r.Request("mute.remove", initUnmuteHandler(db))
+	r.Request("mute.list", initListMutedHandler(db))
+}
+
+type blockReq struct {
+	UserID       string `json:"userid"`
+	Conversation string `json:"conversation"`
+}
+
+// Blocks a user, so the messages sent by the user to the caller are dropped silently, directly or through groups and topics.
+func initBlockHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req blockReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		if req.UserID == uid {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Cannot block yourself."}
+			return ctx.Next()
+		}
+		if _, ok := (*db).GetByID(req.UserID); !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "User not found."}
+			return ctx.Next()
+		}
+
+		if err := (*db).Block(uid, req.UserID); err != nil {
+			return fmt.Errorf("route: block.add: failed to block user: %v", err)
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Unblocks a user blocked by the caller.
+func initUnblockHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req blockReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		if err := (*db).Unblock(ctx.Conn.Session.Get("userid").(string), req.UserID); err != nil {
+			return fmt.Errorf("route: block.remove: failed to unblock user: %v", err)
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Lists the IDs of the users blocked by the caller, in ascending order.
+func initListBlockedHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		ids, err := (*db).GetBlocked(ctx.Conn.Session.Get("userid").(string))
+		if err != nil {
+			return fmt.Errorf("route: block.list: failed to get blocked users: %v", err)
+		}
+
+		ctx.Res = ids
+		return ctx.Next()
+	}
+}
+
+// Mutes a conversation, so no push notifications are sent to the caller for the messages of the conversation.
+// Messages are still delivered as usual.
+func initMuteHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req blockReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		if req.Conversation == "" {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Conversation not found."}
+			return ctx.Next()
+		}
+
+		if err := (*db).Mute(ctx.Conn.Session.Get("userid").(string), req.Conversation); err != nil {
+			return fmt.Errorf("route: mute.add: failed to mute conversation: %v", err)
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Unmutes a conversation muted by the caller.
+func initUnmuteHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req blockReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		if err := (*db).Unmute(ctx.Conn.Session.Get("userid").(string), req.Conversation); err != nil {
+			return fmt.Errorf("route: mute.remove: failed to unmute conversation: %v", err)
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Lists the IDs of the conversations muted by the caller, in ascending order.
+func initListMutedHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		convs, err := (*db).GetMuted(ctx.Conn.Session.Get("userid").(string))
+		if err != nil {
+			return fmt.Errorf("route: mute.list: failed to get muted conversations: %v", err)
+		}
+
+		ctx.Res = convs
+		return ctx.Next()
+	}
+}
@@ -0,0 +1,149 @@
+package titan
+
+import (
+	"fmt"
+
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+	"github.com/titan-x/titan/sms"
+)
+
+// discoverMaxPhones is the maximum number of phone numbers that can be looked up with a single contact.discover request.
+const discoverMaxPhones = 500
+
+func initContactRoutes(r *middleware.Router, db *data.DB) {
+	r.Request("contact.add", initAddContactHandler(db))
+	r.Request("contact.remove", initRemoveContactHandler(db))
+	r.Request("contact.list", initListContactsHandler(db))
+	r.Request("contact.discover", initDiscoverContactsHandler(db))
+}
+
+type contactReq struct {
+	UserID string `json:"userid"`
+}
+
+type contactListRes struct {
+	Contacts []string `json:"contacts"`
+	Cursor   string   `json:"cursor,omitempty"`
+}
+
+type discoverReq struct {
+	Phones []string `json:"phones"`
+}
+
+type discoveredContact struct {
+	Phone  string `json:"phone"` // Phone number as given in the request.
+	UserID string `json:"userid"`
+}
+
+// Adds a registered user to the contacts of the caller.
+func initAddContactHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req contactReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		if req.UserID == uid {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Cannot add yourself as a contact."}
+			return ctx.Next()
+		}
+		if _, ok := (*db).GetByID(req.UserID); !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "User not found."}
+			return ctx.Next()
+		}
+
+		if err := (*db).AddContact(uid, req.UserID); err != nil {
+			return fmt.Errorf("route: contact.add: failed to save contact: %v", err)
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Removes a user from the contacts of the caller.
+func initRemoveContactHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req contactReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		if err := (*db).RemoveContact(ctx.Conn.Session.Get("userid").(string), req.UserID); err != nil {
+			return fmt.Errorf("route: contact.remove: failed to remove contact: %v", err)
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Lists the IDs of the contacts of the caller in ascending order, in pages of given limit.
+// Cursor in the response is used to retrieve the next page.
+func initListContactsHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req pageReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		c, limit, ok := req.page(ctx)
+		if !ok {
+			return ctx.Next()
+		}
+
+		ids, err := (*db).GetContacts(ctx.Conn.Session.Get("userid").(string))
+		if err != nil {
+			return fmt.Errorf("route: contact.list: failed to get contacts: %v", err)
+		}
+
+		start, end, more := pageSlice(len(ids), c, limit)
+		ctx.Res = contactListRes{Contacts: ids[start:end], Cursor: c.next(end-start, more)}
+		return ctx.Next()
+	}
+}
+
+// Finds the registered users among the phone numbers in the address book of the caller, i.e. to suggest them as contacts.
+// Only the verified phone numbers are matched, and the users who blocked the caller are left out.
+func initDiscoverContactsHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req discoverReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+		if len(req.Phones) > discoverMaxPhones {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: fmt.Sprintf("Cannot look up more than %v phone numbers at once.", discoverMaxPhones)}
+			return ctx.Next()
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		found := []discoveredContact{}
+		seen := make(map[string]bool)
+		for _, p := range req.Phones {
+			phone, err := sms.NormalizeNumber(p)
+			if err != nil || seen[phone] {
+				continue
+			}
+			seen[phone] = true
+
+			u, ok := (*db).GetByPhone(phone)
+			if !ok || u.ID == uid {
+				continue
+			}
+			blocked, err := (*db).IsBlocked(u.ID, uid)
+			if err != nil {
+				return fmt.Errorf("route: contact.discover: failed to check blocks: %v", err)
+			}
+			if !blocked {
+				found = append(found, discoveredContact{Phone: p, UserID: u.ID})
+			}
+		}
+
+		ctx.Res = found
+		return ctx.Next()
+	}
+}
@@ -0,0 +1,186 @@
+package titan
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+)
+
+// exportPageSize is the number of messages retrieved from the database at a time while generating an export.
+const exportPageSize = 1000
+
+func initExportRoutes(r *middleware.Router, db *data.DB, q *data.Queue, bs *data.BlobStore) {
+	r.Request("msg.export", initExportHandler(db, q, bs))
+}
+
+type exportReq struct {
+	Conversation string `json:"conversation"`
+	Format       string `json:"format"`
+}
+
+// exportDoc is the JSON document an export consists of, which is put in the archive as messages.json in zip exports.
+type exportDoc struct {
+	Conversation string           `json:"conversation"`
+	Exported     time.Time        `json:"exported"`
+	Messages     []models.Message `json:"messages"`
+}
+
+// Allows clients to export the message history of a conversation they are a part of, as a JSON document or a zip archive of it
+// along with the content of the attachments. Export is generated in the background and the ID of the export is returned right away.
+// Once the export is ready, or fails, the user is notified with an export.ready request carrying the URL to download it at.
+// Exports are kept as attachments of the user, which can be retrieved again with attachment.get and deleted with attachment.delete.
+func initExportHandler(db *data.DB, q *data.Queue, bs *data.BlobStore) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req exportReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		if req.Format == "" {
+			req.Format = models.ExportJSON
+		}
+		if req.Format != models.ExportJSON && req.Format != models.ExportZip {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Export format must be json or zip."}
+			return ctx.Next()
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		if !canAccessConversation(*db, req.Conversation, uid) {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Conversation not found."}
+			return ctx.Next()
+		}
+
+		id, err := shortid.UUID()
+		if err != nil {
+			return fmt.Errorf("route: msg.export: failed to generate export ID: %v", err)
+		}
+
+		e := models.Export{ID: id, Conversation: req.Conversation, Format: req.Format}
+		go generateExport(*db, *q, *bs, uid, e, time.Now())
+
+		ctx.Res = e
+		return ctx.Next()
+	}
+}
+
+// generateExport generates an export of the messages of a conversation sent at or before the given time, saves it as an attachment
+// of the given user, and notifies the user with an export.ready request.
+func generateExport(db data.DB, q data.Queue, bs data.BlobStore, userID string, e models.Export, before time.Time) {
+	if err := writeExport(db, bs, userID, &e, before); err != nil {
+		reqLog.Errorf("failed to export conversation %v for user %v: %v", e.Conversation, userID, err)
+		e.Error = "Export failed."
+	}
+
+	if err := q.AddRequest(userID, "export.ready", e, ignoreResHandler); err != nil {
+		reqLog.Warnf("failed to notify user %v of export %v: %v", userID, e.ID, err)
+	}
+}
+
+// writeExport writes an export to the blob store and saves it as an attachment, filling in its size and URL.
+func writeExport(db data.DB, bs data.BlobStore, userID string, e *models.Export, before time.Time) error {
+	doc := exportDoc{Conversation: e.Conversation, Exported: time.Now(), Messages: []models.Message{}}
+	for offset := 0; ; offset += exportPageSize {
+		msgs, err := db.GetMessages(e.Conversation, before, offset, exportPageSize)
+		if err != nil {
+			return fmt.Errorf("failed to get messages: %v", err)
+		}
+		doc.Messages = append(doc.Messages, msgs...)
+		if len(msgs) < exportPageSize {
+			break
+		}
+	}
+	// messages are retrieved newest first, while exports read oldest first
+	for i, j := 0, len(doc.Messages)-1; i < j; i, j = i+1, j-1 {
+		doc.Messages[i], doc.Messages[j] = doc.Messages[j], doc.Messages[i]
+	}
+
+	var buf bytes.Buffer
+	mimeType := "application/json"
+	if e.Format == models.ExportZip {
+		mimeType = "application/zip"
+		if err := writeExportZip(&buf, db, bs, userID, &doc); err != nil {
+			return err
+		}
+	} else if err := json.NewEncoder(&buf).Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode messages: %v", err)
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	a := &models.Attachment{ID: e.ID, Owner: userID, Size: int64(buf.Len()), MimeType: mimeType, Checksum: hex.EncodeToString(sum[:]), Uploaded: true, Created: time.Now()}
+	if err := bs.PutBlob(a.ID, &buf); err != nil {
+		return fmt.Errorf("failed to store export: %v", err)
+	}
+	if err := db.SaveAttachment(a); err != nil {
+		return fmt.Errorf("failed to save attachment: %v", err)
+	}
+
+	e.Size, e.URL = a.Size, attachmentPath+a.ID
+	if p, ok := bs.(data.BlobPresigner); ok {
+		url, err := p.PresignGet(a.ID, attachmentURLTTL)
+		if err != nil {
+			return fmt.Errorf("failed to presign download url: %v", err)
+		}
+		e.URL = url
+	}
+	return nil
+}
+
+// writeExportZip writes a zip archive of the given export document as messages.json, along with the content of the attachments
+// of the messages the user can access as attachments/<id>. Attachments which are deleted in the meantime are left out.
+func writeExportZip(w io.Writer, db data.DB, bs data.BlobStore, userID string, doc *exportDoc) error {
+	z := zip.NewWriter(w)
+	f, err := z.Create("messages.json")
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %v", err)
+	}
+	if err := json.NewEncoder(f).Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode messages: %v", err)
+	}
+
+	added := make(map[string]bool)
+	for _, m := range doc.Messages {
+		if m.Attachment == nil || added[m.Attachment.ID] {
+			continue
+		}
+		added[m.Attachment.ID] = true
+
+		a, ok, err := db.GetAttachment(m.Attachment.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get attachment: %v", err)
+		}
+		if !ok || !a.Uploaded || !canAccessAttachment(db, a, userID) {
+			continue
+		}
+		r, err := bs.GetBlob(a.ID)
+		if err == data.ErrBlobNotFound {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get attachment content: %v", err)
+		}
+		f, err := z.Create("attachments/" + a.ID)
+		if err == nil {
+			_, err = io.Copy(f, r)
+		}
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("failed to archive attachment: %v", err)
+		}
+	}
+
+	if err := z.Close(); err != nil {
+		return fmt.Errorf("failed to close archive: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package titan
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+)
+
+func initGroupRoutes(r *middleware.Router, db *data.DB, q *data.Queue, ev *events, pu *pusher, fl *filters, ttl time.Duration) {
+	r.Request("group.create", initCreateGroupHandler(db))
+	r.Request("group.add", initAddGroupMembersHandler(db))
+	r.Request("group.leave", initLeaveGroupHandler(db))
+	r.Request("group.members", initGroupMembersHandler(db))
+	r.Request("group.send", initSendGroupMsgHandler(db, q, ev, pu, fl, ttl))
+}
+
+type groupReq struct {
+	ID         string                `json:"id"`
+	Name       string                `json:"name"`
+	Members    []string              `json:"members"`
+	Message    string                `json:"message"`
+	Attachment *models.AttachmentRef `json:"attachment"`
+	Encrypted  bool                  `json:"encrypted"`
+	Push       *models.PushOptions   `json:"push"`
+	pageReq
+}
+
+type groupMembersRes struct {
+	Members []string `json:"members"`
+	Cursor  string   `json:"cursor,omitempty"`
+}
+
+// Creates a new group conversation with the caller as the owner. The newly created group is returned.
+func initCreateGroupHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req groupReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		g := models.Group{Name: req.Name, Owner: uid, Members: addMembers([]string{uid}, req.Members), Created: time.Now()}
+		if err := (*db).SaveGroup(&g); err != nil {
+			return fmt.Errorf("route: group.create: failed to save group: %v", err)
+		}
+
+		ctx.Res = g
+		return ctx.Next()
+	}
+}
+
+// Adds new members to a group. Only existing members of a group can add new members.
+func initAddGroupMembersHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req groupReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		g, ok := getMemberGroup(ctx, db, req.ID)
+		if !ok {
+			return ctx.Next()
+		}
+
+		g.Members = addMembers(g.Members, req.Members)
+		if err := (*db).SaveGroup(g); err != nil {
+			return fmt.Errorf("route: group.add: failed to save group: %v", err)
+		}
+
+		ctx.Res = g
+		return ctx.Next()
+	}
+}
+
+// Removes the caller from the members of a group.
+func initLeaveGroupHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req groupReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		g, ok := getMemberGroup(ctx, db, req.ID)
+		if !ok {
+			return ctx.Next()
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		members := g.Members[:0]
+		for _, m := range g.Members {
+			if m != uid {
+				members = append(members, m)
+			}
+		}
+		g.Members = members
+
+		if err := (*db).SaveGroup(g); err != nil {
+			return fmt.Errorf("route: group.leave: failed to save group: %v", err)
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Lists the members of a group in pages of given limit. Only members of a group can list its members.
+// Cursor in the response is used to retrieve the next page.
+func initGroupMembersHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req groupReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		g, ok := getMemberGroup(ctx, db, req.ID)
+		if !ok {
+			return ctx.Next()
+		}
+
+		c, limit, ok := req.page(ctx)
+		if !ok {
+			return ctx.Next()
+		}
+
+		start, end, more := pageSlice(len(g.Members), c, limit)
+		ctx.Res = groupMembersRes{Members: g.Members[start:end], Cursor: c.next(end-start, more)}
+		return ctx.Next()
+	}
+}
+
+// Sends a message to all the members of a group except the sender, online or offline.
+// Messages are delivered with msg.recv requests with the group field set to the group ID, and persisted in the message history.
+// Messages which cannot be delivered within the given TTL are dropped from the queue, unless the TTL is zero.
+// Messages can carry an attachment uploaded by the sender, which the members of the group are granted access to.
+func initSendGroupMsgHandler(db *data.DB, q *data.Queue, ev *events, pu *pusher, fl *filters, ttl time.Duration) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req groupReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		if reason, ok := validPushOptions(req.Push); !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: reason}
+			return ctx.Next()
+		}
+
+		g, ok := getMemberGroup(ctx, db, req.ID)
+		if !ok {
+			return ctx.Next()
+		}
+
+		id, err := shortid.UUID()
+		if err != nil {
+			return fmt.Errorf("route: group.send: failed to generate message ID: %v", err)
+		}
+
+		att, ok, err := shareAttachment(ctx, *db, req.Attachment, models.GroupConversation(g.ID))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ctx.Next()
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		msg := models.Message{ID: id, From: uid, Group: g.ID, Conversation: models.GroupConversation(g.ID), Time: time.Now(), Message: req.Message, Attachment: att, State: models.StateSent, Encrypted: req.Encrypted}
+		if ok, err := filterMsg(ctx, fl, &msg); !ok {
+			if err != nil {
+				return err
+			}
+			return ctx.Next()
+		}
+		if err := traceDB(ctx, "SaveMessage", func() error { return (*db).SaveMessage(&msg) }); err != nil {
+			return fmt.Errorf("route: group.send: failed to save message: %v", err)
+		}
+		indexMsg(*db, &msg, g.Members)
+
+		msg.State = ""
+		msgs := []models.Message{msg}
+		for _, m := range g.Members {
+			if m == uid {
+				continue
+			}
+
+			// members who blocked the sender miss the message silently
+			if blocked, err := (*db).IsBlocked(m, uid); err != nil {
+				return fmt.Errorf("route: group.send: failed to get blocked state: %v", err)
+			} else if blocked {
+				continue
+			}
+
+			// members with full queues miss the message rather than failing it for the whole group
+			if err := (*q).AddTracedRequest(m, "msg.recv", msgs, ttl, reqSpanContext(ctx), ignoreResHandler); err == data.ErrQueueFull {
+				reqLog.Warnf("group message %v to user %v is discarded: %v", id, m, err)
+				continue
+			} else if err != nil {
+				return fmt.Errorf("route: group.send: failed to add request to queue with error: %v", err)
+			}
+			ev.publish(models.EventMsgQueued, m, msg)
+			pu.msgQueued(m, msg, req.Push, reqSpanContext(ctx))
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Retrieves the group with the given ID if the caller is a member of it.
+// Otherwise, sets the error response on the request context and returns false.
+func getMemberGroup(ctx *neptulon.ReqCtx, db *data.DB, id string) (*models.Group, bool) {
+	g, ok := (*db).GetGroup(id)
+	if !ok || !g.IsMember(ctx.Conn.Session.Get("userid").(string)) {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Group not found."}
+		return nil, false
+	}
+
+	return g, true
+}
+
+// Appends the new members to the list of existing members, skipping duplicates.
+func addMembers(members []string, newMembers []string) []string {
+	for _, n := range newMembers {
+		dup := false
+		for _, m := range members {
+			if m == n {
+				dup = true
+				break
+			}
+		}
+
+		if !dup && n != "" {
+			members = append(members, n)
+		}
+	}
+
+	return members
+}
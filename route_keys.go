@@ -0,0 +1,118 @@
+package titan
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+)
+
+const (
+	maxKeySize          = 1024 // maximum size of a public key or a signature in bytes
+	maxPreKeysPerUpload = 100
+	maxPreKeys          = 1000 // maximum number of one-time prekeys kept for a user
+)
+
+// initKeyRoutes registers the routes to distribute the public end-to-end encryption keys of the users.
+// Server only keeps the public keys, so the messages encrypted with them are opaque to the server.
+func initKeyRoutes(r *middleware.Router, db *data.DB) {
+	r.Request("keys.upload", initUploadKeysHandler(db))
+	r.Request("keys.count", initCountPreKeysHandler(db))
+	r.Request("keys.get", initGetKeysHandler(db))
+}
+
+type keysReq struct {
+	UserID string `json:"userid"`
+}
+
+// Allows clients to upload the identity key and the signed prekey of the user along with a batch of one-time prekeys
+// (i.e. upon registration, or to replenish the one-time prekeys). Existing one-time prekeys are kept unless the identity key changes.
+// Number of the one-time prekeys available is returned.
+func initUploadKeysHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var b models.KeyBundle
+		if err := ctx.Params(&b); err != nil {
+			return err
+		}
+
+		if len(b.IdentityKey) == 0 || b.SignedPreKey == nil || len(b.SignedPreKey.Key) == 0 || len(b.SignedPreKey.Signature) == 0 {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Identity key and signed prekey are required."}
+			return ctx.Next()
+		}
+		if len(b.PreKeys) > maxPreKeysPerUpload {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Too many prekeys.", Data: map[string]int{"max": maxPreKeysPerUpload}}
+			return ctx.Next()
+		}
+		large := len(b.IdentityKey) > maxKeySize || len(b.SignedPreKey.Key) > maxKeySize || len(b.SignedPreKey.Signature) > maxKeySize
+		for _, pk := range b.PreKeys {
+			large = large || len(pk.Key) == 0 || len(pk.Key) > maxKeySize
+		}
+		if large {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid key size.", Data: map[string]int{"max": maxKeySize}}
+			return ctx.Next()
+		}
+
+		// prekeys of a previous identity key are dropped, so they are not counted
+		b.UserID = ctx.Conn.Session.Get("userid").(string)
+		cur, ok, err := (*db).GetKeys(b.UserID)
+		if err != nil {
+			return fmt.Errorf("route: keys.upload: failed to get keys: %v", err)
+		}
+		n := 0
+		if ok && bytes.Equal(cur.IdentityKey, b.IdentityKey) {
+			if n, err = (*db).CountPreKeys(b.UserID); err != nil {
+				return fmt.Errorf("route: keys.upload: failed to count prekeys: %v", err)
+			}
+		}
+		if n+len(b.PreKeys) > maxPreKeys {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Too many prekeys.", Data: map[string]int{"max": maxPreKeys - n}}
+			return ctx.Next()
+		}
+
+		if err := (*db).SaveKeys(&b); err != nil {
+			return fmt.Errorf("route: keys.upload: failed to save keys: %v", err)
+		}
+
+		ctx.Res = n + len(b.PreKeys)
+		return ctx.Next()
+	}
+}
+
+// Allows clients to check the number of one-time prekeys left for the user, to upload more before they run out.
+func initCountPreKeysHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		n, err := (*db).CountPreKeys(ctx.Conn.Session.Get("userid").(string))
+		if err != nil {
+			return fmt.Errorf("route: keys.count: failed to count prekeys: %v", err)
+		}
+
+		ctx.Res = n
+		return ctx.Next()
+	}
+}
+
+// Allows senders to retrieve the key bundle of a user to establish an end-to-end encrypted session with.
+// Bundle carries one of the user's one-time prekeys, which is never given out again, or none if they ran out.
+func initGetKeysHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req keysReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		b, ok, err := (*db).ClaimKeys(req.UserID)
+		if err != nil {
+			return fmt.Errorf("route: keys.get: failed to get keys: %v", err)
+		}
+		if !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Keys not found."}
+			return ctx.Next()
+		}
+
+		ctx.Res = b
+		return ctx.Next()
+	}
+}
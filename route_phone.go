@@ -0,0 +1,100 @@
+package titan
+
+import (
+	"fmt"
+
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+	"github.com/titan-x/titan/sms"
+)
+
+func initPhoneRoutes(r *middleware.Router, db *data.DB, vf *verifier, au *audit) {
+	r.Request("phone.register", initRegisterPhoneHandler(vf))
+	r.Request("phone.verify", initVerifyPhoneHandler(db, vf, au))
+}
+
+type phoneReq struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code,omitempty"`
+}
+
+// Sends a verification code to a phone number in a text message, to be given back with phone.verify to bind the number
+// to the account of the caller. Requesting a code again invalidates the previous one.
+func initRegisterPhoneHandler(vf *verifier) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req phoneReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+		phone, err := sms.NormalizeNumber(req.Phone)
+		if err != nil {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid phone number. Phone numbers should be in international format, i.e. +46123456789."}
+			return ctx.Next()
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		switch err := vf.sendPhone(uid, phone); err {
+		case nil:
+		case errVerifySMSDisabled:
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Phone number verification is not enabled."}
+			return ctx.Next()
+		case errVerifyThrottled:
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "A verification code was sent recently. Please wait before requesting another one."}
+			return ctx.Next()
+		default:
+			return fmt.Errorf("route: phone.register: failed to send verification code to %v: %v", phone, err)
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Verifies a phone number with the code sent by phone.register and binds the number to the account of the caller,
+// so the caller can be discovered with contact.discover by the users who have the number. Phone numbers are recycled
+// by the carriers, so a number bound to another account is moved to the caller upon verification.
+func initVerifyPhoneHandler(db *data.DB, vf *verifier, au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req phoneReq
+		if err := ctx.Params(&req); err != nil || req.Code == "" {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Malformed or null verification code was provided."}
+			return ctx.Next()
+		}
+		phone, _ := sms.NormalizeNumber(req.Phone)
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		if _, err := vf.check(models.VerifyPhone, phone, uid, req.Code); err == errVerifyInvalid {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Invalid or expired verification code."}
+			au.failure(ctx.Conn, models.AuditPhone, "invalid verification code", map[string]string{"phone": phone})
+			return ctx.Next()
+		} else if err != nil {
+			return fmt.Errorf("route: phone.verify: failed to check verification code: %v", err)
+		}
+
+		user, ok := (*db).GetByID(uid)
+		if !ok {
+			return fmt.Errorf("route: phone.verify: user %v not found", uid)
+		}
+
+		var prev string
+		if pu, ok := (*db).GetByPhone(phone); ok && pu.ID != uid {
+			prev = pu.ID
+			pu.PhoneNumber = ""
+			if err := (*db).SaveUser(pu); err != nil {
+				return fmt.Errorf("route: phone.verify: failed to unbind phone number from user %v: %v", pu.ID, err)
+			}
+		}
+
+		user.PhoneNumber = phone
+		if err := (*db).SaveUser(user); err != nil {
+			return fmt.Errorf("route: phone.verify: failed to persist user information: %v", err)
+		}
+
+		ctx.Res = client.ACK
+		au.record(ctx.Conn, models.AuditEntry{Action: models.AuditPhone, Target: prev, Success: true, Details: map[string]string{"phone": phone}})
+		return ctx.Next()
+	}
+}
@@ -1,22 +1,44 @@
 package titan
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
-	"github.com/neptulon/neptulon"
-	"github.com/neptulon/neptulon/middleware"
+	"github.com/neptulon/shortid"
 	"github.com/titan-x/titan/client"
 	"github.com/titan-x/titan/data"
 	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
 )
 
 // We need *data.Queue (pointer to interface) so that the closure below won't capture the actual value that pointer points to
 // so we can swap queues whenever we want using Server.SetQueue(...)
-func initPrivRoutes(r *middleware.Router, q *data.Queue) {
+func initPrivRoutes(r *middleware.Router, db *data.DB, q *data.Queue, bs *data.BlobStore, p *presence, ev *events, dd *dedupe, ty *typing, pu *pusher, bt *bots, fl *filters, vf *verifier, au *audit) {
 	r.Request("auth.jwt", initJWTAuthHandler())
+	r.Request("auth.revoke", initRevokeAuthHandler(db, au))
 	r.Request("echo", middleware.Echo)
-	r.Request("msg.send", initSendMsgHandler(q))
+	r.Request("msg.send", initSendMsgHandler(db, q, ev, dd, pu, bt, fl, Conf.App.MsgTTL))
+	r.Request("msg.sendBatch", initSendBatchMsgHandler(db, q, ev, dd, pu, bt, fl, Conf.App.MsgTTL))
+	r.Request("msg.read", initReadMsgHandler(db, q, ev))
+	r.Request("msg.history", initMsgHistoryHandler(db))
+	r.Request("msg.search", initMsgSearchHandler(db))
+	r.Request("msg.typing", initTypingHandler(db, ty))
+	r.Request("presence.sub", initPresenceSubHandler(db, p))
+	initGroupRoutes(r, db, q, ev, pu, fl, Conf.App.MsgTTL)
+	initTopicRoutes(r, db, q, ev, fl, Conf.App.MsgTTL)
+	initContactRoutes(r, db)
+	initPhoneRoutes(r, db, vf, au)
+	initBlockRoutes(r, db)
+	initProfileRoutes(r, db, q)
+	initKeyRoutes(r, db)
+	initAttachmentRoutes(r, db, bs)
+	initExportRoutes(r, db, q, bs)
+	initSessionRoutes(r, db, p, au)
+	initAccountRoutes(r, db, p, ev, au)
 }
 
 // Used for a client to authenticate and announce its presence.
@@ -29,41 +51,360 @@ func initJWTAuthHandler() func(ctx *neptulon.ReqCtx) error {
 	}
 }
 
+// Allows clients to revoke their refresh tokens (i.e. upon logout or when a device is lost),
+// along with all the access tokens issued with them.
+func initRevokeAuthHandler(db *data.DB, au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		if err := revokeAuth(ctx, *db, au); err != nil {
+			return err
+		}
+		return ctx.Next()
+	}
+}
+
 // Allows clients to send messages to each other, online or offline.
-func initSendMsgHandler(q *data.Queue) func(ctx *neptulon.ReqCtx) error {
+// Each message is assigned a server generated ID and sender is notified of the delivery state transitions of the message
+// with msg.sent, msg.delivered, and msg.read requests. Messages are persisted in the message history along with their delivery state.
+// Messages which cannot be delivered within the given TTL are dropped from the queue, unless the TTL is zero.
+// Messages can carry a client generated ID (clientId), in which case the retries of the same message are not sent again.
+// Messages can also carry an attachment uploaded by the sender, which the recipient is granted access to.
+// If any of the messages could not be sent (see sendMsgs), the error response carries the receipts of all the messages.
+func initSendMsgHandler(db *data.DB, q *data.Queue, ev *events, dd *dedupe, pu *pusher, bt *bots, fl *filters, ttl time.Duration) func(ctx *neptulon.ReqCtx) error {
 	return func(ctx *neptulon.ReqCtx) error {
 		var sMsgs []models.Message
 		if err := ctx.Params(&sMsgs); err != nil {
 			return err
 		}
 
-		uid := ctx.Conn.Session.Get("userid").(string)
+		rs, err := sendMsgs(ctx, db, q, ev, dd, pu, bt, fl, ttl, sMsgs)
+		if err != nil || ctx.Err != nil {
+			return err
+		}
+		if len(failedReceipts(rs)) != 0 {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Some of the messages could not be sent.", Data: rs}
+			return ctx.Next()
+		}
 
-		for _, sMsg := range sMsgs {
-			from := uid
-			to := strings.ToLower(sMsg.To)
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// maxBatchSize is the maximum number of messages that can be sent with a single msg.sendBatch request.
+const maxBatchSize = 100
+
+type sendBatchReq struct {
+	To       []string         `json:"to"`
+	Message  string           `json:"message"`
+	Messages []models.Message `json:"messages"`
+}
+
+// messages returns the messages of the batch, either given as is or as one message body to be sent to each of the recipients.
+func (r *sendBatchReq) messages() []models.Message {
+	msgs := r.Messages
+	for _, to := range r.To {
+		msgs = append(msgs, models.Message{To: to, Message: r.Message})
+	}
+	return msgs
+}
 
-			// handle messages to bots
-			if to == "echo" {
-				from = "echo"
-				to = uid
+// Allows clients to send a message to multiple recipients (i.e. when forwarding to many contacts), or multiple messages,
+// with a single request: {"to": ["user1", "user2"], "message": "..."} or {"messages": [{"to": "user1", "message": "..."}, ...]}.
+// Whole batch is validated before any of the messages is sent, so an invalid batch is rejected as a whole.
+// Messages are delivered the same way as msg.send, and receipts of the messages are returned in the order of the messages
+// so the client can match the message IDs to the recipients. Messages which could not be saved or queued have the failed state
+// in their receipts, along with the reason, while the rest of the batch is sent.
+func initSendBatchMsgHandler(db *data.DB, q *data.Queue, ev *events, dd *dedupe, pu *pusher, bt *bots, fl *filters, ttl time.Duration) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req sendBatchReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		sMsgs := req.messages()
+		if len(sMsgs) == 0 || len(sMsgs) > maxBatchSize {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: fmt.Sprintf("Batch should have 1 to %v messages.", maxBatchSize)}
+			return ctx.Next()
+		}
+		for _, m := range sMsgs {
+			if m.To == "" {
+				ctx.Err = &neptulon.ResError{Code: 666, Message: "Message recipient is required."}
+				return ctx.Next()
 			}
+		}
+
+		rs, err := sendMsgs(ctx, db, q, ev, dd, pu, bt, fl, ttl, sMsgs)
+		if err != nil || ctx.Err != nil {
+			return err
+		}
+
+		ctx.Res = rs
+		return ctx.Next()
+	}
+}
+
+// queueFullMsg is the error message of the messages rejected as the recipient's queue is full.
+const queueFullMsg = "Recipient's queue is full."
+
+// sendMsgs persists and queues the given messages from the caller to their recipients, and queues a msg.sent request for the caller.
+// All the messages are checked before any of them is sent: if any of the recipients' queues is full, any of the messages is rejected
+// by the message filters, or the blocked state of any of the recipients cannot be retrieved, none of the messages is sent and the
+// error response is set on the request context. IDs of all the messages are also generated beforehand.
+// Messages are then sent one by one, and a message which could not be saved or queued (i.e. on a database error, or if the recipient's
+// queue got full in the meantime) does not stop the rest. Its receipt is returned in the failed state along with the reason,
+// and it is not included in the msg.sent request.
+// Messages with a client generated ID which were already sent (i.e. retried after reconnecting) are not sent again,
+// and their current receipts are returned instead. Receipts of the messages are returned in the order of the messages.
+func sendMsgs(ctx *neptulon.ReqCtx, db *data.DB, q *data.Queue, ev *events, dd *dedupe, pu *pusher, bt *bots, fl *filters, ttl time.Duration, sMsgs []models.Message) ([]models.Receipt, error) {
+	uid := ctx.Conn.Session.Get("userid").(string)
+	for i, m := range sMsgs {
+		if len(m.ClientID) > maxClientIDLen {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: fmt.Sprintf("Client message ID should be at most %v characters.", maxClientIDLen)}
+			return nil, nil
+		}
+		if reason, ok := validPushOptions(m.Push); !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: reason}
+			return nil, nil
+		}
+		if to := strings.ToLower(m.To); bt.full(to) || (to != "echo" && !bt.has(to) && (*q).Full(to)) {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: queueFullMsg, Data: map[string]string{"userid": to}}
+			return nil, nil
+		}
+		m.From, m.To = uid, strings.ToLower(m.To)
+		if ok, err := filterMsg(ctx, fl, &m); !ok {
+			return nil, err
+		}
+		sMsgs[i].Message = m.Message
+	}
+
+	// messages to the users who blocked the sender are dropped silently, so the sender cannot tell being blocked
+	blocked := make([]bool, len(sMsgs))
+	for i, m := range sMsgs {
+		from, to := uid, strings.ToLower(m.To)
+		if to == "echo" {
+			from, to = "echo", uid
+		}
+		err := traceDB(ctx, "IsBlocked", func() (err error) {
+			blocked[i], err = (*db).IsBlocked(to, from)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("route: %v: failed to get blocked state: %v", ctx.Method, err)
+		}
+	}
+
+	atts := make([]*models.AttachmentRef, len(sMsgs))
+	for i, m := range sMsgs {
+		a, ok, err := shareAttachment(ctx, *db, m.Attachment, models.DirectConversation(uid, strings.ToLower(m.To)))
+		if err != nil || !ok {
+			return nil, err
+		}
+		atts[i] = a
+	}
+
+	ids := make([]string, len(sMsgs))
+	for i := range ids {
+		id, err := shortid.UUID()
+		if err != nil {
+			return nil, fmt.Errorf("route: %v: failed to generate message ID: %v", ctx.Method, err)
+		}
+		ids[i] = id
+	}
+
+	var rs, sent []models.Receipt
+
+	for i, sMsg := range sMsgs {
+		id := ids[i]
+		from := uid
+		to := strings.ToLower(sMsg.To)
 
-			// submit the messages to send queue
-			err := (*q).AddRequest(to, "msg.recv", []models.Message{models.Message{From: from, Message: sMsg.Message}}, func(ctx *neptulon.ResCtx) error {
+		if sMsg.ClientID != "" {
+			if existing, dup := dd.reserve(uid, sMsg.ClientID, id); dup {
+				r, ok := deliveryState(*db, *q, existing)
+				if !ok {
+					r = models.Receipt{ID: existing, ClientID: sMsg.ClientID, From: uid, To: to, State: models.StateSent, Time: time.Now()}
+				}
+				rs = append(rs, r)
+				sent = append(sent, r)
+				continue
+			}
+		}
+
+		// handle messages to bots
+		if to == "echo" {
+			from = "echo"
+			to = uid
+		}
+
+		if blocked[i] {
+			r := models.Receipt{ID: id, ClientID: sMsg.ClientID, From: uid, To: to, State: models.StateSent, Time: time.Now()}
+			rs = append(rs, r)
+			sent = append(sent, r)
+			continue
+		}
+
+		now := time.Now()
+		r := models.Receipt{ID: id, ClientID: sMsg.ClientID, From: uid, To: strings.ToLower(sMsg.To), State: models.StateSent, Time: now}
+		failed := func(reason string) {
+			dd.release(uid, sMsg.ClientID)
+			r.State, r.Error = models.StateFailed, reason
+			rs = append(rs, r)
+		}
+
+		msg := models.Message{ID: id, From: from, To: to, Conversation: models.DirectConversation(from, to), Time: now, Message: sMsg.Message, Attachment: atts[i], State: models.StateSent, Encrypted: sMsg.Encrypted}
+		if err := traceDB(ctx, "SaveMessage", func() error { return (*db).SaveMessage(&msg) }); err != nil {
+			reqLog.Errorf("route: %v: failed to save message: %v", ctx.Method, err)
+			failed("Message could not be saved.")
+			continue
+		}
+		traceDB(ctx, "IndexMessage", func() error { indexMsg(*db, &msg, []string{from, to}); return nil })
+
+		// submit the messages to send queue, tracing the delivery (and the delivery receipt) as a part of the sender's request
+		msg.State = ""
+		sc := reqSpanContext(ctx)
+		toBot := bt.has(to)
+		var err error
+		if toBot {
+			err = bt.dispatch(msg)
+		} else {
+			err = (*q).AddTracedRequest(to, "msg.recv", []models.Message{msg}, ttl, sc, func(ctx *neptulon.ResCtx) error {
 				var res string
 				ctx.Result(&res)
 				if res == client.ACK {
-					// todo: send 'delivered' message to sender (as a request?) about this message (or failed, depending on output)
-					// todo: q.AddRequest(uid, "msg.delivered", ... // requeue if failed or handle resends automatically in the queue type, which is prefered)
+					if r, ok := setDeliveryState(*db, *q, models.Receipt{ID: id, State: models.StateDelivered, Time: time.Now(), Device: connDevice(ctx.Conn)}); ok {
+						setMsgState(*db, id, r.State)
+						ev.publish(models.EventMsgReceipt, r.From, r)
+						return (*q).AddTracedRequest(r.From, "msg.delivered", []models.Receipt{r}, 0, sc, ignoreResHandler)
+					}
 				} else {
 					// todo: auto retry or "msg.failed" ?
 				}
 				return nil
 			})
+		}
 
+		if err != nil {
+			// queue got full after the check above, or the request could not be persisted, while the message is already saved
+			setMsgState(*db, id, models.StateFailed)
+			if err == data.ErrQueueFull {
+				failed(queueFullMsg)
+			} else {
+				reqLog.Errorf("route: msg.recv: failed to add request to queue with error: %v", err)
+				failed("Message could not be queued.")
+			}
+			continue
+		}
+
+		// delivery state is recorded only once queued, so the failed messages are not tracked. recipient might have acknowledged
+		// the message already, in which case the state is restored from the message history and the sent state is kept in the msg.sent request.
+		// bots are not notified of the delivery state of their messages, as they have no connection to receive the receipts.
+		if from == uid && !bt.has(uid) {
+			if qr, ok := (*q).SetDeliveryState(models.Receipt{ID: id, ClientID: sMsg.ClientID, From: from, To: to, State: models.StateSent, Time: now}); ok {
+				r = qr
+			}
+			sent = append(sent, r)
+		}
+		rs = append(rs, r)
+		ev.publish(models.EventMsgQueued, to, msg)
+		if !toBot {
+			pu.msgQueued(to, msg, sMsg.Push, sc)
+		}
+	}
+
+	if len(sent) != 0 && !bt.has(uid) {
+		if err := (*q).AddRequest(uid, "msg.sent", sent, ignoreResHandler); err == data.ErrQueueFull {
+			reqLog.Warnf("msg.sent receipts for user %v are discarded: %v", uid, err)
+		} else if err != nil {
+			return nil, fmt.Errorf("route: msg.sent: failed to add request to queue with error: %v", err)
+		}
+	}
+
+	return rs, nil
+}
+
+// failedReceipts returns the receipts of the messages which could not be sent.
+func failedReceipts(rs []models.Receipt) []models.Receipt {
+	var failed []models.Receipt
+	for _, r := range rs {
+		if r.State == models.StateFailed {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// maxReadScan is the maximum number of messages of a conversation scanned for the unread messages up to a read watermark.
+const maxReadScan = 1000
+
+type readReq struct {
+	IDs        []string        `json:"ids"`
+	Watermarks []readWatermark `json:"watermarks"`
+}
+
+// readWatermark denotes the messages of a direct conversation up to and including the given message.
+type readWatermark struct {
+	Conversation string `json:"conversation"`
+	ID           string `json:"id"`
+}
+
+// UnmarshalJSON accepts a bare list of message IDs as well, which is the original form of msg.read params.
+func (r *readReq) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) != 0 && b[0] == '[' {
+		return json.Unmarshal(b, &r.IDs)
+	}
+
+	type plainReadReq readReq
+	return json.Unmarshal(b, (*plainReadReq)(r))
+}
+
+// Allows message recipients to mark messages as read in bulk, given the message IDs and/or read watermarks of conversations,
+// i.e. {"ids": ["..."], "watermarks": [{"conversation": "1:2", "id": "<ID of the latest read message>"}]}.
+// Senders of the messages are notified with a single msg.read request each, carrying the receipts of all their messages.
+func initReadMsgHandler(db *data.DB, q *data.Queue, ev *events) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req readReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		device := connDevice(ctx.Conn)
+		read := make(map[string][]models.Receipt) // sender -> receipts
+
+		ids := req.IDs
+		for _, w := range req.Watermarks {
+			unread, err := unreadMessages(*db, uid, w)
 			if err != nil {
-				return fmt.Errorf("route: msg.recv: failed to add request to queue with error: %v", err)
+				return fmt.Errorf("route: msg.read: failed to get messages: %v", err)
+			}
+			ids = append(ids, unread...)
+		}
+
+		now := time.Now()
+		seen := make(map[string]bool)
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			// only the recipient of a message can mark it as read
+			if r, ok := deliveryState(*db, *q, id); !ok || r.To != uid {
+				continue
+			}
+
+			if r, ok := setDeliveryState(*db, *q, models.Receipt{ID: id, State: models.StateRead, Time: now, Device: device}); ok {
+				setMsgState(*db, id, r.State)
+				ev.publish(models.EventMsgReceipt, r.From, r)
+				read[r.From] = append(read[r.From], r)
+			}
+		}
+
+		for from, rs := range read {
+			if err := (*q).AddRequest(from, "msg.read", rs, ignoreResHandler); err == data.ErrQueueFull {
+				reqLog.Warnf("msg.read receipts for user %v are discarded: %v", from, err)
+			} else if err != nil {
+				return fmt.Errorf("route: msg.read: failed to add request to queue with error: %v", err)
 			}
 		}
 
@@ -71,3 +412,257 @@ func initSendMsgHandler(q *data.Queue) func(ctx *neptulon.ReqCtx) error {
 		return ctx.Next()
 	}
 }
+
+// unreadMessages retrieves the IDs of the messages sent to the given user in a direct conversation, up to and including
+// the watermark message, newest first. Scanning stops at the first message that is already read, as the rest is read as well.
+// Watermarks of unknown messages or of the conversations the user is not a part of are ignored.
+func unreadMessages(db data.DB, userID string, w readWatermark) ([]string, error) {
+	groupID, users, ok := models.ParseConversation(w.Conversation)
+	if !ok || groupID != "" || (users[0] != userID && users[1] != userID) {
+		return nil, nil
+	}
+	m, ok := db.GetMessage(w.ID)
+	if !ok || m.Conversation != w.Conversation {
+		return nil, nil
+	}
+
+	var ids []string
+	for offset := 0; offset < maxReadScan; offset += pageLimitMax {
+		msgs, err := db.GetMessages(w.Conversation, m.Time, offset, pageLimitMax)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, msg := range msgs {
+			if msg.To != userID {
+				continue
+			}
+			if msg.State == models.StateRead {
+				return ids, nil
+			}
+			ids = append(ids, msg.ID)
+		}
+
+		if len(msgs) < pageLimitMax {
+			break
+		}
+	}
+	return ids, nil
+}
+
+type historyReq struct {
+	Conversation string `json:"conversation"`
+	pageReq
+}
+
+type historyRes struct {
+	Messages []models.Message `json:"messages"`
+	Cursor   string           `json:"cursor,omitempty"`
+}
+
+// Allows clients to retrieve the message history of a conversation they are part of (i.e. to backfill after reinstall).
+// Messages are returned newest first, in pages of given limit. Cursor in the response is used to retrieve the next (older) page.
+func initMsgHistoryHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req historyReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		if !canAccessConversation(*db, req.Conversation, ctx.Conn.Session.Get("userid").(string)) {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Conversation not found."}
+			return ctx.Next()
+		}
+
+		c, limit, ok := req.page(ctx)
+		if !ok {
+			return ctx.Next()
+		}
+
+		// one extra message is retrieved to see if there are any more messages left
+		msgs, err := (*db).GetMessages(req.Conversation, c.snapshot(), c.Offset, limit+1)
+		if err != nil {
+			return fmt.Errorf("route: msg.history: failed to get messages: %v", err)
+		}
+
+		more := len(msgs) > limit
+		if more {
+			msgs = msgs[:limit]
+		}
+
+		ctx.Res = historyRes{Messages: msgs, Cursor: c.next(len(msgs), more)}
+		return ctx.Next()
+	}
+}
+
+type searchReq struct {
+	Query        string `json:"query"`
+	Conversation string `json:"conversation"`
+	pageReq
+}
+
+// Allows clients to search the messages they sent or received for the given words, in a conversation or in all conversations.
+// Messages containing all the words are returned in pages of given limit, the most relevant ones first, and then the newest ones.
+// Cursor in the response is used to retrieve the next page. End-to-end encrypted messages are not searchable.
+func initMsgSearchHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req searchReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		if len(data.SearchTerms(req.Query)) == 0 {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Search query has no words."}
+			return ctx.Next()
+		}
+
+		c, limit, ok := req.page(ctx)
+		if !ok {
+			return ctx.Next()
+		}
+
+		// one extra message is retrieved to see if there are any more matches left
+		msgs, err := (*db).SearchMessages(ctx.Conn.Session.Get("userid").(string), req.Query, req.Conversation, c.snapshot(), c.Offset, limit+1)
+		if err != nil {
+			return fmt.Errorf("route: msg.search: failed to search messages: %v", err)
+		}
+
+		more := len(msgs) > limit
+		if more {
+			msgs = msgs[:limit]
+		}
+
+		ctx.Res = historyRes{Messages: msgs, Cursor: c.next(len(msgs), more)}
+		return ctx.Next()
+	}
+}
+
+// Allows clients to notify the other participants of a conversation that the user started or stopped typing.
+// Notifications are relayed with msg.typing requests to the participants who are online, and are dropped if throttled.
+func initTypingHandler(db *data.DB, ty *typing) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var n models.Typing
+		if err := ctx.Params(&n); err != nil {
+			return err
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		groupID, users, ok := models.ParseConversation(n.Conversation)
+		if ok && groupID != "" {
+			var g *models.Group
+			if g, ok = getMemberGroup(ctx, db, groupID); ok {
+				users = g.Members
+			}
+		} else if ok {
+			ok = users[0] == uid || users[1] == uid
+		}
+		if !ok {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Conversation not found."}
+			return ctx.Next()
+		}
+
+		n.UserID = uid
+		if ty.allow(uid, n) {
+			ty.relay(n, users)
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// maxPresenceSubs is the maximum number of users that can be subscribed to with a single presence.sub request.
+const maxPresenceSubs = 500
+
+// Allows clients to subscribe to the presence updates of their contacts.
+// Current presence of the users are returned and any further updates are sent with presence.update requests.
+// Users who are not in the contacts of the caller, or who blocked the caller, are omitted.
+func initPresenceSubHandler(db *data.DB, p *presence) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var ids []string
+		if err := ctx.Params(&ids); err != nil {
+			return err
+		}
+		if len(ids) > maxPresenceSubs {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: fmt.Sprintf("Cannot subscribe to more than %v users at once.", maxPresenceSubs)}
+			return ctx.Next()
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		allowed := make([]string, 0, len(ids))
+		for _, id := range ids {
+			ok, err := (*db).HasContact(uid, id)
+			if err != nil {
+				return fmt.Errorf("route: presence.sub: failed to get contact: %v", err)
+			}
+			if !ok {
+				continue
+			}
+			blocked, err := (*db).IsBlocked(id, uid)
+			if err != nil {
+				return fmt.Errorf("route: presence.sub: failed to check blocks: %v", err)
+			}
+			if !blocked {
+				allowed = append(allowed, id)
+			}
+		}
+
+		ctx.Res = p.Subscribe(uid, allowed)
+		return ctx.Next()
+	}
+}
+
+// Response handler for notification-like requests where the response does not matter.
+func ignoreResHandler(ctx *neptulon.ResCtx) error {
+	return nil
+}
+
+// connDevice retrieves the name of the device given upon authentication, or the connection ID if none was given.
+func connDevice(c *neptulon.Conn) string {
+	if d, ok := c.Session.GetOk("device"); ok {
+		return d.(string)
+	}
+	return c.ID
+}
+
+// indexMsg indexes a saved message for search by the given users, unless the message is end-to-end encrypted.
+// Failures are only logged as the search index is not essential for delivering the message.
+func indexMsg(db data.DB, m *models.Message, userIDs []string) {
+	if m.Encrypted {
+		return
+	}
+	if err := db.IndexMessage(m, userIDs); err != nil {
+		reqLog.Errorf("failed to index message: %v: %v", m.ID, err)
+	}
+}
+
+// deliveryState retrieves the latest delivery state of a message from the queue, or from the message history if the queue
+// no longer tracks the message (i.e. once it is read or dead-lettered), in which case the queue tracks it again.
+func deliveryState(db data.DB, q data.Queue, id string) (r models.Receipt, ok bool) {
+	if r, ok = q.GetDeliveryState(id); ok {
+		return r, true
+	}
+	m, ok := db.GetMessage(id)
+	if !ok || m.State == "" {
+		return r, false
+	}
+	r, _ = q.SetDeliveryState(models.Receipt{ID: m.ID, From: m.From, To: m.To, State: m.State, Time: m.Time})
+	return r, true
+}
+
+// setDeliveryState records a delivery state transition of a message with data.Queue.SetDeliveryState, restoring the state
+// of the message from the message history first if the queue no longer tracks it.
+func setDeliveryState(db data.DB, q data.Queue, r models.Receipt) (updated models.Receipt, ok bool) {
+	if _, ok := deliveryState(db, q, r.ID); !ok {
+		return r, false
+	}
+	return q.SetDeliveryState(r)
+}
+
+// setMsgState updates the delivery state of a message in the message history.
+// Failures are only logged as the message is already delivered and the history is not essential for that.
+func setMsgState(db data.DB, id, state string) {
+	if err := db.UpdateDeliveryState(id, state); err != nil {
+		reqLog.Errorf("failed to update message state: %v: %v", id, err)
+	}
+}
@@ -0,0 +1,95 @@
+package titan
+
+import (
+	"fmt"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+)
+
+// Maximum lengths of the profile fields set by the users.
+const (
+	profileNameMaxLen   = 64
+	profileStatusMaxLen = 140
+	profileAvatarMaxLen = 512
+)
+
+func initProfileRoutes(r *middleware.Router, db *data.DB, q *data.Queue) {
+	r.Request("profile.set", initSetProfileHandler(db, q))
+	r.Request("profile.get", initGetProfilesHandler(db))
+}
+
+// Replaces the profile of the caller with the given one and returns it. Users who have the caller in their contacts are notified
+// of the new profile with profile.update requests.
+func initSetProfileHandler(db *data.DB, q *data.Queue) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req models.Profile
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		if len(req.Name) > profileNameMaxLen || len(req.Status) > profileStatusMaxLen || len(req.Avatar) > profileAvatarMaxLen {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: fmt.Sprintf("Profile name, status, and avatar are limited to %v, %v, and %v characters.", profileNameMaxLen, profileStatusMaxLen, profileAvatarMaxLen)}
+			return ctx.Next()
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		u, ok := (*db).GetByID(uid)
+		if !ok {
+			return fmt.Errorf("route: profile.set: failed to get user: %v", uid)
+		}
+
+		u.Name, u.Status, u.Avatar = req.Name, req.Status, req.Avatar
+		if err := (*db).SaveUser(u); err != nil {
+			return fmt.Errorf("route: profile.set: failed to save user: %v", err)
+		}
+
+		p := u.Profile()
+		ids, err := (*db).GetContactOf(uid)
+		if err != nil {
+			return fmt.Errorf("route: profile.set: failed to get contacts: %v", err)
+		}
+		for _, id := range ids {
+			if err := (*q).AddRequest(id, "profile.update", p, ignoreResHandler); err != nil {
+				reqLog.Warnf("failed to notify user %v of profile update of user %v: %v", id, uid, err)
+			}
+		}
+
+		ctx.Res = p
+		return ctx.Next()
+	}
+}
+
+// Retrieves the profiles of the given users. Only the profiles of the caller and the caller's contacts are returned,
+// and the rest of the users are omitted.
+func initGetProfilesHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var ids []string
+		if err := ctx.Params(&ids); err != nil {
+			return err
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		ps := []models.Profile{}
+		for _, id := range ids {
+			if id != uid {
+				ok, err := (*db).HasContact(uid, id)
+				if err != nil {
+					return fmt.Errorf("route: profile.get: failed to get contact: %v", err)
+				}
+				if !ok {
+					continue
+				}
+			}
+
+			if u, ok := (*db).GetByID(id); ok {
+				ps = append(ps, u.Profile())
+			}
+		}
+
+		ctx.Res = ps
+		return ctx.Next()
+	}
+}
@@ -1,25 +1,99 @@
 package titan
 
 import (
-	"github.com/neptulon/neptulon"
-	"github.com/neptulon/neptulon/middleware"
 	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
 )
 
 // We need *data.DB (pointer to interface) so that the closure below won't capture the actual value that pointer points to
 // so we can swap databases whenever we want using Server.SetDB(...)
 //
 // Also we don't do `return ctx.Next()` so that request won't reach the private routes.
-func initPubRoutes(r *middleware.Router, db *data.DB, pass string) {
-	r.Request("auth.google", initGoogleAuthHandler(db, pass))
+func initPubRoutes(r *middleware.Router, db *data.DB, keys *jwtKeys, ev *events, vf *verifier, au *audit) {
+	r.Request("auth.google", initGoogleAuthHandler(db, keys, ev, au))
+	r.Request("auth.register", initRegisterHandler(db, vf, au))
+	r.Request("auth.verifyEmail", initVerifyEmailHandler(db, keys, vf, ev, au))
+	r.Request("auth.refresh", initRefreshAuthHandler(db, keys, au))
+	r.Request("conn.caps", initCapsHandler(Conf.App.CompressThreshold))
 }
 
-func initGoogleAuthHandler(db *data.DB, pass string) func(ctx *neptulon.ReqCtx) error {
+func initGoogleAuthHandler(db *data.DB, keys *jwtKeys, ev *events, au *audit) func(ctx *neptulon.ReqCtx) error {
 	return func(ctx *neptulon.ReqCtx) error {
-		if err := googleAuth(ctx, *db, pass); err != nil {
+		if err := googleAuth(ctx, *db, keys, ev, au); err != nil {
 			return err
 		}
 
 		return nil
 	}
 }
+
+func initRegisterHandler(db *data.DB, vf *verifier, au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		return emailRegister(ctx, *db, vf, au)
+	}
+}
+
+func initVerifyEmailHandler(db *data.DB, keys *jwtKeys, vf *verifier, ev *events, au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		return emailVerify(ctx, *db, keys, vf, ev, au)
+	}
+}
+
+func initRefreshAuthHandler(db *data.DB, keys *jwtKeys, au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		return refreshAuth(ctx, *db, keys, au)
+	}
+}
+
+// Negotiates the optional protocol features with the client, which is done right after connecting so it precedes authentication.
+// Server picks the first codec offered by the client that it supports, and encodes the messages with it after the response.
+// Otherwise, server picks the first supported compression algorithm, and compresses the messages larger than the threshold
+// from then on. Compression is not negotiated at all if the threshold is negative.
+func initCapsHandler(compressThreshold int) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var offer models.Capabilities
+		if err := ctx.Params(&offer); err != nil {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Malformed capabilities were provided."}
+			return nil
+		}
+
+		var caps models.Capabilities
+		if codec := pickCodec(offer.Codecs); codec != nil {
+			// codec switch takes effect after this response, and compression is not combined with codecs
+			ctx.SetCodec(codec)
+			caps.Codecs = []string{codec.Name()}
+		} else if a := pickCompression(offer.Compression); a != "" && compressThreshold >= 0 {
+			if err := ctx.Conn.SetCompression(a, compressThreshold); err != nil {
+				return err
+			}
+			caps.Compression, caps.CompressionThreshold = []string{a}, compressThreshold
+		}
+
+		ctx.Res = caps
+		return nil
+	}
+}
+
+// pickCompression returns the first one of the offered compression algorithms that is supported, or an empty string if none is.
+func pickCompression(offer []string) string {
+	for _, o := range offer {
+		for _, a := range neptulon.CompressionAlgorithms {
+			if o == a {
+				return a
+			}
+		}
+	}
+	return ""
+}
+
+// pickCodec returns the first one of the offered codecs that is supported, or nil if none is.
+func pickCodec(offer []string) neptulon.Codec {
+	for _, o := range offer {
+		if c := neptulon.LookupCodec(o); c != nil {
+			return c
+		}
+	}
+	return nil
+}
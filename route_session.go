@@ -0,0 +1,95 @@
+package titan
+
+import (
+	"fmt"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+)
+
+// initSessionRoutes registers the routes for the users to see and manage the connected devices of their own.
+func initSessionRoutes(r *middleware.Router, db *data.DB, p *presence, au *audit) {
+	r.Request("session.list", initListSessionsHandler(p))
+	r.Request("session.revoke", initRevokeSessionHandler(db, p, au))
+}
+
+// connModel describes a live connection.
+func connModel(c *neptulon.Conn) models.Conn {
+	device, _ := c.Session.Get("device").(string)
+	return models.Conn{
+		ID:           c.ID,
+		UserID:       c.Session.Get("userid").(string),
+		Device:       device,
+		RemoteAddr:   fmt.Sprint(c.RemoteAddr()),
+		ConnectedAt:  c.ConnectedAt(),
+		LastActivity: c.LastActivity(),
+	}
+}
+
+// Lists the live connections of the calling user, on all the devices, with the connection the request is made through marked as current.
+func initListSessionsHandler(p *presence) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		conns := []models.Conn{}
+		for _, c := range p.UserConns(ctx.Conn.Session.Get("userid").(string)) {
+			m := connModel(c)
+			m.Current = c == ctx.Conn
+			conns = append(conns, m)
+		}
+
+		ctx.Res = conns
+		return ctx.Next()
+	}
+}
+
+type revokeSessionReq struct {
+	ID     string `json:"id"`     // connection ID as listed by session.list
+	Device string `json:"device"` // device name, in place of the connection ID
+}
+
+// Force-logs out a device of the calling user (i.e. a lost phone) by revoking its refresh tokens, along with all the access tokens issued
+// with them, and closing its live connections. Device is given either by name, or by the ID of one of its connections as listed by
+// session.list, which also works for the connections without a device name by closing just that connection.
+// Connection the request is made through is left open.
+func initRevokeSessionHandler(db *data.DB, p *presence, au *audit) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req revokeSessionReq
+		if err := ctx.Params(&req); err != nil || (req.ID == "" && req.Device == "") {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Connection ID or device name is required."}
+			return ctx.Next()
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		if req.ID != "" {
+			c, ok := p.Conn(req.ID)
+			if !ok || c.Session.Get("userid").(string) != uid {
+				ctx.Err = &neptulon.ResError{Code: 666, Message: "Connection not found."}
+				return ctx.Next()
+			}
+			device, _ := c.Session.Get("device").(string)
+			if device == "" {
+				var res revokeDeviceRes
+				if c != ctx.Conn {
+					if err := c.Close(); err != nil {
+						authLog.Warnf("failed to close connection %v: %v", c.ID, err)
+					}
+					res.Closed = 1
+				}
+				ctx.Res = res
+				return ctx.Next()
+			}
+			req.Device = device
+		}
+
+		revoked, closed, err := revokeDevice(*db, p, uid, req.Device, ctx.Conn)
+		if err != nil {
+			return fmt.Errorf("route: session.revoke: %v", err)
+		}
+		au.record(ctx.Conn, models.AuditEntry{Action: models.AuditRevoke, Success: true, Details: map[string]string{"device": req.Device}})
+		authLog.Infof("device %q of user %v revoked by the user, tokens: %v, connections: %v", req.Device, uid, revoked, closed)
+
+		ctx.Res = revokeDeviceRes{Revoked: revoked, Closed: closed}
+		return ctx.Next()
+	}
+}
@@ -0,0 +1,230 @@
+package titan
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/neptulon/shortid"
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+)
+
+// topicNameRe is the format of the topic names: up to 64 letters, digits, dots, dashes, and underscores.
+var topicNameRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// topicPageSize is the number of subscribers retrieved from the database at a time while publishing a message to a topic.
+const topicPageSize = 1000
+
+func initTopicRoutes(r *middleware.Router, db *data.DB, q *data.Queue, ev *events, fl *filters, ttl time.Duration) {
+	r.Request("topic.subscribe", initSubscribeTopicHandler(db))
+	r.Request("topic.unsubscribe", initUnsubscribeTopicHandler(db))
+	r.Request("topic.list", initListTopicsHandler(db))
+	r.Request("topic.publish", initPublishTopicHandler(db, q, ev, fl, ttl))
+}
+
+type topicReq struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Message     string `json:"message"`
+}
+
+// Subscribes the caller to a topic, so the messages published to the topic are delivered to the caller from then on.
+func initSubscribeTopicHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req topicReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		t, ok, err := getTopic(ctx, *db, req.Name)
+		if err != nil {
+			return fmt.Errorf("route: topic.subscribe: %v", err)
+		}
+		if !ok {
+			return ctx.Next()
+		}
+
+		if err := (*db).Subscribe(t.Name, ctx.Conn.Session.Get("userid").(string)); err != nil {
+			return fmt.Errorf("route: topic.subscribe: failed to save subscription: %v", err)
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Unsubscribes the caller from a topic.
+func initUnsubscribeTopicHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req topicReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		if err := (*db).Unsubscribe(req.Name, ctx.Conn.Session.Get("userid").(string)); err != nil {
+			return fmt.Errorf("route: topic.unsubscribe: failed to remove subscription: %v", err)
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Lists the topics the caller is subscribed to.
+func initListTopicsHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		names, err := (*db).GetSubscriptions(ctx.Conn.Session.Get("userid").(string))
+		if err != nil {
+			return fmt.Errorf("route: topic.list: failed to get subscriptions: %v", err)
+		}
+
+		ts := []models.Topic{}
+		for _, n := range names {
+			t, ok, err := (*db).GetTopic(n)
+			if err != nil {
+				return fmt.Errorf("route: topic.list: failed to get topic: %v", err)
+			}
+			if ok {
+				ts = append(ts, *t)
+			}
+		}
+
+		ctx.Res = ts
+		return ctx.Next()
+	}
+}
+
+// Publishes a message to all the subscribers of a topic except the sender, online or offline. Only the subscribers of a topic
+// and the admins can publish to it. Messages are delivered with msg.recv requests with the topic field set to the topic name,
+// in the order of publishing, and are not kept in the message history. Messages which cannot be delivered within the given TTL
+// are dropped from the queue, unless the TTL is zero.
+func initPublishTopicHandler(db *data.DB, q *data.Queue, ev *events, fl *filters, ttl time.Duration) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req topicReq
+		if err := ctx.Params(&req); err != nil {
+			return err
+		}
+
+		t, ok, err := getTopic(ctx, *db, req.Name)
+		if err != nil {
+			return fmt.Errorf("route: topic.publish: %v", err)
+		}
+		if !ok {
+			return ctx.Next()
+		}
+
+		uid := ctx.Conn.Session.Get("userid").(string)
+		if !hasRole(connRole(ctx.Conn), models.RoleAdmin) {
+			subs, err := (*db).GetSubscriptions(uid)
+			if err != nil {
+				return fmt.Errorf("route: topic.publish: failed to get subscriptions: %v", err)
+			}
+			if !contains(subs, t.Name) {
+				ctx.Err = &neptulon.ResError{Code: 666, Message: "Not subscribed to the topic."}
+				return ctx.Next()
+			}
+		}
+
+		id, err := shortid.UUID()
+		if err != nil {
+			return fmt.Errorf("route: topic.publish: failed to generate message ID: %v", err)
+		}
+
+		msg := models.Message{ID: id, From: uid, Topic: t.Name, Conversation: models.TopicConversation(t.Name), Time: time.Now(), Message: req.Message}
+		if ok, err := filterMsg(ctx, fl, &msg); !ok {
+			if err != nil {
+				return err
+			}
+			return ctx.Next()
+		}
+		msgs := []models.Message{msg}
+		for after := ""; ; {
+			subs, err := (*db).GetSubscribers(t.Name, after, topicPageSize)
+			if err != nil {
+				return fmt.Errorf("route: topic.publish: failed to get subscribers: %v", err)
+			}
+			if len(subs) == 0 {
+				break
+			}
+			after = subs[len(subs)-1]
+
+			for _, s := range subs {
+				if s == uid {
+					continue
+				}
+
+				// subscribers who blocked the publisher miss the message silently
+				if blocked, err := (*db).IsBlocked(s, uid); err != nil {
+					return fmt.Errorf("route: topic.publish: failed to get blocked state: %v", err)
+				} else if blocked {
+					continue
+				}
+
+				// subscribers with full queues miss the message rather than failing it for the whole topic
+				if err := (*q).AddTracedRequest(s, "msg.recv", msgs, ttl, reqSpanContext(ctx), ignoreResHandler); err == data.ErrQueueFull {
+					reqLog.Warnf("topic message %v to user %v is discarded: %v", id, s, err)
+					continue
+				} else if err != nil {
+					return fmt.Errorf("route: topic.publish: failed to add request to queue with error: %v", err)
+				}
+				ev.publish(models.EventMsgQueued, s, msg)
+			}
+		}
+
+		ctx.Res = client.ACK
+		return ctx.Next()
+	}
+}
+
+// Creates a topic, or updates the description of an existing one. The topic is returned.
+func initCreateTopicHandler(db *data.DB) func(ctx *neptulon.ReqCtx) error {
+	return func(ctx *neptulon.ReqCtx) error {
+		var req topicReq
+		if err := ctx.Params(&req); err != nil || !topicNameRe.MatchString(req.Name) {
+			ctx.Err = &neptulon.ResError{Code: 666, Message: "Topic name must be 1-64 letters, digits, dots, dashes, or underscores."}
+			return ctx.Next()
+		}
+
+		t, ok, err := (*db).GetTopic(req.Name)
+		if err != nil {
+			return fmt.Errorf("route: admin.topic.create: failed to get topic: %v", err)
+		}
+		if !ok {
+			t = &models.Topic{Name: req.Name, Created: time.Now()}
+		}
+		t.Description = req.Description
+		if err := (*db).SaveTopic(t); err != nil {
+			return fmt.Errorf("route: admin.topic.create: failed to save topic: %v", err)
+		}
+		adminLog.Infof("topic %v saved by user: %v", t.Name, ctx.Conn.Session.Get("userid"))
+
+		ctx.Res = t
+		return ctx.Next()
+	}
+}
+
+// Retrieves the topic with the given name. If there is no such topic, sets the error response on the request context and returns false.
+func getTopic(ctx *neptulon.ReqCtx, db data.DB, name string) (*models.Topic, bool, error) {
+	t, ok, err := db.GetTopic(name)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get topic: %v", err)
+	}
+	if !ok {
+		ctx.Err = &neptulon.ResError{Code: 666, Message: "Topic not found."}
+		return nil, false, nil
+	}
+	return t, true, nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,11 +1,29 @@
 package titan
 
 import (
-	"github.com/neptulon/neptulon"
-	"github.com/neptulon/neptulon/middleware"
-	"github.com/neptulon/neptulon/middleware/jwt"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/titan-x/titan/acme"
 	"github.com/titan-x/titan/data"
 	"github.com/titan-x/titan/data/inmem"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/mail"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/neptulon/middleware"
+	"github.com/titan-x/titan/sms"
+)
+
+var (
+	quicLog = log.Component("quic")
+	connLog = log.Component("conn")
 )
 
 // Server wraps a listener instance and registers default connection and message handlers with the listener.
@@ -16,17 +34,122 @@ type Server struct {
 	privRouter *middleware.Router
 
 	// titan server components
-	db    data.DB
-	queue data.Queue
+	db        data.DB
+	queue     data.Queue
+	blobs     data.BlobStore
+	presence  *presence
+	jwtKeys   *jwtKeys
+	limiter   *rateLimiter
+	events    *events
+	hooks     *hooks
+	dedupe    *dedupe
+	pusher    *pusher
+	verifier  *verifier
+	twoFactor *twoFactor
+	bots      *bots
+	filters   *filters
+	audit     *audit
+	abuse     *abuse
+
+	// custom middleware, chained before and after the authentication middleware
+	beforeAuth []func(ctx *neptulon.ReqCtx) error
+	afterAuth  []func(ctx *neptulon.ReqCtx) error
+	chained    int32 // 1 if the middleware chain is built, accessed atomically
+
+	tlsCertFile    string
+	tlsKeyFile     string
+	sniCertFiles   []string
+	sniKeyFiles    []string
+	tlsReload      chan os.Signal
+	clientCA       *clientCA
+	acme           *acme.Manager
+	acmeHTTPAddr   string
+	acmeListener   net.Listener
+	acmeDone       chan struct{}
+	queueStore     data.QueueStore
+	cluster        data.Cluster
+	listening      int32 // 1 if the server is listening for connections, accessed atomically
+	draining       int32 // 1 if the server is draining, accessed atomically
+	broadcastRate  int32 // users per second a broadcast is enqueued for, accessed atomically
+	healthListener net.Listener
+	debugListener  net.Listener
+	longPoll       *longPoll
+	quicListener   net.Listener
+	extraListeners []net.Listener
+	gcmDone        chan struct{}
+	reaperDone     chan struct{}
+	closeOnce      sync.Once
+	closeErr       error
 }
 
+// QUICALPN is the TLS application protocol the QUIC clients must negotiate.
+const QUICALPN = "titan"
+
 // NewServer creates a new server.
 func NewServer(addr string) (*Server, error) {
 	if (Conf == Config{}) {
 		InitConf("")
 	}
 
-	s := Server{neptulon: neptulon.NewServer(addr)}
+	s := Server{neptulon: neptulon.NewServer(addr), jwtKeys: newJWTKeys(Conf.App.JWTPass(), Conf.App.JWTPrevPasses()...)}
+	s.neptulon.ConnLimitPerIP(Conf.App.MaxConnsPerIP)
+	proxyNets, err := Conf.App.ProxyNets()
+	if err != nil {
+		return nil, err
+	}
+	s.neptulon.UseProxyProtocol(proxyNets)
+	s.neptulon.SetListenerConfig(listenerConfig(&Conf.App))
+	policy, err := tlsPolicy(&Conf.App)
+	if err != nil {
+		return nil, err
+	}
+	s.neptulon.SetTLSPolicy(policy)
+	ca, err := readClientCA(Conf.App.TLSCACert, Conf.App.TLSCAKey)
+	if err != nil {
+		return nil, err
+	}
+	s.clientCA = ca
+	if Conf.App.TLSCert != "" {
+		if err := s.useTLS(Conf.App.TLSCert, Conf.App.TLSKey); err != nil {
+			return nil, err
+		}
+		if err := s.useSNICerts(Conf.App.TLSSNICertFiles()); err != nil {
+			return nil, err
+		}
+	}
+	if domains := Conf.App.ACMEDomainList(); len(domains) != 0 {
+		m, err := acme.NewManager(Conf.App.ACMEDirectory, Conf.App.ACMEEmail, Conf.App.ACMECacheDir, domains)
+		if err != nil {
+			return nil, err
+		}
+		m.Client.HTTPClient = Conf.App.HTTPClient()
+		s.UseACME(m, Conf.App.ACMEHTTPAddr)
+	}
+	s.hooks = newHooks(Conf.App.EventWebhookKey, Conf.App.HTTPClient())
+	for _, u := range Conf.App.EventWebhookURLs() {
+		if err := s.hooks.add(u, Conf.App.EventWebhookTypeList()...); err != nil {
+			return nil, err
+		}
+	}
+	s.events = &events{hooks: s.hooks}
+	s.audit = &audit{sink: inmem.NewAuditLog()}
+	s.abuse = newAbuse(Conf.App.AbuseThreshold, Conf.App.AbuseWindow, Conf.App.AbuseBan, s.audit)
+	s.audit.abuse = s.abuse
+	s.neptulon.AcceptFilter(s.abuse.allowed)
+	s.dedupe = newDedupe()
+	s.presence = newPresence(&s.queue, s.events)
+	if s.pusher, err = newPusher(&s.db, s.presence, Conf.GCM); err != nil {
+		return nil, err
+	}
+	s.filters = &filters{}
+	s.bots = newBots(&s.db, &s.queue, s.events, s.dedupe, s.pusher, s.filters)
+	if s.verifier, err = newVerifier(&s.db, Conf.Mail, Conf.SMS); err != nil {
+		return nil, err
+	}
+	s.twoFactor = newTwoFactor(&s.db)
+	s.limiter = newRateLimiter(Conf.App.RateLimitRequests, Conf.App.RateLimitMessages)
+	s.limiter.abuse = s.abuse
+	s.reaperDone = make(chan struct{})
 
 	if err := s.SetDB(inmem.NewDB()); err != nil {
 		return nil, err
@@ -34,27 +157,63 @@ func NewServer(addr string) (*Server, error) {
 	if err := s.SetQueue(inmem.NewQueue(s.neptulon.SendRequest)); err != nil {
 		return nil, err
 	}
+	s.queue.SetMaxDepth(Conf.App.QueueLimit)
+	s.SetBroadcastRate(Conf.App.BroadcastRate)
+	s.SetBlobStore(inmem.NewBlobStore())
 
-	s.neptulon.MiddlewareFunc(middleware.Logger)
+	// middleware chain is built once the server starts listening, so the custom middleware can be registered in between
 	s.pubRouter = middleware.NewRouter()
-	s.neptulon.Middleware(s.pubRouter)
-	initPubRoutes(s.pubRouter, &s.db, Conf.App.JWTPass())
-
-	//all communication below this point is authenticated
-	s.neptulon.MiddlewareFunc(jwt.HMAC(Conf.App.JWTPass()))
-	s.neptulon.Middleware(s.queue)
+	initPubRoutes(s.pubRouter, &s.db, s.jwtKeys, s.events, s.verifier, s.audit)
 	s.privRouter = middleware.NewRouter()
-	s.neptulon.Middleware(s.privRouter)
-	initPrivRoutes(s.privRouter, &s.queue)
-	// todo: r.Middleware(NotFoundHandler()) - 404-like handler, if any request reaches this point without being handled
+	initPrivRoutes(s.privRouter, &s.db, &s.queue, &s.blobs, s.presence, s.events, s.dedupe, newTyping(s.presence), s.pusher, s.bots, s.filters, s.verifier, s.audit)
+	initCertRoutes(s.privRouter, s.clientCA, s.audit)
+	initTOTPRoutes(s.privRouter, &s.db, s.jwtKeys, s.twoFactor, s.audit)
+	initAdminRoutes(s.privRouter, s.jwtKeys, &s.db, &s.queue, s.presence, s.neptulon, s.audit, s.hooks, s.abuse, s.Drain, s.Kick, s.Broadcast)
+
+	s.neptulon.HandleHTTP(attachmentPath, &attachmentHandler{db: &s.db, blobs: &s.blobs, jwtKeys: s.jwtKeys})
+	webhookKeys, err := Conf.App.WebhookKeyUsers()
+	if err != nil {
+		return nil, err
+	}
+	if len(webhookKeys) != 0 {
+		wh := &webhookHandler{server: &s, keys: webhookKeys}
+		s.neptulon.HandleHTTP(webhookPath, wh)
+		s.neptulon.HandleHTTP(webhookPath+"/", wh)
+	}
+	if Conf.App.LongPollHold > 0 {
+		s.longPoll = newLongPoll(s.neptulon, Conf.App.LongPollHold)
+		s.neptulon.HandleHTTP("/poll", s.longPoll)
+	}
 
 	s.neptulon.DisconnHandler(func(c *neptulon.Conn) {
 		// only handle this event for previously authenticated
-		if id, ok := c.Session.GetOk("userid"); ok {
-			s.queue.RemoveConn(id.(string))
+		id, ok := c.Session.GetOk("userid")
+		if ok {
+			s.queue.RemoveConn(id.(string), c.ID)
+		}
+		if err := c.DisconnErr(); err != nil {
+			f := log.Fields{"conn": c.ID, "op": err.Op}
+			if ok {
+				f["user"] = id
+			}
+			connLog.With(f).Warnf("connection closed with error: %v", err.Err)
+			if err.Op == "decode" {
+				s.abuse.report(c, offenseMalformed)
+			}
+		}
+		s.presence.Disconnected(c)
+		s.limiter.Disconnected(c)
+
+		// requests left undelivered on a connection dropped for not reading in time are notified with a push notification
+		if err := c.DisconnErr(); ok && err != nil && err.Op == "send" {
+			s.pusher.connDropped(id.(string), s.queue.Depth(id.(string)))
 		}
 	})
 
+	if Conf.App.Workers > 0 {
+		s.neptulon.SetWorkerPool(Conf.App.Workers, Conf.App.WorkerQueue)
+	}
+
 	return &s, nil
 }
 
@@ -68,19 +227,335 @@ func (s *Server) SetDB(db data.DB) error {
 	return nil
 }
 
+// SetMessageDB sets a separate database to keep the message history in (i.e. one suited for high write volumes), while the rest
+// of the data is kept in the database set with SetDB, which should be called beforehand.
+func (s *Server) SetMessageDB(db data.MessageDB) {
+	s.db = data.WithMessageDB(s.db, db)
+}
+
+// SetBlobStore sets the storage for the content of the attachments. If not supplied, attachments are only kept in memory.
+func (s *Server) SetBlobStore(store data.BlobStore) {
+	s.blobs = store
+}
+
+// SetJWTKeys sets the keys for signing and verifying JWT tokens. Current key is used for signing new tokens
+// while tokens signed with either the current or any of the previous keys are accepted.
+// If not supplied, keys are retrieved from the configuration.
+func (s *Server) SetJWTKeys(current string, previous ...string) {
+	s.jwtKeys.mutex.Lock()
+	defer s.jwtKeys.mutex.Unlock()
+	s.jwtKeys.keys = newJWTKeys(current, previous...).keys
+}
+
+// RotateJWTKey makes the given key the current key for signing new JWT tokens.
+// Tokens signed with the previous keys are still accepted so active sessions and issued tokens are not invalidated.
+func (s *Server) RotateJWTKey(key string) error {
+	if key == "" {
+		return errors.New("server: jwt key cannot be empty")
+	}
+
+	s.jwtKeys.Rotate(key)
+	return nil
+}
+
+// SetRateLimits sets the maximum number of requests per connection, and messages per user per minute.
+// Zero or negative values disable the respective limit. If not supplied, limits are retrieved from the configuration.
+func (s *Server) SetRateLimits(requests, messages int) {
+	s.limiter.SetRates(requests, messages)
+}
+
+// SetConnLimitPerIP sets the maximum number of simultaneous connections from the same remote IP address.
+// Zero means no limit. If not supplied, limit is retrieved from the configuration.
+func (s *Server) SetConnLimitPerIP(limit int) {
+	s.neptulon.ConnLimitPerIP(limit)
+}
+
+// SetAbuseLimits sets the number of offenses (failed logins, malformed frames, rate limit violations) of a remote IP within the given
+// window to ban the IP after, and the duration of the bans. Threshold less than or equal to zero disables the bans.
+// If not supplied, limits are retrieved from the configuration.
+func (s *Server) SetAbuseLimits(threshold int, window, ban time.Duration) {
+	s.abuse.setLimits(threshold, window, ban)
+}
+
+// SetListenerConfig sets the handshake, read, write, and idle timeouts, the heartbeat, and the write buffer of the client connections,
+// for both WebSocket and QUIC listeners.
+// Zero value of a timeout disables it, and zero write buffer makes the writes synchronous. If not supplied, they are retrieved from the configuration.
+func (s *Server) SetListenerConfig(config neptulon.ListenerConfig) {
+	s.neptulon.SetListenerConfig(config)
+}
+
+// SetTLSPolicy sets the minimum TLS version, the cipher suites, and the key exchange curves the clients can negotiate,
+// for both WebSocket and QUIC listeners. It should be called before listening. If not supplied, policy is retrieved from the configuration.
+func (s *Server) SetTLSPolicy(policy neptulon.TLSPolicy) {
+	s.neptulon.SetTLSPolicy(policy)
+}
+
+// listenerConfig retrieves the timeouts, the heartbeat, and the write buffer of the client connections from the configuration,
+// where negative values disable them.
+func listenerConfig(app *App) neptulon.ListenerConfig {
+	positive := func(d time.Duration) time.Duration {
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+	buffer := app.WriteBuffer
+	if buffer < 0 {
+		buffer = 0
+	}
+	return neptulon.ListenerConfig{
+		HandshakeTimeout: positive(app.HandshakeTimeout),
+		ReadTimeout:      positive(app.ReadTimeout),
+		WriteTimeout:     positive(app.WriteTimeout),
+		IdleTimeout:      positive(app.IdleTimeout),
+		PingInterval:     positive(app.HeartbeatInterval),
+		PongTimeout:      app.HeartbeatTimeout,
+		WriteBuffer:      buffer,
+	}
+}
+
+// SetWorkerPool sets the number of workers running the request handlers of all the clients, and the number of requests that can
+// wait for a free worker, beyond which the requests are rejected with an error response with code 503 as the server is overloaded.
+// Zero workers runs each request on its own goroutine. It should be called before listening. If not supplied, they are retrieved
+// from the configuration.
+func (s *Server) SetWorkerPool(workers, queue int) {
+	s.neptulon.SetWorkerPool(workers, queue)
+}
+
+// WorkerPoolStats returns the number of workers, the number of busy workers, the number of requests waiting for a free worker,
+// and the number of requests rejected as the server was overloaded, and false if each request runs on its own goroutine.
+func (s *Server) WorkerPoolStats() (stats neptulon.WorkerPoolStats, ok bool) {
+	return s.neptulon.WorkerPoolStats()
+}
+
+// SetQueueLimit sets the maximum number of requests waiting in a user's queue, beyond which new messages to the user are rejected.
+// Zero means no limit. If not supplied, limit is retrieved from the configuration.
+func (s *Server) SetQueueLimit(limit int) {
+	s.queue.SetMaxDepth(limit)
+}
+
 // SetQueue sets the queue implementation to be used by the server. If not supplied, in-memory queue implementation is used.
+// Requests dead-lettered by the queue are published as events if the queue implements data.DeadLetterNotifier.
 func (s *Server) SetQueue(queue data.Queue) error {
 	s.queue = queue
+	if n, ok := queue.(data.DeadLetterNotifier); ok {
+		n.OnDeadLetter(func(dl models.DeadLetter) {
+			s.events.publish(models.EventDeadLettered, dl.UserID, dl)
+		})
+	}
 	return nil
 }
 
+// SetQueueStore sets the persistent storage for the queued requests. If not supplied, queued requests are only kept in memory.
+// Client supplied message IDs are deduplicated across the server instances if the store implements data.DedupeStore.
+func (s *Server) SetQueueStore(store data.QueueStore) error {
+	if err := s.queue.SetStore(store); err != nil {
+		return err
+	}
+	if ds, ok := store.(data.DedupeStore); ok {
+		s.dedupe.setStore(ds)
+	}
+
+	s.queueStore = store
+	return nil
+}
+
+// SetCluster sets the transport for reaching the users connected to the other server instances.
+// If not supplied, requests are only delivered to the users connected to this instance, unless a shared queue store is used.
+func (s *Server) SetCluster(cluster data.Cluster) error {
+	if err := s.queue.SetCluster(cluster); err != nil {
+		return err
+	}
+
+	s.cluster = cluster
+	return nil
+}
+
+// SetEventBus sets the event bus to publish the server events (user connected/disconnected, message queued, delivery receipt) to.
+// If not supplied, events are not published.
+func (s *Server) SetEventBus(bus data.EventBus) {
+	s.events.bus = bus
+}
+
+// AddWebhook registers an outgoing webhook to deliver the server events of the given types to, or all events if none given,
+// in addition to the ones configured with EVENT_WEBHOOKS. Events are signed with the configured webhook secret, which is required.
+func (s *Server) AddWebhook(url string, eventTypes ...string) error {
+	return s.hooks.add(url, eventTypes...)
+}
+
+// RegisterBot registers a server side bot under the given user ID, which should be lowercase and not belong to a user.
+// Messages sent to the user ID are handled by the bot instead of being queued, and the replies of the bot are sent from the user ID.
+func (s *Server) RegisterBot(userID string, b Bot) error {
+	return s.bots.add(userID, b)
+}
+
+// AddMessageFilter registers a message filter to inspect the direct, group, and topic messages before they are queued for the recipients.
+// Filters run in the order they are registered.
+func (s *Server) AddMessageFilter(f MessageFilter) {
+	s.filters.add(f)
+}
+
+// SetMailSender sets the sender to send the verification codes of the accounts registered with an e-mail address with,
+// in place of the e-mail provider configured with MAIL_PROVIDER. Registration with an e-mail address is disabled if nil.
+func (s *Server) SetMailSender(sender mail.Sender) {
+	s.verifier.setSender(sender)
+}
+
+// SetSMSSender sets the SMS gateway to send the verification codes of the phone numbers with, in place of the gateway
+// configured with SMS_PROVIDER. Phone number verification is disabled if nil.
+func (s *Server) SetSMSSender(sender sms.Sender) {
+	s.verifier.setSMSSender(sender)
+}
+
+// SetAuditSink sets the destination of the audit log, which records the security relevant events (logins, token refreshes,
+// admin actions, account changes). Audit log can only be queried with admin.audit if the sink implements data.AuditQuerier.
+// If not supplied, most recent audit log entries are only kept in memory.
+func (s *Server) SetAuditSink(sink data.AuditSink) {
+	s.audit.sink = sink
+}
+
 // ListenAndServe starts the Titan server. This function blocks until server is closed.
+// If a health check port is configured, health check endpoints are also served at that port.
+// If an admin port is configured, runtime debug endpoints are also served at that port.
+// If ACME is enabled, server certificate is obtained before listening for connections.
+// Additional listeners given in the configuration are served along with the main listener.
+// Middleware and routes cannot be registered once the server starts listening.
 func (s *Server) ListenAndServe() error {
+	s.initMiddleware()
+	if Conf.App.HealthPort != "" {
+		if err := s.listenHealth(":" + Conf.App.HealthPort); err != nil {
+			return err
+		}
+	}
+	if Conf.App.AdminPort != "" {
+		if err := s.listenDebug(":" + Conf.App.AdminPort); err != nil {
+			return err
+		}
+	}
+
+	if s.tlsCertFile != "" {
+		s.watchTLSReload()
+	}
+	if s.acme != nil {
+		if err := s.listenACME(); err != nil {
+			return err
+		}
+	}
+	if Conf.App.QUICAddr != "" {
+		if err := s.listenQUIC(Conf.App.QUICAddr); err != nil {
+			return err
+		}
+	}
+	if urls, err := Conf.App.ListenerURLs(); err != nil {
+		return err
+	} else if err := s.listenExtra(urls); err != nil {
+		return err
+	}
+	if Conf.GCM.Enabled() {
+		if err := s.listenGCM(); err != nil {
+			return err
+		}
+	}
+
+	go s.runReaper(s.reaperDone)
+
+	atomic.StoreInt32(&s.listening, 1)
+	defer atomic.StoreInt32(&s.listening, 0)
 	return s.neptulon.ListenAndServe()
 }
 
+// listenQUIC starts serving the QUIC connections at the given UDP address, in a separate goroutine.
+// QUIC connections carry the same protocol as the WebSocket connections and are handled the same way.
+func (s *Server) listenQUIC(addr string) error {
+	tlsConf := s.neptulon.TLSConfig()
+	if tlsConf == nil {
+		return errors.New("server: quic requires tls to be enabled")
+	}
+
+	l, err := newQUICListener(addr, tlsConf)
+	if err != nil {
+		return fmt.Errorf("server: failed to create quic listener on network address %v: %v", addr, err)
+	}
+	s.quicListener = l
+
+	go func() {
+		if err := s.neptulon.Serve(l); err != nil && atomic.LoadInt32(&s.listening) == 1 {
+			quicLog.Errorf("listener stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// listenGCM connects to GCM CCS (or FCM) for sending push notifications and receiving upstream messages from the devices,
+// and starts applying the registration ID changes reported by GCM to the database periodically until the server is closed.
+func (s *Server) listenGCM() error {
+	tokens := newGCMTokens(s.db, s.presence, s.events)
+	upstream := &gcmUpstream{db: &s.db, q: &s.queue, ev: s.events, dd: s.dedupe, pu: s.pusher, bt: s.bots, fl: s.filters, keys: s.jwtKeys, limiter: s.limiter}
+	push, err := newPushSender(tokens, upstream)
+	if err != nil {
+		return fmt.Errorf("server: %v", err)
+	}
+	s.pusher.setSender(push)
+
+	s.gcmDone = make(chan struct{})
+	go tokens.run(s.gcmDone)
+	return nil
+}
+
 // Close the server and all of the active connections, discarding any read/writes that is going on currently.
 // This is not a problem as we always require an ACK but it will also mean that message deliveries will be at-least-once; to-and-from the server.
+// Server is closed only once, and the calls made while it is being closed (i.e. by a signal handler) wait for it to complete.
 func (s *Server) Close() error {
+	s.closeOnce.Do(func() { s.closeErr = s.close() })
+	return s.closeErr
+}
+
+func (s *Server) close() error {
+	atomic.StoreInt32(&s.listening, 0)
+	if s.healthListener != nil {
+		s.healthListener.Close()
+	}
+	if s.debugListener != nil {
+		s.debugListener.Close()
+	}
+	if s.tlsReload != nil {
+		signal.Stop(s.tlsReload)
+		close(s.tlsReload)
+		s.tlsReload = nil
+	}
+	if s.longPoll != nil {
+		s.longPoll.Close()
+	}
+	if s.quicListener != nil {
+		s.quicListener.Close()
+		s.quicListener = nil
+	}
+	for _, l := range s.extraListeners {
+		l.Close()
+	}
+	s.extraListeners = nil
+	if s.acmeListener != nil {
+		s.acmeListener.Close()
+		s.acmeListener = nil
+	}
+	if s.acmeDone != nil {
+		close(s.acmeDone)
+		s.acmeDone = nil
+	}
+	if push := s.pusher.setSender(nil); push != nil {
+		push.Close()
+	}
+	if s.gcmDone != nil {
+		close(s.gcmDone)
+		s.gcmDone = nil
+	}
+	s.hooks.close()
+	s.bots.close()
+	select {
+	case <-s.reaperDone:
+	default:
+		close(s.reaperDone)
+	}
+
 	return s.neptulon.Close()
 }
@@ -0,0 +1,109 @@
+package titan
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/titan-x/titan/cmap"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/trace"
+)
+
+// ErrUnauthorized is returned when a service call is not authenticated with the JWT token of an admin user.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// ErrMessageRejected is returned when a message is rejected, i.e. the recipient's queue is full or the client message ID is too long.
+var ErrMessageRejected = errors.New("message rejected")
+
+// AuthService authenticates a call of a backend service (i.e. billing, CRM bots) to the service API with the JWT token of an admin user,
+// and records the call to the given audit log entry, including the unauthorized ones. Action of the entry should be the called method.
+// Returns the ID of the admin user.
+func (s *Server) AuthService(token string, e models.AuditEntry) (userID string, err error) {
+	claims, userID, err := verifyJWT(s.jwtKeys, s.db, token)
+	e.UserID = userID
+	if err != nil || !hasRole(claimRole(claims), models.RoleAdmin) {
+		if e.Details == nil {
+			e.Details = make(map[string]string)
+		}
+		e.Details["reason"] = "unauthorized"
+		s.audit.record(nil, e)
+		return "", ErrUnauthorized
+	}
+
+	e.Success = true
+	s.audit.record(nil, e)
+	return userID, nil
+}
+
+// SendMessage sends a message on behalf of the given user (i.e. the bot user of a backend service) the same way as a msg.send request
+// of a connection authenticated as the user: message is saved to the history, queued for the recipient, and pushed if the recipient
+// is offline. Retries of a message with a client generated ID are not sent again. Returns the receipt of the message.
+func (s *Server) SendMessage(from string, m models.Message) (models.Receipt, error) {
+	rs, err := sendAsUser(&s.db, &s.queue, s.events, s.dedupe, s.pusher, s.bots, s.filters, from, "", "service.send", []models.Message{m})
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	return rs[0], nil
+}
+
+// DeliveryState retrieves the latest delivery state of a message, from the queue while the message is tracked there,
+// or from the message history.
+func (s *Server) DeliveryState(msgID string) (r models.Receipt, ok bool) {
+	return deliveryState(s.db, s.queue, msgID)
+}
+
+// Presence retrieves the current presence of the given users.
+func (s *Server) Presence(userIDs ...string) []models.Presence {
+	ps := make([]models.Presence, len(userIDs))
+	for i, id := range userIDs {
+		ps[i] = s.presence.Get(id)
+	}
+	return ps
+}
+
+// sendAsUser sends the messages the same way as a msg.send request of a connection authenticated as the given user, for the messages
+// which do not arrive over a client connection (i.e. GCM upstream messages). Request is traced with the given span name.
+// Rejected messages are reported with ErrMessageRejected. If any of the messages could not be sent, receipts of all the messages
+// are returned along with the error of the first failed one (see sendMsgs).
+func sendAsUser(db *data.DB, q *data.Queue, ev *events, dd *dedupe, pu *pusher, bt *bots, fl *filters, userID, reqID, spanName string, msgs []models.Message) ([]models.Receipt, error) {
+	conn, err := neptulon.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	conn.Session.Set("userid", userID)
+	ctx := &neptulon.ReqCtx{Conn: conn, Session: cmap.New(), ID: reqID, Method: "msg.send"}
+	s := trace.Start(trace.SpanContext{}, spanName)
+	s.SetAttr("user", userID)
+	ctx.Session.Set(spanKey, s)
+	defer s.End()
+
+	rs, err := sendMsgs(ctx, db, q, ev, dd, pu, bt, fl, Conf.App.MsgTTL, msgs)
+	if err != nil {
+		s.SetError(err)
+		return nil, err
+	}
+	if ctx.Err != nil {
+		s.SetError(errors.New(ctx.Err.Message))
+		return nil, fmt.Errorf("%w: %v", ErrMessageRejected, ctx.Err.Message)
+	}
+	if failed := failedReceipts(rs); len(failed) != 0 {
+		r := failed[0]
+		if r.Error == queueFullMsg {
+			err = fmt.Errorf("%w: %v", ErrMessageRejected, r.Error)
+		} else {
+			err = fmt.Errorf("failed to send message %v to %v: %v", r.ID, r.To, r.Error)
+		}
+		s.SetError(err)
+		return rs, err
+	}
+	return rs, nil
+}
+
+// TLSConfig returns the TLS configuration of the server, or nil if TLS is not enabled, so the listeners of the other APIs
+// (i.e. the gRPC service API) present the same certificates.
+func (s *Server) TLSConfig() *tls.Config {
+	return s.neptulon.TLSConfig()
+}
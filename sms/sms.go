@@ -0,0 +1,35 @@
+// Package sms sends text messages through pluggable SMS gateways, i.e. Twilio.
+package sms
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sender sends text messages, i.e. the verification codes of the phone numbers.
+type Sender interface {
+	Send(to, body string) error
+}
+
+// NormalizeNumber converts a phone number in international format to E.164 format (i.e. +46123456789) by removing the spaces,
+// dashes, dots, and parentheses used to group the digits. Numbers without the leading plus and the country code are rejected.
+func NormalizeNumber(number string) (string, error) {
+	n := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, number)
+
+	// E.164 numbers have up to 15 digits, and the shortest ones in use have 8 digits including the country code
+	if len(n) < 9 || len(n) > 16 || n[0] != '+' || n[1] == '0' {
+		return "", fmt.Errorf("sms: invalid phone number %q, expected international format i.e. +46123456789", number)
+	}
+	for _, r := range n[1:] {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("sms: invalid phone number %q, expected international format i.e. +46123456789", number)
+		}
+	}
+	return n, nil
+}
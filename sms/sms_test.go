@@ -0,0 +1,60 @@
+package sms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNormalizeNumber(t *testing.T) {
+	for in, out := range map[string]string{
+		"+46123456789":       "+46123456789",
+		"+46 12-345 67 89":   "+46123456789",
+		"+1 (555) 010.0000":  "+15550100000",
+		"+90 (532) 000 0000": "+905320000000",
+	} {
+		if n, err := NormalizeNumber(in); err != nil || n != out {
+			t.Fatalf("expected %q to be normalized to %q, got: %q, err: %v", in, out, n, err)
+		}
+	}
+
+	for _, in := range []string{"", "0123456789", "46123456789", "+0123456789", "+4612", "+46 123 abc 789", "+1234567890123456", "++46123456789"} {
+		if n, err := NormalizeNumber(in); err == nil {
+			t.Fatalf("expected %q to be rejected, got: %q", in, n)
+		}
+	}
+}
+
+func TestTwilio(t *testing.T) {
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "AC123" || pass != "token" || r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		form = r.PostForm
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sid":"SM1"}`))
+	}))
+	defer srv.Close()
+
+	s := NewTwilio("AC123", "token", "+15550100000")
+	s.Endpoint = srv.URL
+	if err := s.Send("+46123456789", "123456"); err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("To") != "+46123456789" || form.Get("From") != "+15550100000" || form.Get("Body") != "123456" {
+		t.Fatalf("unexpected request: %v", form)
+	}
+
+	if err := s.Send("46123456789", "123456"); err == nil {
+		t.Fatal("expected number not in E.164 format to be rejected")
+	}
+
+	s.AuthToken = "wrong"
+	if err := s.Send("+46123456789", "123456"); err == nil {
+		t.Fatal("expected failed response to be returned as an error")
+	}
+}
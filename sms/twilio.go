@@ -0,0 +1,63 @@
+package sms
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Twilio sends text messages through the Twilio Programmable Messaging API.
+// https://www.twilio.com/docs/sms/api/message-resource#create-a-message-resource
+type Twilio struct {
+	Endpoint   string       // Service URL. Defaults to https://api.twilio.com
+	AccountSID string       // Account SID to authenticate with, along with the auth token.
+	AuthToken  string       // Auth token of the account.
+	From       string       // Twilio phone number or messaging service SID to send the messages from.
+	Client     *http.Client // HTTP client to make the requests with.
+}
+
+// NewTwilio creates a new Twilio sender sending the messages from the given number with the given account.
+func NewTwilio(accountSID, authToken, from string) *Twilio {
+	return &Twilio{
+		Endpoint:   "https://api.twilio.com",
+		AccountSID: accountSID,
+		AuthToken:  authToken,
+		From:       from,
+		Client:     http.DefaultClient,
+	}
+}
+
+// Send sends a text message to the given number in E.164 format.
+func (t *Twilio) Send(to, body string) error {
+	if n, err := NormalizeNumber(to); err != nil || n != to {
+		return fmt.Errorf("sms: twilio: recipient number %q is not in E.164 format", to)
+	}
+
+	form := url.Values{"To": {to}, "Body": {body}}
+	if strings.HasPrefix(t.From, "MG") {
+		form.Set("MessagingServiceSid", t.From)
+	} else {
+		form.Set("From", t.From)
+	}
+
+	req, err := http.NewRequest("POST", t.Endpoint+"/2010-04-01/Accounts/"+url.PathEscape(t.AccountSID)+"/Messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("sms: twilio: failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+
+	res, err := t.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sms: twilio: failed to send message: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("sms: twilio: failed to send message: %v: %s", res.Status, b)
+	}
+	return nil
+}
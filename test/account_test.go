@@ -0,0 +1,96 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	titan.InitConf("test")
+	defer titan.InitConf("test")
+	titan.Conf.App.DeletionGrace = time.Millisecond
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	user1, laptopUser := data.SeedUser1, data.SeedUser1
+	ch1 := sh.GetClientHelper().AsUser(&user1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	laptop := sh.GetClientHelper().AsUser(&laptopUser).AsDevice("laptop").Connect().JWTAuthSync()
+	defer laptop.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	// user 1 leaves behind an attachment, messages, and contacts both ways
+	sum := sha256.Sum256([]byte("hello"))
+	a, url, _ := ch1.RequestAttachmentUploadSync(5, "text/plain", hex.EncodeToString(sum[:]))
+	if code, _ := attachmentRequest(t, "PUT", url, data.SeedUser1.JWTToken, "hello"); code != http.StatusNoContent {
+		t.Fatalf("expected upload to succeed, got: %v", code)
+	}
+	ch1.AddContactSync("2")
+	ch2.AddContactSync("1")
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "bye", Attachment: &models.AttachmentRef{ID: a.ID}}})
+	ch2.GetMessagesWait()
+	ch2.SendMessagesSync([]models.Message{models.Message{To: "1", Message: "see you"}})
+	ch1.GetMessagesWait()
+
+	// all the devices are disconnected and the tokens of the user are refused from then on
+	closed := make(chan bool, 2)
+	laptop.Client.DisconnHandler(func(c *client.Client) { closed <- true })
+	ch1.Client.DisconnHandler(func(c *client.Client) { closed <- true })
+	if deleteAt := ch1.DeleteAccountSync(); deleteAt.IsZero() || deleteAt.After(time.Now().Add(time.Second)) {
+		t.Fatalf("unexpected deletion time: %v", deleteAt)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-closed:
+		case <-time.After(time.Second * 3):
+			t.Fatal("server did not close the connections of the deleted account")
+		}
+	}
+
+	retry := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect()
+	defer retry.CloseWait()
+	gotRes := make(chan bool)
+	retry.Client.DisconnHandler(func(c *client.Client) { closed <- true })
+	retry.Client.JWTAuth(data.SeedUser1.JWTToken, func(ack string) error {
+		gotRes <- true
+		return nil
+	})
+	select {
+	case <-gotRes:
+		t.Fatal("authenticated as a user whose account is scheduled for deletion")
+	case <-closed:
+	case <-time.After(time.Second * 3):
+		t.Fatal("server did not close the connection of the deleted account")
+	}
+
+	// data of the account is deleted once the grace period is over, while the messages of the others are kept
+	if n, err := sh.server.ReapAccounts(); err != nil || n != 1 {
+		t.Fatalf("expected 1 account to be deleted, got: %v, err: %v", n, err)
+	}
+	if _, ok := sh.db.GetByID("1"); ok {
+		t.Fatal("expected user to be deleted")
+	}
+	if _, ok, err := sh.db.GetAttachment(a.ID); err != nil || ok {
+		t.Fatalf("expected attachment to be deleted, err: %v", err)
+	}
+	if ids, err := sh.db.GetContacts("2"); err != nil || len(ids) != 0 {
+		t.Fatalf("expected user to be removed from the contacts of the others, got: %v, err: %v", ids, err)
+	}
+	msgs, _ := ch2.MessageHistorySync(models.DirectConversation("1", "2"), "", 10)
+	if len(msgs) != 1 || msgs[0].Message != "see you" {
+		t.Fatalf("expected only the messages of the other user to be left, got: %+v", msgs)
+	}
+	if n, err := sh.server.ReapAccounts(); err != nil || n != 0 {
+		t.Fatalf("expected no more accounts to be deleted, got: %v, err: %v", n, err)
+	}
+}
@@ -0,0 +1,352 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+func signToken(t *testing.T, key string, claims map[string]interface{}) string {
+	jt := jwt.New(jwt.SigningMethodHS256)
+	for c, v := range claims {
+		jt.Claims[c] = v
+	}
+	ts, err := jt.SignedString([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func TestRotateJWTKey(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	admin := data.SeedUser1
+	admin.JWTToken = signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": admin.ID, "role": "admin"})
+	ch1 := sh.GetClientHelper().AsUser(&admin).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+
+	// key is not generated by the server as it would not be persisted
+	if res := sendRaw(t, ch1, "admin.jwt.rotate", map[string]string{"key": ""}); res.Success {
+		t.Fatal("expected rotation without a key to be rejected")
+	}
+	ch1.RotateJWTKeySync("new-key")
+
+	// active session should not be affected
+	ch1.EchoSync("still here")
+
+	// tokens signed with the previous key are still accepted
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	ch2.CloseWait()
+
+	// tokens signed with the new key are accepted
+	u := data.SeedUser2
+	u.JWTToken = signToken(t, "new-key", map[string]interface{}{"userid": u.ID})
+	ch3 := sh.GetClientHelper().AsUser(&u).Connect().JWTAuthSync()
+	ch3.CloseWait()
+}
+
+func TestAdminRoutes(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	admin := data.SeedUser1
+	admin.JWTToken = signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": admin.ID, "role": "admin"})
+	ch1 := sh.GetClientHelper().AsUser(&admin).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).AsDevice("phone").Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	// users without the admin role are rejected
+	gotRes := make(chan *neptulon.ResCtx)
+	if err := ch2.Client.SendRequest("admin.users", nil, func(ctx *neptulon.ResCtx) error {
+		gotRes <- ctx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ctx := <-gotRes:
+		if ctx.Success || ctx.ErrorCode != 666 {
+			t.Fatalf("expected unauthorized error, got: %+v", ctx)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get an admin.users response in time")
+	}
+
+	// list connections
+	conns := ch1.ListConnsSync()
+	if len(conns) != 2 {
+		t.Fatalf("expected 2 connections, got: %+v", conns)
+	}
+	var conn models.Conn
+	for _, c := range conns {
+		if c.UserID == data.SeedUser2.ID {
+			conn = c
+		}
+	}
+	if conn.ID == "" || conn.Device != "phone" {
+		t.Fatalf("expected user 2's connection with device name, got: %+v", conns)
+	}
+
+	// connection metrics
+	if s := ch1.StatsSync(); s.Conns != 2 || len(s.ConnShards) == 0 {
+		t.Fatalf("expected 2 connections in stats, got: %+v", s)
+	}
+
+	// broadcast a system notice to everyone
+	ch1.BroadcastSync("maintenance at midnight")
+	for _, ch := range []*ClientHelper{ch1, ch2} {
+		if n := ch.GetNoticeWait(); n.Message != "maintenance at midnight" || n.Time.IsZero() {
+			t.Fatalf("expected system notice, got: %+v", n)
+		}
+	}
+
+	// force disconnect user 2
+	closed := make(chan bool, 1)
+	ch2.Client.DisconnHandler(func(c *client.Client) {
+		closed <- true
+	})
+	ch1.DisconnectSync(conn.ID)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("server did not close the connection")
+	}
+
+	// messages to the disconnected user should wait in the queue
+	ch1.SendMessagesSync([]models.Message{models.Message{To: data.SeedUser2.ID, Message: "are you there?"}})
+	for i := 0; ch1.QueueDepthSync(data.SeedUser2.ID) != 1; i++ {
+		if i > 100 {
+			t.Fatal("expected a queued request for the disconnected user")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestBroadcastCohort(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+	sh.server.SetBroadcastRate(10)
+
+	admin := data.SeedUser1
+	admin.JWTToken = signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": admin.ID, "role": "admin"})
+	ch1 := sh.GetClientHelper().AsUser(&admin).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+
+	// all the registered users get the notice, including the offline ones
+	ch1.BroadcastToSync(nil, "maintenance at midnight", 0)
+	if n := ch1.GetNoticeWait(); n.Message != "maintenance at midnight" {
+		t.Fatalf("expected system notice, got: %+v", n)
+	}
+	waitDepth := func(depth int) {
+		for i := 0; ch1.QueueDepthSync(data.SeedUser2.ID) != depth; i++ {
+			if i > 100 {
+				t.Fatalf("expected %v queued notices for the offline user", depth)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+	waitDepth(1)
+
+	// only the given users get the notice
+	ch1.BroadcastToSync([]string{data.SeedUser2.ID}, "your account needs attention", time.Hour)
+	waitDepth(2)
+
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+	// notices are not ordered
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		got[ch2.GetNoticeWait().Message] = true
+	}
+	if !got["maintenance at midnight"] || !got["your account needs attention"] {
+		t.Fatalf("expected both system notices, got: %v", got)
+	}
+	select {
+	case n := <-ch1.notices:
+		t.Fatalf("expected no notice for the users outside the cohort, got: %+v", n)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestKick(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	admin := data.SeedUser1
+	admin.JWTToken = signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": admin.ID, "role": "admin"})
+	ch1 := sh.GetClientHelper().AsUser(&admin).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+
+	closed := make(chan bool, 2)
+	for _, device := range []string{"phone", "tablet"} {
+		ch := sh.GetClientHelper().AsUser(&data.SeedUser2).AsDevice(device).Connect().JWTAuthSync()
+		defer ch.CloseWait()
+		ch.Client.DisconnHandler(func(c *client.Client) {
+			closed <- true
+		})
+	}
+
+	// all the connections of the user are closed
+	if conns, purged := ch1.KickSync(data.SeedUser2.ID, false); conns != 2 || purged != 0 {
+		t.Fatalf("expected 2 closed connections and no purged requests, got: %v, %v", conns, purged)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("server did not close the connections of the kicked user")
+		}
+	}
+
+	// queued requests are purged into the dead letters
+	ch1.SendMessagesSync([]models.Message{models.Message{To: data.SeedUser2.ID, Message: "are you there?"}})
+	for i := 0; ch1.QueueDepthSync(data.SeedUser2.ID) != 1; i++ {
+		if i > 100 {
+			t.Fatal("expected a queued request for the kicked user")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if conns, purged := ch1.KickSync(data.SeedUser2.ID, true); conns != 0 || purged != 1 {
+		t.Fatalf("expected no closed connections and 1 purged request, got: %v, %v", conns, purged)
+	}
+	if d := ch1.QueueDepthSync(data.SeedUser2.ID); d != 0 {
+		t.Fatalf("expected empty queue after purge, got: %v", d)
+	}
+	if dls, _ := ch1.ListDeadLettersSync(data.SeedUser2.ID, "", 0); len(dls) != 1 || dls[0].Reason != models.DeadLetterPurged {
+		t.Fatalf("expected the purged request in dead letters, got: %+v", dls)
+	}
+
+	// admins cannot kick themselves
+	gotRes := make(chan *neptulon.ResCtx)
+	if err := ch1.Client.SendRequest("admin.kick", map[string]string{"userid": admin.ID}, func(ctx *neptulon.ResCtx) error {
+		gotRes <- ctx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ctx := <-gotRes:
+		if ctx.Success || ctx.ErrorCode != 666 {
+			t.Fatalf("expected error kicking self, got: %+v", ctx)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get an admin.kick response in time")
+	}
+}
+
+func TestRevokeDevice(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	hashes := map[string]string{}
+	for _, device := range []string{"phone", "tablet"} {
+		h := sha256.Sum256([]byte("refresh-token-" + device))
+		hashes[device] = hex.EncodeToString(h[:])
+		if err := sh.db.SaveRefreshToken(&models.RefreshToken{ID: hashes[device], UserID: data.SeedUser2.ID, Device: device, Created: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	admin := data.SeedUser1
+	admin.JWTToken = signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": admin.ID, "role": "admin"})
+	ch1 := sh.GetClientHelper().AsUser(&admin).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+
+	u := data.SeedUser2
+	ch2 := sh.GetClientHelper().AsUser(&u).AsDevice("phone").Connect().RefreshAuthSync("refresh-token-phone").JWTAuthSync()
+	defer ch2.CloseWait()
+	closed := make(chan bool, 1)
+	ch2.Client.DisconnHandler(func(c *client.Client) {
+		closed <- true
+	})
+
+	if revoked, conns := ch1.RevokeDeviceSync(u.ID, "phone"); revoked != 1 || conns != 1 {
+		t.Fatalf("expected 1 revoked token and 1 closed connection, got: %v, %v", revoked, conns)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("server did not close the connection of the revoked device")
+	}
+
+	for device, revoked := range map[string]bool{"phone": true, "tablet": false} {
+		if rt, ok := sh.db.GetRefreshToken(hashes[device]); !ok || rt.Revoked != revoked {
+			t.Fatalf("expected refresh token of %v to be revoked: %v, got: %+v", device, revoked, rt)
+		}
+	}
+
+	// revoking the rest of the devices
+	if revoked, conns := ch1.RevokeDeviceSync(u.ID, ""); revoked != 1 || conns != 0 {
+		t.Fatalf("expected 1 revoked token and no closed connections, got: %v, %v", revoked, conns)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+	hs := httptest.NewServer(sh.server.HealthHandler())
+	defer hs.Close()
+
+	admin := data.SeedUser1
+	admin.JWTToken = signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": admin.ID, "role": "admin"})
+	ch1 := sh.GetClientHelper().AsUser(&admin).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+	closed := make(chan bool, 1)
+	ch2.Client.DisconnHandler(func(c *client.Client) {
+		closed <- true
+	})
+
+	ch1.DrainSync(time.Millisecond*200, "restarting")
+	if n := ch2.GetNoticeWait(); n.Message != "restarting" {
+		t.Fatalf("expected drain notice, got: %+v", n)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 2):
+		t.Fatal("server did not close the connection while draining")
+	}
+
+	if status, res := getHealth(t, hs.URL+"/readyz"); status != http.StatusServiceUnavailable || res.Checks["drain"] != "draining" {
+		t.Fatalf("expected readiness check to fail while draining, got: %v: %+v", status, res)
+	}
+
+	// new clients are refused while admin connections stay open
+	ch3 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect()
+	defer ch3.CloseWait()
+	gotRes := make(chan *neptulon.ResCtx)
+	if err := ch3.Client.SendRequest("auth.jwt", map[string]string{"token": data.SeedUser2.JWTToken}, func(ctx *neptulon.ResCtx) error {
+		gotRes <- ctx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ctx := <-gotRes:
+		if ctx.Success {
+			t.Fatal("expected authentication to be refused while draining")
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get an auth.jwt response in time")
+	}
+
+	if s := ch1.StatsSync(); s.Conns != 2 {
+		t.Fatalf("expected admin and the refused connection to be open, got: %+v", s)
+	}
+}
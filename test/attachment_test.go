@@ -0,0 +1,307 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/data/inmem"
+	"github.com/titan-x/titan/models"
+)
+
+func attachmentRequest(t *testing.T, method, url, token, body string) (int, string) {
+	req, err := http.NewRequest(method, "http://127.0.0.1:"+titan.Conf.App.Port+url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(b)
+}
+
+func TestAttachments(t *testing.T) {
+	titan.InitConf("test")
+	defer titan.InitConf("test")
+	titan.Conf.App.AttachmentQuota = 10
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	// user 1 requests an upload slot and uploads the content, which is verified against the checksum
+	sum := sha256.Sum256([]byte("hello"))
+	checksum := hex.EncodeToString(sum[:])
+	a, url, headers := ch1.RequestAttachmentUploadSync(5, "text/plain", checksum)
+	if a.ID == "" || a.Size != 5 || a.Checksum != checksum || url == "" || headers != nil {
+		t.Fatalf("unexpected upload slot: %+v, %v, %v", a, url, headers)
+	}
+	if code, _ := attachmentRequest(t, "PUT", url, "", "hello"); code != http.StatusUnauthorized {
+		t.Fatalf("expected unauthenticated upload to be rejected, got: %v", code)
+	}
+	if code, _ := attachmentRequest(t, "PUT", url, data.SeedUser2.JWTToken, "hello"); code != http.StatusNotFound {
+		t.Fatalf("expected upload by another user to be rejected, got: %v", code)
+	}
+	if code, _ := attachmentRequest(t, "PUT", url, data.SeedUser1.JWTToken, "hellO"); code != http.StatusBadRequest {
+		t.Fatalf("expected upload with a checksum mismatch to be rejected, got: %v", code)
+	}
+	if code, _ := attachmentRequest(t, "PUT", url, data.SeedUser1.JWTToken, "hello"); code != http.StatusNoContent {
+		t.Fatalf("expected upload to succeed, got: %v", code)
+	}
+	if code, _ := attachmentRequest(t, "PUT", url, data.SeedUser1.JWTToken, "hello"); code != http.StatusConflict {
+		t.Fatalf("expected second upload to be rejected, got: %v", code)
+	}
+
+	// user 2 can only download the attachment once it is sent to them, and receives it along with the message
+	if code, _ := attachmentRequest(t, "GET", url, data.SeedUser2.JWTToken, ""); code != http.StatusNotFound {
+		t.Fatalf("expected download of an attachment which is not sent to the user to be rejected, got: %v", code)
+	}
+	ch1.SendMessagesSync([]models.Message{models.Message{To: data.SeedUser2.ID, Message: "look", Attachment: &models.AttachmentRef{ID: a.ID}}})
+	msgs := ch2.GetMessagesWait()
+	if len(msgs) != 1 || msgs[0].Attachment == nil || msgs[0].Attachment.ID != a.ID || msgs[0].Attachment.Size != 5 || msgs[0].Attachment.MimeType != "text/plain" || msgs[0].Attachment.Checksum != checksum {
+		t.Fatalf("unexpected message: %+v", msgs)
+	}
+	ra, rurl := ch2.GetAttachmentSync(a.ID)
+	if ra.ID != a.ID || rurl != url {
+		t.Fatalf("unexpected attachment: %+v, %v", ra, rurl)
+	}
+	if code, body := attachmentRequest(t, "GET", rurl, data.SeedUser2.JWTToken, ""); code != http.StatusOK || body != "hello" {
+		t.Fatalf("unexpected download: %v, %q", code, body)
+	}
+
+	// users cannot send the attachments of others, and uploads are limited by the storage quota until an attachment is deleted
+	for _, f := range []func(func()) error{
+		func(done func()) error {
+			return ch2.Client.SendMessages([]models.Message{models.Message{To: data.SeedUser1.ID, Attachment: &models.AttachmentRef{ID: a.ID}}}, func(ack string) error { done(); return nil })
+		},
+		func(done func()) error {
+			return ch1.Client.RequestAttachmentUpload(6, "text/plain", checksum, func(a *models.AttachmentRef, url string, headers map[string]string) error { done(); return nil })
+		},
+	} {
+		gotRes := make(chan bool, 1)
+		if err := f(func() { gotRes <- true }); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-gotRes:
+			t.Fatal("expected an error response")
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+
+	// error responses close the client connections
+	ch3 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch3.CloseWait()
+	ch3.DeleteAttachmentSync(a.ID)
+	if code, _ := attachmentRequest(t, "GET", url, data.SeedUser2.JWTToken, ""); code != http.StatusNotFound {
+		t.Fatalf("expected deleted attachment not to be found, got: %v", code)
+	}
+	if a, _, _ := ch3.RequestAttachmentUploadSync(6, "text/plain", checksum); a.Size != 6 {
+		t.Fatalf("unexpected upload slot: %+v", a)
+	}
+}
+
+// presigningBlobStore is an in-memory blob store which mimics an object storage service, where the content is uploaded
+// and downloaded directly with presigned URLs and uploads are verified against the X-Amz-Content-Sha256 header.
+type presigningBlobStore struct {
+	*inmem.BlobStore
+	server *httptest.Server
+}
+
+func newPresigningBlobStore() *presigningBlobStore {
+	s := &presigningBlobStore{BlobStore: inmem.NewBlobStore()}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/")
+		switch r.Method {
+		case "PUT":
+			b, _ := ioutil.ReadAll(r.Body)
+			sum := sha256.Sum256(b)
+			if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+				http.Error(w, "checksum mismatch", http.StatusBadRequest)
+				return
+			}
+			s.PutBlob(id, bytes.NewReader(b))
+		case "GET":
+			rc, err := s.GetBlob(id)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			defer rc.Close()
+			io.Copy(w, rc)
+		}
+	}))
+	return s
+}
+
+func (s *presigningBlobStore) PresignPut(id, checksum string, ttl time.Duration) (string, map[string]string, error) {
+	return s.server.URL + "/" + id, map[string]string{"X-Amz-Content-Sha256": checksum}, nil
+}
+
+func (s *presigningBlobStore) PresignGet(id string, ttl time.Duration) (string, error) {
+	return s.server.URL + "/" + id, nil
+}
+
+func (s *presigningBlobStore) StatBlob(id string) (int64, error) {
+	rc, err := s.GetBlob(id)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(ioutil.Discard, rc)
+}
+
+func TestPresignedAttachments(t *testing.T) {
+	sh := NewServerHelper(t)
+	bs := newPresigningBlobStore()
+	defer bs.server.Close()
+	sh.server.SetBlobStore(bs)
+	sh.ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	put := func(url string, headers map[string]string, body string) int {
+		req, err := http.NewRequest("PUT", url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// content is uploaded directly to the object storage with the presigned url and headers, and then the upload is completed
+	sum := sha256.Sum256([]byte("hello"))
+	checksum := hex.EncodeToString(sum[:])
+	a, url, headers := ch1.RequestAttachmentUploadSync(5, "text/plain", checksum)
+	if !strings.HasPrefix(url, bs.server.URL) || headers["X-Amz-Content-Sha256"] != checksum {
+		t.Fatalf("unexpected presigned upload: %v, %v", url, headers)
+	}
+	if code := put(url, headers, "hellO"); code != http.StatusBadRequest {
+		t.Fatalf("expected upload with a checksum mismatch to be rejected, got: %v", code)
+	}
+	if code := put(url, headers, "hello"); code != http.StatusOK {
+		t.Fatalf("expected upload to succeed, got: %v", code)
+	}
+	ch1.CompleteAttachmentUploadSync(a.ID)
+	ch1.CompleteAttachmentUploadSync(a.ID)
+
+	// recipients download the content directly from the object storage
+	ch1.SendMessagesSync([]models.Message{models.Message{To: data.SeedUser2.ID, Attachment: &models.AttachmentRef{ID: a.ID}}})
+	if msgs := ch2.GetMessagesWait(); len(msgs) != 1 || msgs[0].Attachment == nil || msgs[0].Attachment.ID != a.ID {
+		t.Fatalf("unexpected message: %+v", msgs)
+	}
+	if _, rurl := ch2.GetAttachmentSync(a.ID); !strings.HasPrefix(rurl, bs.server.URL) {
+		t.Fatalf("expected a presigned download url, got: %v", rurl)
+	}
+	if code, body := attachmentRequest(t, "GET", "/attachments/"+a.ID, data.SeedUser2.JWTToken, ""); code != http.StatusOK || body != "hello" {
+		t.Fatalf("expected download to be redirected to the object storage, got: %v, %q", code, body)
+	}
+
+	// uploads which do not match the size of the slot are dropped
+	a, url, headers = ch1.RequestAttachmentUploadSync(6, "text/plain", checksum)
+	if code := put(url, headers, "hello"); code != http.StatusOK {
+		t.Fatalf("expected upload to succeed, got: %v", code)
+	}
+	gotRes := make(chan bool, 1)
+	if err := ch1.Client.CompleteAttachmentUpload(a.ID, func(ack string) error { gotRes <- true; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-gotRes:
+		t.Fatal("expected an error response")
+	case <-time.After(time.Millisecond * 100):
+	}
+	if _, err := bs.GetBlob(a.ID); err != data.ErrBlobNotFound {
+		t.Fatalf("expected mismatching content to be deleted, got: %v", err)
+	}
+}
+
+func TestAttachmentThumbnails(t *testing.T) {
+	titan.InitConf("test")
+	defer titan.InitConf("test")
+	titan.Conf.App.ThumbnailSizes = "16"
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 64, 32))); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(img.Bytes())
+	a, url, _ := ch1.RequestAttachmentUploadSync(int64(img.Len()), "image/png", hex.EncodeToString(sum[:]))
+	if code, _ := attachmentRequest(t, "PUT", url, data.SeedUser1.JWTToken, img.String()); code != http.StatusNoContent {
+		t.Fatalf("expected upload to succeed, got: %v", code)
+	}
+
+	// thumbnails are generated in the background after the upload
+	deadline := time.Now().Add(time.Second * 3)
+	for len(a.Thumbnails) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("thumbnails are not generated in time")
+		}
+		time.Sleep(time.Millisecond * 10)
+		a, _ = ch1.GetAttachmentSync(a.ID)
+	}
+	if th := a.Thumbnails[0]; th.Size != 16 || th.Width != 16 || th.Height != 8 || th.MimeType != "image/png" {
+		t.Fatalf("unexpected thumbnail: %+v", th)
+	}
+
+	// thumbnails are delivered along with the messages, and downloaded by the recipients before the content
+	ch1.SendMessagesSync([]models.Message{models.Message{To: data.SeedUser2.ID, Attachment: &models.AttachmentRef{ID: a.ID}}})
+	msgs := ch2.GetMessagesWait()
+	if len(msgs) != 1 || msgs[0].Attachment == nil || len(msgs[0].Attachment.Thumbnails) != 1 || msgs[0].Attachment.Thumbnails[0].Size != 16 {
+		t.Fatalf("unexpected message: %+v", msgs)
+	}
+	code, body := attachmentRequest(t, "GET", url+"?thumbnail=16", data.SeedUser2.JWTToken, "")
+	if code != http.StatusOK {
+		t.Fatalf("expected thumbnail download to succeed, got: %v", code)
+	}
+	if th, err := png.Decode(strings.NewReader(body)); err != nil || th.Bounds().Dx() != 16 || th.Bounds().Dy() != 8 {
+		t.Fatalf("unexpected thumbnail: %v", err)
+	}
+	if code, _ := attachmentRequest(t, "GET", url+"?thumbnail=32", data.SeedUser2.JWTToken, ""); code != http.StatusNotFound {
+		t.Fatalf("expected unknown thumbnail not to be found, got: %v", code)
+	}
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+func TestAuditLog(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	admin := data.SeedUser1
+	admin.JWTToken = signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": admin.ID, "role": "admin"})
+	ch1 := sh.GetClientHelper().AsUser(&admin).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).AsDevice("phone").Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	// failed logins are recorded
+	u := data.SeedUser2
+	u.JWTToken = signToken(t, "wrong-key", map[string]interface{}{"userid": u.ID})
+	ch3 := sh.GetClientHelper().AsUser(&u).Connect()
+	defer ch3.CloseWait()
+	closed := make(chan bool, 1)
+	ch3.Client.DisconnHandler(func(c *client.Client) { closed <- true })
+	ch3.Client.JWTAuth(u.JWTToken, func(ack string) error { return nil })
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 3):
+		t.Fatal("server did not close the connection with an invalid token")
+	}
+
+	// admin requests are recorded along with their params, while the unauthorized ones are recorded as failures
+	gotRes := make(chan bool)
+	ch2.Client.SendRequest("admin.queue", map[string]string{"userid": "1"}, func(ctx *neptulon.ResCtx) error {
+		gotRes <- true
+		return nil
+	})
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get an admin.queue response in time")
+	}
+	ch1.QueueDepthSync("2")
+	ch1.RotateJWTKeySync("secret-key")
+
+	es, _ := ch1.QueryAuditSync("", models.AuditLogin, time.Time{}, "", 10)
+	if len(es) != 3 || es[0].Success || es[0].Details["method"] != "jwt" || es[0].Details["reason"] == "" || es[0].RemoteAddr == "" {
+		t.Fatalf("expected a failed login followed by 2 successful ones, got: %+v", es)
+	}
+	if es[1].UserID != "2" || es[1].Device != "phone" || !es[1].Success || es[2].UserID != "1" || !es[2].Success {
+		t.Fatalf("unexpected successful logins: %+v", es[1:])
+	}
+
+	es, _ = ch1.QueryAuditSync("", "admin.queue", time.Time{}, "", 10)
+	if len(es) != 2 || es[0].UserID != "1" || es[0].Target != "2" || !es[0].Success || es[0].Details["params"] == "" {
+		t.Fatalf("unexpected admin request entry: %+v", es)
+	}
+	if es[1].UserID != "2" || es[1].Target != "1" || es[1].Success || es[1].Details["reason"] != "unauthorized" {
+		t.Fatalf("unexpected unauthorized admin request entry: %+v", es[1])
+	}
+	es, _ = ch1.QueryAuditSync("", "admin.jwt.rotate", time.Time{}, "", 10)
+	if len(es) != 1 || es[0].Details["params"] != "" {
+		t.Fatalf("expected params of the key rotation to be left out, got: %+v", es)
+	}
+
+	// entries are filtered by the user who performed the action or the user it is performed on, and paged through
+	es, next := ch1.QueryAuditSync("2", "", time.Time{}, "", 2)
+	if len(es) != 2 || next == "" || es[0].Action != "admin.queue" || es[0].UserID != "1" || es[1].Action != "admin.queue" {
+		t.Fatalf("unexpected first page: %+v", es)
+	}
+	if es, next = ch1.QueryAuditSync("2", "", time.Time{}, next, 2); len(es) != 1 || next != "" || es[0].Action != models.AuditLogin || es[0].UserID != "2" {
+		t.Fatalf("unexpected last page: %+v, cursor: %v", es, next)
+	}
+	if es, _ = ch1.QueryAuditSync("", "", time.Now().Add(time.Hour), "", 10); len(es) != 0 {
+		t.Fatalf("expected no entries recorded in the future, got: %+v", es)
+	}
+}
@@ -1,6 +1,8 @@
 package test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"log"
 	"os"
 	"testing"
@@ -53,6 +55,47 @@ func TestInvalidToken(t *testing.T) {
 	// todo: no token, un-signed token, invalid token signature, expired token...
 }
 
+func TestRefreshToken(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	rt := "refresh-token-1"
+	h := sha256.Sum256([]byte(rt))
+	if err := sh.db.SaveRefreshToken(&models.RefreshToken{ID: hex.EncodeToString(h[:]), UserID: "1", Device: "phone", Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// exchange refresh token for an access token
+	u := data.SeedUser1
+	ch := sh.GetClientHelper().AsUser(&u).Connect().RefreshAuthSync(rt).JWTAuthSync()
+	ch.EchoSync("authenticated with access token")
+
+	// revoking the refresh token should revoke the access token too, without affecting the active session
+	ch.RevokeRefreshTokenSync(rt)
+	ch.EchoSync("still authenticated")
+	ch.CloseWait()
+
+	ch = sh.GetClientHelper().AsUser(&u).Connect()
+	defer ch.CloseWait()
+
+	gotRes, closed := make(chan bool), make(chan bool)
+	ch.Client.DisconnHandler(func(c *client.Client) {
+		closed <- true
+	})
+	ch.Client.JWTAuth(u.JWTToken, func(ack string) error {
+		gotRes <- true
+		return nil
+	})
+
+	select {
+	case <-gotRes:
+		t.Fatal("authenticated with revoked token")
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("server did not close connection after authentication attempt with revoked token")
+	}
+}
+
 type googleAuthRes struct {
 	Cert, Key []byte
 }
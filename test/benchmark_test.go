@@ -2,7 +2,14 @@ package test
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/titan-x/titan/cmap"
+	"github.com/titan-x/titan/neptulon"
 )
 
 func BenchmarkAuth(b *testing.B) {
@@ -30,3 +37,116 @@ func BenchmarkParallelThroughput(b *testing.B) {
 		fmt.Print("hello")
 	}
 }
+
+// connMap is the common interface of the connection map implementations to compare.
+type connMap interface {
+	Set(key string, val interface{})
+	GetOk(key string) (interface{}, bool)
+	Delete(key string)
+}
+
+type lockedMap struct {
+	m *cmap.CMap
+}
+
+func (l lockedMap) Set(key string, val interface{})      { l.m.Set(key, val) }
+func (l lockedMap) GetOk(key string) (interface{}, bool) { return l.m.GetOk(key) }
+func (l lockedMap) Delete(key string)                    { l.m.Delete(key) }
+
+func BenchmarkConnMap(b *testing.B) {
+	const conns = 100000
+
+	for _, bm := range []struct {
+		name string
+		m    connMap
+	}{
+		{"single-lock", lockedMap{cmap.New()}},
+		{"sharded", cmap.NewSharded(cmap.DefaultShards)},
+	} {
+		for i := 0; i < conns; i++ {
+			bm.m.Set(strconv.Itoa(i), i)
+		}
+
+		// mix of lookups (sending requests) with connects and disconnects, 8:1:1
+		b.Run(bm.name, func(b *testing.B) {
+			var seq int64
+			b.RunParallel(func(pb *testing.PB) {
+				n := atomic.AddInt64(&seq, 1) * 7919 // spread the goroutines over the keys
+				for pb.Next() {
+					n++
+					key := strconv.Itoa(int(n % conns))
+					switch n % 10 {
+					case 0:
+						bm.m.Delete(key)
+					case 1:
+						bm.m.Set(key, n)
+					default:
+						bm.m.GetOk(key)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkRoundTrip measures the cost of a request/response round trip through the listener read loop and the send path,
+// for plain text frames and DEFLATE compressed binary frames. Allocations per round trip are the main figure to watch for GC
+// pressure at high connection counts.
+func BenchmarkRoundTrip(b *testing.B) {
+	const addr = "127.0.0.1:3010"
+
+	s := neptulon.NewServer(addr)
+	s.MiddlewareFunc(func(ctx *neptulon.ReqCtx) error {
+		var m map[string]string
+		if err := ctx.Params(&m); err != nil {
+			return err
+		}
+		ctx.Res = m
+		return ctx.Next()
+	})
+	go s.ListenAndServe()
+	defer s.Close()
+
+	for _, bm := range []struct {
+		name        string
+		compression string
+		size        int
+	}{
+		{"text-small", "", 64},
+		{"text-large", "", 16 << 10},
+		{"deflate-large", neptulon.Deflate, 16 << 10},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			c, err := neptulon.NewConn()
+			if err != nil {
+				b.Fatal(err)
+			}
+			for i := 0; ; i++ {
+				if err = c.Connect("ws://" + addr); err == nil {
+					break
+				} else if i == 100 {
+					b.Fatal("failed to connect to the server:", err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			defer c.Close()
+			if err := c.SetCompression(bm.compression, 0); err != nil {
+				b.Fatal(err)
+			}
+
+			params := map[string]string{"message": strings.Repeat("a", bm.size)}
+			done := make(chan struct{})
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.SendRequest("echo", params, func(ctx *neptulon.ResCtx) error {
+					done <- struct{}{}
+					return nil
+				}); err != nil {
+					b.Fatal(err)
+				}
+				<-done
+			}
+		})
+	}
+}
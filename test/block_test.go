@@ -0,0 +1,56 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+func TestBlock(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	// messages from blocked users are dropped silently
+	ch2.BlockSync("1")
+	if ids := ch2.ListBlockedSync(); len(ids) != 1 || ids[0] != "1" {
+		t.Fatalf("unexpected blocked users: %v", ids)
+	}
+	ch1.SendMessagesSync([]models.Message{{To: "2", Message: "hello?"}})
+	select {
+	case m := <-ch2.inMsgsChan:
+		t.Fatalf("blocked user's message is delivered: %+v", m)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	ch2.UnblockSync("1")
+	ch1.SendMessagesSync([]models.Message{{To: "2", Message: "hello"}})
+	if m := ch2.GetMessagesWait(); len(m) != 1 || m[0].Message != "hello" {
+		t.Fatalf("unexpected messages after unblocking: %+v", m)
+	}
+}
+
+func TestMute(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+
+	conv := models.DirectConversation("1", "2")
+	ch.MuteSync(conv).MuteSync("group:g1")
+	if convs := ch.ListMutedSync(); len(convs) != 2 || convs[0] != conv || convs[1] != "group:g1" {
+		t.Fatalf("unexpected muted conversations: %v", convs)
+	}
+
+	ch.UnmuteSync("group:g1")
+	if convs := ch.ListMutedSync(); len(convs) != 1 || convs[0] != conv {
+		t.Fatalf("unexpected muted conversations after unmuting: %v", convs)
+	}
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"testing"
 
+	"github.com/titan-x/titan"
 	"github.com/titan-x/titan/data"
 	"github.com/titan-x/titan/models"
 )
@@ -26,3 +27,32 @@ func TestEchoBot(t *testing.T) {
 		t.Fatalf("expected message from: Ola!, got: %v", msg.Message)
 	}
 }
+
+func TestRegisteredBot(t *testing.T) {
+	sh := NewServerHelper(t)
+	defer sh.CloseWait()
+
+	bot := titan.BotFunc(func(m models.Message) ([]models.Message, error) {
+		return []models.Message{{Message: "you said: " + m.Message}}, nil
+	})
+	if err := sh.server.RegisterBot("support", bot); err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.server.RegisterBot(data.SeedUser2.ID, bot); err == nil {
+		t.Fatal("expected registering a bot under a user ID to fail")
+	}
+	sh.ListenAndServe()
+
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+
+	ch.SendMessagesSync([]models.Message{{To: "support", Message: "help"}})
+
+	msgs := ch.GetMessagesWait()
+	if msgs[0].From != "support" || msgs[0].Message != "you said: help" {
+		t.Fatalf("expected reply from bot, got: %+v", msgs[0])
+	}
+	if r := ch.GetReceiptWait(models.StateDelivered); r.To != "support" {
+		t.Fatalf("expected delivered receipt of message to bot, got: %+v", r)
+	}
+}
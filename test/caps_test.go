@@ -0,0 +1,187 @@
+package test
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"golang.org/x/net/websocket"
+)
+
+func TestCompression(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect()
+	defer ch1.CloseWait()
+	caps := ch1.ExchangeCapsSync(models.Capabilities{Compression: []string{"zstd", "deflate"}})
+	if len(caps.Compression) != 1 || caps.Compression[0] != "deflate" || caps.CompressionThreshold != titan.Conf.App.CompressThreshold {
+		t.Fatalf("expected deflate compression to be chosen, got: %+v", caps)
+	}
+	ch1.JWTAuthSync()
+
+	// unsupported algorithms are declined
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect()
+	defer ch2.CloseWait()
+	if caps := ch2.ExchangeCapsSync(models.Capabilities{Compression: []string{"zstd"}}); len(caps.Compression) != 0 {
+		t.Fatalf("expected compression to be declined, got: %+v", caps)
+	}
+	ch2.JWTAuthSync()
+
+	// large messages are compressed both ways while the small ones are not, which is transparent to the clients
+	large := strings.Repeat("hello ", 1000)
+	ch2.SendMessagesSync([]models.Message{models.Message{To: data.SeedUser1.ID, Message: large}, models.Message{To: data.SeedUser1.ID, Message: "hi"}})
+	got := make(map[string]bool)
+	for len(got) < 2 {
+		for _, m := range ch1.GetMessagesWait() {
+			got[m.Message] = true
+		}
+	}
+	if !got[large] || !got["hi"] {
+		t.Fatalf("expected both the large and the small message, got: %v messages", len(got))
+	}
+	ch1.SendMessagesSync([]models.Message{models.Message{To: data.SeedUser2.ID, Message: large}})
+	if msgs := ch2.GetMessagesWait(); len(msgs) != 1 || msgs[0].Message != large {
+		t.Fatalf("expected large message, got: %v messages", len(msgs))
+	}
+}
+
+func TestCompressedFrames(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ws, err := websocket.Dial("ws://127.0.0.1:"+titan.Conf.App.Port, "", "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(time.Second * 3))
+
+	large := strings.Repeat("hello ", 1000)
+	for _, req := range []map[string]interface{}{
+		{"id": "1", "method": "conn.caps", "params": models.Capabilities{Compression: []string{"deflate"}}},
+		{"id": "2", "method": "auth.jwt", "params": map[string]string{"token": data.SeedUser1.JWTToken}},
+		{"id": "3", "method": "echo", "params": map[string]string{"message": large}},
+	} {
+		if err := websocket.JSON.Send(ws, req); err != nil {
+			t.Fatal(err)
+		}
+
+		var frame []byte
+		if err := websocket.Message.Receive(ws, &frame); err != nil {
+			t.Fatal(err)
+		}
+		if req["method"] != "echo" {
+			continue
+		}
+
+		// echo response is larger than the threshold so it should arrive deflate compressed
+		if len(frame) >= len(large) {
+			t.Fatalf("expected compressed echo response, got %v bytes", len(frame))
+		}
+		b, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(frame)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res struct {
+			ID     string
+			Result map[string]string
+		}
+		if err := json.Unmarshal(b, &res); err != nil || res.ID != "3" || res.Result["message"] != large {
+			t.Fatalf("unexpected echo response of %v bytes: %v", len(b), err)
+		}
+	}
+}
+
+func TestMsgPack(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect()
+	defer ch1.CloseWait()
+	caps := ch1.ExchangeCapsSync(models.Capabilities{Codecs: []string{"cbor", "msgpack"}, Compression: []string{"deflate"}})
+	if len(caps.Codecs) != 1 || caps.Codecs[0] != "msgpack" || len(caps.Compression) != 0 {
+		t.Fatalf("expected msgpack to be chosen without compression, got: %+v", caps)
+	}
+	ch1.JWTAuthSync()
+
+	// msgpack and json clients talk to each other transparently
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+	ch2.SendMessagesSync([]models.Message{models.Message{To: data.SeedUser1.ID, Message: "hello"}})
+	if msgs := ch1.GetMessagesWait(); len(msgs) != 1 || msgs[0].From != data.SeedUser2.ID || msgs[0].Message != "hello" {
+		t.Fatalf("unexpected messages: %+v", msgs)
+	}
+	ch1.SendMessagesSync([]models.Message{models.Message{To: data.SeedUser2.ID, Message: "hi"}})
+	if msgs := ch2.GetMessagesWait(); len(msgs) != 1 || msgs[0].From != data.SeedUser1.ID || msgs[0].Message != "hi" {
+		t.Fatalf("unexpected messages: %+v", msgs)
+	}
+}
+
+func TestMsgPackFrames(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ws, err := websocket.Dial("ws://127.0.0.1:"+titan.Conf.App.Port, "", "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(time.Second * 3))
+
+	type res struct {
+		ID     string
+		Result interface{}
+	}
+
+	// conn.caps response is still json, and the rest is msgpack both ways
+	if err := websocket.JSON.Send(ws, map[string]interface{}{"id": "1", "method": "conn.caps", "params": models.Capabilities{Codecs: []string{"msgpack"}}}); err != nil {
+		t.Fatal(err)
+	}
+	var r res
+	if err := websocket.JSON.Receive(ws, &r); err != nil || r.ID != "1" {
+		t.Fatalf("unexpected conn.caps response: %+v: %v", r, err)
+	}
+
+	for _, req := range []map[string]interface{}{
+		{"id": "2", "method": "auth.jwt", "params": map[string]string{"token": data.SeedUser1.JWTToken}},
+		{"id": "3", "method": "echo", "params": map[string]interface{}{"message": "hello", "count": 3}},
+	} {
+		b, _ := json.Marshal(req)
+		if b, err = neptulon.MsgPack.FromJSON(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := websocket.Message.Send(ws, b); err != nil {
+			t.Fatal(err)
+		}
+
+		var frame []byte
+		if err := websocket.Message.Receive(ws, &frame); err != nil {
+			t.Fatal(err)
+		}
+		if frame[0]&0xf0 != 0x80 { // msgpack fixmap
+			t.Fatalf("expected msgpack response, got: %q", frame)
+		}
+		b, err := neptulon.MsgPack.ToJSON(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r res
+		if err := json.Unmarshal(b, &r); err != nil || r.ID != req["id"] {
+			t.Fatalf("unexpected response: %s: %v", b, err)
+		}
+		if req["method"] == "echo" {
+			if m, _ := r.Result.(map[string]interface{}); m["message"] != "hello" || m["count"] != float64(3) {
+				t.Fatalf("unexpected echo response: %s", b)
+			}
+		}
+	}
+}
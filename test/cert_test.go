@@ -0,0 +1,102 @@
+package test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+func writeCertFiles(t *testing.T, dir, name string, o titan.CertOptions) (cert []byte, certFile, keyFile string) {
+	cert, key, err := titan.GenCert(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	if err := ioutil.WriteFile(certFile, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, certFile, keyFile
+}
+
+func TestCertAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "titan-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	serverCert, certFile, keyFile := writeCertFiles(t, dir, "server", titan.CertOptions{Hosts: []string{"127.0.0.1"}})
+	_, caFile, caKeyFile := writeCertFiles(t, dir, "ca", titan.CertOptions{CommonName: "titan ca", IsCA: true, ECDSA: true})
+
+	titan.InitConf("test")
+	defer titan.InitConf("test")
+	titan.Conf.App.TLSCert, titan.Conf.App.TLSKey, titan.Conf.App.TLSCACert, titan.Conf.App.TLSCAKey = certFile, keyFile, caFile, caKeyFile
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(serverCert)
+
+	// enroll a certificate for the device, authenticating with jwt token
+	ch1 := sh.GetClientHelper().WithTLS(&tls.Config{RootCAs: roots}).AsUser(&data.SeedUser1).AsDevice("phone").Connect().JWTAuthSync()
+	dc := ch1.EnrollCertSync("")
+	ch1.CloseWait()
+
+	pair, err := tls.X509KeyPair([]byte(dc.Cert), []byte(dc.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+		t.Fatal(err)
+	}
+	if pair.Leaf.Subject.CommonName != data.SeedUser1.ID || pair.Leaf.Subject.OrganizationalUnit[0] != "phone" {
+		t.Fatalf("unexpected certificate subject: %v", pair.Leaf.Subject)
+	}
+
+	// authenticate with the certificate alone and receive messages
+	ch2 := sh.GetClientHelper().WithTLS(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{pair}}).Connect().CertAuthSync()
+	defer ch2.CloseWait()
+
+	ch3 := sh.GetClientHelper().WithTLS(&tls.Config{RootCAs: roots}).AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch3.CloseWait()
+	ch3.SendMessagesSync([]models.Message{models.Message{To: data.SeedUser1.ID, Message: "hello"}})
+
+	msgs := ch2.GetMessagesWait()
+	if len(msgs) != 1 || msgs[0].From != data.SeedUser2.ID || msgs[0].Message != "hello" {
+		t.Fatalf("unexpected messages: %+v", msgs)
+	}
+
+	// certificates not issued by the client ca are rejected during the handshake (even if client sends it regardless of the acceptable cas)
+	selfCert, selfKey, err := titan.GenCert(titan.CertOptions{CommonName: data.SeedUser1.ID, ClientAuth: true, ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	selfPair, err := tls.X509KeyPair(selfCert, selfKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ConnectTLS("wss://127.0.0.1:"+titan.Conf.App.Port, &tls.Config{
+		RootCAs:              roots,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return &selfPair, nil },
+	}); err == nil {
+		c.Close()
+		t.Fatal("expected self-signed client certificate to be rejected")
+	}
+}
@@ -1,14 +1,16 @@
 package test
 
 import (
+	"crypto/tls"
 	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/neptulon/neptulon/middleware"
 	"github.com/titan-x/titan/client"
 	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon/middleware"
 )
 
 // ClientHelper is a Titan Client wrapper for testing.
@@ -19,7 +21,14 @@ type ClientHelper struct {
 
 	testing    *testing.T
 	serverAddr string
+	tlsConfig  *tls.Config
 	inMsgsChan chan []models.Message
+	receipts   chan []models.Receipt
+	presence   chan []models.Presence
+	typing     chan *models.Typing
+	notices    chan *models.Notice
+	profiles   chan *models.Profile
+	exports    chan *models.Export
 }
 
 // NewClientHelper creates a new client helper object.
@@ -39,9 +48,21 @@ func NewClientHelper(t *testing.T, addr string) *ClientHelper {
 		testing:    t,
 		serverAddr: addr,
 		inMsgsChan: make(chan []models.Message, 5000),
+		receipts:   make(chan []models.Receipt, 5000),
+		presence:   make(chan []models.Presence, 5000),
+		typing:     make(chan *models.Typing, 5000),
+		notices:    make(chan *models.Notice, 5000),
+		profiles:   make(chan *models.Profile, 5000),
+		exports:    make(chan *models.Export, 5000),
 	}
 	c.MiddlewareFunc(middleware.LoggerWithPrefix("client"))
 	c.InMsgHandler(ch.inMsgHandler)
+	c.ReceiptHandler(ch.receiptHandler)
+	c.PresenceHandler(ch.presenceHandler)
+	c.TypingHandler(ch.typingHandler)
+	c.NoticeHandler(ch.noticeHandler)
+	c.ProfileHandler(ch.profileHandler)
+	c.ExportHandler(ch.exportHandler)
 	return ch
 }
 
@@ -49,7 +70,7 @@ func NewClientHelper(t *testing.T, addr string) *ClientHelper {
 func (ch *ClientHelper) Connect() *ClientHelper {
 	// retry connect in case we're operating on a very slow machine
 	for i := 0; i <= 5; i++ {
-		if err := ch.Client.Connect(ch.serverAddr); err != nil {
+		if err := ch.Client.ConnectTLS(ch.serverAddr, ch.tlsConfig); err != nil {
 			if operr, ok := err.(*net.OpError); ok && operr.Op == "dial" && operr.Err.Error() == "connection refused" {
 				time.Sleep(time.Millisecond * 50)
 				continue
@@ -75,6 +96,19 @@ func (ch *ClientHelper) AsUser(u *models.User) *ClientHelper {
 	return ch
 }
 
+// WithTLS makes the client connect over TLS with the given configuration, i.e. with a client certificate.
+func (ch *ClientHelper) WithTLS(config *tls.Config) *ClientHelper {
+	ch.tlsConfig = config
+	ch.serverAddr = strings.Replace(ch.serverAddr, "ws://", "wss://", 1)
+	return ch
+}
+
+// AsDevice sets the device name to authenticate the connection with.
+func (ch *ClientHelper) AsDevice(name string) *ClientHelper {
+	ch.Client.Device = name
+	return ch
+}
+
 // GoogleAuthSync is synchronous version of Client.GoogleAuth method.
 // Google OAuth token is exchanged for a JWT token. If any user was assigned with AsUser, the new JWT token is stored in the user's profile.
 func (ch *ClientHelper) GoogleAuthSync(oauthToken string) *ClientHelper {
@@ -99,6 +133,52 @@ func (ch *ClientHelper) GoogleAuthSync(oauthToken string) *ClientHelper {
 	return ch
 }
 
+// RefreshAuthSync is synchronous version of Client.RefreshAuth method.
+// Refresh token is exchanged for a JWT token. If any user was assigned with AsUser, the new JWT token is stored in the user's profile.
+func (ch *ClientHelper) RefreshAuthSync(refreshToken string) *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.RefreshAuth(refreshToken, func(jwtToken string, expires time.Time) error {
+		if jwtToken == "" || !expires.After(time.Now()) {
+			ch.testing.Fatalf("auth.refresh returned invalid token: %v, expiring at: %v", jwtToken, expires)
+		}
+		ch.User.JWTToken = jwtToken
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatalf("refresh authentication request failed: %v", err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an auth.refresh response in time")
+	}
+	return ch
+}
+
+// RevokeRefreshTokenSync is synchronous version of Client.RevokeRefreshToken method.
+func (ch *ClientHelper) RevokeRefreshTokenSync(refreshToken string) *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.RevokeRefreshToken(refreshToken, func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our auth.revoke request: %v", ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an auth.revoke response in time")
+	}
+	return ch
+}
+
 // JWTAuthSync does JWT authentication with the token belonging the the user assigned with AsUser method.
 // This method runs synchronously and blocks until authentication response is received (or connection is closed by server).
 func (ch *ClientHelper) JWTAuthSync() *ClientHelper {
@@ -122,6 +202,127 @@ func (ch *ClientHelper) JWTAuthSync() *ClientHelper {
 	return ch
 }
 
+// CertAuthSync is synchronous version of Client.CertAuth method.
+func (ch *ClientHelper) CertAuthSync() *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.CertAuth(func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our auth.cert request: %v", ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatalf("cert authentication request failed: %v", err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an auth.cert response in time")
+	}
+	return ch
+}
+
+// EnrollCertSync is synchronous version of Client.EnrollCert method.
+func (ch *ClientHelper) EnrollCertSync(device string) *models.DeviceCert {
+	gotRes := make(chan *models.DeviceCert)
+
+	if err := ch.Client.EnrollCert(device, func(cert *models.DeviceCert) error {
+		gotRes <- cert
+		return nil
+	}); err != nil {
+		ch.testing.Fatalf("cert enrollment request failed: %v", err)
+	}
+
+	select {
+	case cert := <-gotRes:
+		return cert
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a cert.enroll response in time")
+	}
+	return nil
+}
+
+// TOTPAuthSync is synchronous version of Client.TOTPAuth method. Returns the JWT token carrying the second factor.
+func (ch *ClientHelper) TOTPAuthSync(code string) string {
+	gotRes := make(chan string)
+
+	if err := ch.Client.TOTPAuth(code, func(jwtToken string, expires time.Time) error {
+		if jwtToken == "" || !expires.After(time.Now()) {
+			ch.testing.Fatalf("auth.totp returned invalid token: %v, expiring at: %v", jwtToken, expires)
+		}
+		gotRes <- jwtToken
+		return nil
+	}); err != nil {
+		ch.testing.Fatalf("totp authentication request failed: %v", err)
+	}
+
+	select {
+	case token := <-gotRes:
+		return token
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an auth.totp response in time")
+	}
+	return ""
+}
+
+// EnrollTOTPSync is synchronous version of Client.EnrollTOTP method.
+func (ch *ClientHelper) EnrollTOTPSync() *models.TOTPEnrollment {
+	gotRes := make(chan *models.TOTPEnrollment)
+
+	if err := ch.Client.EnrollTOTP(func(e *models.TOTPEnrollment) error {
+		gotRes <- e
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case e := <-gotRes:
+		return e
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a totp.enroll response in time")
+	}
+	return nil
+}
+
+// ConfirmTOTPSync is synchronous version of Client.ConfirmTOTP method.
+func (ch *ClientHelper) ConfirmTOTPSync(code string) *ClientHelper {
+	ch.ackSync("totp.confirm", func(handler func(ack string) error) error {
+		return ch.Client.ConfirmTOTP(code, handler)
+	})
+	return ch
+}
+
+// DisableTOTPSync is synchronous version of Client.DisableTOTP method.
+func (ch *ClientHelper) DisableTOTPSync(code string) *ClientHelper {
+	ch.ackSync("totp.disable", func(handler func(ack string) error) error {
+		return ch.Client.DisableTOTP(code, handler)
+	})
+	return ch
+}
+
+// ExchangeCapsSync is synchronous version of Client.ExchangeCaps method.
+func (ch *ClientHelper) ExchangeCapsSync(offer models.Capabilities) *models.Capabilities {
+	gotRes := make(chan *models.Capabilities)
+
+	if err := ch.Client.ExchangeCaps(offer, func(caps *models.Capabilities) error {
+		gotRes <- caps
+		return nil
+	}); err != nil {
+		ch.testing.Fatalf("capability exchange request failed: %v", err)
+	}
+
+	select {
+	case caps := <-gotRes:
+		return caps
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a conn.caps response in time")
+	}
+	return nil
+}
+
 // EchoSync is synchronous version of Client.Echo method.
 func (ch *ClientHelper) EchoSync(message string) *ClientHelper {
 	gotRes := make(chan bool)
@@ -166,6 +367,26 @@ func (ch *ClientHelper) SendMessagesSync(messages []models.Message) *ClientHelpe
 	return ch
 }
 
+// SendBatchSync sends the given message to all the given recipients with a single request and returns the receipts.
+func (ch *ClientHelper) SendBatchSync(to []string, message string) []models.Receipt {
+	gotRes := make(chan []models.Receipt)
+
+	if err := ch.Client.SendBatch(to, message, func(rs []models.Receipt) error {
+		gotRes <- rs
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case rs := <-gotRes:
+		return rs
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a msg.sendBatch response in time")
+	}
+	return nil
+}
+
 // GetMessagesWait waits for and returns incoming messages.
 // If no message arrives within the timeout, test fails.
 func (ch *ClientHelper) GetMessagesWait() []models.Message {
@@ -178,21 +399,1114 @@ func (ch *ClientHelper) GetMessagesWait() []models.Message {
 	return nil
 }
 
-// CloseWait closes a connection.
-// Waits till all the goroutines handling messages quit.
-func (ch *ClientHelper) CloseWait() {
-	if err := ch.Client.Close(); err != nil {
-		ch.testing.Fatal("Failed to close the client connection:", err)
+// ReadMessagesSync is synchronous version of Client.ReadMessages method.
+func (ch *ClientHelper) ReadMessagesSync(ids []string) *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.ReadMessages(ids, func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our msg.read request: %v", ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
 	}
 
-	if os.Getenv("TRAVIS") != "" || os.Getenv("CI") != "" {
-		time.Sleep(time.Millisecond * 50)
-	} else {
-		time.Sleep(time.Millisecond * 5)
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an msg.read response in time")
 	}
+	return ch
 }
 
-func (ch *ClientHelper) inMsgHandler(m []models.Message) error {
-	ch.inMsgsChan <- m
+// ReadConversationSync is synchronous version of Client.ReadConversation method.
+func (ch *ClientHelper) ReadConversationSync(conversation, id string) *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.ReadConversation(conversation, id, func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our msg.read request: %v", ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an msg.read response in time")
+	}
+	return ch
+}
+
+// MessageHistorySync is synchronous version of Client.MessageHistory method.
+func (ch *ClientHelper) MessageHistorySync(conversation, cursor string, limit int) (msgs []models.Message, next string) {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.MessageHistory(conversation, cursor, limit, func(m []models.Message, c string) error {
+		msgs, next = m, c
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an msg.history response in time")
+	}
+	return
+}
+
+// SearchMessagesSync is synchronous version of Client.SearchMessages method.
+func (ch *ClientHelper) SearchMessagesSync(query, conversation, cursor string, limit int) (msgs []models.Message, next string) {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.SearchMessages(query, conversation, cursor, limit, func(m []models.Message, c string) error {
+		msgs, next = m, c
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an msg.search response in time")
+	}
+	return
+}
+
+// GetReceiptWait waits for and returns the next message delivery receipt with the given state.
+// Receipts with other states are discarded. If no such receipt arrives within the timeout, test fails.
+func (ch *ClientHelper) GetReceiptWait(state string) models.Receipt {
+	timeout := time.After(time.Second * 3)
+	for {
+		select {
+		case rs := <-ch.receipts:
+			for _, r := range rs {
+				if r.State == state {
+					return r
+				}
+			}
+		case <-timeout:
+			ch.testing.Fatalf("GetReceiptWait timeout waiting for state: %v", state)
+			return models.Receipt{}
+		}
+	}
+}
+
+// SubscribePresenceSync is synchronous version of Client.SubscribePresence method.
+func (ch *ClientHelper) SubscribePresenceSync(userIDs []string) []models.Presence {
+	gotRes := make(chan []models.Presence)
+
+	if err := ch.Client.SubscribePresence(userIDs, func(p []models.Presence) error {
+		gotRes <- p
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case p := <-gotRes:
+		return p
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a presence.sub response in time")
+	}
+	return nil
+}
+
+// GetPresenceWait waits for and returns the next presence update.
+// If no update arrives within the timeout, test fails.
+func (ch *ClientHelper) GetPresenceWait() []models.Presence {
+	select {
+	case p := <-ch.presence:
+		return p
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("GetPresenceWait timeout")
+	}
+	return nil
+}
+
+// TypingSync is synchronous version of Client.Typing method.
+func (ch *ClientHelper) TypingSync(conversation string, typing bool) *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.Typing(conversation, typing, func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our msg.typing request: %v", ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an msg.typing response in time")
+	}
+	return ch
+}
+
+// GetTypingWait waits for and returns the next typing notification.
+// If no notification arrives within the timeout, test fails.
+func (ch *ClientHelper) GetTypingWait() *models.Typing {
+	select {
+	case t := <-ch.typing:
+		return t
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("GetTypingWait timeout")
+	}
+	return nil
+}
+
+// UploadKeysSync is synchronous version of Client.UploadKeys method.
+func (ch *ClientHelper) UploadKeysSync(b *models.KeyBundle) int {
+	gotRes := make(chan int)
+
+	if err := ch.Client.UploadKeys(b, func(preKeys int) error {
+		gotRes <- preKeys
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case n := <-gotRes:
+		return n
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a keys.upload response in time")
+	}
+	return 0
+}
+
+// CountPreKeysSync is synchronous version of Client.CountPreKeys method.
+func (ch *ClientHelper) CountPreKeysSync() int {
+	gotRes := make(chan int)
+
+	if err := ch.Client.CountPreKeys(func(preKeys int) error {
+		gotRes <- preKeys
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case n := <-gotRes:
+		return n
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a keys.count response in time")
+	}
+	return 0
+}
+
+// GetKeysSync is synchronous version of Client.GetKeys method.
+func (ch *ClientHelper) GetKeysSync(userID string) *models.KeyBundle {
+	gotRes := make(chan *models.KeyBundle)
+
+	if err := ch.Client.GetKeys(userID, func(b *models.KeyBundle) error {
+		gotRes <- b
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case b := <-gotRes:
+		return b
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a keys.get response in time")
+	}
+	return nil
+}
+
+// RequestAttachmentUploadSync is synchronous version of Client.RequestAttachmentUpload method.
+func (ch *ClientHelper) RequestAttachmentUploadSync(size int64, mimeType, checksum string) (*models.AttachmentRef, string, map[string]string) {
+	return ch.attachmentSync("attachment.upload", func(handler func(a *models.AttachmentRef, url string, headers map[string]string) error) error {
+		return ch.Client.RequestAttachmentUpload(size, mimeType, checksum, handler)
+	})
+}
+
+// GetAttachmentSync is synchronous version of Client.GetAttachment method.
+func (ch *ClientHelper) GetAttachmentSync(id string) (*models.AttachmentRef, string) {
+	a, url, _ := ch.attachmentSync("attachment.get", func(handler func(a *models.AttachmentRef, url string, headers map[string]string) error) error {
+		return ch.Client.GetAttachment(id, func(a *models.AttachmentRef, url string) error { return handler(a, url, nil) })
+	})
+	return a, url
+}
+
+func (ch *ClientHelper) attachmentSync(method string, send func(handler func(a *models.AttachmentRef, url string, headers map[string]string) error) error) (*models.AttachmentRef, string, map[string]string) {
+	type res struct {
+		a       *models.AttachmentRef
+		url     string
+		headers map[string]string
+	}
+	gotRes := make(chan res)
+
+	if err := send(func(a *models.AttachmentRef, url string, headers map[string]string) error {
+		gotRes <- res{a, url, headers}
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case r := <-gotRes:
+		return r.a, r.url, r.headers
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatalf("did not get a %v response in time", method)
+	}
+	return nil, "", nil
+}
+
+// CompleteAttachmentUploadSync is synchronous version of Client.CompleteAttachmentUpload method.
+func (ch *ClientHelper) CompleteAttachmentUploadSync(id string) {
+	ch.ackSync("attachment.complete", func(handler func(ack string) error) error {
+		return ch.Client.CompleteAttachmentUpload(id, handler)
+	})
+}
+
+// DeleteAttachmentSync is synchronous version of Client.DeleteAttachment method.
+func (ch *ClientHelper) DeleteAttachmentSync(id string) {
+	ch.ackSync("attachment.delete", func(handler func(ack string) error) error {
+		return ch.Client.DeleteAttachment(id, handler)
+	})
+}
+
+func (ch *ClientHelper) ackSync(method string, send func(handler func(ack string) error) error) {
+	gotRes := make(chan bool)
+
+	if err := send(func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our %v request: %v", method, ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatalf("did not get a %v response in time", method)
+	}
+}
+
+// AddContactSync is synchronous version of Client.AddContact method.
+func (ch *ClientHelper) AddContactSync(userID string) *ClientHelper {
+	ch.ackSync("contact.add", func(handler func(ack string) error) error {
+		return ch.Client.AddContact(userID, handler)
+	})
+	return ch
+}
+
+// RemoveContactSync is synchronous version of Client.RemoveContact method.
+func (ch *ClientHelper) RemoveContactSync(userID string) *ClientHelper {
+	ch.ackSync("contact.remove", func(handler func(ack string) error) error {
+		return ch.Client.RemoveContact(userID, handler)
+	})
+	return ch
+}
+
+// ListContactsSync is synchronous version of Client.ListContacts method.
+func (ch *ClientHelper) ListContactsSync(cursor string, limit int) (ids []string, next string) {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.ListContacts(cursor, limit, func(u []string, c string) error {
+		ids, next = u, c
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a contact.list response in time")
+	}
+
+	return
+}
+
+// DiscoverContactsSync is synchronous version of Client.DiscoverContacts method.
+func (ch *ClientHelper) DiscoverContactsSync(phones []string) map[string]string {
+	gotRes := make(chan map[string]string)
+
+	if err := ch.Client.DiscoverContacts(phones, func(userIDs map[string]string) error {
+		gotRes <- userIDs
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case ids := <-gotRes:
+		return ids
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a contact.discover response in time")
+	}
+	return nil
+}
+
+// RegisterPhoneSync is synchronous version of Client.RegisterPhone method.
+func (ch *ClientHelper) RegisterPhoneSync(phone string) *ClientHelper {
+	ch.ackSync("phone.register", func(handler func(ack string) error) error {
+		return ch.Client.RegisterPhone(phone, handler)
+	})
+	return ch
+}
+
+// VerifyPhoneSync is synchronous version of Client.VerifyPhone method.
+func (ch *ClientHelper) VerifyPhoneSync(phone, code string) *ClientHelper {
+	ch.ackSync("phone.verify", func(handler func(ack string) error) error {
+		return ch.Client.VerifyPhone(phone, code, handler)
+	})
+	return ch
+}
+
+// BlockSync is synchronous version of Client.Block method.
+func (ch *ClientHelper) BlockSync(userID string) *ClientHelper {
+	ch.ackSync("block.add", func(handler func(ack string) error) error {
+		return ch.Client.Block(userID, handler)
+	})
+	return ch
+}
+
+// UnblockSync is synchronous version of Client.Unblock method.
+func (ch *ClientHelper) UnblockSync(userID string) *ClientHelper {
+	ch.ackSync("block.remove", func(handler func(ack string) error) error {
+		return ch.Client.Unblock(userID, handler)
+	})
+	return ch
+}
+
+// ListBlockedSync is synchronous version of Client.ListBlocked method.
+func (ch *ClientHelper) ListBlockedSync() []string {
+	return ch.listSync("block.list", ch.Client.ListBlocked)
+}
+
+// MuteSync is synchronous version of Client.Mute method.
+func (ch *ClientHelper) MuteSync(conversation string) *ClientHelper {
+	ch.ackSync("mute.add", func(handler func(ack string) error) error {
+		return ch.Client.Mute(conversation, handler)
+	})
+	return ch
+}
+
+// UnmuteSync is synchronous version of Client.Unmute method.
+func (ch *ClientHelper) UnmuteSync(conversation string) *ClientHelper {
+	ch.ackSync("mute.remove", func(handler func(ack string) error) error {
+		return ch.Client.Unmute(conversation, handler)
+	})
+	return ch
+}
+
+// ListMutedSync is synchronous version of Client.ListMuted method.
+func (ch *ClientHelper) ListMutedSync() []string {
+	return ch.listSync("mute.list", ch.Client.ListMuted)
+}
+
+func (ch *ClientHelper) listSync(method string, send func(handler func(ids []string) error) error) []string {
+	gotRes := make(chan []string)
+
+	if err := send(func(ids []string) error {
+		gotRes <- ids
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case ids := <-gotRes:
+		return ids
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatalf("did not get a %v response in time", method)
+	}
+	return nil
+}
+
+// SetProfileSync is synchronous version of Client.SetProfile method.
+func (ch *ClientHelper) SetProfileSync(p *models.Profile) *models.Profile {
+	gotRes := make(chan *models.Profile)
+
+	if err := ch.Client.SetProfile(p, func(p *models.Profile) error {
+		gotRes <- p
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case p := <-gotRes:
+		return p
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a profile.set response in time")
+	}
+	return nil
+}
+
+// GetProfilesSync is synchronous version of Client.GetProfiles method.
+func (ch *ClientHelper) GetProfilesSync(userIDs []string) []models.Profile {
+	gotRes := make(chan []models.Profile)
+
+	if err := ch.Client.GetProfiles(userIDs, func(ps []models.Profile) error {
+		gotRes <- ps
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case ps := <-gotRes:
+		return ps
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a profile.get response in time")
+	}
+	return nil
+}
+
+// GetProfileWait waits for and returns the next profile update of a contact.
+func (ch *ClientHelper) GetProfileWait() *models.Profile {
+	select {
+	case p := <-ch.profiles:
+		return p
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("GetProfileWait timeout")
+	}
+	return nil
+}
+
+// ExportConversationSync is synchronous version of Client.ExportConversation method.
+func (ch *ClientHelper) ExportConversationSync(conversation, format string) *models.Export {
+	gotRes := make(chan *models.Export)
+
+	if err := ch.Client.ExportConversation(conversation, format, func(e *models.Export) error {
+		gotRes <- e
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case e := <-gotRes:
+		return e
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an msg.export response in time")
+	}
+	return nil
+}
+
+// GetExportWait waits for and returns the next notification of a conversation export getting ready.
+func (ch *ClientHelper) GetExportWait() *models.Export {
+	select {
+	case e := <-ch.exports:
+		return e
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("GetExportWait timeout")
+	}
+	return nil
+}
+
+// SubscribeTopicSync is synchronous version of Client.SubscribeTopic method.
+func (ch *ClientHelper) SubscribeTopicSync(name string) *ClientHelper {
+	ch.ackSync("topic.subscribe", func(handler func(ack string) error) error {
+		return ch.Client.SubscribeTopic(name, handler)
+	})
+	return ch
+}
+
+// UnsubscribeTopicSync is synchronous version of Client.UnsubscribeTopic method.
+func (ch *ClientHelper) UnsubscribeTopicSync(name string) *ClientHelper {
+	ch.ackSync("topic.unsubscribe", func(handler func(ack string) error) error {
+		return ch.Client.UnsubscribeTopic(name, handler)
+	})
+	return ch
+}
+
+// PublishTopicSync is synchronous version of Client.PublishTopic method.
+func (ch *ClientHelper) PublishTopicSync(name, message string) *ClientHelper {
+	ch.ackSync("topic.publish", func(handler func(ack string) error) error {
+		return ch.Client.PublishTopic(name, message, handler)
+	})
+	return ch
+}
+
+// ListTopicsSync is synchronous version of Client.ListTopics method.
+func (ch *ClientHelper) ListTopicsSync() []models.Topic {
+	gotRes := make(chan []models.Topic)
+
+	if err := ch.Client.ListTopics(func(ts []models.Topic) error {
+		gotRes <- ts
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case ts := <-gotRes:
+		return ts
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a topic.list response in time")
+	}
+	return nil
+}
+
+// CreateTopicSync is synchronous version of Client.CreateTopic method.
+func (ch *ClientHelper) CreateTopicSync(name, description string) *models.Topic {
+	gotRes := make(chan *models.Topic)
+
+	if err := ch.Client.CreateTopic(name, description, func(t *models.Topic) error {
+		gotRes <- t
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case t := <-gotRes:
+		return t
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.topic.create response in time")
+	}
+	return nil
+}
+
+// ListSessionsSync is synchronous version of Client.ListSessions method.
+func (ch *ClientHelper) ListSessionsSync() []models.Conn {
+	gotRes := make(chan []models.Conn)
+
+	if err := ch.Client.ListSessions(func(conns []models.Conn) error {
+		gotRes <- conns
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case conns := <-gotRes:
+		return conns
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a session.list response in time")
+	}
+	return nil
+}
+
+// RevokeSessionSync is synchronous version of Client.RevokeSession method.
+func (ch *ClientHelper) RevokeSessionSync(connID, device string) (revoked, closed int) {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.RevokeSession(connID, device, func(r, c int) error {
+		revoked, closed = r, c
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a session.revoke response in time")
+	}
+	return
+}
+
+// DeleteAccountSync is synchronous version of Client.DeleteAccount method.
+func (ch *ClientHelper) DeleteAccountSync() time.Time {
+	gotRes := make(chan time.Time)
+
+	if err := ch.Client.DeleteAccount(func(t time.Time) error {
+		gotRes <- t
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case t := <-gotRes:
+		return t
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an account.delete response in time")
+	}
+	return time.Time{}
+}
+
+// CreateGroupSync is synchronous version of Client.CreateGroup method.
+func (ch *ClientHelper) CreateGroupSync(name string, members []string) *models.Group {
+	return ch.groupSync("group.create", func(handler func(g *models.Group) error) error {
+		return ch.Client.CreateGroup(name, members, handler)
+	})
+}
+
+// AddGroupMembersSync is synchronous version of Client.AddGroupMembers method.
+func (ch *ClientHelper) AddGroupMembersSync(groupID string, members []string) *models.Group {
+	return ch.groupSync("group.add", func(handler func(g *models.Group) error) error {
+		return ch.Client.AddGroupMembers(groupID, members, handler)
+	})
+}
+
+// LeaveGroupSync is synchronous version of Client.LeaveGroup method.
+func (ch *ClientHelper) LeaveGroupSync(groupID string) *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.LeaveGroup(groupID, func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our group.leave request: %v", ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a group.leave response in time")
+	}
+	return ch
+}
+
+// ListGroupMembersSync is synchronous version of Client.ListGroupMembers method.
+func (ch *ClientHelper) ListGroupMembersSync(groupID, cursor string, limit int) (members []string, next string) {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.ListGroupMembers(groupID, cursor, limit, func(m []string, c string) error {
+		members, next = m, c
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a group.members response in time")
+	}
+	return
+}
+
+// SendGroupMessageSync is synchronous version of Client.SendGroupMessage method.
+func (ch *ClientHelper) SendGroupMessageSync(groupID string, message string) *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.SendGroupMessage(groupID, message, func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our group.send request: %v", ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get a group.send response in time")
+	}
+	return ch
+}
+
+// RotateJWTKeySync is synchronous version of Client.RotateJWTKey method.
+func (ch *ClientHelper) RotateJWTKeySync(key string) *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.RotateJWTKey(key, func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our admin.jwt.rotate request: %v", ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.jwt.rotate response in time")
+	}
+	return ch
+}
+
+// ListConnsSync is synchronous version of Client.ListConns method.
+func (ch *ClientHelper) ListConnsSync() []models.Conn {
+	gotRes := make(chan []models.Conn)
+
+	if err := ch.Client.ListConns(func(conns []models.Conn) error {
+		gotRes <- conns
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case conns := <-gotRes:
+		return conns
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.users response in time")
+	}
+	return nil
+}
+
+// QueueDepthSync is synchronous version of Client.QueueDepth method.
+func (ch *ClientHelper) QueueDepthSync(userID string) int {
+	gotRes := make(chan int)
+
+	if err := ch.Client.QueueDepth(userID, func(depth int) error {
+		gotRes <- depth
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case depth := <-gotRes:
+		return depth
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.queue response in time")
+	}
+	return 0
+}
+
+// DisconnectSync is synchronous version of Client.Disconnect method.
+func (ch *ClientHelper) DisconnectSync(connID string) *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.Disconnect(connID, func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our admin.disconnect request: %v", ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.disconnect response in time")
+	}
+	return ch
+}
+
+// BroadcastSync is synchronous version of Client.Broadcast method.
+func (ch *ClientHelper) BroadcastSync(message string) *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.Broadcast(message, func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our admin.broadcast request: %v", ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.broadcast response in time")
+	}
+	return ch
+}
+
+// BroadcastToSync is synchronous version of Client.BroadcastTo method.
+func (ch *ClientHelper) BroadcastToSync(userIDs []string, message string, ttl time.Duration) *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.BroadcastTo(userIDs, message, ttl, func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our admin.broadcast request: %v", ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.broadcast response in time")
+	}
+	return ch
+}
+
+// StatsSync is synchronous version of Client.Stats method.
+func (ch *ClientHelper) StatsSync() *models.Stats {
+	gotRes := make(chan *models.Stats)
+
+	if err := ch.Client.Stats(func(s *models.Stats) error {
+		gotRes <- s
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case s := <-gotRes:
+		return s
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.stats response in time")
+	}
+	return nil
+}
+
+// ListDeadLettersSync is synchronous version of Client.ListDeadLetters method.
+func (ch *ClientHelper) ListDeadLettersSync(userID, cursor string, limit int) (dls []models.DeadLetter, next string) {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.ListDeadLetters(userID, cursor, limit, func(d []models.DeadLetter, c string) error {
+		dls, next = d, c
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.deadletters response in time")
+	}
+	return
+}
+
+// ListWebhookDeliveriesSync is synchronous version of Client.ListWebhookDeliveries method.
+func (ch *ClientHelper) ListWebhookDeliveriesSync(url, cursor string, limit int) (ds []models.WebhookDelivery, next string) {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.ListWebhookDeliveries(url, cursor, limit, func(d []models.WebhookDelivery, c string) error {
+		ds, next = d, c
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.webhooks response in time")
+	}
+	return
+}
+
+// RedriveSync is synchronous version of Client.Redrive method.
+func (ch *ClientHelper) RedriveSync(id string) *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.Redrive(id, func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our admin.redrive request: %v", ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.redrive response in time")
+	}
+	return ch
+}
+
+// KickSync is synchronous version of Client.Kick method.
+func (ch *ClientHelper) KickSync(userID string, purge bool) (closed, purged int) {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.Kick(userID, purge, func(c, p int) error {
+		closed, purged = c, p
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.kick response in time")
+	}
+	return
+}
+
+// SetRoleSync is synchronous version of Client.SetRole method.
+func (ch *ClientHelper) SetRoleSync(userID, role string) (revoked, closed int) {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.SetRole(userID, role, func(r, c int) error {
+		revoked, closed = r, c
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.role response in time")
+	}
+	return
+}
+
+// RevokeDeviceSync is synchronous version of Client.RevokeDevice method.
+func (ch *ClientHelper) RevokeDeviceSync(userID, device string) (revoked, closed int) {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.RevokeDevice(userID, device, func(r, c int) error {
+		revoked, closed = r, c
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.revoke response in time")
+	}
+	return
+}
+
+// QueryAuditSync is synchronous version of Client.QueryAudit method.
+func (ch *ClientHelper) QueryAuditSync(userID, action string, since time.Time, cursor string, limit int) (es []models.AuditEntry, next string) {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.QueryAudit(userID, action, since, cursor, limit, func(e []models.AuditEntry, c string) error {
+		es, next = e, c
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.audit response in time")
+	}
+	return
+}
+
+// DrainSync is synchronous version of Client.Drain method.
+func (ch *ClientHelper) DrainSync(period time.Duration, message string) *ClientHelper {
+	gotRes := make(chan bool)
+
+	if err := ch.Client.Drain(period, message, func(ack string) error {
+		if ack != client.ACK {
+			ch.testing.Fatalf("server did not ACK our admin.drain request: %v", ack)
+		}
+		gotRes <- true
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case <-gotRes:
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("did not get an admin.drain response in time")
+	}
+	return ch
+}
+
+// GetNoticeWait waits for and returns the next system notice.
+// If no notice arrives within the timeout, test fails.
+func (ch *ClientHelper) GetNoticeWait() *models.Notice {
+	select {
+	case n := <-ch.notices:
+		return n
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatal("GetNoticeWait timeout")
+	}
+	return nil
+}
+
+func (ch *ClientHelper) groupSync(method string, send func(handler func(g *models.Group) error) error) *models.Group {
+	gotRes := make(chan *models.Group)
+
+	if err := send(func(g *models.Group) error {
+		gotRes <- g
+		return nil
+	}); err != nil {
+		ch.testing.Fatal(err)
+	}
+
+	select {
+	case g := <-gotRes:
+		return g
+	case <-time.After(time.Second * 3):
+		ch.testing.Fatalf("did not get a %v response in time", method)
+	}
+	return nil
+}
+
+// CloseWait closes a connection.
+// Waits till all the goroutines handling messages quit.
+func (ch *ClientHelper) CloseWait() {
+	if err := ch.Client.Close(); err != nil {
+		ch.testing.Fatal("Failed to close the client connection:", err)
+	}
+
+	if os.Getenv("TRAVIS") != "" || os.Getenv("CI") != "" {
+		time.Sleep(time.Millisecond * 50)
+	} else {
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func (ch *ClientHelper) inMsgHandler(m []models.Message) error {
+	ch.inMsgsChan <- m
+	return nil
+}
+
+func (ch *ClientHelper) presenceHandler(p []models.Presence) error {
+	ch.presence <- p
+	return nil
+}
+
+func (ch *ClientHelper) typingHandler(t *models.Typing) error {
+	ch.typing <- t
+	return nil
+}
+
+func (ch *ClientHelper) noticeHandler(n *models.Notice) error {
+	ch.notices <- n
+	return nil
+}
+
+func (ch *ClientHelper) profileHandler(p *models.Profile) error {
+	ch.profiles <- p
+	return nil
+}
+
+func (ch *ClientHelper) exportHandler(e *models.Export) error {
+	ch.exports <- e
+	return nil
+}
+
+func (ch *ClientHelper) receiptHandler(r []models.Receipt) error {
+	ch.receipts <- r
 	return nil
 }
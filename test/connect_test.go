@@ -1,10 +1,19 @@
 package test
 
 import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/client"
 	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+	"golang.org/x/net/websocket"
 )
 
 func TestClientDisconnect(t *testing.T) {
@@ -31,6 +40,94 @@ func TestServerDisconnect(t *testing.T) {
 	// todo: validate log output order
 }
 
+// TestBrowserClient verifies that a plain WebSocket client exchanging JSON-RPC messages as text frames, as a Web browser does,
+// can authenticate and make requests without the Titan client library.
+func TestBrowserClient(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ws, err := websocket.Dial("ws://127.0.0.1:"+titan.Conf.App.Port, "", "http://titan.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(time.Second * 3))
+
+	type res struct {
+		ID     string      `json:"id"`
+		Result interface{} `json:"result"`
+	}
+	for _, req := range []map[string]interface{}{
+		{"id": "1", "method": "auth.jwt", "params": map[string]string{"token": data.SeedUser1.JWTToken}},
+		{"id": "2", "method": "echo", "params": map[string]string{"message": "hello"}},
+	} {
+		if err := websocket.JSON.Send(ws, req); err != nil {
+			t.Fatal(err)
+		}
+
+		var r res
+		if err := websocket.JSON.Receive(ws, &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.ID != req["id"] || r.Result == nil {
+			t.Fatalf("unexpected response to %v: %+v", req["method"], r)
+		}
+	}
+}
+
+// TestUnknownResponse verifies that a response to an unknown request, i.e. a late acknowledgement of a request that is
+// already redelivered and acknowledged, is dropped without closing the connection.
+func TestUnknownResponse(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ws, err := websocket.Dial("ws://127.0.0.1:"+titan.Conf.App.Port, "", "http://titan.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(time.Second * 3))
+
+	if err := websocket.Message.Send(ws, `{"id": "1", "result": "ACK"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := websocket.JSON.Send(ws, map[string]interface{}{"id": "2", "method": "auth.jwt", "params": map[string]string{"token": data.SeedUser1.JWTToken}}); err != nil {
+		t.Fatal(err)
+	}
+	var res map[string]interface{}
+	if err := websocket.JSON.Receive(ws, &res); err != nil || res["id"] != "2" || res["result"] == nil {
+		t.Fatalf("expected connection to stay open after an unknown response, got: %v, %v", res, err)
+	}
+}
+
+// TestMalformedMessage verifies that a client sending malformed messages is disconnected, without affecting the other clients.
+func TestMalformedMessage(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+
+	for _, msg := range []string{`{"id": "1", "method": `, `{"foo": "bar"}`} {
+		ws, err := websocket.Dial("ws://127.0.0.1:"+titan.Conf.App.Port, "", "http://titan.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.SetDeadline(time.Now().Add(time.Second * 3))
+
+		if err := websocket.Message.Send(ws, msg); err != nil {
+			t.Fatal(err)
+		}
+		var res string
+		if err := websocket.Message.Receive(ws, &res); err == nil {
+			t.Fatalf("expected connection to be closed after message %v, got: %v", msg, res)
+		}
+		ws.Close()
+	}
+
+	ch.EchoSync("still connected")
+}
+
 func TestClientClose(t *testing.T) {
 	sh := NewServerHelper(t).ListenAndServe()
 	defer sh.CloseWait()
@@ -68,3 +165,276 @@ func TestConnTimeout(t *testing.T) {
 	// t.Fatal("Wait timeout did not occur")
 	// t.Fatal("Read timeout did not occur")
 }
+
+func TestConnLimitPerIP(t *testing.T) {
+	sh := NewServerHelper(t)
+	sh.server.SetConnLimitPerIP(1)
+	sh.ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+
+	// second connection from the same IP should be closed by the server
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2)
+	closed := make(chan bool, 1)
+	ch2.Client.DisconnHandler(func(c *client.Client) {
+		closed <- true
+	})
+	ch2.Connect()
+	defer ch2.CloseWait()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("server did not close the connection exceeding the per IP connection limit")
+	}
+
+	ch1.EchoSync("first connection is still alive")
+
+	// connection slot should be freed upon disconnect
+	ch1.CloseWait()
+	time.Sleep(time.Millisecond * 10)
+	ch3 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	ch3.CloseWait()
+}
+
+func TestListenerTimeouts(t *testing.T) {
+	sh := NewServerHelper(t)
+	sh.server.SetListenerConfig(neptulon.ListenerConfig{HandshakeTimeout: 100 * time.Millisecond, IdleTimeout: 200 * time.Millisecond})
+	sh.ListenAndServe()
+	defer sh.CloseWait()
+
+	// a connection that does not complete the handshake should be closed after the handshake timeout
+	conn, err := net.Dial("tcp", "127.0.0.1:"+titan.Conf.App.Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 3))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected connection to be closed during the handshake, got: %v", err)
+	}
+
+	// active connections should be kept alive, and an idle connection should be closed after the idle timeout
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+	closed := make(chan bool, 1)
+	ch.Client.DisconnHandler(func(c *client.Client) {
+		closed <- true
+	})
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		ch.EchoSync("keep alive")
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("server did not close the idle connection")
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	sh := NewServerHelper(t)
+	sh.server.SetListenerConfig(neptulon.ListenerConfig{IdleTimeout: 300 * time.Millisecond, PingInterval: 100 * time.Millisecond, PongTimeout: 100 * time.Millisecond})
+	sh.ListenAndServe()
+	defer sh.CloseWait()
+
+	// a client responding to pings should be kept connected past the idle timeout without sending any messages
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+	time.Sleep(time.Millisecond * 700)
+	ch.EchoSync("still connected")
+
+	// a client which is not reading from its connection does not respond to pings, as with a half-open connection
+	ws, err := websocket.Dial("ws://127.0.0.1:"+titan.Conf.App.Port, "", "http://titan.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	time.Sleep(time.Millisecond * 400)
+
+	ws.SetDeadline(time.Now().Add(time.Second * 3))
+	var res string
+	if err := websocket.Message.Receive(ws, &res); err == nil {
+		t.Fatalf("expected connection to be closed after pong timeout, got: %v", res)
+	}
+}
+
+func TestWriteBuffer(t *testing.T) {
+	ccs, done := useCCS(t)
+	defer done()
+
+	sh := NewServerHelper(t)
+	sh.server.SetListenerConfig(neptulon.ListenerConfig{WriteTimeout: 10 * time.Second, WriteBuffer: 4})
+	sh.server.SetRateLimits(-1, -1)
+	sh.ListenAndServe()
+	defer sh.CloseWait()
+
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+
+	ws, err := websocket.Dial("ws://127.0.0.1:"+titan.Conf.App.Port, "", "http://titan.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(time.Second * 10))
+	if err := websocket.JSON.Send(ws, map[string]interface{}{"id": "1", "method": "auth.jwt", "params": map[string]string{"token": data.SeedUser2.JWTToken}}); err != nil {
+		t.Fatal(err)
+	}
+	var res map[string]interface{}
+	if err := websocket.JSON.Receive(ws, &res); err != nil || res["result"] != client.ACK {
+		t.Fatalf("expected auth.jwt ACK, got: %v, err: %v", res, err)
+	}
+
+	// message is sent to the client but not acknowledged as the client stops reading
+	ch.SendMessagesSync([]models.Message{{To: "2", Message: "are you there?"}})
+
+	// client which keeps sending requests without reading the responses is disconnected once the write buffer is full,
+	// without blocking the server
+	payload := strings.Repeat("x", 64<<10)
+	for i := 0; err == nil; i++ {
+		if i > 1000 {
+			t.Fatal("expected the connection to be closed")
+		}
+		err = websocket.JSON.Send(ws, map[string]interface{}{"id": strconv.Itoa(i + 2), "method": "echo", "params": map[string]string{"message": payload}})
+	}
+	ch.EchoSync("server is still responsive")
+
+	// undelivered message is notified with a push notification now that the recipient is offline
+	select {
+	case m := <-ccs.Messages:
+		if m.To != data.SeedUser2.GCMRegID || m.Data["n.message_type"] != "sync" || m.Data["n.count"] != "1" {
+			t.Fatalf("unexpected push notification: %+v", m)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get a push notification in time")
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	sh := NewServerHelper(t)
+	defer sh.CloseWait()
+	sh.server.SetWorkerPool(1, 1)
+	started, release := make(chan bool, 2), make(chan bool)
+	if err := sh.server.PublicRoute("app.block", func(ctx *neptulon.ReqCtx) error {
+		started <- true
+		<-release
+		ctx.Res = "done"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sh.ListenAndServe()
+
+	ch := sh.GetClientHelper().Connect()
+	defer ch.CloseWait()
+	gotRes := make(chan *neptulon.ResCtx, 3)
+	send := func() {
+		if err := ch.Client.SendRequest("app.block", nil, func(ctx *neptulon.ResCtx) error {
+			gotRes <- ctx
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// first request occupies the only worker, second one waits in the queue, and the third one is shed right away
+	send()
+	<-started
+	send()
+	send()
+	select {
+	case ctx := <-gotRes:
+		if ctx.Success || ctx.ErrorCode != 503 {
+			t.Fatalf("expected overloaded error, got: %+v", ctx)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get an overloaded error in time")
+	}
+	if s, ok := sh.server.WorkerPoolStats(); !ok || s.Workers != 1 || s.Busy != 1 || s.Queue != 1 || s.Shed != 1 {
+		t.Fatalf("unexpected worker pool stats: %+v", s)
+	}
+
+	// queued request is handled once the worker is free
+	close(release)
+	for i := 0; i < 2; i++ {
+		select {
+		case ctx := <-gotRes:
+			if !ctx.Success {
+				t.Fatalf("expected request to succeed, got: %+v", ctx)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatal("did not get a response in time")
+		}
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	titan.InitConf("test")
+	defer titan.InitConf("test")
+	titan.Conf.App.ProxyProtocol = "127.0.0.1"
+
+	sh := NewServerHelper(t)
+	sh.server.SetConnLimitPerIP(1)
+	sh.ListenAndServe()
+	defer sh.CloseWait()
+
+	// dial sends the given proxy protocol header, completes the websocket handshake, and reports whether the server
+	// kept the connection open
+	dial := func(header []byte) (open bool, err error) {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+titan.Conf.App.Port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(time.Second * 3))
+		if _, err := conn.Write(header); err != nil {
+			return false, err
+		}
+		config, _ := websocket.NewConfig("ws://127.0.0.1:"+titan.Conf.App.Port, "http://titan.example.com")
+		ws, err := websocket.NewClient(config, conn)
+		if err != nil {
+			conn.Close()
+			return false, err
+		}
+		t.Cleanup(func() { ws.Close() })
+
+		ws.SetDeadline(time.Now().Add(time.Millisecond * 200))
+		var res string
+		err = websocket.Message.Receive(ws, &res)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return true, nil
+		}
+		return false, nil
+	}
+
+	v2 := func(ip net.IP, port uint16) []byte {
+		h := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x21, 0x11, 0, 12)
+		h = append(h, ip.To4()...)
+		h = append(h, 127, 0, 0, 1, byte(port>>8), byte(port), 0x01, 0xbb)
+		return h
+	}
+
+	// connections are limited per client address given in the header, instead of the address of the load balancer
+	for _, h := range [][]byte{
+		[]byte("PROXY TCP4 192.0.2.1 127.0.0.1 56324 443\r\n"),
+		v2(net.IPv4(192, 0, 2, 2), 56325),
+		[]byte("PROXY TCP6 2001:db8::1 ::1 56326 443\r\n"),
+	} {
+		if open, err := dial(h); err != nil || !open {
+			t.Fatalf("expected connection with header %q to be accepted, got: %v, %v", h, open, err)
+		}
+	}
+	if open, _ := dial(v2(net.IPv4(192, 0, 2, 1), 56327)); open {
+		t.Fatal("expected second connection of the same client address to exceed the limit")
+	}
+
+	// connections from the load balancer without a valid header are dropped
+	for _, h := range [][]byte{[]byte("GET / HTTP/1.1\r\n"), []byte("PROXY TCP4 garbage\r\n")} {
+		if open, err := dial(h); err == nil || open {
+			t.Fatalf("expected connection with header %q to be dropped", h)
+		}
+	}
+}
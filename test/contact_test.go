@@ -0,0 +1,29 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/titan-x/titan/data"
+)
+
+func TestContacts(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+
+	if ids, _ := ch1.ListContactsSync("", 0); len(ids) != 0 {
+		t.Fatalf("expected no contacts, got: %v", ids)
+	}
+
+	ch1.AddContactSync("2").AddContactSync("2")
+	if ids, _ := ch1.ListContactsSync("", 0); len(ids) != 1 || ids[0] != "2" {
+		t.Fatalf("unexpected contacts: %v", ids)
+	}
+
+	ch1.RemoveContactSync("2")
+	if ids, _ := ch1.ListContactsSync("", 0); len(ids) != 0 {
+		t.Fatalf("expected no contacts after removal, got: %v", ids)
+	}
+}
@@ -0,0 +1,121 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+func TestDebugEndpoints(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+	hs := httptest.NewServer(sh.server.DebugHandler())
+	defer hs.Close()
+
+	admin := signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": "1", "role": "admin"})
+	for _, token := range []string{"", data.SeedUser1.JWTToken, "invalid"} {
+		if code, _ := debugRequest(t, hs.URL+"/debug/gc", token); code != http.StatusUnauthorized {
+			t.Fatalf("expected debug endpoints to require an admin token, got: %v", code)
+		}
+	}
+
+	code, body := debugRequest(t, hs.URL+"/debug/goroutines", admin)
+	if code != http.StatusOK || !strings.Contains(body, "goroutine ") {
+		t.Fatalf("expected goroutine dump, got: %v: %v", code, body)
+	}
+
+	var gc struct {
+		NumGoroutine int   `json:"numGoroutine"`
+		HeapAlloc    int64 `json:"heapAlloc"`
+	}
+	code, body = debugRequest(t, hs.URL+"/debug/gc", admin)
+	if err := json.Unmarshal([]byte(body), &gc); err != nil || code != http.StatusOK || gc.NumGoroutine == 0 || gc.HeapAlloc == 0 {
+		t.Fatalf("expected GC stats, got: %v: %v", code, body)
+	}
+
+	if code, body = debugRequest(t, hs.URL+"/debug/pprof/heap?debug=1", admin); code != http.StatusOK || !strings.Contains(body, "heap profile") {
+		t.Fatalf("expected heap profile, got: %v: %v", code, body)
+	}
+
+	// both authorized and unauthorized requests are audited
+	u := data.SeedUser1
+	u.JWTToken = admin
+	ch := sh.GetClientHelper().AsUser(&u).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+	entries, _ := ch.QueryAuditSync("", models.AuditDebug, time.Time{}, "", 10)
+	if len(entries) != 6 {
+		t.Fatalf("expected 6 audit log entries, got: %+v", entries)
+	}
+	if e := entries[0]; !e.Success || e.UserID != "1" || e.Details["path"] != "/debug/pprof/heap" || e.Details["query"] != "debug=1" {
+		t.Fatalf("unexpected audit log entry: %+v", e)
+	}
+	if e := entries[4]; e.Success || e.UserID != "1" || e.Details["reason"] != "unauthorized" {
+		t.Fatalf("unexpected audit log entry: %+v", e)
+	}
+}
+
+func TestRouteMetrics(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+	hs := httptest.NewServer(sh.server.DebugHandler())
+	defer hs.Close()
+
+	admin := signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": "1", "role": "admin"})
+	type route struct {
+		Count   int64            `json:"count"`
+		Errors  int64            `json:"errors"`
+		Latency map[string]int64 `json:"latency"`
+	}
+	routes := func() map[string]route {
+		var vars struct {
+			Routes map[string]route `json:"routes"`
+		}
+		code, body := debugRequest(t, hs.URL+"/debug/vars", admin)
+		if err := json.Unmarshal([]byte(body), &vars); err != nil || code != http.StatusOK {
+			t.Fatalf("expected metrics, got: %v: %v", code, body)
+		}
+		return vars.Routes
+	}
+	before := routes()
+
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+	ch.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "measured"}})
+	if res := sendRaw(t, ch, "msg.send", []models.Message{models.Message{To: "2", Message: "measured", ClientID: strings.Repeat("x", 1000)}}); res.Success {
+		t.Fatal("expected message with a too long client ID to be rejected")
+	}
+
+	after := routes()
+	if r := after["msg.send"]; r.Count-before["msg.send"].Count != 2 || r.Errors-before["msg.send"].Errors != 1 || r.Latency["+Inf"] != r.Count {
+		t.Fatalf("unexpected msg.send metrics: before: %+v, after: %+v", before["msg.send"], r)
+	}
+}
+
+func debugRequest(t *testing.T, url, token string) (int, string) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(b)
+}
@@ -0,0 +1,7 @@
+// Package e2e contains the end-to-end integration tests, which run the server with TLS against real PostgreSQL and Redis
+// servers in Docker containers, and exercise it with scripted clients.
+//
+// Tests are only built with the docker build tag, and are skipped if the Docker daemon is not available:
+//
+//	go test -tags docker ./test/e2e/
+package e2e
@@ -0,0 +1,87 @@
+//go:build docker
+// +build docker
+
+package e2e
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Container is a Docker container started for the tests.
+type Container struct {
+	ID   string
+	Addr string // Host address the exposed port of the container is published at, i.e. 127.0.0.1:32768.
+}
+
+// DockerAvailable returns an error if the docker command or the Docker daemon is not available.
+func DockerAvailable() error {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return err
+	}
+	if out, err := exec.Command("docker", "info", "--format", "{{.ServerVersion}}").CombinedOutput(); err != nil {
+		return fmt.Errorf("docker daemon is not available: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// RunContainer starts a container from the given image in the background, publishing the given port (i.e. 5432/tcp)
+// at a random host port. Container is removed when it is closed.
+func RunContainer(image, port string, env ...string) (*Container, error) {
+	args := []string{"run", "-d", "--rm", "-p", "127.0.0.1::" + port}
+	for _, e := range env {
+		args = append(args, "-e", e)
+	}
+	out, err := exec.Command("docker", append(args, image)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("e2e: failed to start %v container: %v", image, cmdErr(err))
+	}
+	c := &Container{ID: strings.TrimSpace(string(out))}
+
+	out, err = exec.Command("docker", "port", c.ID, port).Output()
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("e2e: failed to get the published port of %v container: %v", image, cmdErr(err))
+	}
+	c.Addr = strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	return c, nil
+}
+
+// Logs returns the output of the container, to be logged when the tests fail.
+func (c *Container) Logs() string {
+	out, _ := exec.Command("docker", "logs", "--tail", "50", c.ID).CombinedOutput()
+	return string(out)
+}
+
+// Close stops and removes the container.
+func (c *Container) Close() error {
+	if out, err := exec.Command("docker", "rm", "-f", c.ID).CombinedOutput(); err != nil {
+		return fmt.Errorf("e2e: failed to remove container %v: %v: %s", c.ID, err, out)
+	}
+	return nil
+}
+
+// WaitFor calls the given function until it succeeds or the timeout expires, which is for waiting for the services
+// in the containers to start accepting connections.
+func WaitFor(timeout time.Duration, ready func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := ready()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("e2e: service did not become ready in %v: %v", timeout, err)
+		}
+		time.Sleep(time.Millisecond * 250)
+	}
+}
+
+func cmdErr(err error) error {
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(ee.Stderr)))
+	}
+	return err
+}
@@ -0,0 +1,220 @@
+//go:build docker
+// +build docker
+
+package e2e
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-xmpp"
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/ccs/ccstest"
+	"github.com/titan-x/titan/data/postgres"
+	"github.com/titan-x/titan/data/redis"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/test"
+)
+
+// env is the shared environment of the tests, which is set up once in TestMain.
+var env struct {
+	skip     error // Reason to skip the tests, if docker is not available.
+	pgDSN    string
+	redis    string
+	certFile string
+	keyFile  string
+	roots    *x509.CertPool
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	if env.skip = DockerAvailable(); env.skip != nil {
+		return m.Run()
+	}
+
+	pg, err := RunContainer("postgres:16-alpine", "5432/tcp", "POSTGRES_HOST_AUTH_METHOD=trust", "POSTGRES_DB=titan")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer pg.Close()
+	rd, err := RunContainer("redis:7-alpine", "6379/tcp")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer rd.Close()
+
+	env.pgDSN, env.redis = "postgres://postgres@"+pg.Addr+"/titan?sslmode=disable", rd.Addr
+	if err := WaitFor(time.Second*30, func() error {
+		db, err := postgres.NewDB(env.pgDSN)
+		if err != nil {
+			return err
+		}
+		defer db.DB.Close()
+		return db.DB.Ping()
+	}); err != nil {
+		fmt.Println(err, pg.Logs())
+		return 1
+	}
+	if err := WaitFor(time.Second*30, func() error {
+		s := redis.NewQueueStore(env.redis)
+		defer s.Close()
+		return s.Ping()
+	}); err != nil {
+		fmt.Println(err, rd.Logs())
+		return 1
+	}
+
+	dir, err := ioutil.TempDir("", "titan-e2e")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	cert, key, err := titan.GenCert(titan.CertOptions{Hosts: []string{"127.0.0.1"}})
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	env.certFile, env.keyFile = filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	if err := ioutil.WriteFile(env.certFile, cert, 0600); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := ioutil.WriteFile(env.keyFile, key, 0600); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	env.roots = x509.NewCertPool()
+	env.roots.AppendCertsFromPEM(cert)
+
+	return m.Run()
+}
+
+// node is a titan server instance running with TLS, on PostgreSQL and Redis of the environment, and pushing notifications
+// through the given fake GCM CCS server.
+type node struct {
+	t      *testing.T
+	server *titan.Server
+	closed chan error
+}
+
+func startNode(t *testing.T, ccs *ccstest.Server) *node {
+	if env.skip != nil {
+		t.Skipf("skipping end-to-end test: %v", env.skip)
+	}
+
+	titan.InitConf("test")
+	titan.Conf.App.TLSCert, titan.Conf.App.TLSKey = env.certFile, env.keyFile
+	titan.Conf.GCM.CCSHost, titan.Conf.GCM.SenderID = ccs.Addr, ccs.SenderID
+	os.Setenv("GOOGLE_API_KEY", ccs.APIKey)
+	xmpp.DefaultConfig.RootCAs = ccs.RootCAs
+
+	s, err := titan.NewServer("127.0.0.1:" + titan.Conf.App.Port)
+	if err != nil {
+		t.Fatal("failed to create server:", err)
+	}
+	db, err := postgres.NewDB(env.pgDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDB(db); err != nil {
+		t.Fatal("failed to set postgres database:", err)
+	}
+	if err := s.SetQueueStore(redis.NewQueueStore(env.redis)); err != nil {
+		t.Fatal("failed to set redis queue store:", err)
+	}
+
+	n := &node{t: t, server: s, closed: make(chan error, 1)}
+	go func() { n.closed <- s.ListenAndServe() }()
+	for i := 0; i < 100; i++ {
+		if c, err := net.Dial("tcp", "127.0.0.1:"+titan.Conf.App.Port); err == nil {
+			c.Close()
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return n
+}
+
+// client returns a client helper which connects to the node over TLS.
+func (n *node) client(u *models.User, device string) *test.ClientHelper {
+	return test.NewClientHelper(n.t, "ws://127.0.0.1:"+titan.Conf.App.Port).WithTLS(&tls.Config{RootCAs: env.roots}).AsUser(u).AsDevice(device)
+}
+
+func (n *node) closeWait() {
+	if err := n.server.Close(); err != nil {
+		n.t.Fatal("failed to stop the server:", err)
+	}
+	select {
+	case err := <-n.closed:
+		if err != nil {
+			n.t.Fatal("server failed:", err)
+		}
+	case <-time.After(time.Second * 3):
+		n.t.Fatal("server didn't close in time")
+	}
+}
+
+func TestEndToEnd(t *testing.T) {
+	ccs, err := ccstest.NewServer("1234", "api-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ccs.Close()
+
+	n := startNode(t, ccs)
+	if err := n.server.LoadFixtures("../fixtures.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	// users sign in over TLS with the refresh tokens of their devices, and then with the issued jwt tokens
+	alice, bob := models.User{ID: "alice"}, models.User{ID: "bob"}
+	ach := n.client(&alice, "phone").Connect().RefreshAuthSync("refresh-token-alice-phone").JWTAuthSync()
+	bch := n.client(&bob, "phone").Connect().RefreshAuthSync("refresh-token-bob-phone").JWTAuthSync()
+
+	// online users message each other directly
+	bch.SendMessagesSync([]models.Message{{To: "alice", Message: "online"}})
+	if msgs := ach.GetMessagesWait(); len(msgs) != 1 || msgs[0].From != "bob" || msgs[0].Message != "online" {
+		t.Fatalf("unexpected messages: %+v", msgs)
+	}
+
+	// messages to an offline user are queued in redis, and the user is woken up with a push notification
+	ach.CloseWait()
+	time.Sleep(time.Millisecond * 100) // let the server notice the disconnect
+	bch.SendMessagesSync([]models.Message{{To: "alice", Message: "offline"}})
+	select {
+	case m := <-ccs.Messages:
+		if m.To != "gcm-reg-id-alice" || m.Data["n.from"] != "bob" || m.Data["n.conversation"] != models.DirectConversation("alice", "bob") {
+			t.Fatalf("unexpected push notification: %+v", m)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get a push notification in time")
+	}
+	bch.CloseWait()
+
+	// queued messages and the history survive a server restart, and are delivered when the user reconnects
+	n.closeWait()
+	n = startNode(t, ccs)
+	defer n.closeWait()
+
+	ach = n.client(&alice, "phone").Connect().JWTAuthSync()
+	defer ach.CloseWait()
+	if msgs := ach.GetMessagesWait(); len(msgs) != 1 || msgs[0].From != "bob" || msgs[0].Message != "offline" {
+		t.Fatalf("unexpected queued messages: %+v", msgs)
+	}
+	if msgs, _ := ach.MessageHistorySync(models.DirectConversation("alice", "bob"), "", 10); len(msgs) != 5 || msgs[0].Message != "offline" || msgs[1].Message != "online" {
+		t.Fatalf("unexpected message history: %+v", msgs)
+	}
+}
@@ -0,0 +1,117 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/data/inmem"
+	"github.com/titan-x/titan/models"
+)
+
+func TestEvents(t *testing.T) {
+	events := make(chan *models.Event, 100)
+	bus := inmem.NewEventBus()
+	bus.Subscribe("", func(e *models.Event) { events <- e })
+
+	sh := NewServerHelper(t)
+	sh.server.SetEventBus(bus)
+	sh.ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "hi"}})
+	ch2.GetMessagesWait()
+	ch1.GetReceiptWait(models.StateDelivered)
+	ch1.CloseWait()
+
+	// wait for the events of the given types, in order
+	next := func(typ string) *models.Event {
+		for {
+			select {
+			case e := <-events:
+				if e.Type == typ {
+					return e
+				}
+			case <-time.After(time.Second * 3):
+				t.Fatalf("did not get %v event in time", typ)
+			}
+		}
+	}
+
+	if e := next(models.EventUserConnected); e.UserID != "1" {
+		t.Fatalf("expected user 1 to connect first, got: %+v", e)
+	}
+
+	e := next(models.EventMsgQueued)
+	var m models.Message
+	if err := json.Unmarshal(e.Data, &m); err != nil || e.UserID != "2" || m.From != "1" || m.Message != "hi" {
+		t.Fatalf("unexpected msg.queued event: %+v, %v", e, err)
+	}
+
+	e = next(models.EventMsgReceipt)
+	var r models.Receipt
+	if err := json.Unmarshal(e.Data, &r); err != nil || e.UserID != "1" || r.ID != m.ID || r.State != models.StateDelivered {
+		t.Fatalf("unexpected msg.receipt event: %+v, %v", e, err)
+	}
+
+	if e := next(models.EventUserDisconnected); e.UserID != "1" {
+		t.Fatalf("expected user 1 to disconnect, got: %+v", e)
+	}
+}
+
+func TestEventWebhooks(t *testing.T) {
+	received := make(chan *models.Event, 100)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !titan.VerifyWebhookSignature("secret", r.Header.Get("X-Titan-Signature"), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var e models.Event
+		json.Unmarshal(body, &e)
+		received <- &e
+	}))
+	defer hook.Close()
+
+	titan.InitConf("test")
+	defer titan.InitConf("test")
+	titan.Conf.App.EventWebhooks = hook.URL
+	titan.Conf.App.EventWebhookKey = "secret"
+	titan.Conf.App.EventWebhookTypes = models.EventUserConnected
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	admin := data.SeedUser1
+	admin.JWTToken = signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": admin.ID, "role": "admin"})
+	ch := sh.GetClientHelper().AsUser(&admin).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+
+	select {
+	case e := <-received:
+		if e.Type != models.EventUserConnected || e.UserID != admin.ID {
+			t.Fatalf("unexpected event: %+v", e)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get user.connected event in time")
+	}
+
+	// deliveries are recorded right after the webhook responds
+	var ds []models.WebhookDelivery
+	for i := 0; i < 100 && len(ds) == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+		ds, _ = ch.ListWebhookDeliveriesSync(hook.URL, "", 10)
+	}
+	if len(ds) != 1 || !ds[0].Success || ds[0].Event != models.EventUserConnected || ds[0].UserID != admin.ID {
+		t.Fatalf("unexpected webhook deliveries: %+v", ds)
+	}
+}
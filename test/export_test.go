@@ -0,0 +1,122 @@
+package test
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+func TestExportConversation(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	sum := sha256.Sum256([]byte("hello"))
+	a, url, _ := ch1.RequestAttachmentUploadSync(5, "text/plain", hex.EncodeToString(sum[:]))
+	if code, _ := attachmentRequest(t, "PUT", url, data.SeedUser1.JWTToken, "hello"); code != http.StatusNoContent {
+		t.Fatalf("expected upload to succeed, got: %v", code)
+	}
+
+	for _, m := range []models.Message{
+		models.Message{To: "2", Message: "message-1"},
+		models.Message{To: "2", Message: "message-2", Attachment: &models.AttachmentRef{ID: a.ID}},
+	} {
+		ch1.SendMessagesSync([]models.Message{m})
+		ch2.GetMessagesWait()
+		ch1.GetReceiptWait(models.StateDelivered)
+	}
+
+	// json export lists the messages oldest first, and is downloaded by the user who requested it
+	conv := models.DirectConversation("1", "2")
+	e := ch2.ExportConversationSync(conv, models.ExportJSON)
+	if e.ID == "" || e.Conversation != conv || e.Format != models.ExportJSON || e.URL != "" {
+		t.Fatalf("unexpected export: %+v", e)
+	}
+	if r := ch2.GetExportWait(); r.ID != e.ID || r.Error != "" || r.URL == "" || r.Size == 0 {
+		t.Fatalf("unexpected ready export: %+v", r)
+	} else {
+		e = r
+	}
+	if code, _ := attachmentRequest(t, "GET", e.URL, data.SeedUser1.JWTToken, ""); code != http.StatusNotFound {
+		t.Fatalf("expected download of another user's export to be rejected, got: %v", code)
+	}
+	code, body := attachmentRequest(t, "GET", e.URL, data.SeedUser2.JWTToken, "")
+	if code != http.StatusOK || int64(len(body)) != e.Size {
+		t.Fatalf("unexpected export download: %v, %v", code, body)
+	}
+	var doc struct {
+		Conversation string           `json:"conversation"`
+		Messages     []models.Message `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Conversation != conv || len(doc.Messages) != 2 || doc.Messages[0].Message != "message-1" || doc.Messages[1].Attachment == nil {
+		t.Fatalf("unexpected exported messages: %+v", doc)
+	}
+
+	// zip export carries the content of the attachments along with the messages
+	e = ch1.ExportConversationSync(conv, models.ExportZip)
+	if e = ch1.GetExportWait(); e.Error != "" || e.Format != models.ExportZip {
+		t.Fatalf("unexpected ready export: %+v", e)
+	}
+	code, body = attachmentRequest(t, "GET", e.URL, data.SeedUser1.JWTToken, "")
+	if code != http.StatusOK {
+		t.Fatalf("unexpected export download: %v, %v", code, body)
+	}
+	z, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(b)
+	}
+	if len(files) != 2 || !strings.Contains(files["messages.json"], "message-2") || files["attachments/"+a.ID] != "hello" {
+		t.Fatalf("unexpected archive content: %v", files)
+	}
+
+	// exports are kept as attachments which can be deleted
+	ch1.DeleteAttachmentSync(e.ID)
+	if code, _ := attachmentRequest(t, "GET", e.URL, data.SeedUser1.JWTToken, ""); code != http.StatusNotFound {
+		t.Fatalf("expected download of a deleted export to fail, got: %v", code)
+	}
+
+	// users cannot export the conversations they are not a part of
+	var gotErr bool
+	gotRes := make(chan bool)
+	ch1.Client.ExportConversation(models.DirectConversation("2", "3"), models.ExportJSON, func(e *models.Export) error {
+		gotRes <- true
+		return nil
+	})
+	select {
+	case <-gotRes:
+	case <-time.After(time.Millisecond * 100):
+		gotErr = true
+	}
+	if !gotErr {
+		t.Fatal("exported a conversation the user is not a part of")
+	}
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/neptulon"
+)
+
+func TestCustomMiddlewareAndRoutes(t *testing.T) {
+	sh := NewServerHelper(t)
+	defer sh.CloseWait()
+
+	var before, after int32
+	if err := sh.server.MiddlewareBeforeAuth(func(ctx *neptulon.ReqCtx) error {
+		atomic.AddInt32(&before, 1)
+		return ctx.Next()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.server.MiddlewareAfterAuth(func(ctx *neptulon.ReqCtx) error {
+		atomic.AddInt32(&after, 1)
+		return ctx.Next()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.server.PublicRoute("app.version", func(ctx *neptulon.ReqCtx) error {
+		ctx.Res = "1.0"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.server.PrivateRoute("app.whoami", func(ctx *neptulon.ReqCtx) error {
+		ctx.Res = ctx.Conn.Session.Get("userid")
+		return ctx.Next()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sh.ListenAndServe()
+
+	if err := sh.server.PublicRoute("app.late", func(ctx *neptulon.ReqCtx) error { return ctx.Next() }); err == nil {
+		t.Fatal("expected registering a route after listening to fail")
+	}
+
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect()
+	defer ch.CloseWait()
+
+	if v := sendRequestSync(t, ch, "app.version"); v != "1.0" {
+		t.Fatalf("expected version 1.0, got: %v", v)
+	}
+	if a := atomic.LoadInt32(&after); a != 0 {
+		t.Fatalf("expected public request to skip the middleware after auth, got %v calls", a)
+	}
+
+	ch.JWTAuthSync()
+	if id := sendRequestSync(t, ch, "app.whoami"); id != data.SeedUser1.ID {
+		t.Fatalf("expected user ID %v, got: %v", data.SeedUser1.ID, id)
+	}
+	if b, a := atomic.LoadInt32(&before), atomic.LoadInt32(&after); b != 3 || a != 2 {
+		t.Fatalf("expected 3 calls before and 2 calls after auth, got %v and %v", b, a)
+	}
+}
+
+func sendRequestSync(t *testing.T, ch *ClientHelper, method string) string {
+	res := make(chan string)
+	if err := ch.Client.SendRequest(method, nil, func(ctx *neptulon.ResCtx) error {
+		var s string
+		ctx.Result(&s)
+		res <- s
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-res:
+		return s
+	case <-time.After(time.Second * 3):
+		t.Fatalf("did not get a %v response in time", method)
+		return ""
+	}
+}
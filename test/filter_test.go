@@ -0,0 +1,59 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+func TestMessageFilter(t *testing.T) {
+	sh := NewServerHelper(t)
+	defer sh.CloseWait()
+
+	sh.server.AddMessageFilter(titan.MessageFilterFunc(func(m models.Message) (models.Message, string, error) {
+		if strings.Contains(m.Message, "spam") {
+			return m, "looks like spam", nil
+		}
+		m.Message = strings.Replace(m.Message, "darn", "****", -1)
+		return m, "", nil
+	}))
+	sh.ListenAndServe()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	// transformed messages are delivered with the filtered body
+	ch1.SendMessagesSync([]models.Message{{To: "2", Message: "darn it"}})
+	if m := ch2.GetMessagesWait(); len(m) != 1 || m[0].Message != "**** it" {
+		t.Fatalf("expected filtered message, got: %+v", m)
+	}
+
+	// rejected messages are not delivered and the sender gets the reason
+	gotRes := make(chan *neptulon.ResCtx)
+	if err := ch1.Client.SendRequest("msg.send", []models.Message{{To: "2", Message: "buy spam"}}, func(ctx *neptulon.ResCtx) error {
+		gotRes <- ctx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ctx := <-gotRes:
+		if ctx.Success || ctx.ErrorCode != 666 || !strings.Contains(ctx.ErrorMessage, "looks like spam") {
+			t.Fatalf("expected rejected message error, got: %+v", ctx)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get a msg.send response in time")
+	}
+	select {
+	case m := <-ch2.inMsgsChan:
+		t.Fatalf("rejected message is delivered: %+v", m)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
@@ -0,0 +1,48 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/titan-x/titan/models"
+)
+
+func TestFixtures(t *testing.T) {
+	sh := NewServerHelper(t)
+	if err := sh.server.LoadFixtures("fixtures.json"); err != nil {
+		t.Fatal(err)
+	}
+	sh.ListenAndServe()
+	defer sh.CloseWait()
+
+	// users sign in with the refresh tokens of their devices
+	alice := models.User{ID: "alice"}
+	ch := sh.GetClientHelper().AsUser(&alice).AsDevice("phone").Connect().RefreshAuthSync("refresh-token-alice-phone").JWTAuthSync()
+	defer ch.CloseWait()
+
+	if ids, _ := ch.ListContactsSync("", 0); len(ids) != 2 || ids[0] != "bob" || ids[1] != "carol" {
+		t.Fatalf("unexpected contacts: %v", ids)
+	}
+	ids, cur := ch.ListContactsSync("", 1)
+	if len(ids) != 1 || ids[0] != "bob" || cur == "" {
+		t.Fatalf("unexpected first page of contacts: %v, cursor: %v", ids, cur)
+	}
+	if ids, cur := ch.ListContactsSync(cur, 1); len(ids) != 1 || ids[0] != "carol" || cur != "" {
+		t.Fatalf("unexpected last page of contacts: %v, cursor: %v", ids, cur)
+	}
+
+	// sample conversations are in the history in the given order, newest first
+	msgs, _ := ch.MessageHistorySync(models.DirectConversation("alice", "bob"), "", 10)
+	if len(msgs) != 3 || msgs[0].Message != "Lunch at noon?" || msgs[1].From != "bob" || msgs[1].State != models.StateRead || msgs[2].State != models.StateDelivered {
+		t.Fatalf("unexpected message history: %+v", msgs)
+	}
+	if msgs, _ := ch.SearchMessagesSync("lunch", "", "", 10); len(msgs) != 2 {
+		t.Fatalf("expected direct and group messages in search results, got: %+v", msgs)
+	}
+
+	if _, ok := sh.db.GetGroup("lunch"); !ok {
+		t.Fatal("expected group to be loaded")
+	}
+	if u, ok := sh.db.GetByEmail("alice@titan.test"); !ok || u.GCMRegID != "gcm-reg-id-alice" || u.JWTToken == "" {
+		t.Fatalf("expected user with device token and jwt token, got: %+v", u)
+	}
+}
@@ -0,0 +1,200 @@
+package test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-xmpp"
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/ccs/ccstest"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+// useCCS starts a fake GCM CCS server and configures the titan servers to be created to connect to it.
+// Returned function stops the fake server and restores the configuration.
+func useCCS(t *testing.T) (*ccstest.Server, func()) {
+	if (titan.Conf == titan.Config{}) {
+		titan.InitConf("test")
+	}
+
+	s, err := ccstest.NewServer("1234", "api-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gcm, apiKey := titan.Conf.GCM, os.Getenv("GOOGLE_API_KEY")
+	titan.Conf.GCM.CCSHost, titan.Conf.GCM.SenderID = s.Addr, s.SenderID
+	os.Setenv("GOOGLE_API_KEY", s.APIKey)
+	xmpp.DefaultConfig.RootCAs = s.RootCAs
+
+	return s, func() {
+		s.Close()
+		titan.Conf.GCM = gcm
+		os.Setenv("GOOGLE_API_KEY", apiKey)
+		xmpp.DefaultConfig.RootCAs = nil
+	}
+}
+
+func TestGCMUpstream(t *testing.T) {
+	ccs, done := useCCS(t)
+	defer done()
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+
+	// message sent by user 1 as an upstream message through CCS is delivered to user 2
+	id, err := ccs.Upstream(data.SeedUser1.GCMRegID, map[string]string{
+		"n.message_type": "message",
+		"n.token":        data.SeedUser1.JWTToken,
+		"n.to":           "2",
+		"n.message":      "sent while dozing",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := ch.GetMessagesWait()
+	if len(msgs) != 1 || msgs[0].From != "1" || msgs[0].Message != "sent while dozing" {
+		t.Fatalf("unexpected messages: %+v", msgs)
+	}
+	for i := 0; !ccs.Acked(id); i++ {
+		if i == 100 {
+			t.Fatal("expected upstream message to be acknowledged")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// upstream messages with invalid tokens are acknowledged but not delivered
+	if _, err := ccs.Upstream(data.SeedUser1.GCMRegID, map[string]string{"n.message_type": "message", "n.token": "invalid", "n.to": "2", "n.message": "spoofed"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-ch.inMsgsChan:
+		t.Fatalf("expected unauthenticated upstream message to be dropped, got: %+v", m)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestPushOffline(t *testing.T) {
+	ccs, done := useCCS(t)
+	defer done()
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+
+	// offline recipient is notified of the message with a push notification, without the message content
+	conv := models.DirectConversation("1", "2")
+	ch.SendMessagesSync([]models.Message{{To: "2", Message: "wake up"}})
+	select {
+	case m := <-ccs.Messages:
+		if m.To != data.SeedUser2.GCMRegID || m.Data["n.conversation"] != conv || m.Data["n.from"] != "1" || m.Data["n.message"] != "" {
+			t.Fatalf("unexpected push notification: %+v", m)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get a push notification in time")
+	}
+
+	// messages of the muted conversations are not notified
+	if err := sh.db.Mute("2", conv); err != nil {
+		t.Fatal(err)
+	}
+	ch.SendMessagesSync([]models.Message{{To: "2", Message: "shh"}})
+	select {
+	case m := <-ccs.Messages:
+		t.Fatalf("expected no push notification for the muted conversation, got: %+v", m)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestPushCollapse(t *testing.T) {
+	ccs, done := useCCS(t)
+	defer done()
+	titan.Conf.GCM.CollapseWindow = time.Millisecond * 300
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+
+	// first message wakes the device right away, and the rest of the messages within the window are notified together
+	conv := models.DirectConversation("1", "2")
+	for i := 0; i < 4; i++ {
+		ch.SendMessagesSync([]models.Message{{To: "2", Message: "ping"}})
+	}
+	for _, typ := range []string{"message", "sync"} {
+		select {
+		case m := <-ccs.Messages:
+			if m.CollapseKey != conv || m.Data["n.message_type"] != typ || m.Data["n.conversation"] != conv {
+				t.Fatalf("unexpected push notification: %+v", m)
+			}
+			if typ == "sync" && m.Data["n.count"] != "3" {
+				t.Fatalf("expected sync notification for 3 messages, got: %+v", m)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("did not get %v push notification in time", typ)
+		}
+	}
+
+	// nothing is left to notify once the messages are notified
+	select {
+	case m := <-ccs.Messages:
+		t.Fatalf("expected no more push notifications, got: %+v", m)
+	case <-time.After(time.Millisecond * 500):
+	}
+}
+
+func TestPushOptions(t *testing.T) {
+	ccs, done := useCCS(t)
+	defer done()
+	titan.Conf.GCM.CollapseWindow = -1
+	titan.Conf.GCM.Body = "{{.Count}} from {{.From}}"
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+
+	// server defaults apply unless the sender gives the options
+	notify := true
+	ch.SendMessagesSync([]models.Message{{To: "2", Message: "default"}})
+	ch.SendMessagesSync([]models.Message{{To: "2", Message: "custom", Push: &models.PushOptions{Priority: "normal", Notification: &notify, Data: map[string]string{"screen": "chat"}}}})
+	for i, want := range []struct{ priority, body, screen string }{{"high", "", ""}, {"normal", "1 from 1", "chat"}} {
+		select {
+		case m := <-ccs.Messages:
+			if m.Priority != want.priority || m.Data["n.body"] != want.body || m.Data["screen"] != want.screen || m.Data["n.from"] != "1" {
+				t.Fatalf("unexpected push notification %v: %+v", i, m)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatal("did not get a push notification in time")
+		}
+	}
+
+	// reserved data keys cannot be overridden
+	gotRes := make(chan *neptulon.ResCtx)
+	msgs := []models.Message{{To: "2", Message: "spoofed", Push: &models.PushOptions{Data: map[string]string{"n.from": "3"}}}}
+	if err := ch.Client.SendRequest("msg.send", msgs, func(ctx *neptulon.ResCtx) error {
+		gotRes <- ctx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ctx := <-gotRes:
+		if ctx.Success {
+			t.Fatal("expected message with a reserved push data key to be rejected")
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get a msg.send response in time")
+	}
+}
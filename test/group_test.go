@@ -0,0 +1,74 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+)
+
+func TestGroupMessages(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	g := ch1.CreateGroupSync("friends", nil)
+	if g.ID == "" || g.Name != "friends" || g.Owner != "1" || len(g.Members) != 1 {
+		t.Fatalf("unexpected group: %+v", g)
+	}
+
+	g = ch1.AddGroupMembersSync(g.ID, []string{"2", "1"})
+	if len(g.Members) != 2 || !g.IsMember("2") {
+		t.Fatalf("expected user 2 to be added to the group: %+v", g)
+	}
+
+	ch2.SendGroupMessageSync(g.ID, "hi all")
+	m := ch1.GetMessagesWait()
+	if len(m) != 1 || m[0].From != "2" || m[0].Group != g.ID || m[0].Message != "hi all" || m[0].ID == "" {
+		t.Fatalf("unexpected group message: %+v", m)
+	}
+
+	// user 2 should not receive group messages after leaving the group
+	ch2.LeaveGroupSync(g.ID)
+	ch1.SendGroupMessageSync(g.ID, "anyone?")
+	select {
+	case m := <-ch2.inMsgsChan:
+		t.Fatalf("user who left the group received a message: %+v", m)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestListGroupMembers(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+
+	g := ch1.CreateGroupSync("crowd", []string{"2", "3", "4", "5"})
+
+	var members []string
+	cursor, pages := "", 0
+	for {
+		m, next := ch1.ListGroupMembersSync(g.ID, cursor, 2)
+		members = append(members, m...)
+		pages++
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if pages != 3 || len(members) != 5 {
+		t.Fatalf("expected 5 members in 3 pages, got: %v in %v pages", members, pages)
+	}
+	for i, m := range []string{"1", "2", "3", "4", "5"} {
+		if members[i] != m {
+			t.Fatalf("expected members: 1, 2, 3, 4, 5, got: %v", members)
+		}
+	}
+}
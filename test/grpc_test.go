@@ -0,0 +1,101 @@
+package test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/grpcapi"
+	"github.com/titan-x/titan/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPC(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := grpcapi.NewServer(sh.server)
+	go gs.Serve(l)
+	defer gs.Stop()
+
+	cc, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	c := grpcapi.NewTitanClient(cc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	// calls without a token or with the token of a regular user are rejected
+	if _, err := c.GetPresence(ctx, &grpcapi.GetPresenceRequest{UserIds: []string{data.SeedUser2.ID}}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated error, got: %v", err)
+	}
+	userCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+data.SeedUser2.JWTToken)
+	if _, err := c.GetPresence(userCtx, &grpcapi.GetPresenceRequest{UserIds: []string{data.SeedUser2.ID}}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated error, got: %v", err)
+	}
+
+	admin := data.SeedUser1
+	admin.JWTToken = signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": admin.ID, "role": "admin"})
+	adminCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+admin.JWTToken)
+
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+
+	pres, err := c.GetPresence(adminCtx, &grpcapi.GetPresenceRequest{UserIds: []string{data.SeedUser2.ID, "unknown"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pres.Presences) != 2 || pres.Presences[0].UserId != data.SeedUser2.ID || !pres.Presences[0].Online || pres.Presences[1].Online {
+		t.Fatalf("unexpected presence: %v", pres.Presences)
+	}
+
+	// messages are sent on behalf of the calling user and delivered to the connected recipient
+	r, err := c.SendMessage(adminCtx, &grpcapi.SendMessageRequest{To: data.SeedUser2.ID, Message: "invoice is ready", ClientId: "invoice-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Id == "" || r.From != admin.ID || r.To != data.SeedUser2.ID || r.State != models.StateSent {
+		t.Fatalf("unexpected receipt: %v", r)
+	}
+	msgs := ch.GetMessagesWait()
+	if len(msgs) != 1 || msgs[0].ID != r.Id || msgs[0].From != admin.ID || msgs[0].Message != "invoice is ready" {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+
+	// retries with the same client ID are not sent again
+	r2, err := c.SendMessage(adminCtx, &grpcapi.SendMessageRequest{To: data.SeedUser2.ID, Message: "invoice is ready", ClientId: "invoice-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r2.Id != r.Id {
+		t.Fatalf("expected the retry to return the receipt of message %v, got: %v", r.Id, r2)
+	}
+
+	ds, err := c.QueryDeliveryState(adminCtx, &grpcapi.QueryDeliveryStateRequest{MessageId: r.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.Id != r.Id || ds.State == "" {
+		t.Fatalf("unexpected delivery state: %v", ds)
+	}
+	if _, err := c.QueryDeliveryState(adminCtx, &grpcapi.QueryDeliveryStateRequest{MessageId: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+	if _, err := c.SendMessage(adminCtx, &grpcapi.SendMessageRequest{To: data.SeedUser2.ID}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument error, got: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthChecks(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	hs := httptest.NewServer(sh.server.HealthHandler())
+	defer hs.Close()
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		status, res := getHealth(t, hs.URL+path)
+		if status != http.StatusOK || res.Status != "ok" || res.Checks["listener"] != "ok" {
+			t.Fatalf("expected %v to pass, got: %v: %+v", path, status, res)
+		}
+	}
+
+	if _, res := getHealth(t, hs.URL+"/readyz"); res.Checks["db"] != "ok" || res.Checks["gcm"] != "disabled" {
+		t.Fatalf("unexpected readiness checks: %+v", res)
+	}
+
+	sh.CloseWait()
+	if status, res := getHealth(t, hs.URL+"/healthz"); status != http.StatusServiceUnavailable || res.Status != "fail" {
+		t.Fatalf("expected liveness check to fail after server is closed, got: %v: %+v", status, res)
+	}
+}
+
+type healthRes struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func getHealth(t *testing.T, url string) (int, healthRes) {
+	r, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+
+	var res healthRes
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		t.Fatalf("failed to read health check response: %v", err)
+	}
+	return r.StatusCode, res
+}
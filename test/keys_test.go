@@ -0,0 +1,76 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+func TestKeyDistribution(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	// user 1 uploads its keys in two batches
+	spk := &models.SignedPreKey{ID: 1, Key: []byte("signed-prekey"), Signature: []byte("signature")}
+	b := &models.KeyBundle{IdentityKey: []byte("identity-1"), SignedPreKey: spk, PreKeys: []models.PreKey{{ID: 1, Key: []byte("prekey-1")}}}
+	if n := ch1.UploadKeysSync(b); n != 1 {
+		t.Fatalf("expected 1 prekey to be available, got: %v", n)
+	}
+	b.PreKeys = []models.PreKey{{ID: 2, Key: []byte("prekey-2")}}
+	if n := ch1.UploadKeysSync(b); n != 2 {
+		t.Fatalf("expected 2 prekeys to be available, got: %v", n)
+	}
+
+	// each one-time prekey is given out only once, in the order of upload
+	for _, id := range []int{1, 2, 0} {
+		kb := ch2.GetKeysSync(data.SeedUser1.ID)
+		if kb.UserID != data.SeedUser1.ID || !bytes.Equal(kb.IdentityKey, []byte("identity-1")) || kb.SignedPreKey == nil || !bytes.Equal(kb.SignedPreKey.Signature, spk.Signature) {
+			t.Fatalf("unexpected key bundle: %+v", kb)
+		}
+		if (id == 0 && len(kb.PreKeys) != 0) || (id != 0 && (len(kb.PreKeys) != 1 || kb.PreKeys[0].ID != id)) {
+			t.Fatalf("expected prekey %v, got: %+v", id, kb.PreKeys)
+		}
+	}
+	if n := ch1.CountPreKeysSync(); n != 0 {
+		t.Fatalf("expected no prekeys left, got: %v", n)
+	}
+
+	// prekeys of the previous identity key are dropped upon a new identity key
+	b.PreKeys = []models.PreKey{{ID: 3, Key: []byte("prekey-3")}}
+	ch1.UploadKeysSync(b)
+	b.IdentityKey, b.PreKeys = []byte("identity-2"), []models.PreKey{{ID: 1, Key: []byte("prekey-1")}}
+	if n := ch1.UploadKeysSync(b); n != 1 {
+		t.Fatalf("expected only the prekey of the new identity key to be available, got: %v", n)
+	}
+	if kb := ch2.GetKeysSync(data.SeedUser1.ID); !bytes.Equal(kb.IdentityKey, []byte("identity-2")) || len(kb.PreKeys) != 1 || kb.PreKeys[0].ID != 1 {
+		t.Fatalf("unexpected key bundle after identity key change: %+v", kb)
+	}
+
+	// bundles of the users without keys are not found, and uploads without an identity key are rejected
+	for _, f := range []func(func()) error{
+		func(done func()) error {
+			return ch1.Client.GetKeys(data.SeedUser2.ID, func(b *models.KeyBundle) error { done(); return nil })
+		},
+		func(done func()) error {
+			return ch2.Client.UploadKeys(&models.KeyBundle{SignedPreKey: spk}, func(n int) error { done(); return nil })
+		},
+	} {
+		gotRes := make(chan bool, 1)
+		if err := f(func() { gotRes <- true }); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-gotRes:
+			t.Fatal("expected an error response")
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"golang.org/x/net/websocket"
+)
+
+func TestListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "titan-listeners")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	serverCert, certFile, keyFile := writeCertFiles(t, dir, "server", titan.CertOptions{Hosts: []string{"127.0.0.1"}})
+	socket := filepath.Join(dir, "titan.sock")
+
+	titan.InitConf("test")
+	defer titan.InitConf("test")
+	titan.Conf.App.TLSCert, titan.Conf.App.TLSKey = certFile, keyFile
+	titan.Conf.App.Listeners = "ws://127.0.0.1:3020, wss://127.0.0.1:3021, unix://" + socket
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(serverCert)
+
+	// a local sidecar connects through the unix socket
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := websocket.NewConfig("ws://localhost/", "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 3))
+
+	type msg struct {
+		ID     string           `json:"id"`
+		Method string           `json:"method"`
+		Params []models.Message `json:"params"`
+		Result interface{}      `json:"result"`
+	}
+	if err := websocket.JSON.Send(ws, map[string]interface{}{"id": "1", "method": "auth.jwt", "params": map[string]string{"token": data.SeedUser1.JWTToken}}); err != nil {
+		t.Fatal(err)
+	}
+	var res msg
+	if err := websocket.JSON.Receive(ws, &res); err != nil || res.ID != "1" || res.Result != "ACK" {
+		t.Fatalf("unexpected auth.jwt response: %+v: %v", res, err)
+	}
+
+	// clients connected to the other listeners share the routes and the queue
+	ch2 := NewClientHelper(t, "ws://127.0.0.1:3020").AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+	ch2.SendMessagesSync([]models.Message{{To: data.SeedUser1.ID, Message: "hello"}})
+
+	var req msg
+	if err := websocket.JSON.Receive(ws, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != "msg.recv" || len(req.Params) != 1 || req.Params[0].From != data.SeedUser2.ID || req.Params[0].Message != "hello" {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+	if err := websocket.JSON.Send(ws, map[string]interface{}{"id": req.ID, "result": "ACK"}); err != nil {
+		t.Fatal(err)
+	}
+	if r := ch2.GetReceiptWait(models.StateDelivered); r.To != data.SeedUser1.ID {
+		t.Fatalf("unexpected receipt: %+v", r)
+	}
+
+	ch1 := NewClientHelper(t, "ws://127.0.0.1:3021").WithTLS(&tls.Config{RootCAs: roots}).AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch1.SendMessagesSync([]models.Message{{To: data.SeedUser2.ID, Message: "over tls"}})
+	if msgs := ch2.GetMessagesWait(); len(msgs) != 1 || msgs[0].From != data.SeedUser1.ID || msgs[0].Message != "over tls" {
+		t.Fatalf("unexpected messages: %+v", msgs)
+	}
+}
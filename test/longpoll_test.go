@@ -0,0 +1,136 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+type pollMsg struct {
+	ID     string          `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+func pollSend(t *testing.T, url, session string, msgs ...interface{}) string {
+	b, err := json.Marshal(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session != "" {
+		url += "?session=" + session
+	}
+
+	res, err := http.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("poll send failed with status: %v", res.Status)
+	}
+
+	var r struct{ Session string }
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	return r.Session
+}
+
+// pollReceive polls until at least one message is received.
+func pollReceive(t *testing.T, url, session string) []pollMsg {
+	for i := 0; i < 10; i++ {
+		res, err := http.Get(url + "?session=" + session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			t.Fatalf("poll receive failed with status: %v", res.Status)
+		}
+
+		var msgs []pollMsg
+		err = json.NewDecoder(res.Body).Decode(&msgs)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 0 {
+			return msgs
+		}
+	}
+
+	t.Fatal("did not receive any messages in time")
+	return nil
+}
+
+func TestLongPoll(t *testing.T) {
+	titan.InitConf("test")
+	defer titan.InitConf("test")
+	titan.Conf.App.LongPollHold = time.Millisecond * 200
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+	url := "http://127.0.0.1:" + titan.Conf.App.Port + "/poll"
+
+	// authenticate as user 1 over long-polling
+	session := pollSend(t, url, "", map[string]interface{}{"id": "1", "method": "auth.jwt", "params": map[string]string{"token": data.SeedUser1.JWTToken}})
+	if msgs := pollReceive(t, url, session); msgs[0].ID != "1" || string(msgs[0].Result) != `"ACK"` {
+		t.Fatalf("unexpected auth.jwt response: %+v", msgs)
+	}
+
+	// receive a message from user 2, who is connected with websocket
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+	ch2.SendMessagesSync([]models.Message{models.Message{To: data.SeedUser1.ID, Message: "hello"}})
+
+	msgs := pollReceive(t, url, session)
+	if msgs[0].Method != "msg.recv" {
+		t.Fatalf("expected msg.recv request, got: %+v", msgs)
+	}
+	var recv []models.Message
+	if err := json.Unmarshal(msgs[0].Params, &recv); err != nil || len(recv) != 1 || recv[0].From != data.SeedUser2.ID || recv[0].Message != "hello" {
+		t.Fatalf("unexpected message: %s", msgs[0].Params)
+	}
+	pollSend(t, url, session, map[string]interface{}{"id": msgs[0].ID, "result": "ACK"})
+
+	// sender is notified of the delivery
+	if r := ch2.GetReceiptWait(models.StateDelivered); r.To != data.SeedUser1.ID {
+		t.Fatalf("unexpected receipt: %+v", r)
+	}
+
+	// compressed messages are delivered as plain json in case the session negotiated compression, and codecs are never negotiated
+	large := strings.Repeat("hello ", 1000)
+	pollSend(t, url, session,
+		map[string]interface{}{"id": "2", "method": "conn.caps", "params": models.Capabilities{Codecs: []string{"msgpack"}, Compression: []string{"deflate"}}},
+		map[string]interface{}{"id": "3", "method": "echo", "params": map[string]string{"message": large}})
+	for got := 0; got < 2; {
+		for _, m := range pollReceive(t, url, session) {
+			if m.ID == "2" && (strings.Contains(string(m.Result), "msgpack") || !strings.Contains(string(m.Result), "deflate")) {
+				t.Fatalf("expected deflate compression without a codec, got: %s", m.Result)
+			}
+			if m.ID == "3" && !strings.Contains(string(m.Result), large) {
+				t.Fatalf("unexpected echo response of %v bytes", len(m.Result))
+			}
+			got++
+		}
+	}
+
+	// unknown sessions are rejected so the client can start a new one
+	res, err := http.Get(url + "?session=unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected unknown session to be rejected, got: %v", res.Status)
+	}
+}
@@ -1,11 +1,14 @@
 package test
 
 import (
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/titan-x/titan"
 	"github.com/titan-x/titan/data"
 	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
 )
 
 func TestSendEcho(t *testing.T) {
@@ -120,9 +123,408 @@ func TestSendMsgOffline(t *testing.T) {
 	// todo: verify that there are no pending requests for either user 1 or 2
 }
 
+func TestSendBatch(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	// receipts are returned in the order of the recipients
+	m := "Forwarded to everyone"
+	rs := ch1.SendBatchSync([]string{"2", "echo"}, m)
+	if len(rs) != 2 || rs[0].To != "2" || rs[1].To != "echo" || rs[0].ID == "" || rs[0].ID == rs[1].ID || rs[0].State != models.StateSent {
+		t.Fatalf("unexpected receipts: %+v", rs)
+	}
+
+	if msgs := ch2.GetMessagesWait(); len(msgs) != 1 || msgs[0].ID != rs[0].ID || msgs[0].From != "1" || msgs[0].Message != m {
+		t.Fatalf("expected message: %v, got: %+v", rs[0].ID, msgs)
+	}
+	if msgs := ch1.GetMessagesWait(); len(msgs) != 1 || msgs[0].ID != rs[1].ID || msgs[0].From != "echo" || msgs[0].Message != m {
+		t.Fatalf("expected echo message: %v, got: %+v", rs[1].ID, msgs)
+	}
+
+	// batches with a missing recipient are rejected as a whole
+	gotRes := make(chan *neptulon.ResCtx)
+	if err := ch1.Client.SendRequest("msg.sendBatch", map[string]interface{}{"to": []string{"2", ""}, "message": "not sent"}, func(ctx *neptulon.ResCtx) error {
+		gotRes <- ctx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ctx := <-gotRes:
+		if ctx.Success {
+			t.Fatal("expected batch with a missing recipient to be rejected")
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get a msg.sendBatch response in time")
+	}
+
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "after batch"}})
+	if msgs := ch2.GetMessagesWait(); msgs[0].Message != "after batch" {
+		t.Fatalf("expected rejected batch not to be delivered, got: %+v", msgs[0])
+	}
+}
+
+func TestSendBatchPartialFailure(t *testing.T) {
+	sh := NewServerHelper(t)
+	sh.server.SetMessageDB(saveFailingDB{MessageDB: sh.db, to: "3"})
+	sh.ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	// messages which could not be saved are reported in their receipts while the rest of the batch is sent
+	m := "Partially sent"
+	rs := ch1.SendBatchSync([]string{"3", "2"}, m)
+	if len(rs) != 2 || rs[0].To != "3" || rs[0].State != models.StateFailed || rs[0].Error == "" {
+		t.Fatalf("expected failed receipt for user 3, got: %+v", rs)
+	}
+	if rs[1].To != "2" || rs[1].State != models.StateSent || rs[1].Error != "" {
+		t.Fatalf("expected sent receipt for user 2, got: %+v", rs)
+	}
+
+	if msgs := ch2.GetMessagesWait(); len(msgs) != 1 || msgs[0].ID != rs[1].ID || msgs[0].Message != m {
+		t.Fatalf("expected message: %v, got: %+v", rs[1].ID, msgs)
+	}
+	if r := ch1.GetReceiptWait(models.StateSent); r.ID != rs[1].ID {
+		t.Fatalf("expected msg.sent receipt only for the sent message: %v, got: %+v", rs[1].ID, r)
+	}
+}
+
+// saveFailingDB is a message database which fails to save the messages to the given recipient.
+type saveFailingDB struct {
+	data.MessageDB
+	to string
+}
+
+func (db saveFailingDB) SaveMessage(m *models.Message) error {
+	if m.To == db.to {
+		return errors.New("database is unavailable")
+	}
+	return db.MessageDB.SaveMessage(m)
+}
+
+func TestIdempotentSend(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	ch1.SendMessagesSync([]models.Message{models.Message{ClientID: "c1", To: "2", Message: "Only once"}})
+	sent := ch1.GetReceiptWait(models.StateSent)
+	if sent.ID == "" || sent.ClientID != "c1" {
+		t.Fatalf("unexpected msg.sent receipt: %+v", sent)
+	}
+	if msgs := ch2.GetMessagesWait(); len(msgs) != 1 || msgs[0].ID != sent.ID || msgs[0].ClientID != "" {
+		t.Fatalf("expected message: %v without client ID, got: %+v", sent.ID, msgs)
+	}
+
+	// retry of the same message is not delivered again, and the sender gets the receipt of the original message
+	gotRes := make(chan []models.Receipt)
+	msgs := []models.Message{models.Message{ClientID: "c1", To: "2", Message: "Only once"}, models.Message{ClientID: "c2", To: "2", Message: "Next"}}
+	if err := ch1.Client.SendRequest("msg.sendBatch", map[string]interface{}{"messages": msgs}, func(ctx *neptulon.ResCtx) error {
+		var rs []models.Receipt
+		ctx.Result(&rs)
+		gotRes <- rs
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case rs := <-gotRes:
+		if len(rs) != 2 || rs[0].ID != sent.ID || rs[0].ClientID != "c1" || rs[1].ID == sent.ID || rs[1].ClientID != "c2" {
+			t.Fatalf("unexpected receipts: %+v", rs)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get a msg.sendBatch response in time")
+	}
+	if msgs := ch2.GetMessagesWait(); len(msgs) != 1 || msgs[0].Message != "Next" {
+		t.Fatalf("expected only the next message, got: %+v", msgs)
+	}
+}
+
 func TestSendAsync(t *testing.T) {
 	// test case to do all of the following simultaneously to test the async nature of titan server
 	// - cert.auth
 	// - msg.recv
 	// - msg.send (bath to multiple people where some of whom are online)
 }
+
+func TestDeliveryReceipts(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "Did you get this?"}})
+
+	sent := ch1.GetReceiptWait(models.StateSent)
+	if sent.ID == "" || sent.From != "1" || sent.To != "2" {
+		t.Fatalf("unexpected msg.sent receipt: %+v", sent)
+	}
+
+	msgs := ch2.GetMessagesWait()
+	if msgs[0].ID != sent.ID {
+		t.Fatalf("expected message ID: %v, got: %v", sent.ID, msgs[0].ID)
+	}
+
+	if r := ch1.GetReceiptWait(models.StateDelivered); r.ID != sent.ID {
+		t.Fatalf("expected msg.delivered receipt for message: %v, got: %+v", sent.ID, r)
+	}
+
+	ch2.ReadMessagesSync([]string{msgs[0].ID})
+	if r := ch1.GetReceiptWait(models.StateRead); r.ID != sent.ID {
+		t.Fatalf("expected msg.read receipt for message: %v, got: %+v", sent.ID, r)
+	}
+}
+
+func TestReadWatermark(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	var ids []string
+	for _, m := range []string{"message-1", "message-2", "message-3"} {
+		ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: m}})
+		ids = append(ids, ch2.GetMessagesWait()[0].ID)
+		ch1.GetReceiptWait(models.StateDelivered)
+	}
+	ch2.ReadMessagesSync([]string{ids[0]})
+	ch1.GetReceiptWait(models.StateRead)
+
+	// messages up to the watermark which are not read yet are marked read, and the sender is notified with a single request
+	ch2.ReadConversationSync(models.DirectConversation("1", "2"), ids[2])
+	timeout := time.After(time.Second * 3)
+	for {
+		select {
+		case rs := <-ch1.receipts:
+			if rs[0].State != models.StateRead {
+				continue
+			}
+			if len(rs) != 2 || rs[0].ID != ids[2] || rs[1].ID != ids[1] || rs[1].State != models.StateRead {
+				t.Fatalf("expected read receipts for the last two messages, got: %+v", rs)
+			}
+			return
+		case <-timeout:
+			t.Fatal("did not get the read receipts in time")
+		}
+	}
+}
+
+func TestMultiDeviceDelivery(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	phone := sh.GetClientHelper().AsUser(&data.SeedUser2).AsDevice("phone").Connect().JWTAuthSync()
+	defer phone.CloseWait()
+	tablet := sh.GetClientHelper().AsUser(&data.SeedUser2).AsDevice("tablet").Connect().JWTAuthSync()
+	defer tablet.CloseWait()
+
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "Hi on all devices!"}})
+	sent := ch1.GetReceiptWait(models.StateSent)
+
+	// message should be delivered to all the devices of the user
+	for _, ch := range []*ClientHelper{phone, tablet} {
+		msgs := ch.GetMessagesWait()
+		if msgs[0].ID != sent.ID || msgs[0].Message != "Hi on all devices!" {
+			t.Fatalf("expected message: %v, got: %+v", sent.ID, msgs[0])
+		}
+	}
+
+	r := ch1.GetReceiptWait(models.StateDelivered)
+	if r.ID != sent.ID || (r.Device != "phone" && r.Device != "tablet") || r.Devices[r.Device] != models.StateDelivered {
+		t.Fatalf("expected msg.delivered receipt from one of the devices for message: %v, got: %+v", sent.ID, r)
+	}
+
+	tablet.ReadMessagesSync([]string{sent.ID})
+	r = ch1.GetReceiptWait(models.StateRead)
+	if r.ID != sent.ID || r.Device != "tablet" || r.Devices["tablet"] != models.StateRead {
+		t.Fatalf("expected msg.read receipt from tablet for message: %v, got: %+v", sent.ID, r)
+	}
+
+	// user should still be reachable after one of the devices disconnects
+	tablet.CloseWait()
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "Only on phone"}})
+	if msgs := phone.GetMessagesWait(); msgs[0].Message != "Only on phone" {
+		t.Fatalf("expected message: Only on phone, got: %+v", msgs[0])
+	}
+}
+
+func TestMessageHistory(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	for _, m := range []string{"message-1", "message-2", "message-3"} {
+		ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: m}})
+		ch2.GetMessagesWait()
+		ch1.GetReceiptWait(models.StateDelivered)
+	}
+
+	// retrieve history in batches, newest first, from the recipient's side
+	conv := models.DirectConversation("2", "1")
+	msgs, cursor := ch2.MessageHistorySync(conv, "", 2)
+	if len(msgs) != 2 || msgs[0].Message != "message-3" || msgs[1].Message != "message-2" || cursor == "" {
+		t.Fatalf("unexpected first history batch: %+v, cursor: %v", msgs, cursor)
+	}
+	if msgs[0].From != "1" || msgs[0].To != "2" || msgs[0].State != models.StateDelivered {
+		t.Fatalf("unexpected message in history: %+v", msgs[0])
+	}
+
+	msgs, cursor = ch2.MessageHistorySync(conv, cursor, 2)
+	if len(msgs) != 1 || msgs[0].Message != "message-1" || cursor != "" {
+		t.Fatalf("unexpected last history batch: %+v, cursor: %v", msgs, cursor)
+	}
+
+	// users should not be able to read the history of conversations they are not a part of
+	var gotErr bool
+	gotRes := make(chan bool)
+	ch1.Client.MessageHistory(models.DirectConversation("2", "3"), "", 10, func(msgs []models.Message, cursor string) error {
+		gotRes <- true
+		return nil
+	})
+	select {
+	case <-gotRes:
+	case <-time.After(time.Millisecond * 100):
+		gotErr = true
+	}
+	if !gotErr {
+		t.Fatal("retrieved history of a conversation the user is not a part of")
+	}
+}
+
+func TestMessageRetention(t *testing.T) {
+	titan.InitConf("test")
+	defer titan.InitConf("test")
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "old lunch"}})
+	ch2.GetMessagesWait()
+	ch1.GetReceiptWait(models.StateDelivered)
+	cutoff := time.Now()
+	time.Sleep(time.Millisecond * 100)
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "new lunch"}})
+	ch2.GetMessagesWait()
+	ch1.GetReceiptWait(models.StateDelivered)
+
+	// messages are kept unless a retention period is set
+	if n, err := sh.server.ReapMessages(); err != nil || n != 0 {
+		t.Fatalf("expected no messages to be deleted without retention period, got: %v, %v", n, err)
+	}
+
+	titan.Conf.App.MsgRetention = time.Since(cutoff)
+	if n, err := sh.server.ReapMessages(); err != nil || n != 1 {
+		t.Fatalf("expected 1 message to be deleted, got: %v, %v", n, err)
+	}
+
+	// expired message is gone from both the history and the search index, while the delivery state of the rest is kept
+	msgs, _ := ch2.MessageHistorySync(models.DirectConversation("1", "2"), "", 10)
+	if len(msgs) != 1 || msgs[0].Message != "new lunch" || msgs[0].State != models.StateDelivered {
+		t.Fatalf("expected only the new message in history, got: %+v", msgs)
+	}
+	if msgs, _ := ch1.SearchMessagesSync("lunch", "", "", 10); len(msgs) != 1 || msgs[0].Message != "new lunch" {
+		t.Fatalf("expected only the new message in search results, got: %+v", msgs)
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	for _, m := range []models.Message{
+		models.Message{To: "2", Message: "lunch at noon?"},
+		models.Message{To: "2", Message: "Lunch, lunch, lunch!"},
+		models.Message{To: "2", Message: "dinner then"},
+		models.Message{To: "2", Message: "secret lunch", Encrypted: true},
+	} {
+		ch1.SendMessagesSync([]models.Message{m})
+		ch2.GetMessagesWait()
+		ch1.GetReceiptWait(models.StateDelivered)
+	}
+
+	// most relevant message first, from both sides of the conversation, and encrypted messages are not indexed
+	conv := models.DirectConversation("1", "2")
+	msgs, cursor := ch2.SearchMessagesSync("LUNCH", "", "", 10)
+	if len(msgs) != 2 || msgs[0].Message != "Lunch, lunch, lunch!" || msgs[1].Message != "lunch at noon?" || cursor != "" {
+		t.Fatalf("unexpected search results: %+v, cursor: %v", msgs, cursor)
+	}
+	if msgs, _ = ch1.SearchMessagesSync("lunch", conv, "", 10); len(msgs) != 2 {
+		t.Fatalf("unexpected search results for sender: %+v", msgs)
+	}
+
+	// all the words should match
+	if msgs, _ = ch1.SearchMessagesSync("lunch noon", conv, "", 10); len(msgs) != 1 || msgs[0].Message != "lunch at noon?" {
+		t.Fatalf("unexpected search results for multiple words: %+v", msgs)
+	}
+	if msgs, _ = ch1.SearchMessagesSync("lunch dinner", "", "", 10); len(msgs) != 0 {
+		t.Fatalf("expected no search results but got: %+v", msgs)
+	}
+
+	// search is scoped to the given conversation
+	if msgs, _ = ch1.SearchMessagesSync("lunch", models.DirectConversation("1", "3"), "", 10); len(msgs) != 0 {
+		t.Fatalf("expected no search results in another conversation but got: %+v", msgs)
+	}
+
+	// results are paged
+	msgs, cursor = ch1.SearchMessagesSync("lunch", "", "", 1)
+	if len(msgs) != 1 || msgs[0].Message != "Lunch, lunch, lunch!" || cursor == "" {
+		t.Fatalf("unexpected first search page: %+v, cursor: %v", msgs, cursor)
+	}
+	msgs, cursor = ch1.SearchMessagesSync("lunch", "", cursor, 1)
+	if len(msgs) != 1 || msgs[0].Message != "lunch at noon?" || cursor != "" {
+		t.Fatalf("unexpected last search page: %+v, cursor: %v", msgs, cursor)
+	}
+
+	// queries without any words are rejected
+	var gotErr bool
+	gotRes := make(chan bool)
+	ch1.Client.SearchMessages(" ?! ", "", "", 10, func(msgs []models.Message, cursor string) error {
+		gotRes <- true
+		return nil
+	})
+	select {
+	case <-gotRes:
+	case <-time.After(time.Millisecond * 100):
+		gotErr = true
+	}
+	if !gotErr {
+		t.Fatal("searched messages with an empty query")
+	}
+}
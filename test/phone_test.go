@@ -0,0 +1,97 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/neptulon"
+)
+
+// smsInbox is an sms.Sender keeping the sent text messages by the recipient number.
+type smsInbox chan [2]string
+
+func (in smsInbox) Send(to, body string) error {
+	in <- [2]string{to, body}
+	return nil
+}
+
+func (in smsInbox) code(t *testing.T, to string) string {
+	select {
+	case m := <-in:
+		if m[0] != to {
+			t.Fatalf("expected text message to be sent to %v, got: %v", to, m[0])
+		}
+		return codeRegexp.FindString(m[1])
+	case <-time.After(time.Second):
+		t.Fatal("verification text message was not sent")
+	}
+	return ""
+}
+
+func TestPhoneVerification(t *testing.T) {
+	sh := NewServerHelper(t)
+	defer sh.CloseWait()
+	in := make(smsInbox, 10)
+	sh.server.SetSMSSender(in)
+	sh.ListenAndServe()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	// wrong codes are rejected without binding the number
+	ch1.RegisterPhoneSync("+90 532 000 00 00")
+	code := in.code(t, "+905320000000")
+	res := make(chan *neptulon.ResCtx)
+	if err := ch1.Client.SendRequest("phone.verify", map[string]string{"phone": "+905320000000", "code": "x" + code}, func(ctx *neptulon.ResCtx) error {
+		res <- ctx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if ctx := <-res; ctx.Success {
+		t.Fatal("expected wrong code to be rejected")
+	}
+
+	// the other user cannot use the code sent to the caller
+	if err := ch2.Client.SendRequest("phone.verify", map[string]string{"phone": "+905320000000", "code": code}, func(ctx *neptulon.ResCtx) error {
+		res <- ctx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if ctx := <-res; ctx.Success {
+		t.Fatal("expected code of another user to be rejected")
+	}
+
+	ch1.VerifyPhoneSync("+90 (532) 000-00-00", code)
+	if u, _ := sh.db.GetByID(data.SeedUser1.ID); u.PhoneNumber != "+905320000000" {
+		t.Fatalf("expected verified phone number to be bound to the user, got: %v", u.PhoneNumber)
+	}
+
+	ids := ch2.DiscoverContactsSync([]string{"+90 532 000 00 00", "+1 555 0100", "not a number", data.SeedUser2.PhoneNumber})
+	if len(ids) != 1 || ids["+90 532 000 00 00"] != data.SeedUser1.ID {
+		t.Fatalf("unexpected discovered contacts: %v", ids)
+	}
+
+	// number is moved to the user who verified it last, as the numbers are recycled by the carriers
+	ch2.RegisterPhoneSync("+905320000000")
+	ch2.VerifyPhoneSync("+905320000000", in.code(t, "+905320000000"))
+	if u, _ := sh.db.GetByPhone("+905320000000"); u.ID != data.SeedUser2.ID {
+		t.Fatalf("expected phone number to be moved to the user, got: %v", u.ID)
+	}
+	if u, _ := sh.db.GetByID(data.SeedUser1.ID); u.PhoneNumber != "" {
+		t.Fatalf("expected phone number to be unbound from the previous user, got: %v", u.PhoneNumber)
+	}
+
+	// users who blocked the caller are not discovered
+	if ids := ch1.DiscoverContactsSync([]string{"+905320000000"}); len(ids) != 1 {
+		t.Fatalf("unexpected discovered contacts: %v", ids)
+	}
+	ch2.BlockSync(data.SeedUser1.ID)
+	if ids := ch1.DiscoverContactsSync([]string{"+905320000000"}); len(ids) != 0 {
+		t.Fatalf("expected blocking user not to be discovered, got: %v", ids)
+	}
+}
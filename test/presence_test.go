@@ -0,0 +1,50 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/titan-x/titan/data"
+)
+
+func TestPresence(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+
+	// only contacts can be subscribed to
+	if p := ch1.SubscribePresenceSync([]string{"2"}); len(p) != 0 {
+		t.Fatalf("expected non-contacts to be omitted, got: %+v", p)
+	}
+	ch1.AddContactSync("2")
+
+	// subscribe to offline user 2
+	p := ch1.SubscribePresenceSync([]string{"2"})
+	if len(p) != 1 || p[0].UserID != "2" || p[0].Online {
+		t.Fatalf("expected user 2 to be offline, got: %+v", p)
+	}
+
+	// get user 2 online
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	p = ch1.GetPresenceWait()
+	if len(p) != 1 || p[0].UserID != "2" || !p[0].Online {
+		t.Fatalf("expected user 2 to be online, got: %+v", p)
+	}
+
+	// get user 2 offline again
+	ch2.CloseWait()
+	p = ch1.GetPresenceWait()
+	if len(p) != 1 || p[0].UserID != "2" || p[0].Online || p[0].LastSeen.IsZero() {
+		t.Fatalf("expected user 2 to be offline with last seen time, got: %+v", p)
+	}
+
+	// users who blocked the subscriber are omitted
+	ch2 = sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+	ch1.GetPresenceWait()
+	ch2.BlockSync("1")
+	if p := ch1.SubscribePresenceSync([]string{"2"}); len(p) != 0 {
+		t.Fatalf("expected users who blocked the subscriber to be omitted, got: %+v", p)
+	}
+}
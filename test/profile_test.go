@@ -0,0 +1,36 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+func TestProfiles(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	// profiles of the users who are not in the contacts are not retrieved
+	if ps := ch1.GetProfilesSync([]string{"2"}); len(ps) != 0 {
+		t.Fatalf("expected no profiles, got: %+v", ps)
+	}
+
+	ch1.AddContactSync("2")
+	p := ch2.SetProfileSync(&models.Profile{Name: "Chuck", Status: "busy", Avatar: "avatar-id"})
+	if p.UserID != "2" || p.Name != "Chuck" || p.Status != "busy" || p.Avatar != "avatar-id" {
+		t.Fatalf("unexpected profile: %+v", p)
+	}
+
+	if up := ch1.GetProfileWait(); *up != *p {
+		t.Fatalf("expected profile update %+v, got: %+v", p, up)
+	}
+	if ps := ch1.GetProfilesSync([]string{"1", "2"}); len(ps) != 2 || ps[0].UserID != "1" || ps[1] != *p {
+		t.Fatalf("unexpected profiles: %+v", ps)
+	}
+}
@@ -1,10 +1,18 @@
 package test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/titan-x/titan"
 	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/data/file"
 	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
 )
 
 func TestToCaseInsensitive(t *testing.T) {
@@ -50,3 +58,167 @@ func TestMultipleConcurrentQueuedMessages(t *testing.T) {
 		t.Fatalf("expected 4 messages, got %v", len(msgs))
 	}
 }
+
+func TestPersistentQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "titan-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	qs, err := file.NewQueueStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// send a message to offline user 2 and restart the server
+	sh := NewServerHelper(t)
+	sh.server.SetQueueStore(qs)
+	sh.ListenAndServe()
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "Hello after restart!"}})
+	ch1.CloseWait()
+	sh.CloseWait()
+
+	sh = NewServerHelper(t)
+	sh.server.SetQueueStore(qs)
+	sh.ListenAndServe()
+	defer sh.CloseWait()
+
+	// user 2 should receive the message queued by the previous server instance
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	msgs := ch2.GetMessagesWait()
+	if len(msgs) != 1 || msgs[0].From != "1" || msgs[0].Message != "Hello after restart!" {
+		t.Fatalf("expected the persisted message, got: %+v", msgs)
+	}
+}
+
+func TestQueueSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "titan-queue-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queue.json")
+
+	qs, err := file.NewSnapshotQueueStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// send a message to offline user 2, and snapshot the queue upon shutdown
+	sh := NewServerHelper(t)
+	sh.server.SetQueueStore(qs)
+	sh.ListenAndServe()
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "Hello after snapshot!"}})
+	ch1.CloseWait()
+	sh.CloseWait()
+	if err := qs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// queue is loaded back from the snapshot upon startup
+	if qs, err = file.NewSnapshotQueueStore(path); err != nil {
+		t.Fatal(err)
+	}
+	sh = NewServerHelper(t)
+	sh.server.SetQueueStore(qs)
+	sh.ListenAndServe()
+	defer sh.CloseWait()
+
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	msgs := ch2.GetMessagesWait()
+	if len(msgs) != 1 || msgs[0].From != "1" || msgs[0].Message != "Hello after snapshot!" {
+		t.Fatalf("expected the snapshotted message, got: %+v", msgs)
+	}
+}
+
+func TestQueueTTL(t *testing.T) {
+	titan.InitConf("test")
+	defer titan.InitConf("test")
+	titan.Conf.App.MsgTTL = time.Millisecond * 100
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	admin := data.SeedUser1
+	admin.JWTToken = signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": admin.ID, "role": "admin"})
+	ch1 := sh.GetClientHelper().AsUser(&admin).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	expired := ch1.StatsSync().QueueExpired
+
+	// message to offline user 2 expires before user 2 connects
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "Too late"}})
+	time.Sleep(time.Millisecond * 200)
+
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "Just in time"}})
+	if msgs := ch2.GetMessagesWait(); len(msgs) != 1 || msgs[0].Message != "Just in time" {
+		t.Fatalf("expected only the unexpired message, got: %+v", msgs)
+	}
+
+	if s := ch1.StatsSync(); s.QueueExpired != expired+1 {
+		t.Fatalf("expected 1 expired request in stats, got: %+v", s)
+	}
+	// expired message is dead-lettered, and re-driving delivers it after all
+	dls, _ := ch1.ListDeadLettersSync(data.SeedUser2.ID, "", 0)
+	if len(dls) != 1 || dls[0].Method != "msg.recv" || dls[0].Reason != models.DeadLetterExpired || !strings.Contains(string(dls[0].Params), "Too late") {
+		t.Fatalf("expected the expired message in dead letters, got: %+v", dls)
+	}
+	ch1.RedriveSync(dls[0].ID)
+	if msgs := ch2.GetMessagesWait(); len(msgs) != 1 || msgs[0].Message != "Too late" {
+		t.Fatalf("expected the re-driven message, got: %+v", msgs)
+	}
+	if dls, _ := ch1.ListDeadLettersSync(data.SeedUser2.ID, "", 0); len(dls) != 0 {
+		t.Fatalf("expected no dead letters after re-drive, got: %+v", dls)
+	}
+}
+
+func TestQueueLimit(t *testing.T) {
+	sh := NewServerHelper(t)
+	sh.server.SetQueueLimit(2)
+	sh.ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+
+	// offline user 2's queue fills up and further messages are rejected
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "1"}, models.Message{To: "2", Message: "2"}})
+	gotRes := make(chan *neptulon.ResCtx)
+	if err := ch1.Client.SendRequest("msg.send", []models.Message{models.Message{To: "2", Message: "3"}}, func(ctx *neptulon.ResCtx) error {
+		gotRes <- ctx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ctx := <-gotRes:
+		var data map[string]string
+		if ctx.Success || ctx.ErrorData(&data) != nil || data["userid"] != "2" {
+			t.Fatalf("expected queue full error, got: %+v, data: %+v", ctx, data)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get a msg.send response in time")
+	}
+
+	// queued messages are still delivered
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+	got := make(map[string]bool)
+	for len(got) < 2 {
+		for _, m := range ch2.GetMessagesWait() {
+			got[m.Message] = true
+		}
+	}
+	if !got["1"] || !got["2"] {
+		t.Fatalf("expected the queued messages, got: %v", got)
+	}
+}
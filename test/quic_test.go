@@ -0,0 +1,99 @@
+//go:build quic
+// +build quic
+
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"golang.org/x/net/websocket"
+)
+
+func TestQUIC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "titan-quic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	serverCert, certFile, keyFile := writeCertFiles(t, dir, "server", titan.CertOptions{Hosts: []string{"127.0.0.1"}})
+
+	titan.InitConf("test")
+	defer titan.InitConf("test")
+	titan.Conf.App.TLSCert, titan.Conf.App.TLSKey = certFile, keyFile
+	titan.Conf.App.QUICAddr = "127.0.0.1:" + titan.Conf.App.Port
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(serverCert)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	qc, err := quic.DialAddr(ctx, titan.Conf.App.QUICAddr, &tls.Config{RootCAs: roots, NextProtos: []string{titan.QUICALPN}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qc.CloseWithError(0, "")
+	stream, err := qc.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// same protocol as the websocket clients is carried over the stream
+	config, err := websocket.NewConfig("wss://127.0.0.1/", "https://127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := websocket.NewClient(config, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	stream.SetDeadline(time.Now().Add(time.Second * 3))
+
+	type msg struct {
+		ID     string           `json:"id"`
+		Method string           `json:"method"`
+		Params []models.Message `json:"params"`
+		Result interface{}      `json:"result"`
+	}
+	if err := websocket.JSON.Send(ws, map[string]interface{}{"id": "1", "method": "auth.jwt", "params": map[string]string{"token": data.SeedUser1.JWTToken}}); err != nil {
+		t.Fatal(err)
+	}
+	var res msg
+	if err := websocket.JSON.Receive(ws, &res); err != nil || res.ID != "1" || res.Result != "ACK" {
+		t.Fatalf("unexpected auth.jwt response: %+v: %v", res, err)
+	}
+
+	// receive a message from user 2, who is connected with websocket
+	ch2 := sh.GetClientHelper().WithTLS(&tls.Config{RootCAs: roots}).AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+	ch2.SendMessagesSync([]models.Message{models.Message{To: data.SeedUser1.ID, Message: "hello"}})
+
+	var req msg
+	if err := websocket.JSON.Receive(ws, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != "msg.recv" || len(req.Params) != 1 || req.Params[0].From != data.SeedUser2.ID || req.Params[0].Message != "hello" {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+	if err := websocket.JSON.Send(ws, map[string]interface{}{"id": req.ID, "result": "ACK"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := ch2.GetReceiptWait(models.StateDelivered); r.To != data.SeedUser1.ID {
+		t.Fatalf("unexpected receipt: %+v", r)
+	}
+}
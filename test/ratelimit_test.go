@@ -0,0 +1,45 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+func TestMessageRateLimit(t *testing.T) {
+	sh := NewServerHelper(t)
+	sh.server.SetRateLimits(100, 2)
+	sh.ListenAndServe()
+	defer sh.CloseWait()
+
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+
+	ch.SendMessagesSync([]models.Message{models.Message{To: "echo", Message: "1"}, models.Message{To: "echo", Message: "2"}})
+
+	// third message within the same minute should be rejected while the other requests are still allowed
+	gotRes := make(chan *neptulon.ResCtx)
+	if err := ch.Client.SendRequest("msg.send", []models.Message{models.Message{To: "echo", Message: "3"}}, func(ctx *neptulon.ResCtx) error {
+		gotRes <- ctx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ctx := <-gotRes:
+		var data struct {
+			RetryAfter int `json:"retryAfter"`
+		}
+		if ctx.Success || ctx.ErrorData(&data) != nil || data.RetryAfter <= 0 || data.RetryAfter > 60 {
+			t.Fatalf("expected rate limit error with retry after data, got: %+v, data: %+v", ctx, data)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("did not get a msg.send response in time")
+	}
+
+	ch.EchoSync("still allowed")
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/mail"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+// mailbox is a mail.Sender keeping the sent e-mails.
+type mailbox chan mail.Message
+
+func (mb mailbox) Send(m mail.Message) error {
+	mb <- m
+	return nil
+}
+
+var codeRegexp = regexp.MustCompile(`\b\d{6}\b`)
+
+func TestEmailRegistration(t *testing.T) {
+	sh := NewServerHelper(t)
+	defer sh.CloseWait()
+	mb := make(mailbox, 10)
+	sh.server.SetMailSender(mb)
+	sh.ListenAndServe()
+
+	ch := sh.GetClientHelper().Connect()
+	defer ch.CloseWait()
+
+	request := func(method string, params interface{}) *neptulon.ResCtx {
+		res := make(chan *neptulon.ResCtx)
+		if err := ch.Client.SendRequest(method, params, func(ctx *neptulon.ResCtx) error {
+			res <- ctx
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case ctx := <-res:
+			return ctx
+		case <-time.After(time.Second * 3):
+			t.Fatalf("did not get a %v response in time", method)
+			return nil
+		}
+	}
+	register := func(email string) string {
+		var ack string
+		request("auth.register", map[string]string{"email": email, "name": "Jane Doe"}).Result(&ack)
+		return ack
+	}
+	verify := func(email, code string) string {
+		var res struct{ Token string }
+		request("auth.verifyEmail", map[string]string{"email": email, "code": code}).Result(&res)
+		return res.Token
+	}
+
+	// addresses of the verified accounts cannot be registered again
+	if ack := register(data.SeedUser1.Email); ack == "ACK" {
+		t.Fatal("expected registered address to be rejected")
+	}
+
+	if ack := register("jane@titan.test"); ack != "ACK" {
+		t.Fatalf("expected registration to succeed, got: %v", ack)
+	}
+	var m mail.Message
+	select {
+	case m = <-mb:
+	case <-time.After(time.Second):
+		t.Fatal("verification e-mail was not sent")
+	}
+	code := codeRegexp.FindString(m.Body)
+	if m.To != "jane@titan.test" || code == "" {
+		t.Fatalf("unexpected verification e-mail: %+v", m)
+	}
+
+	// account is not signed in until the address is verified, and codes cannot be resent right away
+	u, ok := sh.db.GetByEmail("jane@titan.test")
+	if !ok || !u.Unverified || u.JWTToken != "" {
+		t.Fatalf("expected an unverified account without tokens, got: %+v", u)
+	}
+	if ack := register("jane@titan.test"); ack == "ACK" {
+		t.Fatal("expected the code not to be resent right away")
+	}
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	if token := verify("jane@titan.test", wrong); token != "" {
+		t.Fatal("expected wrong code to be rejected")
+	}
+	token := verify("jane@titan.test", code)
+	if token == "" {
+		t.Fatal("expected a jwt token upon verification")
+	}
+	if u, _ := sh.db.GetByEmail("jane@titan.test"); u.Unverified || u.JWTToken != token {
+		t.Fatalf("expected a verified account, got: %+v", u)
+	}
+
+	// codes cannot be reused
+	if token := verify("jane@titan.test", code); token != "" {
+		t.Fatal("expected used code to be rejected")
+	}
+
+	ch2 := sh.GetClientHelper().AsUser(&models.User{JWTToken: token}).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+}
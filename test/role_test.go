@@ -0,0 +1,98 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/neptulon"
+)
+
+func TestRoles(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	admin := data.SeedUser1
+	admin.JWTToken = signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": admin.ID, "role": "admin"})
+	ch1 := sh.GetClientHelper().AsUser(&admin).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+
+	u := data.SeedUser2
+	ch2 := sh.GetClientHelper().AsUser(&u).Connect().JWTAuthSync()
+
+	// regular users cannot make moderation requests
+	if ctx := sendRaw(t, ch2, "admin.users", nil); ctx.Success {
+		t.Fatalf("expected unauthorized error, got: %+v", ctx)
+	}
+
+	// invalid roles and changing own role are rejected
+	if ctx := sendRaw(t, ch1, "admin.role", map[string]string{"userid": u.ID, "role": "superuser"}); ctx.Success {
+		t.Fatalf("expected invalid role error, got: %+v", ctx)
+	}
+	if ctx := sendRaw(t, ch1, "admin.role", map[string]string{"userid": admin.ID, "role": "user"}); ctx.Success {
+		t.Fatalf("expected error for changing own role, got: %+v", ctx)
+	}
+
+	// promoting a user closes the user's connections so the role is issued in the new tokens
+	closed := make(chan bool, 1)
+	ch2.Client.DisconnHandler(func(c *client.Client) {
+		closed <- true
+	})
+	if _, n := ch1.SetRoleSync(u.ID, models.RoleModerator); n != 1 {
+		t.Fatalf("expected user's connection to be closed, got: %v", n)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("server did not close the connection")
+	}
+	if su, ok := sh.db.GetByID(u.ID); !ok || su.Role != models.RoleModerator {
+		t.Fatalf("expected role to be persisted, got: %+v", su)
+	}
+
+	// moderators are issued the role upon token refresh, and can make moderation requests but not the admin ones
+	h := sha256.Sum256([]byte("refresh-token-2"))
+	if err := sh.db.SaveRefreshToken(&models.RefreshToken{ID: hex.EncodeToString(h[:]), UserID: u.ID, Device: "phone", Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	ch2 = sh.GetClientHelper().AsUser(&u).Connect().RefreshAuthSync("refresh-token-2").JWTAuthSync()
+	defer ch2.CloseWait()
+	if conns := ch2.ListConnsSync(); len(conns) != 2 {
+		t.Fatalf("expected 2 connections, got: %+v", conns)
+	}
+	if ctx := sendRaw(t, ch2, "admin.stats", nil); ctx.Success {
+		t.Fatalf("expected unauthorized error, got: %+v", ctx)
+	}
+
+	// demoting the user revokes the refresh tokens issued with the role
+	if revoked, _ := ch1.SetRoleSync(u.ID, models.RoleUser); revoked != 1 {
+		t.Fatalf("expected user's refresh token to be revoked, got: %v", revoked)
+	}
+	if su, ok := sh.db.GetByID(u.ID); !ok || su.Role != "" {
+		t.Fatalf("expected role to be cleared, got: %+v", su)
+	}
+}
+
+// sendRaw sends a request and returns the response, including the error responses which are not passed to the client handlers.
+func sendRaw(t *testing.T, ch *ClientHelper, method string, params interface{}) *neptulon.ResCtx {
+	gotRes := make(chan *neptulon.ResCtx)
+	if err := ch.Client.SendRequest(method, params, func(ctx *neptulon.ResCtx) error {
+		gotRes <- ctx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ctx := <-gotRes:
+		return ctx
+	case <-time.After(time.Second * 3):
+		t.Fatalf("did not get a %v response in time", method)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"flag"
+	"net"
 	"os"
 	"testing"
 	"time"
@@ -72,7 +73,14 @@ func (sh *ServerHelper) ListenAndServe() *ServerHelper {
 		sh.serverClosed <- true
 	}()
 
-	time.Sleep(time.Millisecond) // give Start() enough time to initiate
+	// wait for the listener to start so that an immediate CloseWait() call won't miss it
+	for i := 0; i < 100; i++ {
+		if c, err := net.Dial("tcp", "127.0.0.1:"+titan.Conf.App.Port); err == nil {
+			c.Close()
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
 	return sh
 }
 
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+func TestSessions(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	h := sha256.Sum256([]byte("refresh-token-phone"))
+	hash := hex.EncodeToString(h[:])
+	if err := sh.db.SaveRefreshToken(&models.RefreshToken{ID: hash, UserID: data.SeedUser2.ID, Device: "phone", Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	phoneUser, laptopUser := data.SeedUser2, data.SeedUser2
+	phone := sh.GetClientHelper().AsUser(&phoneUser).AsDevice("phone").Connect().RefreshAuthSync("refresh-token-phone").JWTAuthSync()
+	defer phone.CloseWait()
+	closed := make(chan bool, 1)
+	phone.Client.DisconnHandler(func(c *client.Client) {
+		closed <- true
+	})
+	laptop := sh.GetClientHelper().AsUser(&laptopUser).AsDevice("laptop").Connect().JWTAuthSync()
+	defer laptop.CloseWait()
+	other := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer other.CloseWait()
+
+	conns := laptop.ListSessionsSync()
+	if len(conns) != 2 {
+		t.Fatalf("expected 2 sessions, got: %+v", conns)
+	}
+	var phoneConn models.Conn
+	for _, c := range conns {
+		if c.UserID != data.SeedUser2.ID || c.ConnectedAt.IsZero() || c.LastActivity.Before(c.ConnectedAt) {
+			t.Fatalf("unexpected session: %+v", c)
+		}
+		if c.Current != (c.Device == "laptop") {
+			t.Fatalf("expected only the laptop session to be current, got: %+v", c)
+		}
+		if c.Device == "phone" {
+			phoneConn = c
+		}
+	}
+
+	if revoked, conns := laptop.RevokeSessionSync(phoneConn.ID, ""); revoked != 1 || conns != 1 {
+		t.Fatalf("expected 1 revoked token and 1 closed connection, got: %v, %v", revoked, conns)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("server did not close the connection of the revoked device")
+	}
+	if rt, ok := sh.db.GetRefreshToken(hash); !ok || !rt.Revoked {
+		t.Fatalf("expected refresh token of the phone to be revoked, got: %+v", rt)
+	}
+
+	// revoking the current device leaves the connection open
+	if revoked, conns := laptop.RevokeSessionSync("", "laptop"); revoked != 0 || conns != 0 {
+		t.Fatalf("expected no revoked tokens or closed connections, got: %v, %v", revoked, conns)
+	}
+	if conns := laptop.ListSessionsSync(); len(conns) != 1 || !conns[0].Current {
+		t.Fatalf("expected only the current session to be left, got: %+v", conns)
+	}
+	if conns := other.ListSessionsSync(); len(conns) != 1 || conns[0].UserID != data.SeedUser1.ID {
+		t.Fatalf("expected only the sessions of the calling user to be listed, got: %+v", conns)
+	}
+}
@@ -0,0 +1,61 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data"
+)
+
+func TestTopics(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	admin := data.SeedUser1
+	admin.JWTToken = signToken(t, titan.Conf.App.JWTPass(), map[string]interface{}{"userid": admin.ID, "role": "admin"})
+	ch1 := sh.GetClientHelper().AsUser(&admin).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	tp := ch1.CreateTopicSync("news", "daily news")
+	if tp.Name != "news" || tp.Description != "daily news" || tp.Created.IsZero() {
+		t.Fatalf("unexpected topic: %+v", tp)
+	}
+
+	ch2.SubscribeTopicSync("news")
+	if ts := ch2.ListTopicsSync(); len(ts) != 1 || ts[0].Name != "news" || ts[0].Description != "daily news" {
+		t.Fatalf("unexpected topic list: %+v", ts)
+	}
+
+	// admins can publish without subscribing
+	ch1.PublishTopicSync("news", "breaking")
+	m := ch2.GetMessagesWait()
+	if len(m) != 1 || m[0].From != "1" || m[0].Topic != "news" || m[0].Message != "breaking" || m[0].ID == "" {
+		t.Fatalf("unexpected topic message: %+v", m)
+	}
+
+	// user 2 should not receive topic messages after unsubscribing
+	ch2.UnsubscribeTopicSync("news")
+	if ts := ch2.ListTopicsSync(); len(ts) != 0 {
+		t.Fatalf("expected no subscriptions, got: %+v", ts)
+	}
+	ch1.PublishTopicSync("news", "anyone?")
+	select {
+	case m := <-ch2.inMsgsChan:
+		t.Fatalf("unsubscribed user received a topic message: %+v", m)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	// users cannot publish to the topics they are not subscribed to
+	gotRes := make(chan bool, 1)
+	if err := ch2.Client.PublishTopic("news", "hi", func(ack string) error { gotRes <- true; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-gotRes:
+		t.Fatal("expected an error response")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
@@ -0,0 +1,114 @@
+package test
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/client"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/neptulon"
+)
+
+// totpCode computes the TOTP code of a base32 encoded secret for the given time, as an authenticator app would.
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(at.Unix()/30))
+	m := hmac.New(sha1.New, key)
+	m.Write(msg[:])
+	sum := m.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestTOTP(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	request := func(ch *ClientHelper, method string, params interface{}) *neptulon.ResCtx {
+		res := make(chan *neptulon.ResCtx)
+		if err := ch.Client.SendRequest(method, params, func(ctx *neptulon.ResCtx) error {
+			res <- ctx
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case ctx := <-res:
+			return ctx
+		case <-time.After(time.Second * 3):
+			t.Fatalf("did not get a %v response in time", method)
+			return nil
+		}
+	}
+	jwtAuth := func(ch *ClientHelper) string {
+		ctx := request(ch, "auth.jwt", map[string]string{"token": ch.User.JWTToken})
+		var ack string
+		ctx.Result(&ack)
+		return ack
+	}
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+
+	e := ch1.EnrollTOTPSync()
+	if e.Secret == "" || len(e.RecoveryCodes) != 10 {
+		t.Fatalf("unexpected enrollment: %+v", e)
+	}
+	if ctx := request(ch1, "totp.confirm", map[string]string{"code": "000000"}); ctx.Success && totpCode(t, e.Secret, time.Now()) != "000000" {
+		t.Fatal("expected wrong code to be rejected")
+	}
+	ch1.ConfirmTOTPSync(totpCode(t, e.Secret, time.Now()))
+
+	// new sessions are not privileged until a valid code is given
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect()
+	defer ch2.CloseWait()
+	if ack := jwtAuth(ch2); ack != client.TOTPRequired {
+		t.Fatalf("expected second factor to be required, got: %v", ack)
+	}
+	if ctx := request(ch2, "echo", map[string]string{"message": "hi"}); ctx.Success {
+		t.Fatal("expected request of an unprivileged session to be rejected")
+	}
+	if ctx := request(ch2, "auth.totp", map[string]string{"code": "abcde-fghij"}); ctx.Success {
+		t.Fatal("expected invalid code to be rejected")
+	}
+	token := ch2.TOTPAuthSync(e.RecoveryCodes[0])
+	ch2.EchoSync("hi")
+
+	// endpoints outside the client connections only accept the tokens carrying the second factor
+	if code, _ := attachmentRequest(t, "GET", "/attachments/none", data.SeedUser1.JWTToken, ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected token without the second factor to be rejected, got: %v", code)
+	}
+	if code, _ := attachmentRequest(t, "GET", "/attachments/none", token, ""); code != http.StatusNotFound {
+		t.Fatalf("expected token with the second factor to be accepted, got: %v", code)
+	}
+
+	// recovery codes can only be used once, and codes of the used periods are rejected
+	ch3 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect()
+	defer ch3.CloseWait()
+	jwtAuth(ch3)
+	if ctx := request(ch3, "auth.totp", map[string]string{"code": e.RecoveryCodes[0]}); ctx.Success {
+		t.Fatal("expected used recovery code to be rejected")
+	}
+	if ctx := request(ch3, "auth.totp", map[string]string{"code": totpCode(t, e.Secret, time.Now().Add(-30*time.Second))}); ctx.Success {
+		t.Fatal("expected code of a used period to be rejected")
+	}
+	ch3.TOTPAuthSync(totpCode(t, e.Secret, time.Now().Add(30*time.Second)))
+
+	// users without a second factor are not affected
+	ch4 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch4.CloseWait()
+
+	ch2.DisableTOTPSync(e.RecoveryCodes[1])
+	ch5 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch5.CloseWait()
+}
@@ -0,0 +1,67 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/trace"
+)
+
+func TestTracing(t *testing.T) {
+	rec := trace.NewRecorder()
+	trace.SetTracer(rec)
+	defer trace.SetTracer(nil)
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	ch1.SendMessagesSync([]models.Message{models.Message{To: "2", Message: "traced"}})
+	ch2.GetMessagesWait()
+
+	// message is traced from the sender's request through the database and the queue to its delivery to the recipient
+	want := []string{"db.IsBlocked", "db.SaveMessage", "db.IndexMessage", "queue.enqueue", "queue.send"}
+	var send trace.RecordedSpan
+	var spans map[string]trace.RecordedSpan
+	for deadline := time.Now().Add(time.Second * 3); ; {
+		spans = make(map[string]trace.RecordedSpan)
+		for _, s := range rec.Spans() {
+			if s.Name == "msg.send" {
+				send = s
+			}
+			if s.Attrs["method"] == "msg.recv" || s.Name == "msg.send" || strings.HasPrefix(s.Name, "db.") {
+				spans[s.Name] = s
+			}
+		}
+		if len(spans) == len(want)+1 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if !send.Context.IsValid() || send.Attrs["user"] != "1" || send.Err != nil {
+		t.Fatalf("expected a span for the msg.send request, got: %+v", send)
+	}
+	for _, name := range want {
+		s, ok := spans[name]
+		if !ok {
+			t.Fatalf("expected a %v span, got: %+v", name, rec.Spans())
+		}
+		if s.Context.TraceID != send.Context.TraceID {
+			t.Fatalf("expected %v span to be in the trace of the msg.send request, got: %+v", name, s)
+		}
+		if s.Err != nil {
+			t.Fatalf("expected %v span to succeed, got: %v", name, s.Err)
+		}
+	}
+	if spans["queue.send"].Parent != spans["queue.enqueue"].Context {
+		t.Fatalf("expected queue.send span to be the child of queue.enqueue span, got: %+v", spans["queue.send"])
+	}
+}
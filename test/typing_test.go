@@ -0,0 +1,42 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+func TestTyping(t *testing.T) {
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch1 := sh.GetClientHelper().AsUser(&data.SeedUser1).Connect().JWTAuthSync()
+	defer ch1.CloseWait()
+	ch2 := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch2.CloseWait()
+
+	// started notification is relayed to the other participant
+	conv := models.DirectConversation(data.SeedUser1.ID, data.SeedUser2.ID)
+	ch1.TypingSync(conv, true)
+	if n := ch2.GetTypingWait(); n.Conversation != conv || n.UserID != data.SeedUser1.ID || !n.Typing {
+		t.Fatalf("expected user 1 to be typing, got: %+v", n)
+	}
+
+	// repeated started notification is throttled while the stopped one is relayed
+	ch1.TypingSync(conv, true)
+	ch1.TypingSync(conv, false)
+	if n := ch2.GetTypingWait(); n.UserID != data.SeedUser1.ID || n.Typing {
+		t.Fatalf("expected user 1 to stop typing, got: %+v", n)
+	}
+
+	// stopped notification without a started one is not relayed, and the typing user is never notified
+	ch1.TypingSync(conv, false)
+	ch2.TypingSync(conv, true)
+	if n := ch1.GetTypingWait(); n.UserID != data.SeedUser2.ID || !n.Typing {
+		t.Fatalf("expected user 2 to be typing, got: %+v", n)
+	}
+	if len(ch1.typing) != 0 || len(ch2.typing) != 0 {
+		t.Fatalf("expected no more typing notifications, got: %v and %v", len(ch1.typing), len(ch2.typing))
+	}
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/titan-x/titan"
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+func TestWebhook(t *testing.T) {
+	key := strings.Repeat("k", 32)
+	titan.InitConf("test")
+	defer titan.InitConf("test")
+	titan.Conf.App.WebhookKeys = "1:" + key // seed users are only initialized along with the database
+
+	sh := NewServerHelper(t).ListenAndServe()
+	defer sh.CloseWait()
+
+	ch := sh.GetClientHelper().AsUser(&data.SeedUser2).Connect().JWTAuthSync()
+	defer ch.CloseWait()
+
+	url := "http://127.0.0.1:" + titan.Conf.App.Port + "/api/messages"
+	do := func(method, url, key, body string) (*http.Response, webhookRes) {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+key)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r webhookRes
+		if res.Header.Get("Content-Type") == "application/json" {
+			if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res, r
+	}
+
+	// requests with an invalid key are rejected
+	if res, _ := do("POST", url, strings.Repeat("x", 32), `{"to": "2", "message": "hi"}`); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got: %v", res.Status)
+	}
+
+	res, r := do("POST", url, key, `{"to": "2", "message": "your order has shipped", "clientId": "order-1"}`)
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got: %v", res.Status)
+	}
+	if r.ID == "" || r.From != data.SeedUser1.ID || r.State != models.StateSent || r.StateURL != "/api/messages/"+r.ID {
+		t.Fatalf("unexpected response: %+v", r)
+	}
+	msgs := ch.GetMessagesWait()
+	if len(msgs) != 1 || msgs[0].ID != r.ID || msgs[0].From != data.SeedUser1.ID || msgs[0].Message != "your order has shipped" {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+
+	// retries with the same client ID are not sent again
+	if _, r2 := do("POST", url, key, `{"to": "2", "message": "your order has shipped", "clientId": "order-1"}`); r2.ID != r.ID {
+		t.Fatalf("expected the retry to return the receipt of message %v, got: %+v", r.ID, r2)
+	}
+
+	res, s := do("GET", "http://127.0.0.1:"+titan.Conf.App.Port+r.StateURL, key, "")
+	if res.StatusCode != http.StatusOK || s.ID != r.ID || s.State == "" {
+		t.Fatalf("unexpected delivery state: %v, %+v", res.Status, s)
+	}
+	if res, _ := do("GET", url+"/unknown", key, ""); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404, got: %v", res.Status)
+	}
+	if res, _ := do("POST", url, key, `{"to": "2"}`); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got: %v", res.Status)
+	}
+}
+
+type webhookRes struct {
+	models.Receipt
+	StateURL string `json:"stateUrl"`
+}
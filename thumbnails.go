@@ -0,0 +1,193 @@
+package titan
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	// decoders of the supported image formats
+	_ "image/gif"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+// thumbnailMaxPixels is the maximum number of pixels of the images to generate thumbnails for,
+// so maliciously crafted images cannot exhaust the server memory while decoding.
+const thumbnailMaxPixels = 50 << 20
+
+// thumbnailQuality is the quality of the JPEG encoded thumbnails.
+const thumbnailQuality = 80
+
+// thumbnailBlobID returns the ID of the blob to store the thumbnail of an attachment with the given size in.
+func thumbnailBlobID(id string, size int) string {
+	return id + ".thumb" + strconv.Itoa(size)
+}
+
+// generateThumbnails generates the thumbnails of an uploaded image attachment with the configured sizes, and saves them
+// along with the attachment. Errors are only logged as it is meant to run in the background after the upload,
+// and the attachment is still usable without the thumbnails.
+func generateThumbnails(db data.DB, bs data.BlobStore, id string) {
+	sizes := Conf.App.ThumbnailSizeList()
+	a, ok, err := db.GetAttachment(id)
+	if err != nil {
+		attachmentLog.Errorf("failed to get attachment %v to generate thumbnails: %v", id, err)
+		return
+	}
+	if !ok || len(sizes) == 0 || !strings.HasPrefix(a.MimeType, "image/") {
+		return
+	}
+
+	img, format, err := decodeImage(bs, id)
+	if err != nil {
+		attachmentLog.Warnf("failed to decode image attachment %v: %v", id, err)
+		return
+	}
+
+	var ts []models.Thumbnail
+	for _, size := range sizes {
+		t, b, err := encodeThumbnail(img, format, size)
+		if err != nil {
+			attachmentLog.Errorf("failed to encode thumbnail of attachment %v: %v", id, err)
+			continue
+		}
+		if err := bs.PutBlob(thumbnailBlobID(id, size), bytes.NewReader(b)); err != nil {
+			attachmentLog.Errorf("failed to store thumbnail of attachment %v: %v", id, err)
+			continue
+		}
+		ts = append(ts, t)
+	}
+	if len(ts) == 0 {
+		return
+	}
+
+	attachmentMutex.Lock()
+	defer attachmentMutex.Unlock()
+
+	// attachment might be updated or deleted meanwhile
+	a, ok, err = db.GetAttachment(id)
+	if err != nil {
+		attachmentLog.Errorf("failed to get attachment %v to save thumbnails: %v", id, err)
+		return
+	}
+	if !ok {
+		if err := deleteThumbnails(bs, id, ts); err != nil {
+			attachmentLog.Errorf("failed to delete thumbnails of deleted attachment %v: %v", id, err)
+		}
+		return
+	}
+	a.Thumbnails = ts
+	if err := db.SaveAttachment(a); err != nil {
+		attachmentLog.Errorf("failed to save thumbnails of attachment %v: %v", id, err)
+		return
+	}
+
+	attachmentLog.Debugf("generated %v thumbnails for attachment %v", len(ts), id)
+}
+
+// deleteThumbnails deletes the stored thumbnails of an attachment.
+func deleteThumbnails(bs data.BlobStore, id string, ts []models.Thumbnail) error {
+	for _, t := range ts {
+		if err := bs.DeleteBlob(thumbnailBlobID(id, t.Size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeImage reads and decodes the content of an image attachment, unless it is too large.
+func decodeImage(bs data.BlobStore, id string) (image.Image, string, error) {
+	rc, err := bs.GetBlob(id)
+	if err != nil {
+		return nil, "", err
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	conf, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+	if conf.Width <= 0 || conf.Height <= 0 || conf.Width*conf.Height > thumbnailMaxPixels {
+		return nil, "", fmt.Errorf("unsupported image dimensions: %vx%v", conf.Width, conf.Height)
+	}
+	return image.Decode(bytes.NewReader(b))
+}
+
+// encodeThumbnail downscales an image to fit in a square of the given size, keeping its aspect ratio, and encodes it.
+// PNG images keep their format to preserve the transparency, and the rest are encoded as JPEG.
+func encodeThumbnail(img image.Image, format string, size int) (models.Thumbnail, []byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, h*size/w
+		} else {
+			w, h = w*size/h, size
+		}
+		if w == 0 {
+			w = 1
+		}
+		if h == 0 {
+			h = 1
+		}
+	}
+	dst := scaleImage(img, w, h)
+
+	var buf bytes.Buffer
+	t := models.Thumbnail{Size: size, Width: w, Height: h}
+	if format == "png" {
+		t.MimeType = "image/png"
+		if err := png.Encode(&buf, dst); err != nil {
+			return t, nil, err
+		}
+		return t, buf.Bytes(), nil
+	}
+
+	// JPEG has no transparency, so transparent pixels (i.e. of GIF images) are rendered over white
+	opaque := image.NewRGBA(dst.Bounds())
+	draw.Draw(opaque, opaque.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(opaque, opaque.Bounds(), dst, image.Point{}, draw.Over)
+	t.MimeType = "image/jpeg"
+	if err := jpeg.Encode(&buf, opaque, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return t, nil, err
+	}
+	return t, buf.Bytes(), nil
+}
+
+// scaleImage resizes an image to the given dimensions, averaging the source pixels covered by each destination pixel
+// (box filter), which gives smooth results for downscaling. Dimensions should not be larger than the source image.
+func scaleImage(img image.Image, w, h int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, (y+1)*sh/h
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, (x+1)*sw/w
+
+			// colors are alpha-premultiplied, so transparent pixels do not bleed into the average
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(bl / n >> 8), A: uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
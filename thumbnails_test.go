@@ -0,0 +1,53 @@
+package titan
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestEncodeThumbnail(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 150))
+	for x := 0; x < 150; x++ {
+		for y := 0; y < 150; y++ {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+
+	// images are downscaled to fit in the thumbnail size, keeping the aspect ratio and the format of PNG images
+	th, b, err := encodeThumbnail(img, "png", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if th.Size != 100 || th.Width != 100 || th.Height != 50 || th.MimeType != "image/png" {
+		t.Fatalf("unexpected thumbnail: %+v", th)
+	}
+	dec, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.Bounds().Dx() != 100 || dec.Bounds().Dy() != 50 {
+		t.Fatalf("unexpected thumbnail dimensions: %v", dec.Bounds())
+	}
+	if r, _, _, a := dec.At(10, 10).RGBA(); r>>8 != 255 || a>>8 != 255 {
+		t.Fatalf("unexpected opaque pixel: %v", dec.At(10, 10))
+	}
+	if _, _, _, a := dec.At(90, 10).RGBA(); a != 0 {
+		t.Fatalf("unexpected transparent pixel: %v", dec.At(90, 10))
+	}
+
+	// other formats are encoded as JPEG, and small images are not upscaled
+	th, b, err = encodeThumbnail(img, "gif", 640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if th.Width != 300 || th.Height != 150 || th.MimeType != "image/jpeg" {
+		t.Fatalf("unexpected thumbnail: %+v", th)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,244 @@
+package titan
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/titan-x/titan/acme"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/neptulon"
+)
+
+var tlsLog = log.Component("tls")
+
+// clientCA is the CA for verifying client certificates and, if the private key is given, for issuing them to the devices.
+type clientCA struct {
+	cert []byte // PEM encoded CA certificate, or nil if client certificates are not accepted.
+	key  []byte // PEM encoded CA private key, or nil if client certificates are not issued.
+}
+
+// readClientCA reads the PEM encoded CA certificate and private key files at the given paths. Both are optional.
+func readClientCA(certFile, keyFile string) (*clientCA, error) {
+	var ca clientCA
+	var err error
+	if certFile != "" {
+		if ca.cert, err = ioutil.ReadFile(certFile); err != nil {
+			return nil, fmt.Errorf("server: failed to read tls ca certificate: %v", err)
+		}
+	}
+	if keyFile != "" {
+		if ca.key, err = ioutil.ReadFile(keyFile); err != nil {
+			return nil, fmt.Errorf("server: failed to read tls ca private key: %v", err)
+		}
+	}
+	return &ca, nil
+}
+
+var (
+	tlsVersions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+	tlsCurveIDs = map[string]tls.CurveID{"X25519": tls.X25519, "P256": tls.CurveP256, "P384": tls.CurveP384, "P521": tls.CurveP521}
+)
+
+// tlsPolicy retrieves the TLS versions, cipher suites, and key exchange curves the clients can negotiate from the configuration.
+// Only the cipher suites without known security issues are accepted.
+func tlsPolicy(app *App) (neptulon.TLSPolicy, error) {
+	var p neptulon.TLSPolicy
+	if app.TLSMinVersion != "" {
+		v, ok := tlsVersions[app.TLSMinVersion]
+		if !ok {
+			return p, fmt.Errorf("invalid tls min version: %v", app.TLSMinVersion)
+		}
+		p.MinVersion = v
+	}
+
+	suites := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		suites[cs.Name] = cs.ID
+	}
+	for _, name := range splitList(app.TLSCipherSuites) {
+		id, ok := suites[name]
+		if !ok {
+			return p, fmt.Errorf("invalid or insecure tls cipher suite: %v", name)
+		}
+		p.CipherSuites = append(p.CipherSuites, id)
+	}
+
+	for _, name := range splitList(app.TLSCurves) {
+		id, ok := tlsCurveIDs[name]
+		if !ok {
+			return p, fmt.Errorf("invalid tls curve: %v", name)
+		}
+		p.CurvePreferences = append(p.CurvePreferences, id)
+	}
+	return p, nil
+}
+
+// splitList splits a comma separated list, skipping the empty entries.
+func splitList(list string) []string {
+	var l []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			l = append(l, s)
+		}
+	}
+	return l
+}
+
+// useTLS enables TLS for the connections using the PEM encoded certificate files at the given paths.
+// Client certificates are verified with the client CA, if any.
+func (s *Server) useTLS(certFile, keyFile string) error {
+	cert, key, err := readCertFiles(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	if err := s.neptulon.UseTLS(cert, key, s.clientCA.cert); err != nil {
+		return fmt.Errorf("server: failed to enable tls: %v", err)
+	}
+
+	s.tlsCertFile, s.tlsKeyFile = certFile, keyFile
+	return nil
+}
+
+// useSNICerts presents the certificates in the given files to the clients requesting their hostnames with SNI,
+// in place of the server certificate.
+func (s *Server) useSNICerts(certFiles, keyFiles []string) error {
+	if err := s.loadSNICerts(certFiles, keyFiles); err != nil {
+		return err
+	}
+	s.sniCertFiles, s.sniKeyFiles = certFiles, keyFiles
+	return nil
+}
+
+func (s *Server) loadSNICerts(certFiles, keyFiles []string) error {
+	pairs := make([]neptulon.CertPair, len(certFiles))
+	for i := range certFiles {
+		cert, key, err := readCertFiles(certFiles[i], keyFiles[i])
+		if err != nil {
+			return err
+		}
+		pairs[i] = neptulon.CertPair{Cert: cert, Key: key}
+	}
+
+	if err := s.neptulon.SetSNICertificates(pairs...); err != nil {
+		return fmt.Errorf("server: failed to use tls sni certificates: %v", err)
+	}
+	return nil
+}
+
+// ReloadTLS reloads the server certificate and private key from the files given in the configuration (i.e. after renewal).
+// SNI certificates are reloaded as well. New certificates are used for the new connections while the existing connections are not dropped.
+// This is also triggered by SIGHUP signal while the server is listening.
+func (s *Server) ReloadTLS() error {
+	if s.tlsCertFile == "" {
+		return fmt.Errorf("server: tls is not enabled")
+	}
+
+	cert, key, err := readCertFiles(s.tlsCertFile, s.tlsKeyFile)
+	if err != nil {
+		return err
+	}
+
+	if err := s.neptulon.SetCertificate(cert, key); err != nil {
+		return fmt.Errorf("server: failed to reload tls certificate: %v", err)
+	}
+	if err := s.loadSNICerts(s.sniCertFiles, s.sniKeyFiles); err != nil {
+		return err
+	}
+
+	tlsLog.Infof("reloaded certificate: %v", s.tlsCertFile)
+	return nil
+}
+
+// watchTLSReload reloads the certificate upon each SIGHUP signal until the server is closed.
+// Failed reloads are logged and the previous certificate stays in use.
+func (s *Server) watchTLSReload() {
+	s.tlsReload = make(chan os.Signal, 1)
+	signal.Notify(s.tlsReload, syscall.SIGHUP)
+
+	go func(c chan os.Signal) {
+		for range c {
+			if err := s.ReloadTLS(); err != nil {
+				tlsLog.Errorf("failed to reload certificate: %v", err)
+			}
+		}
+	}(s.tlsReload)
+}
+
+// acmeRenewInterval is the interval to check the ACME certificate for renewal with.
+var acmeRenewInterval = time.Hour * 12
+
+// UseACME enables TLS for the connections with the certificates obtained and renewed by the given ACME certificate manager,
+// in place of certificate files. HTTP-01 challenges are served at the given address, which must be reachable at port 80 of the domains.
+// Certificate is obtained when the server starts listening.
+func (s *Server) UseACME(m *acme.Manager, httpAddr string) {
+	s.acme, s.acmeHTTPAddr = m, httpAddr
+}
+
+// listenACME starts serving the ACME challenges, obtains the certificate (or loads it from the cache), enables TLS with it,
+// and starts checking the certificate for renewal periodically until the server is closed.
+func (s *Server) listenACME() error {
+	l, err := net.Listen("tcp", s.acmeHTTPAddr)
+	if err != nil {
+		return fmt.Errorf("server: failed to listen for acme challenges at %v: %v", s.acmeHTTPAddr, err)
+	}
+	s.acmeListener = l
+	go http.Serve(l, s.acme.HTTPHandler(nil))
+
+	cert, key, err := s.acme.Cert()
+	if err != nil {
+		return fmt.Errorf("server: failed to obtain acme certificate: %v", err)
+	}
+
+	if err := s.neptulon.UseTLS(cert, key, s.clientCA.cert); err != nil {
+		return fmt.Errorf("server: failed to enable tls: %v", err)
+	}
+
+	s.acmeDone = make(chan struct{})
+	go func(done chan struct{}) {
+		t := time.NewTicker(acmeRenewInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				newCert, newKey, err := s.acme.Cert()
+				if err != nil {
+					tlsLog.Errorf("failed to renew acme certificate: %v", err)
+					continue
+				}
+				if string(newCert) == string(cert) {
+					continue
+				}
+				if err := s.neptulon.SetCertificate(newCert, newKey); err != nil {
+					tlsLog.Errorf("failed to use renewed acme certificate: %v", err)
+					continue
+				}
+				cert = newCert
+				tlsLog.Infof("renewed acme certificate for: %v", s.acme.Domains)
+			}
+		}
+	}(s.acmeDone)
+
+	return nil
+}
+
+func readCertFiles(certFile, keyFile string) (cert, key []byte, err error) {
+	if cert, err = ioutil.ReadFile(certFile); err != nil {
+		return nil, nil, fmt.Errorf("server: failed to read tls certificate: %v", err)
+	}
+	if key, err = ioutil.ReadFile(keyFile); err != nil {
+		return nil, nil, fmt.Errorf("server: failed to read tls private key: %v", err)
+	}
+	return cert, key, nil
+}
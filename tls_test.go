@@ -0,0 +1,233 @@
+package titan
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/neptulon"
+)
+
+func writeTestCert(t *testing.T, dir, cn string) (certFile, keyFile string) {
+	cert, key, err := GenCert(CertOptions{CommonName: cn, Hosts: []string{"localhost"}, ValidFor: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// peerCertCN connects to the server and returns the common name of the presented certificate.
+func peerCertCN(t *testing.T, addr string) string {
+	var err error
+	for i := 0; i < 100; i++ {
+		var c *tls.Conn
+		if c, err = tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true}); err == nil {
+			defer c.Close()
+			return c.ConnectionState().PeerCertificates[0].Subject.CommonName
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("failed to connect to server: %v", err)
+	return ""
+}
+
+func TestReloadTLS(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short testing mode")
+	}
+
+	dir, err := ioutil.TempDir("", "titan-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	InitConf("test")
+	defer InitConf("test")
+	Conf.App.TLSCert, Conf.App.TLSKey = writeTestCert(t, dir, "first")
+
+	const addr = "127.0.0.1:3098"
+	s, err := NewServer(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ListenAndServe()
+	defer s.Close()
+
+	if cn := peerCertCN(t, addr); cn != "first" {
+		t.Fatalf("expected the initial certificate, got: %v", cn)
+	}
+
+	// renewed certificate is picked up upon SIGHUP
+	writeTestCert(t, dir, "second")
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; peerCertCN(t, addr) != "second"; i++ {
+		if i > 100 {
+			t.Fatal("renewed certificate was not picked up")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// invalid certificate files are rejected and the current certificate stays in use
+	ioutil.WriteFile(filepath.Join(dir, "cert.pem"), []byte("invalid"), 0600)
+	if err := s.ReloadTLS(); err == nil {
+		t.Fatal("expected invalid certificate to be rejected")
+	}
+	if cn := peerCertCN(t, addr); cn != "second" {
+		t.Fatalf("expected the renewed certificate to stay in use, got: %v", cn)
+	}
+}
+
+func TestTLSPolicy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short testing mode")
+	}
+
+	dir, err := ioutil.TempDir("", "titan-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	InitConf("test")
+	defer InitConf("test")
+	Conf.App.TLSCert, Conf.App.TLSKey = writeTestCert(t, dir, "policy")
+	Conf.App.TLSCipherSuites, Conf.App.TLSCurves = "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", "P384"
+
+	const addr = "127.0.0.1:3099"
+	s, err := NewServer(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ListenAndServe()
+	defer s.Close()
+	peerCertCN(t, addr)
+
+	dial := func(addr string, c *tls.Config) (tls.ConnectionState, error) {
+		c.InsecureSkipVerify = true
+		conn, err := tls.Dial("tcp", addr, c)
+		if err != nil {
+			return tls.ConnectionState{}, err
+		}
+		defer conn.Close()
+		return conn.ConnectionState(), nil
+	}
+
+	// only the allowed cipher suites and curves are negotiated with tls 1.2
+	st, err := dial(addr, &tls.Config{MaxVersion: tls.VersionTLS12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.CipherSuite != tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384 {
+		t.Fatalf("expected the allowed cipher suite, got: %v", tls.CipherSuiteName(st.CipherSuite))
+	}
+	if _, err := dial(addr, &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}}); err == nil {
+		t.Fatal("expected other cipher suites to be rejected")
+	}
+	if _, err := dial(addr, &tls.Config{CurvePreferences: []tls.CurveID{tls.X25519}}); err == nil {
+		t.Fatal("expected other curves to be rejected")
+	}
+	if _, err := dial(addr, &tls.Config{MaxVersion: tls.VersionTLS11}); err == nil {
+		t.Fatal("expected tls versions older than 1.2 to be rejected")
+	}
+
+	// policy set on the server overrides the configuration, rejecting the clients older than the minimum version
+	const addr13 = "127.0.0.1:3100"
+	s13, err := NewServer(addr13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s13.SetTLSPolicy(neptulon.TLSPolicy{MinVersion: tls.VersionTLS13})
+	go s13.ListenAndServe()
+	defer s13.Close()
+	peerCertCN(t, addr13)
+
+	if _, err := dial(addr13, &tls.Config{MaxVersion: tls.VersionTLS12}); err == nil {
+		t.Fatal("expected tls 1.2 to be rejected")
+	}
+	if st, err := dial(addr13, &tls.Config{CurvePreferences: []tls.CurveID{tls.X25519}}); err != nil || st.Version != tls.VersionTLS13 {
+		t.Fatalf("expected tls 1.3 connection, got: %x, %v", st.Version, err)
+	}
+}
+
+func TestSNICerts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short testing mode")
+	}
+
+	dir, err := ioutil.TempDir("", "titan-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	InitConf("test")
+	defer InitConf("test")
+	Conf.App.TLSCert, Conf.App.TLSKey = writeTestCert(t, dir, "default")
+	var sni []string
+	for _, host := range []string{"api.titan.test", "*.ws.titan.test"} {
+		cert, key, err := GenCert(CertOptions{CommonName: host, Hosts: []string{host}, ValidFor: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		certFile, keyFile := filepath.Join(dir, host+".pem"), filepath.Join(dir, host+"-key.pem")
+		ioutil.WriteFile(certFile, cert, 0600)
+		ioutil.WriteFile(keyFile, key, 0600)
+		sni = append(sni, certFile+":"+keyFile)
+	}
+	Conf.App.TLSSNICerts = strings.Join(sni, ",")
+
+	const addr = "127.0.0.1:3101"
+	s, err := NewServer(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ListenAndServe()
+	defer s.Close()
+	peerCertCN(t, addr)
+
+	// certificate is chosen by the hostname requested with sni, falling back to the default certificate
+	for host, cn := range map[string]string{
+		"api.titan.test":     "api.titan.test",
+		"API.titan.test":     "api.titan.test",
+		"eu.ws.titan.test":   "*.ws.titan.test",
+		"ws.titan.test":      "default",
+		"other.titan.test":   "default",
+		"":                   "default",
+		"a.eu.ws.titan.test": "default",
+	} {
+		c, err := tls.Dial("tcp", addr, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.ConnectionState().PeerCertificates[0].Subject.CommonName; got != cn {
+			t.Fatalf("expected certificate %v for hostname %q, got: %v", cn, host, got)
+		}
+		c.Close()
+	}
+
+	// sni certificates are reloaded along with the default certificate
+	if err := s.ReloadTLS(); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "api.titan.test.pem"))
+	if err := s.ReloadTLS(); err == nil {
+		t.Fatal("expected missing sni certificate to fail the reload")
+	}
+}
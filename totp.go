@@ -0,0 +1,241 @@
+package titan
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/models"
+)
+
+const (
+	totpIssuer          = "Titan"          // issuer shown by the authenticator apps
+	totpPeriod          = 30               // seconds each code is valid for
+	totpDigits          = 6                // number of digits of the codes
+	totpSkew            = 1                // number of periods before and after the current one to accept the codes of, for clock drift
+	totpRecoveryCodes   = 10               // number of recovery codes issued upon enrollment
+	totpMaxFailures     = 10               // number of wrong codes of a user within totpFailureWindow after which the codes are rejected
+	totpFailureWindow   = 15 * time.Minute // time window to count the wrong codes of a user within
+	totpSecretSize      = 20               // size of the shared secrets in bytes, as recommended by RFC 4226
+	totpRecoveryCodeLen = 10               // number of characters of the recovery codes, excluding the separator
+)
+
+var (
+	errTOTPEnabled   = errors.New("totp: two-factor authentication is already enabled")
+	errTOTPNotFound  = errors.New("totp: no two-factor authentication enrollment")
+	errTOTPInvalid   = errors.New("totp: invalid code")
+	errTOTPThrottled = errors.New("totp: too many wrong codes")
+
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// twoFactor manages the TOTP (RFC 6238) second factors of the users: the enrollments, and the checks of the codes.
+// Checks are serialized, so a code cannot be accepted twice by concurrent requests.
+type twoFactor struct {
+	db       *data.DB
+	mutex    sync.Mutex
+	failures map[string][]time.Time // user ID -> times of the recent wrong codes
+}
+
+func newTwoFactor(db *data.DB) *twoFactor {
+	return &twoFactor{db: db, failures: make(map[string][]time.Time)}
+}
+
+// required tells whether a user has a confirmed second factor, so the codes are required upon authentication.
+func (tf *twoFactor) required(userID string) (bool, error) {
+	t, ok, err := (*tf.db).GetTOTP(userID)
+	if err != nil {
+		return false, err
+	}
+	return ok && t.Confirmed, nil
+}
+
+// enroll generates a new shared secret and recovery codes for a user, replacing any unconfirmed enrollment.
+// Enrollment is to be confirmed with a valid code before the codes are required.
+func (tf *twoFactor) enroll(userID, account string) (*models.TOTPEnrollment, error) {
+	tf.mutex.Lock()
+	defer tf.mutex.Unlock()
+
+	if t, ok, err := (*tf.db).GetTOTP(userID); err != nil {
+		return nil, err
+	} else if ok && t.Confirmed {
+		return nil, errTOTPEnabled
+	}
+
+	key := make([]byte, totpSecretSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("totp: failed to generate secret: %v", err)
+	}
+	e := models.TOTPEnrollment{Secret: totpEncoding.EncodeToString(key)}
+	e.URI = totpURI(e.Secret, account)
+
+	t := models.TOTP{UserID: userID, Secret: e.Secret}
+	for i := 0; i < totpRecoveryCodes; i++ {
+		c, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		e.RecoveryCodes = append(e.RecoveryCodes, c)
+		t.RecoveryCodes = append(t.RecoveryCodes, hashRecoveryCode(c))
+	}
+
+	if err := (*tf.db).SaveTOTP(&t); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// confirm confirms the pending enrollment of a user with a TOTP code, after which the codes are required upon authentication.
+func (tf *twoFactor) confirm(userID, code string) error {
+	return tf.check(userID, code, false, func(t *models.TOTP, recovery bool) error {
+		if t.Confirmed {
+			return errTOTPEnabled
+		}
+		t.Confirmed = true
+		return (*tf.db).SaveTOTP(t)
+	})
+}
+
+// verify verifies a TOTP or a recovery code of a user with a confirmed second factor. Recovery codes are used up.
+func (tf *twoFactor) verify(userID, code string) (recovery bool, err error) {
+	err = tf.check(userID, code, true, func(t *models.TOTP, r bool) error {
+		recovery = r
+		return (*tf.db).SaveTOTP(t)
+	})
+	return
+}
+
+// disable deletes the second factor of a user, given a valid TOTP or recovery code.
+func (tf *twoFactor) disable(userID, code string) error {
+	return tf.check(userID, code, true, func(t *models.TOTP, recovery bool) error {
+		return (*tf.db).DeleteTOTP(userID)
+	})
+}
+
+// check checks a code against the second factor of a user and calls the given function with the second factor updated
+// to reject the same code from then on, along with whether the code is a recovery code, if the code is valid. Recovery codes are only accepted if specified, and only
+// with the confirmed second factors. Users are throttled after totpMaxFailures wrong codes.
+func (tf *twoFactor) check(userID, code string, allowRecovery bool, accept func(t *models.TOTP, recovery bool) error) error {
+	tf.mutex.Lock()
+	defer tf.mutex.Unlock()
+
+	now := time.Now()
+	fs := tf.failures[userID]
+	for len(fs) != 0 && now.Sub(fs[0]) > totpFailureWindow {
+		fs = fs[1:]
+	}
+	if len(fs) == 0 {
+		delete(tf.failures, userID)
+	} else {
+		tf.failures[userID] = fs
+	}
+	if len(fs) >= totpMaxFailures {
+		return errTOTPThrottled
+	}
+
+	t, ok, err := (*tf.db).GetTOTP(userID)
+	if err != nil {
+		return err
+	}
+	if !ok || (allowRecovery && !t.Confirmed) {
+		return errTOTPNotFound
+	}
+
+	if checkTOTPCode(t, code, now) {
+		return accept(t, false)
+	}
+	if allowRecovery && useRecoveryCode(t, code) {
+		return accept(t, true)
+	}
+	tf.failures[userID] = append(fs, now)
+	return errTOTPInvalid
+}
+
+// checkTOTPCode checks a TOTP code against the codes of the periods around the given time, and updates the last used
+// period of the second factor if the code is valid. Codes of the periods up to the last used one are rejected.
+func checkTOTPCode(t *models.TOTP, code string, now time.Time) bool {
+	key, err := totpEncoding.DecodeString(t.Secret)
+	if err != nil || len(code) != totpDigits {
+		return false
+	}
+
+	step := now.Unix() / totpPeriod
+	for s := step - totpSkew; s <= step+totpSkew; s++ {
+		if s > t.LastStep && subtle.ConstantTimeCompare([]byte(totpCode(key, s, totpDigits)), []byte(code)) == 1 {
+			t.LastStep = s
+			return true
+		}
+	}
+	return false
+}
+
+// useRecoveryCode removes a recovery code from the unused recovery codes of a second factor, if found.
+func useRecoveryCode(t *models.TOTP, code string) bool {
+	h := hashRecoveryCode(code)
+	for i, c := range t.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(c), []byte(h)) == 1 {
+			t.RecoveryCodes = append(t.RecoveryCodes[:i:i], t.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// totpCode computes the HOTP (RFC 4226) code of a key for the given counter, which is the time step for TOTP.
+func totpCode(key []byte, counter int64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	m := hmac.New(sha1.New, key)
+	m.Write(msg[:])
+	sum := m.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, v%mod)
+}
+
+// totpURI formats the otpauth:// URI of a secret, which the authenticator apps import the secrets from, i.e. as a QR code.
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func totpURI(secret, account string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + totpIssuer + ":" + account, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// newRecoveryCode generates a random recovery code formatted as two groups of lowercase base32 characters, i.e. abcde-fghij.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, totpRecoveryCodeLen*5/8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("totp: failed to generate recovery code: %v", err)
+	}
+	c := strings.ToLower(totpEncoding.EncodeToString(b))
+	return c[:totpRecoveryCodeLen/2] + "-" + c[totpRecoveryCodeLen/2:], nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring the case and the separators.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	h := sha256.Sum256([]byte("totp-recovery:" + code))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,82 @@
+package titan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/data/inmem"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B test vectors for HMAC-SHA1
+	key := []byte("12345678901234567890")
+	for ts, code := range map[int64]string{59: "94287082", 1111111109: "07081804", 1111111111: "14050471", 1234567890: "89005924", 2000000000: "69279037"} {
+		if c := totpCode(key, ts/totpPeriod, 8); c != code {
+			t.Fatalf("expected code %v at %v, got: %v", code, ts, c)
+		}
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	var db data.DB = inmem.NewDB()
+	tf := newTwoFactor(&db)
+	e, err := tf.enroll("1", "user@titan.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.RecoveryCodes) != totpRecoveryCodes || e.URI == "" {
+		t.Fatalf("unexpected enrollment: %+v", e)
+	}
+	if tt, _, _ := db.GetTOTP("1"); tt.RecoveryCodes[0] == e.RecoveryCodes[0] {
+		t.Fatal("expected recovery codes to be stored hashed")
+	}
+	key, _ := totpEncoding.DecodeString(e.Secret)
+	code := func(d time.Duration) string { return totpCode(key, time.Now().Add(d).Unix()/totpPeriod, totpDigits) }
+
+	// codes are not required, nor recovery codes accepted, until the enrollment is confirmed
+	if ok, _ := tf.required("1"); ok {
+		t.Fatal("expected unconfirmed enrollment not to be required")
+	}
+	if _, err := tf.verify("1", e.RecoveryCodes[0]); err != errTOTPNotFound {
+		t.Fatalf("expected unconfirmed enrollment to be rejected, got: %v", err)
+	}
+	if err := tf.confirm("1", code(0)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := tf.required("1"); !ok {
+		t.Fatal("expected confirmed enrollment to be required")
+	}
+	if _, err := tf.enroll("1", "user@titan.test"); err != errTOTPEnabled {
+		t.Fatalf("expected enrollment to be rejected while enabled, got: %v", err)
+	}
+
+	// codes cannot be reused, while recovery codes are accepted in any format once
+	if _, err := tf.verify("1", code(0)); err != errTOTPInvalid {
+		t.Fatalf("expected reused code to be rejected, got: %v", err)
+	}
+	if r, err := tf.verify("1", code(totpPeriod*time.Second)); err != nil || r {
+		t.Fatalf("expected code of the next period to be accepted, got: %v", err)
+	}
+	if r, err := tf.verify("1", " "+e.RecoveryCodes[0][:5]+e.RecoveryCodes[0][6:]+" "); err != nil || !r {
+		t.Fatalf("expected recovery code to be accepted, got: %v", err)
+	}
+	if _, err := tf.verify("1", e.RecoveryCodes[0]); err != errTOTPInvalid {
+		t.Fatalf("expected used recovery code to be rejected, got: %v", err)
+	}
+
+	// users are throttled after too many wrong codes, including the two above
+	for i := 2; i < totpMaxFailures; i++ {
+		tf.verify("1", "000000x")
+	}
+	if _, err := tf.verify("1", e.RecoveryCodes[1]); err != errTOTPThrottled {
+		t.Fatalf("expected user to be throttled, got: %v", err)
+	}
+	tf.failures["1"][0] = time.Now().Add(-totpFailureWindow - time.Second)
+	if err := tf.disable("1", e.RecoveryCodes[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := db.GetTOTP("1"); ok {
+		t.Fatal("expected second factor to be deleted")
+	}
+}
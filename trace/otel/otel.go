@@ -0,0 +1,87 @@
+//go:build otel
+// +build otel
+
+// Package otel exports the spans of the trace package through OpenTelemetry.
+// It is not included in the default build and requires building with the otel tag (go build -tags otel).
+package otel
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/titan-x/titan/trace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation is the name of the OpenTelemetry tracer the spans are started with.
+const instrumentation = "github.com/titan-x/titan"
+
+// Tracer starts the spans with an OpenTelemetry tracer provider.
+type Tracer struct {
+	tracer oteltrace.Tracer
+}
+
+// NewTracer creates a tracer which starts the spans with the given OpenTelemetry tracer provider, to be used with trace.SetTracer.
+func NewTracer(tp oteltrace.TracerProvider) *Tracer {
+	return &Tracer{tracer: tp.Tracer(instrumentation)}
+}
+
+// Start starts an OpenTelemetry span with the given name as a child of the given parent span.
+func (t *Tracer) Start(parent trace.SpanContext, name string) trace.Span {
+	ctx := context.Background()
+	if parent.IsValid() {
+		var flags oteltrace.TraceFlags
+		if parent.Sampled {
+			flags = oteltrace.FlagsSampled
+		}
+		ctx = oteltrace.ContextWithRemoteSpanContext(ctx, oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+			TraceID:    parent.TraceID,
+			SpanID:     parent.SpanID,
+			TraceFlags: flags,
+			Remote:     true,
+		}))
+	}
+
+	_, s := t.tracer.Start(ctx, name)
+	return span{s}
+}
+
+type span struct {
+	s oteltrace.Span
+}
+
+func (s span) Context() trace.SpanContext {
+	sc := s.s.SpanContext()
+	return trace.SpanContext{TraceID: sc.TraceID(), SpanID: sc.SpanID(), Sampled: sc.IsSampled()}
+}
+
+func (s span) SetAttr(key string, value interface{}) {
+	var kv attribute.KeyValue
+	switch v := value.(type) {
+	case string:
+		kv = attribute.String(key, v)
+	case int:
+		kv = attribute.Int(key, v)
+	case int64:
+		kv = attribute.Int64(key, v)
+	case bool:
+		kv = attribute.Bool(key, v)
+	default:
+		kv = attribute.String(key, fmt.Sprint(v))
+	}
+	s.s.SetAttributes(kv)
+}
+
+func (s span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.s.RecordError(err)
+	s.s.SetStatus(codes.Error, err.Error())
+}
+
+func (s span) End() {
+	s.s.End()
+}
@@ -0,0 +1,89 @@
+package trace
+
+import (
+	"sync"
+	"time"
+)
+
+// RecordedSpan is a span recorded by a Recorder.
+type RecordedSpan struct {
+	Name    string
+	Context SpanContext
+	Parent  SpanContext
+	Attrs   map[string]interface{}
+	Err     error
+	Start   time.Time
+	End     time.Time
+}
+
+// Recorder is an in-memory tracer which keeps the ended spans, i.e. for testing the instrumentation.
+type Recorder struct {
+	mutex sync.Mutex
+	spans []RecordedSpan
+}
+
+// NewRecorder creates a new in-memory span recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Start starts a span which is recorded once it ends.
+func (r *Recorder) Start(parent SpanContext, name string) Span {
+	return &recordingSpan{r: r, s: RecordedSpan{Name: name, Context: NewSpanContext(parent), Parent: parent, Attrs: make(map[string]interface{}), Start: time.Now()}}
+}
+
+// Spans returns the ended spans, in the order they ended.
+func (r *Recorder) Spans() []RecordedSpan {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]RecordedSpan(nil), r.spans...)
+}
+
+// Reset discards the recorded spans.
+func (r *Recorder) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.spans = nil
+}
+
+type recordingSpan struct {
+	r     *Recorder
+	mutex sync.Mutex
+	s     RecordedSpan
+	ended bool
+}
+
+func (s *recordingSpan) Context() SpanContext {
+	return s.s.Context
+}
+
+func (s *recordingSpan) SetAttr(key string, value interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.s.Attrs[key] = value
+}
+
+func (s *recordingSpan) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.s.Err = err
+}
+
+func (s *recordingSpan) End() {
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.s.End = time.Now()
+	rs := s.s
+	s.mutex.Unlock()
+
+	s.r.mutex.Lock()
+	s.r.spans = append(s.r.spans, rs)
+	s.r.mutex.Unlock()
+}
@@ -0,0 +1,139 @@
+// Package trace provides spans for tracing a message through the server, from the request of the sender to the delivery to the
+// recipient or a push notification, with W3C Trace Context (traceparent) propagation across the queue and the server instances.
+//
+// Spans are discarded unless a tracer is set with SetTracer (i.e. the OpenTelemetry tracer in trace/otel, or a Recorder).
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// SpanContext identifies a span within a trace, which is propagated to the child spans, including the ones on other server instances.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid tells whether the span context has both a trace ID and a span ID, i.e. it is not the zero value.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// String formats the span context as a W3C traceparent header value, or returns an empty string if the span context is not valid.
+func (sc SpanContext) String() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// Parse parses a W3C traceparent header value (i.e. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01).
+func Parse(traceparent string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("trace: malformed traceparent: %q", traceparent)
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 || hexDecode(sc.TraceID[:], parts[1]) != nil || hexDecode(sc.SpanID[:], parts[2]) != nil {
+		return SpanContext{}, fmt.Errorf("trace: malformed traceparent: %q", traceparent)
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("trace: traceparent with zero trace or span ID: %q", traceparent)
+	}
+
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+func hexDecode(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return errors.New("invalid length or case")
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// NewSpanContext generates a new span context with random IDs, for the child of the given parent, or for a new sampled trace
+// if the parent is not valid. This is meant for the tracers which do not generate the IDs themselves.
+func NewSpanContext(parent SpanContext) SpanContext {
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		rand.Read(sc.TraceID[:])
+		sc.Sampled = true
+	}
+	rand.Read(sc.SpanID[:])
+	return sc
+}
+
+// Span is a timed operation within a trace. Spans should be ended exactly once, and are not to be used after that.
+type Span interface {
+	// Context returns the span context to start the child spans with.
+	Context() SpanContext
+
+	// SetAttr sets an attribute of the span, i.e. the user ID or the request method. Values are strings, integers, or booleans.
+	SetAttr(key string, value interface{})
+
+	// SetError marks the span as failed with the given error, if not nil.
+	SetError(err error)
+
+	// End completes the span.
+	End()
+}
+
+// Tracer starts the spans. Implementations should be safe for concurrent use.
+type Tracer interface {
+	// Start starts a span with the given name as a child of the given parent span, or as the root of a new trace if the parent is not valid.
+	Start(parent SpanContext, name string) Span
+}
+
+type tracerHolder struct {
+	Tracer
+}
+
+var tracer atomic.Value
+
+func init() {
+	tracer.Store(tracerHolder{nopTracer{}})
+}
+
+// SetTracer sets the tracer to start the spans with, or discards the spans if nil.
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = nopTracer{}
+	}
+	tracer.Store(tracerHolder{t})
+}
+
+// Start starts a span with the given name as a child of the given parent span, with the tracer set with SetTracer.
+func Start(parent SpanContext, name string) Span {
+	return tracer.Load().(tracerHolder).Start(parent, name)
+}
+
+// nopTracer discards the spans, while passing the parent span context on to the child spans so the traces started elsewhere
+// (i.e. by another server instance) are not broken.
+type nopTracer struct{}
+
+func (nopTracer) Start(parent SpanContext, name string) Span {
+	return nopSpan{sc: parent}
+}
+
+type nopSpan struct {
+	sc SpanContext
+}
+
+func (s nopSpan) Context() SpanContext                  { return s.sc }
+func (s nopSpan) SetAttr(key string, value interface{}) {}
+func (s nopSpan) SetError(err error)                    {}
+func (s nopSpan) End()                                  {}
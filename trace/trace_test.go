@@ -0,0 +1,76 @@
+package trace
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := Parse(tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.IsValid() || !sc.Sampled || sc.String() != tp {
+		t.Fatalf("unexpected span context: %+v, %v", sc, sc.String())
+	}
+
+	if sc, err = Parse("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"); err != nil || sc.Sampled {
+		t.Fatalf("expected unsampled span context, got: %+v, err: %v", sc, err)
+	}
+
+	for _, tp := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if sc, err := Parse(tp); err == nil {
+			t.Fatalf("expected %q to be rejected, got: %+v", tp, sc)
+		}
+	}
+
+	// future versions may carry more fields
+	if _, err := Parse("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Fatal(err)
+	}
+	if (SpanContext{}).String() != "" {
+		t.Fatal("expected zero span context to be formatted as an empty string")
+	}
+}
+
+func TestTracer(t *testing.T) {
+	defer SetTracer(nil)
+
+	// spans are discarded by default, while the parent span context is passed on
+	parent := NewSpanContext(SpanContext{})
+	if s := Start(parent, "discarded"); s.Context() != parent {
+		t.Fatalf("expected parent span context to be passed on, got: %+v", s.Context())
+	}
+
+	r := NewRecorder()
+	SetTracer(r)
+	root := Start(SpanContext{}, "root")
+	child := Start(root.Context(), "child")
+	child.SetAttr("user", "1")
+	child.SetError(errors.New("failed"))
+	child.End()
+	child.End()
+	root.End()
+
+	spans := r.Spans()
+	if len(spans) != 2 || spans[0].Name != "child" || spans[1].Name != "root" {
+		t.Fatalf("unexpected spans: %+v", spans)
+	}
+	c, p := spans[0], spans[1]
+	if c.Context.TraceID != p.Context.TraceID || c.Parent != p.Context || c.Context.SpanID == p.Context.SpanID || p.Parent.IsValid() {
+		t.Fatalf("expected child span to be in the same trace as its parent, got: %+v, %+v", c, p)
+	}
+	if c.Attrs["user"] != "1" || c.Err == nil || c.End.Before(c.Start) {
+		t.Fatalf("unexpected child span: %+v", c)
+	}
+}
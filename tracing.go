@@ -0,0 +1,52 @@
+package titan
+
+import (
+	"errors"
+
+	"github.com/titan-x/titan/neptulon"
+	"github.com/titan-x/titan/trace"
+)
+
+// spanKey is the request session key of the span of the request being handled.
+const spanKey = "span"
+
+// traceRequest traces each incoming request with a span named after the request method, which the spans of the work
+// done for the request (i.e. database calls, queueing, and delivery of a message to its recipient) are the children of.
+func traceRequest(ctx *neptulon.ReqCtx) error {
+	s := trace.Start(trace.SpanContext{}, ctx.Method)
+	s.SetAttr("conn", ctx.Conn.ID)
+	s.SetAttr("req", ctx.ID)
+	ctx.Session.Set(spanKey, s)
+
+	err := ctx.Next()
+
+	if uid, ok := ctx.Conn.Session.GetOk("userid"); ok {
+		s.SetAttr("user", uid)
+		s.SetAttr("device", connDevice(ctx.Conn))
+	}
+	if err != nil {
+		s.SetError(err)
+	} else if ctx.Err != nil {
+		s.SetAttr("error.code", ctx.Err.Code)
+		s.SetError(errors.New(ctx.Err.Message))
+	}
+	s.End()
+	return err
+}
+
+// reqSpanContext retrieves the span context of the request being handled, or the zero span context if the request is not traced.
+func reqSpanContext(ctx *neptulon.ReqCtx) trace.SpanContext {
+	if s, ok := ctx.Session.GetOk(spanKey); ok {
+		return s.(trace.Span).Context()
+	}
+	return trace.SpanContext{}
+}
+
+// traceDB traces a database call made while handling a request, with a span named db.<op>.
+func traceDB(ctx *neptulon.ReqCtx, op string, f func() error) error {
+	s := trace.Start(reqSpanContext(ctx), "db."+op)
+	err := f()
+	s.SetError(err)
+	s.End()
+	return err
+}
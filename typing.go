@@ -0,0 +1,73 @@
+package titan
+
+import (
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/models"
+)
+
+// typingThrottle is the minimum interval between the typing started notifications relayed for a user in a conversation.
+const typingThrottle = time.Second * 3
+
+// typing relays the typing notifications of a user to the other participants of a conversation, only through their live
+// connections to this server instance, so the notifications are neither persisted nor queued for the offline users.
+// Started notifications are throttled per user and conversation, and stopped notifications are only relayed after a started one.
+// Clients are expected to repeat the started notifications while the user keeps typing, and to time out the indicators of others.
+type typing struct {
+	presence *presence
+
+	mutex       sync.Mutex
+	started     map[typingKey]time.Time // time of the last relayed started notification
+	lastCleanup time.Time
+}
+
+type typingKey struct {
+	userID, conversation string
+}
+
+func newTyping(p *presence) *typing {
+	return &typing{presence: p, started: make(map[typingKey]time.Time), lastCleanup: time.Now()}
+}
+
+// allow reports whether the given typing notification should be relayed, keeping track of the relayed started notifications.
+func (t *typing) allow(userID string, n models.Typing) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.lastCleanup) > time.Minute {
+		for k, v := range t.started {
+			if now.Sub(v) > time.Minute {
+				delete(t.started, k)
+			}
+		}
+		t.lastCleanup = now
+	}
+
+	k := typingKey{userID: userID, conversation: n.Conversation}
+	last, ok := t.started[k]
+	if !n.Typing {
+		delete(t.started, k)
+		return ok
+	}
+	if ok && now.Sub(last) < typingThrottle {
+		return false
+	}
+	t.started[k] = now
+	return true
+}
+
+// relay sends a typing notification to the live connections of the given users, except for the typing user.
+func (t *typing) relay(n models.Typing, userIDs []string) {
+	for _, id := range userIDs {
+		if id == n.UserID {
+			continue
+		}
+		for _, c := range t.presence.UserConns(id) {
+			if _, err := c.SendRequest("msg.typing", n, ignoreResHandler); err != nil {
+				reqLog.Debugf("failed to relay typing notification of user %v to connection %v: %v", n.UserID, c.ID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package titan
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/mail"
+	"github.com/titan-x/titan/models"
+	"github.com/titan-x/titan/sms"
+)
+
+var verifyLog = log.Component("verify")
+
+const (
+	verifyCodeTTL      = 30 * time.Minute // lifetime of the verification codes
+	verifyResendPeriod = time.Minute      // minimum time between the codes sent to the same address or phone number
+	verifyMaxAttempts  = 5                // number of wrong codes after which a verification is canceled
+)
+
+var (
+	errVerifyDisabled    = errors.New("verify: no e-mail provider is configured")
+	errVerifySMSDisabled = errors.New("verify: no sms provider is configured")
+	errVerifyThrottled   = errors.New("verify: a code was sent to the address recently")
+	errVerifyInvalid     = errors.New("verify: invalid or expired code")
+)
+
+// verifier sends the one-time codes to verify the e-mail addresses of the new accounts and the phone numbers of the users with,
+// and checks the codes. Only the hashes of the codes are stored, and verifications are canceled after verifyMaxAttempts wrong codes.
+type verifier struct {
+	db        *data.DB
+	mutex     sync.Mutex
+	sender    mail.Sender
+	smsSender sms.Sender
+	verifyURL string
+}
+
+func newVerifier(db *data.DB, conf Mail, smsConf SMS) (*verifier, error) {
+	v := &verifier{db: db, verifyURL: conf.VerifyURL}
+	if smsConf.Provider == smsTwilio {
+		tw := sms.NewTwilio(smsConf.TwilioAccountSID, smsConf.TwilioAuthToken, smsConf.TwilioFrom)
+		tw.Client = Conf.App.HTTPClient()
+		v.smsSender = tw
+	}
+	switch conf.Provider {
+	case mailSMTP:
+		v.sender = &mail.SMTP{Addr: conf.SMTPAddr, From: conf.From, Username: conf.SMTPUser, Password: conf.SMTPPassword}
+	case mailSES:
+		ses, err := mail.NewSES(conf.SESRegion, conf.From, nil)
+		if err != nil {
+			return nil, err
+		}
+		ses.Client = Conf.App.HTTPClient()
+		v.sender = ses
+	}
+	return v, nil
+}
+
+// setSender sets the sender to send the e-mails with, or disables the registrations with e-mail addresses if nil.
+func (v *verifier) setSender(s mail.Sender) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.sender = s
+}
+
+// setSMSSender sets the sender to send the text messages with, or disables the phone number verification if nil.
+func (v *verifier) setSMSSender(s sms.Sender) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.smsSender = s
+}
+
+// sendEmail sends a new verification code for the e-mail address of a user, replacing the previous one, if any.
+func (v *verifier) sendEmail(userID, email string) error {
+	v.mutex.Lock()
+	s := v.sender
+	v.mutex.Unlock()
+	if s == nil {
+		return errVerifyDisabled
+	}
+
+	code, err := v.newCode(models.VerifyEmail, email, userID)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Your verification code is %v. It expires in %v minutes.\n", code, int(verifyCodeTTL.Minutes()))
+	if v.verifyURL != "" {
+		body += fmt.Sprintf("\nYou can also verify your e-mail address by following the link below:\n%v\n", verifyLink(v.verifyURL, email, code))
+	}
+	if err := s.Send(mail.Message{To: email, Subject: "Your verification code", Body: body}); err != nil {
+		return err
+	}
+
+	verifyLog.Infof("sent verification code to %v for user %v", email, userID)
+	return nil
+}
+
+// sendPhone sends a new verification code for the phone number of a user in a text message, replacing the previous one, if any.
+func (v *verifier) sendPhone(userID, phone string) error {
+	v.mutex.Lock()
+	s := v.smsSender
+	v.mutex.Unlock()
+	if s == nil {
+		return errVerifySMSDisabled
+	}
+
+	code, err := v.newCode(models.VerifyPhone, phone, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Send(phone, fmt.Sprintf("Your verification code is %v. It expires in %v minutes.", code, int(verifyCodeTTL.Minutes()))); err != nil {
+		return err
+	}
+
+	verifyLog.Infof("sent verification code to %v for user %v", phone, userID)
+	return nil
+}
+
+// newCode generates a new code for the given address or phone number and saves its hash as the pending verification,
+// unless a code was sent to the same target within verifyResendPeriod.
+func (v *verifier) newCode(kind, target, userID string) (string, error) {
+	now := time.Now()
+	if vr, ok, err := (*v.db).GetVerification(kind, target); err != nil {
+		return "", err
+	} else if ok && now.Sub(vr.Sent) < verifyResendPeriod {
+		return "", errVerifyThrottled
+	}
+
+	code, err := newVerifyCode()
+	if err != nil {
+		return "", err
+	}
+	vr := models.Verification{Kind: kind, Target: target, UserID: userID, CodeHash: hashVerifyCode(kind, target, code), Sent: now, Expires: now.Add(verifyCodeTTL)}
+	if err := (*v.db).SaveVerification(&vr); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// check checks the given code against the pending verification of the given kind for an address, and returns the ID of the user
+// the address belongs to if the code is correct. Verification is completed, and so deleted, upon the correct code.
+// If forUser is given, the verifications started by other users are treated as nonexistent, so they cannot be completed or canceled.
+func (v *verifier) check(kind, target, forUser, code string) (userID string, err error) {
+	vr, ok, err := (*v.db).GetVerification(kind, target)
+	if err != nil {
+		return "", err
+	}
+	if !ok || time.Now().After(vr.Expires) || (forUser != "" && vr.UserID != forUser) {
+		return "", errVerifyInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(vr.CodeHash), []byte(hashVerifyCode(kind, target, code))) != 1 {
+		vr.Attempts++
+		if vr.Attempts >= verifyMaxAttempts {
+			err = (*v.db).DeleteVerification(kind, target)
+		} else {
+			err = (*v.db).SaveVerification(vr)
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", errVerifyInvalid
+	}
+
+	if err := (*v.db).DeleteVerification(kind, target); err != nil {
+		return "", err
+	}
+	return vr.UserID, nil
+}
+
+// newVerifyCode generates a random 6 digit code.
+func newVerifyCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", fmt.Errorf("verify: failed to generate code: %v", err)
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// hashVerifyCode hashes a code along with the address it is sent to, so the same code yields different hashes for different addresses.
+func hashVerifyCode(kind, target, code string) string {
+	h := sha256.Sum256([]byte(kind + ":" + target + ":" + code))
+	return hex.EncodeToString(h[:])
+}
+
+// verifyLink appends the address and the code to the verification page URL.
+func verifyLink(base, email, code string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	q := u.Query()
+	q.Set("email", email)
+	q.Set("code", code)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,130 @@
+package titan
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/titan-x/titan/data"
+	"github.com/titan-x/titan/data/inmem"
+	"github.com/titan-x/titan/mail"
+	"github.com/titan-x/titan/models"
+)
+
+type mailSenderFunc func(m mail.Message) error
+
+func (f mailSenderFunc) Send(m mail.Message) error {
+	return f(m)
+}
+
+type smsSenderFunc func(to, body string) error
+
+func (f smsSenderFunc) Send(to, body string) error {
+	return f(to, body)
+}
+
+func TestVerifyEmail(t *testing.T) {
+	var db data.DB = inmem.NewDB()
+	var sent []mail.Message
+	v := &verifier{db: &db, verifyURL: "https://titan.test/verify"}
+	if err := v.sendEmail("1", "user@titan.test"); err != errVerifyDisabled {
+		t.Fatalf("expected verification to be disabled without a sender, got: %v", err)
+	}
+
+	v.setSender(mailSenderFunc(func(m mail.Message) error {
+		sent = append(sent, m)
+		return nil
+	}))
+	if err := v.sendEmail("1", "user@titan.test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.sendEmail("1", "user@titan.test"); err != errVerifyThrottled {
+		t.Fatalf("expected resend to be throttled, got: %v", err)
+	}
+	if len(sent) != 1 || !strings.Contains(sent[0].Body, "https://titan.test/verify?code=") {
+		t.Fatalf("unexpected e-mails: %+v", sent)
+	}
+	code := strings.Fields(sent[0].Body)[4]
+	code = strings.TrimSuffix(code, ".")
+
+	if vr, _, _ := db.GetVerification(models.VerifyEmail, "user@titan.test"); vr.CodeHash == code || vr.UserID != "1" {
+		t.Fatalf("expected hashed code to be stored, got: %+v", vr)
+	}
+	if _, err := v.check(models.VerifyEmail, "other@titan.test", "", code); err != errVerifyInvalid {
+		t.Fatalf("expected code of another address to be rejected, got: %v", err)
+	}
+	if uid, err := v.check(models.VerifyEmail, "user@titan.test", "", code); err != nil || uid != "1" {
+		t.Fatalf("expected code to be accepted, got: %v, %v", uid, err)
+	}
+	if _, err := v.check(models.VerifyEmail, "user@titan.test", "", code); err != errVerifyInvalid {
+		t.Fatalf("expected used code to be rejected, got: %v", err)
+	}
+}
+
+func TestVerifyPhone(t *testing.T) {
+	var db data.DB = inmem.NewDB()
+	var code string
+	v := &verifier{db: &db}
+	if err := v.sendPhone("1", "+46123456789"); err != errVerifySMSDisabled {
+		t.Fatalf("expected phone verification to be disabled without an sms provider, got: %v", err)
+	}
+
+	v.setSMSSender(smsSenderFunc(func(to, body string) error {
+		code = strings.TrimSuffix(strings.Fields(body)[4], ".")
+		return nil
+	}))
+	if err := v.sendPhone("1", "+46123456789"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.sendPhone("2", "+46123456789"); err != errVerifyThrottled {
+		t.Fatalf("expected resend to be throttled, got: %v", err)
+	}
+
+	// verifications started by another user can neither be completed nor canceled
+	for i := 0; i < verifyMaxAttempts; i++ {
+		if _, err := v.check(models.VerifyPhone, "+46123456789", "2", "000000"); err != errVerifyInvalid {
+			t.Fatalf("expected verification of another user to be rejected, got: %v", err)
+		}
+	}
+	if uid, err := v.check(models.VerifyPhone, "+46123456789", "1", code); err != nil || uid != "1" {
+		t.Fatalf("expected code to be accepted, got: %v, %v", uid, err)
+	}
+}
+
+func TestVerifyAttempts(t *testing.T) {
+	var db data.DB = inmem.NewDB()
+	v := &verifier{db: &db}
+	vr := models.Verification{Kind: models.VerifyEmail, Target: "user@titan.test", UserID: "1", CodeHash: hashVerifyCode(models.VerifyEmail, "user@titan.test", "123456"),
+		Sent: time.Now(), Expires: time.Now().Add(time.Minute)}
+	db.SaveVerification(&vr)
+
+	// verification is canceled after too many wrong codes, so the right code is rejected too
+	for i := 0; i < verifyMaxAttempts; i++ {
+		if _, err := v.check(models.VerifyEmail, "user@titan.test", "", "000000"); err != errVerifyInvalid {
+			t.Fatalf("expected wrong code to be rejected, got: %v", err)
+		}
+	}
+	if _, err := v.check(models.VerifyEmail, "user@titan.test", "", "123456"); err != errVerifyInvalid {
+		t.Fatalf("expected verification to be canceled, got: %v", err)
+	}
+
+	// expired codes are rejected
+	vr.Expires = time.Now().Add(-time.Second)
+	db.SaveVerification(&vr)
+	if _, err := v.check(models.VerifyEmail, "user@titan.test", "", "123456"); err != errVerifyInvalid {
+		t.Fatalf("expected expired code to be rejected, got: %v", err)
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	for in, want := range map[string]string{"User@Titan.TEST": "User@titan.test", " user@titan.test ": "user@titan.test"} {
+		if got, ok := normalizeEmail(in); !ok || got != want {
+			t.Fatalf("expected %v to be normalized to %v, got: %v", in, want, got)
+		}
+	}
+	for _, in := range []string{"", "user", "Jane <jane@titan.test>", "a@b, c@d"} {
+		if _, ok := normalizeEmail(in); ok {
+			t.Fatalf("expected %q to be rejected", in)
+		}
+	}
+}
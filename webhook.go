@@ -0,0 +1,135 @@
+package titan
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/titan-x/titan/log"
+	"github.com/titan-x/titan/models"
+)
+
+var webhookLog = log.Component("webhook")
+
+// webhookPath is the path of the HTTP endpoint for the external systems to send messages at.
+const webhookPath = "/api/messages"
+
+// minWebhookKeyLen is the minimum length of the API keys of the webhook endpoint, so they cannot be guessed.
+const minWebhookKeyLen = 32
+
+// maxWebhookBody is the maximum size of a webhook request body, in bytes.
+const maxWebhookBody = 64 << 10
+
+// webhookHandler is the HTTP endpoint for the external systems (i.e. transactional notifications) to send messages to the users
+// without speaking the device protocol. Requests are authenticated with the API keys configured with WEBHOOK_KEYS, each of which
+// sends the messages on behalf of its user:
+//
+//	POST /api/messages with "Authorization: Bearer <key>" header and {"to": "<userid>", "message": "...", "clientId": "..."} body
+//	sends a message the same way as msg.send, and responds with 202 and {"id": "<message id>", "state": "sent", "stateUrl": "/api/messages/<id>"}.
+//	Client ID is optional and deduplicates the retries, which are responded with the receipt of the original message.
+//	Rejected messages (i.e. the recipient's queue is full) are responded with 422.
+//
+//	GET /api/messages/<id> with "Authorization: Bearer <key>" header responds with the latest receipt of a message sent with the key.
+//
+// Requests with an invalid key are responded with 401, and the messages of the other users with 404. Sent messages are recorded
+// to the audit log as webhook.send.
+type webhookHandler struct {
+	server *Server
+	keys   map[string]string // API key -> user ID
+}
+
+type webhookReq struct {
+	To       string `json:"to"`
+	Message  string `json:"message"`
+	ClientID string `json:"clientId"`
+}
+
+type webhookRes struct {
+	models.Receipt
+	StateURL string `json:"stateUrl"` // URL to query the delivery state of the message at, relative to the server address.
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.auth(r)
+	if !ok {
+		h.server.audit.record(nil, models.AuditEntry{Action: models.AuditWebhook, RemoteAddr: r.RemoteAddr, Details: map[string]string{"reason": "unauthorized"}})
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, webhookPath), "/")
+	switch {
+	case r.Method == "POST" && id == "":
+		h.send(w, r, userID)
+	case r.Method == "GET" && id != "":
+		h.state(w, r, userID, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// auth verifies the API key in the Authorization header of a request and returns the ID of the user of the key.
+func (h *webhookHandler) auth(r *http.Request) (userID string, ok bool) {
+	k := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if k == "" {
+		return "", false
+	}
+
+	// all the keys are compared in constant time so the response time does not reveal a partially matching key
+	for key, id := range h.keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			userID, ok = id, true
+		}
+	}
+	return userID, ok
+}
+
+func (h *webhookHandler) send(w http.ResponseWriter, r *http.Request, userID string) {
+	var req webhookReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBody)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.To == "" || req.Message == "" {
+		http.Error(w, "recipient and message are required", http.StatusBadRequest)
+		return
+	}
+
+	e := models.AuditEntry{Action: models.AuditWebhook, UserID: userID, RemoteAddr: r.RemoteAddr, Target: req.To}
+	rc, err := h.server.SendMessage(userID, models.Message{To: req.To, Message: req.Message, ClientID: req.ClientID})
+	if errors.Is(err, ErrMessageRejected) {
+		e.Details = map[string]string{"reason": "rejected"}
+		h.server.audit.record(nil, e)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		webhookLog.Errorf("failed to send message of user %v: %v", userID, err)
+		http.Error(w, "failed to send message", http.StatusInternalServerError)
+		return
+	}
+	e.Success = true
+	e.Details = map[string]string{"message": rc.ID}
+	h.server.audit.record(nil, e)
+
+	webhookLog.Debugf("message %v from user %v is sent to %v", rc.ID, userID, rc.To)
+	h.respond(w, http.StatusAccepted, rc)
+}
+
+func (h *webhookHandler) state(w http.ResponseWriter, r *http.Request, userID, id string) {
+	rc, ok := h.server.DeliveryState(id)
+	if !ok || rc.From != userID {
+		http.NotFound(w, r)
+		return
+	}
+	h.respond(w, http.StatusOK, rc)
+}
+
+func (h *webhookHandler) respond(w http.ResponseWriter, code int, rc models.Receipt) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(webhookRes{Receipt: rc, StateURL: webhookPath + "/" + rc.ID})
+}